@@ -1,11 +1,22 @@
 module github.com/release-engineering/retrodep/v2
 
+go 1.20
+
 require (
 	github.com/Masterminds/semver v1.4.2
-	github.com/kr/pretty v0.1.0 // indirect
+	github.com/golang/protobuf v1.3.2
 	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
 	github.com/pkg/errors v0.8.1
-	golang.org/x/tools v0.0.0-20190325161752-5a8dccf5b48a
-	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
+	golang.org/x/tools v0.0.0-20190524140312-2c0ae7006135
+	google.golang.org/grpc v1.27.1
 	gopkg.in/yaml.v2 v2.2.2
 )
+
+require (
+	github.com/kr/pretty v0.1.0 // indirect
+	golang.org/x/net v0.0.0-20190311183353-d8887717615a // indirect
+	golang.org/x/sys v0.0.0-20190215142949-d0b11bdaac8a // indirect
+	golang.org/x/text v0.3.0 // indirect
+	google.golang.org/genproto v0.0.0-20190819201941-24fa4b261c55 // indirect
+	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
+)
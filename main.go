@@ -17,18 +17,35 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	stdlog "log"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/op/go-logging"
 	"github.com/release-engineering/retrodep/v2/retrodep"
+	"github.com/release-engineering/retrodep/v2/retrodep/scanpb"
 	"golang.org/x/tools/go/vcs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 const defaultTemplate string = `
@@ -43,31 +60,348 @@ var importPath = flag.String("importpath", "", "top-level import path")
 var onlyImportPath = flag.Bool("only-importpath", false, "only show the top-level import path")
 var depsFlag = flag.Bool("deps", true, "show vendored dependencies")
 var diffArg = flag.String("diff", "", "compare with upstream ref (implies -deps=false)")
+var submodulesFlag = flag.Bool("submodules", false, "include the files of git submodules in upstream versions, cloning each submodule")
+var lfsFlag = flag.Bool("lfs", false, "hash the content of files stored with Git LFS in git upstreams, rather than their pointer files")
+var exportAttributes = flag.Bool("export-attributes", false, "compare with git upstreams as 'git archive' would export them, applying export-ignore and export-subst")
 var excludeFrom = flag.String("exclude-from", "", "ignore directory entries matching globs in `exclusions`")
+var hashExcludeFrom = flag.String("hash-exclude-from", "", "leave paths within projects matching patterns in `file` out of comparisons, locally and upstream")
+var hashFilesArg = flag.String("hash-files", "", "only compare the files within projects in the comma-separated `list` of file classes (go, cgo, proto or all) and gitignore patterns, locally and upstream (default all)")
+var blocklistFrom = flag.String("blocklist", "", "fail if a version found is listed in `file` (or URL)")
+var blocklistWarn = flag.Bool("blocklist-warn", false, "only warn about versions in the -blocklist")
+var moduleHashFlag = flag.Bool("module-hash", false, "compute the go.sum h1: hash of the module zip for each vendored version found")
+var moduleVersionsFlag = flag.Bool("module-versions", false, "report versions as Go modules know them, such as v2.3.4+incompatible and v0.0.0-timestamp-revision (implies -module-pseudo-versions)")
+var pseudoVersionTemplate = flag.String("pseudo-version-template", "", "make pseudo-versions with go `template` using Tag, Version, Time, Date, Timestamp, Rev and ShortRev, such as {{.Version}}^{{.Date}}git{{.ShortRev}}")
+var modulePseudoVersions = flag.Bool("module-pseudo-versions", false, "make pseudo-versions as the go command does, with UTC timestamps and based only on tags Go modules accept")
+var osvSource = flag.String("osv", "", "look up known vulnerabilities in each vendored version found in the OSV database at `source`, either an API URL such as https://api.osv.dev or a directory or zip file of OSV records")
+var sumDBURL = flag.String("sumdb", "", "check module hashes against the Go checksum database at `URL`, such as https://sum.golang.org (implies -module-hash)")
+var healthFlag = flag.Bool("health", false, "warn of archived, moved or inactive upstream repositories")
+var forgeTokensFrom = flag.String("forge-tokens", "", "use the forge API tokens listed in `file` in turn (default $GITHUB_TOKEN)")
+var forgeAPIFlag = flag.Bool("forge-api", false, "list tags and download archives using the GitHub or GitLab API rather than cloning, where possible")
+var forgeBudget = flag.Int("forge-budget", 0, "make at most `requests` forge API requests (0 for no limit), then use the repositories instead")
+var behindFlag = flag.Bool("behind", false, "count the commits and releases between each version found and the latest upstream release")
+var inactiveYears = flag.Int("inactive-years", 2, "with -health, warn of repositories with no commits for `years`")
+var offlineFlag = flag.Bool("offline", false, "resolve import paths without the network, using -repo-roots for those not on github.com, gopkg.in or golang.org/x")
+var filesFlag = flag.Bool("files", false, "show whether each file matched the version found (or the -nearest), was modified or added, or is missing")
+var prunedFlag = flag.Bool("pruned", false, "count the upstream files left out of each copy matched, such as the tests, non-Go files and unused packages pruned by dep or 'go mod vendor'")
+var patchesDir = flag.String("patches", "", "write a patch for each vendored project which differs from the version found (or the -nearest) to `dir`")
+var nearestFlag = flag.Bool("nearest", false, "when no upstream version matches, show the one with the fewest differing files")
+var alternatesFrom = flag.String("alternates", "", "when no upstream version matches, try the forks and mirrors listed for each import path prefix in `file`")
+var repoRootsFrom = flag.String("repo-roots", "", "resolve import path prefixes to the VCS and repository URL listed in `file` first")
+var configFrom = flag.String("config", "", "read the repository, tag prefix, branch, excluded paths, credentials and preferred tags for import path prefixes from the YAML `file`, where the other flags give none")
+var credentialsFrom = flag.String("credentials", "", "use the credentials and SSH keys listed for each host in `file`")
+var netrcFrom = flag.String("netrc", "", "read HTTPS credentials from the .netrc `file` rather than ~/.netrc")
+var gitConfigFrom = flag.String("git-config", "", "run git commands with the configuration settings listed in `file`")
+var branchesFrom = flag.String("branches", "", "only consider upstream revisions on the branches given for import path prefixes in `file`, or * for all branches")
+var localReposFrom = flag.String("local-repos", "", "use the existing checkouts in the directories given for import path prefixes in `file`, fetching into them, rather than cloning")
+var tagPrefixesFrom = flag.String("tag-prefixes", "", "only consider upstream tags beginning with the tag prefixes given for import path prefixes in `file`, such as api/ for api/v1.2.3")
+var tagMatchArg = flag.String("tag-match", "", "only base pseudo-versions on upstream tags matching one of the comma-separated globs in `list`, such as v1.*")
+var tagExcludeArg = flag.String("tag-exclude", "", "never base pseudo-versions on upstream tags matching one of the comma-separated globs in `list`, such as *-nightly*")
+var preferTagsArg = flag.String("prefer-tags", "", "base pseudo-versions on the nearest upstream git tag of `kind`, annotated or lightweight, where there is one")
+var semverTags = flag.Bool("semver-tags", false, "only base pseudo-versions on upstream tags which are semantic versions")
+var tagKeyring = flag.String("tag-keyring", "", "verify the signatures of matching tags with the OpenPGP keys in the GnuPG home `dir`")
+var tagAllowedSigners = flag.String("tag-allowed-signers", "", "verify the signatures of matching tags with the SSH keys in the ssh-keygen allowed signers `file`")
+var relocationsFrom = flag.String("relocations", "", "resolve moved import paths using the old and new prefixes listed in `file`")
+var forksFlag = flag.Bool("forks", false, "show where vendored projects from forks leave the history of the canonical upstream repository")
+var gerritChanges = flag.Bool("gerrit-changes", false, "also search unmerged Gerrit changes (refs/changes/*)")
+var normalizeFlag = flag.String("normalize", "", "normalize file content before hashing, both locally and upstream: comma-separated `list` of build-tags, crlf, gofmt, import-comment and trailing-space")
+var hashAlgorithm = flag.String("hash", "", "compare files using hash `algorithm`: git-sha1, sha256 or blake3 (default depends on the VCS)")
+var sinceArg = flag.String("since", "", "only try untagged revisions committed on or after `date` (YYYY-MM-DD or RFC 3339)")
+var untilArg = flag.String("until", "", "only try untagged revisions committed on or before `date` (YYYY-MM-DD or RFC 3339)")
+var maxRevisions = flag.Int("max-revisions", 0, "only try the newest `n` untagged revisions (0 for no limit)")
+var indexFrom = flag.String("index", "", "try first the upstream refs found for each project in the fingerprint index `file`, or with index, record fingerprints there")
+var indexFilesArg = flag.String("index-files", "", "with index, record the fingerprints of the files in the comma-separated `list` of file classes (go, cgo, proto or all) and gitignore patterns (default go)")
+var hintsFrom = flag.String("hints", "", "try tags or revisions listed in `file` first")
+var preferArg = flag.String("prefer", retrodep.PreferOldest, "choose among tags matching identically by `policy`: oldest or newest release")
+var interactiveFlag = flag.Bool("interactive", false, "ask which tag to report when several match identically, remembering the answer in the -config file")
 var debugFlag = flag.Bool("debug", false, "show debugging output")
-var outputArg = flag.String("o", "", "output format, one of: go-template=...")
+var traceFlag = flag.Bool("trace", false, "log each VCS command run, with timings")
+var progressFlag = flag.Bool("progress", false, "show a progress bar on standard error while cloning and matching")
+var formatFrom = flag.String("format", "", "write each project through the Go text/template in `file`, using ImportPath, Repo, Rev, Tag, Version, Matched and Files, after any template named header and before any named footer")
+var outputArg = flag.String("o", "", "output format, one of: cyclonedx, gomod, json, porcelain, spdx, go-template=...")
 var templateArg = flag.String("template", "", "go template to use for output with Pkg, Repo, Rev, Tag and Ver (deprecated)")
 var exitFirst = flag.Bool("x", false, "exit on the first failure")
+var strictFile = flag.String("strict", "", "exit with code 15 if the version of any vendored project is missing, writing a JSON summary of them to `file` (- for standard error)")
+var dryRun = flag.Bool("dry-run", false, "only show which repositories would be cloned")
+
+var allowEnv = flag.String("allow-env", "", "also pass the comma-separated environment `variables` on to VCS commands")
+var assetsFlag = flag.Bool("assets", false, "also match vendored files outside any vendored project with the upstream repositories found")
+var detectLicensesFlag = flag.Bool("detect-licenses", false, "identify the licenses of the versions found by SPDX identifier, for reports, SBOMs and templates")
+var licensesFlag = flag.Bool("licenses", false, "warn of license file changes in the matched and latest upstream versions")
+var policyFrom = flag.String("policy", "", "check the versions found against the JSON policy in `file`")
+var noticeFile = flag.String("notice", "", "write license and copyright notices of the versions found to `file`")
+var modulesTxtFile = flag.String("modules-txt", "", "write a vendor/modules.txt for the versions found to `file`")
+var metricsFile = flag.String("metrics", "", "write counters and timings for the run in Prometheus text format to `file` (- for standard error)")
+var statsFile = flag.String("stats", "", "write statistics about the run as JSON to `file` (- for standard error)")
+var cloneJobs = flag.Int("clone-jobs", 0, "clone at most `n` repositories at once (default depends on GOMAXPROCS)")
+var matchJobs = flag.Int("match-jobs", 0, "match at most `n` vendored projects at once (default GOMAXPROCS)")
+var hostLimitsFrom = flag.String("host-limits", "", "make at most the number of clones and API requests at once given for each host in `file`, or for * for other hosts")
+var hashJobs = flag.Int("hash-jobs", 0, "hash at most `n` files at once (default GOMAXPROCS)")
+var partialClone = flag.Bool("partial-clone", false, "clone git repositories without file contents, fetching them when needed")
+var goProxy = flag.String("goproxy", "", "read Go modules from the module proxy at `URL` rather than cloning their repositories")
+var repoCacheDir = flag.String("repo-cache", "", "keep mirrors of git repositories in `dir` between runs, fetching into them")
+var repoCacheSize = flag.Int64("repo-cache-size", 10240, "with -repo-cache, remove least recently used mirrors when above `MiB` in total")
+var retries = flag.Int("retries", 1, "try failed clones and fetches again up to `n` times, unless they failed for lack of authentication or a missing repository")
+var retryBackoff = flag.Duration("retry-backoff", time.Second, "wait `duration` before the first retry, doubling it for each one after")
+var retryTimeout = flag.Duration("retry-timeout", 0, "count each clone or fetch as failed if it takes longer than `duration` (0 for no limit)")
+var tempDirArg = flag.String("temp-dir", "", "create working trees in `dir` rather than the system temporary directory")
+var keepTrees = flag.Bool("keep-trees", false, "leave working trees in place when done, showing where each one is")
+var hashCacheDir = flag.String("hash-cache", "", "keep the file hashes of upstream revisions in `dir` between runs")
+var sharedCacheURL = flag.String("shared-cache", "", "look up and store file hashes in the shared cache service at `URL`")
+var sharedCacheKey = flag.String("shared-cache-key", "", "sign and check the -shared-cache entries, or with serve-cache accept entries signed, with the key in `file`")
+var listenAddr = flag.String("listen", "localhost:8780", "with serve or serve-cache, listen on `address`")
+var scanJobs = flag.Int("scan-jobs", 1, "with serve, run at most `n` scans at once")
+var grpcAddr = flag.String("grpc", "", "with serve, also accept gRPC scan requests on `address`, which must be a loopback address unless -grpc-client-ca is given")
+var grpcCert = flag.String("grpc-cert", "", "with -grpc, serve TLS using the certificate in `file`")
+var grpcKey = flag.String("grpc-key", "", "with -grpc-cert, use the private key in `file`")
+var grpcClientCA = flag.String("grpc-client-ca", "", "with -grpc-cert, only accept gRPC clients with certificates signed by a CA in `file`")
+var attestationFile = flag.String("attestation", "", "JSON report or in-toto attestation `file` to verify against (verify)")
+var lockFile = flag.String("lock", "retrodep.lock", "lock `file` to write (lock) or verify against (check)")
 
 var errorShown = false
 var usage func(string)
 
+// subcommands are the commands which may be given before the options.
+var subcommands = map[string]string{
+	"batch":          "scan each project listed in the file PATH, writing one JSON report keyed by project",
+	"lock":           "also write a lock file of the versions found",
+	"check":          "verify the local files against a lock file",
+	"compare":        "show dependency changes from the first PATH to the second",
+	"drift":          "show dependency changes in -importpath from the first ref to the second",
+	"index":          "record fingerprints of the tags and revisions of each repository listed in the file PATH in the -index file",
+	"serve":          "accept scan requests over HTTP, unpacking uploaded sources in PATH",
+	"serve-cache":    "serve a shared file hashes cache stored in PATH over HTTP",
+	"verify":         "verify the local files still match the upstream revisions in an -attestation",
+	"verify-modules": "verify the Go module zip files at PATH, or in a module cache there, against their upstream revisions",
+}
+
+// command is the subcommand given, or "" if there was none.
+var command string
+
+// comparing returns true if the command shows changes rather than
+// each Reference.
+func comparing() bool {
+	return command == "compare" || command == "drift"
+}
+
+// report collects the results when the output format is written
+// from a Report (see reporting).
+var report *retrodep.Report
+
+// references holds each Reference displayed, in order.
+var references []*retrodep.Reference
+
+// record remembers ref as having been displayed.
+func record(ref *retrodep.Reference) {
+	references = append(references, ref)
+	if report != nil {
+		report.Add(ref)
+	}
+}
+
 func displayUnknown(tmpl *template.Template, topLevelMarker string, ref *retrodep.Reference, projectRoot string) {
+	progress.hide()
 	if ref == nil || *templateArg != "" {
-		fmt.Printf("%s%s ?\n", topLevelMarker, projectRoot)
+		if ref == nil {
+			ref = &retrodep.Reference{Pkg: projectRoot}
+		}
+		record(ref)
+		switch {
+		case report != nil || comparing():
+		case porcelain():
+			displayPorcelain(ref)
+		default:
+			fmt.Printf("%s%s ?\n", topLevelMarker, projectRoot)
+		}
 	} else {
 		display(tmpl, topLevelMarker, ref)
 	}
+	if ref != nil && ref.TopPkg != "" {
+		unmatched = append(unmatched, ref)
+	}
 	if !errorShown {
 		errorShown = true
 		fmt.Fprintln(os.Stderr, "error: not all versions identified")
 		if *exitFirst {
-			os.Exit(2)
+			writeReport()
+			writeStats()
+			writeMetrics()
+			exitMissing()
+		}
+	}
+}
+
+// Exit codes for versions not found because the upstream repository
+// could not be used, instead of 2.
+const (
+	exitUnreachable  = 12
+	exitAuthRequired = 13
+	exitCloneFailed  = 14
+)
+
+// exitStrict is the exit code for a vendored project's version not
+// being found with -strict.
+const exitStrict = 15
+
+// Reasons a version was not found, in the -strict summary.
+const (
+	reasonNotFound     = "version-not-found"
+	reasonUnreachable  = "unreachable"
+	reasonAuthRequired = "auth-required"
+	reasonCloneFailed  = "clone-failed"
+)
+
+// upstreamFailure is the exit code for the first version not found
+// because its upstream repository could not be used, or 0.
+var upstreamFailure int
+
+// failureReasons maps the import paths of projects whose upstream
+// repository could not be used to the reason.
+var failureReasons = make(map[string]string)
+
+// unmatched holds the vendored projects whose version was not found.
+var unmatched []*retrodep.Reference
+
+// noteUpstreamFailure records err, the reason the upstream
+// repository for the project pkg could not be used, for the exit
+// code. An import path which cannot be resolved is
+// ErrorUnreachable.
+func noteUpstreamFailure(pkg string, err error) {
+	code, reason := exitCloneFailed, reasonCloneFailed
+	switch {
+	case errors.Is(err, retrodep.ErrorUnreachable):
+		code, reason = exitUnreachable, reasonUnreachable
+	case errors.Is(err, retrodep.ErrorAuthRequired):
+		code, reason = exitAuthRequired, reasonAuthRequired
+	}
+	failureReasons[pkg] = reason
+	if upstreamFailure == 0 {
+		upstreamFailure = code
+	}
+}
+
+// strictFailure describes a vendored project whose version was not
+// found, in the -strict summary.
+type strictFailure struct {
+	Pkg    string `json:"pkg"`
+	TopPkg string `json:"topPkg"`
+	Repo   string `json:"repo,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// writeStrictSummary writes the vendored projects whose version was
+// not found, and why, to w as JSON.
+func writeStrictSummary(w io.Writer) error {
+	failures := make([]strictFailure, 0, len(unmatched))
+	for _, ref := range unmatched {
+		reason, ok := failureReasons[ref.Pkg]
+		if !ok {
+			reason = reasonNotFound
+		}
+		failures = append(failures, strictFailure{
+			Pkg:    ref.Pkg,
+			TopPkg: ref.TopPkg,
+			Repo:   ref.Repo,
+			Reason: reason,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Unmatched []strictFailure `json:"unmatched"`
+	}{failures})
+}
+
+// exitMissing exits for a version not being found, after writing
+// the -strict summary, with missingCode.
+func exitMissing() {
+	writeStrict()
+	os.Exit(missingCode())
+}
+
+// writeStrict writes the -strict summary, if -strict was given and a
+// vendored project's version is missing.
+func writeStrict() {
+	if *strictFile == "" || len(unmatched) == 0 {
+		return
+	}
+	if *strictFile == "-" {
+		if err := writeStrictSummary(os.Stderr); err != nil {
+			log.Fatal(err)
 		}
+		return
+	}
+	f, err := os.Create(*strictFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = writeStrictSummary(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatalf("%s: %s", *strictFile, err)
+	}
+}
+
+// missingCode returns the exit code for a version not being found:
+// exitStrict if -strict was given and a vendored project's version
+// is missing, and otherwise missingExitCode.
+func missingCode() int {
+	if *strictFile != "" && len(unmatched) > 0 {
+		return exitStrict
+	}
+	return missingExitCode()
+}
+
+// exitCode returns the exit code for the problems found once every
+// project has been described, or 0 if there were none. Where there
+// are several, the checks asked for are given precedence over a
+// missing version, so that they can be told apart, in this order:
+// -expect assertions, -blocklist, -policy, -sumdb module hashes, tag
+// signatures and -osv vulnerabilities. The changes found by -diff
+// come last.
+func exitCode(diffChanges bool) int {
+	switch {
+	case assertionFailed:
+		return 7
+	case blockedFound:
+		return 8
+	case policyViolated:
+		return 9
+	case moduleHashMismatch:
+		return 10
+	case unverifiedTag:
+		return 11
+	case vulnerable:
+		return 16
+	case errorShown:
+		return missingCode()
+	case diffChanges:
+		return 5
+	}
+	return 0
+}
+
+// missingExitCode returns the exit code for a version not being
+// found: 2, unless an upstream repository could not be used.
+func missingExitCode() int {
+	if upstreamFailure != 0 {
+		return upstreamFailure
 	}
+	return 2
 }
 
 func display(tmpl *template.Template, topLevelMarker string, ref *retrodep.Reference) {
+	progress.hide()
+	record(ref)
+	if report != nil || comparing() {
+		return
+	}
+	if porcelain() {
+		displayPorcelain(ref)
+		return
+	}
+
 	var builder strings.Builder
 	builder.WriteString(topLevelMarker)
 	err := tmpl.Execute(&builder, ref)
@@ -77,7 +411,272 @@ func display(tmpl *template.Template, topLevelMarker string, ref *retrodep.Refer
 	fmt.Println(builder.String())
 }
 
+// reporting returns true if the output format is written from a
+// Report once all projects are described.
+func reporting() bool {
+	if outputTemplate != nil {
+		return true
+	}
+	switch *outputArg {
+	case "json", "spdx", "cyclonedx", "gomod":
+		return true
+	}
+	return false
+}
+
+// outputTemplate is the template read from -format, or nil.
+var outputTemplate *retrodep.OutputTemplate
+
+// readFormatFile returns the template in the file named by -format,
+// or nil.
+func readFormatFile() *retrodep.OutputTemplate {
+	if *formatFrom == "" {
+		return nil
+	}
+	if *outputArg != "" || *templateArg != "" {
+		usage("-format cannot be used with -o or -template")
+	}
+
+	text, err := ioutil.ReadFile(*formatFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	t, err := retrodep.ParseOutputTemplate(filepath.Base(*formatFrom), string(text))
+	if err != nil {
+		log.Fatalf("%s: %s", *formatFrom, err)
+	}
+	return t
+}
+
+// porcelain returns true if the output format is porcelain: one
+// tab-separated line per project, for scripts.
+func porcelain() bool {
+	return *outputArg == "porcelain"
+}
+
+// porcelainOut is where porcelain output is written. While projects
+// are described, anything else written to os.Stdout, such as the
+// output of failed VCS commands, goes to stderr instead.
+var porcelainOut io.Writer = os.Stdout
+
+// displayPorcelain shows ref as a line of tab-separated fields: "top"
+// or "vendored", the package, the version ("?" if not found), the tag,
+// the revision, the repository, and the top-level package. Empty
+// fields are shown as "-". Fields may be added at the end in future
+// but will not be reordered.
+func displayPorcelain(ref *retrodep.Reference) {
+	kind := "vendored"
+	if ref.TopPkg == "" {
+		kind = "top"
+	}
+	ver := ref.Ver
+	if ver == "" {
+		ver = "?"
+	}
+	fields := []string{kind, ref.Pkg, ver, ref.Tag, ref.Rev, ref.Repo, ref.TopPkg}
+	for i, field := range fields {
+		if field == "" {
+			fields[i] = "-"
+		}
+	}
+	fmt.Fprintln(porcelainOut, strings.Join(fields, "\t"))
+}
+
+// progressInterval is the least time between redraws of the
+// progress bar.
+const progressInterval = 100 * time.Millisecond
+
+// progressBar is a retrodep.ProgressReporter which keeps a line
+// showing the progress of the run at the bottom of a terminal.
+type progressBar struct {
+	mu sync.Mutex
+	w  io.Writer
+
+	cloning, cloned int
+	queued, tested  int
+	hashed          int
+
+	// drawn is when the line was last drawn, and shown is true
+	// if it is still there.
+	drawn time.Time
+	shown bool
+}
+
+// progress is the progress bar shown with -progress, or nil.
+var progress *progressBar
+
+func (b *progressBar) CloneStarted(repo string) {
+	b.update(func() { b.cloning++ })
+}
+
+func (b *progressBar) CloneFinished(repo string, err error) {
+	b.update(func() { b.cloning--; b.cloned++ })
+}
+
+func (b *progressBar) RevisionsQueued(n int) {
+	b.update(func() { b.queued += n })
+}
+
+func (b *progressBar) RevisionTested(ref string) {
+	b.update(func() { b.tested++ })
+}
+
+func (b *progressBar) FilesHashed(n int) {
+	b.update(func() { b.hashed += n })
+}
+
+// update changes the counts with f and redraws the line, unless it
+// was drawn within progressInterval.
+func (b *progressBar) update(f func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f()
+	if b.shown && time.Since(b.drawn) < progressInterval {
+		return
+	}
+	b.draw()
+}
+
+// draw writes the line over any previous one. The caller must hold
+// b.mu.
+func (b *progressBar) draw() {
+	cloned := fmt.Sprintf("cloned %d", b.cloned)
+	if b.cloning > 0 {
+		cloned += fmt.Sprintf(" (%d cloning)", b.cloning)
+	}
+	fmt.Fprintf(b.w, "\r%s, tested %d/%d refs, hashed %d files\x1b[K",
+		cloned, b.tested, b.queued, b.hashed)
+	b.drawn = time.Now()
+	b.shown = true
+}
+
+// hide clears the line, if shown, so that other output can be
+// written. It is drawn again on the next update.
+func (b *progressBar) hide() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.shown {
+		fmt.Fprint(b.w, "\r\x1b[K")
+		b.shown = false
+	}
+}
+
+// Write writes the log message p above the line.
+func (b *progressBar) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	shown := b.shown
+	if shown {
+		fmt.Fprint(b.w, "\r\x1b[K")
+	}
+	n, err := b.w.Write(p)
+	if shown {
+		b.draw()
+	}
+	return n, err
+}
+
+// metrics holds the metrics for -metrics, or nil.
+var metrics *retrodep.PrometheusMetrics
+
+// writeMetrics writes the metrics for the run to the file named by
+// -metrics, if given.
+func writeMetrics() {
+	if metrics == nil {
+		return
+	}
+	progress.hide()
+
+	var w io.Writer = os.Stderr
+	if *metricsFile != "-" {
+		f, err := os.Create(*metricsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := metrics.Write(w); err != nil {
+		log.Fatalf("%s: %s", *metricsFile, err)
+	}
+}
+
+// writeStats writes the statistics for the run to the file named by
+// -stats, if given.
+func writeStats() {
+	if *statsFile == "" {
+		return
+	}
+	progress.hide()
+	stats := retrodep.ReadStats()
+	for _, ref := range references {
+		stats.AddReference(ref)
+	}
+
+	var w io.Writer = os.Stderr
+	if *statsFile != "-" {
+		f, err := os.Create(*statsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := stats.Write(w); err != nil {
+		log.Fatalf("%s: %s", *statsFile, err)
+	}
+}
+
+// reportTime returns the time SPDX and CycloneDX documents are
+// created at: that in SOURCE_DATE_EPOCH, in seconds since the epoch,
+// so that runs can be reproduced, or else now.
+func reportTime() time.Time {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return time.Now()
+	}
+	secs, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		log.Fatalf("SOURCE_DATE_EPOCH: %s", err)
+	}
+	return time.Unix(secs, 0)
+}
+
+// writeReport writes the collected results to stdout, if the output
+// format is written from a Report.
+func writeReport() {
+	progress.hide()
+	if report == nil {
+		return
+	}
+	write := report.Write
+	switch *outputArg {
+	case "spdx":
+		write = func(w io.Writer) error {
+			return report.WriteSPDX(w, reportTime())
+		}
+	case "cyclonedx":
+		write = func(w io.Writer) error {
+			return report.WriteCycloneDX(w, reportTime())
+		}
+	case "gomod":
+		write = report.WriteGoModRequire
+	}
+	if outputTemplate != nil {
+		write = func(w io.Writer) error {
+			return report.WriteTemplate(w, outputTemplate)
+		}
+	}
+	if err := write(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
 func getProject(src *retrodep.GoSource, importPath string) *retrodep.RepoPath {
+	defer retrodep.RecordPhase(retrodep.PhaseResolve, time.Now())
 	main, err := src.Project(importPath)
 	if err != nil {
 		if err == retrodep.ErrorNeedImportPath {
@@ -89,130 +688,2299 @@ func getProject(src *retrodep.GoSource, importPath string) *retrodep.RepoPath {
 		log.Fatalf("%s: %s", src.Path, err)
 	}
 
-	return main
-}
+	return main
+}
+
+// sharedTrees, if not nil, holds the working trees created so far,
+// by repository, so they can be reused instead of cloned again.
+var sharedTrees map[string]retrodep.WorkingTree
+
+// sharedTree is a retrodep.WorkingTree held in sharedTrees. Closing
+// it has no effect; closeSharedTrees closes them all.
+type sharedTree struct {
+	retrodep.WorkingTree
+}
+
+func (sharedTree) Close() error {
+	return nil
+}
+
+// RevisionsIter lists the revisions of the shared working tree as
+// they are found, if it can.
+func (t sharedTree) RevisionsIter(yield func(rev string) (bool, error)) error {
+	return retrodep.RevisionsIter(t.WorkingTree, yield)
+}
+
+// closeSharedTrees closes the working trees in sharedTrees.
+func closeSharedTrees() {
+	for _, wt := range sharedTrees {
+		wt.Close()
+	}
+	sharedTrees = nil
+}
+
+// tagPrefixes holds the tag prefixes read from -tag-prefixes and
+// -config.
+var tagPrefixes retrodep.TagPrefixes
+
+// branches holds the branches read from -branches and -config.
+var branches retrodep.Branches
+
+// credentialNames holds the credentials read from -config.
+var credentialNames retrodep.CredentialNames
+
+// hashExcludes holds the excluded paths read from -config.
+var hashExcludes retrodep.ProjectHashExcludes
+
+// localRepos holds the checkouts read from -local-repos.
+var localRepos retrodep.LocalRepos
+
+// mirrorsUsed maps the URLs of unavailable repositories to the
+// mirrors cloned instead.
+var mirrorsUsed = make(map[string]string)
+
+// treesMu guards sharedTrees and mirrorsUsed, as working trees are
+// created concurrently.
+var treesMu sync.Mutex
+
+// annotate fills in the details of ref which depend on how its
+// working tree, wt, was obtained.
+func annotate(ref *retrodep.Reference, wt retrodep.WorkingTree) {
+	treesMu.Lock()
+	ref.Mirror = mirrorsUsed[ref.Repo]
+	treesMu.Unlock()
+	if claim := ref.Manifest; claim != nil && !claim.Matches && ref.Ver != "" {
+		claimed := claim.Revision
+		if claimed == "" {
+			claimed = claim.Version
+		}
+		log.Warningf("%s: %s claims %s but found %s", ref.Pkg, claim.File, claimed, ref.Ver)
+	}
+	if !*gerritChanges || ref.Rev == "" {
+		return
+	}
+	if s, ok := wt.(sharedTree); ok {
+		wt = s.WorkingTree
+	}
+	cwt, ok := wt.(retrodep.ChangesWorkingTree)
+	if !ok {
+		return
+	}
+	change, err := cwt.UnmergedChange(ref.Rev)
+	if err != nil {
+		log.Errorf("%s: %s", ref.Pkg, err)
+		return
+	}
+	if change != "" {
+		ref.Change = change
+		log.Warningf("%s: %s is from unmerged change %s", ref.Pkg, ref.Ver, change)
+	}
+}
+
+// checkFork sets ref.Fork if -forks was given and project is from a
+// fork of its canonical upstream repository, warning if ref includes
+// commits only in the fork.
+func checkFork(src *retrodep.GoSource, project *retrodep.RepoPath, ref *retrodep.Reference, wt retrodep.WorkingTree) {
+	if !*forksFlag {
+		return
+	}
+	if s, ok := wt.(sharedTree); ok {
+		wt = s.WorkingTree
+	}
+	fwt, ok := wt.(retrodep.ForkWorkingTree)
+	if !ok {
+		return
+	}
+	fork, err := src.ForkPoint(project, ref, fwt)
+	if err != nil {
+		log.Errorf("%s: %s", ref.Pkg, err)
+		return
+	}
+	if fork == nil {
+		return
+	}
+	ref.Fork = fork
+	switch {
+	case fork.Commits == 0:
+	case fork.Rev == "":
+		log.Warningf("%s: %s shares no history with %s", ref.Pkg, ref.Ver, fork.Upstream)
+	default:
+		log.Warningf("%s: %s includes %d commits not in %s (forked at %s)",
+			ref.Pkg, ref.Ver, fork.Commits, fork.Upstream, fork.Rev)
+	}
+}
+
+// checkReplace sets ref.Replace if project's repository is a fork
+// and ref is written to a report or -modules-txt, where it gives a
+// replace directive.
+func checkReplace(src *retrodep.GoSource, project *retrodep.RepoPath, ref *retrodep.Reference) {
+	if ref.Ver == "" || (report == nil && *modulesTxtFile == "" && !*forksFlag) {
+		return
+	}
+	ref.Replace = src.ForkReplacement(project)
+	if ref.Replace != "" {
+		log.Debugf("%s: found in fork %s", ref.Pkg, ref.Replace)
+	}
+}
+
+// normalizations returns the normalizations given with -normalize.
+func normalizations() []string {
+	if *normalizeFlag == "" {
+		return nil
+	}
+	return strings.Split(*normalizeFlag, ",")
+}
+
+// commaList returns the comma-separated items of value, or nil.
+func commaList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// tagMatch holds the tags to base pseudo-versions on, from
+// -tag-match, -tag-exclude, -prefer-tags and -semver-tags.
+var tagMatch retrodep.TagMatch
+
+// treeOptions returns the options for retrodep.NewWorkingTree.
+func treeOptions() []retrodep.WorkingTreeOption {
+	var opts []retrodep.WorkingTreeOption
+	if *partialClone {
+		opts = append(opts, retrodep.PartialClone())
+	}
+	if *exportAttributes {
+		opts = append(opts, retrodep.ExportAttributes())
+	}
+	if *submodulesFlag {
+		opts = append(opts, retrodep.Submodules())
+	}
+	if *lfsFlag {
+		opts = append(opts, retrodep.LFS())
+	}
+	if *repoCacheDir != "" {
+		opts = append(opts, retrodep.RepoCache(*repoCacheDir, *repoCacheSize<<20))
+	}
+	if *goProxy != "" {
+		opts = append(opts, retrodep.ModuleProxy(*goProxy))
+	}
+	if *forgeAPIFlag {
+		opts = append(opts, retrodep.ForgeAPI())
+	}
+	if *keepTrees {
+		opts = append(opts, retrodep.KeepWorkingTree())
+	}
+	if *retries > 0 || *retryTimeout > 0 {
+		opts = append(opts, retrodep.Retry(retrodep.RetryPolicy{
+			Retries: *retries,
+			Backoff: *retryBackoff,
+			Timeout: *retryTimeout,
+		}))
+	}
+	if revisionLimits != (retrodep.RevisionLimits{}) {
+		opts = append(opts, retrodep.LimitRevisions(revisionLimits))
+	}
+	if *tagMatchArg != "" || *tagExcludeArg != "" || *preferTagsArg != "" || *semverTags {
+		opts = append(opts, retrodep.MatchTags(tagMatch))
+	}
+	return opts
+}
+
+// revisionLimits holds the limits from -since, -until and
+// -max-revisions.
+var revisionLimits retrodep.RevisionLimits
+
+// parseDate parses the date given for the flag name, as YYYY-MM-DD
+// (UTC) or in RFC 3339 format. A day is taken to end at its last
+// second if end is true, or to start at midnight otherwise.
+func parseDate(name, value string, end bool) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err == nil && end {
+		t = t.Add(24*time.Hour - time.Second)
+	} else if err != nil {
+		t, err = time.Parse(time.RFC3339, value)
+	}
+	if err != nil {
+		usage("-" + name + ": expected YYYY-MM-DD or RFC 3339 date")
+	}
+	return t
+}
+
+// projectTreeOptions returns the options for the working tree of
+// project for the path, and the key identifying the trees which can
+// be shared with the same options.
+func projectTreeOptions(path string, project *vcs.RepoRoot) (string, []retrodep.WorkingTreeOption) {
+	key := project.VCS.Cmd + " " + project.Repo
+	opts := treeOptions()
+	if prefix := tagPrefixes.For(path); prefix != "" {
+		// Trees with different tag prefixes cannot be shared.
+		key += " " + prefix
+		opts = append(opts, retrodep.TagPrefix(prefix))
+	}
+	if branch := branches.For(path); branch != "" {
+		// Nor can trees considering different branches.
+		key += " " + branch
+		opts = append(opts, retrodep.Branch(branch))
+	}
+	if local := localRepos.For(project.Root); local != "" {
+		key += " " + local
+		opts = append(opts, retrodep.LocalRepo(local))
+	}
+	if name := credentialNames.For(path); name != "" {
+		key += " " + name
+		opts = append(opts, retrodep.UseCredentials(name))
+	}
+	if ig := hashExcludes.For(path); ig != nil {
+		// Nor can trees leaving different files out.
+		key += " " + project.Root
+		opts = append(opts, retrodep.HashExcludes(ig))
+	}
+	return key, opts
+}
+
+// newWorkingTree creates a new retrodep.WorkingTree for the path.
+func newWorkingTree(path string, project *vcs.RepoRoot) (wt retrodep.WorkingTree, err error) {
+	key, opts := projectTreeOptions(path, project)
+	local := localRepos.For(project.Root)
+	treesMu.Lock()
+	shared, ok := sharedTrees[key]
+	treesMu.Unlock()
+	if ok {
+		return sharedTree{shared}, nil
+	}
+
+	// Faster mirrors are tried first, except when Gerrit changes
+	// are needed as mirrors do not have them, or there is a local
+	// checkout to use.
+	if !*gerritChanges && local == "" {
+		for _, mirror := range retrodep.FastMirrors(project) {
+			mwt, merr := retrodep.NewWorkingTree(mirror, opts...)
+			if merr != nil {
+				log.Debugf("%s: %s: %s", path, mirror.Repo, merr)
+				continue
+			}
+			log.Debugf("%s: using mirror %s", path, mirror.Repo)
+			treesMu.Lock()
+			mirrorsUsed[project.Repo] = mirror.Repo
+			treesMu.Unlock()
+			wt = mwt
+			break
+		}
+	}
+	if wt == nil {
+		wt, err = retrodep.NewWorkingTree(project, opts...)
+	}
+	if err != nil {
+		for _, mirror := range retrodep.HgMirrors(project) {
+			mwt, merr := retrodep.NewWorkingTree(mirror, opts...)
+			if merr != nil {
+				log.Debugf("%s: %s: %s", path, mirror.Repo, merr)
+				continue
+			}
+			log.Warningf("%s: using git mirror %s", path, mirror.Repo)
+			treesMu.Lock()
+			mirrorsUsed[project.Repo] = mirror.Repo
+			treesMu.Unlock()
+			wt, err = mwt, nil
+			break
+		}
+	}
+	if err == nil && *gerritChanges {
+		if cwt, ok := wt.(retrodep.ChangesWorkingTree); ok {
+			if ferr := cwt.FetchChanges(); ferr != nil {
+				log.Errorf("%s: fetching changes: %s", path, ferr)
+			}
+		}
+	}
+	if err == nil && (*hashAlgorithm != "" || *normalizeFlag != "") {
+		algorithm := *hashAlgorithm
+		if algorithm == "" {
+			algorithm = wt.HashAlgorithm()
+		}
+		algorithm = retrodep.NormalizedAlgorithm(algorithm, normalizations())
+		if err = wt.SetHashAlgorithm(algorithm); err != nil {
+			wt.Close()
+			return nil, err
+		}
+	}
+	treesMu.Lock()
+	defer treesMu.Unlock()
+	if err == nil && sharedTrees != nil {
+		sharedTrees[key] = wt
+		wt = sharedTree{wt}
+	}
+	return
+}
+
+// clonedTree is the result of newWorkingTree for a project.
+type clonedTree struct {
+	wt  retrodep.WorkingTree
+	err error
+}
+
+// cloneVendored starts creating the working trees for the projects
+// in vendored, as many at once as -clone-jobs allows, and returns the
+// channel each project's result will be sent on. Projects from the
+// same repository are cloned one after another, so that they can
+// share a working tree.
+func cloneVendored(vendored map[string]*retrodep.RepoPath) map[string]chan clonedTree {
+	results := make(map[string]chan clonedTree)
+	byRepo := make(map[string][]string)
+	for pkg, project := range vendored {
+		if project.Err != nil {
+			continue
+		}
+		results[pkg] = make(chan clonedTree, 1)
+		key := project.VCS.Cmd + " " + project.Repo
+		byRepo[key] = append(byRepo[key], pkg)
+	}
+	for _, pkgs := range byRepo {
+		sort.Strings(pkgs)
+		go func(pkgs []string) {
+			for _, pkg := range pkgs {
+				project := vendored[pkg]
+				wt, err := newWorkingTree(project.Root, &project.RepoRoot)
+				results[pkg] <- clonedTree{wt, err}
+			}
+		}(pkgs)
+	}
+	return results
+}
+
+// matchedProject is the result of matching a vendored project.
+type matchedProject struct {
+	wt retrodep.WorkingTree
+
+	// cloneErr is the error from creating wt, if any.
+	cloneErr error
+
+	ref *retrodep.Reference
+	err error
+
+	// project is the project with the alternate repository which
+	// matched, if any, in which case wt is its working tree.
+	project *retrodep.RepoPath
+
+	// lock is held while wt is used, as projects from the same
+	// repository share it.
+	lock *sync.Mutex
+}
+
+// matchVendored clones and matches the projects in vendored, as many
+// at once as -match-jobs allows, and returns the channel each
+// project's result will be sent on. Projects from the same
+// repository are matched one after another, in the same order as
+// the results are read, and the lock in their results must be held
+// while their working tree is used.
+func matchVendored(src *retrodep.GoSource, vendored map[string]*retrodep.RepoPath, top *retrodep.Reference) map[string]chan matchedProject {
+	clones := cloneVendored(vendored)
+	results := make(map[string]chan matchedProject)
+	byRepo := make(map[string][]string)
+	for pkg, project := range vendored {
+		if project.Err != nil {
+			continue
+		}
+		results[pkg] = make(chan matchedProject, 1)
+		key := project.VCS.Cmd + " " + project.Repo
+		byRepo[key] = append(byRepo[key], pkg)
+	}
+	var groups [][]string
+	for _, pkgs := range byRepo {
+		sort.Strings(pkgs)
+		groups = append(groups, pkgs)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i][0] < groups[j][0]
+	})
+
+	jobs := make(chan []string, len(groups))
+	for _, pkgs := range groups {
+		jobs <- pkgs
+	}
+	close(jobs)
+	workers := *matchJobs
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for pkgs := range jobs {
+				lock := &sync.Mutex{}
+				for _, pkg := range pkgs {
+					cloned := <-clones[pkg]
+					m := matchedProject{wt: cloned.wt, cloneErr: cloned.err, lock: lock}
+					if cloned.err == nil {
+						lock.Lock()
+						matchStart := time.Now()
+						m.ref, m.err = src.DescribeVendoredProject(vendored[pkg], cloned.wt, top)
+						if m.err == retrodep.ErrorVersionNotFound {
+							matchAlternates(src, vendored[pkg], top, &m)
+						}
+						retrodep.RecordPhase(retrodep.PhaseMatch, matchStart)
+						lock.Unlock()
+					}
+					results[pkg] <- m
+				}
+			}
+		}()
+	}
+	return results
+}
+
+// alternates holds the repositories read from -alternates, or nil.
+var alternates retrodep.Alternates
+
+// matchAlternates tries the alternate repositories for project, which
+// did not match in its own repository, in turn. When one matches, m
+// is updated to use it.
+func matchAlternates(src *retrodep.GoSource, project *retrodep.RepoPath, top *retrodep.Reference, m *matchedProject) {
+	for _, alt := range alternates.For(&project.RepoRoot) {
+		wt, err := newWorkingTree(project.Root, alt)
+		if err != nil {
+			log.Errorf("%s: %s: %s", project.Root, alt.Repo, err)
+			continue
+		}
+		altProject := *project
+		altProject.RepoRoot = *alt
+		ref, err := src.DescribeVendoredProject(&altProject, wt, top)
+		if err != nil {
+			if err != retrodep.ErrorVersionNotFound {
+				log.Errorf("%s: %s: %s", project.Root, alt.Repo, err)
+			}
+			wt.Close()
+			continue
+		}
+		log.Warningf("%s: no version of %s matches, found in %s",
+			project.Root, project.Repo, alt.Repo)
+		m.wt.Close()
+		m.wt, m.ref, m.err, m.project = wt, ref, nil, &altProject
+		return
+	}
+}
+
+func showTopLevel(tmpl *template.Template, src *retrodep.GoSource) *retrodep.Reference {
+	var topLevelMarker string
+	if *templateArg != "" {
+		topLevelMarker = "*"
+	}
+	main := getProject(src, *importPath)
+	if main.Err != nil {
+		log.Errorf("%s: %s", *importPath, main.Err)
+		noteUpstreamFailure(main.Root, retrodep.ErrorUnreachable)
+		displayUnknown(tmpl, topLevelMarker, nil, main.Root)
+		return nil
+	}
+
+	wt, err := newWorkingTree(src.Path, &main.RepoRoot)
+	if err != nil {
+		log.Errorf("%s: %s", src.Path, err)
+		noteUpstreamFailure(main.Root, err)
+
+		// Treat this as VersionNotFound.
+		project := &retrodep.Reference{
+			Pkg:  main.Root,
+			Repo: main.Repo,
+		}
+		displayUnknown(tmpl, topLevelMarker, project, main.Root)
+		return project
+	}
+
+	defer wt.Close()
+	matchStart := time.Now()
+	project, err := src.DescribeProject(main, wt, src.Path, nil)
+	retrodep.RecordPhase(retrodep.PhaseMatch, matchStart)
+	if project != nil {
+		annotate(project, wt)
+		checkTagSignature(project, wt)
+		checkAssertions(project, wt)
+		checkBlocklist(project)
+		checkPolicy(project, wt)
+		checkBehind(project, wt)
+		checkHealth(project, wt)
+	}
+	switch err {
+	case retrodep.ErrorVersionNotFound:
+		checkNearest(project, func() (*retrodep.NearMatch, error) {
+			return src.NearestRevision(main, wt, src.Path)
+		})
+		checkFiles(project, func(rev string) ([]retrodep.FileMatch, error) {
+			return src.FileMatches(main, wt, src.Path, rev)
+		})
+		displayUnknown(tmpl, topLevelMarker, project, main.Root)
+	case nil:
+		checkFiles(project, func(rev string) ([]retrodep.FileMatch, error) {
+			return src.FileMatches(main, wt, src.Path, rev)
+		})
+		checkPruned(project, func(rev string) (*retrodep.Pruning, error) {
+			return src.Pruning(main, wt, src.Path, rev)
+		})
+		useModuleVersion(project, wt, main.SubPath)
+		detectLicenses(project, wt, main.SubPath)
+		display(tmpl, topLevelMarker, project)
+		checkLicenses(src, project, wt, main.SubPath)
+		collectNotice(project, wt, main.SubPath)
+	default:
+		log.Fatalf("%s: %s", src.Path, err)
+	}
+
+	return project
+}
+
+func showVendored(tmpl *template.Template, src *retrodep.GoSource, top *retrodep.Reference) {
+	resolveStart := time.Now()
+	vendored, err := src.VendoredProjects()
+	retrodep.RecordPhase(retrodep.PhaseResolve, resolveStart)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Sort the projects for predictable output
+	var repos []string
+	for repo := range vendored {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+
+	var assets []*retrodep.Asset
+	if *assetsFlag {
+		assets, err = src.Assets(vendored)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Describe each vendored project
+	matches := matchVendored(src, vendored, top)
+	for _, repo := range repos {
+		project := vendored[repo]
+		if project.Err != nil {
+			log.Errorf("%s: %s", repo, project.Err)
+			noteUpstreamFailure(repo, retrodep.ErrorUnreachable)
+			ref := &retrodep.Reference{
+				TopPkg: top.Pkg,
+				TopVer: top.Ver,
+				Pkg:    repo,
+			}
+			displayUnknown(tmpl, "", ref, repo)
+			continue
+		}
+
+		matched := <-matches[repo]
+		if err := matched.cloneErr; err != nil {
+			log.Errorf("%s: %s", project.Root, err)
+			noteUpstreamFailure(project.Root, err)
+
+			// Treat this as VersionNotFound.
+			vp := &retrodep.Reference{
+				TopPkg: top.Pkg,
+				TopVer: top.Ver,
+				Pkg:    project.Root,
+				Repo:   project.Repo,
+			}
+			displayUnknown(tmpl, "", vp, project.Root)
+			continue
+		}
+
+		wt := matched.wt
+		defer wt.Close()
+		if matched.project != nil {
+			project = matched.project
+		}
+		matched.lock.Lock()
+		vp, err := matched.ref, matched.err
+		if vp != nil {
+			annotate(vp, wt)
+			checkFork(src, project, vp, wt)
+			checkReplace(src, project, vp)
+			checkTagSignature(vp, wt)
+			checkAssertions(vp, wt)
+			checkBlocklist(vp)
+			checkPolicy(vp, wt)
+			checkBehind(vp, wt)
+			checkHealth(vp, wt)
+			checkModuleHash(vp, wt)
+		}
+		switch err {
+		case retrodep.ErrorVersionNotFound:
+			checkNearest(vp, func() (*retrodep.NearMatch, error) {
+				return src.NearestVendoredRevision(project, wt)
+			})
+			checkFiles(vp, func(rev string) ([]retrodep.FileMatch, error) {
+				return src.VendoredFileMatches(project, wt, rev)
+			})
+			writePatch(vp, func(rev string, out io.Writer) (bool, error) {
+				return src.VendoredPatch(project, wt, out, rev)
+			})
+			displayUnknown(tmpl, "", vp, project.Root)
+		case nil:
+			checkFiles(vp, func(rev string) ([]retrodep.FileMatch, error) {
+				return src.VendoredFileMatches(project, wt, rev)
+			})
+			checkPruned(vp, func(rev string) (*retrodep.Pruning, error) {
+				return src.VendoredPruning(project, wt, rev)
+			})
+			writePatch(vp, func(rev string, out io.Writer) (bool, error) {
+				return src.VendoredPatch(project, wt, out, rev)
+			})
+			useModuleVersion(vp, wt, project.SubPath)
+			checkVulnerabilities(vp)
+			detectLicenses(vp, wt, project.SubPath)
+			display(tmpl, "", vp)
+			checkLicenses(src, vp, wt, project.SubPath)
+			collectNotice(vp, wt, project.SubPath)
+			if err := src.MatchAssets(assets, vp, wt); err != nil {
+				log.Errorf("%s: %s", project.Root, err)
+			}
+		default:
+			log.Fatalf("%s: %s", project.Root, err)
+		}
+		matched.lock.Unlock()
+	}
+
+	showAssets(assets)
+}
+
+// nearestShown is the number of mismatched files named in the
+// warning from checkNearest.
+const nearestShown = 5
+
+// checkNearest sets ref.Nearest, if -nearest was given, to the
+// closest upstream version returned by find, and warns about it.
+func checkNearest(ref *retrodep.Reference, find func() (*retrodep.NearMatch, error)) {
+	if !*nearestFlag || ref == nil {
+		return
+	}
+	near, err := find()
+	if err == retrodep.ErrorVersionNotFound {
+		return
+	}
+	if err != nil {
+		log.Errorf("%s: %s", ref.Pkg, err)
+		return
+	}
+	ref.Nearest = near
+	files := near.Mismatched
+	more := ""
+	if len(files) > nearestShown {
+		more = fmt.Sprintf(" and %d more", len(files)-nearestShown)
+		files = files[:nearestShown]
+	}
+	log.Warningf("%s: nearest is %s, a %.1f%% match except %s%s",
+		ref.Pkg, near.Ver, near.Similarity, strings.Join(files, ", "), more)
+}
+
+// comparedRev returns the revision found for ref, or else the
+// nearest revision, or "" if there is neither.
+func comparedRev(ref *retrodep.Reference) string {
+	if ref.Rev == "" && ref.Nearest != nil {
+		return ref.Nearest.Rev
+	}
+	return ref.Rev
+}
+
+// writePatch writes the output of patch for the revision found for
+// ref, or else the nearest revision, to a file in the directory named
+// by -patches, if it was given and there were changes.
+func writePatch(ref *retrodep.Reference, patch func(rev string, out io.Writer) (bool, error)) {
+	if *patchesDir == "" || ref == nil {
+		return
+	}
+	rev := comparedRev(ref)
+	if rev == "" {
+		return
+	}
+	var buf bytes.Buffer
+	changes, err := patch(rev, &buf)
+	if err != nil {
+		log.Errorf("%s: %s", ref.Pkg, err)
+		return
+	}
+	if !changes {
+		return
+	}
+	if err := os.MkdirAll(*patchesDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	name := filepath.Join(*patchesDir, strings.Replace(ref.Pkg, "/", "_", -1)+".patch")
+	if err := ioutil.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+	if diffStyle.Stat {
+		log.Infof("%s: wrote %s:%s", ref.Pkg, name, diffSummary(buf.Bytes()))
+		return
+	}
+	log.Infof("%s: wrote %s", ref.Pkg, name)
+}
+
+// diffStyleFlag is a flag.Value for -diff-style.
+type diffStyleFlag struct {
+	retrodep.DiffStyle
+}
+
+func (d *diffStyleFlag) Set(value string) error {
+	style, err := retrodep.ParseDiffStyle(value)
+	if err != nil {
+		return err
+	}
+	d.DiffStyle = style
+	return nil
+}
+
+var diffStyle diffStyleFlag
+
+func init() {
+	flag.Var(&diffStyle, "diff-style", "show the -diff output, and summarize -patches, in the comma-separated `list` of styles: color, stat and words")
+}
+
+// diffSummary returns the last line of the -diff-style stat output
+// for diff, such as " 2 files changed, 3 insertions(+)".
+func diffSummary(diff []byte) string {
+	var buf bytes.Buffer
+	w := retrodep.NewDiffWriter(&buf, retrodep.DiffStyle{Stat: true})
+	w.Write(diff)
+	w.Close()
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	return lines[len(lines)-1]
+}
+
+// checkFiles sets ref.Files, if -files was given, to the comparison
+// returned by compare for each file with the revision found, or else
+// the nearest revision, and ref.Changes to those which did not
+// match. Unless a report is being written, these are shown as
+// warnings and counted in a message.
+func checkFiles(ref *retrodep.Reference, compare func(rev string) ([]retrodep.FileMatch, error)) {
+	if !*filesFlag || ref == nil {
+		return
+	}
+	rev := comparedRev(ref)
+	if rev == "" {
+		return
+	}
+	files, err := compare(rev)
+	if err != nil {
+		log.Errorf("%s: %s", ref.Pkg, err)
+		return
+	}
+	ref.Files = files
+	ref.Changes = retrodep.NewFileChanges(files)
+	if report != nil || ref.Changes == nil {
+		return
+	}
+	for _, f := range files {
+		if f.Status != retrodep.FileMatched {
+			log.Warningf("%s: %s: %s", ref.Pkg, f.Path, f.Status)
+		}
+	}
+	log.Infof("%s: %d added, %d modified and %d missing compared with %s",
+		ref.Pkg, len(ref.Changes.Added), len(ref.Changes.Modified),
+		len(ref.Changes.Missing), rev)
+}
+
+// checkPruned sets ref.Pruned, if -pruned was given, to the upstream
+// files left out of the copy matching the revision found, as
+// returned by count. Unless a report is being written, these are
+// counted in a message.
+func checkPruned(ref *retrodep.Reference, count func(rev string) (*retrodep.Pruning, error)) {
+	if !*prunedFlag || ref == nil || ref.Rev == "" {
+		return
+	}
+	pruned, err := count(ref.Rev)
+	if err != nil {
+		log.Errorf("%s: %s", ref.Pkg, err)
+		return
+	}
+	ref.Pruned = pruned
+	if report != nil || pruned.Ignored == 0 {
+		return
+	}
+	log.Infof("%s: %d of %d upstream files left out of the copy, and not compared",
+		ref.Pkg, pruned.Ignored, pruned.Compared+pruned.Ignored)
+}
+
+// showAssets displays each vendored file outside the vendored
+// projects, and the upstream file it matches.
+func showAssets(assets []*retrodep.Asset) {
+	for _, asset := range assets {
+		switch {
+		case report != nil:
+			report.AddAsset(asset)
+		case comparing() || porcelain():
+		case asset.Pkg == "":
+			fmt.Printf("%s ?\n", asset.Path)
+		default:
+			fmt.Printf("%s %s:%s %s\n", asset.Path, asset.Pkg, asset.Ver, asset.File)
+		}
+	}
+}
+
+// assertionsFlag is a flag.Value collecting -expect assertions.
+type assertionsFlag []*retrodep.Assertion
+
+func (a *assertionsFlag) String() string {
+	var s []string
+	for _, assertion := range *a {
+		s = append(s, assertion.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func (a *assertionsFlag) Set(value string) error {
+	assertion, err := retrodep.ParseAssertion(value)
+	if err != nil {
+		return err
+	}
+	*a = append(*a, assertion)
+	return nil
+}
+
+var assertions assertionsFlag
+
+func init() {
+	flag.Var(&assertions, "expect", "require the version of a package to satisfy `PKG[OP]VERSION`, where OP is one of = != < <= > >= (may be repeated)")
+}
+
+// assertionsChecked records which assertions a project was checked
+// against.
+var assertionsChecked = make(map[*retrodep.Assertion]bool)
+
+// assertionFailed is true if any assertion was not satisfied.
+var assertionFailed = false
+
+// checkAssertions checks ref against any -expect assertions for its
+// package, using d to interpret asserted revisions.
+func checkAssertions(ref *retrodep.Reference, d retrodep.Describable) {
+	for _, a := range assertions {
+		if a.Pkg != ref.Pkg {
+			continue
+		}
+		assertionsChecked[a] = true
+		if err := a.Check(ref, d); err != nil {
+			log.Errorf("%s", err)
+			assertionFailed = true
+		}
+	}
+}
+
+// checkAssertionsSeen reports assertions for packages which were not
+// found at all.
+func checkAssertionsSeen() {
+	for _, a := range assertions {
+		if !assertionsChecked[a] {
+			log.Errorf("%s: not found, expected %s %s", a.Pkg, a.Op, a.Version)
+			assertionFailed = true
+		}
+	}
+}
+
+// blocklist holds the versions read from -blocklist.
+var blocklist retrodep.Blocklist
+
+// blockedFound is true if any version found is in the blocklist.
+var blockedFound = false
+
+// checkBlocklist reports ref if it is in the blocklist.
+func checkBlocklist(ref *retrodep.Reference) {
+	blocked := blocklist.Match(ref)
+	if blocked == nil {
+		return
+	}
+	msg := fmt.Sprintf("%s: %s is blocklisted", ref.Pkg, blocked.Version)
+	if blocked.Reason != "" {
+		msg += ": " + blocked.Reason
+	}
+	if *blocklistWarn {
+		log.Warning(msg)
+		return
+	}
+	log.Error(msg)
+	blockedFound = true
+}
+
+// sumDB is the checksum database given by -sumdb, or nil.
+var sumDB *retrodep.SumDB
+
+// moduleHashMismatch is true if any module hash differs from the
+// checksum database.
+var moduleHashMismatch = false
+
+// checkModuleHash sets ref.ModuleHash, for the version found in wt,
+// if -module-hash or -sumdb was given, and checks it against the
+// checksum database if -sumdb was given.
+func checkModuleHash(ref *retrodep.Reference, wt retrodep.WorkingTree) {
+	if !*moduleHashFlag && sumDB == nil {
+		return
+	}
+	if s, ok := wt.(sharedTree); ok {
+		wt = s.WorkingTree
+	}
+	hash, err := retrodep.UpstreamModuleHash(ref, wt)
+	if err == retrodep.ErrorVersionNotFound {
+		return
+	}
+	if err != nil {
+		log.Errorf("%s: %s", ref.Pkg, err)
+		return
+	}
+	ref.ModuleHash = hash
+	if sumDB == nil {
+		return
+	}
+	version := retrodep.GoModVersion(ref)
+	published, err := sumDB.Lookup(ref.Pkg, version)
+	switch {
+	case err == retrodep.ErrorVersionNotFound:
+		log.Warningf("%s: %s is not in the checksum database", ref.Pkg, version)
+	case err != nil:
+		log.Errorf("%s: %s", ref.Pkg, err)
+	case published != hash:
+		log.Errorf("%s: %s has module hash %s but the checksum database has %s",
+			ref.Pkg, version, hash, published)
+		moduleHashMismatch = true
+	default:
+		log.Debugf("%s: %s matches the checksum database", ref.Pkg, version)
+	}
+}
+
+// vulnDB is the vulnerability database given by -osv, or nil.
+var vulnDB retrodep.VulnerabilityDB
+
+// vulnerable is true if any version found has known vulnerabilities.
+var vulnerable = false
+
+// openVulnerabilityDB returns the OSV API client for source if it is
+// a URL, or else reads the OSV records in the directory or zip file
+// it names.
+func openVulnerabilityDB(source string) retrodep.VulnerabilityDB {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		return retrodep.NewOSV(source)
+	}
+	db, err := retrodep.ReadOSVDatabase(source)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return db
+}
+
+// checkVulnerabilities sets ref.Vulnerabilities, if -osv was given,
+// and shows an error for each one.
+func checkVulnerabilities(ref *retrodep.Reference) {
+	if vulnDB == nil {
+		return
+	}
+	err := retrodep.CheckVulnerabilities(vulnDB, ref)
+	if err == retrodep.ErrorVersionNotFound {
+		log.Debugf("%s: no module version to look up vulnerabilities for", ref.Pkg)
+		return
+	}
+	if err != nil {
+		log.Errorf("%s: %s", ref.Pkg, err)
+		return
+	}
+	for _, v := range ref.Vulnerabilities {
+		msg := fmt.Sprintf("%s: %s is affected by %s", ref.Pkg, ref.Ver, v.ID)
+		if len(v.Aliases) > 0 {
+			msg += " (" + strings.Join(v.Aliases, ", ") + ")"
+		}
+		if v.Summary != "" {
+			msg += ": " + v.Summary
+		}
+		if v.Fixed != "" {
+			msg += ", fixed in " + v.Fixed
+		}
+		log.Error(msg)
+		vulnerable = true
+	}
+}
+
+// useModuleVersion replaces ref.Ver, found in wt at subPath, with
+// the version Go modules know it by, if -module-versions was given.
+func useModuleVersion(ref *retrodep.Reference, wt retrodep.WorkingTree, subPath string) {
+	if !*moduleVersionsFlag {
+		return
+	}
+	ver, err := retrodep.ModuleVersion(ref, wt, subPath)
+	if err != nil {
+		log.Errorf("%s: %s", ref.Pkg, err)
+		return
+	}
+	if ver != "" {
+		ref.Ver = ver
+	}
+}
+
+// unverifiedTag is true if any tag matched was not verified.
+var unverifiedTag = false
+
+// checkTagSignature sets ref.Signature for the tag matched in wt, if
+// -tag-keyring or -tag-allowed-signers was given, showing an error if
+// it is not verified.
+func checkTagSignature(ref *retrodep.Reference, wt retrodep.WorkingTree) {
+	if (*tagKeyring == "" && *tagAllowedSigners == "") || ref.Tag == "" {
+		return
+	}
+	if s, ok := wt.(sharedTree); ok {
+		wt = s.WorkingTree
+	}
+	swt, ok := wt.(retrodep.SignedTagsWorkingTree)
+	if !ok {
+		log.Warningf("%s: cannot verify tag %s", ref.Pkg, ref.Tag)
+		return
+	}
+	sig, err := swt.VerifyTag(ref.Tag, retrodep.TagKeyring{
+		GnuPGHome:      *tagKeyring,
+		AllowedSigners: *tagAllowedSigners,
+	})
+	if err != nil {
+		log.Errorf("%s: %s", ref.Pkg, err)
+		return
+	}
+	ref.Signature = sig
+	if sig.Verified {
+		log.Debugf("%s: %s is signed by %s", ref.Pkg, ref.Tag, sig.Signer)
+		return
+	}
+	log.Errorf("%s: tag %s is not verified: %s", ref.Pkg, ref.Tag, sig.Reason)
+	unverifiedTag = true
+}
+
+// policy is the policy read from -policy, or nil.
+var policy *retrodep.Policy
+
+// policyViolated is true if any version found violates the policy.
+var policyViolated = false
+
+// checkPolicy evaluates the policy for ref, whose upstream is wt.
+func checkPolicy(ref *retrodep.Reference, wt retrodep.WorkingTree) {
+	if policy == nil {
+		return
+	}
+	violations, err := policy.Evaluate(ref, wt)
+	if err != nil {
+		log.Fatalf("%s: %s", ref.Pkg, err)
+	}
+	for _, v := range violations {
+		policyViolated = true
+		if report != nil {
+			report.AddViolation(v)
+		} else {
+			log.Errorf("%s", v)
+		}
+	}
+}
+
+// checkBehind sets ref.Behind if -behind was given, warning if ref,
+// found in wt, is behind the latest upstream release.
+func checkBehind(ref *retrodep.Reference, wt retrodep.WorkingTree) {
+	if !*behindFlag {
+		return
+	}
+	if s, ok := wt.(sharedTree); ok {
+		wt = s.WorkingTree
+	}
+	behind, err := retrodep.Behind(ref, wt)
+	if err != nil {
+		log.Errorf("%s: %s", ref.Pkg, err)
+		return
+	}
+	if behind == nil {
+		return
+	}
+	ref.Behind = behind
+	if behind.Commits > 0 {
+		log.Warningf("%s: %s is %d commits and %d releases behind %s",
+			ref.Pkg, ref.Ver, behind.Commits, behind.Releases, behind.Latest)
+	}
+}
+
+// checkHealth shows the state of the upstream repository for ref,
+// found in wt, if -health was given.
+func checkHealth(ref *retrodep.Reference, wt retrodep.WorkingTree) {
+	if !*healthFlag {
+		return
+	}
+	health, err := retrodep.CheckRepoHealth(ref)
+	if err == retrodep.ErrorForgeLimited {
+		health, err = retrodep.RepoHealthFromWorkingTree(ref, wt)
+	}
+	if err != nil {
+		log.Debugf("%s: %s", ref.Pkg, err)
+		return
+	}
+	if report != nil {
+		report.AddHealth(health)
+		return
+	}
+	if health.Archived {
+		log.Warningf("%s: %s is archived", ref.Pkg, health.Repo)
+	}
+	if health.MovedTo != "" {
+		log.Warningf("%s: %s has moved to %s", ref.Pkg, health.Repo, health.MovedTo)
+	}
+	inactive := time.Duration(*inactiveYears) * 365 * 24 * time.Hour
+	if health.Inactive(inactive, time.Now()) {
+		log.Warningf("%s: %s has had no commits since %s", ref.Pkg,
+			health.Repo, health.LastPush.Format("2006-01-02"))
+	}
+}
+
+func readPolicyFile() *retrodep.Policy {
+	if *policyFrom == "" {
+		return nil
+	}
+
+	f, err := os.Open(*policyFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	p, err := retrodep.ReadPolicy(f)
+	if err != nil {
+		log.Fatalf("%s: %s", *policyFrom, err)
+	}
+	return p
+}
+
+// openBlocklist opens the file or URL named by -blocklist.
+func openBlocklist() (io.ReadCloser, error) {
+	if !strings.HasPrefix(*blocklistFrom, "http://") &&
+		!strings.HasPrefix(*blocklistFrom, "https://") {
+		return os.Open(*blocklistFrom)
+	}
+
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: retrodep.NewCredentialsTransport(nil),
+	}
+	resp, err := client.Get(*blocklistFrom)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func readBlocklist() retrodep.Blocklist {
+	if *blocklistFrom == "" {
+		return nil
+	}
+
+	r, err := openBlocklist()
+	if err != nil {
+		log.Fatalf("%s: %s", *blocklistFrom, err)
+	}
+	defer r.Close()
+
+	b, err := retrodep.ReadBlocklist(r)
+	if err != nil {
+		log.Fatalf("%s: %s", *blocklistFrom, err)
+	}
+	return b
+}
+
+// plannedClone describes a repository which would be cloned, and
+// the packages it would be cloned for.
+type plannedClone struct {
+	root *vcs.RepoRoot
+	pkgs []string
+}
+
+// humanSize formats a size in bytes for display.
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// showPlan resolves the import paths for the top-level project and
+// its vendored dependencies, and displays the repositories which
+// would be cloned, how many tags would be tried and which caches
+// would be used, without cloning any of them.
+func showPlan(src *retrodep.GoSource) {
+	var planned []*plannedClone
+	byRepo := make(map[string]*plannedClone)
+	add := func(pkg string, project *retrodep.RepoPath) {
+		if project.Err != nil {
+			log.Errorf("%s: %s", pkg, project.Err)
+			fmt.Printf("%s ?\n", pkg)
+			return
+		}
+		p, ok := byRepo[project.Repo]
+		if !ok {
+			p = &plannedClone{root: &project.RepoRoot}
+			byRepo[project.Repo] = p
+			planned = append(planned, p)
+		}
+		p.pkgs = append(p.pkgs, pkg)
+	}
+
+	main := getProject(src, *importPath)
+	add(main.Root, main)
+	if *depsFlag {
+		vendored, err := src.VendoredProjects()
+		if err != nil {
+			log.Fatal(err)
+		}
+		var pkgs []string
+		for pkg := range vendored {
+			pkgs = append(pkgs, pkg)
+		}
+		sort.Strings(pkgs)
+		for _, pkg := range pkgs {
+			add(pkg, vendored[pkg])
+		}
+	}
+
+	for _, p := range planned {
+		size := "?"
+		if s, err := retrodep.EstimateRepoSize(p.root); err == nil {
+			size = "~" + humanSize(s)
+		}
+
+		tags, cached, cache := "?", "?", "cache:miss"
+		_, opts := projectTreeOptions(p.pkgs[0], p.root)
+		plan, err := retrodep.PlanWorkingTree(context.Background(), p.root, opts...)
+		if err != nil {
+			log.Warningf("%s: %s", p.root.Repo, err)
+		} else {
+			if plan.Tags >= 0 {
+				tags = strconv.Itoa(plan.Tags)
+				cached = strconv.Itoa(plan.CachedTags)
+			}
+			if plan.Mirrored {
+				cache = "cache:hit"
+			}
+			if plan.LocalRepo != "" {
+				cache = "local:" + plan.LocalRepo
+			}
+		}
+		fmt.Printf("%s %s %s tags:%s hashes:%s %s %s\n", p.root.VCS.Cmd,
+			p.root.Repo, size, tags, cached, cache, strings.Join(p.pkgs, ","))
+	}
+}
+
+// describe shows the top-level project for src and, unless -deps=false,
+// its vendored projects. It returns the References displayed.
+func describe(tmpl *template.Template, src *retrodep.GoSource) []*retrodep.Reference {
+	if porcelain() {
+		defer func(stdout *os.File) { os.Stdout = stdout }(os.Stdout)
+		os.Stdout = os.Stderr
+	}
+	start := len(references)
+	top := showTopLevel(tmpl, src)
+	if *depsFlag {
+		showVendored(tmpl, src, top)
+	}
+	refs := references[start:]
+	if src.SubPath != "" {
+		for _, ref := range refs {
+			ref.SourceDir = filepath.ToSlash(src.SubPath)
+		}
+	}
+	return refs
+}
+
+// treeLock returns a lock for the tree at path. If path is a file it
+// is read as a lock file written by the lock command, otherwise
+// the versions of the projects in the tree are worked out.
+func treeLock(tmpl *template.Template, path string) *retrodep.Lock {
+	if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		lock, err := retrodep.ReadLock(f)
+		if err != nil {
+			log.Fatalf("%s: %s", path, err)
+		}
+		return lock
+	}
+
+	lock := &retrodep.Lock{}
+	for _, src := range findSources(path) {
+		addLockEntries(lock, src, describe(tmpl, src))
+	}
+	return lock
+}
+
+// ver returns the version in entry, or "?" if none was found.
+func ver(entry *retrodep.LockEntry) string {
+	if entry.Ver == "" {
+		return "?"
+	}
+	return entry.Ver
+}
+
+// showChanges displays the changes in projects from one lock to
+// another: as JSON with -o json, as tab-separated kind, import path,
+// old and new versions with -o porcelain, and otherwise as text.
+func showChanges(from, to *retrodep.Lock) {
+	changes := retrodep.CompareLocks(from, to)
+	switch {
+	case *outputArg == "json":
+		if err := retrodep.WriteChanges(os.Stdout, changes); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case porcelain():
+		for _, c := range changes {
+			var oldVer, newVer string
+			if c.Old != nil {
+				oldVer = c.Old.Ver
+			}
+			if c.New != nil {
+				newVer = c.New.Ver
+			}
+			fmt.Fprintf(porcelainOut, "%s\t%s\t%s\t%s\n", c.Kind, c.Pkg(), oldVer, newVer)
+		}
+		return
+	}
+	for _, c := range changes {
+		switch c.Kind {
+		case retrodep.ChangeAdded, retrodep.ChangeRepatched:
+			fmt.Printf("%s %s %s\n", c.Kind, c.New.Pkg, ver(c.New))
+		case retrodep.ChangeRemoved:
+			fmt.Printf("%s %s %s\n", c.Kind, c.Old.Pkg, ver(c.Old))
+		default:
+			fmt.Printf("%s %s %s -> %s\n", c.Kind, c.New.Pkg,
+				ver(c.Old), ver(c.New))
+		}
+	}
+}
+
+// compareTrees displays the changes in vendored projects between the
+// trees (or lock files) at oldPath and newPath.
+func compareTrees(tmpl *template.Template, oldPath, newPath string) {
+	sharedTrees = make(map[string]retrodep.WorkingTree)
+	defer closeSharedTrees()
+	showChanges(treeLock(tmpl, oldPath), treeLock(tmpl, newPath))
+}
+
+// driftRefs displays the changes in vendored projects of the
+// -importpath project between its upstream refs oldRef and newRef.
+func driftRefs(tmpl *template.Template, oldRef, newRef string) {
+	if *importPath == "" {
+		fmt.Fprintln(os.Stderr, "Provide import path with -importpath")
+		os.Exit(3)
+	}
+	root, err := retrodep.DefaultResolver.RepoRootForImportPath(*importPath)
+	if err != nil {
+		log.Fatalf("%s: %s", *importPath, err)
+	}
+
+	// This checkout is what is examined, so is not shared: the
+	// top-level project is matched using a separate one.
+	wt, err := newWorkingTree(*importPath, root)
+	if err != nil {
+		log.Fatalf("%s: %s", *importPath, err)
+	}
+	defer wt.Close()
+
+	sharedTrees = make(map[string]retrodep.WorkingTree)
+	defer closeSharedTrees()
+
+	subPath := strings.TrimPrefix(*importPath, root.Root)
+	dir := filepath.Join(wt.Root(), filepath.FromSlash(subPath))
+	refLock := func(ref string) *retrodep.Lock {
+		if err := wt.RevSync(ref); err != nil {
+			log.Fatalf("%s: %s", ref, err)
+		}
+		return treeLock(tmpl, dir)
+	}
+	showChanges(refLock(oldRef), refLock(newRef))
+}
+
+// scanBatch describes the projects listed in the batch file at path,
+// sharing working trees between them, and writes a report of them
+// all, keyed by project. Projects which cannot be scanned are
+// reported as errors rather than ending the batch.
+func scanBatch(tmpl *template.Template, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	targets, err := retrodep.ReadBatch(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("%s: %s", path, err)
+	}
+
+	sharedTrees = make(map[string]retrodep.WorkingTree)
+	defer closeSharedTrees()
+	batch := retrodep.NewBatchReport()
+	for _, target := range targets {
+		name := target.Name()
+		if err := scanTarget(tmpl, target); err != nil {
+			log.Errorf("%s: %s", name, err)
+			batch.Fail(name, err)
+			continue
+		}
+		batch.Add(name, report)
+	}
+	report = nil
+	progress.hide()
+	if err := batch.Write(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// scanTarget describes the projects in target into a new report,
+// cloning its repository first if it has one.
+func scanTarget(tmpl *template.Template, target retrodep.BatchTarget) error {
+	dir := target.Path
+	if root := target.RepoRoot(); root != nil {
+		// This checkout is what is examined, so is not shared:
+		// the top-level project is matched using a separate one.
+		wt, err := retrodep.NewWorkingTree(root, treeOptions()...)
+		if err != nil {
+			return err
+		}
+		defer wt.Close()
+		if target.Ref != "" {
+			if err := wt.RevSync(target.Ref); err != nil {
+				return fmt.Errorf("%s: %s", target.Ref, err)
+			}
+		}
+		dir = wt.Root()
+	}
+	srcs, err := goSources(dir)
+	if err != nil {
+		return err
+	}
+	*importPath = target.ImportPath
+	report = retrodep.NewReport()
+	for _, src := range srcs {
+		describe(tmpl, src)
+	}
+	return nil
+}
+
+// checkLicenses warns about license file changes between the local
+// copy of ref and its upstream, wt, if -licenses was given.
+func checkLicenses(src *retrodep.GoSource, ref *retrodep.Reference, wt retrodep.WorkingTree, subPath string) {
+	if !*licensesFlag {
+		return
+	}
+	changes, err := src.LicenseChanges(ref, wt, subPath)
+	if err != nil {
+		log.Fatalf("%s: %s", ref.Pkg, err)
+	}
+	for _, c := range changes {
+		log.Warningf("%s: %s %s upstream at %s", ref.Pkg, c.Name, c.Kind, c.Ref)
+	}
+}
+
+// detectLicenses sets the licenses of ref, whose upstream is wt, if
+// -detect-licenses was given.
+func detectLicenses(ref *retrodep.Reference, wt retrodep.WorkingTree, subPath string) {
+	if !*detectLicensesFlag {
+		return
+	}
+	if err := retrodep.DetectLicenses(wt, ref, subPath); err != nil {
+		log.Fatalf("%s: %s", ref.Pkg, err)
+	}
+}
+
+// notices holds the notices collected for -notice.
+var notices []*retrodep.Notice
+
+// collectNotice adds the notice for ref, whose upstream is wt, to
+// notices if -notice was given.
+func collectNotice(ref *retrodep.Reference, wt retrodep.WorkingTree, subPath string) {
+	if *noticeFile == "" {
+		return
+	}
+	notice, err := retrodep.CollectNotice(wt, ref, subPath)
+	if err != nil {
+		log.Fatalf("%s: %s", ref.Pkg, err)
+	}
+	notices = append(notices, notice)
+}
+
+// writeNotices writes notices to the file named by -notice.
+func writeNotices() {
+	f, err := os.Create(*noticeFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = retrodep.WriteNotices(f, notices)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// addLockEntries appends lock entries for refs, which were displayed
+// for src, to lock.
+func addLockEntries(lock *retrodep.Lock, src *retrodep.GoSource, refs []*retrodep.Reference) {
+	for _, ref := range refs {
+		entry, err := src.NewLockEntry(ref)
+		if err != nil {
+			log.Fatalf("%s: %s", ref.Pkg, err)
+		}
+		lock.Projects = append(lock.Projects, entry)
+	}
+}
+
+// vendoredModules holds the modules to write to the file named by
+// -modules-txt.
+var vendoredModules []*retrodep.VendoredModule
+
+// addVendoredModules appends the modules for refs, which were
+// displayed for src, to vendoredModules.
+func addVendoredModules(src *retrodep.GoSource, refs []*retrodep.Reference) {
+	modules, err := src.VendoredModules(refs)
+	if err != nil {
+		log.Fatalf("%s: %s", src.Path, err)
+	}
+	vendoredModules = append(vendoredModules, modules...)
+}
+
+// writeModulesTxt writes vendoredModules to the file named by
+// -modules-txt.
+func writeModulesTxt() {
+	f, err := os.Create(*modulesTxtFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = retrodep.WriteModulesTxt(f, vendoredModules)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeLock writes lock to the file named by -lock.
+func writeLock(lock *retrodep.Lock) {
+	f, err := os.Create(*lockFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = lock.Write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// checkLock verifies srcs against the file named by -lock,
+// displaying any differences. It returns true if there were any.
+func checkLock(srcs []*retrodep.GoSource) bool {
+	f, err := os.Open(*lockFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lock, err := retrodep.ReadLock(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("%s: %s", *lockFile, err)
+	}
+
+	drifted := false
+	for _, src := range srcs {
+		drifts, err := src.VerifyLock(lock)
+		if err != nil {
+			log.Fatalf("%s: %s", src.Path, err)
+		}
+		for _, drift := range drifts {
+			drifted = true
+			if drift.Entry != nil {
+				fmt.Printf("%s %s %s:%s\n", drift.Kind, drift.Dir,
+					drift.Entry.Pkg, drift.Entry.Ver)
+			} else {
+				fmt.Printf("%s %s\n", drift.Kind, drift.Dir)
+			}
+		}
+	}
+	return drifted
+}
+
+// verifyAttestation checks that the local files of each project in
+// the -attestation still match the upstream revision attested,
+// showing those which do not or could not be checked. It returns
+// true if any were found.
+func verifyAttestation(srcs []*retrodep.GoSource) bool {
+	if *attestationFile == "" {
+		usage("verify needs -attestation")
+	}
+	f, err := os.Open(*attestationFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report, err := retrodep.ReadAttestedReport(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("%s: %s", *attestationFile, err)
+	}
+
+	tampered := false
+	show := func(kind, dir string, ref *retrodep.Reference) {
+		tampered = true
+		if ref != nil {
+			fmt.Printf("%s %s %s:%s\n", kind, dir, ref.Pkg, ref.Ver)
+		} else {
+			fmt.Printf("%s %s\n", kind, dir)
+		}
+	}
+	for _, src := range srcs {
+		vendored, err := src.VendoredProjects()
+		if err != nil {
+			log.Fatal(err)
+		}
+		attested := make(map[string]bool)
+		for _, ref := range report.Projects {
+			if ref.SourceDir != filepath.ToSlash(src.SubPath) {
+				continue
+			}
+			dir := src.ProjectDir(ref)
+			var project *retrodep.RepoPath
+			if ref.TopPkg == "" {
+				importPath := *importPath
+				if importPath == "" {
+					importPath = ref.Pkg
+				}
+				project = getProject(src, importPath)
+			} else {
+				attested[ref.Pkg] = true
+				project = vendored[ref.Pkg]
+				if project == nil {
+					show(retrodep.DriftMissing, dir, ref)
+					continue
+				}
+			}
+			if ref.Rev == "" {
+				log.Warningf("%s: no revision attested", ref.Pkg)
+				show(retrodep.DriftUnverified, dir, ref)
+				continue
+			}
+			if project.Err != nil {
+				log.Errorf("%s: %s", ref.Pkg, project.Err)
+				show(retrodep.DriftUnverified, dir, ref)
+				continue
+			}
+
+			root := project.RepoRoot
+			if ref.Mirror != "" {
+				root.Repo = ref.Mirror
+			} else if ref.Repo != "" {
+				root.Repo = ref.Repo
+			}
+			wt, err := newWorkingTree(src.Path, &root)
+			if err != nil {
+				log.Errorf("%s: %s", ref.Pkg, err)
+				show(retrodep.DriftUnverified, dir, ref)
+				continue
+			}
+			mismatches, err := src.VerifyReference(project, ref, wt)
+			wt.Close()
+			switch {
+			case err == retrodep.ErrorNoFiles || os.IsNotExist(err):
+				show(retrodep.DriftMissing, dir, ref)
+			case err != nil:
+				log.Errorf("%s: %s", ref.Pkg, err)
+				show(retrodep.DriftUnverified, dir, ref)
+			case len(mismatches) > 0:
+				show(retrodep.DriftModified, dir, ref)
+				for _, file := range mismatches {
+					log.Warningf("%s: %s does not match %s", ref.Pkg, file, ref.Rev)
+				}
+			}
+		}
+
+		var roots []string
+		for root := range vendored {
+			if !attested[root] {
+				roots = append(roots, root)
+			}
+		}
+		sort.Strings(roots)
+		for _, root := range roots {
+			ref := &retrodep.Reference{TopPkg: "?", Pkg: root}
+			show(retrodep.DriftUntracked, src.ProjectDir(ref), nil)
+		}
+	}
+	return tampered
+}
+
+// verifyModuleZips checks that the files in each module zip found at
+// pth match the upstream revision the module version claims to be,
+// showing those which do not or could not be checked. It returns
+// true if any were found.
+func verifyModuleZips(pth string) bool {
+	zips, err := retrodep.FindModuleZips(pth)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(zips) == 0 {
+		fmt.Fprintf(os.Stderr, "%s: no module zip files at %s\n",
+			filepath.Base(os.Args[0]), pth)
+		os.Exit(4)
+	}
+
+	tampered := false
+	for _, z := range zips {
+		modVer := z.Path + "@" + z.Version
+		unverified := func(err error) {
+			log.Errorf("%s: %s", modVer, err)
+			fmt.Printf("%s %s\n", retrodep.DriftUnverified, modVer)
+			tampered = true
+		}
+		root, err := z.RepoRoot()
+		if err != nil {
+			unverified(err)
+			continue
+		}
+		if o := z.Origin; o != nil && o.URL != "" && !retrodep.SameRepo(o.URL, root.Repo) {
+			log.Warningf("%s: claims origin %s, not %s", modVer, o.URL, root.Repo)
+		}
+		wt, err := newWorkingTree(pth, root)
+		if err != nil {
+			unverified(err)
+			continue
+		}
+		rev, mismatches, err := retrodep.VerifyModuleZip(z, root, wt)
+		wt.Close()
+		switch {
+		case err != nil:
+			unverified(err)
+		case len(mismatches) > 0:
+			fmt.Printf("%s %s %s\n", retrodep.DriftModified, modVer, rev)
+			for _, file := range mismatches {
+				log.Warningf("%s: %s does not match %s", modVer, file, rev)
+			}
+			tampered = true
+		default:
+			log.Debugf("%s: matches %s", modVer, rev)
+		}
+	}
+	return tampered
+}
+
+func readExcludeFile() []string {
+	if *excludeFrom == "" {
+		return nil
+	}
+
+	e, err := os.Open(*excludeFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer e.Close()
+
+	excludes := make([]string, 0)
+	scanner := bufio.NewScanner(bufio.NewReader(e))
+	for scanner.Scan() {
+		excludes = append(excludes, strings.TrimSpace(scanner.Text()))
+	}
+	return excludes
+}
+
+// configHints holds the preferred tags read from -config.
+var configHints retrodep.Hints
+
+// configForks holds the import path prefixes whose repositories are
+// forks, read from -config.
+var configForks []string
+
+// tagChooser returns the TagChooser for -prefer and -interactive, or
+// nil for the default policy.
+func tagChooser() retrodep.TagChooser {
+	chooser, err := retrodep.TagPolicy(*preferArg)
+	if err != nil {
+		usage(err.Error())
+	}
+	if *interactiveFlag {
+		return promptTag(chooser, os.Stdin, os.Stderr)
+	}
+	if *preferArg == retrodep.PreferOldest {
+		return nil
+	}
+	return chooser
+}
+
+// chosenTags holds the tags chosen with -interactive, by import path.
+var chosenTags = make(map[string]string)
+
+// promptTag returns a TagChooser which asks on out which tag to
+// report, reading the answer from in, with the choice of fallback
+// as the default. Each answer is recorded in chosenTags.
+func promptTag(fallback retrodep.TagChooser, in io.Reader, out io.Writer) retrodep.TagChooser {
+	var mu sync.Mutex
+	input := bufio.NewReader(in)
+	return func(project *retrodep.RepoPath, tags []string) (string, error) {
+		def, err := fallback(project, tags)
+		if err != nil {
+			return "", err
+		}
+
+		// Matching runs concurrently, so ask one question at a time.
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(out, "%s matches these tags identically:\n", project.Root)
+		for i, tag := range tags {
+			fmt.Fprintf(out, "%4d) %s\n", i+1, tag)
+		}
+		for {
+			fmt.Fprintf(out, "Report which? [%s] ", def)
+			line, err := input.ReadString('\n')
+			answer := strings.TrimSpace(line)
+			if answer == "" {
+				if err != nil {
+					// No more input: use the default
+					// without remembering it.
+					fmt.Fprintln(out)
+					return def, nil
+				}
+				answer = def
+			}
+			choice := answer
+			if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(tags) {
+				choice = tags[n-1]
+			}
+			for _, tag := range tags {
+				if tag == choice {
+					chosenTags[project.Root] = choice
+					return choice, nil
+				}
+			}
+			if err != nil {
+				return "", fmt.Errorf("%s: %q is not one of the tags", project.Root, answer)
+			}
+			fmt.Fprintf(out, "%q is not one of the tags\n", answer)
+		}
+	}
+}
+
+// saveChosenTags remembers the tags chosen with -interactive as
+// preferred tags in the -config file.
+func saveChosenTags() {
+	if len(chosenTags) == 0 {
+		return
+	}
+	if *configFrom == "" {
+		log.Warning("not remembering the tags chosen: no -config file")
+		return
+	}
+
+	config := &retrodep.Config{}
+	if r, err := os.Open(*configFrom); err == nil {
+		config, err = retrodep.ReadConfig(r)
+		r.Close()
+		if err != nil {
+			log.Fatalf("%s: %s", *configFrom, err)
+		}
+	} else if !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	for importPath, tag := range chosenTags {
+		config.Prefer(importPath, tag)
+	}
+
+	f, err := os.Create(*configFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = config.Write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// fingerprintIndex holds the fingerprint index read from -index, if
+// not indexing.
+var fingerprintIndex *retrodep.FingerprintIndex
+
+// readFingerprintIndex returns the fingerprint index in the -index
+// file.
+func readFingerprintIndex() *retrodep.FingerprintIndex {
+	f, err := os.Open(*indexFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	x, err := retrodep.ReadFingerprintIndex(f)
+	if err != nil {
+		log.Fatalf("%s: %s", *indexFrom, err)
+	}
+	return x
+}
+
+// indexRepos records the fingerprints of the repositories for the
+// import paths listed in the file at path, one per line, in the
+// -index file, keeping those of other repositories already there.
+// Blank lines and lines starting with "#" are ignored.
+func indexRepos(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var importPaths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			importPaths = append(importPaths, line)
+		}
+	}
+	f.Close()
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("%s: %s", path, err)
+	}
+
+	var x *retrodep.FingerprintIndex
+	if _, err := os.Stat(*indexFrom); err == nil {
+		x = readFingerprintIndex()
+		if *indexFilesArg != "" && strings.Join(x.Files, ",") != *indexFilesArg {
+			log.Fatalf("%s: fingerprints are of %s", *indexFrom, strings.Join(x.Files, ","))
+		}
+	} else if x, err = retrodep.NewFingerprintIndex(commaList(*indexFilesArg)); err != nil {
+		usage("-index-files: " + err.Error())
+	}
+
+	failed := false
+	for _, importPath := range importPaths {
+		root, err := retrodep.DefaultResolver.RepoRootForImportPath(importPath)
+		if err != nil {
+			log.Errorf("%s: %s", importPath, err)
+			failed = true
+			continue
+		}
+		wt, err := newWorkingTree(importPath, root)
+		if err != nil {
+			log.Errorf("%s: %s", importPath, err)
+			failed = true
+			continue
+		}
+		err = x.Index(root.Repo, wt)
+		wt.Close()
+		if err != nil {
+			log.Errorf("%s: %s", importPath, err)
+			failed = true
+		}
+	}
+
+	// Replace the index only once it is complete.
+	tmp, err := ioutil.TempFile(filepath.Dir(*indexFrom), ".retrodep-index.")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := x.Write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		log.Fatal(err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		log.Fatal(err)
+	}
+	if err := os.Rename(tmp.Name(), *indexFrom); err != nil {
+		os.Remove(tmp.Name())
+		log.Fatal(err)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func readHintsFile() retrodep.Hints {
+	if *hintsFrom == "" {
+		return nil
+	}
+
+	h, err := os.Open(*hintsFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer h.Close()
+
+	hints, err := retrodep.ReadHints(h)
+	if err != nil {
+		log.Fatalf("%s: %s", *hintsFrom, err)
+	}
+	return hints
+}
+
+func readRelocationsFile() retrodep.Relocations {
+	if *relocationsFrom == "" {
+		return nil
+	}
+
+	r, err := os.Open(*relocationsFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+
+	relocations, err := retrodep.ReadRelocations(r)
+	if err != nil {
+		log.Fatalf("%s: %s", *relocationsFrom, err)
+	}
+	return relocations
+}
+
+// readTagPrefixesFile returns the tag prefixes listed in
+// -tag-prefixes, or nil.
+func readTagPrefixesFile() retrodep.TagPrefixes {
+	if *tagPrefixesFrom == "" {
+		return nil
+	}
+
+	r, err := os.Open(*tagPrefixesFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+
+	prefixes, err := retrodep.ReadTagPrefixes(r)
+	if err != nil {
+		log.Fatalf("%s: %s", *tagPrefixesFrom, err)
+	}
+	return prefixes
+}
+
+// readBranchesFile returns the branches listed in -branches, or nil.
+func readBranchesFile() retrodep.Branches {
+	if *branchesFrom == "" {
+		return nil
+	}
+
+	r, err := os.Open(*branchesFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+
+	b, err := retrodep.ReadBranches(r)
+	if err != nil {
+		log.Fatalf("%s: %s", *branchesFrom, err)
+	}
+	return b
+}
+
+// readHostLimitsFile returns the limits listed in -host-limits.
+func readHostLimitsFile() retrodep.HostLimits {
+	r, err := os.Open(*hostLimitsFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+
+	limits, err := retrodep.ReadHostLimits(r)
+	if err != nil {
+		log.Fatalf("%s: %s", *hostLimitsFrom, err)
+	}
+	return limits
+}
+
+// readLocalReposFile returns the checkouts listed in -local-repos, or
+// nil.
+func readLocalReposFile() retrodep.LocalRepos {
+	if *localReposFrom == "" {
+		return nil
+	}
+
+	r, err := os.Open(*localReposFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+
+	repos, err := retrodep.ReadLocalRepos(r)
+	if err != nil {
+		log.Fatalf("%s: %s", *localReposFrom, err)
+	}
+	return repos
+}
+
+// readConfigFile returns the configuration in -config, or nil. The
+// tag prefixes, branches, credentials and excluded paths it gives
+// are added to those from other flags, which take precedence.
+func readConfigFile() *retrodep.Config {
+	credentialNames, hashExcludes, configHints, configForks = nil, nil, nil, nil
+	if *configFrom == "" {
+		return nil
+	}
+
+	r, err := os.Open(*configFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+
+	config, err := retrodep.ReadConfig(r)
+	if err != nil {
+		log.Fatalf("%s: %s", *configFrom, err)
+	}
+	tagPrefixes = mergePrefixMap(config.TagPrefixes(), tagPrefixes)
+	branches = mergePrefixMap(config.Branches(), branches)
+	credentialNames = config.Credentials()
+	configHints = config.Hints()
+	configForks = config.Forks()
+	hashExcludes, err = config.HashExcludes()
+	if err != nil {
+		log.Fatalf("%s: %s", *configFrom, err)
+	}
+	return config
+}
+
+// mergePrefixMap returns the entries of m with those of override
+// added, replacing any for the same import path prefixes.
+func mergePrefixMap(m, override map[string]string) map[string]string {
+	for prefix, value := range override {
+		m[prefix] = value
+	}
+	return m
+}
+
+func readRepoRootsFile() retrodep.StaticResolver {
+	if *repoRootsFrom == "" {
+		return nil
+	}
+
+	r, err := os.Open(*repoRootsFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
 
-// newWorkingTree creates a new retrodep.WorkingTree for the path.
-func newWorkingTree(path string, project *vcs.RepoRoot) (wt retrodep.WorkingTree, err error) {
-	wt, err = retrodep.NewWorkingTree(project)
+	roots, err := retrodep.ReadStaticResolver(r)
 	if err != nil {
-		log.Errorf("%s: %s, retrying", path, err)
-		wt, err = retrodep.NewWorkingTree(project)
+		log.Fatalf("%s: %s", *repoRootsFrom, err)
 	}
-	return
+	return roots
 }
 
-func showTopLevel(tmpl *template.Template, src *retrodep.GoSource) *retrodep.Reference {
-	var topLevelMarker string
-	if *templateArg != "" {
-		topLevelMarker = "*"
-	}
-	main := getProject(src, *importPath)
-	if main.Err != nil {
-		log.Errorf("%s: %s", *importPath, main.Err)
-		displayUnknown(tmpl, topLevelMarker, nil, main.Root)
+// readAlternatesFile returns the alternate repositories listed in
+// -alternates, or nil.
+func readAlternatesFile() retrodep.Alternates {
+	if *alternatesFrom == "" {
 		return nil
 	}
 
-	wt, err := newWorkingTree(src.Path, &main.RepoRoot)
+	r, err := os.Open(*alternatesFrom)
 	if err != nil {
-		log.Errorf("%s: %s", src.Path, err)
+		log.Fatal(err)
+	}
+	defer r.Close()
 
-		// Treat this as VersionNotFound.
-		project := &retrodep.Reference{
-			Pkg:  main.Root,
-			Repo: main.Repo,
-		}
-		displayUnknown(tmpl, topLevelMarker, project, main.Root)
-		return project
+	a, err := retrodep.ReadAlternates(r)
+	if err != nil {
+		log.Fatalf("%s: %s", *alternatesFrom, err)
 	}
+	return a
+}
 
-	defer wt.Close()
-	project, err := src.DescribeProject(main, wt, src.Path, nil)
-	switch err {
-	case retrodep.ErrorVersionNotFound:
-		displayUnknown(tmpl, topLevelMarker, project, main.Root)
-	case nil:
-		display(tmpl, topLevelMarker, project)
-	default:
-		log.Fatalf("%s: %s", src.Path, err)
+// readForgeTokens returns the tokens listed in -forge-tokens, one per
+// line, or the token in GITHUB_TOKEN.
+func readForgeTokens() []string {
+	if *forgeTokensFrom == "" {
+		if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+			return []string{token}
+		}
+		return nil
 	}
 
-	return project
+	data, err := ioutil.ReadFile(*forgeTokensFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var tokens []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			tokens = append(tokens, line)
+		}
+	}
+	return tokens
 }
 
-func showVendored(tmpl *template.Template, src *retrodep.GoSource, top *retrodep.Reference) {
-	vendored, err := src.VendoredProjects()
+func readGitConfigFile() {
+	if *gitConfigFrom == "" {
+		return
+	}
+
+	r, err := os.Open(*gitConfigFrom)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer r.Close()
 
-	// Sort the projects for predictable output
-	var repos []string
-	for repo := range vendored {
-		repos = append(repos, repo)
+	config, err := retrodep.ReadGitConfig(r)
+	if err != nil {
+		log.Fatalf("%s: %s", *gitConfigFrom, err)
 	}
-	sort.Strings(repos)
-
-	// Describe each vendored project
-	for _, repo := range repos {
-		project := vendored[repo]
-		if project.Err != nil {
-			log.Errorf("%s: %s", repo, project.Err)
-			ref := &retrodep.Reference{
-				TopPkg: top.Pkg,
-				TopVer: top.Ver,
-				Pkg:    repo,
-			}
-			displayUnknown(tmpl, "", ref, repo)
-			continue
+	for prefix, settings := range config {
+		if err := retrodep.AddGitConfig(prefix, settings...); err != nil {
+			log.Fatalf("%s: %s", *gitConfigFrom, err)
 		}
+	}
+}
 
-		wt, err := newWorkingTree(project.Root, &project.RepoRoot)
-		if err != nil {
-			log.Errorf("%s: %s", project.Root, err)
+// readHashExcludeFile sets the patterns for paths to leave out of
+// file hash comparisons from the file named by -hash-exclude-from.
+func readHashExcludeFile() {
+	if *hashExcludeFrom == "" {
+		return
+	}
 
-			// Treat this as VersionNotFound.
-			vp := &retrodep.Reference{
-				TopPkg: top.Pkg,
-				TopVer: top.Ver,
-				Pkg:    project.Root,
-				Repo:   project.Repo,
-			}
-			displayUnknown(tmpl, "", vp, project.Root)
-			continue
-		}
+	r, err := os.Open(*hashExcludeFrom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
 
-		defer wt.Close()
-		vp, err := src.DescribeVendoredProject(project, wt, top)
-		switch err {
-		case retrodep.ErrorVersionNotFound:
-			displayUnknown(tmpl, "", vp, project.Root)
-		case nil:
-			display(tmpl, "", vp)
-		default:
-			log.Fatalf("%s: %s", project.Root, err)
-		}
+	ignore, err := retrodep.ReadIgnore(r)
+	if err != nil {
+		log.Fatalf("%s: %s", *hashExcludeFrom, err)
 	}
+	retrodep.SetHashExcludes(ignore)
 }
 
-func readExcludeFile() []string {
-	if *excludeFrom == "" {
-		return nil
+// readCredentialsFile sets the host credentials listed in the
+// -credentials file, and the -netrc file to use.
+func readCredentialsFile() {
+	if *netrcFrom != "" {
+		retrodep.SetNetrcFile(*netrcFrom)
+	}
+	if *credentialsFrom == "" {
+		return
 	}
 
-	e, err := os.Open(*excludeFrom)
+	r, err := os.Open(*credentialsFrom)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer e.Close()
+	defer r.Close()
 
-	excludes := make([]string, 0)
-	scanner := bufio.NewScanner(bufio.NewReader(e))
-	for scanner.Scan() {
-		excludes = append(excludes, strings.TrimSpace(scanner.Text()))
+	config, err := retrodep.ReadCredentialsConfig(r)
+	if err != nil {
+		log.Fatalf("%s: %s", *credentialsFrom, err)
 	}
-	return excludes
+	retrodep.SetHostCredentials(config)
 }
 
 func processArgs(args []string) []*retrodep.GoSource {
@@ -225,13 +2993,32 @@ func processArgs(args []string) []*retrodep.GoSource {
 	cli.SetOutput(ioutil.Discard)
 	cli.Usage = func() {}
 
-	usageMsg := fmt.Sprintf("usage: %s [OPTION]... PATH", progName)
+	args = args[1:]
+	command = ""
+	if len(args) > 0 {
+		if _, ok := subcommands[args[0]]; ok {
+			command = args[0]
+			args = args[1:]
+		}
+	}
+
+	usageMsg := fmt.Sprintf("usage: %s [COMMAND] [OPTION]... PATH", progName)
 	usage = func(flaw string) {
 		log.Fatalf("%s: %s\n%s", progName, flaw, usageMsg)
 	}
-	err := cli.Parse(args[1:])
+	err := cli.Parse(args)
 	if err == flag.ErrHelp || *helpFlag { // Handle ‘-h’.
 		fmt.Printf("%s: help requested\n%s\n", progName, usageMsg)
+		var cmds []string
+		for cmd := range subcommands {
+			cmds = append(cmds, cmd)
+		}
+		sort.Strings(cmds)
+		fmt.Println("commands:")
+		for _, cmd := range cmds {
+			fmt.Printf("  %s\n    \t%s\n", cmd, subcommands[cmd])
+		}
+		fmt.Println("options:")
 		cli.SetOutput(os.Stdout)
 		flag.PrintDefaults()
 		os.Exit(0) // Not an error.
@@ -240,40 +3027,381 @@ func processArgs(args []string) []*retrodep.GoSource {
 		usage(err.Error())
 	}
 
+	npaths := 1
+	if comparing() {
+		npaths = 2
+	}
 	narg := flag.NArg()
-	if narg == 0 {
+	if narg < npaths {
 		usage("missing path")
 	}
-	if narg != 1 {
-		usage(fmt.Sprintf("only one path allowed: %q", flag.Arg(1)))
+	if narg != npaths {
+		usage(fmt.Sprintf("too many paths: %q", flag.Arg(npaths)))
 	}
 
+	if *progressFlag {
+		progress = &progressBar{w: os.Stderr}
+		retrodep.SetProgressReporter(progress)
+		logging.SetBackend(logging.NewLogBackend(progress, "", stdlog.LstdFlags))
+	}
 	level := logging.INFO
 	if *debugFlag {
 		level = logging.DEBUG
 	}
 	logging.SetLevel(level, "retrodep")
+	traceLevel := logging.INFO
+	if *traceFlag {
+		traceLevel = logging.DEBUG
+	}
+	logging.SetLevel(traceLevel, "retrodep.vcs")
 
-	excludeGlobs := readExcludeFile()
-	path := flag.Arg(0)
-	sources, err := retrodep.FindGoSources(path, excludeGlobs)
+	if *allowEnv != "" {
+		retrodep.AllowEnv(strings.Split(*allowEnv, ",")...)
+	}
+	if *hashAlgorithm != "" {
+		if _, err := retrodep.NewHasher(*hashAlgorithm); err != nil {
+			usage(fmt.Sprintf("-hash %s: %s", *hashAlgorithm, err))
+		}
+	}
+	if *normalizeFlag != "" {
+		algorithm := retrodep.NormalizedAlgorithm(retrodep.HashSHA256, normalizations())
+		if _, err := retrodep.NewHasher(algorithm); err != nil {
+			usage(fmt.Sprintf("-normalize %s: %s", *normalizeFlag, err))
+		}
+	}
+	readGitConfigFile()
+	readCredentialsFile()
+	readHashExcludeFile()
+	hashFiles, err := retrodep.ParseHashFiles(commaList(*hashFilesArg))
+	if err != nil {
+		usage("-hash-files: " + err.Error())
+	}
+	retrodep.SetHashFiles(hashFiles)
+	retrodep.SetForgeTokens(readForgeTokens()...)
+	retrodep.SetGitLabToken(os.Getenv("GITLAB_TOKEN"))
+	retrodep.SetForgeBudget(*forgeBudget)
+	if *statsFile != "" {
+		retrodep.EnableStats()
+	}
+	if *metricsFile != "" {
+		metrics = retrodep.NewPrometheusMetrics(nil)
+		retrodep.SetMetrics(metrics)
+	}
+	retrodep.SetConcurrency(retrodep.Concurrency{
+		Clones:  *cloneJobs,
+		Hashers: *hashJobs,
+	})
+	if *hostLimitsFrom != "" {
+		retrodep.SetHostLimits(readHostLimitsFile())
+	}
+	blocklist = readBlocklist()
+	policy = readPolicyFile()
+	alternates = readAlternatesFile()
+	tagPrefixes = readTagPrefixesFile()
+	branches = readBranchesFile()
+	localRepos = readLocalReposFile()
+	config := readConfigFile()
+	outputTemplate = readFormatFile()
+	if *offlineFlag {
+		retrodep.SetOffline()
+	}
+	revisionLimits = retrodep.RevisionLimits{
+		Since: parseDate("since", *sinceArg, false),
+		Until: parseDate("until", *untilArg, true),
+		Max:   *maxRevisions,
+	}
+	switch *preferTagsArg {
+	case "", retrodep.AnnotatedTags, retrodep.LightweightTags:
+	default:
+		usage("-prefer-tags: expected annotated or lightweight")
+	}
+	tagMatch = retrodep.TagMatch{
+		Match:      commaList(*tagMatchArg),
+		Exclude:    commaList(*tagExcludeArg),
+		Prefer:     *preferTagsArg,
+		SemverOnly: *semverTags,
+	}
+	if *modulePseudoVersions || *moduleVersionsFlag {
+		retrodep.SetModulePseudoVersions()
+	}
+	if *pseudoVersionTemplate != "" {
+		tmpl, err := template.New("pseudo-version").Parse(*pseudoVersionTemplate)
+		if err != nil {
+			log.Fatalf("-pseudo-version-template: %s", err)
+		}
+		retrodep.SetPseudoVersionFormatter(retrodep.PseudoVersionTemplate(tmpl))
+	}
+	if config != nil {
+		retrodep.DefaultResolver = retrodep.ChainResolver{
+			config.RepoRoots(),
+			retrodep.DefaultResolver,
+		}
+	}
+	if roots := readRepoRootsFile(); roots != nil {
+		retrodep.DefaultResolver = retrodep.ChainResolver{
+			roots,
+			retrodep.DefaultResolver,
+		}
+	}
+	if *tempDirArg != "" {
+		retrodep.SetTempDir(*tempDirArg)
+	}
+	if *hashCacheDir != "" {
+		retrodep.SetDiskCache(retrodep.NewDiskCache(*hashCacheDir))
+	}
+	if *sharedCacheURL != "" {
+		c := retrodep.NewRemoteCache(*sharedCacheURL)
+		if key := readSharedCacheKey(); key != nil {
+			c.SetKey(key)
+		}
+		retrodep.SetRemoteCache(c)
+	} else if *sharedCacheKey != "" && command != "serve-cache" {
+		usage("-shared-cache-key applies to -shared-cache and serve-cache")
+	}
+	if *sumDBURL != "" {
+		sumDB = retrodep.NewSumDB(*sumDBURL)
+	}
+	if *osvSource != "" {
+		vulnDB = openVulnerabilityDB(*osvSource)
+	}
+	if command == "batch" {
+		if *diffArg != "" || *dryRun || *onlyImportPath || *modulesTxtFile != "" {
+			usage("batch: -diff, -dry-run, -modules-txt and -only-importpath apply to a single project")
+		}
+		if (*outputArg != "" && *outputArg != "json") || outputTemplate != nil {
+			usage("batch: the report is written as JSON")
+		}
+		if *importPath != "" {
+			usage("batch: give import paths in the batch file")
+		}
+	}
+	if *grpcCert != "" || *grpcKey != "" || *grpcClientCA != "" {
+		if *grpcAddr == "" {
+			usage("-grpc-cert, -grpc-key and -grpc-client-ca apply to -grpc")
+		}
+		if *grpcCert == "" || *grpcKey == "" {
+			usage("-grpc-cert and -grpc-key must be given together")
+		}
+	}
+	if *grpcAddr != "" && *grpcClientCA == "" && !loopbackAddr(*grpcAddr) {
+		usage("-grpc: gRPC clients are not authenticated without -grpc-client-ca, so listen on a loopback address such as localhost:8782")
+	}
+	if command == "index" {
+		if *indexFrom == "" {
+			usage("index: give the index file with -index")
+		}
+	} else if *indexFilesArg != "" {
+		usage("-index-files applies to index")
+	} else if *indexFrom != "" {
+		fingerprintIndex = readFingerprintIndex()
+	}
+	if comparing() || command == "batch" || command == "index" || command == "serve" || command == "serve-cache" || command == "verify-modules" {
+		// The arguments are examined by compareTrees,
+		// driftRefs, scanBatch, indexRepos, serveScans,
+		// serveCache or verifyModuleZips.
+		return nil
+	}
+	return findSources(flag.Arg(0))
+}
+
+// readSharedCacheKey returns the key in -shared-cache-key, if given.
+func readSharedCacheKey() []byte {
+	if *sharedCacheKey == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(*sharedCacheKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) == 0 {
+		log.Fatalf("%s: no key", *sharedCacheKey)
+	}
+	return key
+}
+
+// serveCache serves the shared file hashes cache stored in dir at
+// the -listen address, until it fails. Entries are only stored if
+// signed with the key in -shared-cache-key.
+func serveCache(dir string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	key := readSharedCacheKey()
+	if key == nil {
+		log.Infof("serving %s read-only on %s", dir, *listenAddr)
+	} else {
+		log.Infof("serving %s on %s", dir, *listenAddr)
+	}
+	log.Fatal(http.ListenAndServe(*listenAddr, retrodep.NewCacheServer(dir, key)))
+}
+
+// loopbackAddr reports whether addr, a host and port to listen on,
+// can only be reached from this machine.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// grpcServerOptions returns the options for the gRPC server: TLS with
+// the -grpc-cert and -grpc-key, requiring client certificates signed
+// by the CAs in -grpc-client-ca, if given.
+func grpcServerOptions() []grpc.ServerOption {
+	if *grpcCert == "" {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(*grpcCert, *grpcKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if *grpcClientCA != "" {
+		data, err := ioutil.ReadFile(*grpcClientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			log.Fatalf("%s: no certificates", *grpcClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(config))}
+}
+
+// serveScans runs the scans requested at the -listen address,
+// unpacking uploaded sources in dir, until it fails.
+func serveScans(dir string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	if *grpcAddr != "" {
+		l, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g := grpc.NewServer(grpcServerOptions()...)
+		scanpb.RegisterScannerServer(g, retrodep.NewScanService(treeOptions()...))
+		log.Infof("accepting gRPC scans on %s", *grpcAddr)
+		go func() { log.Fatal(g.Serve(l)) }()
+	}
+	log.Infof("accepting scans on %s", *listenAddr)
+	s := retrodep.NewScanServer(context.Background(), dir, *scanJobs, treeOptions()...)
+	log.Fatal(http.ListenAndServe(*listenAddr, s))
+}
+
+// findSources returns the Go sources found at path, exiting if there
+// are none.
+func findSources(path string) []*retrodep.GoSource {
+	sources, err := goSources(path)
 	if err != nil {
 		if err == retrodep.ErrorNoGo {
 			fmt.Fprintf(os.Stderr,
 				"%s: no Go source code at %s\n",
-				progName, path)
+				filepath.Base(os.Args[0]), path)
 			os.Exit(4)
 		}
 
 		log.Fatal(err)
 	}
-
 	return sources
 }
 
+// extractedSources are the directories source archives were
+// unpacked in, to be removed by removeExtractedSources.
+var extractedSources []string
+
+// sourceDir returns path or, if it is a source archive such as a
+// release tarball, the directory it has been unpacked in.
+func sourceDir(path string) (string, error) {
+	if !retrodep.IsSourceArchive(path) {
+		return path, nil
+	}
+	dir, err := ioutil.TempDir(*tempDirArg, "retrodep-source.")
+	if err != nil {
+		return "", err
+	}
+	extractedSources = append(extractedSources, dir)
+	log.Debugf("unpacking %s in %s", path, dir)
+	return retrodep.ExtractSourceArchive(path, dir)
+}
+
+// removeExtractedSources removes the directories source archives
+// were unpacked in.
+func removeExtractedSources() {
+	for _, dir := range extractedSources {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Error(err)
+		}
+	}
+	extractedSources = nil
+}
+
+// goSources returns the Go sources found at path, which may be a
+// source archive, configured by the options given.
+func goSources(path string) ([]*retrodep.GoSource, error) {
+	dir, err := sourceDir(path)
+	if err != nil {
+		return nil, err
+	}
+	excludeGlobs := readExcludeFile()
+	sources, err := retrodep.FindGoSources(dir, excludeGlobs)
+	if err != nil {
+		return nil, err
+	}
+
+	if hints := readHintsFile(); hints != nil {
+		for _, src := range sources {
+			src.AddHints(hints)
+		}
+	}
+	if configHints != nil {
+		for _, src := range sources {
+			src.AddHints(configHints)
+		}
+	}
+	if configForks != nil {
+		for _, src := range sources {
+			src.AddForks(configForks)
+		}
+	}
+	if chooser := tagChooser(); chooser != nil {
+		for _, src := range sources {
+			src.SetTagChooser(chooser)
+		}
+	}
+	if hashExcludes != nil {
+		for _, src := range sources {
+			src.AddHashExcludes(hashExcludes)
+		}
+	}
+	if fingerprintIndex != nil {
+		for _, src := range sources {
+			src.SetFingerprintIndex(fingerprintIndex)
+		}
+	}
+	if relocations := readRelocationsFile(); relocations != nil {
+		for _, src := range sources {
+			src.AddRelocations(relocations)
+		}
+	}
+
+	return sources, nil
+}
+
 func getTemplate() string {
 	var customTemplate string
 	switch {
+	case reporting() || porcelain():
+		// No template is used for these output formats.
 	case *outputArg != "":
 		customTemplate = strings.TrimPrefix(*outputArg, "go-template=")
 		if customTemplate == *outputArg {
@@ -290,13 +3418,61 @@ func getTemplate() string {
 }
 
 func main() {
+	// Import path discovery uses http.DefaultClient.
+	http.DefaultClient.Transport = retrodep.NewCredentialsTransport(http.DefaultTransport)
+
 	srcs := processArgs(os.Args)
+	defer removeExtractedSources()
+	if command == "serve" {
+		serveScans(flag.Arg(0))
+		return
+	}
+	if command == "serve-cache" {
+		serveCache(flag.Arg(0))
+		return
+	}
+	if command == "index" {
+		indexRepos(flag.Arg(0))
+		return
+	}
+	if command == "verify-modules" {
+		if verifyModuleZips(flag.Arg(0)) {
+			os.Exit(6)
+		}
+		return
+	}
+	if command == "check" {
+		if checkLock(srcs) {
+			os.Exit(6)
+		}
+		return
+	}
+	if command == "verify" {
+		if verifyAttestation(srcs) {
+			os.Exit(6)
+		}
+		return
+	}
 
 	customTemplate := getTemplate()
 	tmpl, err := template.New("output").Parse(customTemplate)
 	if err != nil {
 		log.Fatal(err)
 	}
+	switch command {
+	case "compare":
+		compareTrees(tmpl, flag.Arg(0), flag.Arg(1))
+		return
+	case "drift":
+		driftRefs(tmpl, flag.Arg(0), flag.Arg(1))
+		return
+	case "batch":
+		scanBatch(tmpl, flag.Arg(0))
+	}
+	if reporting() && command != "batch" {
+		report = retrodep.NewReport()
+	}
+	lock := &retrodep.Lock{}
 	changes := false
 	for _, src := range srcs {
 		if *diffArg != "" {
@@ -308,28 +3484,53 @@ func main() {
 			}
 			defer wt.Close()
 
-			c, err := src.Diff(main, wt, os.Stdout, src.Path, *diffArg)
+			out := retrodep.NewDiffWriter(os.Stdout, diffStyle.DiffStyle)
+			c, err := src.Diff(main, wt, out, src.Path, *diffArg)
 			if err != nil {
 				log.Fatal(err)
 			}
+			if err := out.Close(); err != nil {
+				log.Fatal(err)
+			}
 
 			changes = changes || c
+		} else if *dryRun {
+			showPlan(src)
 		} else if *onlyImportPath {
 			main := getProject(src, *importPath)
 			fmt.Println("*" + main.Root)
 		} else {
-			top := showTopLevel(tmpl, src)
-			if *depsFlag {
-				showVendored(tmpl, src, top)
+			refs := describe(tmpl, src)
+			if command == "lock" {
+				addLockEntries(lock, src, refs)
+			}
+			if *modulesTxtFile != "" {
+				addVendoredModules(src, refs)
 			}
 		}
 	}
 
+	if command == "lock" {
+		writeLock(lock)
+	}
+	if *noticeFile != "" && *diffArg == "" && !*dryRun && !*onlyImportPath {
+		writeNotices()
+	}
+	if *modulesTxtFile != "" && *diffArg == "" && !*dryRun && !*onlyImportPath {
+		writeModulesTxt()
+	}
+	saveChosenTags()
+	writeReport()
+	writeStats()
+	writeMetrics()
+	removeExtractedSources()
+	if len(assertions) > 0 && *diffArg == "" && !*dryRun && !*onlyImportPath {
+		checkAssertionsSeen()
+	}
 	if errorShown {
-		os.Exit(2)
+		writeStrict()
 	}
-
-	if *diffArg != "" && changes {
-		os.Exit(5)
+	if code := exitCode(*diffArg != "" && changes); code != 0 {
+		os.Exit(code)
 	}
 }
@@ -16,36 +16,28 @@
 package main
 
 import (
+	"bytes"
 	"io"
 	"io/ioutil"
 	"os"
-	"syscall"
+	"strings"
 	"testing"
 
 	"github.com/release-engineering/retrodep/v2/retrodep"
+	"golang.org/x/tools/go/vcs"
 )
 
 func captureStdout(t *testing.T) (r io.Reader, reset func()) {
-	stdout := int(os.Stdout.Fd())
-	orig, err := syscall.Dup(stdout)
-	if err != nil {
-		t.Fatal(err)
-	}
+	orig := os.Stdout
 	r, w, err := os.Pipe()
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = syscall.Dup2(int(w.Fd()), stdout)
-	if err != nil {
-		t.Fatal(err)
-	}
+	os.Stdout = w
 
 	reset = func() {
 		w.Close()
-		err := syscall.Dup2(orig, stdout)
-		if err != nil {
-			t.Fatal(err)
-		}
+		os.Stdout = orig
 	}
 
 	return
@@ -92,6 +84,80 @@ func TestDisplayUnknown(t *testing.T) {
 	}
 }
 
+func TestDisplayPorcelain(t *testing.T) {
+	defer func() {
+		*outputArg = ""
+		porcelainOut = os.Stdout
+	}()
+	*outputArg = "porcelain"
+	var buf strings.Builder
+	porcelainOut = &buf
+
+	display(nil, "*", &retrodep.Reference{
+		Pkg:  "example.com/foo",
+		Repo: "https://example.com/foo",
+		Tag:  "v1.0.0",
+		Rev:  "abc",
+		Ver:  "v1.0.0",
+	})
+	display(nil, "", &retrodep.Reference{
+		TopPkg: "example.com/foo",
+		Pkg:    "example.com/bar",
+		Rev:    "def",
+		Ver:    "v0.0.0-20190101000000-def",
+	})
+	displayUnknown(nil, "", nil, "example.com/baz")
+	expected := "top\texample.com/foo\tv1.0.0\tv1.0.0\tabc\thttps://example.com/foo\t-\n" +
+		"vendored\texample.com/bar\tv0.0.0-20190101000000-def\t-\tdef\t-\texample.com/foo\n" +
+		"top\texample.com/baz\t?\t-\t-\t-\t-\n"
+	if buf.String() != expected {
+		t.Errorf("got %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestSubcommand(t *testing.T) {
+	tcs := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"retrodep", "."}, ""},
+		{[]string{"retrodep", "lock", "."}, "lock"},
+		{[]string{"retrodep", "check", "-lock", "x.lock", "."}, "check"},
+		{[]string{"retrodep", "compare", ".", "."}, "compare"},
+		{[]string{"retrodep", "drift", "v1.0.0", "v1.1.0"}, "drift"},
+		{[]string{"retrodep", "batch", "projects.txt"}, "batch"},
+	}
+
+	for _, tc := range tcs {
+		processArgs(tc.args)
+		if command != tc.expected {
+			t.Errorf("%v: got %q, want %q", tc.args, command, tc.expected)
+		}
+	}
+}
+
+func TestLoopbackAddr(t *testing.T) {
+	tcs := []struct {
+		addr     string
+		expected bool
+	}{
+		{"localhost:8782", true},
+		{"127.0.0.1:8782", true},
+		{"[::1]:8782", true},
+		{":8782", false},
+		{"0.0.0.0:8782", false},
+		{"192.0.2.1:8782", false},
+		{"scanner.example.com:8782", false},
+		{"localhost", false},
+	}
+
+	for _, tc := range tcs {
+		if got := loopbackAddr(tc.addr); got != tc.expected {
+			t.Errorf("%s: got %v, want %v", tc.addr, got, tc.expected)
+		}
+	}
+}
+
 func TestGetTemplate(t *testing.T) {
 	tcs := []struct {
 		name     string
@@ -108,6 +174,16 @@ func TestGetTemplate(t *testing.T) {
 			[]string{"retrodep", "-o", "go-template={{.Pkg}}", "."},
 			"{{.Pkg}}",
 		},
+		{
+			"json",
+			[]string{"retrodep", "-o", "json", "."},
+			"",
+		},
+		{
+			"porcelain",
+			[]string{"retrodep", "-o", "porcelain", "."},
+			"",
+		},
 		{
 			"compatibility",
 			[]string{"retrodep", "-template", "@{{.Rev}}", "."},
@@ -132,3 +208,187 @@ func TestGetTemplate(t *testing.T) {
 		})
 	}
 }
+
+func TestProgressBar(t *testing.T) {
+	var out strings.Builder
+	b := &progressBar{w: &out}
+	b.CloneStarted("https://example.com/foo")
+	if exp := "\rcloned 0 (1 cloning), tested 0/0 refs, hashed 0 files\x1b[K"; out.String() != exp {
+		t.Errorf("got %q, want %q", out.String(), exp)
+	}
+
+	// Updates soon after are not drawn.
+	b.CloneFinished("https://example.com/foo", nil)
+	b.RevisionsQueued(10)
+	b.RevisionTested("v1.0.0")
+	b.FilesHashed(20)
+	out.Reset()
+	b.hide()
+	if exp := "\r\x1b[K"; out.String() != exp {
+		t.Errorf("got %q, want %q", out.String(), exp)
+	}
+
+	out.Reset()
+	b.FilesHashed(1)
+	b.Write([]byte("message\n"))
+	if exp := "\rcloned 1, tested 1/10 refs, hashed 21 files\x1b[K" +
+		"\r\x1b[Kmessage\n" +
+		"\rcloned 1, tested 1/10 refs, hashed 21 files\x1b[K"; out.String() != exp {
+		t.Errorf("got %q, want %q", out.String(), exp)
+	}
+}
+
+func TestMissingExitCode(t *testing.T) {
+	defer func() {
+		upstreamFailure = 0
+		failureReasons = make(map[string]string)
+	}()
+	tcs := []struct {
+		errs     []error
+		expected int
+	}{
+		{nil, 2},
+		{[]error{retrodep.ErrorUnreachable}, 12},
+		{[]error{&retrodep.CloneError{Kind: retrodep.ErrorAuthRequired, Err: io.EOF}}, 13},
+		{[]error{retrodep.ErrorUnknownVCS, retrodep.ErrorUnreachable}, 14},
+	}
+	for _, tc := range tcs {
+		upstreamFailure = 0
+		for _, err := range tc.errs {
+			noteUpstreamFailure("example.com/a", err)
+		}
+		if code := missingExitCode(); code != tc.expected {
+			t.Errorf("%v: got %d, want %d", tc.errs, code, tc.expected)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	reset := func() {
+		assertionFailed = false
+		blockedFound = false
+		policyViolated = false
+		moduleHashMismatch = false
+		unverifiedTag = false
+		vulnerable = false
+		errorShown = false
+	}
+	defer reset()
+
+	// Each problem in order of precedence, with its exit code.
+	problems := []struct {
+		flag *bool
+		code int
+	}{
+		{&assertionFailed, 7},
+		{&blockedFound, 8},
+		{&policyViolated, 9},
+		{&moduleHashMismatch, 10},
+		{&unverifiedTag, 11},
+		{&vulnerable, 16},
+		{&errorShown, 2},
+	}
+	reset()
+	if code := exitCode(false); code != 0 {
+		t.Errorf("no problems: got %d", code)
+	}
+	if code := exitCode(true); code != 5 {
+		t.Errorf("-diff changes: got %d", code)
+	}
+	for i, p := range problems {
+		// With this problem and every one after it, and
+		// changes found by -diff, this problem decides.
+		reset()
+		for _, later := range problems[i:] {
+			*later.flag = true
+		}
+		if code := exitCode(true); code != p.code {
+			t.Errorf("from %d: got %d", p.code, code)
+		}
+	}
+
+	// A failed assertion is not masked by a missing version.
+	reset()
+	assertionFailed = true
+	errorShown = true
+	if code := exitCode(false); code != 7 {
+		t.Errorf("assertion and missing version: got %d", code)
+	}
+}
+
+func TestStrictSummary(t *testing.T) {
+	defer func() {
+		unmatched = nil
+		failureReasons = make(map[string]string)
+		upstreamFailure = 0
+	}()
+	noteUpstreamFailure("example.com/b", retrodep.ErrorAuthRequired)
+	unmatched = []*retrodep.Reference{
+		{TopPkg: "example.com/top", Pkg: "example.com/a", Repo: "https://example.com/a"},
+		{TopPkg: "example.com/top", Pkg: "example.com/b"},
+	}
+	var buf bytes.Buffer
+	if err := writeStrictSummary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	exp := `{
+  "unmatched": [
+    {
+      "pkg": "example.com/a",
+      "topPkg": "example.com/top",
+      "repo": "https://example.com/a",
+      "reason": "version-not-found"
+    },
+    {
+      "pkg": "example.com/b",
+      "topPkg": "example.com/top",
+      "reason": "auth-required"
+    }
+  ]
+}
+`
+	if buf.String() != exp {
+		t.Errorf("got %s", buf.String())
+	}
+}
+
+func TestPromptTag(t *testing.T) {
+	defer func() { chosenTags = make(map[string]string) }()
+	oldest, err := retrodep.TagPolicy(retrodep.PreferOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := []string{"v1.2.1", "v1.2.0"}
+
+	var out bytes.Buffer
+	in := strings.NewReader("v9\n1\n\n")
+	prompt := promptTag(oldest, in, &out)
+	tag, err := prompt(&retrodep.RepoPath{RepoRoot: vcs.RepoRoot{Root: "example.com/a"}}, tags)
+	if err != nil || tag != "v1.2.1" {
+		t.Errorf("by number: got %q, %v", tag, err)
+	}
+	if !strings.Contains(out.String(), `"v9" is not one of the tags`) {
+		t.Errorf("no complaint about v9:\n%s", out.String())
+	}
+	tag, err = prompt(&retrodep.RepoPath{RepoRoot: vcs.RepoRoot{Root: "example.com/b"}}, tags)
+	if err != nil || tag != "v1.2.0" {
+		t.Errorf("default: got %q, %v", tag, err)
+	}
+	tag, err = prompt(&retrodep.RepoPath{RepoRoot: vcs.RepoRoot{Root: "example.com/c"}}, tags)
+	if err != nil || tag != "v1.2.0" {
+		t.Errorf("end of input: got %q, %v", tag, err)
+	}
+
+	exp := map[string]string{
+		"example.com/a": "v1.2.1",
+		"example.com/b": "v1.2.0",
+	}
+	if len(chosenTags) != len(exp) {
+		t.Errorf("chosen: got %v, want %v", chosenTags, exp)
+	}
+	for importPath, tag := range exp {
+		if chosenTags[importPath] != tag {
+			t.Errorf("chosen: got %v, want %v", chosenTags, exp)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// Alternates maps import path prefixes to other repositories, such
+// as forks or mirrors, which may hold the vendored copies of the
+// projects they match, for when no version in the canonical upstream
+// repository matches. The repositories for the prefix "*" are
+// candidates for every project. In repository URLs, "{root}" stands
+// for the project's import path.
+type Alternates map[string][]*vcs.RepoRoot
+
+// ReadAlternates parses an alternate repositories file from r. Each
+// line has an import path prefix (or "*"), a VCS command (such as
+// "git") and a repository URL, separated by whitespace. A prefix may
+// be listed more than once. Blank lines and lines starting with "#"
+// are ignored.
+func ReadAlternates(r io.Reader) (Alternates, error) {
+	a := make(Alternates)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		v := vcsByCmd(fields[1])
+		if v == nil {
+			return nil, fmt.Errorf("%s: %s: %s", fields[0], ErrorUnknownVCS, fields[1])
+		}
+		a[fields[0]] = append(a[fields[0]], &vcs.RepoRoot{
+			VCS:  v,
+			Repo: fields[2],
+			Root: fields[0],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// For returns the alternate repositories for the project at root,
+// in the order they should be tried: those for the longest matching
+// prefix first and those for "*" last. Repositories which are the
+// same as root's are left out. The Root of each is root.Root.
+func (a Alternates) For(root *vcs.RepoRoot) []*vcs.RepoRoot {
+	var prefixes []string
+	for prefix := range a {
+		if prefix != "*" && (root.Root == prefix || strings.HasPrefix(root.Root, prefix+"/")) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(prefixes[i]) > len(prefixes[j])
+	})
+	prefixes = append(prefixes, "*")
+
+	var alternates []*vcs.RepoRoot
+	for _, prefix := range prefixes {
+		for _, alt := range a[prefix] {
+			repo := strings.Replace(alt.Repo, "{root}", root.Root, -1)
+			if SameRepo(repo, root.Repo) {
+				continue
+			}
+			alternates = append(alternates, &vcs.RepoRoot{
+				VCS:  alt.VCS,
+				Repo: repo,
+				Root: root.Root,
+			})
+		}
+	}
+	return alternates
+}
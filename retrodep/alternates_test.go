@@ -0,0 +1,65 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestAlternates(t *testing.T) {
+	a, err := ReadAlternates(strings.NewReader(`# comment
+*                  git https://mirror.example.com/{root}
+github.com/foo     git https://github.com/corp/foo-all
+github.com/foo/bar git https://github.com/corp/bar
+github.com/foo/bar hg  https://hg.example.com/bar
+github.com/foo/bar git https://GitHub.com/foo/bar.git
+
+incomplete
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := &vcs.RepoRoot{VCS: vcs.ByCmd(vcsGit), Repo: "https://github.com/foo/bar", Root: "github.com/foo/bar"}
+	var repos []string
+	for _, alt := range a.For(root) {
+		if alt.Root != root.Root {
+			t.Errorf("%s: root %s", alt.Repo, alt.Root)
+		}
+		repos = append(repos, alt.VCS.Cmd+" "+alt.Repo)
+	}
+	expected := []string{
+		"git https://github.com/corp/bar",
+		"hg https://hg.example.com/bar",
+		"git https://github.com/corp/foo-all",
+		"git https://mirror.example.com/github.com/foo/bar",
+	}
+	if strings.Join(repos, ",") != strings.Join(expected, ",") {
+		t.Errorf("got %v, want %v", repos, expected)
+	}
+
+	other := &vcs.RepoRoot{VCS: vcs.ByCmd(vcsGit), Repo: "https://github.com/foo/barn", Root: "github.com/foo/barn"}
+	if alts := a.For(other); len(alts) != 2 {
+		t.Errorf("github.com/foo/barn: got %v", alts)
+	}
+
+	if _, err := ReadAlternates(strings.NewReader("example.com/x cvs https://example.com/x")); err == nil {
+		t.Error("unknown VCS accepted")
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+// This file contains helpers for working trees which read the files
+// for each ref from an archive rather than from a clone.
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// extractFunc writes the files for a ref to dir.
+type extractFunc func(dir string) error
+
+// extractedFileHashes returns the hashes, using hasher, of the files
+// within subPath written by extract.
+func extractedFileHashes(env *scanEnv, hasher Hasher, extract extractFunc, subPath string) (FileHashes, error) {
+	dir, err := env.tempDir("retrodep.")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := extract(dir); err != nil {
+		return nil, err
+	}
+	return newFileHashes(env, hasher, filepath.Join(dir, subPath), nil)
+}
+
+// extractedGrep searches the files within subPath written by extract
+// for pattern.
+func extractedGrep(env *scanEnv, extract extractFunc, pattern, subPath string) ([]GrepMatch, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := env.tempDir("retrodep.")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := extract(dir); err != nil {
+		return nil, err
+	}
+	return grepFiles(filepath.Join(dir, subPath), re)
+}
+
+// extractedSync replaces the files in dir with those written by
+// extract.
+func extractedSync(dir string, extract extractFunc) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return err
+	}
+	return extract(dir)
+}
+
+// extractTarball writes the regular files and symbolic links in the
+// gzipped tar archive read from r to dir, without the top-level
+// directory forges put them in. Other entries are skipped.
+func extractTarball(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	links := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		isLink := hdr.Typeflag == tar.TypeSymlink
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA && !isLink {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		slash := strings.Index(name, "/")
+		if slash < 0 {
+			continue
+		}
+		rel := name[slash+1:]
+		if rel == ".." || strings.HasPrefix(rel, "../") || path.IsAbs(rel) ||
+			throughLink(links, rel) {
+			return fmt.Errorf("invalid file name %s", hdr.Name)
+		}
+		dest := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if isLink {
+			// The link is kept to be hashed, but nothing is
+			// written through it.
+			links[rel] = true
+			if err := os.Symlink(hdr.Linkname, dest); err != nil {
+				return err
+			}
+			continue
+		}
+		w, err := os.Create(dest)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, tr); err != nil {
+			w.Close()
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+}
+
+// throughLink returns true if rel, a slash-separated path, is one of
+// links or is below one of them.
+func throughLink(links map[string]bool, rel string) bool {
+	for p := rel; p != "."; p = path.Dir(p) {
+		if links[p] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+// assertionOps are the comparison operators understood by
+// ParseAssertion. Longer operators must come first.
+var assertionOps = []string{">=", "<=", "!=", "=", ">", "<"}
+
+// Assertion is a requirement that the version found for a project
+// compares in a particular way with a given version.
+type Assertion struct {
+	// Pkg is the import path of the project.
+	Pkg string
+
+	// Op is one of "=", "!=", ">", ">=", "<", "<=".
+	Op string
+
+	// Version is a semantic version, a pseudo-version, or an
+	// upstream revision.
+	Version string
+}
+
+// ParseAssertion parses an assertion of the form "PKG OP VERSION",
+// for example "golang.org/x/crypto>=v0.0.0-0.20190308221718-c2843e01d9a2".
+func ParseAssertion(s string) (*Assertion, error) {
+	for _, op := range assertionOps {
+		i := strings.Index(s, op)
+		if i == -1 {
+			continue
+		}
+		a := &Assertion{
+			Pkg:     strings.TrimSpace(s[:i]),
+			Op:      op,
+			Version: strings.TrimSpace(s[i+len(op):]),
+		}
+		if a.Pkg == "" || a.Version == "" {
+			break
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("invalid assertion %q", s)
+}
+
+func (a *Assertion) String() string {
+	return a.Pkg + a.Op + a.Version
+}
+
+// AssertionError describes a project whose version does not satisfy
+// an Assertion.
+type AssertionError struct {
+	Assertion *Assertion
+
+	// Ver is the version found, or "" if none was found.
+	Ver string
+}
+
+func (e *AssertionError) Error() string {
+	found := e.Ver
+	if found == "" {
+		found = "no version"
+	}
+	return fmt.Sprintf("%s: found %s, expected %s %s",
+		e.Assertion.Pkg, found, e.Assertion.Op, e.Assertion.Version)
+}
+
+// Check returns an *AssertionError if ref does not satisfy the
+// assertion. If the asserted version is not a semantic version it is
+// taken to be a revision, and d (normally the project's WorkingTree)
+// is used to find its PseudoVersion for comparison.
+func (a *Assertion) Check(ref *Reference, d Describable) error {
+	if ref.Ver == "" {
+		return &AssertionError{Assertion: a}
+	}
+
+	want, err := semver.NewVersion(a.Version)
+	if err != nil {
+		if ref.Rev != "" && strings.HasPrefix(ref.Rev, a.Version) {
+			// Same revision
+			want, err = semver.NewVersion(ref.Ver)
+		} else {
+			var pseudo string
+			pseudo, err = PseudoVersion(d, a.Version)
+			if err != nil {
+				return err
+			}
+			want, err = semver.NewVersion(pseudo)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	have, err := semver.NewVersion(ref.Ver)
+	if err != nil {
+		return &AssertionError{Assertion: a, Ver: ref.Ver}
+	}
+
+	cmp := have.Compare(want)
+	var ok bool
+	switch a.Op {
+	case "=":
+		ok = cmp == 0
+	case "!=":
+		ok = cmp != 0
+	case ">":
+		ok = cmp > 0
+	case ">=":
+		ok = cmp >= 0
+	case "<":
+		ok = cmp < 0
+	case "<=":
+		ok = cmp <= 0
+	}
+	if !ok {
+		return &AssertionError{Assertion: a, Ver: ref.Ver}
+	}
+	return nil
+}
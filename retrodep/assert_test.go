@@ -0,0 +1,91 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAssertion(t *testing.T) {
+	tcs := []struct {
+		s     string
+		exp   Assertion
+		expOk bool
+	}{
+		{"example.com/foo>=v1.2.0", Assertion{"example.com/foo", ">=", "v1.2.0"}, true},
+		{"example.com/foo = v1.2.0", Assertion{"example.com/foo", "=", "v1.2.0"}, true},
+		{"example.com/foo!=v1.2.0", Assertion{"example.com/foo", "!=", "v1.2.0"}, true},
+		{"example.com/foo<abcdef", Assertion{"example.com/foo", "<", "abcdef"}, true},
+		{"example.com/foo", Assertion{}, false},
+		{">=v1.2.0", Assertion{}, false},
+	}
+	for _, tc := range tcs {
+		a, err := ParseAssertion(tc.s)
+		if (err == nil) != tc.expOk {
+			t.Errorf("%q: got %v, want ok:%t", tc.s, err, tc.expOk)
+			continue
+		}
+		if err == nil && *a != tc.exp {
+			t.Errorf("%q: got %v, want %v", tc.s, *a, tc.exp)
+		}
+	}
+}
+
+func TestAssertionCheck(t *testing.T) {
+	rev := "d4c3dbfa77a74ae238e401d5d2197b45f30d8513"
+	d := &mockDescribable{
+		t:    t,
+		rev:  rev,
+		tag:  "v1.2.0",
+		time: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+	}
+
+	tcs := []struct {
+		assertion string
+		ver       string
+		expOk     bool
+	}{
+		{"p>=v1.2.0", "v1.2.0", true},
+		{"p>v1.2.0", "v1.2.0", false},
+		{"p=v1.2.0", "v1.3.0", false},
+		{"p<v1.3.0", "v1.2.1-0.20050102150405-0123456789ab", true},
+		{"p>=v1.2.0", "", false},
+
+		// The revision has pseudo-version
+		// v1.2.1-0.20060102150405-d4c3dbfa77a7
+		{"p>=" + rev, "v1.2.1", true},
+		{"p>=" + rev, "v1.2.0", false},
+	}
+	for _, tc := range tcs {
+		a, err := ParseAssertion(tc.assertion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = a.Check(&Reference{Pkg: "p", Ver: tc.ver}, d)
+		if err != nil {
+			if _, ok := err.(*AssertionError); !ok {
+				t.Errorf("%s with %q: unexpected error %v",
+					tc.assertion, tc.ver, err)
+				continue
+			}
+		}
+		if (err == nil) != tc.expOk {
+			t.Errorf("%s with %q: got %v, want ok:%t",
+				tc.assertion, tc.ver, err, tc.expOk)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Asset is a vendored file which is not part of any vendored
+// project, for example a .proto file or a script copied from another
+// repository, and the upstream file found to match it, if any.
+type Asset struct {
+	// Path is the vendored file, relative to the top-level
+	// project, using forward slashes.
+	Path string `json:"path"`
+
+	// Pkg is the vendored project whose upstream repository has a
+	// matching file, or "" if none was found.
+	Pkg string `json:"pkg,omitempty"`
+
+	// Repo is the URL of that repository.
+	Repo string `json:"repo,omitempty"`
+
+	// Rev is the revision of that repository with the matching
+	// file, and Ver is its version.
+	Rev string `json:"rev,omitempty"`
+	Ver string `json:"ver,omitempty"`
+
+	// File is the matching file, relative to the repository root.
+	File string `json:"file,omitempty"`
+}
+
+// vendorManifests are files in the vendor directory written by
+// dependency managers, which are not assets.
+var vendorManifests = map[string]struct{}{
+	"modules.txt": {},
+	"vendor.json": {},
+	"manifest":    {},
+}
+
+// Assets returns the files in the vendor directory which are not
+// within any of the vendored projects, as returned by
+// VendoredProjects. Go source files and files whose names begin
+// with "." are ignored, as are the directories of modules a
+// workspace module does not require.
+func (src GoSource) Assets(vendored map[string]*RepoPath) ([]*Asset, error) {
+	vendor := src.Vendor()
+	if _, err := os.Stat(vendor); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var assets []*Asset
+	walkfn := func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if _, skip := src.excludes[pth]; skip {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(vendor, pth)
+		if err != nil {
+			return err
+		}
+		importPath := filepath.ToSlash(rel)
+		if info.IsDir() {
+			if _, ok := vendored[importPath]; ok ||
+				(pth != vendor && (strings.HasPrefix(info.Name(), ".") ||
+					!src.requiresImportPath(importPath))) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() ||
+			strings.HasPrefix(info.Name(), ".") ||
+			strings.HasSuffix(info.Name(), ".go") {
+			return nil
+		}
+		if _, ok := vendorManifests[importPath]; ok {
+			return nil
+		}
+		rel, err = filepath.Rel(src.Path, pth)
+		if err != nil {
+			return err
+		}
+		assets = append(assets, &Asset{Path: filepath.ToSlash(rel)})
+		return nil
+	}
+	if err := filepath.Walk(vendor, walkfn); err != nil {
+		return nil, err
+	}
+	return assets, nil
+}
+
+// MatchAssets looks for files matching the assets not yet matched
+// in the repository in wt at ref.Rev, which is the upstream of the
+// project ref, and fills in the assets which match.
+func (src GoSource) MatchAssets(assets []*Asset, ref *Reference, wt WorkingTree) error {
+	if ref.Rev == "" {
+		return nil
+	}
+
+	var pending []*Asset
+	for _, asset := range assets {
+		if asset.Pkg == "" {
+			pending = append(pending, asset)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	refHashes, err := wt.FileHashesFromRef(ref.Rev, "")
+	if err != nil {
+		return err
+	}
+	files := make(map[FileHash][]string)
+	for file, hash := range refHashes {
+		files[hash] = append(files[hash], file)
+	}
+
+	for _, asset := range pending {
+		abs := filepath.Join(src.Path, filepath.FromSlash(asset.Path))
+		hash, err := wt.Hash(path.Base(asset.Path), abs)
+		if err != nil {
+			return err
+		}
+		matches := files[hash]
+		if len(matches) == 0 {
+			continue
+		}
+		sort.Strings(matches)
+		log.Debugf("%s: matches %s in %s", asset.Path, matches[0], ref.Pkg)
+		asset.Pkg = ref.Pkg
+		asset.Repo = ref.Repo
+		asset.Rev = ref.Rev
+		asset.Ver = ref.Ver
+		asset.File = matches[0]
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// assetsWorkingTree is a mock WorkingTree with fixed file hashes.
+type assetsWorkingTree struct {
+	stubWorkingTree
+
+	hashes FileHashes
+}
+
+func (wt *assetsWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	return wt.hashes, nil
+}
+
+func TestAssets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-assets.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"main.go":                           "package main\n",
+		"vendor/modules.txt":                "# github.com/foo/bar v1.0.0\n",
+		"vendor/github.com/foo/bar/bar.go":  "package bar\n",
+		"vendor/github.com/foo/bar/a.proto": "syntax = \"proto3\";\n",
+		"vendor/protos/b.proto":             "syntax = \"proto2\";\n",
+		"vendor/protos/.hidden":             "",
+		"vendor/scripts/build.sh":           "#!/bin/sh\n",
+		"vendor/scripts/gen.go":             "package scripts\n",
+	})
+
+	src, err := NewGoSource(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vendored := map[string]*RepoPath{"github.com/foo/bar": {}}
+	assets, err := src.Assets(vendored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assets) != 2 || assets[0].Path != "vendor/protos/b.proto" ||
+		assets[1].Path != "vendor/scripts/build.sh" {
+		t.Fatalf("unexpected assets %v", assets)
+	}
+
+	wt := &assetsWorkingTree{}
+	wt.hasher = &sha256Hasher{}
+	proto, err := wt.Hash("b.proto", filepath.Join(dir, "vendor", "protos", "b.proto"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt.hashes = FileHashes{
+		"z/b.proto":   proto,
+		"api/b.proto": proto,
+		"other.sh":    FileHash("0123"),
+	}
+	ref := &Reference{Pkg: "github.com/eggs/ham", Rev: "abc", Ver: "v0.1.0"}
+	if err := src.MatchAssets(assets, ref, wt); err != nil {
+		t.Fatal(err)
+	}
+	if a := assets[0]; a.Pkg != ref.Pkg || a.Rev != "abc" || a.Ver != "v0.1.0" || a.File != "api/b.proto" {
+		t.Errorf("unexpected match %+v", a)
+	}
+	if a := assets[1]; a.Pkg != "" {
+		t.Errorf("unexpected match %+v", a)
+	}
+
+	// Matched assets are left alone.
+	other := &Reference{Pkg: "github.com/other/name", Rev: "def"}
+	if err := src.MatchAssets(assets, other, wt); err != nil {
+		t.Fatal(err)
+	}
+	if assets[0].Pkg != ref.Pkg {
+		t.Errorf("asset matched again: %+v", assets[0])
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// ReportPredicateType is the in-toto predicate type of attestations
+// whose predicate is a Report.
+const ReportPredicateType = "https://github.com/release-engineering/retrodep/schema/report.schema.json"
+
+// DriftUnverified means the local files of a project could not be
+// compared with the revision attested for it, for example because
+// there is none or it is no longer available upstream.
+const DriftUnverified = "unverified"
+
+// attestation holds the fields of a Report, an in-toto Statement, or
+// a DSSE envelope holding a Statement, which are told apart by which
+// fields are present.
+type attestation struct {
+	// DSSE envelope
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+
+	// in-toto Statement
+	Type          string          `json:"_type"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
+
+// ReadAttestedReport reads a JSON report from r, which may also be
+// an in-toto Statement with a Report as its predicate, or a DSSE
+// envelope holding one. Signatures are not checked.
+func ReadAttestedReport(r io.Reader) (*Report, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var a attestation
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, err
+	}
+	switch {
+	case a.PayloadType != "":
+		payload, err := base64.StdEncoding.DecodeString(a.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("DSSE payload: %s", err)
+		}
+		return ReadAttestedReport(bytes.NewReader(payload))
+	case a.Type != "":
+		if a.PredicateType != ReportPredicateType {
+			return nil, fmt.Errorf("unsupported predicate type %q", a.PredicateType)
+		}
+		return ReadReport(bytes.NewReader(a.Predicate))
+	}
+	return ReadReport(bytes.NewReader(data))
+}
+
+// VerifyReference checks that the local files of the project
+// described by ref, which must be this top-level project or one of
+// its vendored projects, still match revision ref.Rev in wt. It
+// returns the paths of the local files which do not, relative to
+// the project's directory, or ErrorNoFiles if there are no local
+// files.
+func (src GoSource) VerifyReference(project *RepoPath, ref *Reference, wt WorkingTree) ([]string, error) {
+	dir := filepath.Join(src.Path, filepath.FromSlash(src.projectDir(ref)))
+	hashes, err := src.hashLocalFiles(wt, project, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	strip := src.usesGodep && ref.TopPkg != ""
+	_, err = matchFromRefs(strip, hashes, wt, project.SubPath, []string{ref.Rev})
+	if err != ErrorVersionNotFound {
+		// Either matched or failed
+		return nil, err
+	}
+
+	upstream, err := wt.FileHashesFromRef(ref.Rev, project.SubPath)
+	if err != nil {
+		return nil, err
+	}
+	mismatches := hashes.Mismatches(upstream, false)
+	sort.Strings(mismatches)
+	return mismatches, nil
+}
+
+// ProjectDir returns the slash-separated directory holding the
+// project described by ref, relative to the path the GoSource was
+// found from, in the same form as LockEntry.Dir.
+func (src GoSource) ProjectDir(ref *Reference) string {
+	return path.Join(filepath.ToSlash(src.SubPath), src.projectDir(ref))
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestReadAttestedReport(t *testing.T) {
+	report := `{"schemaVersion":"1.9","projects":[{"pkg":"github.com/foo/bar","rev":"d4c3dbf"}]}`
+	statement := `{"_type":"https://in-toto.io/Statement/v1","subject":[],"predicateType":"` +
+		ReportPredicateType + `","predicate":` + report + `}`
+	envelope := `{"payloadType":"application/vnd.in-toto+json","payload":"` +
+		base64.StdEncoding.EncodeToString([]byte(statement)) + `","signatures":[]}`
+
+	for _, input := range []string{report, statement, envelope} {
+		r, err := ReadAttestedReport(strings.NewReader(input))
+		if err != nil {
+			t.Errorf("%s: %s", input, err)
+			continue
+		}
+		if len(r.Projects) != 1 || r.Projects[0].Rev != "d4c3dbf" {
+			t.Errorf("%s: got %v", input, r.Projects)
+		}
+	}
+
+	other := strings.Replace(statement, ReportPredicateType, "https://slsa.dev/provenance/v1", 1)
+	if _, err := ReadAttestedReport(strings.NewReader(other)); err == nil {
+		t.Error("unsupported predicate type accepted")
+	}
+}
+
+func TestVerifyReference(t *testing.T) {
+	src, err := NewGoSource("testdata/gosource", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	project := &RepoPath{RepoRoot: vcs.RepoRoot{Root: "github.com/foo/bar"}}
+	wt := &mockVendorWorkingTree{}
+	wt.hasher = &dummyHasher{}
+	dir := filepath.Join(src.Path, "vendor", "github.com", "foo", "bar")
+	wt.localHashes, err = src.hashLocalFiles(wt, project, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref := &Reference{TopPkg: "top", Pkg: "github.com/foo/bar", Rev: matchRevision}
+	if dir := src.ProjectDir(ref); dir != "vendor/github.com/foo/bar" {
+		t.Errorf("ProjectDir: got %q", dir)
+	}
+	mismatches, err := src.VerifyReference(project, ref, wt)
+	if err != nil || mismatches != nil {
+		t.Errorf("unchanged: got %v, %v", mismatches, err)
+	}
+
+	ref.Rev = "fedcba9876543210"
+	mismatches, err = src.VerifyReference(project, ref, wt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(mismatches, []string{"bar.go"}) {
+		t.Errorf("changed: got %v", mismatches)
+	}
+
+	ref.Pkg = "github.com/gone/away"
+	_, err = src.VerifyReference(project, ref, wt)
+	if err != ErrorNoFiles && !os.IsNotExist(err) {
+		t.Errorf("missing: got %v", err)
+	}
+}
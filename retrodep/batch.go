@@ -0,0 +1,180 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// BatchTarget is a top-level project to scan as part of a batch,
+// either in a local directory or in a repository to clone.
+type BatchTarget struct {
+	// Path is the local directory, or "" if Repo is given.
+	Path string
+
+	// Repo is the URL of the git repository to clone, and Ref the
+	// tag, revision or branch to scan in it, or "" for the
+	// default branch.
+	Repo string
+	Ref  string
+
+	// ImportPath is the import path of the top-level project, or
+	// "" for it to be worked out.
+	ImportPath string
+}
+
+// Name returns the name the results for t are reported under: its
+// path, or its repository URL followed by "@" and the ref if there
+// is one.
+func (t BatchTarget) Name() string {
+	if t.Repo == "" {
+		return t.Path
+	}
+	if t.Ref == "" {
+		return t.Repo
+	}
+	return t.Repo + "@" + t.Ref
+}
+
+// RepoRoot returns the repository to clone for t, or nil if it is in
+// a local directory.
+func (t BatchTarget) RepoRoot() *vcs.RepoRoot {
+	if t.Repo == "" {
+		return nil
+	}
+	return &vcs.RepoRoot{VCS: vcsByCmd(vcsGit), Repo: t.Repo, Root: t.Repo}
+}
+
+// ReadBatch parses a batch file from r. Each line has a local
+// directory, or the URL of a git repository (containing "://"),
+// optionally followed by whitespace-separated ref=REF, for the tag,
+// revision or branch to scan in the repository, and
+// importpath=IMPORTPATH. Blank lines and lines starting with "#" are
+// ignored. It is an error for a project to be listed twice.
+func ReadBatch(r io.Reader) ([]BatchTarget, error) {
+	var targets []BatchTarget
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		var t BatchTarget
+		if strings.Contains(fields[0], "://") {
+			t.Repo = fields[0]
+		} else {
+			t.Path = fields[0]
+		}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 || kv[1] == "" {
+				return nil, fmt.Errorf("line %d: expected key=value: %s", lineno, field)
+			}
+			switch kv[0] {
+			case "ref":
+				if t.Repo == "" {
+					return nil, fmt.Errorf("line %d: ref given for local directory %s", lineno, t.Path)
+				}
+				t.Ref = kv[1]
+			case "importpath":
+				t.ImportPath = kv[1]
+			default:
+				return nil, fmt.Errorf("line %d: unknown key %q", lineno, kv[0])
+			}
+		}
+		name := t.Name()
+		if seen[name] {
+			return nil, fmt.Errorf("line %d: %s listed twice", lineno, name)
+		}
+		seen[name] = true
+		targets = append(targets, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// BatchReport holds the results of scanning several top-level
+// projects, keyed by the name of each BatchTarget.
+type BatchReport struct {
+	// SchemaVersion is the ReportSchemaVersion the batch report,
+	// and each Report in it, was written with.
+	SchemaVersion string `json:"schemaVersion"`
+
+	// Reports holds the Report for each project scanned.
+	Reports map[string]*Report `json:"reports"`
+
+	// Errors explains why each project which could not be
+	// scanned failed.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// NewBatchReport returns an empty BatchReport.
+func NewBatchReport() *BatchReport {
+	return &BatchReport{
+		SchemaVersion: ReportSchemaVersion,
+		Reports:       make(map[string]*Report),
+	}
+}
+
+// Add records report as the result for the project called name.
+func (b *BatchReport) Add(name string, report *Report) {
+	b.Reports[name] = report
+}
+
+// Fail records err as the reason the project called name could not
+// be scanned.
+func (b *BatchReport) Fail(name string, err error) {
+	if b.Errors == nil {
+		b.Errors = make(map[string]string)
+	}
+	b.Errors[name] = err.Error()
+}
+
+// Write writes the batch report as indented JSON to w, with each
+// Report ordered as Report.Write orders it.
+func (b *BatchReport) Write(w io.Writer) error {
+	for _, report := range b.Reports {
+		report.sort()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}
+
+// ReadBatchReport reads a JSON batch report from r. It returns an
+// error if it was written with an incompatible schema version.
+func ReadBatchReport(r io.Reader) (*BatchReport, error) {
+	var b BatchReport
+	if err := json.NewDecoder(r).Decode(&b); err != nil {
+		return nil, err
+	}
+	if err := checkSchemaVersion(b.SchemaVersion); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
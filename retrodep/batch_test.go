@@ -0,0 +1,106 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadBatch(t *testing.T) {
+	targets, err := ReadBatch(strings.NewReader(`
+# nightly scans
+src/product
+https://github.com/example/name ref=v1.2.0
+https://github.com/example/name ref=v1.3.0 importpath=example.com/name
+ssh://git@example.com/other.git
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []BatchTarget{
+		{Path: "src/product"},
+		{Repo: "https://github.com/example/name", Ref: "v1.2.0"},
+		{Repo: "https://github.com/example/name", Ref: "v1.3.0", ImportPath: "example.com/name"},
+		{Repo: "ssh://git@example.com/other.git"},
+	}
+	if !reflect.DeepEqual(targets, expected) {
+		t.Fatalf("got %v, want %v", targets, expected)
+	}
+
+	var names []string
+	for _, target := range targets {
+		names = append(names, target.Name())
+	}
+	if !reflect.DeepEqual(names, []string{
+		"src/product",
+		"https://github.com/example/name@v1.2.0",
+		"https://github.com/example/name@v1.3.0",
+		"ssh://git@example.com/other.git",
+	}) {
+		t.Errorf("names: got %v", names)
+	}
+	if root := targets[0].RepoRoot(); root != nil {
+		t.Errorf("local directory: got %v", root)
+	}
+	if root := targets[1].RepoRoot(); root == nil || root.VCS.Cmd != vcsGit || root.Repo != targets[1].Repo {
+		t.Errorf("repository: got %v", root)
+	}
+
+	for _, bad := range []string{
+		"src/product ref=v1.0.0",
+		"src/product importpath",
+		"src/product branch=main",
+		"src/product\nsrc/product",
+	} {
+		if _, err := ReadBatch(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestBatchReportRoundTrip(t *testing.T) {
+	b := NewBatchReport()
+	report := NewReport()
+	report.Add(&Reference{Pkg: "example.com/name", Ver: "v1.2.0"})
+	report.Add(&Reference{TopPkg: "example.com/name", Pkg: "example.com/b"})
+	report.Add(&Reference{TopPkg: "example.com/name", Pkg: "example.com/a"})
+	b.Add("src/product", report)
+	b.Fail("https://github.com/example/gone", errors.New("repository unreachable"))
+
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadBatchReport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, b) {
+		t.Errorf("got %#v, want %#v", read, b)
+	}
+	if projects := read.Reports["src/product"].Projects; projects[1].Pkg != "example.com/a" {
+		t.Errorf("not sorted: %v", projects)
+	}
+
+	_, err = ReadBatchReport(strings.NewReader(`{"schemaVersion": "2.0", "reports": {}}`))
+	if err == nil {
+		t.Error("incompatible schema version accepted")
+	}
+}
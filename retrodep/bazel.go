@@ -0,0 +1,174 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// bazelWorkspaceFiles are the files which mark the root of a Bazel
+// workspace.
+var bazelWorkspaceFiles = []string{"WORKSPACE", "WORKSPACE.bazel", "MODULE.bazel"}
+
+// bazelFiles are the files, relative to the top-level project, which
+// may pin Go repositories in a Bazel workspace. Gazelle writes
+// go_repository rules to deps.bzl by convention.
+var bazelFiles = append(bazelWorkspaceFiles, "deps.bzl")
+
+// bazelVendors are the directories, relative to the top-level
+// project, where a Bazel workspace may check in third-party Go
+// source, in order of preference.
+var bazelVendors = []string{"third_party/go", "third_party"}
+
+// bazelAttrRE matches a string attribute of a Bazel rule, capturing
+// its name and value.
+var bazelAttrRE = regexp.MustCompile(`(\w+)\s*=\s*"([^"]*)"`)
+
+// isBazelWorkspace returns true if path is the root of a Bazel
+// workspace.
+func isBazelWorkspace(path string) bool {
+	for _, name := range bazelWorkspaceFiles {
+		if _, err := os.Stat(filepath.Join(path, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// bazelRules returns the string attributes of each call to rule in
+// data, a Starlark file.
+func bazelRules(data, rule string) []map[string]string {
+	var rules []map[string]string
+	for {
+		start := strings.Index(data, rule+"(")
+		if start == -1 {
+			break
+		}
+		data = data[start+len(rule)+1:]
+
+		// Find the matching closing parenthesis, ignoring any
+		// in strings and comments.
+		depth, end := 1, len(data)
+		var quote rune
+		comment := false
+	scan:
+		for i, c := range data {
+			switch {
+			case comment:
+				comment = c != '\n'
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case c == '#':
+				comment = true
+			case c == '"' || c == '\'':
+				quote = c
+			case c == '(':
+				depth++
+			case c == ')':
+				depth--
+				if depth == 0 {
+					end = i
+					break scan
+				}
+			}
+		}
+
+		attrs := make(map[string]string)
+		for _, line := range strings.Split(data[:end], "\n") {
+			if comment := strings.Index(line, "#"); comment != -1 {
+				line = line[:comment]
+			}
+			for _, m := range bazelAttrRE.FindAllStringSubmatch(line, -1) {
+				attrs[m[1]] = m[2]
+			}
+		}
+		rules = append(rules, attrs)
+		data = data[end:]
+	}
+	return rules
+}
+
+// loadBazelConf parses the Bazel workspace files to extract the
+// repositories and versions pinned by go_repository rules and by
+// go_deps.module tags.
+func loadBazelConf(src *GoSource) error {
+	for _, name := range bazelFiles {
+		conf := filepath.Join(src.Path, name)
+		if _, skip := src.excludes[conf]; skip {
+			continue
+		}
+		data, err := ioutil.ReadFile(conf)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		for _, attrs := range bazelRules(string(data), "go_repository") {
+			importPath := attrs["importpath"]
+			if importPath == "" {
+				continue
+			}
+			version := attrs["tag"]
+			if version == "" {
+				version = attrs["version"]
+			}
+			src.addClaim(importPath, &ManifestClaim{
+				File:     name,
+				Revision: attrs["commit"],
+				Version:  version,
+			})
+
+			if attrs["remote"] == "" {
+				continue
+			}
+			theVcs := vcs.ByCmd(vcsGit) // default to git
+			if v := vcsByCmd(attrs["vcs"]); v != nil {
+				theVcs = v
+			}
+			if src.repoPaths == nil {
+				src.repoPaths = make(map[string]*RepoPath)
+			}
+			src.repoPaths[importPath] = &RepoPath{
+				RepoRoot: vcs.RepoRoot{
+					VCS:  theVcs,
+					Repo: attrs["remote"],
+					Root: importPath,
+				},
+			}
+		}
+
+		for _, attrs := range bazelRules(string(data), "go_deps.module") {
+			if attrs["path"] == "" {
+				continue
+			}
+			src.addClaim(attrs["path"], &ManifestClaim{
+				File:    name,
+				Version: attrs["version"],
+			})
+		}
+	}
+	return nil
+}
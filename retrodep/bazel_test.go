@@ -0,0 +1,105 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBazelRules(t *testing.T) {
+	rules := bazelRules(`
+go_repository(
+    name = "a",  # comment = "ignored"
+    importpath = "example.com/a",
+    build_directives = ["gazelle:proto disable"],  # (unbalanced
+)
+
+go_repository(name = "b", importpath = "example.com/b")
+`, "go_repository")
+	exp := []map[string]string{
+		{"name": "a", "importpath": "example.com/a"},
+		{"name": "b", "importpath": "example.com/b"},
+	}
+	if !reflect.DeepEqual(rules, exp) {
+		t.Errorf("got %v, want %v", rules, exp)
+	}
+}
+
+func TestBazel(t *testing.T) {
+	defer mockRepoRoots("github.com/foo/bar")()
+
+	src, err := NewGoSource("testdata/bazel", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !src.usesBazel {
+		t.Fatal("usesBazel")
+	}
+	if src.Vendor() != filepath.FromSlash("testdata/bazel/third_party/go") {
+		t.Errorf("Vendor: %s", src.Vendor())
+	}
+
+	vendored, err := src.VendoredProjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vendored) != 1 || vendored["github.com/foo/bar"] == nil {
+		t.Errorf("unexpected vendored projects %v", vendored)
+	}
+
+	ham, err := src.RepoPathForImportPath("github.com/eggs/ham")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ham.Repo != "https://github.com/fork/ham" {
+		t.Errorf("github.com/eggs/ham: got %s", ham.Repo)
+	}
+
+	tcs := []struct {
+		root  string
+		claim ManifestClaim
+	}{
+		{"github.com/foo/bar", ManifestClaim{File: "WORKSPACE", Revision: "a19ce4aa4d8b5b0e1b0a0c0b3ae0e49a5e1921c2"}},
+		{"github.com/eggs/ham", ManifestClaim{File: "WORKSPACE", Version: "v0.1.0"}},
+		{"golang.org/x/net", ManifestClaim{File: "MODULE.bazel", Version: "v0.0.1"}},
+	}
+	for _, tc := range tcs {
+		claims := src.manifestClaims(tc.root)
+		if len(claims) != 1 || *claims[0] != tc.claim {
+			t.Errorf("%s: unexpected claims %v", tc.root, claims)
+		}
+	}
+}
+
+func TestBazelThirdParty(t *testing.T) {
+	defer mockRepoRoots("github.com/foo/bar")()
+
+	// Without a go directory third_party itself is used, and
+	// directories which are not import paths are skipped.
+	src, err := NewGoSource("testdata/bazelthirdparty", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vendored, err := src.VendoredProjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vendored) != 1 || vendored["github.com/foo/bar"] == nil {
+		t.Errorf("unexpected vendored projects %v", vendored)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+// Staleness describes how far a revision is behind the latest
+// upstream release.
+type Staleness struct {
+	// Latest is the tag of the latest upstream release.
+	Latest string `json:"latest"`
+
+	// Commits is the number of commits reachable from Latest but
+	// not from the revision.
+	Commits int `json:"commits"`
+
+	// Releases is the number of those commits tagged as releases.
+	Releases int `json:"releases"`
+}
+
+// Behind returns how far ref, the project found in the upstream wt,
+// is behind the latest release in wt. It returns nil if ref has no
+// revision, there are no releases, or wt cannot answer ancestry
+// queries.
+func Behind(ref *Reference, wt WorkingTree) (*Staleness, error) {
+	if ref.Rev == "" {
+		return nil, nil
+	}
+	bwt, ok := wt.(BehindWorkingTree)
+	if !ok {
+		return nil, nil
+	}
+	tags, err := wt.VersionTags()
+	if err != nil {
+		return nil, err
+	}
+	latest := latestRelease(tags)
+	if latest == "" {
+		return nil, nil
+	}
+	staleness := &Staleness{Latest: latest}
+	if ref.Tag == latest {
+		return staleness, nil
+	}
+	latestRev, err := wt.RevisionFromTag(latest)
+	if err != nil {
+		return nil, err
+	}
+	commits, between, err := bwt.CommitsBehind(ref.Rev, latestRev)
+	if err != nil {
+		return nil, err
+	}
+	staleness.Commits = commits
+	for _, tag := range between {
+		v, err := semver.NewVersion(tag)
+		if err == nil && v.Prerelease() == "" {
+			staleness.Releases++
+		}
+	}
+	return staleness, nil
+}
+
+// CommitsBehind returns the number of commits reachable from target
+// but not from rev, using 'git rev-list --count', and the tags of
+// those commits, using 'git tag --merged ... --no-merged ...'.
+func (g *gitWorkingTree) CommitsBehind(rev, target string) (int, []string, error) {
+	rev, target = g.tagRef(rev), g.tagRef(target)
+	stdout, stderr, err := g.run("rev-list", "--count", rev+".."+target)
+	if err != nil {
+		g.showOutput(stdout, stderr)
+		return 0, nil, err
+	}
+	commits, err := strconv.Atoi(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	stdout, stderr, err = g.run("tag", "--list", "--merged", target,
+		"--no-merged", rev)
+	if err != nil {
+		g.showOutput(stdout, stderr)
+		return 0, nil, err
+	}
+	tags := strings.Fields(stdout.String())
+	if g.tagPrefix != "" {
+		tags = stripTagPrefix(g.tagPrefix, tags)
+	}
+	return commits, tags, nil
+}
+
+// CommitsBehind returns the number of commits reachable from target
+// but not from rev, and the tags of those commits, using 'hg log -r
+// "only(...)"'.
+func (h *hgWorkingTree) CommitsBehind(rev, target string) (int, []string, error) {
+	entries, err := h.log([]string{"-r", "only(" + target + ", " + rev + ")"}, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	var tags []string
+	for _, entry := range entries {
+		if entry.Tag != "" && entry.Tag != "tip" {
+			tags = append(tags, entry.Tag)
+		}
+	}
+	return len(entries), tags, nil
+}
@@ -0,0 +1,95 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// behindWorkingTree is a policyWorkingTree which can count commits.
+type behindWorkingTree struct {
+	policyWorkingTree
+}
+
+func (wt *behindWorkingTree) CommitsBehind(rev, target string) (int, []string, error) {
+	if target != "r100" {
+		return 0, nil, nil
+	}
+	switch rev {
+	case "r0":
+		return 100, []string{"v1.3.0-rc1", "v1.2.0", "v1.1.0"}, nil
+	case "r50":
+		return 50, []string{"v1.3.0-rc1", "v1.2.0"}, nil
+	}
+	return 0, nil, nil
+}
+
+func TestBehind(t *testing.T) {
+	tcs := []struct {
+		ref      Reference
+		commits  int
+		releases int
+	}{
+		{Reference{Tag: "v1.2.0", Rev: "r100"}, 0, 0},
+		{Reference{Tag: "v1.1.0", Rev: "r50"}, 50, 1},
+		{Reference{Rev: "r0"}, 100, 2},
+	}
+	wt := &behindWorkingTree{}
+	for _, tc := range tcs {
+		behind, err := Behind(&tc.ref, wt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if behind == nil || behind.Latest != "v1.2.0" ||
+			behind.Commits != tc.commits || behind.Releases != tc.releases {
+			t.Errorf("%s: got %+v", tc.ref.Rev, behind)
+		}
+	}
+
+	if behind, err := Behind(&Reference{}, wt); behind != nil || err != nil {
+		t.Errorf("no revision: got %v, %v", behind, err)
+	}
+	if behind, err := Behind(&Reference{Rev: "r0"}, &policyWorkingTree{}); behind != nil || err != nil {
+		t.Errorf("no ancestry: got %v, %v", behind, err)
+	}
+}
+
+func TestGitCommitsBehind(t *testing.T) {
+	defer mockExecCommand()()
+
+	wt := gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			Dir: "",
+			VCS: vcs.ByCmd(vcsGit),
+		},
+	}
+
+	mockedStdout = "3\n"
+	commits, tags, err := wt.CommitsBehind("r0", "r100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commits != 3 || len(tags) != 1 {
+		t.Errorf("got %d, %v", commits, tags)
+	}
+
+	mockedStdout = "not a number\n"
+	if _, _, err := wt.CommitsBehind("r0", "r100"); err == nil {
+		t.Error("bad output was not reported")
+	}
+}
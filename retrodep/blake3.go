@@ -0,0 +1,238 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+// This file contains an implementation of the BLAKE3 hash function,
+// used by the "blake3" hash algorithm. Only the default hashing mode
+// with 32-byte output is provided.
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+const (
+	blake3BlockLen = 64
+	blake3ChunkLen = 1024
+	blake3Size     = 32
+
+	blake3ChunkStart = 1 << 0
+	blake3ChunkEnd   = 1 << 1
+	blake3Parent     = 1 << 2
+	blake3Root       = 1 << 3
+)
+
+var blake3IV = [8]uint32{
+	0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a,
+	0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+}
+
+var blake3Permutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+func blake3G(s *[16]uint32, a, b, c, d int, mx, my uint32) {
+	s[a] += s[b] + mx
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] += s[b] + my
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
+
+// blake3Compress returns the full output of the compression
+// function for one block.
+func blake3Compress(cv *[8]uint32, block *[16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+	s := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags,
+	}
+	m := *block
+	for round := 0; round < 7; round++ {
+		blake3G(&s, 0, 4, 8, 12, m[0], m[1])
+		blake3G(&s, 1, 5, 9, 13, m[2], m[3])
+		blake3G(&s, 2, 6, 10, 14, m[4], m[5])
+		blake3G(&s, 3, 7, 11, 15, m[6], m[7])
+		blake3G(&s, 0, 5, 10, 15, m[8], m[9])
+		blake3G(&s, 1, 6, 11, 12, m[10], m[11])
+		blake3G(&s, 2, 7, 8, 13, m[12], m[13])
+		blake3G(&s, 3, 4, 9, 14, m[14], m[15])
+
+		var permuted [16]uint32
+		for i, j := range blake3Permutation {
+			permuted[i] = m[j]
+		}
+		m = permuted
+	}
+	for i := 0; i < 8; i++ {
+		s[i] ^= s[i+8]
+		s[i+8] ^= cv[i]
+	}
+	return s
+}
+
+// blake3Output is the input to a compression whose result is not
+// yet known to be the root or a chaining value.
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o *blake3Output) chainingValue() [8]uint32 {
+	var cv [8]uint32
+	s := blake3Compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags)
+	copy(cv[:], s[:8])
+	return cv
+}
+
+func (o *blake3Output) rootBytes() []byte {
+	s := blake3Compress(&o.cv, &o.block, 0, o.blockLen, o.flags|blake3Root)
+	out := make([]byte, blake3Size)
+	for i := 0; i < blake3Size/4; i++ {
+		binary.LittleEndian.PutUint32(out[4*i:], s[i])
+	}
+	return out
+}
+
+func blake3ParentOutput(left, right [8]uint32) *blake3Output {
+	o := &blake3Output{
+		cv:       blake3IV,
+		blockLen: blake3BlockLen,
+		flags:    blake3Parent,
+	}
+	copy(o.block[:8], left[:])
+	copy(o.block[8:], right[:])
+	return o
+}
+
+func blake3Words(block []byte) [16]uint32 {
+	var buf [blake3BlockLen]byte
+	copy(buf[:], block)
+	var words [16]uint32
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(buf[4*i:])
+	}
+	return words
+}
+
+// blake3Chunk is the state of the chunk being hashed.
+type blake3Chunk struct {
+	cv         [8]uint32
+	counter    uint64
+	block      [blake3BlockLen]byte
+	blockLen   int
+	compressed int
+}
+
+func newBlake3Chunk(counter uint64) blake3Chunk {
+	return blake3Chunk{cv: blake3IV, counter: counter}
+}
+
+func (c *blake3Chunk) len() int {
+	return blake3BlockLen*c.compressed + c.blockLen
+}
+
+func (c *blake3Chunk) startFlag() uint32 {
+	if c.compressed == 0 {
+		return blake3ChunkStart
+	}
+	return 0
+}
+
+func (c *blake3Chunk) update(p []byte) {
+	for len(p) > 0 {
+		if c.blockLen == blake3BlockLen {
+			// The buffered block is not the last one.
+			words := blake3Words(c.block[:])
+			s := blake3Compress(&c.cv, &words, c.counter, blake3BlockLen, c.startFlag())
+			copy(c.cv[:], s[:8])
+			c.compressed++
+			c.block = [blake3BlockLen]byte{}
+			c.blockLen = 0
+		}
+		n := copy(c.block[c.blockLen:], p)
+		c.blockLen += n
+		p = p[n:]
+	}
+}
+
+func (c *blake3Chunk) output() *blake3Output {
+	return &blake3Output{
+		cv:       c.cv,
+		block:    blake3Words(c.block[:c.blockLen]),
+		counter:  c.counter,
+		blockLen: uint32(c.blockLen),
+		flags:    c.startFlag() | blake3ChunkEnd,
+	}
+}
+
+// blake3Digest implements hash.Hash for BLAKE3.
+type blake3Digest struct {
+	chunk blake3Chunk
+	stack [][8]uint32 // chaining values of complete subtrees
+}
+
+func newBlake3() hash.Hash {
+	return &blake3Digest{chunk: newBlake3Chunk(0)}
+}
+
+func (d *blake3Digest) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if d.chunk.len() == blake3ChunkLen {
+			// The current chunk is complete and more input
+			// follows, so it is not the root.
+			cv := d.chunk.output().chainingValue()
+			total := d.chunk.counter + 1
+			for total&1 == 0 {
+				cv = blake3ParentOutput(d.stack[len(d.stack)-1], cv).chainingValue()
+				d.stack = d.stack[:len(d.stack)-1]
+				total >>= 1
+			}
+			d.stack = append(d.stack, cv)
+			d.chunk = newBlake3Chunk(d.chunk.counter + 1)
+		}
+		take := blake3ChunkLen - d.chunk.len()
+		if take > len(p) {
+			take = len(p)
+		}
+		d.chunk.update(p[:take])
+		p = p[take:]
+	}
+	return n, nil
+}
+
+func (d *blake3Digest) Sum(b []byte) []byte {
+	output := d.chunk.output()
+	for i := len(d.stack) - 1; i >= 0; i-- {
+		output = blake3ParentOutput(d.stack[i], output.chainingValue())
+	}
+	return append(b, output.rootBytes()...)
+}
+
+func (d *blake3Digest) Reset() {
+	d.chunk = newBlake3Chunk(0)
+	d.stack = nil
+}
+
+func (d *blake3Digest) Size() int { return blake3Size }
+
+func (d *blake3Digest) BlockSize() int { return blake3BlockLen }
@@ -0,0 +1,97 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// BlockedVersion is a version or revision of a project which is
+// known to be bad, for example because it was malicious or yanked.
+type BlockedVersion struct {
+	// Pkg is the import path of the project.
+	Pkg string
+
+	// Version is a tag, semantic version or pseudo-version, or
+	// an upstream revision, which may be abbreviated to no fewer
+	// than minAbbrevRev hexadecimal digits.
+	Version string
+
+	// Reason is the explanation given, or "".
+	Reason string
+}
+
+// minAbbrevRev is the fewest hexadecimal digits a blocked revision
+// may be abbreviated to, as for git's short hashes, so that a short
+// entry does not block unrelated revisions.
+const minAbbrevRev = 7
+
+// abbrevRevRE matches an abbreviated hexadecimal revision.
+var abbrevRevRE = regexp.MustCompile(fmt.Sprintf("^[0-9a-f]{%d,}$", minAbbrevRev))
+
+// Blocklist maps project import paths to their blocked versions.
+type Blocklist map[string][]*BlockedVersion
+
+// ReadBlocklist parses a blocklist from r. Each line has an import
+// path, a version or revision, and optionally a reason, separated
+// by whitespace. Blank lines and lines starting with "#" are
+// ignored.
+func ReadBlocklist(r io.Reader) (Blocklist, error) {
+	blocklist := make(Blocklist)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		b := &BlockedVersion{
+			Pkg:     fields[0],
+			Version: fields[1],
+			Reason:  strings.Join(fields[2:], " "),
+		}
+		blocklist[b.Pkg] = append(blocklist[b.Pkg], b)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return blocklist, nil
+}
+
+// Match returns the blocked version matching ref, or nil if there
+// is none. Versions and tags must be the same, and revisions must
+// be the same or begin with an abbreviated revision of at least
+// minAbbrevRev hexadecimal digits.
+func (b Blocklist) Match(ref *Reference) *BlockedVersion {
+	for _, blocked := range b[ref.Pkg] {
+		switch {
+		case ref.Ver != "" && blocked.Version == ref.Ver,
+			ref.Tag != "" && blocked.Version == ref.Tag,
+			ref.Rev != "" && blocked.Version == ref.Rev,
+			ref.Rev != "" && abbrevRevRE.MatchString(blocked.Version) &&
+				strings.HasPrefix(ref.Rev, blocked.Version):
+			return blocked
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBlocklist(t *testing.T) {
+	input := `# comment
+github.com/foo/bar v1.2.3 yanked: data loss
+github.com/foo/bar d4c3dbf
+github.com/eggs/ham v0.1.0
+github.com/eggs/ham 01
+github.com/eggs/ham 1234
+
+incomplete
+`
+	b, err := ReadBlocklist(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b["github.com/foo/bar"]) != 2 || len(b) != 2 || len(b["github.com/eggs/ham"]) != 3 {
+		t.Fatalf("unexpected blocklist %v", b)
+	}
+	if reason := b["github.com/foo/bar"][0].Reason; reason != "yanked: data loss" {
+		t.Errorf("reason: got %q", reason)
+	}
+
+	tcs := []struct {
+		ref     Reference
+		blocked string
+	}{
+		{Reference{Pkg: "github.com/foo/bar", Ver: "v1.2.3"}, "v1.2.3"},
+		{Reference{Pkg: "github.com/foo/bar", Tag: "v1.2.3", Ver: "v1.2.3"}, "v1.2.3"},
+		{Reference{Pkg: "github.com/foo/bar", Rev: "d4c3dbfa77a7", Ver: "v1.2.4-0.20190101000000-d4c3dbfa77a7"}, "d4c3dbf"},
+		{Reference{Pkg: "github.com/foo/bar", Rev: "0123456789ab", Ver: "v1.2.4"}, ""},
+		{Reference{Pkg: "github.com/eggs/ham", Ver: "v1.2.3"}, ""},
+		// Short entries do not block revisions beginning with them.
+		{Reference{Pkg: "github.com/eggs/ham", Rev: "0123456789ab", Ver: "v0.1.1-0.20190101000000-0123456789ab"}, ""},
+		{Reference{Pkg: "github.com/eggs/ham", Rev: "12345", Ver: "v0.2.0"}, ""},
+		{Reference{Pkg: "github.com/eggs/ham", Rev: "1234", Ver: "v0.0.0-1.20190101000000-1234"}, "1234"},
+		{Reference{Pkg: "github.com/eggs/ham"}, ""},
+	}
+	for _, tc := range tcs {
+		blocked := b.Match(&tc.ref)
+		if blocked == nil {
+			if tc.blocked != "" {
+				t.Errorf("%v: not matched, want %s", tc.ref, tc.blocked)
+			}
+		} else if blocked.Version != tc.blocked {
+			t.Errorf("%v: matched %s, want %q", tc.ref, blocked.Version, tc.blocked)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import "io"
+
+// AllBranches is the branch name standing for every branch, which
+// is the default.
+const AllBranches = "*"
+
+// Branch makes git and Mercurial working trees consider only the
+// revisions on branch, such as "release-1.19", rather than those on
+// every branch. Revisions lists only the revisions reachable from
+// the branch, and ReachableTag only finds tags along it rather than
+// tags from other branches merged into it.
+func Branch(branch string) WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		if branch == AllBranches {
+			branch = ""
+		}
+		o.branch = branch
+	}
+}
+
+// Branches maps import path prefixes to the branch used for the
+// projects below them, or AllBranches.
+type Branches map[string]string
+
+// ReadBranches parses a branches file from r. Each line has an
+// import path prefix followed by the branch name, or "*" for all
+// branches, separated by whitespace. Blank lines and lines starting
+// with "#" are ignored.
+func ReadBranches(r io.Reader) (Branches, error) {
+	branches, err := readPrefixMap(r)
+	return Branches(branches), err
+}
+
+// For returns the branch for the project at importPath, from the
+// longest import path prefix matching it, or "" if there is none.
+func (b Branches) For(importPath string) string {
+	return longestPrefix(b, importPath)
+}
+
+// gitBranchRef returns the git ref for the branch: the
+// remote-tracking branch, as the clone only has a local branch for
+// the default branch.
+func gitBranchRef(branch string) string {
+	return "refs/remotes/origin/" + branch
+}
+
+// hgBranch returns the revset for the revisions on the named branch.
+func hgBranch(branch string) string {
+	return "branch(r'literal:" + branch + "')"
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestReadBranches(t *testing.T) {
+	b, err := ReadBranches(strings.NewReader(`# comment
+k8s.io release-1.19
+k8s.io/utils *
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for importPath, expected := range map[string]string{
+		"k8s.io/api":      "release-1.19",
+		"k8s.io/utils/io": AllBranches,
+		"k8s.io.example":  "",
+	} {
+		if branch := b.For(importPath); branch != expected {
+			t.Errorf("%s: got %q, want %q", importPath, branch, expected)
+		}
+	}
+
+	var o workingTreeOptions
+	Branch(AllBranches)(&o)
+	if o.branch != "" {
+		t.Errorf("all branches: got %q", o.branch)
+	}
+}
+
+func TestGitBranch(t *testing.T) {
+	var commands [][]string
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		commands = append(commands, args)
+		var out string
+		switch args[0] {
+		case "rev-list":
+			out = "d4c3dbfa77a74ae238e401d5d2197b45f30d8513\n"
+		case "describe":
+			out = "v1.19.2-3-gd4c3dbf\n"
+		}
+		return exec.Command("printf", "%s", out)
+	}
+
+	wt := gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			VCS:    vcs.ByCmd(vcsGit),
+			branch: "release-1.19",
+		},
+	}
+	if _, err := wt.Revisions(); err != nil {
+		t.Fatal(err)
+	}
+	tag, err := wt.ReachableTag("d4c3dbf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "v1.19.2" {
+		t.Errorf("got tag %q", tag)
+	}
+	exp := [][]string{
+		{"rev-list", "refs/remotes/origin/release-1.19"},
+		{"describe", "--tags", "--first-parent", "--match=v[0-9]*", "d4c3dbf"},
+	}
+	if !reflect.DeepEqual(commands, exp) {
+		t.Errorf("ran %v, want %v", commands, exp)
+	}
+}
+
+func TestHgBranch(t *testing.T) {
+	var commands [][]string
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		commands = append(commands, args)
+		return exec.Command("printf", "%s", `<?xml version="1.0"?>
+<log>
+<logentry revision="1" node="abc">
+<tag>v1.19.2</tag>
+</logentry>
+</log>
+`)
+	}
+
+	wt := hgWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			VCS:    vcs.ByCmd(vcsHg),
+			branch: "stable",
+		},
+	}
+	if _, err := wt.Revisions(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.ReachableTag("abc"); err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 2 {
+		t.Fatalf("ran %v", commands)
+	}
+	if revset := commands[0][len(commands[0])-1]; revset != "reverse(ancestors(branch(r'literal:stable')))" {
+		t.Errorf("revisions revset %q", revset)
+	}
+	if revset := strings.Join(commands[1], " "); !strings.Contains(revset, "& branch(r'literal:stable')") {
+		t.Errorf("reachable tag ran %q", revset)
+	}
+}
@@ -0,0 +1,402 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/pkg/errors"
+)
+
+// This file contains methods specific to working with bzr.
+
+// Revisions are bzr revision numbers on the mainline of the branch,
+// e.g. "12". Revisions from merged branches have dotted revision
+// numbers, e.g. "10.1.2".
+
+type bzrWorkingTree struct {
+	anyWorkingTree
+}
+
+// bzrTag is a tag and the revision number it is for.
+type bzrTag struct {
+	Name  string
+	Revno string
+}
+
+// bzrLogEntry is a revision from 'bzr log --log-format=long'.
+type bzrLogEntry struct {
+	Revno     string
+	Timestamp time.Time
+
+	// Changes has a line for each file changed, in the form
+	// used by parseChangeLog. It is only set for verbose logs.
+	Changes []string
+}
+
+// bzrTimestampLayout is the layout of timestamps in 'bzr log'.
+const bzrTimestampLayout = "Mon 2006-01-02 15:04:05 -0700"
+
+// bzrStatus maps the file sections of verbose 'bzr log' output to
+// parseChangeLog statuses.
+var bzrStatus = map[string]string{
+	"added:":        "A",
+	"modified:":     "M",
+	"removed:":      "D",
+	"kind changed:": "M",
+}
+
+// WithContext implements the ContextWorkingTree interface.
+func (b *bzrWorkingTree) WithContext(ctx context.Context) WorkingTree {
+	c := *b
+	c.ctx = ctx
+	return &c
+}
+
+// tags returns the tags in the branch, using 'bzr tags'. Tags for
+// revisions not in the branch are omitted.
+func (b *bzrWorkingTree) tags() ([]bzrTag, error) {
+	stdout, stderr, err := b.run("tags")
+	if err != nil {
+		b.showOutput(stdout, stderr)
+		return nil, err
+	}
+	var tags []bzrTag
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[1] == "?" {
+			continue
+		}
+		tags = append(tags, bzrTag{Name: fields[0], Revno: fields[1]})
+	}
+	return tags, scanner.Err()
+}
+
+// log runs 'bzr log --log-format=long' for the mainline revisions
+// in the range revs, and returns them newest first.
+func (b *bzrWorkingTree) log(revs string, verbose bool) ([]bzrLogEntry, error) {
+	args := []string{"log", "--log-format=long", "--levels=1"}
+	if verbose {
+		args = append(args, "--verbose")
+	}
+	if revs != "" {
+		args = append(args, "-r", revs)
+	}
+	stdout, stderr, err := b.run(args...)
+	if err != nil {
+		b.showOutput(stdout, stderr)
+		return nil, err
+	}
+	return parseBzrLog(stdout)
+}
+
+// parseBzrLog parses the output of 'bzr log --log-format=long'.
+func parseBzrLog(stdout *bytes.Buffer) ([]bzrLogEntry, error) {
+	var entries []bzrLogEntry
+	var entry *bzrLogEntry
+	var status string
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "revno: "):
+			revno := strings.Fields(line[len("revno: "):])
+			if len(revno) == 0 {
+				return nil, fmt.Errorf("unexpected log output: %s", line)
+			}
+			entries = append(entries, bzrLogEntry{Revno: revno[0]})
+			entry = &entries[len(entries)-1]
+			status = ""
+		case entry == nil:
+			continue
+		case strings.HasPrefix(line, "timestamp: "):
+			t, err := time.Parse(bzrTimestampLayout, line[len("timestamp: "):])
+			if err != nil {
+				return nil, errors.Wrap(err, "bzr log")
+			}
+			entry.Timestamp = t
+		case strings.HasPrefix(line, "  "):
+			pth := strings.TrimSpace(line)
+			if status == "" || strings.HasSuffix(pth, "/") {
+				// Message text, or a directory
+				continue
+			}
+			if status == "R" {
+				names := strings.SplitN(pth, " => ", 2)
+				if len(names) != 2 {
+					return nil, fmt.Errorf("unexpected log output: %s", line)
+				}
+				entry.Changes = append(entry.Changes,
+					"D\t"+filepath.FromSlash(names[0]),
+					"A\t"+filepath.FromSlash(names[1]))
+				continue
+			}
+			entry.Changes = append(entry.Changes,
+				status+"\t"+filepath.FromSlash(pth))
+		case line == "renamed:":
+			status = "R"
+		default:
+			status = bzrStatus[line]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// bzrRevisionSpec returns the bzr revision specifier for the tag or
+// revision ref.
+func bzrRevisionSpec(ref string) string {
+	if isBzrRevno(ref) {
+		return ref
+	}
+	return "tag:" + ref
+}
+
+// bzrRevnoRE matches a bzr revision number, e.g. "12" or "10.1.2".
+var bzrRevnoRE = regexp.MustCompile(`^[0-9]+(\.[0-9]+\.[0-9]+)?$`)
+
+// isBzrRevno returns true if ref is a revision number rather than a
+// tag.
+func isBzrRevno(ref string) bool {
+	return bzrRevnoRE.MatchString(ref)
+}
+
+// VersionTags returns the tags that are parseable as semantic tags,
+// e.g. v1.1.0.
+func (b *bzrWorkingTree) VersionTags() ([]string, error) {
+	tags, err := b.tags()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+	return versionTags(names), nil
+}
+
+// Revisions returns all mainline revisions, newest first.
+func (b *bzrWorkingTree) Revisions() ([]string, error) {
+	entries, err := b.log("", false)
+	if err != nil {
+		return nil, err
+	}
+	revisions := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		revisions = append(revisions, entry.Revno)
+	}
+	return revisions, nil
+}
+
+// RevisionFromTag returns the revision number for the tag.
+func (b *bzrWorkingTree) RevisionFromTag(tag string) (string, error) {
+	tags, err := b.tags()
+	if err != nil {
+		return "", err
+	}
+	for _, t := range tags {
+		if t.Name == tag {
+			return t.Revno, nil
+		}
+	}
+	return "", ErrorInvalidRef
+}
+
+// TagSync syncs the working tree to the named tag, or to the branch
+// tip if tag is "", using 'bzr update'.
+func (b *bzrWorkingTree) TagSync(tag string) error {
+	args := []string{"update"}
+	if tag != "" {
+		args = append(args, "-r", bzrRevisionSpec(tag))
+	}
+	stdout, stderr, err := b.run(args...)
+	if err != nil {
+		b.showOutput(stdout, stderr)
+	}
+	return err
+}
+
+// RevSync syncs the working tree to the revision rev, using 'bzr
+// update'.
+func (b *bzrWorkingTree) RevSync(rev string) error {
+	return b.TagSync(rev)
+}
+
+// TimeFromRevision returns the time of the revision rev, in UTC.
+func (b *bzrWorkingTree) TimeFromRevision(rev string) (time.Time, error) {
+	var t time.Time
+	entries, err := b.log(rev, false)
+	if err != nil {
+		return t, err
+	}
+	if len(entries) != 1 {
+		return t, fmt.Errorf("bzr log -r %s: %d revisions (expected 1)",
+			rev, len(entries))
+	}
+	return entries[0].Timestamp.UTC(), nil
+}
+
+// ReachableTag returns the tag on the most recent mainline revision
+// no later than rev, preferring semver tags. Only tags the TagMatch
+// accepts are considered.
+func (b *bzrWorkingTree) ReachableTag(rev string) (string, error) {
+	n, err := strconv.Atoi(rev)
+	if err != nil {
+		// Not a mainline revision
+		return "", ErrorInvalidRef
+	}
+	tags, err := b.tags()
+	if err != nil {
+		return "", err
+	}
+	best, bestSemver := -1, -1
+	var tag, semverTag string
+	for _, t := range tags {
+		revno, err := strconv.Atoi(t.Revno)
+		if err != nil || revno > n || !b.tagMatch.matches(t.Name) {
+			continue
+		}
+		if revno > best {
+			best, tag = revno, t.Name
+		}
+		if _, err := semver.NewVersion(t.Name); err == nil && revno > bestSemver {
+			bestSemver, semverTag = revno, t.Name
+		}
+	}
+	switch {
+	case semverTag != "":
+		return semverTag, nil
+	case tag != "":
+		return tag, nil
+	}
+	return "", ErrorVersionNotFound
+}
+
+// FileHashesFromRef returns the file hashes for the given tag or
+// revision ref, from the local or shared cache if possible.
+func (b *bzrWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	return b.cachedFileHashes(ref, subPath,
+		b.remoteFileHashes(b.fileHashesFromRef, b.revisionID))
+}
+
+// revisionID returns the bzr revision ID of the tag or revision ref,
+// using 'bzr revision-info'.
+func (b *bzrWorkingTree) revisionID(ref string) (string, error) {
+	stdout, stderr, err := b.run("revision-info", "-r", bzrRevisionSpec(ref))
+	if err != nil {
+		b.showOutput(stdout, stderr)
+		return "", err
+	}
+	fields := strings.Fields(stdout.String())
+	if len(fields) != 2 {
+		return "", fmt.Errorf("unexpected revision-info output: %q", stdout.String())
+	}
+	return fields[1], nil
+}
+
+// export makes a copy of the files within subPath at the tag or
+// revision ref, using 'bzr export', and returns its directory within
+// dir.
+func (b *bzrWorkingTree) export(dir, ref, subPath string) (string, error) {
+	export := filepath.Join(dir, "export")
+	args := []string{"export", "--format=dir", "-r", bzrRevisionSpec(ref), export}
+	if subPath != "" {
+		args = append(args, filepath.Join(b.Dir, subPath))
+	}
+	stdout, stderr, err := b.run(args...)
+	if err != nil {
+		if strings.Contains(stderr.String(), "No such tag") ||
+			strings.Contains(stderr.String(), "does not exist in branch") {
+			return "", ErrorInvalidRef
+		}
+		b.showOutput(stdout, stderr)
+		return "", err
+	}
+	return export, nil
+}
+
+// fileHashesFromRef returns the file hashes for the given tag or
+// revision ref, using 'bzr export'.
+func (b *bzrWorkingTree) fileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	dir, err := b.env.tempDir("retrodep.")
+	if err != nil {
+		return nil, errors.Wrapf(err, "FileHashesFromRef(%s)", ref)
+	}
+	defer os.RemoveAll(dir)
+
+	export, err := b.export(dir, ref, subPath)
+	if err != nil {
+		return nil, err
+	}
+	return newFileHashes(b.env, b.hasher, export, nil)
+}
+
+// Grep returns the lines of files at the tag or revision ref which
+// match the regular expression pattern, searching a copy made with
+// 'bzr export'.
+func (b *bzrWorkingTree) Grep(ref, pattern, subPath string) ([]GrepMatch, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := b.env.tempDir("retrodep.")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	export, err := b.export(dir, ref, subPath)
+	if err != nil {
+		return nil, err
+	}
+	return grepFiles(export, re)
+}
+
+// LastRevisions returns the most recent mainline revision no later
+// than ref which changed each file within subPath, using 'bzr log
+// --verbose'.
+func (b *bzrWorkingTree) LastRevisions(ref, subPath string) (map[string]string, error) {
+	entries, err := b.log("1.."+bzrRevisionSpec(ref), true)
+	if err != nil {
+		return nil, err
+	}
+	var changeLog bytes.Buffer
+	for _, entry := range entries {
+		fmt.Fprintf(&changeLog, "%s%s\n", changeLogPrefix, entry.Revno)
+		for _, change := range entry.Changes {
+			fmt.Fprintln(&changeLog, change)
+		}
+	}
+	return parseChangeLog(&changeLog, subPath)
+}
+
+// Submodules returns nil, as bzr branches have no submodules.
+func (b *bzrWorkingTree) Submodules(ref string) ([]Submodule, error) {
+	return nil, nil
+}
@@ -0,0 +1,162 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+const bzrLogOutput = `------------------------------------------------------------
+revno: 3 [merge]
+tags: v1.1.0
+committer: Example <example@example.com>
+branch nick: trunk
+timestamp: Wed 2019-01-02 12:00:00 +0000
+message:
+  Merge fixes
+  modified:
+    not a file
+modified:
+  a.go
+removed:
+  sub/old.go
+renamed:
+  c.go => sub/c.go
+------------------------------------------------------------
+revno: 1
+tags: v1.0.0
+committer: Example <example@example.com>
+branch nick: trunk
+timestamp: Tue 2019-01-01 12:00:00 +0100
+message:
+  Initial import
+added:
+  a.go
+  c.go
+  sub/
+  sub/b.go
+  sub/old.go
+`
+
+func newMockBzrWorkingTree() *bzrWorkingTree {
+	return &bzrWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			Dir: "",
+			VCS: vcs.ByCmd(vcsBzr),
+		},
+	}
+}
+
+func TestBzrLog(t *testing.T) {
+	defer mockExecCommand()()
+
+	mockedStdout = bzrLogOutput
+	b := newMockBzrWorkingTree()
+	revs, err := b.Revisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(revs, []string{"3", "1"}) {
+		t.Errorf("Revisions: got %v", revs)
+	}
+
+	last, err := b.LastRevisions("v1.1.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"a.go":     "3",
+		"sub/c.go": "3",
+		"sub/b.go": "1",
+	}
+	if !reflect.DeepEqual(last, expected) {
+		t.Errorf("LastRevisions: got %v, want %v", last, expected)
+	}
+
+	mockedStdout = "revno: 1\ntimestamp: Tue 2019-01-01 12:00:00 +0100\n"
+	tm, err := b.TimeFromRevision("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tm.Equal(time.Date(2019, 1, 1, 11, 0, 0, 0, time.UTC)) {
+		t.Errorf("TimeFromRevision: got %s", tm)
+	}
+
+	mockedStdout = "revno: 1\ntimestamp: yesterday\n"
+	if _, err := b.TimeFromRevision("1"); err == nil {
+		t.Error("invalid timestamp accepted")
+	}
+}
+
+func TestBzrTags(t *testing.T) {
+	defer mockExecCommand()()
+
+	mockedStdout = `nightly              4
+v1.0.0               1
+v1.1.0               3
+v2.0.0               ?
+`
+	b := newMockBzrWorkingTree()
+	tags, err := b.VersionTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"v1.1.0", "v1.0.0"}) {
+		t.Errorf("VersionTags: got %v", tags)
+	}
+
+	if rev, err := b.RevisionFromTag("v1.1.0"); err != nil || rev != "3" {
+		t.Errorf("RevisionFromTag(v1.1.0): got %q, %v", rev, err)
+	}
+	if _, err := b.RevisionFromTag("v2.0.0"); err != ErrorInvalidRef {
+		t.Errorf("RevisionFromTag(v2.0.0): got %v", err)
+	}
+
+	tcs := []struct {
+		rev string
+		tag string
+		err error
+	}{
+		{"5", "v1.1.0", nil},
+		{"2", "v1.0.0", nil},
+		{"0", "", ErrorVersionNotFound},
+		{"2.1.1", "", ErrorInvalidRef},
+	}
+	for _, tc := range tcs {
+		tag, err := b.ReachableTag(tc.rev)
+		if err != tc.err || tag != tc.tag {
+			t.Errorf("ReachableTag(%s): got %q, %v; want %q, %v",
+				tc.rev, tag, err, tc.tag, tc.err)
+		}
+	}
+}
+
+func TestBzrRevisionSpec(t *testing.T) {
+	for ref, spec := range map[string]string{
+		"12":     "12",
+		"10.1.2": "10.1.2",
+		"v1.0.0": "tag:v1.0.0",
+		"1.0":    "tag:1.0",
+	} {
+		if got := bzrRevisionSpec(ref); got != spec {
+			t.Errorf("%s: got %s, want %s", ref, got, spec)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/Masterminds/semver"
+)
+
+// Kinds of Change.
+const (
+	// ChangeAdded means the project is only in the new tree.
+	ChangeAdded = "added"
+
+	// ChangeRemoved means the project is only in the old tree.
+	ChangeRemoved = "removed"
+
+	// ChangeUpgraded means the new version is higher.
+	ChangeUpgraded = "upgraded"
+
+	// ChangeDowngraded means the new version is lower.
+	ChangeDowngraded = "downgraded"
+
+	// ChangeRepatched means the version is the same but the
+	// local files differ.
+	ChangeRepatched = "re-patched"
+
+	// ChangeChanged means the versions differ but cannot be
+	// ordered, for example because one was not identified.
+	ChangeChanged = "changed"
+)
+
+// Change describes how a project differs between two trees.
+type Change struct {
+	// Kind is one of the Change... constants.
+	Kind string
+
+	// Old is the entry from the old tree, or nil if added.
+	Old *LockEntry
+
+	// New is the entry from the new tree, or nil if removed.
+	New *LockEntry
+}
+
+// Dir returns the directory of the project which changed.
+func (c *Change) Dir() string {
+	if c.New != nil {
+		return c.New.Dir
+	}
+	return c.Old.Dir
+}
+
+// Pkg returns the import path of the project which changed, as it
+// is in the new tree if it is there.
+func (c *Change) Pkg() string {
+	if c.New != nil {
+		return c.New.Pkg
+	}
+	return c.Old.Pkg
+}
+
+// MarshalJSON encodes the Change as an object with the kind, import
+// path and directory of the project, and its "old" and "new" lock
+// entries, so that the versions, tags and revisions found in each
+// tree are included.
+func (c *Change) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind string     `json:"kind"`
+		Pkg  string     `json:"pkg"`
+		Dir  string     `json:"dir"`
+		Old  *LockEntry `json:"old,omitempty"`
+		New  *LockEntry `json:"new,omitempty"`
+	}{c.Kind, c.Pkg(), c.Dir(), c.Old, c.New})
+}
+
+// WriteChanges writes changes to w as a JSON object whose "changes"
+// field holds them.
+func WriteChanges(w io.Writer, changes []*Change) error {
+	if changes == nil {
+		changes = make([]*Change, 0)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Changes []*Change `json:"changes"`
+	}{changes})
+}
+
+// compareEntries returns the Change between two entries for the same
+// directory, or nil if there is no difference.
+func compareEntries(from, to *LockEntry) *Change {
+	c := &Change{Old: from, New: to}
+	if from.Ver == to.Ver {
+		if from.Digest == to.Digest {
+			return nil
+		}
+		if from.Ver == "" {
+			c.Kind = ChangeChanged
+		} else {
+			c.Kind = ChangeRepatched
+		}
+		return c
+	}
+
+	c.Kind = ChangeChanged
+	oldVer, err := semver.NewVersion(from.Ver)
+	if err != nil {
+		return c
+	}
+	newVer, err := semver.NewVersion(to.Ver)
+	if err != nil {
+		return c
+	}
+	switch cmp := newVer.Compare(oldVer); {
+	case cmp > 0:
+		c.Kind = ChangeUpgraded
+	case cmp < 0:
+		c.Kind = ChangeDowngraded
+	}
+	return c
+}
+
+// CompareLocks returns the differences between the projects in from
+// and to, matched by directory and sorted by it.
+func CompareLocks(from, to *Lock) []*Change {
+	oldEntries := make(map[string]*LockEntry)
+	for _, entry := range from.Projects {
+		oldEntries[entry.Dir] = entry
+	}
+
+	var changes []*Change
+	seen := make(map[string]bool)
+	for _, entry := range to.Projects {
+		seen[entry.Dir] = true
+		oldEntry, ok := oldEntries[entry.Dir]
+		if !ok {
+			changes = append(changes, &Change{
+				Kind: ChangeAdded,
+				New:  entry,
+			})
+			continue
+		}
+		if c := compareEntries(oldEntry, entry); c != nil {
+			changes = append(changes, c)
+		}
+	}
+	for _, entry := range from.Projects {
+		if !seen[entry.Dir] {
+			changes = append(changes, &Change{
+				Kind: ChangeRemoved,
+				Old:  entry,
+			})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Dir() < changes[j].Dir()
+	})
+	return changes
+}
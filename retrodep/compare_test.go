@@ -0,0 +1,135 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"testing"
+)
+
+func lockEntry(dir, ver, digest string) *LockEntry {
+	return &LockEntry{
+		Dir:       dir,
+		Reference: Reference{Pkg: dir, Ver: ver},
+		Digest:    digest,
+	}
+}
+
+func TestCompareLocks(t *testing.T) {
+	from := &Lock{Projects: []*LockEntry{
+		lockEntry(".", "v1.0.0", "a"),
+		lockEntry("vendor/same", "v1.0.0", "b"),
+		lockEntry("vendor/up", "v1.0.0", "c"),
+		lockEntry("vendor/down", "v1.2.0", "d"),
+		lockEntry("vendor/patched", "v1.0.0", "e"),
+		lockEntry("vendor/gone", "v1.0.0", "f"),
+		lockEntry("vendor/unknown", "", "g"),
+	}}
+	to := &Lock{Projects: []*LockEntry{
+		lockEntry(".", "v1.1.0", "A"),
+		lockEntry("vendor/same", "v1.0.0", "b"),
+		lockEntry("vendor/up", "v1.0.1-0.20190101000000-0123456789ab", "C"),
+		lockEntry("vendor/down", "v1.1.0", "D"),
+		lockEntry("vendor/patched", "v1.0.0", "E"),
+		lockEntry("vendor/new", "v0.1.0", "h"),
+		lockEntry("vendor/unknown", "v0.1.0", "G"),
+	}}
+
+	expected := []struct {
+		dir  string
+		kind string
+	}{
+		{".", ChangeUpgraded},
+		{"vendor/down", ChangeDowngraded},
+		{"vendor/gone", ChangeRemoved},
+		{"vendor/new", ChangeAdded},
+		{"vendor/patched", ChangeRepatched},
+		{"vendor/unknown", ChangeChanged},
+		{"vendor/up", ChangeUpgraded},
+	}
+
+	changes := CompareLocks(from, to)
+	if len(changes) != len(expected) {
+		for _, c := range changes {
+			t.Logf("%s %s", c.Kind, c.Dir())
+		}
+		t.Fatalf("got %d changes, want %d", len(changes), len(expected))
+	}
+	for i, c := range changes {
+		if c.Dir() != expected[i].dir || c.Kind != expected[i].kind {
+			t.Errorf("%d: got %s %s, want %s %s", i,
+				c.Kind, c.Dir(), expected[i].kind, expected[i].dir)
+		}
+	}
+}
+
+func TestWriteChanges(t *testing.T) {
+	pseudo := lockEntry("vendor/up", "v1.0.1-0.20190102030405-0123456789ab", "b")
+	pseudo.Rev = "0123456789abcdef"
+	changes := CompareLocks(
+		&Lock{Projects: []*LockEntry{lockEntry("vendor/up", "v1.0.0", "a")}},
+		&Lock{Projects: []*LockEntry{pseudo, lockEntry("vendor/new", "v0.1.0", "c")}},
+	)
+	var buf bytes.Buffer
+	if err := WriteChanges(&buf, changes); err != nil {
+		t.Fatal(err)
+	}
+	exp := `{
+  "changes": [
+    {
+      "kind": "added",
+      "pkg": "vendor/new",
+      "dir": "vendor/new",
+      "new": {
+        "dir": "vendor/new",
+        "pkg": "vendor/new",
+        "ver": "v0.1.0",
+        "digest": "c"
+      }
+    },
+    {
+      "kind": "upgraded",
+      "pkg": "vendor/up",
+      "dir": "vendor/up",
+      "old": {
+        "dir": "vendor/up",
+        "pkg": "vendor/up",
+        "ver": "v1.0.0",
+        "digest": "a"
+      },
+      "new": {
+        "dir": "vendor/up",
+        "pkg": "vendor/up",
+        "rev": "0123456789abcdef",
+        "ver": "v1.0.1-0.20190102030405-0123456789ab",
+        "digest": "b"
+      }
+    }
+  ]
+}
+`
+	if buf.String() != exp {
+		t.Errorf("got %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteChanges(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "{\n  \"changes\": []\n}\n" {
+		t.Errorf("no changes: got %q", buf.String())
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+// This file contains the limits on how much work of each kind is
+// done at once. Cloning is limited by the network and hashing by
+// the CPU, so they are limited separately.
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Concurrency holds the number of operations of each kind which may
+// run at once. A zero field means the default is used.
+type Concurrency struct {
+	// Clones is the number of working trees which may be created
+	// at once, by NewWorkingTree.
+	Clones int
+
+	// Hashers is the number of files which may be hashed at once,
+	// by NewFileHashes.
+	Hashers int
+}
+
+// DefaultConcurrency returns the limits used unless others are set:
+// one hasher per CPU Go may use (GOMAXPROCS), and twice as many
+// clones, since clones mostly wait for the network, but between 2
+// and 8 of them so as not to overload the servers.
+func DefaultConcurrency() Concurrency {
+	procs := runtime.GOMAXPROCS(0)
+	clones := 2 * procs
+	if clones < 2 {
+		clones = 2
+	} else if clones > 8 {
+		clones = 8
+	}
+	return Concurrency{Clones: clones, Hashers: procs}
+}
+
+// limiter allows a limited number of holders at once.
+type limiter chan struct{}
+
+func (l limiter) acquire() {
+	l <- struct{}{}
+}
+
+func (l limiter) release() {
+	<-l
+}
+
+var (
+	concurrencyMu sync.Mutex
+	cloneLimit    limiter
+	hashLimit     limiter
+)
+
+func init() {
+	SetConcurrency(Concurrency{})
+}
+
+// SetConcurrency sets the number of operations of each kind which may
+// run at once, across all working trees. Operations already waiting
+// keep the previous limits.
+func SetConcurrency(c Concurrency) {
+	defaults := DefaultConcurrency()
+	if c.Clones <= 0 {
+		c.Clones = defaults.Clones
+	}
+	if c.Hashers <= 0 {
+		c.Hashers = defaults.Hashers
+	}
+	concurrencyMu.Lock()
+	defer concurrencyMu.Unlock()
+	cloneLimit = make(limiter, c.Clones)
+	hashLimit = make(limiter, c.Hashers)
+}
+
+// cloneLimiter returns the limiter for creating working trees.
+func cloneLimiter() limiter {
+	concurrencyMu.Lock()
+	defer concurrencyMu.Unlock()
+	return cloneLimit
+}
+
+// hashLimiter returns the limiter for hashing files.
+func hashLimiter() limiter {
+	concurrencyMu.Lock()
+	defer concurrencyMu.Unlock()
+	return hashLimit
+}
@@ -0,0 +1,137 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDefaultConcurrency(t *testing.T) {
+	c := DefaultConcurrency()
+	if c.Hashers != runtime.GOMAXPROCS(0) {
+		t.Errorf("hashers: got %d", c.Hashers)
+	}
+	if c.Clones < 2 || c.Clones > 8 {
+		t.Errorf("clones: got %d", c.Clones)
+	}
+
+	defer SetConcurrency(Concurrency{})
+	SetConcurrency(Concurrency{Hashers: 3})
+	if cap(hashLimiter()) != 3 || cap(cloneLimiter()) != c.Clones {
+		t.Errorf("got %d hashers, %d clones", cap(hashLimiter()), cap(cloneLimiter()))
+	}
+}
+
+// countingHasher is a mock Hasher recording how many files it hashes
+// at once.
+type countingHasher struct {
+	mu      sync.Mutex
+	current int
+	max     int
+	fail    string
+}
+
+func (h *countingHasher) Hash(relativePath, absPath string) (FileHash, error) {
+	h.mu.Lock()
+	h.current++
+	if h.current > h.max {
+		h.max = h.current
+	}
+	h.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	h.mu.Lock()
+	h.current--
+	h.mu.Unlock()
+	if relativePath == h.fail {
+		return FileHash(""), errors.New("failed: " + relativePath)
+	}
+	return FileHash("hash:" + relativePath), nil
+}
+
+func TestHashFiles(t *testing.T) {
+	defer SetConcurrency(Concurrency{})
+	SetConcurrency(Concurrency{Hashers: 2})
+
+	var files []hashJob
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("f%d", i)
+		files = append(files, hashJob{relativePath: name, path: "/" + name})
+	}
+	h := &countingHasher{}
+	fileHashes, err := hashFiles(hashLimiter(), h, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, fileHash := range fileHashes {
+		if fileHash != FileHash("hash:"+files[i].relativePath) {
+			t.Errorf("%d: got %s", i, fileHash)
+		}
+	}
+	if h.max < 1 || h.max > 2 {
+		t.Errorf("hashed %d files at once", h.max)
+	}
+
+	h = &countingHasher{fail: "f5"}
+	if _, err := hashFiles(hashLimiter(), h, files); err == nil || err.Error() != "failed: f5" {
+		t.Errorf("got %v", err)
+	}
+	if fileHashes, err := hashFiles(hashLimiter(), h, nil); err != nil || len(fileHashes) != 0 {
+		t.Errorf("no files: got %v,%v", fileHashes, err)
+	}
+}
+
+// BenchmarkNewFileHashes compares hashing a tree of files one at a
+// time with hashing them four at a time.
+func BenchmarkNewFileHashes(b *testing.B) {
+	dir, err := ioutil.TempDir("", "retrodep-bench.")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := bytes.Repeat([]byte("package bench\n"), 4096)
+	for i := 0; i < 500; i++ {
+		pth := filepath.Join(dir, fmt.Sprintf("d%d", i%10), fmt.Sprintf("f%d.go", i))
+		if err := os.MkdirAll(filepath.Dir(pth), 0777); err != nil {
+			b.Fatal(err)
+		}
+		if err := ioutil.WriteFile(pth, data, 0666); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	defer SetConcurrency(Concurrency{})
+	for _, hashers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("hashers=%d", hashers), func(b *testing.B) {
+			SetConcurrency(Concurrency{Hashers: hashers})
+			for i := 0; i < b.N; i++ {
+				if _, err := NewFileHashes(&sha256Hasher{}, dir, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds the settings for projects needing special treatment,
+// keyed by import path prefix. It gathers in one file what can
+// otherwise be given in repository roots, tag prefixes and branches
+// files.
+type Config struct {
+	Projects map[string]ProjectConfig `yaml:"projects"`
+}
+
+// ProjectConfig holds the settings for the projects below an import
+// path prefix. Empty settings are left alone.
+type ProjectConfig struct {
+	// Repo is the repository URL to use, with VCS its VCS
+	// command ("git" if empty).
+	Repo string `yaml:"repo,omitempty"`
+	VCS  string `yaml:"vcs,omitempty"`
+
+	// TagPrefix is as for TagPrefix.
+	TagPrefix string `yaml:"tag-prefix,omitempty"`
+
+	// Branch is as for Branch.
+	Branch string `yaml:"branch,omitempty"`
+
+	// Exclude holds patterns, in gitignore syntax, for paths to
+	// leave out of the file hashes compared.
+	Exclude []string `yaml:"exclude,omitempty"`
+
+	// Credentials is the name of the credentials to use for the
+	// repository, as for UseCredentials.
+	Credentials string `yaml:"credentials,omitempty"`
+
+	// Prefer holds tags or revisions to try first, as for Hints,
+	// for the project at exactly this import path. A tag chosen
+	// from several matching identically is remembered here.
+	Prefer []string `yaml:"prefer,omitempty"`
+
+	// Fork is true if Repo is a fork of the canonical repository
+	// for the import path, rather than a mirror, so versions found
+	// in it need go.mod replace directives.
+	Fork bool `yaml:"fork,omitempty"`
+}
+
+// ReadConfig parses a YAML configuration file from r, such as:
+//
+//	projects:
+//	  github.com/example/name:
+//	    repo: https://git.example.com/mirrors/name.git
+//	    tag-prefix: api/
+//	    branch: release-1.2
+//	    exclude: [testdata/]
+//	    credentials: mirrors
+//	    prefer: [v1.2.0]
+//
+// Unknown settings are errors.
+func ReadConfig(r io.Reader) (*Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, err
+	}
+	for prefix, p := range config.Projects {
+		if p.Repo == "" && p.VCS != "" {
+			return nil, fmt.Errorf("%s: vcs without repo", prefix)
+		}
+		if p.Repo == "" && p.Fork {
+			return nil, fmt.Errorf("%s: fork without repo", prefix)
+		}
+		if p.VCS != "" && vcsByCmd(p.VCS) == nil {
+			return nil, fmt.Errorf("%s: %s: %s", prefix, ErrorUnknownVCS, p.VCS)
+		}
+	}
+	return &config, nil
+}
+
+// RepoRoots returns the repositories given for import path prefixes,
+// for use as a Resolver.
+func (c *Config) RepoRoots() StaticResolver {
+	s := make(StaticResolver)
+	for prefix, p := range c.Projects {
+		if p.Repo == "" {
+			continue
+		}
+		cmd := p.VCS
+		if cmd == "" {
+			cmd = vcsGit
+		}
+		s[prefix] = &vcs.RepoRoot{
+			VCS:  vcsByCmd(cmd),
+			Repo: p.Repo,
+			Root: prefix,
+		}
+	}
+	return s
+}
+
+// TagPrefixes returns the tag prefixes given for import path
+// prefixes.
+func (c *Config) TagPrefixes() TagPrefixes {
+	return TagPrefixes(c.settings(func(p ProjectConfig) string { return p.TagPrefix }))
+}
+
+// Branches returns the branches given for import path prefixes.
+func (c *Config) Branches() Branches {
+	return Branches(c.settings(func(p ProjectConfig) string { return p.Branch }))
+}
+
+// CredentialNames maps import path prefixes to the names of the
+// credentials used for the repositories of the projects below them.
+type CredentialNames map[string]string
+
+// For returns the name of the credentials for the project at
+// importPath, from the longest import path prefix matching it, or ""
+// if there is none.
+func (n CredentialNames) For(importPath string) string {
+	return longestPrefix(n, importPath)
+}
+
+// Credentials returns the names of the credentials given for import
+// path prefixes.
+func (c *Config) Credentials() CredentialNames {
+	return CredentialNames(c.settings(func(p ProjectConfig) string { return p.Credentials }))
+}
+
+// HashExcludes returns the patterns given for import path prefixes.
+func (c *Config) HashExcludes() (ProjectHashExcludes, error) {
+	excludes := make(ProjectHashExcludes)
+	for prefix, p := range c.Projects {
+		if len(p.Exclude) == 0 {
+			continue
+		}
+		ig, err := ReadIgnore(strings.NewReader(strings.Join(p.Exclude, "\n")))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", prefix, err)
+		}
+		excludes[prefix] = ig
+	}
+	return excludes, nil
+}
+
+// Forks returns the import path prefixes whose repositories are
+// forks, for GoSource.AddForks, in sorted order.
+func (c *Config) Forks() []string {
+	var forks []string
+	for prefix, p := range c.Projects {
+		if p.Fork {
+			forks = append(forks, prefix)
+		}
+	}
+	sort.Strings(forks)
+	return forks
+}
+
+// Hints returns the tags or revisions to try first for import paths.
+func (c *Config) Hints() Hints {
+	hints := make(Hints)
+	for importPath, p := range c.Projects {
+		if len(p.Prefer) > 0 {
+			hints[importPath] = p.Prefer
+		}
+	}
+	return hints
+}
+
+// Prefer sets the tag or revision to try first for the project at
+// importPath, replacing any set before.
+func (c *Config) Prefer(importPath, ref string) {
+	if c.Projects == nil {
+		c.Projects = make(map[string]ProjectConfig)
+	}
+	p := c.Projects[importPath]
+	p.Prefer = []string{ref}
+	c.Projects[importPath] = p
+}
+
+// Write writes the configuration to w in YAML, in the format read by
+// ReadConfig. Comments in the file it was read from are not kept.
+func (c *Config) Write(w io.Writer) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// settings returns the non-empty values of setting for each import
+// path prefix.
+func (c *Config) settings(setting func(ProjectConfig) string) map[string]string {
+	m := make(map[string]string)
+	for prefix, p := range c.Projects {
+		if value := setting(p); value != "" {
+			m[prefix] = value
+		}
+	}
+	return m
+}
@@ -0,0 +1,198 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+projects:
+  github.com/example/name:
+    repo: https://git.example.com/mirrors/name.git
+    tag-prefix: api/
+    branch: release-1.2
+    exclude: [testdata/, "*.md"]
+    credentials: mirrors
+    prefer: [v1.2.0]
+  example.com/hg:
+    repo: https://hg.example.com/hg
+    vcs: hg
+  github.com/example/other:
+    branch: "*"
+`
+
+func TestReadConfig(t *testing.T) {
+	config, err := ReadConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := config.RepoRoots().RepoRootForImportPath("github.com/example/name/pkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.VCS.Cmd != vcsGit || root.Repo != "https://git.example.com/mirrors/name.git" || root.Root != "github.com/example/name" {
+		t.Errorf("got %v %s %s", root.VCS.Cmd, root.Repo, root.Root)
+	}
+	root, err = config.RepoRoots().RepoRootForImportPath("example.com/hg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.VCS.Cmd != vcsHg {
+		t.Errorf("hg: got %s", root.VCS.Cmd)
+	}
+	if _, err := config.RepoRoots().RepoRootForImportPath("github.com/example/other"); err != ErrorNoRepoRoot {
+		t.Errorf("other: got %v", err)
+	}
+
+	if prefix := config.TagPrefixes().For("github.com/example/name/pkg"); prefix != "api/" {
+		t.Errorf("tag prefix: got %q", prefix)
+	}
+	branches := config.Branches()
+	if !reflect.DeepEqual(branches, Branches{
+		"github.com/example/name":  "release-1.2",
+		"github.com/example/other": AllBranches,
+	}) {
+		t.Errorf("branches: got %v", branches)
+	}
+	if name := config.Credentials().For("github.com/example/name"); name != "mirrors" {
+		t.Errorf("credentials: got %q", name)
+	}
+
+	excludes, err := config.HashExcludes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(excludes) != 1 {
+		t.Errorf("excludes: got %v", excludes)
+	}
+	ig := excludes.For("github.com/example/name")
+	if !ig.MatchPath("testdata/a.golden") || !ig.MatchPath("README.md") || ig.MatchPath("a.go") {
+		t.Errorf("excludes: got %v", ig)
+	}
+	if ig := excludes.For("github.com/example/other"); ig != nil {
+		t.Errorf("other excludes: got %v", ig)
+	}
+}
+
+func TestConfigPrefer(t *testing.T) {
+	config, err := ReadConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hints := config.Hints(); !reflect.DeepEqual(hints, Hints{
+		"github.com/example/name": {"v1.2.0"},
+	}) {
+		t.Errorf("hints: got %v", hints)
+	}
+
+	config.Prefer("github.com/example/name", "v1.2.1")
+	config.Prefer("github.com/example/new", "v0.1.0")
+	var buf bytes.Buffer
+	if err := config.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), `""`) {
+		t.Errorf("empty settings written:\n%s", buf.String())
+	}
+	written, err := ReadConfig(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hints := written.Hints(); !reflect.DeepEqual(hints, Hints{
+		"github.com/example/name": {"v1.2.1"},
+		"github.com/example/new":  {"v0.1.0"},
+	}) {
+		t.Errorf("written hints: got %v", hints)
+	}
+	if prefix := written.TagPrefixes().For("github.com/example/name"); prefix != "api/" {
+		t.Errorf("written tag prefix: got %q", prefix)
+	}
+
+	empty := &Config{}
+	empty.Prefer("github.com/example/name", "v1.0.0")
+	if hints := empty.Hints(); len(hints) != 1 {
+		t.Errorf("empty: got %v", hints)
+	}
+}
+
+func TestConfigForks(t *testing.T) {
+	config, err := ReadConfig(strings.NewReader(`projects:
+  example.com/b:
+    repo: https://github.com/me/b
+    fork: true
+  example.com/c:
+    repo: https://mirror.example.com/c
+  example.com/a:
+    repo: https://github.com/me/a
+    fork: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forks := config.Forks(); !reflect.DeepEqual(forks, []string{"example.com/a", "example.com/b"}) {
+		t.Errorf("got %v", forks)
+	}
+}
+
+func TestReadConfigErrors(t *testing.T) {
+	for _, config := range []string{
+		"projects:\n  example.com/a:\n    tag_prefix: v\n",
+		"projects:\n  example.com/a:\n    repo: https://example.com/a\n    vcs: cvs\n",
+		"projects:\n  example.com/a:\n    vcs: git\n",
+		"projects:\n  example.com/a:\n    fork: true\n",
+		"project: {}\n",
+	} {
+		if _, err := ReadConfig(strings.NewReader(config)); err == nil {
+			t.Errorf("%q: no error", config)
+		}
+	}
+}
+
+func TestUseCredentials(t *testing.T) {
+	defer func() {
+		hostCredentials = make(CredentialsConfig)
+		credentialsCache = make(map[string]*Credentials)
+		repoCredentialNames = make(map[string]string)
+	}()
+	SetHostCredentials(CredentialsConfig{
+		"git.example.com": {Token: "host"},
+		"mirrors":         {Username: "ci", Password: "pw", SSHKey: "/keys/mirrors"},
+	})
+
+	const repo = "https://git.example.com/mirrors/name.git"
+	if err := useRepoCredentials(repo, "unknown"); err == nil {
+		t.Error("unknown: no error")
+	}
+	if err := useRepoCredentials(repo, "mirrors"); err != nil {
+		t.Fatal(err)
+	}
+	expected := gitHeaderEnv("git.example.com", "Y2k6cHc=")
+	if env := gitAuthEnv(repo); !reflect.DeepEqual(env, expected) {
+		t.Errorf("got %v, want %v", env, expected)
+	}
+	expected = gitHeaderEnv("git.example.com", "eC1hY2Nlc3MtdG9rZW46aG9zdA==")
+	if env := gitAuthEnv("https://git.example.com/other.git"); !reflect.DeepEqual(env, expected) {
+		t.Errorf("other: got %v, want %v", env, expected)
+	}
+	if creds, host, ok := hgCredentials(repo); !ok || host != "git.example.com" || creds.Username != "ci" {
+		t.Errorf("hg: got %v, %q, %t", creds, host, ok)
+	}
+}
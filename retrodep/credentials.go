@@ -0,0 +1,611 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+// This file contains support for finding credentials for HTTPS
+// servers in the same places git does, and for credentials and SSH
+// keys configured for each host.
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Credentials are a username and password for a server.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Netrc maps machine names from a .netrc file to their credentials.
+// The credentials for the "default" entry have the key "".
+type Netrc map[string]Credentials
+
+// ReadNetrc parses a .netrc file from r. Macro definitions are
+// skipped.
+func ReadNetrc(r io.Reader) (Netrc, error) {
+	netrc := make(Netrc)
+	scanner := bufio.NewScanner(r)
+	var tokens []string
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// A macro definition ends at a blank line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		for i, field := range fields {
+			if field == "macdef" {
+				fields = fields[:i]
+				inMacro = true
+				break
+			}
+		}
+		tokens = append(tokens, fields...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	machine, inMachine := "", false
+	var creds Credentials
+	for i := 0; i < len(tokens); i++ {
+		next := func() string {
+			if i+1 < len(tokens) {
+				i++
+				return tokens[i]
+			}
+			return ""
+		}
+		switch tokens[i] {
+		case "machine", "default":
+			if inMachine {
+				netrc.add(machine, creds)
+			}
+			machine, inMachine = "", true
+			if tokens[i] == "machine" {
+				machine = next()
+			}
+			creds = Credentials{}
+		case "login":
+			creds.Username = next()
+		case "password":
+			creds.Password = next()
+		case "account":
+			next()
+		}
+	}
+	if inMachine {
+		netrc.add(machine, creds)
+	}
+	return netrc, nil
+}
+
+// add adds creds for machine unless there are already credentials for
+// it: the first entry for a machine is used.
+func (n Netrc) add(machine string, creds Credentials) {
+	if _, ok := n[machine]; !ok {
+		n[machine] = creds
+	}
+}
+
+// netrcFile is the .netrc file set with SetNetrcFile, or "" to use
+// the user's.
+var netrcFile string
+
+// SetNetrcFile makes credentials be read from the .netrc file path
+// rather than the user's own.
+func SetNetrcFile(path string) {
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+	netrcFile = path
+	credentialsCache = make(map[string]*Credentials)
+}
+
+// netrcPath returns the path of the .netrc file set with
+// SetNetrcFile, or else of the user's .netrc file, which is named by
+// NETRC if it is set.
+func netrcPath() string {
+	if netrcFile != "" {
+		return netrcFile
+	}
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// netrcCredentials returns the credentials for host from the user's
+// .netrc file.
+func netrcCredentials(host string) (Credentials, bool) {
+	path := netrcPath()
+	if path == "" {
+		return Credentials{}, false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return Credentials{}, false
+	}
+	defer f.Close()
+	netrc, err := ReadNetrc(f)
+	if err != nil {
+		log.Debugf("%s: %s", path, err)
+		return Credentials{}, false
+	}
+	if creds, ok := netrc[host]; ok {
+		return creds, true
+	}
+	creds, ok := netrc[""]
+	return creds, ok
+}
+
+// gitCredentials returns the credentials for the HTTPS server host
+// from the git credential helpers configured, using 'git credential
+// fill'. The user is never prompted.
+func gitCredentials(host string) (Credentials, bool) {
+	p := execCommand(vcsGit, "credential", "fill")
+	if p.Env == nil {
+		p.Env = commandEnv()
+	}
+	p.Env = append(p.Env, "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	p.Stdin = strings.NewReader("protocol=https\nhost=" + host + "\n\n")
+	var stdout strings.Builder
+	p.Stdout = &stdout
+	if err := runCommand(p); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			log.Debugf("git credential fill: %s", err)
+		}
+		return Credentials{}, false
+	}
+
+	var creds Credentials
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if value := strings.TrimPrefix(line, "username="); value != line {
+			creds.Username = value
+		} else if value := strings.TrimPrefix(line, "password="); value != line {
+			creds.Password = value
+		}
+	}
+	return creds, creds.Username != "" || creds.Password != ""
+}
+
+// lookupCredentials is the function used to find credentials for a
+// host, and is a variable so it can be replaced for tests.
+var lookupCredentials = func(host string) (Credentials, bool) {
+	if creds, ok := configuredCredentials(host).credentials(); ok {
+		return creds, true
+	}
+	if creds, ok := netrcCredentials(host); ok {
+		return creds, true
+	}
+	return gitCredentials(host)
+}
+
+var (
+	credentialsMu    sync.Mutex
+	credentialsCache = make(map[string]*Credentials)
+)
+
+// CredentialsFor returns the credentials for the HTTPS server host
+// (which may include a port) set with SetHostCredentials, or found in
+// the user's .netrc file, or otherwise from the git credential
+// helpers configured, or false if there are none. Results are
+// remembered for each host.
+func CredentialsFor(host string) (Credentials, bool) {
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+	if creds, ok := credentialsCache[host]; ok {
+		if creds == nil {
+			return Credentials{}, false
+		}
+		return *creds, true
+	}
+	creds, ok := lookupCredentials(host)
+	if !ok {
+		credentialsCache[host] = nil
+		return Credentials{}, false
+	}
+	credentialsCache[host] = &creds
+	return creds, true
+}
+
+// credentialsTransport is an http.RoundTripper adding basic
+// authentication from CredentialsFor to HTTPS requests.
+type credentialsTransport struct {
+	base http.RoundTripper
+}
+
+// NewCredentialsTransport returns an http.RoundTripper which adds
+// the credentials from CredentialsFor to HTTPS requests made with
+// base, unless they already have an Authorization header. If base is
+// nil, http.DefaultTransport is used.
+func NewCredentialsTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &credentialsTransport{base: base}
+}
+
+func (t *credentialsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" || req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+	creds, ok := CredentialsFor(req.URL.Host)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+
+	// A RoundTripper must not modify the request.
+	authReq := req.Clone(req.Context())
+	authReq.SetBasicAuth(creds.Username, creds.Password)
+	return t.base.RoundTrip(authReq)
+}
+
+// hgAuthArgs returns the 'hg' options giving the SSH key for the
+// SSH repository repo, if one is configured.
+func hgAuthArgs(repo string) []string {
+	host, https := repoHost(repo)
+	if host == "" || https {
+		return nil
+	}
+	if hc, _ := repoCredentials(repo, host); hc.SSHKey != "" {
+		return []string{"--config", "ui.ssh=" + sshCommand(hc.SSHKey)}
+	}
+	return nil
+}
+
+// hgCredentials returns the credentials for the HTTPS repository
+// repo, and its host. Mercurial's own configuration does not read
+// .netrc or git's credential helpers.
+func hgCredentials(repo string) (Credentials, string, bool) {
+	host, https := repoHost(repo)
+	if host == "" || !https {
+		return Credentials{}, "", false
+	}
+	hc, named := repoCredentials(repo, host)
+	var creds Credentials
+	var ok bool
+	if named {
+		creds, ok = hc.credentials()
+	} else {
+		creds, ok = CredentialsFor(host)
+	}
+	return creds, host, ok
+}
+
+// hgAuthEnv returns the environment supplying the credentials for
+// the HTTPS repository repo to 'hg', if there are any, and a
+// function removing the file written for them.
+//
+// hg cannot read a password from the environment, and one given
+// with --config could be read by other users from the process
+// list, so it is written to an hgrc only the user can read, which
+// HGRCPATH adds to the files hg reads.
+func hgAuthEnv(repo string) ([]string, func(), error) {
+	creds, host, ok := hgCredentials(repo)
+	if !ok {
+		return nil, nil, nil
+	}
+	if strings.ContainsAny(creds.Username+creds.Password, "\r\n") {
+		return nil, nil, fmt.Errorf("%s: credentials cannot be given to hg", host)
+	}
+	f, err := ioutil.TempFile(tempDirInUse(), "retrodep-hgrc.")
+	if err != nil {
+		return nil, nil, err
+	}
+	remove := func() { os.Remove(f.Name()) }
+	_, err = fmt.Fprintf(f, "[auth]\nretrodep.prefix = https://%s\nretrodep.username = %s\nretrodep.password = %s\n",
+		host, creds.Username, creds.Password)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		remove()
+		return nil, nil, err
+	}
+	paths := append(hgrcPath(), f.Name())
+	return []string{"HGRCPATH=" + strings.Join(paths, string(filepath.ListSeparator))}, remove, nil
+}
+
+// hgrcPath returns the files and directories hg reads its
+// configuration from: those in HGRCPATH if it is passed on to
+// subprocesses, or else the system and user defaults.
+func hgrcPath() []string {
+	for _, kv := range commandEnv() {
+		if strings.HasPrefix(kv, "HGRCPATH=") {
+			return filepath.SplitList(kv[len("HGRCPATH="):])
+		}
+	}
+	home, _ := os.UserHomeDir()
+	if runtime.GOOS == "windows" {
+		return []string{
+			filepath.Join(home, "mercurial.ini"),
+			filepath.Join(home, ".hgrc"),
+		}
+	}
+	paths := []string{"/etc/mercurial/hgrc", "/etc/mercurial/hgrc.d"}
+	if home != "" {
+		paths = append(paths, filepath.Join(home, ".hgrc"))
+	}
+	if config, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(config, "hg", "hgrc"))
+	}
+	return paths
+}
+
+// authEnv returns the environment supplying the credentials for
+// repo to the VCS command vcsCmd, and a function, if not nil,
+// removing any file written for them once they are no longer
+// needed.
+func authEnv(vcsCmd, repo string) ([]string, func(), error) {
+	switch vcsCmd {
+	case vcsGit:
+		return gitAuthEnv(repo), nil, nil
+	case vcsHg:
+		return hgAuthEnv(repo)
+	}
+	return nil, nil, nil
+}
+
+// gitAuthArgs returns the git options giving the SSH key for the
+// SSH repository repo, if one is configured.
+func gitAuthArgs(repo string) []string {
+	host, https := repoHost(repo)
+	if host == "" || https {
+		return nil
+	}
+	if hc, _ := repoCredentials(repo, host); hc.SSHKey != "" {
+		return []string{"-c", "core.sshCommand=" + sshCommand(hc.SSHKey)}
+	}
+	return nil
+}
+
+// gitAuthEnv returns the environment supplying the credentials set
+// with SetHostCredentials for the HTTPS repository repo to git.
+// Other credentials are found by git itself. The header is set with
+// GIT_CONFIG_COUNT rather than "-c", which other users could read
+// from the process list.
+func gitAuthEnv(repo string) []string {
+	host, https := repoHost(repo)
+	if host == "" || !https {
+		return nil
+	}
+	hc, _ := repoCredentials(repo, host)
+	creds, ok := hc.credentials()
+	if !ok {
+		return nil
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password))
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.https://" + host + "/.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic " + auth,
+	}
+}
+
+// repoHost returns the host of the repository URL repo, and whether
+// it is an HTTPS URL, or "" if it is not a URL with credentials to
+// supply. Besides URLs, the scp-like "user@host:path" form is
+// accepted for SSH.
+func repoHost(repo string) (string, bool) {
+	if !strings.Contains(repo, "://") {
+		if at := strings.Index(repo, "@"); at >= 0 {
+			if colon := strings.Index(repo[at:], ":"); colon > 0 {
+				return repo[at+1 : at+colon], false
+			}
+		}
+		return "", false
+	}
+	u, err := url.Parse(repo)
+	if err != nil {
+		return "", false
+	}
+	switch u.Scheme {
+	case "https":
+		if u.User != nil {
+			return "", false
+		}
+		return u.Host, true
+	case "ssh", "git+ssh", "ssh+git":
+		return u.Hostname(), false
+	}
+	return "", false
+}
+
+// sshCommand returns the ssh command line using only the private key
+// file key.
+func sshCommand(key string) string {
+	return "ssh -i '" + strings.Replace(key, "'", `'\''`, -1) + "' -o IdentitiesOnly=yes"
+}
+
+// HostCredentials are the credentials configured for a host.
+type HostCredentials struct {
+	// Username and Password are used for HTTPS.
+	Username string
+	Password string
+
+	// Token is an API or access token used as the password for
+	// HTTPS, with the username "x-access-token" unless Username
+	// is set.
+	Token string
+
+	// SSHKey is the path of the private key file used for SSH.
+	SSHKey string
+}
+
+// credentials returns the HTTPS credentials in hc.
+func (hc HostCredentials) credentials() (Credentials, bool) {
+	creds := Credentials{Username: hc.Username, Password: hc.Password}
+	if hc.Token != "" {
+		creds.Password = hc.Token
+		if creds.Username == "" {
+			creds.Username = "x-access-token"
+		}
+	}
+	return creds, creds.Username != "" || creds.Password != ""
+}
+
+// CredentialsConfig maps hosts (which may include a port) to their
+// credentials. The credentials for the host "*" are used for every
+// host, except where the host's own entry sets them.
+type CredentialsConfig map[string]HostCredentials
+
+var (
+	hostCredentialsMu sync.Mutex
+	hostCredentials   = make(CredentialsConfig)
+)
+
+// ReadCredentialsConfig parses a credentials file from r. Each line
+// is a host, "*" for every host, or a name given to UseCredentials,
+// followed by whitespace-separated
+// settings: username=, password=, token= and ssh-key=. A setting
+// whose value starts with "$" is read from the environment variable
+// it names. Blank lines and lines starting with "#" are ignored.
+func ReadCredentialsConfig(r io.Reader) (CredentialsConfig, error) {
+	config := make(CredentialsConfig)
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		hc := config[fields[0]]
+		for _, setting := range fields[1:] {
+			eq := strings.Index(setting, "=")
+			if eq < 0 {
+				return nil, fmt.Errorf("line %d: %q is not name=value", lineno, setting)
+			}
+			value := setting[eq+1:]
+			if strings.HasPrefix(value, "$") {
+				value = os.Getenv(value[1:])
+			}
+			switch setting[:eq] {
+			case "username":
+				hc.Username = value
+			case "password":
+				hc.Password = value
+			case "token":
+				hc.Token = value
+			case "ssh-key":
+				hc.SSHKey = value
+			default:
+				return nil, fmt.Errorf("line %d: unknown setting %q", lineno, setting[:eq])
+			}
+		}
+		config[fields[0]] = hc
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// SetHostCredentials adds the credentials in config, replacing any
+// already set for the same hosts. It affects working trees created
+// afterwards.
+func SetHostCredentials(config CredentialsConfig) {
+	hostCredentialsMu.Lock()
+	for host, hc := range config {
+		hostCredentials[host] = hc
+	}
+	hostCredentialsMu.Unlock()
+
+	credentialsMu.Lock()
+	credentialsCache = make(map[string]*Credentials)
+	credentialsMu.Unlock()
+}
+
+// configuredCredentials returns the credentials set with
+// SetHostCredentials for host: those for "*" with any set for host
+// itself in their place.
+func configuredCredentials(host string) HostCredentials {
+	hostCredentialsMu.Lock()
+	defer hostCredentialsMu.Unlock()
+	hc := hostCredentials["*"]
+	if own, ok := hostCredentials[host]; ok {
+		if own.Username != "" || own.Password != "" || own.Token != "" {
+			hc.Username, hc.Password, hc.Token = own.Username, own.Password, own.Token
+		}
+		if own.SSHKey != "" {
+			hc.SSHKey = own.SSHKey
+		}
+	}
+	return hc
+}
+
+// repoCredentialNames maps repository URLs to the names of the
+// credentials given to UseCredentials for them.
+var repoCredentialNames = make(map[string]string)
+
+// UseCredentials makes working trees use the credentials set with
+// SetHostCredentials under name for their repository, in place of
+// those for its host. The name is the first field of a line in the
+// credentials file, and need not be a host.
+func UseCredentials(name string) WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		o.credentials = name
+	}
+}
+
+// useRepoCredentials makes VCS commands for repo use the credentials
+// set under name.
+func useRepoCredentials(repo, name string) error {
+	hostCredentialsMu.Lock()
+	defer hostCredentialsMu.Unlock()
+	if _, ok := hostCredentials[name]; !ok {
+		return fmt.Errorf("%s: no credentials named %q", repo, name)
+	}
+	repoCredentialNames[repo] = name
+	return nil
+}
+
+// repoCredentials returns the credentials for repo, which is on
+// host: those named for it with UseCredentials, and true, or else
+// those configured for host.
+func repoCredentials(repo, host string) (HostCredentials, bool) {
+	hostCredentialsMu.Lock()
+	name, ok := repoCredentialNames[repo]
+	hc := hostCredentials[name]
+	hostCredentialsMu.Unlock()
+	if ok {
+		return hc, true
+	}
+	return configuredCredentials(host), false
+}
@@ -0,0 +1,349 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// mockCredentials makes CredentialsFor find only the credentials in
+// creds, counting the lookups made for each host. The returned
+// function should be deferred to reset it.
+func mockCredentials(creds map[string]Credentials, lookups map[string]int) func() {
+	credentialsCache = make(map[string]*Credentials)
+	saved := lookupCredentials
+	lookupCredentials = func(host string) (Credentials, bool) {
+		if lookups != nil {
+			lookups[host]++
+		}
+		c, ok := creds[host]
+		return c, ok
+	}
+	return func() {
+		lookupCredentials = saved
+		credentialsCache = make(map[string]*Credentials)
+	}
+}
+
+func TestReadNetrc(t *testing.T) {
+	netrc, err := ReadNetrc(strings.NewReader(`# comment
+machine example.com login user password secret
+machine example.com login other password ignored
+macdef init
+cd /pub
+machine not.a.machine login no
+
+machine git.example.com
+	login me
+	account acct
+	password pw
+default login anon password guest
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Netrc{
+		"example.com":     {Username: "user", Password: "secret"},
+		"git.example.com": {Username: "me", Password: "pw"},
+		"":                {Username: "anon", Password: "guest"},
+	}
+	if !reflect.DeepEqual(netrc, expected) {
+		t.Errorf("got %v, want %v", netrc, expected)
+	}
+}
+
+func TestNetrcCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-netrc.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "netrc")
+	err = ioutil.WriteFile(path, []byte("machine example.com login user password secret\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NETRC", path)
+
+	if creds, ok := netrcCredentials("example.com"); !ok || creds.Password != "secret" {
+		t.Errorf("example.com: got %v,%t", creds, ok)
+	}
+	if creds, ok := netrcCredentials("example.org"); ok {
+		t.Errorf("example.org: got %v", creds)
+	}
+}
+
+func TestGitCredentials(t *testing.T) {
+	defer mockExecCommand()()
+	mockedStdout = "protocol=https\nhost=example.com\nusername=user\npassword=secret\n"
+	creds, ok := gitCredentials("example.com")
+	if !ok || creds != (Credentials{Username: "user", Password: "secret"}) {
+		t.Errorf("got %v,%t", creds, ok)
+	}
+
+	mockedStdout = ""
+	mockedStderr = "fatal: could not read Username"
+	mockedExitStatus = 128
+	if creds, ok := gitCredentials("example.com"); ok {
+		t.Errorf("got %v", creds)
+	}
+}
+
+func TestCredentialsFor(t *testing.T) {
+	lookups := make(map[string]int)
+	defer mockCredentials(map[string]Credentials{
+		"example.com": {Username: "user", Password: "secret"},
+	}, lookups)()
+
+	for i := 0; i < 2; i++ {
+		if _, ok := CredentialsFor("example.com"); !ok {
+			t.Error("example.com: no credentials")
+		}
+		if creds, ok := CredentialsFor("example.org"); ok {
+			t.Errorf("example.org: got %v", creds)
+		}
+	}
+	if lookups["example.com"] != 1 || lookups["example.org"] != 1 {
+		t.Errorf("lookups not remembered: %v", lookups)
+	}
+}
+
+// roundTripFunc is an http.RoundTripper recording the requests made.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestCredentialsTransport(t *testing.T) {
+	defer mockCredentials(map[string]Credentials{
+		"example.com": {Username: "user", Password: "secret"},
+	}, nil)()
+
+	var auth string
+	transport := NewCredentialsTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		auth = req.Header.Get("Authorization")
+		return &http.Response{StatusCode: http.StatusOK, Request: req}, nil
+	}))
+
+	tcs := []struct {
+		url    string
+		header string
+		auth   bool
+	}{
+		{"https://example.com/path", "", true},
+		{"http://example.com/path", "", false},
+		{"https://example.org/path", "", false},
+		{"https://example.com/path", "Bearer token", false},
+	}
+	for _, tc := range tcs {
+		req, err := http.NewRequest("GET", tc.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		if _, err := transport.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+		if tc.auth {
+			if auth != "Basic dXNlcjpzZWNyZXQ=" {
+				t.Errorf("%s: got Authorization %q", tc.url, auth)
+			}
+			if req.Header.Get("Authorization") != "" {
+				t.Errorf("%s: request modified", tc.url)
+			}
+		} else if auth != tc.header {
+			t.Errorf("%s: got Authorization %q, want %q", tc.url, auth, tc.header)
+		}
+	}
+}
+
+// gitHeaderEnv returns the environment gitAuthEnv is expected to
+// give for host, with the base64 credentials auth.
+func gitHeaderEnv(host, auth string) []string {
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.https://" + host + "/.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic " + auth,
+	}
+}
+
+func TestHgAuthEnv(t *testing.T) {
+	defer mockCredentials(map[string]Credentials{
+		"example.com": {Username: "user", Password: "secret"},
+	}, nil)()
+	defer SetTempDir(tempDirInUse())
+	SetTempDir(t.TempDir())
+	t.Setenv("HGRCPATH", "/etc/hgrc.test")
+	defer func(saved []string) { allowedEnv = saved }(allowedEnv)
+	AllowEnv("HGRCPATH")
+
+	env, remove, err := hgAuthEnv("https://example.com/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env) != 1 || !strings.HasPrefix(env[0], "HGRCPATH=/etc/hgrc.test"+string(filepath.ListSeparator)) {
+		t.Fatalf("got %v", env)
+	}
+	hgrc := env[0][len("HGRCPATH=/etc/hgrc.test")+1:]
+	if fi, err := os.Stat(hgrc); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+		t.Errorf("mode %v", fi.Mode())
+	}
+	content, err := ioutil.ReadFile(hgrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[auth]\nretrodep.prefix = https://example.com\nretrodep.username = user\nretrodep.password = secret\n"
+	if string(content) != expected {
+		t.Errorf("got %q", content)
+	}
+	remove()
+	if _, err := os.Stat(hgrc); !os.IsNotExist(err) {
+		t.Errorf("not removed: %v", err)
+	}
+
+	for _, repo := range []string{
+		"https://example.org/repo",
+		"https://me@example.com/repo",
+		"ssh://example.com/repo",
+	} {
+		if env, remove, err := hgAuthEnv(repo); env != nil || remove != nil || err != nil {
+			t.Errorf("%s: got %v, %v", repo, env, err)
+		}
+	}
+	if args := hgAuthArgs("https://example.com/repo"); args != nil {
+		t.Errorf("password in arguments: %v", args)
+	}
+}
+
+func TestHgAuthEnvCommand(t *testing.T) {
+	defer mockCredentials(map[string]Credentials{
+		"example.com": {Username: "user", Password: "secret"},
+	}, nil)()
+	defer SetTempDir(tempDirInUse())
+	SetTempDir(t.TempDir())
+	defer mockExecCommand()()
+
+	project := &vcs.RepoRoot{
+		VCS:  vcs.ByCmd(vcsHg),
+		Repo: "https://example.com/repo",
+		Root: "example.com/repo",
+	}
+	wt, err := NewWorkingTree(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	awt := wt.(*hgWorkingTree).anyWorkingTree
+	if len(awt.authEnv) != 1 {
+		t.Fatalf("got %v", awt.authEnv)
+	}
+	p := withEnv(execCommand("hg", "pull"), awt.authEnv)
+	for _, arg := range p.Args {
+		if strings.Contains(arg, "secret") {
+			t.Errorf("password in arguments: %v", p.Args)
+		}
+	}
+	if p.Env[len(p.Env)-1] != awt.authEnv[0] {
+		t.Errorf("got %v", p.Env)
+	}
+	hgrc := awt.authEnv[0][strings.LastIndexByte(awt.authEnv[0], filepath.ListSeparator)+1:]
+	wt.Close()
+	if _, err := os.Stat(hgrc); !os.IsNotExist(err) {
+		t.Errorf("not removed on Close: %v", err)
+	}
+}
+
+func TestReadCredentialsConfig(t *testing.T) {
+	t.Setenv("RETRODEP_TEST_TOKEN", "tok")
+	config, err := ReadCredentialsConfig(strings.NewReader(`# comment
+github.com token=$RETRODEP_TEST_TOKEN
+git.example.com username=ci password=pw
+git.example.com ssh-key=/keys/example
+
+* ssh-key=/keys/default
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := CredentialsConfig{
+		"github.com":      {Token: "tok"},
+		"git.example.com": {Username: "ci", Password: "pw", SSHKey: "/keys/example"},
+		"*":               {SSHKey: "/keys/default"},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("got %v, want %v", config, expected)
+	}
+
+	for _, bad := range []string{"example.com token", "example.com user=me"} {
+		if _, err := ReadCredentialsConfig(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestGitAuthArgs(t *testing.T) {
+	defer func() {
+		hostCredentials = make(CredentialsConfig)
+		credentialsCache = make(map[string]*Credentials)
+	}()
+	SetHostCredentials(CredentialsConfig{
+		"github.com":      {Token: "tok"},
+		"git.example.com": {Username: "ci", Password: "pw", SSHKey: "/keys/it's"},
+		"*":               {SSHKey: "/keys/default"},
+	})
+
+	tcs := []struct {
+		repo string
+		args []string
+		env  []string
+	}{
+		{"https://github.com/foo/bar", nil, gitHeaderEnv("github.com", "eC1hY2Nlc3MtdG9rZW46dG9r")},
+		{"https://git.example.com/repo", nil, gitHeaderEnv("git.example.com", "Y2k6cHc=")},
+		{"git@git.example.com:repo.git", []string{"-c", `core.sshCommand=ssh -i '/keys/it'\''s' -o IdentitiesOnly=yes`}, nil},
+		{"ssh://git@github.com/foo/bar", []string{"-c", "core.sshCommand=ssh -i '/keys/default' -o IdentitiesOnly=yes"}, nil},
+		{"https://example.org/repo", nil, nil},
+		{"https://me@github.com/foo/bar", nil, nil},
+		{"/local/repo", nil, nil},
+	}
+	for _, tc := range tcs {
+		if args := gitAuthArgs(tc.repo); !reflect.DeepEqual(args, tc.args) {
+			t.Errorf("%s: got %v, want %v", tc.repo, args, tc.args)
+		}
+		if env := gitAuthEnv(tc.repo); !reflect.DeepEqual(env, tc.env) {
+			t.Errorf("%s: got env %v, want %v", tc.repo, env, tc.env)
+		}
+	}
+
+	if args := hgAuthArgs("ssh://hg@git.example.com/repo"); len(args) != 2 || args[1] != `ui.ssh=ssh -i '/keys/it'\''s' -o IdentitiesOnly=yes` {
+		t.Errorf("hg ssh: got %v", args)
+	}
+	if creds, ok := CredentialsFor("github.com"); !ok || creds.Password != "tok" {
+		t.Errorf("CredentialsFor: got %v,%t", creds, ok)
+	}
+}
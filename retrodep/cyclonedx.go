@@ -0,0 +1,183 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// CycloneDXVersion is the version of the CycloneDX specification
+// written by WriteCycloneDX.
+const CycloneDXVersion = "1.4"
+
+// cdxBOM is a CycloneDX BOM in its JSON serialization.
+type cdxBOM struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	SerialNumber string          `json:"serialNumber"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Timestamp string        `json:"timestamp"`
+	Tools     []cdxTool     `json:"tools"`
+	Component *cdxComponent `json:"component,omitempty"`
+}
+
+type cdxTool struct {
+	Name string `json:"name"`
+}
+
+type cdxComponent struct {
+	Type               string                 `json:"type"`
+	BOMRef             string                 `json:"bom-ref"`
+	Name               string                 `json:"name"`
+	Version            string                 `json:"version,omitempty"`
+	PURL               string                 `json:"purl,omitempty"`
+	Licenses           []cdxLicenseChoice     `json:"licenses,omitempty"`
+	ExternalReferences []cdxExternalReference `json:"externalReferences,omitempty"`
+}
+
+type cdxLicenseChoice struct {
+	License cdxLicense `json:"license"`
+}
+
+type cdxLicense struct {
+	ID string `json:"id"`
+}
+
+type cdxExternalReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// purl returns the package URL for ref, or "" if its version is
+// not known.
+func purl(ref *Reference) string {
+	if ref.Ver == "" {
+		return ""
+	}
+	return "pkg:golang/" + ref.Pkg + "@" + ref.Ver
+}
+
+// newCDXComponent returns the CycloneDX component of the given type
+// for ref.
+func newCDXComponent(ref *Reference, typ string) cdxComponent {
+	c := cdxComponent{
+		Type:    typ,
+		BOMRef:  purl(ref),
+		Name:    ref.Pkg,
+		Version: ref.Ver,
+		PURL:    purl(ref),
+	}
+	if c.BOMRef == "" {
+		c.BOMRef = ref.Pkg
+	}
+	for _, file := range ref.LicenseFiles {
+		if file.License != "" && !hasCDXLicense(c.Licenses, file.License) {
+			c.Licenses = append(c.Licenses, cdxLicenseChoice{
+				License: cdxLicense{ID: file.License},
+			})
+		}
+	}
+	if ref.Repo != "" {
+		c.ExternalReferences = []cdxExternalReference{{
+			Type: "vcs",
+			URL:  ref.Repo,
+		}}
+	}
+	return c
+}
+
+// hasCDXLicense returns whether licenses includes the license id.
+func hasCDXLicense(licenses []cdxLicenseChoice, id string) bool {
+	for _, l := range licenses {
+		if l.License.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteCycloneDX writes the projects in the report to w as a
+// CycloneDX BOM in JSON, created at the given time. The first
+// top-level project, by import path, is the BOM's metadata component, and each
+// vendored project whose version was identified is a library
+// component identified by its purl. Vendored projects whose version
+// was not identified are left out.
+func (r *Report) WriteCycloneDX(w io.Writer, created time.Time) error {
+	r.sort()
+	projects := r.identified()
+	// The serial number is a name-based UUID, with the version
+	// and variant bits set as for version 5.
+	var uuid [16]byte
+	copy(uuid[:], digest(projects, created))
+	uuid[6] = uuid[6]&0x0f | 0x50
+	uuid[8] = uuid[8]&0x3f | 0x80
+	serial := hex.EncodeToString(uuid[:])
+	bom := cdxBOM{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: CycloneDXVersion,
+		SerialNumber: "urn:uuid:" + serial[:8] + "-" + serial[8:12] + "-" +
+			serial[12:16] + "-" + serial[16:20] + "-" + serial[20:],
+		Version: 1,
+		Metadata: cdxMetadata{
+			Timestamp: created.UTC().Format(time.RFC3339),
+			Tools:     []cdxTool{{Name: "retrodep"}},
+		},
+		Components:   make([]cdxComponent, 0, len(projects)),
+		Dependencies: make([]cdxDependency, 0),
+	}
+
+	deps := make(map[string]int)
+	for _, ref := range projects {
+		if ref.TopPkg == "" {
+			top := newCDXComponent(ref, "application")
+			if bom.Metadata.Component == nil {
+				bom.Metadata.Component = &top
+			} else {
+				bom.Components = append(bom.Components, top)
+			}
+			bom.Dependencies = append(bom.Dependencies, cdxDependency{
+				Ref:       top.BOMRef,
+				DependsOn: make([]string, 0),
+			})
+			deps[ref.Pkg] = len(bom.Dependencies) - 1
+			continue
+		}
+		c := newCDXComponent(ref, "library")
+		bom.Components = append(bom.Components, c)
+		if i, ok := deps[ref.TopPkg]; ok {
+			dep := &bom.Dependencies[i]
+			dep.DependsOn = append(dep.DependsOn, c.BOMRef)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&bom)
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestWriteCycloneDX(t *testing.T) {
+	report := NewReport()
+	report.Add(&Reference{Pkg: "example.com/foo", Repo: "https://example.com/foo", Ver: "v1.0.0", Tag: "v1.0.0"})
+	report.Add(&Reference{TopPkg: "example.com/foo", Pkg: "example.com/bar", Repo: "https://example.com/bar",
+		Tag: "v0.2.0", Ver: "v0.2.0", LicenseFiles: []LicenseFile{
+			{Path: "LICENSE", License: "MIT"},
+			{Path: "sub/LICENSE"},
+			{Path: "sub/COPYING", License: "MIT"},
+		}})
+	report.Add(&Reference{TopPkg: "example.com/foo", Pkg: "example.com/unknown"})
+	report.Add(&Reference{Pkg: "example.com/other"})
+	report.Add(&Reference{TopPkg: "example.com/other", Pkg: "example.com/bar", Tag: "v0.3.0", Ver: "v0.3.0"})
+
+	var buf bytes.Buffer
+	created := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := report.WriteCycloneDX(&buf, created); err != nil {
+		t.Fatal(err)
+	}
+	var bom cdxBOM
+	if err := json.Unmarshal(buf.Bytes(), &bom); err != nil {
+		t.Fatal(err)
+	}
+	uuidRE := regexp.MustCompile(`^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if bom.BOMFormat != "CycloneDX" || bom.Version != 1 ||
+		!uuidRE.MatchString(bom.SerialNumber) ||
+		bom.Metadata.Timestamp != "2019-01-02T03:04:05Z" {
+		t.Errorf("unexpected BOM %+v", bom)
+	}
+	if top := bom.Metadata.Component; top == nil || top.PURL != "pkg:golang/example.com/foo@v1.0.0" {
+		t.Errorf("unexpected metadata component %+v", top)
+	}
+
+	exp := []string{
+		"pkg:golang/example.com/bar@v0.2.0",
+		"example.com/other",
+		"pkg:golang/example.com/bar@v0.3.0",
+	}
+	if len(bom.Components) != len(exp) {
+		t.Fatalf("got %+v, want %v", bom.Components, exp)
+	}
+	for i, c := range bom.Components {
+		if c.BOMRef != exp[i] {
+			t.Errorf("component %d: got %s, want %s", i, c.BOMRef, exp[i])
+		}
+	}
+	if bar := bom.Components[0]; bar.Type != "library" || len(bar.ExternalReferences) != 1 ||
+		bar.ExternalReferences[0].URL != "https://example.com/bar" ||
+		len(bar.Licenses) != 1 || bar.Licenses[0].License.ID != "MIT" {
+		t.Errorf("unexpected component %+v", bar)
+	}
+
+	if len(bom.Dependencies) != 2 ||
+		len(bom.Dependencies[0].DependsOn) != 1 || bom.Dependencies[0].DependsOn[0] != exp[0] ||
+		bom.Dependencies[1].Ref != "example.com/other" ||
+		len(bom.Dependencies[1].DependsOn) != 1 || bom.Dependencies[1].DependsOn[0] != exp[2] {
+		t.Errorf("unexpected dependencies %+v", bom.Dependencies)
+	}
+}
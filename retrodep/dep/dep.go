@@ -0,0 +1,166 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package dep reads the Gopkg.toml and Gopkg.lock files written by
+// the dep dependency manager.
+package dep
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Project represents a project in Gopkg.lock.
+type Project struct {
+	Name     string
+	Source   string
+	Revision string
+	Version  string
+}
+
+// Dep represents the dep configuration.
+type Dep struct {
+	// Projects are the locked projects, from Gopkg.lock.
+	Projects []Project
+
+	// Sources maps project names to the alternate locations they
+	// are fetched from, from Gopkg.toml and Gopkg.lock.
+	Sources map[string]string
+}
+
+// LoadDep tries to load Gopkg.toml and Gopkg.lock. Gopkg.toml must
+// be present; in case no Gopkg.lock is present, there are no locked
+// projects.
+func LoadDep(projectRoot string) (*Dep, error) {
+	confPath := filepath.Join(projectRoot, "Gopkg.toml")
+	conf, err := readTables(confPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dep := &Dep{Sources: make(map[string]string)}
+	for _, kind := range []string{"constraint", "override"} {
+		for _, table := range conf[kind] {
+			if table["name"] != "" && table["source"] != "" {
+				dep.Sources[table["name"]] = table["source"]
+			}
+		}
+	}
+
+	lock, err := readTables(filepath.Join(projectRoot, "Gopkg.lock"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return dep, nil
+		}
+		return nil, err
+	}
+	for _, table := range lock["projects"] {
+		project := Project{
+			Name:     table["name"],
+			Source:   table["source"],
+			Revision: table["revision"],
+			Version:  table["version"],
+		}
+		if project.Name == "" {
+			continue
+		}
+		if project.Source != "" {
+			dep.Sources[project.Name] = project.Source
+		}
+		dep.Projects = append(dep.Projects, project)
+	}
+	return dep, nil
+}
+
+// readTables parses the file at path with parseTables.
+func readTables(path string) (map[string][]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tables, err := parseTables(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decoding %s", path)
+	}
+	return tables, nil
+}
+
+// parseTables parses the subset of TOML written by dep, returning
+// the arrays of tables by name. Only string values are kept; other
+// values, including arrays, are skipped.
+func parseTables(r io.Reader) (map[string][]map[string]string, error) {
+	tables := make(map[string][]map[string]string)
+	var current map[string]string
+	inArray := false
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case inArray:
+			// Skip to the end of a multi-line array.
+			inArray = !strings.HasSuffix(text, "]")
+			continue
+		case text == "" || strings.HasPrefix(text, "#"):
+			continue
+		case strings.HasPrefix(text, "[["):
+			name := strings.TrimSpace(strings.TrimSuffix(text[2:], "]]"))
+			current = make(map[string]string)
+			tables[name] = append(tables[name], current)
+			continue
+		case strings.HasPrefix(text, "["):
+			// A table we are not interested in.
+			current = nil
+			continue
+		}
+
+		eq := strings.Index(text, "=")
+		if eq == -1 {
+			return nil, errors.Errorf("line %d: expected key = value", line)
+		}
+		key := strings.Trim(strings.TrimSpace(text[:eq]), "\"")
+		value := strings.TrimSpace(text[eq+1:])
+		switch {
+		case strings.HasPrefix(value, "["):
+			inArray = !strings.HasSuffix(value, "]")
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end == -1 {
+				return nil, errors.Errorf("line %d: unterminated string", line)
+			}
+			if current != nil {
+				current[key] = value[1 : end+1]
+			}
+		case strings.HasPrefix(value, "\""):
+			s, err := strconv.QuotedPrefix(value)
+			if err != nil {
+				return nil, errors.Errorf("line %d: %s", line, err)
+			}
+			if current != nil {
+				current[key], _ = strconv.Unquote(s)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
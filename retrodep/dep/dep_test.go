@@ -0,0 +1,83 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dep
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadDep(t *testing.T) {
+	dep, err := LoadDep("../testdata/dep/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expProjects := []Project{
+		{
+			Name:     "github.com/eggs/ham",
+			Source:   "github.com/fork/ham",
+			Revision: "0123456789abcdef0123456789abcdef01234567",
+		},
+		{
+			Name:     "github.com/foo/bar",
+			Revision: "a19ce4aa4d8b5b0e1b0a0c0b3ae0e49a5e1921c2",
+			Version:  "v1.2.0",
+		},
+	}
+	if !reflect.DeepEqual(dep.Projects, expProjects) {
+		t.Errorf("expected %v, got %v", expProjects, dep.Projects)
+	}
+
+	expSources := map[string]string{
+		"github.com/eggs/ham": "github.com/fork/ham",
+		"example.com/spam":    "https://example.org/spam.git",
+	}
+	if !reflect.DeepEqual(dep.Sources, expSources) {
+		t.Errorf("expected %v, got %v", expSources, dep.Sources)
+	}
+}
+
+func TestLoadDepMissing(t *testing.T) {
+	if _, err := LoadDep("../testdata/glide/"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestParseTables(t *testing.T) {
+	tables, err := parseTables(strings.NewReader(`
+[[a]]
+  "quoted" = 'literal'
+  escaped = "x\ty"
+  number = 1
+[b]
+  ignored = "yes"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string][]map[string]string{
+		"a": {{"quoted": "literal", "escaped": "x\ty"}},
+	}
+	if !reflect.DeepEqual(tables, exp) {
+		t.Errorf("expected %v, got %v", exp, tables)
+	}
+
+	if _, err := parseTables(strings.NewReader("[[a]]\nname\n")); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,459 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DiffStyle says how the unified diff output of Diff, Patch and
+// VendoredPatch is shown by a writer from NewDiffWriter. The zero
+// DiffStyle leaves it as it is.
+type DiffStyle struct {
+	// Color highlights the output with ANSI escape sequences for
+	// a terminal.
+	Color bool
+
+	// Words shows changed lines as the words removed, in the
+	// form [-old-], and added, in the form {+new+}, as 'git diff
+	// --word-diff' does. With Color, the words are only
+	// highlighted.
+	Words bool
+
+	// Stat shows, instead of the differences, the number of lines
+	// changed in each file and in total, as 'git diff --stat'
+	// does.
+	Stat bool
+}
+
+// ParseDiffStyle parses a comma-separated list of the names of the
+// DiffStyle fields in lower case, such as "color,words".
+func ParseDiffStyle(list string) (DiffStyle, error) {
+	var style DiffStyle
+	if list == "" {
+		return style, nil
+	}
+	for _, name := range strings.Split(list, ",") {
+		switch strings.TrimSpace(name) {
+		case "color":
+			style.Color = true
+		case "words":
+			style.Words = true
+		case "stat":
+			style.Stat = true
+		default:
+			return style, fmt.Errorf("unknown diff style %q", name)
+		}
+	}
+	return style, nil
+}
+
+func (s DiffStyle) String() string {
+	var names []string
+	if s.Color {
+		names = append(names, "color")
+	}
+	if s.Words {
+		names = append(names, "words")
+	}
+	if s.Stat {
+		names = append(names, "stat")
+	}
+	return strings.Join(names, ",")
+}
+
+// ANSI escape sequences used for Color.
+const (
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiCyan  = "\x1b[36m"
+	ansiReset = "\x1b[m"
+)
+
+// statWidth is the widest the graph of added and removed lines for
+// a file is made by Stat.
+const statWidth = 50
+
+// diffStat is the number of lines added and removed in a file.
+type diffStat struct {
+	name             string
+	added, removed   int
+	fromName, toName string
+}
+
+// diffWriter is the io.WriteCloser returned by NewDiffWriter.
+type diffWriter struct {
+	out   io.Writer
+	style DiffStyle
+
+	// partial holds the start of a line not yet complete.
+	partial []byte
+
+	// fromLeft and toLeft count the lines still to come in the
+	// current hunk.
+	fromLeft, toLeft int
+
+	// removed and added hold the lines of the current change,
+	// for Words.
+	removed, added []string
+
+	// fromName is the name from the last "--- " header.
+	fromName string
+
+	stats []diffStat
+	err   error
+}
+
+// NewDiffWriter returns a writer which shows the unified diff
+// output written to it on out in the given style. Close must be
+// called once the output is complete; it does not close out.
+func NewDiffWriter(out io.Writer, style DiffStyle) io.WriteCloser {
+	return &diffWriter{out: out, style: style}
+}
+
+// Write implements the io.Writer interface.
+func (w *diffWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for w.err == nil && len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i == -1 {
+			w.partial = append(w.partial, p...)
+			break
+		}
+		line := string(append(w.partial, p[:i+1]...))
+		w.partial = w.partial[:0]
+		p = p[i+1:]
+		w.line(line)
+	}
+	return n, w.err
+}
+
+// Close implements the io.Closer interface by writing whatever
+// output is still held back.
+func (w *diffWriter) Close() error {
+	if len(w.partial) > 0 {
+		w.line(string(w.partial))
+		w.partial = nil
+	}
+	w.flushWords()
+	if w.style.Stat {
+		w.writeStat()
+	}
+	return w.err
+}
+
+// write writes s to the output, remembering any error.
+func (w *diffWriter) write(s string) {
+	if w.err == nil {
+		_, w.err = io.WriteString(w.out, s)
+	}
+}
+
+// colored returns s highlighted with the escape sequence color, if
+// Color is in use. Any newline is kept outside the highlighting.
+func (w *diffWriter) colored(color, s string) string {
+	if !w.style.Color || s == "" || s == "\n" {
+		return s
+	}
+	if strings.HasSuffix(s, "\n") {
+		return color + s[:len(s)-1] + ansiReset + "\n"
+	}
+	return color + s + ansiReset
+}
+
+// line handles a single line of unified diff output.
+func (w *diffWriter) line(line string) {
+	inHunk := w.fromLeft > 0 || w.toLeft > 0
+	switch {
+	case inHunk && strings.HasPrefix(line, "\\"):
+		// "\ No newline at end of file"
+		if !w.style.Words && !w.style.Stat {
+			w.write(line)
+		}
+		return
+	case inHunk && strings.HasPrefix(line, "-"):
+		w.fromLeft--
+		w.change('-', line)
+		return
+	case inHunk && strings.HasPrefix(line, "+"):
+		w.toLeft--
+		w.change('+', line)
+		return
+	case inHunk:
+		w.fromLeft--
+		w.toLeft--
+		w.flushWords()
+		if w.style.Stat {
+			return
+		}
+		if w.style.Words {
+			line = strings.TrimPrefix(line, " ")
+		}
+		w.write(line)
+		return
+	}
+
+	// Outside a hunk.
+	w.flushWords()
+	switch {
+	case strings.HasPrefix(line, "--- "):
+		w.fromName = headerName(line)
+	case strings.HasPrefix(line, "+++ "):
+		w.stats = append(w.stats, diffStat{
+			fromName: w.fromName,
+			toName:   headerName(line),
+		})
+	case strings.HasPrefix(line, "@@ "):
+		w.fromLeft, w.toLeft = parseHunkHeader(line)
+		if !w.style.Stat {
+			w.write(w.colored(ansiCyan, line))
+		}
+		return
+	}
+	if w.style.Stat {
+		return
+	}
+	if strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "+++ ") {
+		line = w.colored(ansiBold, line)
+	}
+	w.write(line)
+}
+
+// change handles a line removed ('-') or added ('+') in a hunk.
+func (w *diffWriter) change(kind byte, line string) {
+	if n := len(w.stats); n > 0 {
+		if kind == '-' {
+			w.stats[n-1].removed++
+		} else {
+			w.stats[n-1].added++
+		}
+	}
+	switch {
+	case w.style.Stat:
+	case w.style.Words:
+		if kind == '-' {
+			if len(w.added) > 0 {
+				w.flushWords()
+			}
+			w.removed = append(w.removed, line[1:])
+		} else {
+			w.added = append(w.added, line[1:])
+		}
+	case kind == '-':
+		w.write(w.colored(ansiRed, line))
+	default:
+		w.write(w.colored(ansiGreen, line))
+	}
+}
+
+// flushWords writes the words removed and added in the lines
+// collected for Words.
+func (w *diffWriter) flushWords() {
+	if len(w.removed) == 0 && len(w.added) == 0 {
+		return
+	}
+	from := splitWords(strings.Join(w.removed, ""))
+	to := splitWords(strings.Join(w.added, ""))
+	w.removed, w.added = nil, nil
+
+	// Lay the words out as the lines added are, so removed
+	// newlines are left out.
+	var buf strings.Builder
+	ops := diffLines(from, to)
+	for i := 0; i < len(ops); {
+		kind := ops[i].kind
+		if ops[i].line == "\n" {
+			if kind != '-' {
+				buf.WriteString("\n")
+			}
+			i++
+			continue
+		}
+		var words strings.Builder
+		for ; i < len(ops) && ops[i].kind == kind && ops[i].line != "\n"; i++ {
+			words.WriteString(ops[i].line)
+		}
+		buf.WriteString(w.wordChange(kind, words.String()))
+	}
+	out := buf.String()
+	if !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	w.write(out)
+}
+
+// wordChange returns the words kept (' '), removed ('-') or added
+// ('+') as they are shown for Words.
+func (w *diffWriter) wordChange(kind byte, words string) string {
+	switch {
+	case kind == ' ':
+		return words
+	case w.style.Color && kind == '-':
+		return w.colored(ansiRed, words)
+	case w.style.Color:
+		return w.colored(ansiGreen, words)
+	case kind == '-':
+		return "[-" + words + "-]"
+	}
+	return "{+" + words + "+}"
+}
+
+// writeStat writes the number of lines changed in each file, and in
+// total.
+func (w *diffWriter) writeStat() {
+	if len(w.stats) == 0 {
+		return
+	}
+	nameWidth, most, added, removed := 0, 0, 0, 0
+	for i := range w.stats {
+		st := &w.stats[i]
+		st.name = statName(st.fromName, st.toName)
+		if len(st.name) > nameWidth {
+			nameWidth = len(st.name)
+		}
+		if n := st.added + st.removed; n > most {
+			most = n
+		}
+		added += st.added
+		removed += st.removed
+	}
+	countWidth := len(strconv.Itoa(most))
+	for _, st := range w.stats {
+		plus, minus := st.added, st.removed
+		if most > statWidth {
+			plus = scaleStat(plus, most)
+			minus = scaleStat(minus, most)
+		}
+		w.write(fmt.Sprintf(" %-*s | %*d %s%s\n",
+			nameWidth, st.name, countWidth, st.added+st.removed,
+			w.colored(ansiGreen, strings.Repeat("+", plus)),
+			w.colored(ansiRed, strings.Repeat("-", minus))))
+	}
+	summary := fmt.Sprintf(" %d %s changed", len(w.stats), plural(len(w.stats), "file", "files"))
+	if added > 0 {
+		summary += fmt.Sprintf(", %d %s(+)", added, plural(added, "insertion", "insertions"))
+	}
+	if removed > 0 {
+		summary += fmt.Sprintf(", %d %s(-)", removed, plural(removed, "deletion", "deletions"))
+	}
+	w.write(summary + "\n")
+}
+
+// scaleStat returns n scaled so that most would be statWidth,
+// keeping any change visible.
+func scaleStat(n, most int) int {
+	if n == 0 {
+		return 0
+	}
+	scaled := n * statWidth / most
+	if scaled == 0 {
+		scaled = 1
+	}
+	return scaled
+}
+
+// plural returns one if n is 1, otherwise many.
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// headerName returns the file name from a "--- " or "+++ " header
+// line, without any modification time.
+func headerName(line string) string {
+	name := strings.TrimRight(line[4:], "\n")
+	if i := strings.IndexByte(name, '\t'); i != -1 {
+		name = name[:i]
+	}
+	return name
+}
+
+// statName returns the name shown by Stat for a file with the
+// headers fromName and toName. The a/ and b/ prefixes written by
+// Patch are removed.
+func statName(fromName, toName string) string {
+	name := toName
+	if name == "/dev/null" || name == "" {
+		name = fromName
+	}
+	if (strings.HasPrefix(fromName, "a/") || fromName == "/dev/null") &&
+		strings.HasPrefix(name, "b/") {
+		name = name[2:]
+	}
+	return name
+}
+
+// parseHunkHeader returns the number of lines from each file in the
+// hunk with the header line, of the form "@@ -l,s +l,s @@".
+func parseHunkHeader(line string) (int, int) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return 0, 0
+	}
+	return hunkLength(fields[1]), hunkLength(fields[2])
+}
+
+// hunkLength returns the number of lines in a hunk range, such as
+// "-3,4" or "+7".
+func hunkLength(r string) int {
+	i := strings.IndexByte(r, ',')
+	if i == -1 {
+		return 1
+	}
+	n, err := strconv.Atoi(r[i+1:])
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// splitWords splits s into words, runs of space, newlines and
+// single punctuation characters, to be compared for Words.
+func splitWords(s string) []string {
+	var words []string
+	kind := func(r rune) int {
+		switch {
+		case r == '\n':
+			return 0
+		case unicode.IsSpace(r):
+			return 1
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			return 2
+		}
+		return 3
+	}
+	start, last := 0, -1
+	for i, r := range s {
+		k := kind(r)
+		if i > start && (k != last || k == 0 || k == 3) {
+			words = append(words, s[start:i])
+			start = i
+		}
+		last = k
+	}
+	if start < len(s) {
+		words = append(words, s[start:])
+	}
+	return words
+}
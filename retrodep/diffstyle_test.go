@@ -0,0 +1,116 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"strings"
+	"testing"
+)
+
+const styledDiff = `--- a/foo.go
++++ b/foo.go
+@@ -1,3 +1,3 @@
+ package foo
+-var x = 1
++var y = 1
+ // end
+--- /dev/null
++++ b/bar.go
+@@ -0,0 +1,2 @@
++package foo
++-- not a header
+`
+
+func TestParseDiffStyle(t *testing.T) {
+	style, err := ParseDiffStyle("color,stat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if style != (DiffStyle{Color: true, Stat: true}) {
+		t.Errorf("got %+v", style)
+	}
+	if s := style.String(); s != "color,stat" {
+		t.Errorf("String: got %q", s)
+	}
+	if _, err := ParseDiffStyle("colour"); err == nil {
+		t.Error("no error for unknown style")
+	}
+}
+
+func TestDiffWriter(t *testing.T) {
+	tcases := []struct {
+		name  string
+		style DiffStyle
+		exp   string
+	}{
+		{
+			name: "unified",
+			exp:  styledDiff,
+		},
+		{
+			name:  "color",
+			style: DiffStyle{Color: true},
+			exp: ansiBold + "--- a/foo.go" + ansiReset + "\n" +
+				ansiBold + "+++ b/foo.go" + ansiReset + "\n" +
+				ansiCyan + "@@ -1,3 +1,3 @@" + ansiReset + "\n" +
+				" package foo\n" +
+				ansiRed + "-var x = 1" + ansiReset + "\n" +
+				ansiGreen + "+var y = 1" + ansiReset + "\n" +
+				" // end\n" +
+				ansiBold + "--- /dev/null" + ansiReset + "\n" +
+				ansiBold + "+++ b/bar.go" + ansiReset + "\n" +
+				ansiCyan + "@@ -0,0 +1,2 @@" + ansiReset + "\n" +
+				ansiGreen + "+package foo" + ansiReset + "\n" +
+				ansiGreen + "+-- not a header" + ansiReset + "\n",
+		},
+		{
+			name:  "words",
+			style: DiffStyle{Words: true},
+			exp: "--- a/foo.go\n+++ b/foo.go\n@@ -1,3 +1,3 @@\n" +
+				"package foo\n" +
+				"var [-x-]{+y+} = 1\n" +
+				"// end\n" +
+				"--- /dev/null\n+++ b/bar.go\n@@ -0,0 +1,2 @@\n" +
+				"{+package foo+}\n{+-- not a header+}\n",
+		},
+		{
+			name:  "stat",
+			style: DiffStyle{Stat: true},
+			exp: " foo.go | 2 +-\n" +
+				" bar.go | 2 ++\n" +
+				" 2 files changed, 3 insertions(+), 1 deletion(-)\n",
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			w := NewDiffWriter(&buf, tc.style)
+
+			// Write in pieces which split lines.
+			for _, piece := range strings.SplitAfter(styledDiff, "o") {
+				if _, err := w.Write([]byte(piece)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tc.exp {
+				t.Errorf("got:\n%q\nwant:\n%q", got, tc.exp)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DiskCache is a store of the file hashes of whole revisions in a
+// local directory, kept between runs. Entries have the same keys and
+// format as for a RemoteCache, and are stored as
+// <dir>/<first two characters of key>/<key>.json.
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache returns a *DiskCache storing entries in dir, which is
+// created when needed.
+func NewDiskCache(dir string) *DiskCache {
+	return &DiskCache{dir: dir}
+}
+
+// path returns the file name of the entry for key.
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+// Get returns the file hashes stored for key, or false if there are
+// none.
+func (c *DiskCache) Get(key string) (FileHashes, bool, error) {
+	data, err := ioutil.ReadFile(c.path(key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var entry remoteCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false, err
+	}
+	hashes := make(FileHashes, len(entry.Hashes))
+	for path, fileHash := range entry.Hashes {
+		hashes[filepath.FromSlash(path)] = fileHash
+	}
+	return hashes, true, nil
+}
+
+// Put stores hashes as the file hashes for key.
+func (c *DiskCache) Put(key string, hashes FileHashes) error {
+	entry := remoteCacheEntry{Hashes: make(map[string]FileHash, len(hashes))}
+	for path, fileHash := range hashes {
+		entry.Hashes[filepath.ToSlash(path)] = fileHash
+	}
+	data, err := json.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+	return writeEntry(c.path(key), data)
+}
+
+var diskCacheMu sync.Mutex
+var diskCache *DiskCache
+
+// SetDiskCache makes working trees look up file hashes in c before
+// the shared cache, if any, and before computing them, and store
+// them there afterwards. A nil c stops them using any disk cache.
+func SetDiskCache(c *DiskCache) {
+	diskCacheMu.Lock()
+	defer diskCacheMu.Unlock()
+	diskCache = c
+}
+
+// diskCacheInUse returns the cache set by SetDiskCache.
+func diskCacheInUse() *DiskCache {
+	diskCacheMu.Lock()
+	defer diskCacheMu.Unlock()
+	return diskCache
+}
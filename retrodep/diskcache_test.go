@@ -0,0 +1,103 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewDiskCache(filepath.Join(dir, "hashes"))
+	key := remoteCacheKey("https://github.com/foo/bar", HashGitSHA1, "rev")
+	if _, ok, err := c.Get(key); ok || err != nil {
+		t.Errorf("empty cache: got %t, %v", ok, err)
+	}
+	hashes := FileHashes{"README": "1", filepath.Join("sub", "a.go"): "2"}
+	if err := c.Put(key, hashes); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := c.Get(key)
+	if !ok || err != nil || !reflect.DeepEqual(got, hashes) {
+		t.Errorf("got %v, %t, %v", got, ok, err)
+	}
+
+	// The cache server reads the same layout.
+	srv := httptest.NewServer(NewCacheServer(filepath.Join(dir, "hashes"), nil))
+	defer srv.Close()
+	got, ok, err = NewRemoteCache(srv.URL).Get(key)
+	if !ok || err != nil || !reflect.DeepEqual(got, hashes) {
+		t.Errorf("server: got %v, %t, %v", got, ok, err)
+	}
+}
+
+func TestDiskCacheFileHashes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secret := []byte("secret")
+	srv := httptest.NewServer(NewCacheServer(filepath.Join(dir, "shared"), secret))
+	defer srv.Close()
+	c := NewRemoteCache(srv.URL)
+	c.SetKey(secret)
+	SetRemoteCache(c)
+	defer SetRemoteCache(nil)
+	defer SetDiskCache(nil)
+
+	fetches := 0
+	fetch := func(ref, subPath string) (FileHashes, error) {
+		fetches++
+		return FileHashes{"README": "1"}, nil
+	}
+	revision := func(ref string) (string, error) {
+		return "rev-" + ref, nil
+	}
+
+	// Two runs on each of two hosts: only the first run on the
+	// first host computes the hashes, and the first run on the
+	// second host copies them from the shared cache.
+	for _, host := range []string{"a", "b", "a", "b"} {
+		SetDiskCache(NewDiskCache(filepath.Join(dir, host)))
+		wt := &anyWorkingTree{repo: "https://github.com/foo/bar", algorithm: HashGitSHA1}
+		hashes, err := wt.remoteFileHashes(fetch, revision)("v1.0.0", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(hashes, FileHashes{"README": "1"}) {
+			t.Errorf("got %v", hashes)
+		}
+		if host == "b" {
+			// The service is not needed once host b has the
+			// entry.
+			srv.Config.Handler = NewCacheServer(filepath.Join(dir, "empty"), secret)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("fetched %d times", fetches)
+	}
+}
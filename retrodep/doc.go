@@ -41,6 +41,11 @@
 //
 //    wt, err := retrodep.NewWorkingTree(&proj.RepoRoot)
 //
+// To be able to cancel the clone and the VCS commands run later, or
+// give them a deadline, use NewWorkingTreeContext instead. The
+// WithContext method of the ContextWorkingTree returned gives it a
+// new context.
+//
 // The DescribeProject function takes a RepoPath, a WorkingTree, and
 // path within the tree, and returns a Representation, indicating the
 // upstream version of the project or vendored project, e.g.
@@ -15,7 +15,10 @@
 
 package retrodep
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 // ErrorNoGo indicates there is no Go source code to process.
 var ErrorNoGo = errors.New("no Go source code to process")
@@ -38,3 +41,121 @@ var ErrorNoFiles = errors.New("no files to hash")
 // ErrorInvalidRef indicates the ref is not a tag or a revision
 // (perhaps it is a branch name instead).
 var ErrorInvalidRef = errors.New("invalid ref")
+
+// ErrorSizeUnknown indicates the size of an upstream repository
+// cannot be estimated without cloning it.
+var ErrorSizeUnknown = errors.New("size unknown")
+
+// ErrorHealthUnknown indicates the state of an upstream repository
+// cannot be found out from its forge.
+var ErrorHealthUnknown = errors.New("repository health unknown")
+
+// ErrorNoRepoRoot indicates a Resolver does not know the repository
+// for an import path.
+var ErrorNoRepoRoot = errors.New("no repository known for import path")
+
+// ErrorOffline indicates the repository for an import path cannot be
+// found without the network, which SetOffline stops being used.
+var ErrorOffline = errors.New("no repository listed for import path, and resolving it needs the network")
+
+// ErrorUnknownHash indicates the hash algorithm requested is not one
+// of those for which support is implemented in retrodep.
+var ErrorUnknownHash = errors.New("unknown hash algorithm")
+
+// ErrorCloneFailed indicates an upstream repository could not be
+// cloned, for a reason other than those below.
+var ErrorCloneFailed = errors.New("clone failed")
+
+// ErrorAuthRequired indicates an upstream repository could not be
+// cloned without credentials, or the credentials given were refused.
+var ErrorAuthRequired = errors.New("authentication required")
+
+// ErrorUnreachable indicates an upstream repository could not be
+// reached: its host could not be found or connected to, or it did not
+// have the repository.
+var ErrorUnreachable = errors.New("repository unreachable")
+
+// CloneError describes the failure to clone an upstream repository.
+// It matches its Kind with errors.Is.
+type CloneError struct {
+	// Repo is the repository URL.
+	Repo string
+
+	// Kind is ErrorAuthRequired, ErrorUnreachable or
+	// ErrorCloneFailed.
+	Kind error
+
+	// Err is the error from running the VCS command.
+	Err error
+}
+
+func (e *CloneError) Error() string {
+	return e.Repo + ": " + e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Is returns true if target is e.Kind.
+func (e *CloneError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns e.Err.
+func (e *CloneError) Unwrap() error {
+	return e.Err
+}
+
+// authFailures and unreachableFailures hold the messages, in lower
+// case, from git, hg, svn and bzr showing why a clone failed.
+var (
+	authFailures = []string{
+		"authentication failed",
+		"authorization failed",
+		"could not read username",
+		"could not read password",
+		"permission denied (publickey",
+		"http error 401",
+		"http error 403",
+		"the requested url returned error: 401",
+		"the requested url returned error: 403",
+		"terminal prompts disabled",
+	}
+	unreachableFailures = []string{
+		"could not resolve host",
+		"name or service not known",
+		"temporary failure in name resolution",
+		"connection refused",
+		"connection timed out",
+		"network is unreachable",
+		"no route to host",
+		"repository not found",
+		"does not appear to be a git repository",
+		"the requested url returned error: 404",
+		"http error 404",
+		"unable to connect",
+	}
+)
+
+// newCloneError returns a CloneError for the failure err to clone
+// repo, with its Kind found from the output of the VCS command.
+func newCloneError(repo string, err error, output string) *CloneError {
+	return &CloneError{Repo: repo, Kind: cloneFailure(output), Err: err}
+}
+
+// cloneFailure returns the Kind of CloneError shown by output.
+func cloneFailure(output string) error {
+	output = strings.ToLower(output)
+	for _, msg := range authFailures {
+		if strings.Contains(output, msg) {
+			return ErrorAuthRequired
+		}
+	}
+	for _, msg := range unreachableFailures {
+		if strings.Contains(output, msg) {
+			return ErrorUnreachable
+		}
+	}
+	return ErrorCloneFailed
+}
+
+// ErrorForgeLimited indicates no more forge API requests can be made,
+// because of rate limits or the request budget.
+var ErrorForgeLimited = errors.New("forge API rate limit reached")
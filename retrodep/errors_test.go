@@ -0,0 +1,62 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestCloneFailure(t *testing.T) {
+	for output, expected := range map[string]error{
+		"fatal: could not read Username for 'https://github.com': terminal prompts disabled":            ErrorAuthRequired,
+		"git@github.com: Permission denied (publickey).":                                                ErrorAuthRequired,
+		"abort: authorization failed":                                                                   ErrorAuthRequired,
+		"fatal: unable to access 'https://example.invalid/x/': Could not resolve host: example.invalid": ErrorUnreachable,
+		"remote: Repository not found.\nfatal: repository 'https://github.com/x/y/' not found":          ErrorUnreachable,
+		"abort: HTTP Error 404: Not Found":                                                              ErrorUnreachable,
+		"fatal: early EOF":                                                                              ErrorCloneFailed,
+	} {
+		if kind := cloneFailure(output); kind != expected {
+			t.Errorf("%q: got %v, want %v", output, kind, expected)
+		}
+	}
+}
+
+func TestCloneError(t *testing.T) {
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		return exec.Command("sh", "-c", "echo 'fatal: Authentication failed' >&2; exit 128")
+	}
+
+	_, err := NewWorkingTree(&vcs.RepoRoot{
+		VCS:  vcs.ByCmd(vcsGit),
+		Repo: "https://example.com/private",
+	})
+	if !errors.Is(err, ErrorAuthRequired) {
+		t.Fatalf("got %v", err)
+	}
+	var cloneErr *CloneError
+	if !errors.As(err, &cloneErr) || cloneErr.Repo != "https://example.com/private" {
+		t.Errorf("got %#v", err)
+	}
+	if errors.Is(err, ErrorUnreachable) {
+		t.Error("unexpectedly unreachable")
+	}
+}
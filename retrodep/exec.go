@@ -0,0 +1,194 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// traceStderrMax is the maximum number of bytes of stderr to include
+// when tracing a subprocess.
+const traceStderrMax = 512
+
+// allowedEnv holds the names of the environment variables passed on
+// to subprocesses. Others, such as GIT_DIR or GIT_INDEX_FILE, could
+// change how the VCS behaves.
+var allowedEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "TMPDIR", "TZ", "SYSTEMROOT",
+	"LANG", "LC_ALL", "LC_CTYPE", "LC_MESSAGES", "XDG_CONFIG_HOME",
+	"SSH_AUTH_SOCK", "SSH_ASKPASS",
+	"GIT_SSH", "GIT_SSH_COMMAND", "GIT_ASKPASS",
+	"GIT_SSL_CAINFO", "GIT_SSL_CAPATH", "SSL_CERT_FILE", "SSL_CERT_DIR",
+	"http_proxy", "https_proxy", "no_proxy", "all_proxy",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "ALL_PROXY",
+}
+
+// fixedEnv is the environment set for every subprocess regardless
+// of the caller's environment.
+var fixedEnv = []string{
+	// Output from hg should not depend on the user's configuration.
+	"HGPLAIN=1",
+}
+
+// AllowEnv adds the named variables to those passed on from the
+// environment to version control subprocesses. By default only
+// variables needed for locating programs and configuration, for
+// authentication, and for proxies are passed on.
+func AllowEnv(names ...string) {
+	allowedEnv = append(allowedEnv, names...)
+}
+
+// commandEnv returns the environment for subprocesses.
+func commandEnv() []string {
+	allowed := make(map[string]bool, len(allowedEnv))
+	for _, name := range allowedEnv {
+		allowed[name] = true
+	}
+	var env []string
+	for _, kv := range os.Environ() {
+		if i := strings.IndexByte(kv, '='); i > 0 && allowed[kv[:i]] {
+			env = append(env, kv)
+		}
+	}
+	return append(env, fixedEnv...)
+}
+
+// withEnv returns p, with env added to its environment: that from
+// commandEnv unless p.Env is already set.
+func withEnv(p *exec.Cmd, env []string) *exec.Cmd {
+	if len(env) > 0 {
+		if p.Env == nil {
+			p.Env = commandEnv()
+		}
+		p.Env = append(p.Env, env...)
+	}
+	return p
+}
+
+// runCommand runs p, waiting for it to complete. Unless p.Env is
+// already set, it runs with the environment from commandEnv. When
+// tracing is enabled the command, its arguments, its duration, its
+// exit code and (truncated) stderr are logged.
+func runCommand(p *exec.Cmd) error {
+	return runCommandContext(context.Background(), p)
+}
+
+// runCommandContext is runCommand, killing p if ctx is done before
+// it completes, in which case the context's error is returned.
+func runCommandContext(ctx context.Context, p *exec.Cmd) error {
+	if p.Env == nil {
+		p.Env = commandEnv()
+	}
+	if !traceLog.enabled(LogDebug) {
+		return runContext(ctx, p)
+	}
+
+	var stderr bytes.Buffer
+	if p.Stderr == nil {
+		p.Stderr = &stderr
+	} else {
+		p.Stderr = io.MultiWriter(p.Stderr, &stderr)
+	}
+
+	start := time.Now()
+	err := runContext(ctx, p)
+	duration := time.Since(start)
+
+	exit := 0
+	if err != nil {
+		exit = -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exit = exitErr.ExitCode()
+		}
+	}
+
+	output := stderr.String()
+	if len(output) > traceStderrMax {
+		output = output[:traceStderrMax] + "..."
+	}
+	traceLog.Debugf("%s (in %s): %s, exit %d: %q",
+		strings.Join(redactArgs(p.Args), " "), p.Dir, duration, exit,
+		strings.TrimSpace(output))
+	return err
+}
+
+// killWaitDelay is how long to wait, once a killed command has
+// exited, for its output to be closed by any processes it started
+// which outlived it.
+const killWaitDelay = 5 * time.Second
+
+// runContext runs p, killing it, together with the processes it
+// started, if ctx is done first.
+func runContext(ctx context.Context, p *exec.Cmd) error {
+	if ctx.Done() == nil {
+		return p.Run()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	setProcessGroup(p)
+	p.WaitDelay = killWaitDelay
+	if err := p.Start(); err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcess(p)
+		case <-exited:
+		}
+	}()
+	err := p.Wait()
+	close(exited)
+	if ctxErr := ctx.Err(); ctxErr != nil && err != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// secretOptions are the configuration option names, in lower case,
+// whose values are hidden by redactArgs.
+var secretOptions = []string{".password=", ".extraheader="}
+
+// redactArgs returns args with the values of any password or header
+// options replaced, so they can be logged.
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		lower := strings.ToLower(arg)
+		for _, option := range secretOptions {
+			if j := strings.Index(lower, option); j >= 0 {
+				arg = arg[:j+len(option)] + "***"
+				break
+			}
+		}
+		redacted[i] = arg
+	}
+	return redacted
+}
+
+// traceDuration logs the time taken by an operation which runs
+// subprocesses outside of runCommand, such as vcs.Cmd.Create.
+func traceDuration(start time.Time, what string, err error) {
+	traceLog.Debugf("%s: %s, error: %v", what, time.Since(start), err)
+}
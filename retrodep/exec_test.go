@@ -16,11 +16,18 @@
 package retrodep
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
+	"runtime"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/tools/go/vcs"
 )
 
 const (
@@ -75,3 +82,124 @@ func TestHelper(t *testing.T) {
 	exit, _ := strconv.Atoi(os.Getenv(envExitStatus))
 	os.Exit(exit)
 }
+
+func TestRunCommandTrace(t *testing.T) {
+	defer mockExecCommand()()
+	r := &recordingLogger{level: LogDebug}
+	SetLogger(r)
+	defer SetLogger(nil)
+
+	mockedStderr = "fatal: something went wrong"
+	mockedExitStatus = 128
+	var stderr bytes.Buffer
+	p := execCommand("git", "status")
+	p.Stderr = &stderr
+	err := runCommand(p)
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 128 {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if stderr.String() != mockedStderr {
+		t.Errorf("stderr: got %q, want %q", stderr.String(), mockedStderr)
+	}
+
+	// The command line, exit status and output are logged.
+	if len(r.entries) != 1 {
+		t.Fatalf("got %d log entries: %v", len(r.entries), r.entries)
+	}
+	entry := r.entries[0]
+	if entry.level != LogDebug || entry.subsystem != SubsystemVCS {
+		t.Errorf("logged at %s from %s", entry.level, entry.subsystem)
+	}
+	for _, want := range []string{" git status (in ", ", exit 128: ", `"fatal: something went wrong"`} {
+		if !strings.Contains(entry.msg, want) {
+			t.Errorf("%q not logged: %s", want, entry.msg)
+		}
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	wt := &gitWorkingTree{anyWorkingTree: anyWorkingTree{
+		Dir: "",
+		VCS: &vcs.Cmd{Cmd: "sleep"},
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err := wt.WithContext(ctx).(*gitWorkingTree).run("10")
+	if err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("command not killed: took %s", elapsed)
+	}
+
+	// The original has no deadline.
+	if wt.context().Done() != nil {
+		t.Error("WithContext changed the original")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	defer mockExecCommand()()
+	if _, _, err := wt.WithContext(ctx).(*gitWorkingTree).run("status"); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRunContextGrandchild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	wt := &gitWorkingTree{anyWorkingTree: anyWorkingTree{
+		Dir: "",
+		VCS: &vcs.Cmd{Cmd: "sh"},
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+
+	// The shell's child keeps its stdout open after the shell
+	// is killed, unless it is killed too.
+	_, _, err := wt.WithContext(ctx).(*gitWorkingTree).run("-c", "sleep 10 & wait")
+	if err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed >= killWaitDelay {
+		t.Errorf("child of command not killed: took %s", elapsed)
+	}
+}
+
+func TestCommandEnv(t *testing.T) {
+	for name, value := range map[string]string{
+		"GIT_DIR":         "/elsewhere/.git",
+		"HGRCPATH":        "/elsewhere/hgrc",
+		"RETRODEP_TEST_A": "a",
+	} {
+		t.Setenv(name, value)
+	}
+	defer func(names []string) { allowedEnv = names }(allowedEnv)
+
+	hasVar := func(env []string, kv string) bool {
+		for _, v := range env {
+			if v == kv {
+				return true
+			}
+		}
+		return false
+	}
+
+	env := commandEnv()
+	for _, kv := range []string{"GIT_DIR=/elsewhere/.git", "HGRCPATH=/elsewhere/hgrc", "RETRODEP_TEST_A=a"} {
+		if hasVar(env, kv) {
+			t.Errorf("%s passed on", kv)
+		}
+	}
+	if !hasVar(env, "PATH="+os.Getenv("PATH")) || !hasVar(env, "HGPLAIN=1") {
+		t.Errorf("unexpected environment %v", env)
+	}
+
+	AllowEnv("RETRODEP_TEST_A")
+	if !hasVar(commandEnv(), "RETRODEP_TEST_A=a") {
+		t.Error("allowed variable not passed on")
+	}
+}
@@ -17,46 +17,350 @@ package retrodep
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 )
 
-// FileHash records the hash of a file in the format preferred by the
-// version control system that tracks it.
+// Hash algorithms which FileHash values can be computed with.
+const (
+	// HashGitSHA1 is the SHA-1 object ID git gives the file's
+	// blob. This is the default for git working trees.
+	HashGitSHA1 = "git-sha1"
+
+	// HashGitSHA256 is the SHA-256 object ID git gives the file's
+	// blob in repositories using the sha256 object format. This
+	// is the default for git working trees of such repositories.
+	HashGitSHA256 = "git-sha256"
+
+	// HashSHA256 is SHA-256 of the file's content. This is the
+	// default for hg working trees.
+	HashSHA256 = "sha256"
+
+	// HashBLAKE3 is BLAKE3 of the file's content.
+	HashBLAKE3 = "blake3"
+)
+
+// Normalizations which can follow a hash algorithm name, each after
+// "+", for file content to be normalized before it is hashed. Files
+// which look binary, containing a NUL byte, are not normalized.
+// Others can be added with RegisterNormalization.
+const (
+	// NormalizeCRLF converts CRLF line endings to LF.
+	NormalizeCRLF = "crlf"
+
+	// NormalizeTrailingSpace removes spaces and tabs from the ends
+	// of lines.
+	NormalizeTrailingSpace = "trailing-space"
+
+	// NormalizeImportComment removes canonical import comments
+	// from the package clauses of Go files, as godep does.
+	NormalizeImportComment = "import-comment"
+
+	// NormalizeGofmt formats Go files as gofmt does. Files which
+	// do not parse are left alone.
+	NormalizeGofmt = "gofmt"
+
+	// NormalizeBuildTags rewrites the build constraints of Go
+	// files as a single //go:build line, removing "// +build"
+	// lines, as go fix does.
+	NormalizeBuildTags = "build-tags"
+)
+
+// NormalizedAlgorithm returns the name of the hash algorithm which
+// is algorithm with normalizations, some of the Normalize...
+// constants, applied. The normalizations are put in a canonical
+// order.
+func NormalizedAlgorithm(algorithm string, normalizations []string) string {
+	parts := strings.Split(algorithm, "+")
+	seen := make(map[string]bool)
+	var mods []string
+	for _, n := range append(parts[1:], normalizations...) {
+		if n != "" && !seen[n] {
+			seen[n] = true
+			mods = append(mods, n)
+		}
+	}
+	if len(mods) == 0 {
+		return parts[0]
+	}
+	sort.Strings(mods)
+	return parts[0] + "+" + strings.Join(mods, "+")
+}
+
+// FileHash records the hash of a file. Except for HashGitSHA1, in
+// which case it is the object ID git uses, it is the name of the
+// hash algorithm followed by ":" and the hex-encoded hash.
 type FileHash string
 
+// Algorithm returns the name of the hash algorithm used for h.
+func (h FileHash) Algorithm() string {
+	if i := strings.IndexByte(string(h), ':'); i >= 0 {
+		return string(h[:i])
+	}
+	return HashGitSHA1
+}
+
+// Sum returns the hex-encoded hash without the algorithm name.
+func (h FileHash) Sum() string {
+	return string(h[strings.IndexByte(string(h), ':')+1:])
+}
+
 // Hasher is the interface that wraps the Hash method.
 type Hasher interface {
 	// Hash returns the file hash for the filename absPath, hashed
 	// as though it were in the repository as filename
-	// relativePath.
+	// relativePath. It may be called by several goroutines at
+	// once.
 	Hash(relativePath, absPath string) (FileHash, error)
 }
 
-type sha256Hasher struct{}
+// NewHasher returns a Hasher for the named algorithm, one of the
+// Hash... constants or those registered with RegisterHasher,
+// optionally followed by normalizations, which hashes the content of
+// files alone. It returns ErrorUnknownHash for other algorithms.
+func NewHasher(algorithm string) (Hasher, error) {
+	if i := strings.IndexByte(algorithm, '+'); i >= 0 {
+		return newNormalizingHasher(algorithm[:i], algorithm)
+	}
+	switch algorithm {
+	case HashGitSHA1:
+		return &gitBlobHasher{}, nil
+	case HashGitSHA256:
+		return &gitBlobHasher{sha256: true}, nil
+	case HashSHA256:
+		return &sha256Hasher{}, nil
+	case HashBLAKE3:
+		return &blake3Hasher{}, nil
+	}
+	if h := registeredHasher(algorithm); h != nil {
+		return h, nil
+	}
+	return nil, ErrorUnknownHash
+}
 
-// Hash implements the Hasher interface generically using sha256.
-func (h sha256Hasher) Hash(relativePath, absPath string) (FileHash, error) {
+// contentHasher is a Hasher which can also hash content which is
+// not in a file.
+type contentHasher interface {
+	Hasher
+
+	// hashContent returns the file hash for the content read
+	// from r.
+	hashContent(r io.Reader) (FileHash, error)
+}
+
+// normalizingHasher hashes file content with another contentHasher
+// after normalizing it.
+type normalizingHasher struct {
+	hasher     contentHasher
+	algorithm  string
+	normalizer Normalizer
+}
+
+// newNormalizingHasher returns a Hasher for the algorithm named, which
+// is base followed by normalizations.
+func newNormalizingHasher(base, algorithm string) (Hasher, error) {
+	algorithm = NormalizedAlgorithm(algorithm, nil)
+	inner, err := NewHasher(base)
+	if err != nil {
+		return nil, err
+	}
+	content, ok := inner.(contentHasher)
+	if !ok {
+		return nil, ErrorUnknownHash
+	}
+	normalizer, err := normalizationPipeline(strings.Split(algorithm, "+")[1:])
+	if err != nil {
+		return nil, err
+	}
+	return &normalizingHasher{
+		hasher:     content,
+		algorithm:  algorithm,
+		normalizer: normalizer,
+	}, nil
+}
+
+// Hash implements the Hasher interface by normalizing the file's
+// content before hashing it.
+func (h *normalizingHasher) Hash(relativePath, absPath string) (FileHash, error) {
+	return hashFile(&normalizingFile{h, filepath.ToSlash(relativePath)}, absPath)
+}
+
+func (h *normalizingHasher) hashContent(r io.Reader) (FileHash, error) {
+	return h.hashPathContent("", r)
+}
+
+// hashPathContent returns the file hash for the content read from
+// r, normalized as the file at the slash-separated path.
+func (h *normalizingHasher) hashPathContent(path string, r io.Reader) (FileHash, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return FileHash(""), err
+	}
+	if bytes.IndexByte(content, 0) < 0 {
+		content = h.normalizer(path, content)
+	}
+	fileHash, err := h.hasher.hashContent(bytes.NewReader(content))
+	if err != nil {
+		return FileHash(""), err
+	}
+	return FileHash(h.algorithm + ":" + fileHash.Sum()), nil
+}
+
+// normalizingFile is a normalizingHasher for the content of the file
+// at path.
+type normalizingFile struct {
+	*normalizingHasher
+	path string
+}
+
+func (f *normalizingFile) hashContent(r io.Reader) (FileHash, error) {
+	return f.hashPathContent(f.path, r)
+}
+
+// pathHasher returns a contentHasher hashing content as hasher does
+// for the file at the slash-separated path, which matters for
+// normalizations applied only to some files.
+func pathHasher(hasher contentHasher, path string) contentHasher {
+	if h, ok := hasher.(*normalizingHasher); ok {
+		return &normalizingFile{h, path}
+	}
+	return hasher
+}
+
+// hashLink returns the file hash from h for a symbolic link to
+// target, hashed as though it were in the repository as filename
+// relativePath. The content hashed is the target path, as for the
+// blob git records for a link.
+func hashLink(h Hasher, relativePath, target string) (FileHash, error) {
+	if ch, ok := h.(contentHasher); ok {
+		return ch.hashContent(strings.NewReader(target))
+	}
+
+	// Other hashers only hash files.
+	f, err := ioutil.TempFile("", "retrodep-link.")
+	if err != nil {
+		return FileHash(""), err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(target)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return FileHash(""), err
+	}
+	return h.Hash(relativePath, f.Name())
+}
+
+// hashFile returns the file hash from h for the content of the file
+// absPath.
+func hashFile(h contentHasher, absPath string) (FileHash, error) {
 	f, err := os.Open(absPath)
 	if err != nil {
 		return FileHash(""), errors.Wrapf(err, "hashing %s", absPath)
 	}
 	defer f.Close()
 
-	hash := sha256.New()
-	_, err = io.Copy(hash, f)
+	r := &countingReader{r: f}
+	fileHash, err := h.hashContent(r)
+	metrics.Add(MetricHashBytes, r.n)
 	if err != nil {
 		return FileHash(""), errors.Wrapf(err, "hashing %s", absPath)
 	}
+	return fileHash, nil
+}
+
+// countingReader is an io.Reader counting the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// digestContent returns the file hash of the content read from r
+// using hash, for the named algorithm.
+func digestContent(algorithm string, hash hash.Hash, r io.Reader) (FileHash, error) {
+	if _, err := io.Copy(hash, r); err != nil {
+		return FileHash(""), err
+	}
+	return FileHash(algorithm + ":" + hex.EncodeToString(hash.Sum(nil))), nil
+}
+
+type sha256Hasher struct{}
+
+// Hash implements the Hasher interface generically using sha256.
+func (h sha256Hasher) Hash(relativePath, absPath string) (FileHash, error) {
+	return hashFile(h, absPath)
+}
+
+func (h sha256Hasher) hashContent(r io.Reader) (FileHash, error) {
+	return digestContent(HashSHA256, sha256.New(), r)
+}
+
+type blake3Hasher struct{}
+
+// Hash implements the Hasher interface using BLAKE3.
+func (h blake3Hasher) Hash(relativePath, absPath string) (FileHash, error) {
+	return hashFile(h, absPath)
+}
+
+func (h blake3Hasher) hashContent(r io.Reader) (FileHash, error) {
+	return digestContent(HashBLAKE3, newBlake3(), r)
+}
+
+// gitBlobHasher computes git blob object IDs without running git, and
+// so without applying any filters configured by .gitattributes. If
+// sha256 is true, object IDs are for the sha256 object format.
+type gitBlobHasher struct {
+	sha256 bool
+}
+
+// Hash implements the Hasher interface using git's blob object IDs.
+func (h gitBlobHasher) Hash(relativePath, absPath string) (FileHash, error) {
+	return hashFile(h, absPath)
+}
+
+func (h gitBlobHasher) hashContent(r io.Reader) (FileHash, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return FileHash(""), err
+	}
+	hash := sha1.New()
+	if h.sha256 {
+		hash = sha256.New()
+	}
+	fmt.Fprintf(hash, "blob %d\x00", len(content))
+	hash.Write(content)
+	return gitObjectHash(hex.EncodeToString(hash.Sum(nil))), nil
+}
 
-	return FileHash(hex.EncodeToString(hash.Sum(nil))), nil
+// gitObjectHash returns the FileHash for the git object ID id, which
+// may be for either object format.
+func gitObjectHash(id string) FileHash {
+	if len(id) == sha256.Size*2 {
+		return FileHash(HashGitSHA256 + ":" + id)
+	}
+	return FileHash(id)
 }
 
 // FileHashes is a map of paths, relative to the top-level of the
@@ -67,7 +371,12 @@ type FileHashes map[string]FileHash
 // whose files belong to the version control system named in vcsCmd. Keys in
 // the excludes map are filenames to ignore.
 func NewFileHashes(h Hasher, root string, excludes map[string]struct{}) (FileHashes, error) {
-	hashes := make(FileHashes)
+	return newFileHashes(envOf(h), h, root, excludes)
+}
+
+// newFileHashes is NewFileHashes, hashing as many files at once as
+// env allows.
+func newFileHashes(env *scanEnv, h Hasher, root string, excludes map[string]struct{}) (FileHashes, error) {
 	root = path.Clean(root)
 
 	// Make a local copy of excludes we can safely modify
@@ -78,6 +387,7 @@ func NewFileHashes(h Hasher, root string, excludes map[string]struct{}) (FileHas
 		}
 	}
 
+	var files []hashJob
 	walkfn := func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -121,7 +431,8 @@ func NewFileHashes(h Hasher, root string, excludes map[string]struct{}) (FileHas
 
 			return nil
 		}
-		if !info.Mode().IsRegular() {
+		isLink := info.Mode()&os.ModeSymlink != 0
+		if !info.Mode().IsRegular() && !isLink {
 			return nil
 		}
 		relativePath, err := filepath.Rel(root, path)
@@ -129,20 +440,87 @@ func NewFileHashes(h Hasher, root string, excludes map[string]struct{}) (FileHas
 			return err
 		}
 
-		fileHash, err := h.Hash(relativePath, path)
-		if err != nil {
-			return err
+		job := hashJob{relativePath: relativePath, path: path}
+		if isLink {
+			// Hash the link itself, as git does, wherever
+			// it points.
+			if job.link, err = os.Readlink(path); err != nil {
+				return err
+			}
+			job.isLink = true
 		}
-		hashes[relativePath] = fileHash
+		files = append(files, job)
 		return nil
 	}
 	err := filepath.Walk(root, walkfn)
 	if err != nil {
 		return nil, err
 	}
+
+	fileHashes, err := hashFiles(env.hashLimiter(), h, files)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(FileHashes, len(files))
+	for i, file := range files {
+		hashes[file.relativePath] = fileHashes[i]
+	}
 	return hashes, nil
 }
 
+// hashJob is a file for hashFiles to hash.
+type hashJob struct {
+	relativePath string
+	path         string
+
+	// isLink is set for symbolic links, which are hashed as
+	// their target path, link.
+	isLink bool
+	link   string
+}
+
+// hashFiles returns the file hash from h for each of files, hashing
+// as many at once as l allows. If any fail, the error for the first
+// of them is returned.
+func hashFiles(l limiter, h Hasher, files []hashJob) ([]FileHash, error) {
+	workers := cap(l)
+	if workers > len(files) {
+		workers = len(files)
+	}
+
+	fileHashes := make([]FileHash, len(files))
+	errs := make([]error, len(files))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range next {
+				l.acquire()
+				if files[j].isLink {
+					fileHashes[j], errs[j] = hashLink(h, files[j].relativePath, files[j].link)
+				} else {
+					fileHashes[j], errs[j] = h.Hash(files[j].relativePath, files[j].path)
+				}
+				l.release()
+			}
+		}()
+	}
+	for j := range files {
+		next <- j
+	}
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fileHashes, nil
+}
+
 // IsSubsetOf returns true if these file hashes are a subset of s.
 func (h FileHashes) IsSubsetOf(s FileHashes) bool {
 	return h.Mismatches(s, true) == nil
@@ -157,11 +535,11 @@ func (h FileHashes) Mismatches(s FileHashes, failFast bool) []string {
 		sh, ok := s[path]
 		if !ok {
 			// File not present in s
-			log.Debugf("%s: not present", path)
+			hashLog.Debugf("%s: not present", path)
 			mismatches = append(mismatches, path)
 		} else if fileHash != sh {
 			// Hash does not match
-			log.Debugf("%s: hash mismatch", path)
+			hashLog.Debugf("%s: hash mismatch", path)
 			mismatches = append(mismatches, path)
 		}
 
@@ -172,3 +550,83 @@ func (h FileHashes) Mismatches(s FileHashes, failFast bool) []string {
 
 	return mismatches
 }
+
+// fileHashesVersion is the version of the format written by
+// FileHashes.Marshal.
+const fileHashesVersion = 1
+
+// fileHashesSnapshot is the format written by FileHashes.Marshal.
+type fileHashesSnapshot struct {
+	Version int                 `json:"version"`
+	Files   map[string]FileHash `json:"files"`
+}
+
+// Marshal returns a JSON encoding of h which can be read with
+// Unmarshal, including on other machines: paths are stored with
+// forward slashes.
+func (h FileHashes) Marshal() ([]byte, error) {
+	snapshot := fileHashesSnapshot{
+		Version: fileHashesVersion,
+		Files:   make(map[string]FileHash, len(h)),
+	}
+	for path, fileHash := range h {
+		snapshot.Files[filepath.ToSlash(path)] = fileHash
+	}
+	return json.MarshalIndent(&snapshot, "", "  ")
+}
+
+// Unmarshal replaces the contents of h with the file hashes encoded
+// in data by Marshal.
+func (h *FileHashes) Unmarshal(data []byte) error {
+	var snapshot fileHashesSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return errors.Wrap(err, "reading file hashes")
+	}
+	if snapshot.Version != fileHashesVersion {
+		return fmt.Errorf("file hashes: unsupported version %d",
+			snapshot.Version)
+	}
+	hashes := make(FileHashes, len(snapshot.Files))
+	for path, fileHash := range snapshot.Files {
+		hashes[filepath.FromSlash(path)] = fileHash
+	}
+	*h = hashes
+	return nil
+}
+
+// SaveFile writes h to the file named path, replacing it if it
+// exists. The file is written under a temporary name and renamed, so
+// readers never see it partly written.
+func (h FileHashes) SaveFile(path string) error {
+	data, err := h.Marshal()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".hashes")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadFile replaces the contents of h with the file hashes saved in
+// the file named path by SaveFile.
+func (h *FileHashes) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(h.Unmarshal(data), path)
+}
@@ -16,14 +16,21 @@
 package retrodep
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"sort"
+	"strings"
 	"testing"
+
+	"golang.org/x/tools/go/vcs"
 )
 
 func TestSha256Hasher(t *testing.T) {
 	h := sha256Hasher{}
 	// from sha256sum:
-	emptysum := FileHash("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	emptysum := FileHash("sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
 	fh, err := h.Hash("", "testdata/gosource/ignored.go")
 	if err != nil {
 		t.Fatal(err)
@@ -63,6 +70,64 @@ func TestNewFileHashes(t *testing.T) {
 	}
 }
 
+func TestNewFileHashesSymlinks(t *testing.T) {
+	top, err := ioutil.TempDir("", "retrodep-symlinks.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(top)
+	writeFiles(t, top, map[string]string{
+		"outside.go":       "package outside\n",
+		"tree/a.go":        "package a\n",
+		"tree/sub/b.go":    "package sub\n",
+		"tree/sub/.keep":   "",
+		"tree/.gitignore2": "",
+	})
+	dir := filepath.Join(top, "tree")
+	links := map[string]string{
+		"inside":  "a.go",
+		"subdir":  "sub",
+		"outside": "../outside.go",
+		"abs":     "/nonexistent/file",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, h := range []contentHasher{sha256Hasher{}, gitBlobHasher{}} {
+		hashes, err := NewFileHashes(h, dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := hashes[filepath.Join("subdir", "b.go")]; ok {
+			t.Errorf("%T: link to directory followed", h)
+		}
+		for name, target := range links {
+			// Links are hashed as their targets, wherever
+			// they point, rather than followed.
+			expected, _ := h.hashContent(strings.NewReader(target))
+			if hashes[name] != expected {
+				t.Errorf("%T: %s: got %s, want %s", h, name, hashes[name], expected)
+			}
+		}
+		if len(hashes) != 4+len(links) {
+			t.Errorf("%T: got %v", h, hashes)
+		}
+	}
+
+	// Git hashes links the same way as gitBlobHasher.
+	hashes, err := NewFileHashes(&gitHasher{}, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := gitBlobHasher{}.hashContent(strings.NewReader("a.go"))
+	if hashes["inside"] != expected {
+		t.Errorf("git: got %s, want %s", hashes["inside"], expected)
+	}
+}
+
 func TestNewFileHashesExclude(t *testing.T) {
 	excludes := make(map[string]struct{})
 	excludes["testdata/gosource/ignored.go"] = struct{}{}
@@ -91,6 +156,27 @@ func TestNewFileHashesExclude(t *testing.T) {
 	}
 }
 
+func TestGitHasherDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-hasher.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// File names are relative to the current directory, not dir.
+	expected, err := NewFileHashes(&gitHasher{}, "testdata/gosource", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes, err := NewFileHashes(&gitHasher{dir: dir}, "testdata/gosource", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hashes, expected) {
+		t.Errorf("got %v, want %v", hashes, expected)
+	}
+}
+
 func TestIsSubsetOf(t *testing.T) {
 	hasher := &gitHasher{}
 	hashes, err := NewFileHashes(hasher, "testdata/gosource", nil)
@@ -159,3 +245,167 @@ func TestMismatches(t *testing.T) {
 		t.Errorf("too many mismatches returned: %v", mismatches)
 	}
 }
+
+func TestFileHashesSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hashes := FileHashes{
+		"a.go":                         FileHash("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"),
+		filepath.Join("sub", "b c.go"): FileHash("d4c3dbfa77a74ae238e401d5d2197b45f30d8513"),
+	}
+	path := filepath.Join(dir, "hashes.json")
+	if err := ioutil.WriteFile(path, []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := hashes.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if infos, err := ioutil.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(infos) != 1 {
+		t.Errorf("%d files left in %s", len(infos), dir)
+	}
+
+	var loaded FileHashes
+	if err := loaded.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, hashes) {
+		t.Errorf("got %v, want %v", loaded, hashes)
+	}
+
+	for _, data := range []string{
+		`{"version": 2, "files": {}}`,
+		`{"version": 1, "files": []}`,
+	} {
+		var h FileHashes
+		if err := h.Unmarshal([]byte(data)); err == nil {
+			t.Errorf("%s: invalid data accepted", data)
+		}
+	}
+}
+
+func TestNewHasher(t *testing.T) {
+	// The empty file
+	expected := map[string]FileHash{
+		HashGitSHA1:   "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391",
+		HashGitSHA256: "git-sha256:473a0f4c3be8a93681a267e3b1e9a7dcda1185436fe141f7749120a303721813",
+		HashSHA256:    "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		HashBLAKE3:    "blake3:af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262",
+	}
+	for algorithm, want := range expected {
+		h, err := NewHasher(algorithm)
+		if err != nil {
+			t.Errorf("%s: %s", algorithm, err)
+			continue
+		}
+		fh, err := h.Hash("", "testdata/gosource/ignored.go")
+		if err != nil {
+			t.Errorf("%s: %s", algorithm, err)
+			continue
+		}
+		if fh != want {
+			t.Errorf("%s: got %s, want %s", algorithm, fh, want)
+		}
+		if fh.Algorithm() != algorithm || !strings.HasSuffix(string(want), fh.Sum()) {
+			t.Errorf("%s: got algorithm %s, sum %s", algorithm, fh.Algorithm(), fh.Sum())
+		}
+	}
+
+	if _, err := NewHasher("md5"); err != ErrorUnknownHash {
+		t.Errorf("md5: got %v", err)
+	}
+}
+
+func TestNormalizingHasher(t *testing.T) {
+	if got := NormalizedAlgorithm("sha256+trailing-space", []string{NormalizeCRLF, NormalizeTrailingSpace}); got != "sha256+crlf+trailing-space" {
+		t.Errorf("algorithm: got %s", got)
+	}
+	if got := NormalizedAlgorithm(HashGitSHA1, nil); got != HashGitSHA1 {
+		t.Errorf("no normalizations: got %s", got)
+	}
+
+	sha256 := func(content string) string {
+		fh, err := sha256Hasher{}.hashContent(strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fh.Sum()
+	}
+	tcs := []struct {
+		algorithm string
+		content   string
+		expected  string
+	}{
+		{"sha256+crlf", "a\r\nb \r\n", "a\nb \n"},
+		{"sha256+trailing-space", "a \t\r\nb  \nc ", "a\r\nb\nc"},
+		{"sha256+trailing-space+crlf", "a \r\nb\r\n", "a\nb\n"},
+		{"sha256+crlf", "\x00\r\n", "\x00\r\n"},
+	}
+	for _, tc := range tcs {
+		h, err := NewHasher(tc.algorithm)
+		if err != nil {
+			t.Errorf("%s: %s", tc.algorithm, err)
+			continue
+		}
+		fh, err := h.(contentHasher).hashContent(strings.NewReader(tc.content))
+		if err != nil {
+			t.Errorf("%s: %s", tc.algorithm, err)
+			continue
+		}
+		if fh.Algorithm() != NormalizedAlgorithm(tc.algorithm, nil) || fh.Sum() != sha256(tc.expected) {
+			t.Errorf("%s: %q: got %s", tc.algorithm, tc.content, fh)
+		}
+	}
+
+	// Git blob hashes are labelled with the algorithm.
+	h, err := NewHasher("git-sha1+crlf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh, err := h.Hash("", "testdata/gosource/ignored.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fh != "git-sha1+crlf:e69de29bb2d1d6434b8b29ae775ad8c2e48c5391" {
+		t.Errorf("git-sha1+crlf: got %s", fh)
+	}
+
+	if _, err := NewHasher("sha256+tabs"); err != ErrorUnknownHash {
+		t.Errorf("sha256+tabs: got %v", err)
+	}
+	if _, err := NewHasher("md5+crlf"); err != ErrorUnknownHash {
+		t.Errorf("md5+crlf: got %v", err)
+	}
+}
+
+func TestSetHashAlgorithm(t *testing.T) {
+	wt := &anyWorkingTree{
+		VCS:             vcs.ByCmd(vcsGit),
+		cache:           newFileHashesCache(fileHashesCacheSize),
+		nativeAlgorithm: HashGitSHA1,
+	}
+	wt.cache.add("v1.0.0", FileHashes{})
+	if err := wt.SetHashAlgorithm(HashBLAKE3); err != nil {
+		t.Fatal(err)
+	}
+	if wt.HashAlgorithm() != HashBLAKE3 {
+		t.Errorf("got %s", wt.HashAlgorithm())
+	}
+	if _, ok := wt.cache.get("v1.0.0"); ok {
+		t.Error("cached file hashes kept")
+	}
+	if err := wt.SetHashAlgorithm(HashGitSHA1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := wt.hasher.(*gitHasher); !ok {
+		t.Errorf("git working tree not using git for git-sha1: %T", wt.hasher)
+	}
+	if err := wt.SetHashAlgorithm("md5"); err == nil {
+		t.Error("unknown hash algorithm accepted")
+	}
+}
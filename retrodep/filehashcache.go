@@ -0,0 +1,163 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"container/list"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fileHashesCacheSize is the number of refs whose file hashes are
+// kept by each working tree.
+var fileHashesCacheSize = 16
+
+// fileHashesCache holds the file hashes for whole refs, discarding
+// those least recently used. Vendored projects sharing an upstream
+// repository ask for the same refs with different subPaths, and
+// these can then be answered without running the VCS again.
+type fileHashesCache struct {
+	size    int
+	order   *list.List // of *fileHashesEntry, most recent first
+	entries map[string]*list.Element
+}
+
+type fileHashesEntry struct {
+	ref    string
+	hashes FileHashes
+}
+
+func newFileHashesCache(size int) *fileHashesCache {
+	return &fileHashesCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the file hashes for ref, if they are cached.
+func (c *fileHashesCache) get(ref string) (FileHashes, bool) {
+	elem, ok := c.entries[ref]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*fileHashesEntry).hashes, true
+}
+
+// add caches the file hashes for ref.
+func (c *fileHashesCache) add(ref string, hashes FileHashes) {
+	if elem, ok := c.entries[ref]; ok {
+		elem.Value.(*fileHashesEntry).hashes = hashes
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[ref] = c.order.PushFront(&fileHashesEntry{ref, hashes})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		delete(c.entries, oldest.Value.(*fileHashesEntry).ref)
+		c.order.Remove(oldest)
+	}
+}
+
+// under returns the file hashes for paths within subPath, with
+// paths relative to it.
+func (h FileHashes) under(subPath string) FileHashes {
+	sub := make(FileHashes)
+	if subPath == "" {
+		for path, fileHash := range h {
+			sub[path] = fileHash
+		}
+		return sub
+	}
+	prefix := filepath.Clean(subPath) + string(filepath.Separator)
+	for path, fileHash := range h {
+		if strings.HasPrefix(path, prefix) {
+			sub[path[len(prefix):]] = fileHash
+		}
+	}
+	return sub
+}
+
+// FileHashesForSubPaths returns the file hashes for the tag or
+// revision ref within each of subPaths, keyed by subPath and relative
+// to it. The files at ref are found and walked only once, however
+// many subPaths there are, which helps when several modules or
+// vendored projects come from the same repository.
+func FileHashesForSubPaths(wt WorkingTree, ref string, subPaths []string) (map[string]FileHashes, error) {
+	all, err := wt.FileHashesFromRef(ref, "")
+	if err != nil {
+		return nil, err
+	}
+
+	bySubPath := make(map[string]FileHashes, len(subPaths))
+	for _, subPath := range subPaths {
+		bySubPath[subPath] = all.under(subPath)
+	}
+	return bySubPath, nil
+}
+
+// cachedFileHashes returns the file hashes for ref within subPath,
+// using fetch to find the file hashes for the whole of ref if they
+// are not already cached.
+func (wt *anyWorkingTree) cachedFileHashes(ref, subPath string, fetch func(ref, subPath string) (FileHashes, error)) (FileHashes, error) {
+	defer RecordPhase(PhaseHash, time.Now())
+	if wt.cache == nil {
+		hashes, err := fetch(ref, subPath)
+		if err != nil {
+			return nil, err
+		}
+		progress.FilesHashed(len(hashes))
+		return wt.excluding(hashes), nil
+	}
+	hashes, ok := wt.cache.get(ref)
+	recordCacheLookup(ok)
+	if !ok {
+		var err error
+		hashes, err = fetch(ref, "")
+		if err != nil {
+			return nil, err
+		}
+		progress.FilesHashed(len(hashes))
+		wt.cache.add(ref, hashes)
+	}
+	return wt.excluding(hashes.under(subPath)), nil
+}
+
+// excluding returns hashes without the paths matching hashExcludes
+// or the tree's own HashExcludes, and with only those matching
+// hashIncludes, unless the tree is including all files.
+func (wt *anyWorkingTree) excluding(hashes FileHashes) FileHashes {
+	if wt.includeAll {
+		return hashes
+	}
+	return hashes.excluding().without(wt.excludes)
+}
+
+// allFilesWorkingTree is a WorkingTree whose file hashes can be made
+// to include the paths matching hashExcludes.
+type allFilesWorkingTree interface {
+	// includeAllFiles sets whether all files are included, and
+	// returns the previous setting.
+	includeAllFiles(all bool) bool
+}
+
+func (wt *anyWorkingTree) includeAllFiles(all bool) bool {
+	prev := wt.includeAll
+	wt.includeAll = all
+	return prev
+}
@@ -0,0 +1,134 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFileHashesCache(t *testing.T) {
+	c := newFileHashesCache(2)
+	c.add("r1", FileHashes{"a": "1"})
+	c.add("r2", FileHashes{"a": "2"})
+	if _, ok := c.get("r1"); !ok {
+		t.Fatal("r1 not cached")
+	}
+	c.add("r3", FileHashes{"a": "3"})
+	if _, ok := c.get("r2"); ok {
+		t.Error("least recently used ref not evicted")
+	}
+	for _, ref := range []string{"r1", "r3"} {
+		if _, ok := c.get(ref); !ok {
+			t.Errorf("%s evicted", ref)
+		}
+	}
+}
+
+func TestCachedFileHashes(t *testing.T) {
+	var fetched []string
+	fetch := func(ref, subPath string) (FileHashes, error) {
+		fetched = append(fetched, ref+":"+subPath)
+		return FileHashes{
+			"top.go":         "1",
+			"sub/a.go":       "2",
+			"sub/inner/b.go": "3",
+			"subdir/c.go":    "4",
+		}, nil
+	}
+
+	wt := &anyWorkingTree{cache: newFileHashesCache(fileHashesCacheSize)}
+	hashes, err := wt.cachedFileHashes("v1.0.0", "sub", fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := FileHashes{"a.go": "2", "inner/b.go": "3"}
+	if !reflect.DeepEqual(hashes, expected) {
+		t.Errorf("got %v, want %v", hashes, expected)
+	}
+
+	// Changes made by the caller must not affect the cache.
+	delete(hashes, "a.go")
+	hashes, err = wt.cachedFileHashes("v1.0.0", "sub", fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hashes, expected) {
+		t.Errorf("got %v, want %v", hashes, expected)
+	}
+
+	hashes, err = wt.cachedFileHashes("v1.0.0", "", fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 4 {
+		t.Errorf("got %v", hashes)
+	}
+	if !reflect.DeepEqual(fetched, []string{"v1.0.0:"}) {
+		t.Errorf("fetched %v", fetched)
+	}
+
+	// Without a cache, the subPath is passed on.
+	fetched = nil
+	wt = &anyWorkingTree{}
+	if _, err := wt.cachedFileHashes("v1.0.0", "sub", fetch); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fetched, []string{"v1.0.0:sub"}) {
+		t.Errorf("fetched %v", fetched)
+	}
+}
+
+func TestFileHashesForSubPaths(t *testing.T) {
+	wt := &subPathsWorkingTree{}
+	bySubPath, err := FileHashesForSubPaths(wt, "v1.0.0", []string{"", "sub", "sub/inner", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]FileHashes{
+		"":          wt.all(),
+		"sub":       {"a.go": "2", "inner/b.go": "3"},
+		"sub/inner": {"b.go": "3"},
+		"missing":   {},
+	}
+	if !reflect.DeepEqual(bySubPath, expected) {
+		t.Errorf("got %v, want %v", bySubPath, expected)
+	}
+	if !reflect.DeepEqual(wt.subPaths, []string{""}) {
+		t.Errorf("FileHashesFromRef called for %q", wt.subPaths)
+	}
+}
+
+// subPathsWorkingTree is a mock WorkingTree recording the subPaths
+// file hashes are asked for.
+type subPathsWorkingTree struct {
+	stubWorkingTree
+	subPaths []string
+}
+
+func (wt *subPathsWorkingTree) all() FileHashes {
+	return FileHashes{
+		"top.go":         "1",
+		"sub/a.go":       "2",
+		"sub/inner/b.go": "3",
+		"subdir/c.go":    "4",
+	}
+}
+
+func (wt *subPathsWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	wt.subPaths = append(wt.subPaths, subPath)
+	return wt.all(), nil
+}
@@ -0,0 +1,142 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Statuses a FileMatch can have.
+const (
+	// FileMatched is for a file which is the same upstream.
+	FileMatched = "matched"
+
+	// FileModified is for a file which differs from upstream.
+	FileModified = "modified"
+
+	// FileAdded is for a file which is not upstream.
+	FileAdded = "added"
+
+	// FileMissing is for an upstream file which is not in the
+	// copy, although others in the same directory are.
+	FileMissing = "missing"
+)
+
+// FileMatch describes how a file in a copy of a project compares
+// with an upstream ref.
+type FileMatch struct {
+	// Path is the file's path, relative to the project.
+	Path string `json:"path"`
+
+	// Status is one of the File... constants.
+	Status string `json:"status"`
+
+	// Rev is the upstream revision which last changed the file,
+	// if it is upstream and the VCS tells.
+	Rev string `json:"rev,omitempty"`
+}
+
+// VendoredFileMatches compares each file in the vendored copy of
+// project with upstream ref in wt, returning a FileMatch for each,
+// and for each upstream file missing from the directories vendored,
+// in path order.
+func (src GoSource) VendoredFileMatches(project *RepoPath, wt WorkingTree, ref string) ([]FileMatch, error) {
+	projDir := filepath.Join(src.Vendor(), filepath.FromSlash(project.Root))
+	return src.FileMatches(project, wt, projDir, ref)
+}
+
+// FileMatches is VendoredFileMatches for the project whose files are
+// in dir. Import comments are not stripped for godep.
+func (src GoSource) FileMatches(project *RepoPath, wt WorkingTree, dir, ref string) ([]FileMatch, error) {
+	local, err := src.hashLocalFiles(wt, project, dir)
+	if err != nil {
+		return nil, err
+	}
+	upstream, err := wt.FileHashesFromRef(ref, project.SubPath)
+	if err != nil {
+		return nil, err
+	}
+	last, err := wt.LastRevisions(ref, project.SubPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make(map[string]bool)
+	var matches []FileMatch
+	for path, hash := range local {
+		dirs[filepath.Dir(path)] = true
+		status := FileMatched
+		if upstreamHash, ok := upstream[path]; !ok {
+			status = FileAdded
+		} else if upstreamHash != hash {
+			status = FileModified
+		}
+		match := FileMatch{Path: path, Status: status}
+		if status != FileAdded {
+			match.Rev = last[path]
+		}
+		matches = append(matches, match)
+	}
+	for path := range upstream {
+		if _, ok := local[path]; ok || strings.HasPrefix(path, ".") {
+			continue
+		}
+		if dirs[filepath.Dir(path)] {
+			matches = append(matches, FileMatch{Path: path, Status: FileMissing, Rev: last[path]})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Path < matches[j].Path
+	})
+	return matches, nil
+}
+
+// FileChanges lists, by path, the files of a copy of a project which
+// did not match an upstream ref, so that carried patches, generated
+// files and files removed from the copy can be audited.
+type FileChanges struct {
+	// Added are the files in the copy which are not upstream.
+	Added []string `json:"added,omitempty"`
+
+	// Modified are the files which differ from upstream.
+	Modified []string `json:"modified,omitempty"`
+
+	// Missing are the upstream files which are not in the copy,
+	// although others in the same directory are.
+	Missing []string `json:"missing,omitempty"`
+}
+
+// NewFileChanges returns the FileChanges for files, as returned by
+// FileMatches, or nil if every file matched.
+func NewFileChanges(files []FileMatch) *FileChanges {
+	var changes FileChanges
+	for _, f := range files {
+		switch f.Status {
+		case FileAdded:
+			changes.Added = append(changes.Added, f.Path)
+		case FileModified:
+			changes.Modified = append(changes.Modified, f.Path)
+		case FileMissing:
+			changes.Missing = append(changes.Missing, f.Path)
+		}
+	}
+	if changes.Added == nil && changes.Modified == nil && changes.Missing == nil {
+		return nil
+	}
+	return &changes
+}
@@ -0,0 +1,114 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestFileMatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-filematch.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"a.go":       "package a\n",
+		"b.go":       "package a // b\n",
+		"local.go":   "package a // local\n",
+		"sub/sub.go": "package sub\n",
+	})
+	src, err := NewGoSource(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proj := &RepoPath{RepoRoot: vcs.RepoRoot{Root: "example.com/a"}}
+	wt := &nearWorkingTree{}
+	wt.hasher = &sha256Hasher{}
+	wt.localHashes, err = src.hashLocalFiles(wt, proj, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(wt.localHashes, "local.go")
+	wt.localHashes["sub/gone.go"] = "gone"
+	wt.localHashes["other/other.go"] = "other"
+	wt.localHashes[".travis.yml"] = "travis"
+	wt.differ = map[string][]string{"v1.0.0": {"b.go"}}
+
+	last := &lastRevisionsWorkingTree{nearWorkingTree: wt}
+	last.revs = map[string]string{
+		"a.go":        "r1",
+		"b.go":        "r2",
+		"sub/gone.go": "r3",
+	}
+
+	files, err := src.FileMatches(proj, last, dir, "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []FileMatch{
+		{Path: "a.go", Status: FileMatched, Rev: "r1"},
+		{Path: "b.go", Status: FileModified, Rev: "r2"},
+		{Path: "local.go", Status: FileAdded},
+		{Path: "sub/gone.go", Status: FileMissing, Rev: "r3"},
+		{Path: "sub/sub.go", Status: FileMatched},
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("got %+v, want %+v", files, expected)
+	}
+
+	if _, err := src.FileMatches(proj, wt, dir, "v2.0.0"); err != ErrorInvalidRef {
+		t.Errorf("unknown ref: got %v", err)
+	}
+}
+
+// lastRevisionsWorkingTree is a mock WorkingTree giving the last
+// revisions in revs.
+type lastRevisionsWorkingTree struct {
+	*nearWorkingTree
+	revs map[string]string
+}
+
+func (wt *lastRevisionsWorkingTree) LastRevisions(ref, subPath string) (map[string]string, error) {
+	return wt.revs, nil
+}
+
+func TestNewFileChanges(t *testing.T) {
+	changes := NewFileChanges([]FileMatch{
+		{Path: "a.go", Status: FileMatched},
+		{Path: "b.go", Status: FileModified},
+		{Path: "gen.go", Status: FileAdded},
+		{Path: "local.go", Status: FileAdded},
+		{Path: "sub/gone.go", Status: FileMissing},
+	})
+	expected := &FileChanges{
+		Added:    []string{"gen.go", "local.go"},
+		Modified: []string{"b.go"},
+		Missing:  []string{"sub/gone.go"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("got %+v, want %+v", changes, expected)
+	}
+
+	if changes := NewFileChanges([]FileMatch{{Path: "a.go", Status: FileMatched}}); changes != nil {
+		t.Errorf("all matched: got %+v", changes)
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// fingerprintIndexVersion is the version of the format written by
+// FingerprintIndex.Write.
+const fingerprintIndexVersion = 1
+
+// A FingerprintIndex records the file hashes of the fingerprint
+// files, those matching its file patterns, at each tag and revision
+// of candidate repositories. Looking up the hashes of a few files
+// from a copy of a project finds the refs it may have been taken
+// from, so that these can be tried without listing the history of
+// the repository. Set it with GoSource.SetFingerprintIndex.
+type FingerprintIndex struct {
+	// Version is the version of the format.
+	Version int `json:"version"`
+
+	// Files holds the file classes and patterns, as for
+	// ParseHashFiles, of the fingerprint files.
+	Files []string `json:"files"`
+
+	// Repos maps the URL of each repository indexed to its
+	// fingerprints.
+	Repos map[string]*indexedRepo `json:"repos"`
+
+	// files holds the patterns parsed from Files.
+	files Ignore
+}
+
+// indexedRepo holds the fingerprints of a repository.
+type indexedRepo struct {
+	// HashAlgorithm is the hash algorithm of the file hashes.
+	HashAlgorithm string `json:"hashAlgorithm"`
+
+	// Tags are the version tags indexed.
+	Tags []string `json:"tags,omitempty"`
+
+	// Revisions are the revisions indexed, newest first.
+	Revisions []string `json:"revisions,omitempty"`
+
+	// Files maps the hash and path of each fingerprint file,
+	// separated by a space, to the ranges of refs at which it is
+	// present. Refs are numbered through Tags then Revisions.
+	Files map[string][]refRange `json:"files"`
+}
+
+// refRange is the first and last of a run of refs, numbered as for
+// indexedRepo.Files.
+type refRange [2]int
+
+// NewFingerprintIndex returns an empty FingerprintIndex whose
+// fingerprint files are given by file classes and patterns, as for
+// ParseHashFiles, or are Go source files if there are none.
+func NewFingerprintIndex(files []string) (*FingerprintIndex, error) {
+	if len(files) == 0 {
+		files = []string{FilesGo}
+	}
+	x := &FingerprintIndex{
+		Version: fingerprintIndexVersion,
+		Files:   files,
+		Repos:   make(map[string]*indexedRepo),
+	}
+	var err error
+	x.files, err = ParseHashFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// ReadFingerprintIndex reads a FingerprintIndex written by Write
+// from r.
+func ReadFingerprintIndex(r io.Reader) (*FingerprintIndex, error) {
+	var x FingerprintIndex
+	if err := json.NewDecoder(r).Decode(&x); err != nil {
+		return nil, err
+	}
+	if x.Version != fingerprintIndexVersion {
+		return nil, fmt.Errorf("unsupported fingerprint index version %d", x.Version)
+	}
+	var err error
+	x.files, err = ParseHashFiles(x.Files)
+	if err != nil {
+		return nil, err
+	}
+	if x.Repos == nil {
+		x.Repos = make(map[string]*indexedRepo)
+	}
+	return &x, nil
+}
+
+// Write writes the index to w, as JSON.
+func (x *FingerprintIndex) Write(w io.Writer) error {
+	return json.NewEncoder(w).Encode(x)
+}
+
+// Index records the fingerprints of the version tags and revisions
+// of wt, a working tree for the repository at repo, replacing any
+// already recorded for it.
+func (x *FingerprintIndex) Index(repo string, wt WorkingTree) error {
+	tags, err := wt.VersionTags()
+	if err != nil {
+		return err
+	}
+	var revs []string
+	err = RevisionsIter(wt, func(rev string) (bool, error) {
+		revs = append(revs, rev)
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r := &indexedRepo{
+		HashAlgorithm: wt.HashAlgorithm(),
+		Tags:          tags,
+		Revisions:     revs,
+		Files:         make(map[string][]refRange),
+	}
+	for i, ref := range append(tags[:len(tags):len(tags)], revs...) {
+		hashes, err := wt.FileHashesFromRef(ref, "")
+		if err != nil {
+			return fmt.Errorf("%s: %s", ref, err)
+		}
+		for p, fileHash := range hashes.including(x.files) {
+			key := fingerprintKey(fileHash, filepath.ToSlash(p))
+			ranges := r.Files[key]
+			if n := len(ranges); n > 0 && ranges[n-1][1] == i-1 {
+				ranges[n-1][1] = i
+			} else {
+				r.Files[key] = append(ranges, refRange{i, i})
+			}
+		}
+	}
+	log.Debugf("%s: indexed %d tags and %d revisions", repo, len(tags), len(revs))
+	x.Repos[repo] = r
+	return nil
+}
+
+// fingerprintKey returns the key in indexedRepo.Files for the file
+// at the slash-separated path p with hash fileHash.
+func fingerprintKey(fileHash FileHash, p string) string {
+	return string(fileHash) + " " + p
+}
+
+// candidates returns the tags and revisions of the repository at
+// repo at which the fingerprint files among the local files in any
+// of layouts are as they are locally. It returns false if the
+// repository was not indexed with the hash algorithm, or none of
+// the local files are fingerprint files.
+func (x *FingerprintIndex) candidates(repo, algorithm string, layouts []moduleLayout) ([]string, []string, bool) {
+	if x == nil {
+		return nil, nil, false
+	}
+	r := x.Repos[repo]
+	if r == nil || r.HashAlgorithm != algorithm {
+		return nil, nil, false
+	}
+	found := make(map[int]bool)
+	indexed := false
+	for _, layout := range layouts {
+		probes := probePaths(layout.hashes.including(x.files))
+		if len(probes) == 0 {
+			continue
+		}
+		indexed = true
+		var refs map[int]bool
+		for _, local := range probes {
+			repoPath := path.Join(filepath.ToSlash(layout.subPath), filepath.ToSlash(local))
+			at := make(map[int]bool)
+			for _, rr := range r.Files[fingerprintKey(layout.hashes[local], repoPath)] {
+				for i := rr[0]; i <= rr[1]; i++ {
+					if refs == nil || refs[i] {
+						at[i] = true
+					}
+				}
+			}
+			refs = at
+		}
+		for i := range refs {
+			found[i] = true
+		}
+	}
+	if !indexed {
+		return nil, nil, false
+	}
+
+	numbers := make([]int, 0, len(found))
+	for i := range found {
+		numbers = append(numbers, i)
+	}
+	sort.Ints(numbers)
+	var tags, revs []string
+	for _, i := range numbers {
+		if i < len(r.Tags) {
+			tags = append(tags, r.Tags[i])
+		} else if i-len(r.Tags) < len(r.Revisions) {
+			revs = append(revs, r.Revisions[i-len(r.Tags)])
+		}
+	}
+	return tags, revs, true
+}
+
+// SetFingerprintIndex makes DescribeProject try first the refs which
+// x finds for projects from repositories it has indexed. Tags not in
+// the index, and revisions newer than those in it, are tried too.
+// If none match, the repository is searched as usual.
+func (src *GoSource) SetFingerprintIndex(x *FingerprintIndex) {
+	src.index = x
+}
+
+// describeFromIndex tries the refs the fingerprint index finds for
+// project's layouts, with tags those of wt. It returns
+// ErrorVersionNotFound if the repository was not indexed or none
+// match.
+func (src GoSource) describeFromIndex(ref *Reference, project *RepoPath, wt WorkingTree, layouts []moduleLayout, tags []string) (*Reference, error) {
+	candidateTags, candidateRevs, ok := src.index.candidates(project.Repo, wt.HashAlgorithm(), layouts)
+	if !ok {
+		return nil, ErrorVersionNotFound
+	}
+	r := src.index.Repos[project.Repo]
+	matchLog.Debugf("%s: fingerprint index found %d tags and %d revisions",
+		project.Root, len(candidateTags), len(candidateRevs))
+
+	// Tags made since the repository was indexed are tried too.
+	indexedTags := make(map[string]bool, len(r.Tags))
+	for _, tag := range r.Tags {
+		indexedTags[tag] = true
+	}
+	current := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		current[tag] = true
+	}
+	var tryTags []string
+	for _, tag := range tags {
+		if !indexedTags[tag] {
+			tryTags = append(tryTags, tag)
+		}
+	}
+	for _, tag := range candidateTags {
+		if current[tag] {
+			tryTags = append(tryTags, tag)
+		}
+	}
+	if len(tryTags) > 0 {
+		matches, err := matchLayoutsFromRefs(false, layouts, wt, tryTags)
+		switch err {
+		case nil:
+			return src.describeTag(ref, project, wt, sortedTags(tags, matches))
+		case ErrorVersionNotFound:
+		default:
+			return nil, err
+		}
+	}
+
+	// So are revisions newer than the newest indexed, unless it
+	// is no longer listed, when the index is out of date.
+	var newRevs []string
+	if len(r.Revisions) > 0 {
+		newest := r.Revisions[0]
+		found := false
+		err := RevisionsIter(wt, func(rev string) (bool, error) {
+			if rev == newest {
+				found = true
+				return true, nil
+			}
+			newRevs = append(newRevs, rev)
+			return false, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			matchLog.Debugf("%s: %s not found, not using the fingerprint index",
+				project.Root, newest)
+			return nil, ErrorVersionNotFound
+		}
+	}
+	tryRevs := append(newRevs, candidateRevs...)
+	if len(tryRevs) == 0 {
+		return nil, ErrorVersionNotFound
+	}
+	matches, err := matchLayoutsFromRefs(false, layouts, wt, tryRevs)
+	if err != nil {
+		return nil, err
+	}
+	return describeRevision(ref, wt, matches[0])
+}
+
+// sortedTags returns those of tags which are in matches, in the
+// order of tags.
+func sortedTags(tags, matches []string) []string {
+	matched := make(map[string]bool, len(matches))
+	for _, tag := range matches {
+		matched[tag] = true
+	}
+	var sorted []string
+	for _, tag := range tags {
+		if matched[tag] {
+			sorted = append(sorted, tag)
+		}
+	}
+	return sorted
+}
@@ -0,0 +1,151 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// indexedWorkingTree has the tag v1.0.0 and revisions r0 (newest)
+// to r999, of which r500 to r510 have the local files, and counts
+// the revisions listed.
+type indexedWorkingTree struct {
+	stubWorkingTree
+	local  FileHashes
+	newer  []string
+	listed int
+}
+
+func (wt *indexedWorkingTree) VersionTags() ([]string, error) {
+	return []string{"v1.0.0"}, nil
+}
+
+func (wt *indexedWorkingTree) RevisionsIter(yield func(rev string) (bool, error)) error {
+	revs := append([]string{}, wt.newer...)
+	for i := 0; i < 1000; i++ {
+		revs = append(revs, fmt.Sprintf("r%d", i))
+	}
+	for _, rev := range revs {
+		wt.listed++
+		stop, err := yield(rev)
+		if err != nil || stop {
+			return err
+		}
+	}
+	return nil
+}
+
+func (wt *indexedWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	var i int
+	fmt.Sscanf(ref, "r%d", &i)
+	hashes := FileHashes{"README.md": "readme", "b.go": wt.local["b.go"]}
+	if ref[0] == 'r' && i >= 500 && i <= 510 {
+		hashes["a.go"] = wt.local["a.go"]
+	} else {
+		hashes["a.go"] = FileHash("a.go at " + ref)
+	}
+	return hashes, nil
+}
+
+func (wt *indexedWorkingTree) ReachableTag(rev string) (string, error) {
+	return "", ErrorVersionNotFound
+}
+
+func TestFingerprintIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-fingerprint.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"a.go": "package a\n",
+		"b.go": "package a // b\n",
+	})
+	src, err := NewGoSource(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proj := &RepoPath{RepoRoot: vcs.RepoRoot{Root: "example.com/a", Repo: "https://example.com/a"}}
+	wt := &indexedWorkingTree{stubWorkingTree: stubWorkingTree{
+		anyWorkingTree: anyWorkingTree{hasher: &sha256Hasher{}},
+	}}
+	wt.local, err = src.hashLocalFiles(wt, proj, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, err := NewFingerprintIndex(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Index(proj.Repo, wt); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := x.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	x, err = ReadFingerprintIndex(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := x.Repos[proj.Repo]
+	if ranges := r.Files[fingerprintKey(wt.local["b.go"], "b.go")]; !reflect.DeepEqual(ranges, []refRange{{0, 1000}}) {
+		t.Errorf("b.go: %v", ranges)
+	}
+	if _, ok := r.Files[fingerprintKey("readme", "README.md")]; ok {
+		t.Error("README.md indexed")
+	}
+
+	layouts := []moduleLayout{{wt.local, ""}}
+	tags, revs, ok := x.candidates(proj.Repo, "", layouts)
+	if !ok || len(tags) != 0 || len(revs) != 11 || revs[0] != "r500" {
+		t.Errorf("candidates: %v, %v, %v", tags, revs, ok)
+	}
+	if _, _, ok := x.candidates("https://example.com/other", "", layouts); ok {
+		t.Error("unindexed repository has candidates")
+	}
+
+	// Only the newest revision is listed, to find newer ones.
+	src.SetFingerprintIndex(x)
+	wt.listed = 0
+	ref, err := src.DescribeProject(proj, wt, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Rev != "r500" || wt.listed != 1 {
+		t.Errorf("got %s, listing %d revisions", ref.Rev, wt.listed)
+	}
+
+	// Once the index is out of date, the history is searched.
+	wt.newer = []string{"s0"}
+	x.Repos[proj.Repo].Revisions[0] = "gone"
+	wt.listed = 0
+	ref, err = src.DescribeProject(proj, wt, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Rev != "r500" || wt.listed < 1000 {
+		t.Errorf("got %s, listing %d revisions", ref.Rev, wt.listed)
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+// This file contains methods for querying forge (e.g. GitHub) APIs
+// about upstream repositories without cloning them.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+var githubAPI = "https://api.github.com"
+
+var forgeClient = &http.Client{
+	Timeout:   30 * time.Second,
+	Transport: NewCredentialsTransport(nil),
+}
+
+// forgeArchiveClient is the HTTP client for downloading archives
+// from forges, which takes longer than other requests.
+var forgeArchiveClient = &http.Client{
+	Timeout:   5 * time.Minute,
+	Transport: NewCredentialsTransport(nil),
+}
+
+// githubRepo returns the "owner/name" path for a GitHub repository
+// URL, or false if the URL is not for github.com.
+func githubRepo(repo string) (string, bool) {
+	u, err := url.Parse(repo)
+	if err != nil || u.Host != "github.com" {
+		return "", false
+	}
+	p := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	if strings.Count(p, "/") != 1 {
+		return "", false
+	}
+	return p, true
+}
+
+// forgeGet requests url from the GitHub API, returning the response
+// if its status is OK. It returns ErrorForgeLimited if the request
+// cannot be made because of rate limits, and ErrorVersionNotFound if
+// the forge does not have url.
+func forgeGet(url string) (*http.Response, error) {
+	return githubDo(forgeClient, url)
+}
+
+// githubDo is forgeGet, using client.
+func githubDo(client *http.Client, url string) (*http.Response, error) {
+	for {
+		token, err := forgeLimit.acquire()
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if token.token != "" {
+			req.Header.Set("Authorization", "Bearer "+token.token)
+		}
+		resp, err := hostLimitedDo(client, req)
+		if err != nil {
+			return nil, err
+		}
+		if forgeLimit.update(token, resp) {
+			// Try another token.
+			resp.Body.Close()
+			continue
+		}
+		return forgeResponse(url, resp)
+	}
+}
+
+// forgeResponse returns resp if its status is OK, and otherwise
+// closes it and returns an error.
+func forgeResponse(url string, resp *http.Response) (*http.Response, error) {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrorVersionNotFound
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("%s: %s", url, resp.Status)
+}
+
+// getJSON fetches url from the GitHub API and decodes the JSON
+// response into v. It returns ErrorForgeLimited if the request cannot
+// be made because of rate limits.
+func getJSON(url string, v interface{}) error {
+	return decodeJSON(forgeGet, url, v)
+}
+
+// decodeJSON fetches url using get and decodes the JSON response
+// into v.
+func decodeJSON(get func(url string) (*http.Response, error), url string, v interface{}) error {
+	resp, err := get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+var gitlabAPI = "https://gitlab.com/api/v4"
+
+// gitlabToken is the API token for GitLab requests, or "" for none.
+var gitlabToken string
+
+// SetGitLabToken sets the API token used for GitLab requests. With no
+// token, requests are made without authentication.
+func SetGitLabToken(token string) {
+	gitlabToken = token
+}
+
+// gitlabRepo returns the project path, including any subgroups, for
+// a GitLab repository URL, or false if the URL is not for gitlab.com.
+func gitlabRepo(repo string) (string, bool) {
+	u, err := url.Parse(repo)
+	if err != nil || u.Host != "gitlab.com" {
+		return "", false
+	}
+	p := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	if !strings.Contains(p, "/") {
+		return "", false
+	}
+	return p, true
+}
+
+// gitlabGet requests url from the GitLab API, returning the response
+// if its status is OK. It counts towards the forge request budget.
+func gitlabGet(url string) (*http.Response, error) {
+	return gitlabDo(forgeClient, url)
+}
+
+// gitlabDo is gitlabGet, using client.
+func gitlabDo(client *http.Client, url string) (*http.Response, error) {
+	if err := forgeLimit.spend(); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if gitlabToken != "" {
+		req.Header.Set("PRIVATE-TOKEN", gitlabToken)
+	}
+	resp, err := hostLimitedDo(client, req)
+	if err != nil {
+		return nil, err
+	}
+	return forgeResponse(url, resp)
+}
+
+// EstimateRepoSize returns the approximate size in bytes of the
+// upstream repository, without cloning it. It returns
+// ErrorSizeUnknown if the size cannot be found out.
+func EstimateRepoSize(root *vcs.RepoRoot) (int64, error) {
+	p, ok := githubRepo(root.Repo)
+	if !ok {
+		return 0, ErrorSizeUnknown
+	}
+
+	var info struct {
+		// Size is in kilobytes
+		Size int64 `json:"size"`
+	}
+	if err := getJSON(githubAPI+"/repos/"+p, &info); err != nil {
+		log.Debugf("%s: %s", root.Repo, err)
+		return 0, ErrorSizeUnknown
+	}
+	return info.Size * 1024, nil
+}
+
+// RepoHealth describes the state of an upstream repository, as
+// reported by its forge.
+type RepoHealth struct {
+	// Pkg is the import path of the project.
+	Pkg string `json:"pkg"`
+
+	// Repo is the URL of the repository.
+	Repo string `json:"repo"`
+
+	// Archived is true if the repository is read-only.
+	Archived bool `json:"archived,omitempty"`
+
+	// MovedTo is the URL the repository has been transferred or
+	// renamed to, or "" if it has not moved.
+	MovedTo string `json:"movedTo,omitempty"`
+
+	// LastPush is when commits were last pushed.
+	LastPush time.Time `json:"lastPush"`
+}
+
+// Inactive returns true if there have been no commits pushed for
+// longer than d before now.
+func (h *RepoHealth) Inactive(d time.Duration, now time.Time) bool {
+	return now.Sub(h.LastPush) > d
+}
+
+// CheckRepoHealth returns the state of the upstream repository for
+// ref, without cloning it. It returns ErrorHealthUnknown if this
+// cannot be found out, or ErrorForgeLimited if the forge API cannot
+// be used because of rate limits.
+func CheckRepoHealth(ref *Reference) (*RepoHealth, error) {
+	p, ok := githubRepo(ref.Repo)
+	if !ok {
+		return nil, ErrorHealthUnknown
+	}
+
+	var info struct {
+		Archived bool      `json:"archived"`
+		FullName string    `json:"full_name"`
+		HTMLURL  string    `json:"html_url"`
+		PushedAt time.Time `json:"pushed_at"`
+	}
+	if err := getJSON(githubAPI+"/repos/"+p, &info); err != nil {
+		log.Debugf("%s: %s", ref.Repo, err)
+		if err == ErrorForgeLimited {
+			return nil, err
+		}
+		return nil, ErrorHealthUnknown
+	}
+
+	health := &RepoHealth{
+		Pkg:      ref.Pkg,
+		Repo:     ref.Repo,
+		Archived: info.Archived,
+		LastPush: info.PushedAt,
+	}
+	// GitHub redirects requests for repositories which have been
+	// transferred or renamed.
+	if !strings.EqualFold(info.FullName, p) {
+		health.MovedTo = info.HTMLURL
+	}
+	return health, nil
+}
+
+// RepoHealthFromWorkingTree returns what can be found out about the
+// state of the upstream repository for ref from its local checkout
+// wt, for when the forge cannot be asked: only when the latest
+// commit was made. It returns ErrorHealthUnknown if there are no
+// commits.
+func RepoHealthFromWorkingTree(ref *Reference, wt WorkingTree) (*RepoHealth, error) {
+	// The newest revision is listed first.
+	var newest string
+	err := RevisionsIter(wt, func(rev string) (bool, error) {
+		newest = rev
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if newest == "" {
+		return nil, ErrorHealthUnknown
+	}
+	last, err := wt.TimeFromRevision(newest)
+	if err != nil {
+		return nil, err
+	}
+	return &RepoHealth{
+		Pkg:      ref.Pkg,
+		Repo:     ref.Repo,
+		LastPush: last,
+	}, nil
+}
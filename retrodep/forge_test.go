@@ -0,0 +1,128 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// mockGithubAPI serves canned responses for GitHub API paths and
+// points githubAPI at the server. The returned function should be
+// deferred to reset githubAPI.
+func mockGithubAPI(t *testing.T, responses map[string]string) func() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	orig := githubAPI
+	githubAPI = srv.URL
+	return func() {
+		githubAPI = orig
+		srv.Close()
+	}
+}
+
+func TestGithubRepo(t *testing.T) {
+	tcs := []struct {
+		repo string
+		exp  string
+		ok   bool
+	}{
+		{"https://github.com/foo/bar", "foo/bar", true},
+		{"https://github.com/foo/bar.git", "foo/bar", true},
+		{"https://github.com/foo", "", false},
+		{"https://gitlab.com/foo/bar", "", false},
+	}
+	for _, tc := range tcs {
+		p, ok := githubRepo(tc.repo)
+		if ok != tc.ok || p != tc.exp {
+			t.Errorf("%s: got %q,%t, want %q,%t", tc.repo, p, ok, tc.exp, tc.ok)
+		}
+	}
+}
+
+func TestEstimateRepoSize(t *testing.T) {
+	defer mockGithubAPI(t, map[string]string{
+		"/repos/foo/bar": `{"size": 2}`,
+	})()
+
+	size, err := EstimateRepoSize(&vcs.RepoRoot{Repo: "https://github.com/foo/bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 2048 {
+		t.Errorf("got %d, want %d", size, 2048)
+	}
+
+	_, err = EstimateRepoSize(&vcs.RepoRoot{Repo: "https://github.com/foo/missing"})
+	if err != ErrorSizeUnknown {
+		t.Errorf("missing: got %v, want %v", err, ErrorSizeUnknown)
+	}
+
+	_, err = EstimateRepoSize(&vcs.RepoRoot{Repo: "https://example.com/foo/bar"})
+	if err != ErrorSizeUnknown {
+		t.Errorf("unknown host: got %v, want %v", err, ErrorSizeUnknown)
+	}
+}
+
+func TestCheckRepoHealth(t *testing.T) {
+	defer mockGithubAPI(t, map[string]string{
+		"/repos/foo/bar": `{"archived": true, "full_name": "Foo/bar",
+			"html_url": "https://github.com/Foo/bar",
+			"pushed_at": "2015-01-02T03:04:05Z"}`,
+		"/repos/foo/old": `{"full_name": "eggs/new",
+			"html_url": "https://github.com/eggs/new",
+			"pushed_at": "2019-01-02T03:04:05Z"}`,
+	})()
+
+	health, err := CheckRepoHealth(&Reference{Pkg: "github.com/foo/bar", Repo: "https://github.com/foo/bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !health.Archived || health.MovedTo != "" {
+		t.Errorf("foo/bar: unexpected %+v", health)
+	}
+	now := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	if !health.Inactive(2*365*24*time.Hour, now) {
+		t.Errorf("foo/bar: not inactive")
+	}
+
+	health, err = CheckRepoHealth(&Reference{Pkg: "github.com/foo/old", Repo: "https://github.com/foo/old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if health.Archived || health.MovedTo != "https://github.com/eggs/new" {
+		t.Errorf("foo/old: unexpected %+v", health)
+	}
+	if health.Inactive(2*365*24*time.Hour, now) {
+		t.Errorf("foo/old: inactive")
+	}
+
+	_, err = CheckRepoHealth(&Reference{Pkg: "example.com/foo", Repo: "https://example.com/foo"})
+	if err != ErrorHealthUnknown {
+		t.Errorf("unknown host: got %v, want %v", err, ErrorHealthUnknown)
+	}
+}
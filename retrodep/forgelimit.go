@@ -0,0 +1,172 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+// This file contains the rate limiting shared by the forge API
+// requests of a run.
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// forgeNow returns the current time, and is a variable so it can be
+// replaced for tests.
+var forgeNow = time.Now
+
+// forgeLimitedWait is how long a token which was refused without a
+// usable reset time is left unused.
+const forgeLimitedWait = time.Minute
+
+// forgeToken is an API token, or none if token is "", and what the
+// forge has said about its rate limit.
+type forgeToken struct {
+	token string
+
+	// remaining is the number of requests left until reset, or -1
+	// if this is not known.
+	remaining int
+	reset     time.Time
+}
+
+// forgeLimiter chooses the token for each forge API request, in
+// turn, skipping those which have no requests left until their rate
+// limit resets. It also limits the number of requests made in total.
+type forgeLimiter struct {
+	mu     sync.Mutex
+	tokens []*forgeToken
+	next   int
+
+	// budget is the number of requests allowed, or 0 for no limit.
+	budget int
+	used   int
+}
+
+// newForgeLimiter returns a forgeLimiter using tokens, or no token
+// if there are none.
+func newForgeLimiter(tokens []string, budget int) *forgeLimiter {
+	if len(tokens) == 0 {
+		tokens = []string{""}
+	}
+	l := &forgeLimiter{budget: budget}
+	for _, token := range tokens {
+		l.tokens = append(l.tokens, &forgeToken{token: token, remaining: -1})
+	}
+	return l
+}
+
+// forgeLimit is the forgeLimiter used for all forge API requests.
+var forgeLimit = newForgeLimiter(nil, 0)
+
+// SetForgeTokens sets the API tokens used for forge requests. They
+// are used in turn, so that their rate limits are shared. With no
+// tokens, requests are made without authentication, except for any
+// credentials found by CredentialsFor.
+func SetForgeTokens(tokens ...string) {
+	l := newForgeLimiter(tokens, 0)
+	forgeLimit.mu.Lock()
+	defer forgeLimit.mu.Unlock()
+	forgeLimit.tokens = l.tokens
+	forgeLimit.next = 0
+}
+
+// SetForgeBudget limits the number of forge API requests made in
+// total to n, or removes the limit if n is 0. Once the budget is
+// used up, or the rate limits of all the tokens are reached, forge
+// requests fail with ErrorForgeLimited and the information is found
+// out from the repository itself where possible.
+func SetForgeBudget(n int) {
+	forgeLimit.mu.Lock()
+	defer forgeLimit.mu.Unlock()
+	forgeLimit.budget = n
+}
+
+// acquire returns the token to use for the next request, or
+// ErrorForgeLimited if no more requests can be made.
+func (l *forgeLimiter) acquire() (*forgeToken, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.budget > 0 && l.used >= l.budget {
+		return nil, ErrorForgeLimited
+	}
+	now := forgeNow()
+	for i := range l.tokens {
+		t := l.tokens[(l.next+i)%len(l.tokens)]
+		if t.remaining == 0 && now.Before(t.reset) {
+			continue
+		}
+		if t.remaining == 0 {
+			// The limit has been reset.
+			t.remaining = -1
+		} else if t.remaining > 0 {
+			t.remaining--
+		}
+		l.next = (l.next + i + 1) % len(l.tokens)
+		l.used++
+		return t, nil
+	}
+	return nil, ErrorForgeLimited
+}
+
+// spend counts a request made without any of the tokens, returning
+// ErrorForgeLimited if the budget is used up.
+func (l *forgeLimiter) spend() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.budget > 0 && l.used >= l.budget {
+		return ErrorForgeLimited
+	}
+	l.used++
+	return nil
+}
+
+// update records the rate limit reported in resp, the response to a
+// request made with t. It returns true if the request was refused
+// because of the rate limit, in which case it may be retried.
+func (l *forgeLimiter) update(t *forgeToken, resp *http.Response) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := forgeNow()
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		t.remaining = remaining
+	}
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		t.reset = time.Unix(reset, 0)
+	}
+
+	if resp.StatusCode != http.StatusForbidden &&
+		resp.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	retryAfter := resp.Header.Get("Retry-After")
+	if t.remaining != 0 && retryAfter == "" {
+		// Refused for some other reason.
+		return false
+	}
+	t.remaining = 0
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		if after := now.Add(time.Duration(seconds) * time.Second); after.After(t.reset) {
+			t.reset = after
+		}
+	}
+	if !t.reset.After(now) {
+		t.reset = now.Add(forgeLimitedWait)
+	}
+	log.Debugf("forge rate limit reached until %s", t.reset.Format(time.RFC3339))
+	return true
+}
@@ -0,0 +1,169 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// mockForgeLimit makes forge requests use l, with the time now. The
+// returned function should be deferred to reset them.
+func mockForgeLimit(l *forgeLimiter, now *time.Time) func() {
+	saved := forgeLimit
+	forgeLimit = l
+	forgeNow = func() time.Time { return *now }
+	return func() {
+		forgeLimit = saved
+		forgeNow = time.Now
+	}
+}
+
+func TestForgeLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newForgeLimiter([]string{"a", "b"}, 0)
+	defer mockForgeLimit(l, &now)()
+
+	var tokens []string
+	for i := 0; i < 3; i++ {
+		token, err := l.acquire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token.token)
+	}
+	if fmt.Sprint(tokens) != "[a b a]" {
+		t.Errorf("tokens not rotated: %v", tokens)
+	}
+
+	// Both tokens are refused until their limits reset.
+	for _, token := range l.tokens {
+		resp := &http.Response{
+			StatusCode: http.StatusForbidden,
+			Header: http.Header{
+				"X-Ratelimit-Remaining": {"0"},
+				"X-Ratelimit-Reset":     {"1060"},
+			},
+		}
+		if !l.update(token, resp) {
+			t.Errorf("%s: not limited", token.token)
+		}
+	}
+	if _, err := l.acquire(); err != ErrorForgeLimited {
+		t.Errorf("got %v, want %v", err, ErrorForgeLimited)
+	}
+	now = time.Unix(1060, 0)
+	if _, err := l.acquire(); err != nil {
+		t.Errorf("after reset: %s", err)
+	}
+
+	// Other refusals are not retried.
+	resp := &http.Response{
+		StatusCode: http.StatusForbidden,
+		Header:     http.Header{"X-Ratelimit-Remaining": {"10"}},
+	}
+	if l.update(l.tokens[0], resp) {
+		t.Error("limited with requests remaining")
+	}
+}
+
+func TestForgeBudget(t *testing.T) {
+	now := time.Unix(1000, 0)
+	defer mockForgeLimit(newForgeLimiter(nil, 0), &now)()
+	SetForgeBudget(2)
+	SetForgeTokens("a")
+	for i := 0; i < 2; i++ {
+		if token, err := forgeLimit.acquire(); err != nil || token.token != "a" {
+			t.Fatalf("got %v,%v", token, err)
+		}
+	}
+	if _, err := forgeLimit.acquire(); err != ErrorForgeLimited {
+		t.Errorf("got %v, want %v", err, ErrorForgeLimited)
+	}
+}
+
+func TestGetJSONRateLimit(t *testing.T) {
+	now := time.Unix(1000, 0)
+	defer mockForgeLimit(newForgeLimiter([]string{"a", "b"}, 0), &now)()
+
+	requests := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		requests[auth]++
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(2000))
+		if auth == "Bearer a" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		// This was the last request allowed for b.
+		fmt.Fprint(w, `{"size": 1}`)
+	}))
+	defer srv.Close()
+
+	var info struct {
+		Size int `json:"size"`
+	}
+	if err := getJSON(srv.URL, &info); err != nil || info.Size != 1 {
+		t.Fatalf("got %v,%v", info, err)
+	}
+	if requests["Bearer a"] != 1 || requests["Bearer b"] != 1 {
+		t.Errorf("unexpected requests %v", requests)
+	}
+	if err := getJSON(srv.URL, &info); err != ErrorForgeLimited {
+		t.Errorf("got %v, want %v", err, ErrorForgeLimited)
+	}
+	if len(requests) != 2 || requests["Bearer b"] != 1 {
+		t.Errorf("request made beyond the rate limit: %v", requests)
+	}
+}
+
+// healthWorkingTree is a mock WorkingTree with two revisions.
+type healthWorkingTree struct {
+	stubWorkingTree
+}
+
+func (wt *healthWorkingTree) Revisions() ([]string, error) {
+	return []string{"r1", "r0"}, nil
+}
+
+func (wt *healthWorkingTree) TimeFromRevision(rev string) (time.Time, error) {
+	return time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, len(rev)), nil
+}
+
+func TestRepoHealthFromWorkingTree(t *testing.T) {
+	defer mockGithubAPI(t, nil)()
+	now := time.Unix(1000, 0)
+	l := newForgeLimiter(nil, 1)
+	l.used = 1
+	defer mockForgeLimit(l, &now)()
+
+	ref := &Reference{Pkg: "github.com/foo/bar", Repo: "https://github.com/foo/bar"}
+	if _, err := CheckRepoHealth(ref); err != ErrorForgeLimited {
+		t.Fatalf("got %v, want %v", err, ErrorForgeLimited)
+	}
+	health, err := RepoHealthFromWorkingTree(ref, &healthWorkingTree{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if health.Pkg != ref.Pkg || health.LastPush.Day() != 3 {
+		t.Errorf("unexpected %+v", health)
+	}
+}
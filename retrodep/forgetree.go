@@ -0,0 +1,383 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+// This file contains a WorkingTree which uses forge (e.g. GitHub)
+// APIs to list tags and download archives instead of cloning.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/vcs"
+)
+
+// ForgeAPI makes working trees for git repositories on github.com
+// and gitlab.com use the forge's API to list tags and download
+// archives of refs, rather than cloning the repository. The
+// repository is still cloned if the API cannot be used.
+func ForgeAPI() WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		o.forgeAPI = true
+	}
+}
+
+// errNoForgeAPI is returned by newForgeWorkingTree for repositories
+// whose forge has no supported API.
+var errNoForgeAPI = errors.New("no supported forge API")
+
+// forgeTagsPerPage is the number of tags requested at a time.
+const forgeTagsPerPage = 100
+
+// forgeRepo is a repository on a forge with an API.
+type forgeRepo interface {
+	// tags returns the commit for each tag.
+	tags() (map[string]string, error)
+
+	// commitTime returns the committer date of ref.
+	commitTime(ref string) (time.Time, error)
+
+	// archive requests a gzipped tar archive of ref.
+	archive(ref string) (*http.Response, error)
+}
+
+// githubForgeRepo is a forgeRepo on github.com, with path
+// "owner/name".
+type githubForgeRepo struct {
+	path string
+}
+
+func (r githubForgeRepo) tags() (map[string]string, error) {
+	revs := make(map[string]string)
+	for page := 1; ; page++ {
+		var tags []struct {
+			Name   string `json:"name"`
+			Commit struct {
+				SHA string `json:"sha"`
+			} `json:"commit"`
+		}
+		u := fmt.Sprintf("%s/repos/%s/tags?per_page=%d&page=%d",
+			githubAPI, r.path, forgeTagsPerPage, page)
+		if err := getJSON(u, &tags); err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			revs[tag.Name] = tag.Commit.SHA
+		}
+		if len(tags) < forgeTagsPerPage {
+			return revs, nil
+		}
+	}
+}
+
+func (r githubForgeRepo) commitTime(ref string) (time.Time, error) {
+	var commit struct {
+		Commit struct {
+			Committer struct {
+				Date time.Time `json:"date"`
+			} `json:"committer"`
+		} `json:"commit"`
+	}
+	u := githubAPI + "/repos/" + r.path + "/commits/" + url.PathEscape(ref)
+	if err := getJSON(u, &commit); err != nil {
+		return time.Time{}, err
+	}
+	return commit.Commit.Committer.Date, nil
+}
+
+func (r githubForgeRepo) archive(ref string) (*http.Response, error) {
+	return githubDo(forgeArchiveClient,
+		githubAPI+"/repos/"+r.path+"/tarball/"+url.PathEscape(ref))
+}
+
+// gitlabForgeRepo is a forgeRepo on gitlab.com, with path
+// "group/name", which may include subgroups.
+type gitlabForgeRepo struct {
+	path string
+}
+
+// project returns the API URL for the project.
+func (r gitlabForgeRepo) project() string {
+	return gitlabAPI + "/projects/" + url.PathEscape(r.path)
+}
+
+func (r gitlabForgeRepo) tags() (map[string]string, error) {
+	revs := make(map[string]string)
+	for page := 1; ; page++ {
+		var tags []struct {
+			Name   string `json:"name"`
+			Commit struct {
+				ID string `json:"id"`
+			} `json:"commit"`
+		}
+		u := fmt.Sprintf("%s/repository/tags?per_page=%d&page=%d",
+			r.project(), forgeTagsPerPage, page)
+		if err := decodeJSON(gitlabGet, u, &tags); err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			revs[tag.Name] = tag.Commit.ID
+		}
+		if len(tags) < forgeTagsPerPage {
+			return revs, nil
+		}
+	}
+}
+
+func (r gitlabForgeRepo) commitTime(ref string) (time.Time, error) {
+	var commit struct {
+		CommittedDate time.Time `json:"committed_date"`
+	}
+	u := r.project() + "/repository/commits/" + url.PathEscape(ref)
+	if err := decodeJSON(gitlabGet, u, &commit); err != nil {
+		return time.Time{}, err
+	}
+	return commit.CommittedDate, nil
+}
+
+func (r gitlabForgeRepo) archive(ref string) (*http.Response, error) {
+	return gitlabDo(forgeArchiveClient,
+		r.project()+"/repository/archive.tar.gz?sha="+url.QueryEscape(ref))
+}
+
+// forgeRepoFor returns the forgeRepo for the repository URL, or
+// false if its forge has no supported API.
+func forgeRepoFor(repo string) (forgeRepo, bool) {
+	if p, ok := githubRepo(repo); ok {
+		return githubForgeRepo{path: p}, true
+	}
+	if p, ok := gitlabRepo(repo); ok {
+		return gitlabForgeRepo{path: p}, true
+	}
+	return nil, false
+}
+
+// forgeTimes holds the commit times found, shared between the copies
+// made by WithContext.
+type forgeTimes struct {
+	mu    sync.Mutex
+	times map[string]time.Time
+}
+
+// forgeWorkingTree is a WorkingTree for a git repository read using
+// its forge's API. Its revisions are those of its tags, and the
+// files for each ref are read from an archive of it.
+type forgeWorkingTree struct {
+	anyWorkingTree
+
+	forge forgeRepo
+
+	// revs maps each tag to its commit.
+	revs  map[string]string
+	times *forgeTimes
+}
+
+// newForgeWorkingTree returns a *forgeWorkingTree, in dir, for
+// project. It returns errNoForgeAPI if its forge has no supported
+// API.
+func newForgeWorkingTree(ctx context.Context, project *vcs.RepoRoot, dir string) (*forgeWorkingTree, error) {
+	if project.VCS.Cmd != vcsGit {
+		return nil, errNoForgeAPI
+	}
+	forge, ok := forgeRepoFor(project.Repo)
+	if !ok {
+		return nil, errNoForgeAPI
+	}
+	start := time.Now()
+	revs, err := forge.tags()
+	traceDuration(start, "list tags "+project.Repo, err)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("%s: %d tags from forge API", project.Repo, len(revs))
+	return &forgeWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			Dir:       dir,
+			VCS:       project.VCS,
+			hasher:    &sha256Hasher{},
+			algorithm: HashSHA256,
+			cache:     newFileHashesCache(fileHashesCacheSize),
+			repo:      project.Repo,
+			ctx:       ctx,
+		},
+		forge: forge,
+		revs:  revs,
+		times: &forgeTimes{times: make(map[string]time.Time)},
+	}, nil
+}
+
+// WithContext returns a copy of f with ctx as its context.
+func (f *forgeWorkingTree) WithContext(ctx context.Context) WorkingTree {
+	c := *f
+	c.ctx = ctx
+	return &c
+}
+
+// VersionTags returns the tags which are semantic versions.
+func (f *forgeWorkingTree) VersionTags() ([]string, error) {
+	tags := make([]string, 0, len(f.revs))
+	for tag := range f.revs {
+		tags = append(tags, tag)
+	}
+	return versionTags(tags), nil
+}
+
+// Revisions returns the commits of the version tags, newest version
+// first. Untagged commits are not listed.
+func (f *forgeWorkingTree) Revisions() ([]string, error) {
+	tags, err := f.VersionTags()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var revs []string
+	for _, tag := range tags {
+		if rev := f.revs[tag]; !seen[rev] {
+			seen[rev] = true
+			revs = append(revs, rev)
+		}
+	}
+	return revs, nil
+}
+
+// RevisionFromTag returns the commit for tag.
+func (f *forgeWorkingTree) RevisionFromTag(tag string) (string, error) {
+	rev, ok := f.revs[tag]
+	if !ok {
+		return "", ErrorInvalidRef
+	}
+	return rev, nil
+}
+
+// TimeFromRevision returns the committer date of rev, in UTC.
+func (f *forgeWorkingTree) TimeFromRevision(rev string) (time.Time, error) {
+	f.times.mu.Lock()
+	t, ok := f.times.times[rev]
+	f.times.mu.Unlock()
+	if ok {
+		return t, nil
+	}
+	t, err := f.forge.commitTime(rev)
+	if err == ErrorVersionNotFound {
+		return t, ErrorInvalidRef
+	}
+	if err != nil {
+		return t, err
+	}
+	t = t.UTC()
+	f.times.mu.Lock()
+	f.times.times[rev] = t
+	f.times.mu.Unlock()
+	return t, nil
+}
+
+// ReachableTag returns the highest version tag for rev. As the
+// history of the repository is not known, it returns
+// ErrorVersionNotFound if rev is not tagged, or not with a tag the
+// TagMatch accepts.
+func (f *forgeWorkingTree) ReachableTag(rev string) (string, error) {
+	tags, err := f.VersionTags()
+	if err != nil {
+		return "", err
+	}
+	for _, tag := range tags {
+		if strings.HasPrefix(f.revs[tag], rev) && f.tagMatch.matches(tag) {
+			return tag, nil
+		}
+	}
+	return "", ErrorVersionNotFound
+}
+
+// extractor returns an extractFunc for the archive of ref.
+func (f *forgeWorkingTree) extractor(ref string) extractFunc {
+	return func(dir string) error {
+		start := time.Now()
+		resp, err := f.forge.archive(ref)
+		if err == ErrorVersionNotFound {
+			err = ErrorInvalidRef
+		}
+		if err == nil {
+			err = extractTarball(resp.Body, dir)
+			resp.Body.Close()
+		}
+		traceDuration(start, "archive "+f.repo+" "+ref, err)
+		return err
+	}
+}
+
+// FileHashesFromRef returns the file hashes within subPath of the
+// archive of ref.
+func (f *forgeWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	return f.cachedFileHashes(ref, subPath, func(ref, subPath string) (FileHashes, error) {
+		hashes, err := extractedFileHashes(f.env, f.hasher, f.extractor(ref), subPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "FileHashesFromRef(%s)", ref)
+		}
+		return hashes, nil
+	})
+}
+
+// TagSync replaces the files in the working tree with those of the
+// archive of tag, or of the highest version if tag is "".
+func (f *forgeWorkingTree) TagSync(tag string) error {
+	if tag == "" {
+		tags, err := f.VersionTags()
+		if err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			return ErrorVersionNotFound
+		}
+		tag = tags[0]
+	}
+	return extractedSync(f.Dir, f.extractor(tag))
+}
+
+// RevSync replaces the files in the working tree with those of the
+// archive of rev.
+func (f *forgeWorkingTree) RevSync(rev string) error {
+	return f.TagSync(rev)
+}
+
+// Grep searches the files within subPath in the archive of ref.
+func (f *forgeWorkingTree) Grep(ref, pattern, subPath string) ([]GrepMatch, error) {
+	return extractedGrep(f.env, f.extractor(ref), pattern, subPath)
+}
+
+// LastRevisions gives ref as the last revision for each file within
+// subPath, as the history of the repository is not known.
+func (f *forgeWorkingTree) LastRevisions(ref, subPath string) (map[string]string, error) {
+	hashes, err := f.FileHashesFromRef(ref, subPath)
+	if err != nil {
+		return nil, err
+	}
+	revs := make(map[string]string, len(hashes))
+	for file := range hashes {
+		revs[file] = ref
+	}
+	return revs, nil
+}
+
+// Submodules returns nil, as archives do not include submodules.
+func (f *forgeWorkingTree) Submodules(ref string) ([]Submodule, error) {
+	return nil, nil
+}
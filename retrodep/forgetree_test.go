@@ -0,0 +1,236 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// tarball returns a gzipped tar archive of files, within the
+// top-level directory top.
+func tarball(t *testing.T, top string, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		hdr := &tar.Header{
+			Name:     top + "/" + name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGitlabRepo(t *testing.T) {
+	tcs := []struct {
+		repo string
+		exp  string
+		ok   bool
+	}{
+		{"https://gitlab.com/foo/bar", "foo/bar", true},
+		{"https://gitlab.com/group/sub/bar.git", "group/sub/bar", true},
+		{"https://gitlab.com/foo", "", false},
+		{"https://github.com/foo/bar", "", false},
+	}
+	for _, tc := range tcs {
+		p, ok := gitlabRepo(tc.repo)
+		if p != tc.exp || ok != tc.ok {
+			t.Errorf("%s: got %q,%t want %q,%t", tc.repo, p, ok, tc.exp, tc.ok)
+		}
+	}
+}
+
+func TestForgeWorkingTree(t *testing.T) {
+	archives := map[string][]byte{
+		"/repos/foo/bar/tarball/v1.0.0": tarball(t, "foo-bar-0123456",
+			map[string]string{"bar.go": "package bar\n", "sub/sub.go": "package sub\n"}),
+		"/projects/group/sub/bar/repository/archive.tar.gz": tarball(t, "bar-v2.0.0-89abcde",
+			map[string]string{"bar.go": "package bar // v2\n"}),
+	}
+	responses := map[string]string{
+		"/repos/foo/bar/tags":                     `[{"name":"v1.0.0","commit":{"sha":"0123456789"}},{"name":"v1.1.0","commit":{"sha":"89abcdef01"}},{"name":"latest","commit":{"sha":"89abcdef01"}}]`,
+		"/repos/foo/bar/commits/0123456789":       `{"commit":{"committer":{"date":"2019-01-01T00:00:00Z"}}}`,
+		"/projects/group/sub/bar/repository/tags": `[{"name":"v2.0.0","commit":{"id":"89abcdef01"}}]`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body, ok := archives[r.URL.Path]; ok {
+			w.Write(body)
+			return
+		}
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+	defer func(github, gitlab string) {
+		githubAPI, gitlabAPI = github, gitlab
+	}(githubAPI, gitlabAPI)
+	githubAPI, gitlabAPI = srv.URL, srv.URL
+
+	project := &vcs.RepoRoot{VCS: vcs.ByCmd(vcsGit), Repo: "https://github.com/foo/bar", Root: "github.com/foo/bar"}
+	wt, err := NewWorkingTreeContext(context.Background(), project, ForgeAPI())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wt.Close()
+	f, ok := wt.(*forgeWorkingTree)
+	if !ok {
+		t.Fatalf("got %T", wt)
+	}
+
+	tags, err := f.VersionTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"v1.1.0", "v1.0.0"}) {
+		t.Errorf("tags: got %v", tags)
+	}
+	if rev, err := f.RevisionFromTag("v1.0.0"); err != nil || rev != "0123456789" {
+		t.Errorf("v1.0.0: got %q, %v", rev, err)
+	}
+	if _, err := f.RevisionFromTag("v3.0.0"); err != ErrorInvalidRef {
+		t.Errorf("v3.0.0: got %v", err)
+	}
+	if tag, err := f.ReachableTag("89abcdef"); err != nil || tag != "v1.1.0" {
+		t.Errorf("ReachableTag: got %q, %v", tag, err)
+	}
+	if when, err := f.TimeFromRevision("0123456789"); err != nil || when.Year() != 2019 {
+		t.Errorf("TimeFromRevision: got %v, %v", when, err)
+	}
+	if _, err := f.TimeFromRevision("fedcba"); err != ErrorInvalidRef {
+		t.Errorf("TimeFromRevision(fedcba): got %v", err)
+	}
+	hashes, err := f.FileHashesFromRef("v1.0.0", "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 1 || hashes["sub.go"] == "" {
+		t.Errorf("FileHashesFromRef: got %v", hashes)
+	}
+	if _, err := f.FileHashesFromRef("v1.1.0", ""); err == nil {
+		t.Error("FileHashesFromRef(v1.1.0): expected error")
+	}
+
+	project = &vcs.RepoRoot{VCS: vcs.ByCmd(vcsGit), Repo: "https://gitlab.com/group/sub/bar.git", Root: "gitlab.com/group/sub/bar"}
+	gl, err := newForgeWorkingTree(context.Background(), project, f.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev, err := gl.RevisionFromTag("v2.0.0"); err != nil || rev != "89abcdef01" {
+		t.Errorf("gitlab v2.0.0: got %q, %v", rev, err)
+	}
+	hashes, err = gl.FileHashesFromRef("v2.0.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 1 || hashes["bar.go"] == "" {
+		t.Errorf("gitlab FileHashesFromRef: got %v", hashes)
+	}
+
+	project = &vcs.RepoRoot{VCS: vcs.ByCmd(vcsGit), Repo: "https://example.com/foo/bar", Root: "example.com/foo/bar"}
+	if _, err := newForgeWorkingTree(context.Background(), project, f.Dir); err != errNoForgeAPI {
+		t.Errorf("example.com: got %v", err)
+	}
+}
+
+func TestExtractTarballSymlinks(t *testing.T) {
+	archive := func(hdrs ...*tar.Header) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for _, hdr := range hdrs {
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tw.Close()
+		gz.Close()
+		return buf.Bytes()
+	}
+
+	dir, err := ioutil.TempDir("", "retrodep-extract.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = extractTarball(bytes.NewReader(archive(
+		&tar.Header{Name: "top/a.go", Mode: 0644, Typeflag: tar.TypeReg},
+		&tar.Header{Name: "top/inside", Linkname: "a.go", Typeflag: tar.TypeSymlink},
+		&tar.Header{Name: "top/outside", Linkname: "/etc", Typeflag: tar.TypeSymlink},
+	)), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{"inside": "a.go", "outside": "/etc"} {
+		if target, err := os.Readlink(filepath.Join(dir, name)); err != nil || target != expected {
+			t.Errorf("%s: got %q, %v", name, target, err)
+		}
+	}
+
+	// Nothing may be written through a link.
+	for _, name := range []string{"top/outside", "top/outside/passwd"} {
+		evil, err := ioutil.TempDir("", "retrodep-extract.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(evil)
+		outside, err := ioutil.TempDir("", "retrodep-outside.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(outside)
+		err = extractTarball(bytes.NewReader(archive(
+			&tar.Header{Name: "top/outside", Linkname: outside, Typeflag: tar.TypeSymlink},
+			&tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg},
+		)), evil)
+		if err == nil {
+			t.Errorf("%s: written through link", name)
+		}
+		if entries, _ := ioutil.ReadDir(outside); len(entries) != 0 {
+			t.Errorf("%s: wrote %v", name, entries)
+		}
+	}
+}
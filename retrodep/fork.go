@@ -0,0 +1,203 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"net/url"
+	"strings"
+)
+
+// ForkPoint describes how a revision from a fork relates to the
+// history of the canonical upstream repository.
+type ForkPoint struct {
+	// Upstream is the URL of the canonical upstream repository.
+	Upstream string `json:"upstream"`
+
+	// Rev is the most recent upstream revision the fork's
+	// revision is based on, or "" if they share no history.
+	Rev string `json:"rev,omitempty"`
+
+	// Commits is the number of commits included which are only
+	// in the fork.
+	Commits int `json:"commits"`
+}
+
+// normalizeRepoURL returns the host and path of the repository URL
+// repo, in lower case and without any ".git" suffix, so that
+// different spellings of the same repository compare equal.
+func normalizeRepoURL(repo string) string {
+	u, err := url.Parse(repo)
+	if err != nil || u.Host == "" {
+		// Perhaps scp-like syntax, user@host:path
+		hostPath := repo
+		if at := strings.Index(hostPath, "@"); at != -1 {
+			hostPath = hostPath[at+1:]
+		}
+		return strings.ToLower(strings.TrimSuffix(strings.TrimSuffix(
+			strings.Replace(hostPath, ":", "/", 1), "/"), ".git"))
+	}
+	pth := strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git")
+	return strings.ToLower(u.Hostname() + pth)
+}
+
+// SameRepo returns true if the repository URLs a and b name the same
+// repository, differing only in scheme, user, case or ".git" suffix.
+func SameRepo(a, b string) bool {
+	return normalizeRepoURL(a) == normalizeRepoURL(b)
+}
+
+// CanonicalRepo returns the URL of the repository the import path
+// of project resolves to, if this is a different repository from
+// the one project uses (for example because a dependency manager's
+// manifest names a fork as its source). Otherwise it returns "".
+func (src GoSource) CanonicalRepo(project *RepoPath) string {
+	root, err := src.repoRootForImportPath(project.Root)
+	if err != nil {
+		log.Debugf("%s: %s", project.Root, err)
+		return ""
+	}
+	if root.VCS == nil || project.VCS == nil || root.VCS.Cmd != project.VCS.Cmd {
+		return ""
+	}
+	if SameRepo(root.Repo, project.Repo) {
+		return ""
+	}
+	return root.Repo
+}
+
+// ForkPoint finds where the history of ref, found in the fork wt of
+// project, leaves that of the canonical upstream repository for
+// project. It returns nil if project is not a fork or ref has no
+// revision.
+func (src GoSource) ForkPoint(project *RepoPath, ref *Reference, wt ForkWorkingTree) (*ForkPoint, error) {
+	if ref.Rev == "" {
+		return nil, nil
+	}
+	upstream := src.CanonicalRepo(project)
+	if upstream == "" {
+		return nil, nil
+	}
+	return wt.ForkPoint(ref.Rev, upstream)
+}
+
+// AddForks marks the repositories used for the projects below the
+// import path prefixes as forks of their canonical repositories,
+// rather than mirrors, for ForkReplacement.
+func (src *GoSource) AddForks(prefixes []string) {
+	src.forks = append(src.forks, prefixes...)
+}
+
+// ForkReplacement returns the module path of the repository project
+// uses, for a go.mod replace directive, if it is a fork: either it
+// is not the repository the import path of project resolves to, or
+// it was marked as a fork with AddForks. Otherwise it returns "".
+func (src GoSource) ForkReplacement(project *RepoPath) string {
+	if project.Repo == "" {
+		return ""
+	}
+	for _, prefix := range src.forks {
+		if project.Root == prefix || strings.HasPrefix(project.Root, prefix+"/") {
+			return normalizeRepoURL(project.Repo)
+		}
+	}
+	if src.CanonicalRepo(project) == "" {
+		return ""
+	}
+	return normalizeRepoURL(project.Repo)
+}
+
+// replacement returns the module path to replace ref with in go.mod,
+// or "" if it was not found in a fork.
+func replacement(ref *Reference) string {
+	switch {
+	case ref.Replace != "":
+		return ref.Replace
+	case ref.Fork != nil && ref.Repo != "":
+		return normalizeRepoURL(ref.Repo)
+	}
+	return ""
+}
+
+// gitUpstreamRefs is the prefix of refs temporarily fetched from
+// the canonical upstream repository.
+const gitUpstreamRefs = "refs/retrodep/upstream/"
+
+// ForkPoint fetches the branches and tags of the repository at the
+// URL upstream and counts the commits reachable from rev but not
+// from them. The fork point is the merge base of rev and the
+// upstream commits those commits are based on, so commit dates do
+// not matter. The fetched refs are removed afterwards so they do
+// not affect the revisions of this repository.
+func (g *gitWorkingTree) ForkPoint(rev, upstream string) (*ForkPoint, error) {
+	stdout, stderr, err := g.run("fetch", "--quiet", "--no-tags", upstream,
+		"+refs/heads/*:"+gitUpstreamRefs+"heads/*",
+		"+refs/tags/*:"+gitUpstreamRefs+"tags/*")
+	defer g.removeUpstreamRefs()
+	if err != nil {
+		g.showOutput(stdout, stderr)
+		return nil, err
+	}
+
+	stdout, stderr, err = g.run("rev-list", "--boundary",
+		rev, "--not", "--glob="+gitUpstreamRefs+"*")
+	if err != nil {
+		g.showOutput(stdout, stderr)
+		return nil, err
+	}
+	fork := &ForkPoint{Upstream: upstream}
+	var boundary []string
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "-"):
+			boundary = append(boundary, line[1:])
+		default:
+			fork.Commits++
+		}
+	}
+	if fork.Commits == 0 {
+		// rev is itself upstream
+		fork.Rev = rev
+		return fork, nil
+	}
+	if len(boundary) == 0 {
+		// no shared history
+		return fork, nil
+	}
+
+	stdout, stderr, err = g.run(append([]string{"merge-base", rev}, boundary...)...)
+	if err != nil {
+		g.showOutput(stdout, stderr)
+		return nil, err
+	}
+	fork.Rev = strings.TrimSpace(stdout.String())
+	return fork, nil
+}
+
+// removeUpstreamRefs deletes the refs fetched by ForkPoint.
+func (g *gitWorkingTree) removeUpstreamRefs() {
+	stdout, _, err := g.run("for-each-ref",
+		"--format=delete %(refname)", gitUpstreamRefs)
+	if err != nil || stdout.Len() == 0 {
+		return
+	}
+	if _, stderr, err := g.runInput(stdout, "update-ref", "--stdin"); err != nil {
+		log.Debugf("removing %s: %s: %s", gitUpstreamRefs, err, stderr)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestNormalizeRepoURL(t *testing.T) {
+	for _, repo := range []string{
+		"https://github.com/Foo/Bar",
+		"https://github.com/foo/bar.git",
+		"https://github.com/foo/bar/",
+		"git@github.com:foo/bar.git",
+		"ssh://git@github.com/foo/bar",
+	} {
+		if n := normalizeRepoURL(repo); n != "github.com/foo/bar" {
+			t.Errorf("%s: got %q", repo, n)
+		}
+	}
+}
+
+func TestCanonicalRepo(t *testing.T) {
+	defer mockRepoRoots("github.com/foo/bar")()
+
+	src := &GoSource{}
+	git := vcs.ByCmd(vcsGit)
+	tcs := []struct {
+		project  RepoPath
+		upstream string
+	}{
+		{RepoPath{RepoRoot: vcs.RepoRoot{VCS: git, Root: "github.com/foo/bar", Repo: "https://github.com/fork/bar"}}, "https://github.com/foo/bar"},
+		{RepoPath{RepoRoot: vcs.RepoRoot{VCS: git, Root: "github.com/foo/bar", Repo: "https://github.com/foo/bar.git"}}, ""},
+		{RepoPath{RepoRoot: vcs.RepoRoot{VCS: vcs.ByCmd("hg"), Root: "github.com/foo/bar", Repo: "https://github.com/fork/bar"}}, ""},
+		{RepoPath{RepoRoot: vcs.RepoRoot{VCS: git, Root: "example.com/gone", Repo: "https://github.com/fork/gone"}}, ""},
+	}
+	for _, tc := range tcs {
+		if upstream := src.CanonicalRepo(&tc.project); upstream != tc.upstream {
+			t.Errorf("%s: got %q, want %q", tc.project.Repo, upstream, tc.upstream)
+		}
+	}
+}
+
+func TestForkReplacement(t *testing.T) {
+	defer mockRepoRoots("github.com/foo/bar", "github.com/foo/baz")()
+
+	src := &GoSource{}
+	src.AddForks([]string{"github.com/foo/baz"})
+	git := vcs.ByCmd(vcsGit)
+	tcs := []struct {
+		project RepoPath
+		replace string
+	}{
+		{RepoPath{RepoRoot: vcs.RepoRoot{VCS: git, Root: "github.com/foo/bar", Repo: "https://github.com/fork/bar.git"}}, "github.com/fork/bar"},
+		{RepoPath{RepoRoot: vcs.RepoRoot{VCS: git, Root: "github.com/foo/bar", Repo: "https://github.com/foo/bar"}}, ""},
+		{RepoPath{RepoRoot: vcs.RepoRoot{VCS: git, Root: "github.com/foo/baz", Repo: "https://github.com/foo/baz"}}, "github.com/foo/baz"},
+		{RepoPath{RepoRoot: vcs.RepoRoot{VCS: git, Root: "github.com/foo/bazaar", Repo: "https://github.com/foo/bazaar"}}, ""},
+		{RepoPath{RepoRoot: vcs.RepoRoot{VCS: git, Root: "github.com/foo/baz"}}, ""},
+	}
+	for _, tc := range tcs {
+		if replace := src.ForkReplacement(&tc.project); replace != tc.replace {
+			t.Errorf("%s %s: got %q, want %q", tc.project.Root, tc.project.Repo, replace, tc.replace)
+		}
+	}
+}
+
+func TestGitForkPoint(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("needs git")
+	}
+	dir := t.TempDir()
+	git := func(dir, date string, args ...string) string {
+		t.Helper()
+		p := exec.Command("git", args...)
+		p.Dir = dir
+		p.Env = append(commandEnv(), "GIT_AUTHOR_NAME=a", "GIT_AUTHOR_EMAIL=a@example.com",
+			"GIT_COMMITTER_NAME=a", "GIT_COMMITTER_EMAIL=a@example.com", "GIT_CONFIG_NOSYSTEM=1",
+			"GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+		output, err := p.Output()
+		if err != nil {
+			t.Fatalf("git %v: %s", args, err)
+		}
+		return strings.TrimSpace(string(output))
+	}
+
+	// The fork merges a branch based on upstream commit u3 into
+	// one based on its ancestor u1, and commit dates are out of
+	// order, so u1 looks more recent than u3.
+	upstream := filepath.Join(dir, "upstream")
+	git(dir, "", "init", "--quiet", upstream)
+	var u []string
+	for i, date := range []string{"2020-01-11", "2020-01-08", "2020-01-01", "2020-01-07"} {
+		writeFiles(t, upstream, map[string]string{"a": fmt.Sprintf("%d\n", i)})
+		git(upstream, "", "add", "a")
+		git(upstream, date+"T00:00:00Z", "commit", "--quiet", "-m", "upstream")
+		u = append(u, git(upstream, "", "rev-parse", "HEAD"))
+	}
+	fork := filepath.Join(dir, "fork")
+	git(dir, "", "clone", "--quiet", upstream, fork)
+	git(fork, "", "checkout", "--quiet", "-b", "x", u[2])
+	writeFiles(t, fork, map[string]string{"x": "x\n"})
+	git(fork, "", "add", "x")
+	git(fork, "2020-01-23T00:00:00Z", "commit", "--quiet", "-m", "fx")
+	git(fork, "", "checkout", "--quiet", "-b", "y", u[0])
+	writeFiles(t, fork, map[string]string{"y": "y\n"})
+	git(fork, "", "add", "y")
+	git(fork, "2020-01-05T00:00:00Z", "commit", "--quiet", "-m", "fy")
+	git(fork, "2020-01-28T00:00:00Z", "merge", "--quiet", "--no-edit", "x")
+	merged := git(fork, "", "rev-parse", "HEAD")
+
+	wt := &gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			Dir: fork,
+			VCS: vcs.ByCmd(vcsGit),
+		},
+	}
+	tcs := []struct {
+		rev     string
+		forkRev string
+		commits int
+	}{
+		{merged, u[2], 3},
+		{u[0], u[0], 0},
+	}
+	for _, tc := range tcs {
+		fp, err := wt.ForkPoint(tc.rev, upstream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fp.Rev != tc.forkRev || fp.Commits != tc.commits || fp.Upstream != upstream {
+			t.Errorf("%s: got %+v, want %s with %d commits", tc.rev, fp, tc.forkRev, tc.commits)
+		}
+	}
+	if refs := git(fork, "", "for-each-ref", gitUpstreamRefs); refs != "" {
+		t.Errorf("upstream refs left behind: %s", refs)
+	}
+}
+
+func TestGitForkPointFailure(t *testing.T) {
+	defer mockExecCommand()()
+
+	wt := gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			Dir: "",
+			VCS: vcs.ByCmd(vcsGit),
+		},
+	}
+	mockedExitStatus = 1
+	if _, err := wt.ForkPoint("d4c3dbf", "https://github.com/foo/bar"); err == nil {
+		t.Error("git failure was not reported")
+	}
+}
+
+func TestForkPointNotFork(t *testing.T) {
+	defer mockRepoRoots("github.com/foo/bar")()
+
+	src := &GoSource{}
+	project := &RepoPath{RepoRoot: vcs.RepoRoot{
+		VCS:  vcs.ByCmd(vcsGit),
+		Root: "github.com/foo/bar",
+		Repo: "https://github.com/foo/bar",
+	}}
+	wt := &gitWorkingTree{}
+	fork, err := src.ForkPoint(project, &Reference{Rev: "d4c3dbf"}, wt)
+	if err != nil || fork != nil {
+		t.Errorf("got %v, %v", fork, err)
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"golang.org/x/tools/go/vcs"
+)
+
+// This file contains methods specific to working with fossil.
+
+// Revisions are full check-in hashes. The repository file is kept
+// inside the checkout, which needs fossil 2.12 or later to open it
+// directly from the repository URL.
+
+// vcsFossilCmd describes fossil, which golang.org/x/tools/go/vcs
+// does not know.
+var vcsFossilCmd = &vcs.Cmd{
+	Name:      "Fossil",
+	Cmd:       vcsFossil,
+	CreateCmd: "open --force --workdir {dir} --repodir {dir} {repo}",
+}
+
+type fossilWorkingTree struct {
+	anyWorkingTree
+}
+
+// fossilTimeLayout is the layout of check-in times in 'fossil info'.
+const fossilTimeLayout = "2006-01-02 15:04:05"
+
+// fossilHashRE matches a full SHA1 or SHA3-256 check-in hash.
+var fossilHashRE = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+
+// WithContext implements the ContextWorkingTree interface.
+func (f *fossilWorkingTree) WithContext(ctx context.Context) WorkingTree {
+	c := *f
+	c.ctx = ctx
+	return &c
+}
+
+// fossilCheckinSpec returns the fossil check-in name for the tag or
+// revision ref.
+func fossilCheckinSpec(ref string) string {
+	if fossilHashRE.MatchString(ref) {
+		return ref
+	}
+	return "tag:" + ref
+}
+
+// lines runs fossil with args and returns the lines of its output,
+// without surrounding space.
+func (f *fossilWorkingTree) lines(args ...string) ([]string, error) {
+	stdout, stderr, err := f.run(args...)
+	if err != nil {
+		f.showOutput(stdout, stderr)
+		return nil, err
+	}
+	var lines []string
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// tags returns the names of the tags, using 'fossil tag list'. Branch
+// names, which fossil lists as tags as well, are left out.
+func (f *fossilWorkingTree) tags() ([]string, error) {
+	names, err := f.lines("tag", "list")
+	if err != nil {
+		return nil, err
+	}
+	branchNames, err := f.lines("branch", "list", "--all")
+	if err != nil {
+		return nil, err
+	}
+	branches := make(map[string]bool, len(branchNames))
+	for _, branch := range branchNames {
+		branches[strings.TrimPrefix(branch, "* ")] = true
+	}
+	tags := make([]string, 0, len(names))
+	for _, name := range names {
+		if !branches[name] {
+			tags = append(tags, name)
+		}
+	}
+	return tags, nil
+}
+
+// timeline returns the hashes of the check-ins from 'fossil timeline'
+// with args, newest first.
+func (f *fossilWorkingTree) timeline(args ...string) ([]string, error) {
+	args = append([]string{"timeline"}, args...)
+	lines, err := f.lines(append(args, "-t", "ci", "-n", "0", "-F", "%H")...)
+	if err != nil {
+		return nil, err
+	}
+	return parseFossilTimeline(lines), nil
+}
+
+// parseFossilTimeline returns the hashes from the lines of 'fossil
+// timeline -F %H' output, skipping the date headings.
+func parseFossilTimeline(lines []string) []string {
+	hashes := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if fossilHashRE.MatchString(line) {
+			hashes = append(hashes, line)
+		}
+	}
+	return hashes
+}
+
+// info returns the hash and time of the check-in named by the tag or
+// revision ref, using 'fossil info'.
+func (f *fossilWorkingTree) info(ref string) (string, time.Time, error) {
+	stdout, stderr, err := f.run("info", fossilCheckinSpec(ref))
+	if err != nil {
+		f.showOutput(stdout, stderr)
+		return "", time.Time{}, ErrorInvalidRef
+	}
+	return parseFossilInfo(stdout)
+}
+
+// parseFossilInfo returns the hash and time of the check-in from the
+// output of 'fossil info'.
+func parseFossilInfo(stdout *bytes.Buffer) (string, time.Time, error) {
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// hash: <hash> <date> <time> UTC (uuid: before fossil 2.0)
+		if len(fields) < 4 || (fields[0] != "hash:" && fields[0] != "uuid:") {
+			continue
+		}
+		t, err := time.Parse(fossilTimeLayout, fields[2]+" "+fields[3])
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("fossil info: %s", err)
+		}
+		return fields[1], t, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", time.Time{}, err
+	}
+	return "", time.Time{}, fmt.Errorf("unexpected info output: %q", stdout.String())
+}
+
+// VersionTags returns the tags that are parseable as semantic tags,
+// e.g. v1.1.0.
+func (f *fossilWorkingTree) VersionTags() ([]string, error) {
+	tags, err := f.tags()
+	if err != nil {
+		return nil, err
+	}
+	return versionTags(tags), nil
+}
+
+// Revisions returns all check-ins, newest first.
+func (f *fossilWorkingTree) Revisions() ([]string, error) {
+	return f.timeline()
+}
+
+// RevisionFromTag returns the hash of the check-in for the tag.
+func (f *fossilWorkingTree) RevisionFromTag(tag string) (string, error) {
+	hash, _, err := f.info(tag)
+	return hash, err
+}
+
+// TagSync syncs the checkout to the named tag, or to the tip of its
+// branch if tag is "", using 'fossil update'.
+func (f *fossilWorkingTree) TagSync(tag string) error {
+	args := []string{"update"}
+	if tag != "" {
+		args = append(args, fossilCheckinSpec(tag))
+	}
+	stdout, stderr, err := f.run(args...)
+	if err != nil {
+		f.showOutput(stdout, stderr)
+	}
+	return err
+}
+
+// RevSync syncs the checkout to the check-in rev, using 'fossil
+// update'.
+func (f *fossilWorkingTree) RevSync(rev string) error {
+	return f.TagSync(rev)
+}
+
+// TimeFromRevision returns the time of the check-in rev, in UTC.
+func (f *fossilWorkingTree) TimeFromRevision(rev string) (time.Time, error) {
+	_, t, err := f.info(rev)
+	return t.UTC(), err
+}
+
+// ReachableTag returns the tag on the most recent ancestor of rev,
+// or rev itself, preferring semver tags. Only tags the TagMatch
+// accepts are considered.
+func (f *fossilWorkingTree) ReachableTag(rev string) (string, error) {
+	ancestors, err := f.timeline("ancestors", rev)
+	if err != nil {
+		return "", err
+	}
+	age := make(map[string]int, len(ancestors))
+	for i, hash := range ancestors {
+		age[hash] = i
+	}
+	tags, err := f.tags()
+	if err != nil {
+		return "", err
+	}
+	best, bestSemver := len(ancestors), len(ancestors)
+	var tag, semverTag string
+	for _, t := range tags {
+		if !f.tagMatch.matches(t) {
+			continue
+		}
+		hash, _, err := f.info(t)
+		if err != nil {
+			continue
+		}
+		i, ok := age[hash]
+		if !ok {
+			continue
+		}
+		if i < best {
+			best, tag = i, t
+		}
+		if _, err := semver.NewVersion(t); err == nil && i < bestSemver {
+			bestSemver, semverTag = i, t
+		}
+	}
+	switch {
+	case semverTag != "":
+		return semverTag, nil
+	case tag != "":
+		return tag, nil
+	}
+	return "", ErrorVersionNotFound
+}
+
+// FileHashesFromRef returns the file hashes for the given tag or
+// revision ref, from the local or shared cache if possible.
+func (f *fossilWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	return f.cachedFileHashes(ref, subPath,
+		f.remoteFileHashes(f.fileHashesFromRef, f.RevisionFromTag))
+}
+
+// extractor returns an extractFunc writing the files at the tag or
+// revision ref, using 'fossil tarball'.
+func (f *fossilWorkingTree) extractor(ref string) extractFunc {
+	return func(dir string) error {
+		tarball := filepath.Join(dir, ".retrodep.tar.gz")
+		stdout, stderr, err := f.run("tarball", fossilCheckinSpec(ref), tarball)
+		if err != nil {
+			f.showOutput(stdout, stderr)
+			return ErrorInvalidRef
+		}
+		defer os.Remove(tarball)
+		r, err := os.Open(tarball)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return extractTarball(r, dir)
+	}
+}
+
+// fileHashesFromRef returns the file hashes for the given tag or
+// revision ref, from the files written by 'fossil tarball'.
+func (f *fossilWorkingTree) fileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	return extractedFileHashes(f.env, f.hasher, f.extractor(ref), subPath)
+}
+
+// Grep returns the lines of files at the tag or revision ref which
+// match the regular expression pattern, searching the files written
+// by 'fossil tarball'.
+func (f *fossilWorkingTree) Grep(ref, pattern, subPath string) ([]GrepMatch, error) {
+	return extractedGrep(f.env, f.extractor(ref), pattern, subPath)
+}
+
+// LastRevisions gives ref as the last revision for each file within
+// subPath, as 'fossil timeline' does not list the files changed in a
+// form which can be relied on.
+func (f *fossilWorkingTree) LastRevisions(ref, subPath string) (map[string]string, error) {
+	hashes, err := f.FileHashesFromRef(ref, subPath)
+	if err != nil {
+		return nil, err
+	}
+	revs := make(map[string]string, len(hashes))
+	for file := range hashes {
+		revs[file] = ref
+	}
+	return revs, nil
+}
+
+// Submodules returns nil, as fossil repositories have no submodules.
+func (f *fossilWorkingTree) Submodules(ref string) ([]Submodule, error) {
+	return nil, nil
+}
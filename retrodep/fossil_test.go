@@ -0,0 +1,105 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+const (
+	fossilHash1 = "0123456789abcdef0123456789abcdef01234567"
+	fossilHash2 = "89abcdef0123456789abcdef0123456789abcdef0123456789abcdef01234567"
+)
+
+func newMockFossilWorkingTree() *fossilWorkingTree {
+	return &fossilWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			Dir: "",
+			VCS: vcsByCmd(vcsFossil),
+		},
+	}
+}
+
+func TestFossilRevisions(t *testing.T) {
+	defer mockExecCommand()()
+
+	mockedStdout = "=== 2019-01-02 ===\n" + fossilHash2 + "\n" +
+		"=== 2019-01-01 ===\n" + fossilHash1 + "\n" +
+		"--- entry limit (0) reached ---\n"
+	f := newMockFossilWorkingTree()
+	revs, err := f.Revisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(revs, []string{fossilHash2, fossilHash1}) {
+		t.Errorf("Revisions: got %v", revs)
+	}
+
+	mockedStdout = "hash:         " + fossilHash1 + " 2019-01-01 12:00:00 UTC\n" +
+		"parent:       " + fossilHash2 + " 2018-12-31 12:00:00 UTC\n" +
+		"tags:         trunk, v1.0.0\n"
+	tm, err := f.TimeFromRevision(fossilHash1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tm.Equal(time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("TimeFromRevision: got %s", tm)
+	}
+	if rev, err := f.RevisionFromTag("v1.0.0"); err != nil || rev != fossilHash1 {
+		t.Errorf("RevisionFromTag: got %q, %v", rev, err)
+	}
+
+	mockedStdout = "uuid:         " + fossilHash1 + " yesterday 12:00:00 UTC\n"
+	if _, err := f.TimeFromRevision(fossilHash1); err == nil {
+		t.Error("invalid time accepted")
+	}
+
+	mockedExitStatus = 1
+	if _, err := f.RevisionFromTag("v9.0.0"); err != ErrorInvalidRef {
+		t.Errorf("RevisionFromTag(v9.0.0): got %v", err)
+	}
+}
+
+func TestFossilCheckinSpec(t *testing.T) {
+	for ref, spec := range map[string]string{
+		fossilHash1: fossilHash1,
+		fossilHash2: fossilHash2,
+		"v1.0.0":    "tag:v1.0.0",
+		"0123abcd":  "tag:0123abcd",
+	} {
+		if got := fossilCheckinSpec(ref); got != spec {
+			t.Errorf("%s: got %s, want %s", ref, got, spec)
+		}
+	}
+}
+
+func TestFossilVCS(t *testing.T) {
+	if v := vcsByCmd(vcsFossil); v == nil || v.Cmd != vcsFossil {
+		t.Fatalf("vcsByCmd: got %v", v)
+	}
+	defer RegisterWorkingTreeFactory(vcsFossil, nil)
+	RegisterWorkingTreeFactory(vcsFossil, func(_ context.Context, _ *vcs.RepoRoot, _ string) (WorkingTree, error) {
+		return nil, ErrorUnknownVCS
+	})
+	if v := vcsByCmd(vcsFossil); v == vcsFossilCmd {
+		t.Error("registered factory not preferred")
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/vcs"
+)
+
+// gbVendor is the directory, relative to the top-level project,
+// where gb keeps vendored projects by import path.
+const gbVendor = "vendor/src"
+
+// gbManifestFile is the gb-vendor manifest, relative to the
+// top-level project.
+const gbManifestFile = "vendor/manifest"
+
+// loadGbConf parses vendor/manifest to extract the repositories and
+// revisions of vendored projects.
+func loadGbConf(src *GoSource) error {
+	type gbDependency struct {
+		ImportPath string
+		Repository string
+		VCS        string
+		Revision   string
+		Path       string
+	}
+	type gbManifest struct {
+		Dependencies []gbDependency
+	}
+	conf := filepath.Join(src.Path, filepath.FromSlash(gbManifestFile))
+	if _, skip := src.excludes[conf]; skip {
+		return nil
+	}
+	f, err := os.Open(conf)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	var manifest gbManifest
+	if err := json.NewDecoder(f).Decode(&manifest); err != nil {
+		return errors.Wrapf(err, "decoding %s", conf)
+	}
+
+	for _, dep := range manifest.Dependencies {
+		if dep.ImportPath == "" {
+			continue
+		}
+		src.addClaim(dep.ImportPath, &ManifestClaim{
+			File:     gbManifestFile,
+			Revision: dep.Revision,
+		})
+
+		// Only a project at the root of its repository can be
+		// given a replacement repository.
+		if dep.Repository == "" || (dep.Path != "" && dep.Path != "/") {
+			continue
+		}
+		theVcs := vcs.ByCmd(vcsGit) // default to git
+		if dep.VCS != "" {
+			if v := vcsByCmd(dep.VCS); v != nil {
+				theVcs = v
+			}
+		}
+		if src.repoPaths == nil {
+			src.repoPaths = make(map[string]*RepoPath)
+		}
+		src.repoPaths[dep.ImportPath] = &RepoPath{
+			RepoRoot: vcs.RepoRoot{
+				VCS:  theVcs,
+				Repo: dep.Repository,
+				Root: dep.ImportPath,
+			},
+		}
+	}
+	return nil
+}
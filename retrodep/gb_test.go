@@ -0,0 +1,56 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGb(t *testing.T) {
+	defer mockRepoRoots()()
+
+	src, err := NewGoSource("testdata/gb", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Vendor() != filepath.FromSlash("testdata/gb/vendor/src") {
+		t.Errorf("Vendor: %s", src.Vendor())
+	}
+
+	vendored, err := src.VendoredProjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar := vendored["github.com/foo/bar"]
+	if len(vendored) != 1 || bar == nil {
+		t.Fatalf("unexpected vendored projects %v", vendored)
+	}
+	if bar.Repo != "https://github.com/fork/bar" {
+		t.Errorf("github.com/foo/bar: got %s", bar.Repo)
+	}
+	if dir := src.projectDir(&Reference{TopPkg: "example.com/gb", Pkg: "github.com/foo/bar"}); dir != "vendor/src/github.com/foo/bar" {
+		t.Errorf("projectDir: %s", dir)
+	}
+
+	if _, ok := src.repoPaths["github.com/eggs/ham/spam"]; ok {
+		t.Error("replacement repository for sub-path")
+	}
+	claims := src.manifestClaims("github.com/eggs/ham")
+	if len(claims) != 1 || claims[0].File != "vendor/manifest" {
+		t.Errorf("unexpected claims %v", claims)
+	}
+}
@@ -18,26 +18,56 @@ package retrodep
 // This file contains methods specific to working with git.
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
-	"os"
+	"io"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Masterminds/semver"
 	"github.com/pkg/errors"
 )
 
 type gitWorkingTree struct {
 	anyWorkingTree
+
+	// exportAttributes means file hashes are read from 'git
+	// archive', which applies export-ignore and export-subst.
+	exportAttributes bool
+
+	// submodules, if set, holds the working trees of submodules,
+	// whose files are included.
+	submodules *gitSubmodules
+
+	// lfs means the file hashes of LFS pointer files are replaced
+	// by those of the objects they point to.
+	lfs bool
+
+	// tagPrefix, if set, is the prefix of the tags considered,
+	// which is removed from their names.
+	tagPrefix string
+}
+
+// WithContext implements the ContextWorkingTree interface.
+func (g *gitWorkingTree) WithContext(ctx context.Context) WorkingTree {
+	c := *g
+	c.ctx = ctx
+	return &c
 }
 
-// Revisions returns all revisions in the git repository, using 'git
-// rev-list --all'.
+// Revisions returns all revisions in the git repository, or those
+// reachable from the Branch, within any RevisionLimits, using 'git
+// rev-list ...'.
 func (g *gitWorkingTree) Revisions() ([]string, error) {
-	stdout, stderr, err := g.run("rev-list", "--all")
+	stdout, stderr, err := g.run(g.revListArgs()...)
 	if err != nil {
 		g.showOutput(stdout, stderr)
 		return nil, err
@@ -50,10 +80,56 @@ func (g *gitWorkingTree) Revisions() ([]string, error) {
 	return revisions, nil
 }
 
+// revListArgs returns the 'git rev-list' arguments for Revisions.
+func (g *gitWorkingTree) revListArgs() []string {
+	args := []string{"rev-list", "--all"}
+	if g.branch != "" {
+		args = []string{"rev-list", gitBranchRef(g.branch)}
+	}
+	return append(args, g.limits.gitArgs()...)
+}
+
+// RevisionsIter implements the RevisionsIterWorkingTree interface,
+// reading the output of 'git rev-list ...' as it is written. Once
+// yield asks to stop, git is stopped too.
+func (g *gitWorkingTree) RevisionsIter(yield func(rev string) (bool, error)) error {
+	err := g.stream(nil, func(r *bufio.Reader) error {
+		output := bufio.NewScanner(r)
+		for output.Scan() {
+			stop, err := yield(strings.TrimSpace(output.Text()))
+			if err != nil {
+				return err
+			}
+			if stop {
+				return errStopRevisions
+			}
+		}
+		return output.Err()
+	}, g.revListArgs()...)
+	if err == errStopRevisions {
+		return nil
+	}
+	return err
+}
+
+// VersionTags returns the tags that are parseable as semantic tags,
+// highest first. With a tag prefix, only the tags beginning with it
+// are considered, and it is removed.
+func (g *gitWorkingTree) VersionTags() ([]string, error) {
+	if g.tagPrefix == "" {
+		return g.anyWorkingTree.VersionTags()
+	}
+	tags, err := g.tags()
+	if err != nil {
+		return nil, err
+	}
+	return versionTags(stripTagPrefix(g.tagPrefix, tags)), nil
+}
+
 // RevisionFromTag returns the commit hash for the given tag, using
 // 'git rev-parse ...'
 func (g *gitWorkingTree) RevisionFromTag(tag string) (string, error) {
-	stdout, stderr, err := g.run("rev-parse", tag)
+	stdout, stderr, err := g.run("rev-parse", g.tagRef(tag))
 	if err != nil {
 		g.showOutput(stdout, stderr)
 		return "", err
@@ -63,40 +139,130 @@ func (g *gitWorkingTree) RevisionFromTag(tag string) (string, error) {
 }
 
 // RevSync updates the working tree to reflect the revision rev, using
-// 'git checkout ...'. The working tree must not have been locally
-// modified.
+// 'git checkout ...', and checks out its submodules if they are
+// included. The working tree must not have been locally modified.
+// A rev starting with "-", which git would take as an option, is
+// rejected.
 func (g *gitWorkingTree) RevSync(rev string) error {
-	stdout, stderr, err := g.run("checkout", rev)
+	if strings.HasPrefix(rev, "-") {
+		return ErrorInvalidRef
+	}
+	stdout, stderr, err := g.run("checkout", g.tagRef(rev), "--")
 	if err != nil {
 		g.showOutput(stdout, stderr)
+		return err
 	}
-	return err
+	return g.updateSubmodules()
+}
+
+// TagSync syncs the working tree to the named tag, as vcs.Cmd.TagSync
+// does, and checks out its submodules if they are included.
+func (g *gitWorkingTree) TagSync(tag string) error {
+	if err := g.anyWorkingTree.TagSync(g.tagRef(tag)); err != nil {
+		return err
+	}
+	return g.updateSubmodules()
 }
 
 // TimeFromRevision returns the commit timestamp for the revision
-// rev, using 'git show -s --pretty=format:%cI ...'.
+// rev, in UTC, using 'git show -s --pretty=format:%cI ...'.
 func (g *gitWorkingTree) TimeFromRevision(rev string) (time.Time, error) {
 	run := g.run
 	var t time.Time
-	stdout, stderr, err := run("show", "-s", "--pretty=format:%cI", rev)
+	stdout, stderr, err := run("show", "-s", "--pretty=format:%cI", g.tagRef(rev))
 	if err != nil {
 		g.showOutput(stdout, stderr)
 		return t, err
 	}
 
 	t, err = time.Parse(time.RFC3339, strings.TrimSpace(stdout.String()))
-	return t, err
+	return t.UTC(), err
 }
 
+// describeCandidates is the most tags ReachableTag tries in turn,
+// of each kind, when the TagMatch leaves out tags which are not
+// semantic versions.
+const describeCandidates = 10
+
 // ReachableTag returns the most recent reachable semver tag, using
 // 'git describe --tags --match=...', with match globs for tags that
-// are likely to be semvers. It returns ErrorVersionNotFound if no
-// suitable tag is found.
+// are likely to be semvers or else those of the TagMatch. With a
+// Branch, only the first parent of merges is followed. It returns
+// ErrorVersionNotFound if no suitable tag is found.
 func (g *gitWorkingTree) ReachableTag(rev string) (string, error) {
+	switch g.tagMatch.Prefer {
+	case AnnotatedTags:
+		tag, err := g.describe(rev, false, nil)
+		if err != ErrorVersionNotFound {
+			return tag, err
+		}
+	case LightweightTags:
+		annotated, err := g.annotatedTags()
+		if err != nil {
+			return "", err
+		}
+		if len(annotated) > 0 {
+			tag, err := g.describe(rev, true, annotated)
+			if err != ErrorVersionNotFound {
+				return tag, err
+			}
+		}
+	}
+	return g.describe(rev, true, nil)
+}
+
+// describe returns the most recent tag reachable from rev which the
+// TagMatch accepts, trying up to describeCandidates tags. Lightweight
+// tags are only considered if lightweight is true, and tags matching
+// the exclude globs never are.
+func (g *gitWorkingTree) describe(rev string, lightweight bool, exclude []string) (string, error) {
+	exclude = exclude[:len(exclude):len(exclude)]
+	for _, pattern := range g.tagMatch.Exclude {
+		exclude = append(exclude, g.tagPrefix+pattern)
+	}
+	for i := 0; i < describeCandidates; i++ {
+		tag, err := g.describeOnce(rev, lightweight, exclude)
+		if err != nil {
+			return "", err
+		}
+		if !g.tagMatch.SemverOnly {
+			return tag, nil
+		}
+		if _, err := semver.NewVersion(tag); err == nil {
+			return tag, nil
+		}
+		log.Debugf("%s: %s is not a semantic version, trying older tags", rev, tag)
+		exclude = append(exclude, globEscape(g.tagPrefix+tag))
+	}
+	return "", ErrorVersionNotFound
+}
+
+// describeOnce returns the most recent tag reachable from rev which
+// matches the match globs and none of the exclude globs, using 'git
+// describe'.
+func (g *gitWorkingTree) describeOnce(rev string, lightweight bool, exclude []string) (string, error) {
 	run := g.run
+	matches := [][]string{{"v[0-9]*"}, {"[0-9]*"}}
+	if len(g.tagMatch.Match) > 0 {
+		matches = [][]string{g.tagMatch.Match}
+	}
 	var tag string
-	for _, match := range []string{"v[0-9]*", "[0-9]*"} {
-		stdout, stderr, err := run("describe", "--tags", "--match="+match, rev)
+	for _, match := range matches {
+		args := []string{"describe"}
+		if lightweight {
+			args = append(args, "--tags")
+		}
+		if g.branch != "" {
+			args = append(args, "--first-parent")
+		}
+		for _, pattern := range match {
+			args = append(args, "--match="+g.tagPrefix+pattern)
+		}
+		for _, pattern := range exclude {
+			args = append(args, "--exclude="+pattern)
+		}
+		args = append(args, g.tagRef(rev))
+		stdout, stderr, err := run(args...)
 		output := strings.TrimSpace(stdout.String() + stderr.String())
 		if err == nil {
 			tag = output
@@ -125,6 +291,7 @@ func (g *gitWorkingTree) ReachableTag(rev string) (string, error) {
 	}
 
 	log.Debugf("%s is described as %s", rev, tag)
+	tag = strings.TrimPrefix(tag, g.tagPrefix)
 	fields := strings.Split(tag, "-")
 	if len(fields) < 3 {
 		// This matches a tag exactly (it must not be a semver tag)
@@ -134,71 +301,376 @@ func (g *gitWorkingTree) ReachableTag(rev string) (string, error) {
 	return tag, nil
 }
 
-// FileHashesFromRef parses the output of 'git ls-tree -r' to
-// return the file hashes for the given tag or revision ref.
+// annotatedTags returns globs matching each annotated tag beginning
+// with the tag prefix, using 'git for-each-ref'.
+func (g *gitWorkingTree) annotatedTags() ([]string, error) {
+	stdout, stderr, err := g.run("for-each-ref",
+		"--format=%(objecttype) %(refname)", "refs/tags/"+g.tagPrefix)
+	if err != nil {
+		g.showOutput(stdout, stderr)
+		return nil, err
+	}
+	var globs []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "tag" {
+			globs = append(globs, globEscape(strings.TrimPrefix(fields[1], "refs/tags/")))
+		}
+	}
+	return globs, nil
+}
+
+// FileHashesFromRef returns the file hashes for the given tag or
+// revision ref, from the local or shared cache if possible.
 func (g *gitWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
-	args := []string{"ls-tree", "-r", ref}
+	ref = g.tagRef(ref)
+	fetch := g.fileHashesFromRef
+	if g.lfs {
+		fetch = g.lfsFileHashes(fetch)
+	}
+	if g.submodules != nil {
+		fetch = g.submoduleFileHashes(fetch)
+	}
+	if g.submodules != nil || g.lfs || g.exportAttributes {
+		// The shared caches hold file hashes from 'git ls-tree'
+		// of the repository alone.
+		return g.cachedFileHashes(ref, subPath, fetch)
+	}
+	return g.cachedFileHashes(ref, subPath,
+		g.remoteFileHashes(fetch, g.RevisionFromTag))
+}
+
+// fileHashesFromRef returns the file hashes for the given tag or
+// revision ref from the object IDs listed by 'git ls-tree -r'. For
+// hash algorithms other than git's own it hashes the content of the
+// files from 'git cat-file --batch' instead, and to apply export
+// attributes the files from 'git archive'.
+func (g *gitWorkingTree) fileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	hasher, ok := g.hasher.(contentHasher)
+	hashContent := ok && g.algorithm != g.nativeAlgorithm
+	switch {
+	case g.exportAttributes && hashContent:
+		return g.archiveFileHashes(hasher, ref, subPath)
+	case g.exportAttributes:
+		blobHasher := gitBlobHasher{sha256: g.nativeAlgorithm == HashGitSHA256}
+		return g.archiveFileHashes(blobHasher, ref, subPath)
+	case hashContent:
+		return g.batchFileHashes(hasher, ref, subPath)
+	}
+	entries, err := g.lsTree(ref, subPath)
+	if err != nil {
+		return nil, err
+	}
+	fh := make(FileHashes, len(entries))
+	for _, entry := range entries {
+		fh[entry.path] = gitObjectHash(entry.object)
+	}
+	return fh, nil
+}
+
+// archiveFileHashes returns the file hashes from hasher for the files
+// in the output of 'git archive' for ref.
+func (g *gitWorkingTree) archiveFileHashes(hasher contentHasher, ref, subPath string) (FileHashes, error) {
+	args := []string{"archive", "--format=tar", ref}
 	if subPath != "" {
-		args = append(args, subPath)
+		args = append(args, "--", subPath)
 	}
 	stdout, stderr, err := g.run(args...)
 	if err != nil {
-		output := strings.ToLower(stdout.String() + stderr.String())
+		output := strings.ToLower(stderr.String())
 		switch {
-		case strings.HasPrefix(output, "fatal: not a valid object name "):
-			// This is a branch name, not a tag name
-			return nil, ErrorInvalidRef
-		case strings.HasPrefix(output, "fatal: not a tree object"):
-			// This ref is not present in the repo
+		case strings.HasPrefix(output, "fatal: not a valid object name"),
+			strings.HasPrefix(output, "fatal: not a tree object"):
 			return nil, ErrorInvalidRef
+		case strings.HasPrefix(output, "fatal: pathspec "):
+			// Nothing within subPath, as for 'git ls-tree'
+			return make(FileHashes), nil
 		}
 
 		g.showOutput(stdout, stderr)
 		return nil, err
 	}
+
 	fh := make(FileHashes)
+	tr := tar.NewReader(stdout)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "git archive %s", ref)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeSymlink {
+			continue
+		}
+		filename := filepath.FromSlash(hdr.Name)
+		if subPath != "" {
+			filename, err = filepath.Rel(subPath, filename)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Rel(%q, %q)",
+					subPath, hdr.Name)
+			}
+		}
+		if hdr.Typeflag == tar.TypeSymlink {
+			fh[filename], err = hasher.hashContent(strings.NewReader(hdr.Linkname))
+		} else {
+			fh[filename], err = pathHasher(hasher, filepath.ToSlash(filename)).hashContent(tr)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "hashing %s", hdr.Name)
+		}
+	}
+	return fh, nil
+}
+
+// gitGrepRE matches a line of 'git grep -n' output following the
+// ref, capturing the path, line number and text.
+var gitGrepRE = regexp.MustCompile(`^(.*?):([0-9]+):(.*)$`)
+
+// Grep returns the lines matching the extended regular expression
+// pattern in files at ref, using 'git grep -n -I -E ...'. Binary
+// files are not searched.
+func (g *gitWorkingTree) Grep(ref, pattern, subPath string) ([]GrepMatch, error) {
+	ref = g.tagRef(ref)
+	args := []string{"grep", "-n", "-I", "--full-name", "-E", "-e", pattern, ref}
+	if subPath != "" {
+		args = append(args, "--", subPath)
+	}
+	stdout, stderr, err := g.run(args...)
+	if err != nil {
+		if exitedWith(err, 1) && stderr.Len() == 0 {
+			// No lines matched
+			return nil, nil
+		}
+		g.showOutput(stdout, stderr)
+		return nil, err
+	}
+
+	var matches []GrepMatch
 	scanner := bufio.NewScanner(stdout)
 	for scanner.Scan() {
+		// <ref> : <file> : <line> : <text>
 		line := scanner.Text()
-		// <mode> SP <type> SP <object> TAB <file>
-		ts := strings.SplitN(line, "\t", 2)
-		if len(ts) != 2 {
-			return nil, fmt.Errorf("expected TAB: %s", line)
+		m := gitGrepRE.FindStringSubmatch(strings.TrimPrefix(line, ref+":"))
+		if m == nil {
+			return nil, fmt.Errorf("unexpected grep output: %s", line)
 		}
-		var filename string
-		if subPath == "" {
-			filename = ts[1]
-		} else {
-			filename, err = filepath.Rel(subPath, ts[1])
+		path := m[1]
+		if subPath != "" {
+			path, err = filepath.Rel(subPath, path)
 			if err != nil {
 				return nil, errors.Wrapf(err, "Rel(%q, %q)",
-					subPath, ts[1])
+					subPath, m[1])
 			}
 		}
-		fields := strings.Fields(ts[0])
-		if len(fields) != 3 {
-			return nil, fmt.Errorf("expected 3 fields: %s", ts[0])
+		lineno, _ := strconv.Atoi(m[2])
+		matches = append(matches, GrepMatch{
+			Path: path,
+			Line: lineno,
+			Text: m[3],
+		})
+	}
+	return matches, scanner.Err()
+}
+
+// LastRevisions returns the revision which last changed each file
+// at ref, using 'git log -z --name-status ...'.
+func (g *gitWorkingTree) LastRevisions(ref, subPath string) (map[string]string, error) {
+	args := []string{"log", "-z", "--format=" + changeLogPrefix + "%H",
+		"--name-status", "--no-renames", g.tagRef(ref)}
+	if subPath != "" {
+		args = append(args, "--", subPath)
+	}
+	stdout, stderr, err := g.run(args...)
+	if err != nil {
+		g.showOutput(stdout, stderr)
+		return nil, err
+	}
+	return parseGitChangeLog(stdout.String(), subPath)
+}
+
+// parseGitChangeLog parses the output of 'git log -z --name-status'
+// for LastRevisions. Each commit is given by a NUL-terminated field
+// starting with changeLogPrefix, followed by a status field and a
+// path field for each file it changed, so paths need no quoting. The
+// status field after a commit is preceded by a newline.
+func parseGitChangeLog(out, subPath string) (map[string]string, error) {
+	changes := newLastChanges(subPath)
+	fields := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	var rev string
+	for i := 0; i < len(fields); i++ {
+		field := strings.TrimPrefix(fields[i], "\n")
+		if strings.HasPrefix(field, changeLogPrefix) {
+			rev = field[len(changeLogPrefix):]
+			continue
+		}
+		if field == "" && i == len(fields)-1 {
+			break
+		}
+		if rev == "" || i+1 == len(fields) {
+			return nil, fmt.Errorf("unexpected log output: %q", field)
+		}
+		i++
+		changes.add(rev, field, fields[i])
+	}
+	return changes.revs, nil
+}
+
+// Submodules returns the submodules of ref, using 'git ls-tree' to
+// find the commits pinned and the .gitmodules file of ref for their
+// URLs.
+func (g *gitWorkingTree) Submodules(ref string) ([]Submodule, error) {
+	ref = g.tagRef(ref)
+	stdout, stderr, err := g.run("ls-tree", "-r", "-z", "--full-tree", ref)
+	if err != nil {
+		g.showOutput(stdout, stderr)
+		return nil, err
+	}
+	var submodules []Submodule
+	for _, entry := range strings.Split(stdout.String(), "\x00") {
+		// <mode> SP <type> SP <object> TAB <file>
+		tab := strings.IndexByte(entry, '\t')
+		if tab == -1 {
+			continue
+		}
+		fields := strings.Fields(entry[:tab])
+		if len(fields) != 3 || fields[1] != "commit" {
+			continue
 		}
+		submodules = append(submodules, Submodule{
+			Path: entry[tab+1:],
+			Rev:  fields[2],
+		})
+	}
+	if len(submodules) == 0 {
+		return nil, nil
+	}
 
-		fh[filename] = FileHash(fields[2])
+	urls, err := g.submoduleURLs(ref)
+	if err != nil {
+		log.Debugf("%s:.gitmodules: %s", ref, err)
+	}
+	for i := range submodules {
+		submodules[i].URL = urls[submodules[i].Path]
 	}
+	sort.Slice(submodules, func(i, j int) bool {
+		return submodules[i].Path < submodules[j].Path
+	})
+	return submodules, nil
+}
 
-	return fh, nil
+// submoduleURLs returns the URL of each submodule path configured in
+// the .gitmodules file of ref.
+func (g *gitWorkingTree) submoduleURLs(ref string) (map[string]string, error) {
+	stdout, _, err := g.run("config", "--blob", ref+":.gitmodules", "-z",
+		"--get-regexp", `^submodule\..*\.(path|url)$`)
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]string)
+	urls := make(map[string]string)
+	for _, item := range strings.Split(stdout.String(), "\x00") {
+		// submodule.<name>.<key> LF <value>
+		nl := strings.IndexByte(item, '\n')
+		if nl == -1 {
+			continue
+		}
+		key, value := item[:nl], item[nl+1:]
+		dot := strings.LastIndexByte(key, '.')
+		name := strings.TrimPrefix(key[:dot], "submodule.")
+		switch key[dot+1:] {
+		case "path":
+			paths[name] = value
+		case "url":
+			urls[name] = value
+		}
+	}
+	byPath := make(map[string]string)
+	for name, pth := range paths {
+		byPath[pth] = urls[name]
+	}
+	return byPath, nil
+}
+
+// gerritChangesRef is the prefix of refs for Gerrit changes.
+const gerritChangesRef = "refs/changes/"
+
+// FetchChanges fetches the refs for Gerrit changes, including those
+// which are not merged, so that their revisions can be matched.
+func (g *gitWorkingTree) FetchChanges() error {
+	stdout, stderr, err := g.runRetried("fetch", "--quiet", "origin",
+		"+"+gerritChangesRef+"*:"+gerritChangesRef+"*")
+	if err != nil {
+		g.showOutput(stdout, stderr)
+	}
+	return err
+}
+
+// UnmergedChange returns the Gerrit change ref (of the form
+// refs/changes/NN/CHANGE/PATCHSET) containing rev if rev is not
+// reachable from any other ref, or "" if it is.
+func (g *gitWorkingTree) UnmergedChange(rev string) (string, error) {
+	stdout, stderr, err := g.run("for-each-ref", "--contains", rev,
+		"--format=%(refname)")
+	if err != nil {
+		g.showOutput(stdout, stderr)
+		return "", err
+	}
+	var change string
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		ref := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(ref, gerritChangesRef) {
+			// Merged
+			return "", nil
+		}
+		if change == "" {
+			change = ref
+		}
+	}
+	return change, nil
+}
+
+// objectFormat returns the object format of the repository, using
+// 'git rev-parse --show-object-format'. Versions of git without
+// support for this only know the "sha1" format.
+func (g *gitWorkingTree) objectFormat() string {
+	stdout, _, err := g.run("rev-parse", "--show-object-format")
+	if err == nil && strings.TrimSpace(stdout.String()) == "sha256" {
+		return "sha256"
+	}
+	return "sha1"
 }
 
-type gitHasher struct{}
+// gitHasher uses 'git hash-object' in the repository at dir, so that
+// its object format and .gitattributes are used.
+type gitHasher struct {
+	dir     string
+	options []string
+}
 
 // Hash implements the Hasher interface for git.
 func (g *gitHasher) Hash(relativePath, absPath string) (FileHash, error) {
-	args := []string{"hash-object", "--path", relativePath, absPath}
+	if g.dir != "" && !filepath.IsAbs(absPath) {
+		// The file name is relative to our directory, not g.dir.
+		abs, err := filepath.Abs(absPath)
+		if err != nil {
+			return FileHash(""), err
+		}
+		absPath = abs
+	}
+	args := append(append([]string{}, g.options...),
+		"hash-object", "--path", relativePath, absPath)
 	cmd := exec.Command(vcsGit, args...)
-	var buf bytes.Buffer
-	cmd.Stdout = &buf
-	cmd.Stderr = &buf
-	err := cmd.Run()
+	cmd.Dir = g.dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := runCommand(cmd)
 	if err != nil {
-		os.Stderr.Write(buf.Bytes())
+		traceLog.output(&stdout, &stderr, "dir", g.dir)
 		return FileHash(""), err
 	}
-	return FileHash(strings.TrimSpace(buf.String())), nil
+	return gitObjectHash(strings.TrimSpace(stdout.String())), nil
 }
@@ -16,7 +16,13 @@
 package retrodep
 
 import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -91,6 +97,25 @@ func TestGitRevSync(t *testing.T) {
 	if err := wt.RevSync(revision); err != nil {
 		t.Errorf("unexpected error: RevSync(%q): %s", revision, err)
 	}
+
+	var args []string
+	execCommand = func(command string, a ...string) *exec.Cmd {
+		args = a
+		return exec.Command("true")
+	}
+	if err := wt.RevSync(revision); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"checkout", revision, "--"}; !reflect.DeepEqual(args, expected) {
+		t.Errorf("got %v, want %v", args, expected)
+	}
+	args = nil
+	if err := wt.RevSync("--orphan=x"); err != ErrorInvalidRef {
+		t.Errorf("got %v, want ErrorInvalidRef", err)
+	}
+	if args != nil {
+		t.Errorf("ran git %v", args)
+	}
 }
 
 func TestGitTimeFromRevision(t *testing.T) {
@@ -122,6 +147,9 @@ func TestGitTimeFromRevision(t *testing.T) {
 		if !tm.Equal(expected) {
 			t.Errorf("unexpected time: got %s, want %s", tm, expected)
 		}
+		if tm.Location() != time.UTC {
+			t.Errorf("time not in UTC: %s", tm)
+		}
 	}
 }
 
@@ -280,6 +308,11 @@ func TestGitErrors(t *testing.T) {
 		t.Error("FileHashesFromRef: git failure was not reported")
 	}
 
+	_, err = wt.LastRevisions("012345", "")
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Error("LastRevisions: git failure was not reported")
+	}
+
 	mockedStderr = "fatal: Not a valid object name 012345\n"
 	_, err = wt.FileHashesFromRef("012345", "")
 	if err != ErrorInvalidRef {
@@ -298,3 +331,389 @@ func TestGitErrors(t *testing.T) {
 		t.Error("Hash: git failure was not reported")
 	}
 }
+
+func TestGitUnmergedChange(t *testing.T) {
+	defer mockExecCommand()()
+
+	wt := gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			Dir: "",
+			VCS: vcs.ByCmd(vcsGit),
+		},
+	}
+
+	tcs := []struct {
+		stdout string
+		change string
+	}{
+		{"refs/changes/45/12345/1\nrefs/changes/45/12345/2\n", "refs/changes/45/12345/1"},
+		{"refs/changes/45/12345/1\nrefs/remotes/origin/master\n", ""},
+		{"", ""},
+	}
+	for _, tc := range tcs {
+		mockedStdout = tc.stdout
+		change, err := wt.UnmergedChange("d4c3dbfa77a74ae238e401d5d2197b45f30d8513")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if change != tc.change {
+			t.Errorf("%q: got %q, want %q", tc.stdout, change, tc.change)
+		}
+	}
+}
+
+func TestGitGrep(t *testing.T) {
+	defer mockExecCommand()()
+
+	wt := gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			Dir: "",
+			VCS: vcs.ByCmd(vcsGit),
+		},
+	}
+
+	mockedStdout = strings.Join([]string{
+		`v1.0.0:sub/version.go:3:const Version = "1.0.0"`,
+		`v1.0.0:sub/doc/a:b.txt:10:Version: 1.0.0`,
+	}, "\n") + "\n"
+	matches, err := wt.Grep("v1.0.0", "Version", "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []GrepMatch{
+		{Path: "version.go", Line: 3, Text: `const Version = "1.0.0"`},
+		{Path: "doc/a:b.txt", Line: 10, Text: "Version: 1.0.0"},
+	}
+	if !reflect.DeepEqual(matches, expected) {
+		t.Errorf("got %v, want %v", matches, expected)
+	}
+
+	mockedStdout = "what?\n"
+	if _, err := wt.Grep("v1.0.0", "Version", ""); err == nil {
+		t.Error("invalid output not reported as error")
+	}
+
+	// No matches
+	mockedStdout = ""
+	mockedExitStatus = 1
+	matches, err = wt.Grep("v1.0.0", "Version", "")
+	if err != nil || len(matches) != 0 {
+		t.Errorf("no matches: got %v, %v", matches, err)
+	}
+
+	mockedStderr = "fatal: not a git repository\n"
+	mockedExitStatus = 128
+	if _, err := wt.Grep("v1.0.0", "Version", ""); err == nil {
+		t.Error("git failure was not reported")
+	}
+}
+
+func TestGitLastRevisions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Output is NUL-separated, so cannot be mocked through the
+	// environment.
+	writeFiles(t, dir, map[string]string{"log": strings.Join([]string{
+		"commit a2176f4275f92ceddb47cff1e363313156124bf6",
+		"\nM", "vendor/github.com/foo/bar/bar.go",
+		"commit 0a5a2b8c5b1a0c1d2bb1c8e1d1e4cfd0b9c2b4d7",
+		"commit d4c3dbfa77a74ae238e401d5d2197b45f30d8513",
+		"\nA", "vendor/github.com/foo/bar/bar.go",
+		"A", "vendor/github.com/foo/bar/bar baz.go",
+		"A", "vendor/github.com/foo/bar/tab\tnew\nline.go",
+	}, "\x00") + "\x00"})
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		return exec.Command("cat", filepath.Join(dir, args[0]))
+	}
+
+	wt := gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			Dir: "",
+			VCS: vcs.ByCmd(vcsGit),
+		},
+	}
+	revs, err := wt.LastRevisions("HEAD", "vendor/github.com/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"bar.go":            "a2176f4275f92ceddb47cff1e363313156124bf6",
+		"bar baz.go":        "d4c3dbfa77a74ae238e401d5d2197b45f30d8513",
+		"tab\tnew\nline.go": "d4c3dbfa77a74ae238e401d5d2197b45f30d8513",
+	}
+	if !reflect.DeepEqual(revs, expected) {
+		t.Errorf("got %v, want %v", revs, expected)
+	}
+}
+
+func TestGitSHA256(t *testing.T) {
+	defer mockExecCommand()()
+
+	wt := gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			Dir: "",
+			VCS: vcs.ByCmd(vcsGit),
+		},
+	}
+
+	mockedStdout = "sha256\n"
+	if format := wt.objectFormat(); format != "sha256" {
+		t.Errorf("object format: got %s", format)
+	}
+	mockedStdout = "--show-object-format\n" // older git
+	if format := wt.objectFormat(); format != "sha1" {
+		t.Errorf("object format: got %s", format)
+	}
+
+	id := "473a0f4c3be8a93681a267e3b1e9a7dcda1185436fe141f7749120a303721813"
+	mockedStdout = "100644 blob " + id + "\tempty.go\n"
+	h, err := wt.FileHashesFromRef("HEAD", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fh := h["empty.go"]; fh.Algorithm() != HashGitSHA256 || fh.Sum() != id {
+		t.Errorf("got %s", fh)
+	}
+}
+
+func TestGitSHA256Repository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("needs git")
+	}
+	dir := t.TempDir()
+	upstream := filepath.Join(dir, "upstream")
+	files := map[string]string{
+		"a.go":     "package a\n",
+		"sub/b.go": "package sub\n",
+	}
+	writeFiles(t, upstream, files)
+	if output, err := exec.Command("git", "init", "--quiet", "--object-format=sha256", upstream).CombinedOutput(); err != nil {
+		t.Skipf("git without sha256 support: %s", output)
+	}
+	git := func(args ...string) {
+		t.Helper()
+		p := exec.Command("git", args...)
+		p.Dir = upstream
+		p.Env = append(commandEnv(), "GIT_AUTHOR_NAME=a", "GIT_AUTHOR_EMAIL=a@example.com",
+			"GIT_COMMITTER_NAME=a", "GIT_COMMITTER_EMAIL=a@example.com", "GIT_CONFIG_NOSYSTEM=1")
+		if output, err := p.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s: %s", args, err, output)
+		}
+	}
+	git("add", ".")
+	git("commit", "--quiet", "-m", "init")
+	git("tag", "v1.0.0")
+	local := filepath.Join(dir, "local")
+	writeFiles(t, local, files)
+
+	project := &vcs.RepoRoot{
+		VCS:  vcs.ByCmd(vcsGit),
+		Repo: upstream,
+		Root: "example.com/a",
+	}
+	tcs := []struct {
+		name      string
+		algorithm string
+		opts      []WorkingTreeOption
+	}{
+		{"default", HashGitSHA256, nil},
+		{"sha256", HashSHA256, nil},
+		{"export-attributes", HashGitSHA256, []WorkingTreeOption{ExportAttributes()}},
+		{"repo-cache", HashGitSHA256, []WorkingTreeOption{RepoCache(filepath.Join(dir, "cache"), 0)}},
+		{"partial-clone", HashGitSHA256, []WorkingTreeOption{PartialClone()}},
+	}
+	for _, tc := range tcs {
+		wt, err := NewWorkingTree(project, tc.opts...)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		defer wt.Close()
+		if wt.(*gitWorkingTree).nativeAlgorithm != HashGitSHA256 {
+			t.Errorf("%s: object format not detected", tc.name)
+		}
+		if tc.algorithm != wt.HashAlgorithm() {
+			if err := wt.SetHashAlgorithm(tc.algorithm); err != nil {
+				t.Fatalf("%s: %s", tc.name, err)
+			}
+		}
+
+		hashes, err := NewFileHashes(wt, local, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		upstreamHashes, err := wt.FileHashesFromRef("v1.0.0", "")
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if !reflect.DeepEqual(hashes, upstreamHashes) {
+			t.Errorf("%s: local %v, upstream %v", tc.name, hashes, upstreamHashes)
+		}
+		for path, fh := range upstreamHashes {
+			if fh.Algorithm() != tc.algorithm {
+				t.Errorf("%s: %s hashed with %s", tc.name, path, fh.Algorithm())
+			}
+		}
+	}
+}
+
+func TestGitSubmodules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-submodules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Output is NUL-separated, so cannot be mocked through the
+	// environment. Instead each command writes a file.
+	writeFiles(t, dir, map[string]string{
+		"ls-tree": strings.Join([]string{
+			"100644 blob 8ddd2cbbd8d7fc0ba1fe0ca6f6f6ac2ecc30d5cb\t.gitmodules",
+			"160000 commit d4c3dbfa77a74ae238e401d5d2197b45f30d8513\tthird_party/zlib",
+			"160000 commit a2176f4275f92ceddb47cff1e363313156124bf6\tthird_party/bar baz",
+		}, "\x00") + "\x00",
+		"config": strings.Join([]string{
+			"submodule.zlib.path\nthird_party/zlib",
+			"submodule.zlib.url\nhttps://github.com/madler/zlib",
+		}, "\x00") + "\x00",
+	})
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		return exec.Command("cat", filepath.Join(dir, args[0]))
+	}
+
+	wt := gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			Dir: "",
+			VCS: vcs.ByCmd(vcsGit),
+		},
+	}
+	submodules, err := wt.Submodules("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Submodule{
+		{Path: "third_party/bar baz", Rev: "a2176f4275f92ceddb47cff1e363313156124bf6"},
+		{Path: "third_party/zlib", Rev: "d4c3dbfa77a74ae238e401d5d2197b45f30d8513", URL: "https://github.com/madler/zlib"},
+	}
+	if !reflect.DeepEqual(submodules, expected) {
+		t.Errorf("got %v, want %v", submodules, expected)
+	}
+}
+
+func TestGitExportAttributes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 'git archive' has already left out export-ignore files and
+	// expanded export-subst placeholders.
+	files := map[string]string{
+		"a.go":       "package a\n",
+		"version.go": "package a // 0123456\n",
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	archive := filepath.Join(dir, "archive.tar")
+	if err := ioutil.WriteFile(archive, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var commands []string
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		commands = append(commands, args[0])
+		return exec.Command("cat", archive)
+	}
+
+	wt := gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			VCS:             vcs.ByCmd(vcsGit),
+			hasher:          &gitHasher{},
+			algorithm:       HashGitSHA1,
+			nativeAlgorithm: HashGitSHA1,
+		},
+		exportAttributes: true,
+	}
+	h, err := wt.FileHashesFromRef("v1.0.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := FileHashes{}
+	for name, content := range files {
+		expected[name], _ = gitBlobHasher{}.hashContent(strings.NewReader(content))
+	}
+	if !reflect.DeepEqual(h, expected) {
+		t.Errorf("got %v, want %v", h, expected)
+	}
+	if !reflect.DeepEqual(commands, []string{"archive"}) {
+		t.Errorf("ran %v", commands)
+	}
+}
+
+func TestGitArchiveSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "a.go", Mode: 0644, Typeflag: tar.TypeReg},
+		{Name: "inside", Linkname: "a.go", Typeflag: tar.TypeSymlink},
+		{Name: "outside", Linkname: "../../etc/passwd", Typeflag: tar.TypeSymlink},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	archive := filepath.Join(dir, "archive.tar")
+	if err := ioutil.WriteFile(archive, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		return exec.Command("cat", archive)
+	}
+
+	wt := gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			VCS:             vcs.ByCmd(vcsGit),
+			hasher:          &sha256Hasher{},
+			algorithm:       HashSHA256,
+			nativeAlgorithm: HashGitSHA1,
+		},
+	}
+	// Only export attributes need 'git archive'.
+	h, err := wt.archiveFileHashes(sha256Hasher{}, "v1.0.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := FileHashes{}
+	for name, content := range map[string]string{
+		"a.go":    "",
+		"inside":  "a.go",
+		"outside": "../../etc/passwd",
+	} {
+		expected[name], _ = sha256Hasher{}.hashContent(strings.NewReader(content))
+	}
+	if !reflect.DeepEqual(h, expected) {
+		t.Errorf("got %v, want %v", h, expected)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+// This file contains the methods which read the files at a git ref
+// with a fixed number of git processes, however many files there are.
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// gitLsTreeEntry is a line of 'git ls-tree' output.
+type gitLsTreeEntry struct {
+	mode, kind, object string
+
+	// path is relative to the subPath listed.
+	path string
+}
+
+// gitSymlinkMode is the mode of a symbolic link in a git tree.
+const gitSymlinkMode = "120000"
+
+// lsTree returns the entries from 'git ls-tree -r' for the files
+// within subPath at ref.
+func (g *gitWorkingTree) lsTree(ref, subPath string) ([]gitLsTreeEntry, error) {
+	args := []string{"ls-tree", "-r", ref, "--"}
+	if subPath != "" {
+		args = append(args, subPath)
+	}
+	stdout, stderr, err := g.run(args...)
+	if err != nil {
+		output := strings.ToLower(stdout.String() + stderr.String())
+		switch {
+		case strings.HasPrefix(output, "fatal: not a valid object name "):
+			// This is a branch name, not a tag name
+			return nil, ErrorInvalidRef
+		case strings.HasPrefix(output, "fatal: not a tree object"):
+			// This ref is not present in the repo
+			return nil, ErrorInvalidRef
+		}
+
+		g.showOutput(stdout, stderr)
+		return nil, err
+	}
+	var entries []gitLsTreeEntry
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// <mode> SP <type> SP <object> TAB <file>
+		ts := strings.SplitN(line, "\t", 2)
+		if len(ts) != 2 {
+			return nil, fmt.Errorf("expected TAB: %s", line)
+		}
+		var filename string
+		if subPath == "" {
+			filename = ts[1]
+		} else {
+			filename, err = filepath.Rel(subPath, ts[1])
+			if err != nil {
+				return nil, errors.Wrapf(err, "Rel(%q, %q)",
+					subPath, ts[1])
+			}
+		}
+		fields := strings.Fields(ts[0])
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("expected 3 fields: %s", ts[0])
+		}
+		entries = append(entries, gitLsTreeEntry{
+			mode:   fields[0],
+			kind:   fields[1],
+			object: fields[2],
+			path:   filename,
+		})
+	}
+	return entries, scanner.Err()
+}
+
+// batchFileHashes returns the file hashes from hasher for the files
+// within subPath at ref, reading their content with one 'git
+// cat-file --batch' process. Each blob is read once, however many
+// files share it. Submodules are left out, as by 'git archive'.
+func (g *gitWorkingTree) batchFileHashes(hasher contentHasher, ref, subPath string) (FileHashes, error) {
+	entries, err := g.lsTree(ref, subPath)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]gitLsTreeEntry)
+	var objects []string
+	for _, entry := range entries {
+		if entry.kind != "blob" {
+			continue
+		}
+		if _, ok := files[entry.object]; !ok {
+			objects = append(objects, entry.object)
+		}
+		files[entry.object] = append(files[entry.object], entry)
+	}
+	fh := make(FileHashes, len(entries))
+	if len(objects) == 0 {
+		return fh, nil
+	}
+
+	input := strings.NewReader(strings.Join(objects, "\n") + "\n")
+	err = g.stream(input, func(r *bufio.Reader) error {
+		for range objects {
+			object, content, err := readGitBatchObject(r)
+			if err != nil {
+				return err
+			}
+			for _, entry := range files[object] {
+				fileHasher := hasher
+				if entry.mode != gitSymlinkMode {
+					// Symbolic link targets are not
+					// normalized as file content.
+					fileHasher = pathHasher(hasher, filepath.ToSlash(entry.path))
+				}
+				fh[entry.path], err = fileHasher.hashContent(bytes.NewReader(content))
+				if err != nil {
+					return errors.Wrapf(err, "hashing %s", entry.path)
+				}
+			}
+		}
+		return nil
+	}, "cat-file", "--batch")
+	if err != nil {
+		return nil, err
+	}
+	return fh, nil
+}
+
+// readGitBatchObject reads the next object from 'git cat-file
+// --batch' output, returning its ID and content.
+func readGitBatchObject(r *bufio.Reader) (string, []byte, error) {
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return "", nil, errors.Wrap(err, "git cat-file")
+	}
+	// <object> SP <type> SP <size> LF <contents> LF
+	fields := strings.Fields(header)
+	if len(fields) == 2 && fields[1] == "missing" {
+		return "", nil, fmt.Errorf("git cat-file: %s missing", fields[0])
+	}
+	if len(fields) != 3 {
+		return "", nil, fmt.Errorf("unexpected cat-file output: %q", header)
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return "", nil, fmt.Errorf("unexpected cat-file output: %q", header)
+	}
+	content := make([]byte, size)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return "", nil, errors.Wrapf(err, "git cat-file %s", fields[0])
+	}
+	if lf, err := r.ReadByte(); err != nil || lf != '\n' {
+		return "", nil, fmt.Errorf("git cat-file %s: expected LF", fields[0])
+	}
+	return fields[0], content, nil
+}
+
+// stream runs git with args, after the working tree's options, with
+// stdin read from input, and calls consume to read its output as it
+// is written. The command is killed if the working tree's context is
+// done first.
+func (g *gitWorkingTree) stream(input io.Reader, consume func(r *bufio.Reader) error, args ...string) error {
+	if len(g.options) > 0 {
+		args = append(append([]string{}, g.options...), args...)
+	}
+	p := g.env.command(vcsGit, args...)
+	p.Dir = g.Dir
+	p.Stdin = input
+	var stderr bytes.Buffer
+	p.Stderr = &stderr
+	pr, pw := io.Pipe()
+	p.Stdout = pw
+	exited := make(chan error, 1)
+	go func() {
+		err := runCommandContext(g.context(), p)
+		pw.Close()
+		exited <- err
+	}()
+
+	err := consume(bufio.NewReader(pr))
+	// Unblock the command if its output was not all read.
+	pr.Close()
+	runErr := <-exited
+	if cause := errors.Cause(err); err != nil && cause != io.EOF && cause != io.ErrUnexpectedEOF {
+		// The output was not as expected, whether or not
+		// the command then failed.
+		return err
+	}
+	if runErr != nil {
+		g.showOutput(&bytes.Buffer{}, &stderr)
+		return runErr
+	}
+	return err
+}
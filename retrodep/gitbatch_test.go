@@ -0,0 +1,97 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestGitBatchFileHashes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const (
+		unformatted = "8ddd2cbbd8d7fc0ba1fe0ca6f6f6ac2ecc30d5cb"
+		target      = "d4c3dbfa77a74ae238e401d5d2197b45f30d8513"
+	)
+	writeFiles(t, dir, map[string]string{
+		"ls-tree": strings.Join([]string{
+			"100644 blob " + unformatted + "\tsub/a.go",
+			"100644 blob " + unformatted + "\tsub/a.txt",
+			"120000 blob " + target + "\tsub/link.go",
+			"160000 commit a2176f4275f92ceddb47cff1e363313156124bf6\tsub/zlib",
+		}, "\n") + "\n",
+		"cat-file": unformatted + " blob 11\npackage  a\n\n" +
+			target + " blob 4\na.go\n",
+	})
+	var commands []string
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		commands = append(commands, args[0])
+		return exec.Command("cat", filepath.Join(dir, args[0]))
+	}
+
+	hasher, err := NewHasher(HashSHA256 + "+" + NormalizeGofmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt := gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			VCS:             vcs.ByCmd(vcsGit),
+			hasher:          hasher,
+			algorithm:       HashSHA256 + "+" + NormalizeGofmt,
+			nativeAlgorithm: HashGitSHA1,
+		},
+	}
+	h, err := wt.FileHashesFromRef("v1.0.0", "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := FileHashes{}
+	for name, content := range map[string]string{
+		// Only Go files are formatted, and the link target is
+		// not.
+		"a.go":    "package a\n",
+		"a.txt":   "package  a\n",
+		"link.go": "a.go",
+	} {
+		expected[name], _ = hasher.(contentHasher).hashContent(strings.NewReader(content))
+	}
+	if !reflect.DeepEqual(h, expected) {
+		t.Errorf("got %v, want %v", h, expected)
+	}
+	if !reflect.DeepEqual(commands, []string{"ls-tree", "cat-file"}) {
+		t.Errorf("ran %v", commands)
+	}
+
+	writeFiles(t, dir, map[string]string{
+		"cat-file": unformatted + " missing\n",
+	})
+	if _, err := wt.FileHashesFromRef("v1.0.1", "sub"); err == nil {
+		t.Error("missing object not reported")
+	}
+}
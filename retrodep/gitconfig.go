@@ -0,0 +1,122 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// GitConfig maps repository URL prefixes to the git configuration
+// settings, in "name=value" form, used for the repositories they
+// match. The settings for the prefix "" are used for every
+// repository.
+type GitConfig map[string][]string
+
+var (
+	gitConfigMu sync.Mutex
+	gitConfig   = make(GitConfig)
+)
+
+// ReadGitConfig parses a git configuration file from r. Each line is
+// either a setting, such as "core.longpaths=true", used for every
+// repository, or a repository URL prefix followed by whitespace and a
+// setting used for the repositories it matches. Blank lines and lines
+// starting with "#" are ignored.
+func ReadGitConfig(r io.Reader) (GitConfig, error) {
+	config := make(GitConfig)
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		prefix, setting := "", line
+		if fields := strings.Fields(line); !strings.Contains(fields[0], "=") {
+			prefix = fields[0]
+			setting = strings.TrimSpace(line[len(prefix):])
+		}
+		if err := checkGitSetting(setting); err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineno, err)
+		}
+		config[prefix] = append(config[prefix], setting)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// checkGitSetting returns an error if setting is not "name=value"
+// with a dotted name.
+func checkGitSetting(setting string) error {
+	eq := strings.Index(setting, "=")
+	if eq < 0 || !strings.Contains(setting[:eq], ".") ||
+		strings.ContainsAny(setting[:eq], " \t") {
+		return fmt.Errorf("%q is not name=value", setting)
+	}
+	return nil
+}
+
+// AddGitConfig adds settings, in "name=value" form, to those used
+// for the git repositories whose URLs start with prefix, or for every
+// git repository if prefix is "". It affects working trees created
+// afterwards.
+func AddGitConfig(prefix string, settings ...string) error {
+	for _, setting := range settings {
+		if err := checkGitSetting(setting); err != nil {
+			return err
+		}
+	}
+	gitConfigMu.Lock()
+	defer gitConfigMu.Unlock()
+	gitConfig[prefix] = append(gitConfig[prefix], settings...)
+	return nil
+}
+
+// args returns the git options applying the settings for repo. When
+// a setting is given more than once, git uses the last one, so the
+// most specific prefixes come last.
+func (c GitConfig) args(repo string) []string {
+	var prefixes []string
+	for prefix := range c {
+		if strings.HasPrefix(repo, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(prefixes[i]) < len(prefixes[j])
+	})
+	var args []string
+	for _, prefix := range prefixes {
+		for _, setting := range c[prefix] {
+			args = append(args, "-c", setting)
+		}
+	}
+	return args
+}
+
+// gitConfigArgs returns the git options applying the settings added
+// with AddGitConfig for repo.
+func gitConfigArgs(repo string) []string {
+	gitConfigMu.Lock()
+	defer gitConfigMu.Unlock()
+	return gitConfig.args(repo)
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestReadGitConfig(t *testing.T) {
+	config, err := ReadGitConfig(strings.NewReader(`# comment
+core.longpaths=true
+
+https://git.example.com/ http.extraHeader=Authorization: Bearer token
+https://git.example.com/ core.autocrlf=false
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := GitConfig{
+		"": {"core.longpaths=true"},
+		"https://git.example.com/": {
+			"http.extraHeader=Authorization: Bearer token",
+			"core.autocrlf=false",
+		},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("got %v, want %v", config, expected)
+	}
+
+	for _, input := range []string{"https://example.com/\n", "core\n", "core longpaths=true\n"} {
+		if _, err := ReadGitConfig(strings.NewReader(input)); err == nil {
+			t.Errorf("%q: no error", input)
+		}
+	}
+}
+
+func TestGitConfigArgs(t *testing.T) {
+	config := GitConfig{
+		"":                            {"core.longpaths=true"},
+		"https://example.com/":        {"http.sslVerify=true"},
+		"https://example.com/foo/":    {"http.sslVerify=false"},
+		"https://example.com/foobar/": {"core.autocrlf=false"},
+	}
+	expected := []string{
+		"-c", "core.longpaths=true",
+		"-c", "http.sslVerify=true",
+		"-c", "http.sslVerify=false",
+	}
+	if args := config.args("https://example.com/foo/bar"); !reflect.DeepEqual(args, expected) {
+		t.Errorf("got %v, want %v", args, expected)
+	}
+	if args := (GitConfig{}).args("https://example.com/"); args != nil {
+		t.Errorf("got %v", args)
+	}
+}
+
+func TestAddGitConfig(t *testing.T) {
+	defer func() { gitConfig = make(GitConfig) }()
+	if err := AddGitConfig("", "core.longpaths=true"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddGitConfig("https://example.com/", "core"); err == nil {
+		t.Error("invalid setting accepted")
+	}
+	args := gitConfigArgs("https://example.com/repo")
+	if expected := []string{"-c", "core.longpaths=true"}; !reflect.DeepEqual(args, expected) {
+		t.Errorf("got %v, want %v", args, expected)
+	}
+}
+
+func TestWorkingTreeOptions(t *testing.T) {
+	var commands [][]string
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		commands = append(commands, append([]string{command}, args...))
+		return exec.Command("true")
+	}
+	defer func() { execCommand = exec.Command }()
+
+	wt := &gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			VCS:     vcs.ByCmd(vcsGit),
+			options: []string{"-c", "core.longpaths=true"},
+		},
+	}
+	if err := wt.TagSync("v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) == 0 {
+		t.Fatal("no commands run")
+	}
+	for _, command := range commands {
+		if len(command) < 3 || command[1] != "-c" || command[2] != "core.longpaths=true" {
+			t.Errorf("options not given: %v", command)
+		}
+	}
+
+	redacted := redactArgs([]string{"-c", "http.extraHeader=Authorization: Bearer token"})
+	if redacted[1] != "http.extraHeader=***" {
+		t.Errorf("not redacted: %v", redacted)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LFS makes git working trees hash the content of files stored with
+// Git LFS, rather than their pointer files, in the file hashes of
+// each ref which uses LFS. Objects are fetched with 'git lfs smudge'
+// unless their hash can be read from the pointer file. Git's LFS
+// filters are not applied when hashing local files.
+func LFS() WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		o.lfs = true
+	}
+}
+
+// lfsOptions stop git running the LFS filters, so that checkouts
+// have pointer files and local files are hashed as they are.
+var lfsOptions = []string{
+	"-c", "filter.lfs.clean=",
+	"-c", "filter.lfs.smudge=",
+	"-c", "filter.lfs.process=",
+	"-c", "filter.lfs.required=false",
+}
+
+// lfsPointerVersion is the first line of an LFS pointer file.
+const lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+
+// lfsPointerMaxSize is the largest an LFS pointer file can be.
+const lfsPointerMaxSize = 1024
+
+// lfsPointerRE matches the first line of an LFS pointer file.
+var lfsPointerRE = "^" + regexp.QuoteMeta(lfsPointerVersion) + "$"
+
+// lfsPointer is a parsed LFS pointer file.
+type lfsPointer struct {
+	// oid is the SHA-256 of the object, in hex.
+	oid string
+
+	// size is the object's size in bytes.
+	size int64
+}
+
+// parseLFSPointer parses the LFS pointer file content, returning
+// false if it is not one.
+func parseLFSPointer(content []byte) (*lfsPointer, bool) {
+	if len(content) > lfsPointerMaxSize {
+		return nil, false
+	}
+	var pointer lfsPointer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for first := true; scanner.Scan(); first = false {
+		line := scanner.Text()
+		if first {
+			if line != lfsPointerVersion {
+				return nil, false
+			}
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return nil, false
+		}
+		switch fields[0] {
+		case "oid":
+			oid := strings.TrimPrefix(fields[1], "sha256:")
+			if oid == fields[1] || len(oid) != 64 {
+				return nil, false
+			}
+			pointer.oid = oid
+		case "size":
+			size, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil || size < 0 {
+				return nil, false
+			}
+			pointer.size = size
+		}
+	}
+	if pointer.oid == "" {
+		return nil, false
+	}
+	return &pointer, true
+}
+
+// usesLFS reports whether any .gitattributes file at ref assigns
+// files to the lfs filter.
+func (g *gitWorkingTree) usesLFS(ref string) (bool, error) {
+	stdout, stderr, err := g.run("grep", "-l", "-I", "-e", "filter=lfs",
+		ref, "--", ":(glob)**/.gitattributes")
+	if err != nil {
+		if exitedWith(err, 1) && stderr.Len() == 0 {
+			return false, nil
+		}
+		g.showOutput(stdout, stderr)
+		return false, err
+	}
+	return stdout.Len() > 0, nil
+}
+
+// lfsFileHashes returns a function which is fetch, with the hashes
+// of LFS pointer files replaced by those of the objects they point
+// to. Objects which cannot be fetched keep the hash of their pointer
+// file.
+func (g *gitWorkingTree) lfsFileHashes(fetch func(ref, subPath string) (FileHashes, error)) func(ref, subPath string) (FileHashes, error) {
+	return func(ref, subPath string) (FileHashes, error) {
+		hashes, err := fetch(ref, subPath)
+		if err != nil {
+			return nil, err
+		}
+		uses, err := g.usesLFS(ref)
+		if err != nil || !uses {
+			return hashes, err
+		}
+		matches, err := g.Grep(ref, lfsPointerRE, subPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if _, ok := hashes[m.Path]; !ok || m.Line != 1 {
+				continue
+			}
+			path := filepath.ToSlash(filepath.Join(subPath, m.Path))
+			hash, err := g.lfsHash(ref, path)
+			if err != nil {
+				log.Warningf("%s: %s: LFS object: %s", ref, path, err)
+				continue
+			}
+			if hash != "" {
+				hashes[m.Path] = hash
+			}
+		}
+		return hashes, nil
+	}
+}
+
+// lfsHash returns the hash of the LFS object whose pointer file is
+// at path in ref, or "" if the file is not a pointer file.
+func (g *gitWorkingTree) lfsHash(ref, path string) (FileHash, error) {
+	content, stderr, err := g.run("cat-file", "blob", ref+":"+path)
+	if err != nil {
+		return FileHash(""), errors.Wrap(err, strings.TrimSpace(stderr.String()))
+	}
+	pointer, ok := parseLFSPointer(content.Bytes())
+	if !ok {
+		return FileHash(""), nil
+	}
+	if g.algorithm == HashSHA256 {
+		// The pointer file holds the hash.
+		return FileHash(HashSHA256 + ":" + pointer.oid), nil
+	}
+
+	object, stderr, err := g.runInput(bytes.NewReader(content.Bytes()),
+		"lfs", "smudge", "--", path)
+	if err != nil {
+		return FileHash(""), errors.Wrap(err, strings.TrimSpace(stderr.String()))
+	}
+	if int64(object.Len()) != pointer.size {
+		return FileHash(""), errors.Errorf("got %d bytes, want %d",
+			object.Len(), pointer.size)
+	}
+	hasher, ok := g.hasher.(contentHasher)
+	if !ok {
+		hasher = gitBlobHasher{sha256: g.nativeAlgorithm == HashGitSHA256}
+	}
+	return hasher.hashContent(object)
+}
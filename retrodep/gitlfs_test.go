@@ -0,0 +1,113 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestParseLFSPointer(t *testing.T) {
+	oid := strings.Repeat("ab", 32)
+	pointer, ok := parseLFSPointer([]byte(lfsPointerVersion + "\noid sha256:" + oid + "\nsize 12\n"))
+	if !ok || pointer.oid != oid || pointer.size != 12 {
+		t.Errorf("got %v,%t", pointer, ok)
+	}
+	for _, content := range []string{
+		"package a\n",
+		lfsPointerVersion + "\nsize 12\n",
+		lfsPointerVersion + "\noid md5:" + oid + "\nsize 12\n",
+		lfsPointerVersion + "\noid sha256:" + oid + "\nsize -1\n",
+	} {
+		if pointer, ok := parseLFSPointer([]byte(content)); ok {
+			t.Errorf("%q: parsed as %v", content, pointer)
+		}
+	}
+}
+
+func TestGitLFSFileHashes(t *testing.T) {
+	const object = "real content\n"
+	sum := sha256.Sum256([]byte(object))
+	oid := hex.EncodeToString(sum[:])
+	pointer := fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsPointerVersion, oid, len(object))
+
+	var smudged []string
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		var out string
+		switch args[0] {
+		case "grep":
+			if args[1] == "-l" {
+				out = "v1.0.0:.gitattributes\n"
+			} else {
+				out = "v1.0.0:assets/big.bin:1:" + lfsPointerVersion + "\n" +
+					"v1.0.0:assets/notes.txt:3:" + lfsPointerVersion + "\n"
+			}
+		case "cat-file":
+			out = pointer
+		case "lfs":
+			smudged = append(smudged, args[len(args)-1])
+			out = object
+		}
+		return exec.Command("printf", "%s", out)
+	}
+
+	fetch := func(ref, subPath string) (FileHashes, error) {
+		return FileHashes{
+			"a.go":             "1",
+			"assets/big.bin":   "pointer",
+			"assets/notes.txt": "2",
+		}, nil
+	}
+	wt := gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			VCS:             vcs.ByCmd(vcsGit),
+			hasher:          &gitHasher{},
+			algorithm:       HashGitSHA1,
+			nativeAlgorithm: HashGitSHA1,
+		},
+		lfs: true,
+	}
+	h, err := wt.lfsFileHashes(fetch)("v1.0.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := gitBlobHasher{}.hashContent(strings.NewReader(object))
+	if h["assets/big.bin"] != expected || h["assets/notes.txt"] != "2" || h["a.go"] != "1" {
+		t.Errorf("got %v, want assets/big.bin %s", h, expected)
+	}
+	if len(smudged) != 1 || smudged[0] != "assets/big.bin" {
+		t.Errorf("smudged %v", smudged)
+	}
+
+	// SHA-256 hashes are read from the pointer file.
+	smudged = nil
+	wt.hasher = sha256Hasher{}
+	wt.algorithm = HashSHA256
+	h, err = wt.lfsFileHashes(fetch)("v1.0.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h["assets/big.bin"] != FileHash(HashSHA256+":"+oid) || len(smudged) != 0 {
+		t.Errorf("got %v, smudged %v", h, smudged)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// Submodules makes git working trees include the files of their
+// submodules, at the revisions pinned, in the file hashes of each
+// ref, and check out submodules when syncing to a ref. Each
+// submodule is cloned when first needed.
+func Submodules() WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		o.submodules = true
+	}
+}
+
+// gitSubmodules holds the working trees cloned for the submodules of
+// a git working tree, keyed by repository URL.
+type gitSubmodules struct {
+	open func(repo string) (WorkingTree, error)
+
+	mu    sync.Mutex
+	trees map[string]WorkingTree
+	errs  map[string]error
+}
+
+// newGitSubmodules returns a gitSubmodules which clones submodules
+// with the options opts, apart from those which do not clone.
+func newGitSubmodules(ctx context.Context, opts []WorkingTreeOption) *gitSubmodules {
+	opts = append(append([]WorkingTreeOption{}, opts...), func(o *workingTreeOptions) {
+		// Submodules are known by URL, not import path, and
+		// the tag prefix and branch are the superproject's.
+		o.proxy = ""
+		o.forgeAPI = false
+		o.tagPrefix = ""
+		o.branch = ""
+	})
+	return &gitSubmodules{
+		open: func(repo string) (WorkingTree, error) {
+			root := &vcs.RepoRoot{VCS: vcs.ByCmd(vcsGit), Repo: repo, Root: repo}
+			return NewWorkingTreeContext(ctx, root, opts...)
+		},
+		trees: make(map[string]WorkingTree),
+		errs:  make(map[string]error),
+	}
+}
+
+// tree returns the working tree for the submodule repository repo,
+// cloning it the first time.
+func (s *gitSubmodules) tree(repo string) (WorkingTree, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if wt, ok := s.trees[repo]; ok {
+		return wt, nil
+	}
+	if err, ok := s.errs[repo]; ok {
+		return nil, err
+	}
+	wt, err := s.open(repo)
+	if err != nil {
+		s.errs[repo] = err
+		return nil, err
+	}
+	s.trees[repo] = wt
+	return wt, nil
+}
+
+// close removes the working trees of the submodules.
+func (s *gitSubmodules) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for repo, wt := range s.trees {
+		wt.Close()
+		delete(s.trees, repo)
+	}
+}
+
+// submoduleRepo returns the repository URL of a submodule configured
+// with the URL sub in the superproject whose repository is at repo.
+// Relative URLs are resolved as git does, relative to repo.
+func submoduleRepo(repo, sub string) string {
+	if !strings.HasPrefix(sub, "./") && !strings.HasPrefix(sub, "../") {
+		return sub
+	}
+	base, err := url.Parse(strings.TrimSuffix(repo, "/") + "/")
+	if err != nil {
+		return sub
+	}
+	rel, err := url.Parse(sub)
+	if err != nil {
+		return sub
+	}
+	return strings.TrimSuffix(base.ResolveReference(rel).String(), "/")
+}
+
+// addSubmoduleHashes adds to hashes, the file hashes within subPath
+// of a superproject, those of the files of each of its submodules
+// within subPath, from the working trees returned by tree. The
+// entries for the submodules themselves are removed. Submodules
+// which cannot be cloned are left out.
+func addSubmoduleHashes(hashes FileHashes, subPath string, submodules []Submodule, tree func(repo string) (WorkingTree, error)) error {
+	sub := filepath.Clean(subPath)
+	for _, submodule := range submodules {
+		modPath := filepath.FromSlash(submodule.Path)
+		var prefix, modSubPath string
+		switch {
+		case subPath == "":
+			prefix = modPath
+		case strings.HasPrefix(modPath, sub+string(filepath.Separator)):
+			prefix = modPath[len(sub)+1:]
+		case strings.HasPrefix(sub, modPath+string(filepath.Separator)):
+			modSubPath = sub[len(modPath)+1:]
+		case sub == modPath:
+		default:
+			continue
+		}
+		delete(hashes, prefix)
+		if submodule.URL == "" {
+			log.Debugf("%s: no URL for submodule", submodule.Path)
+			continue
+		}
+		wt, err := tree(submodule.URL)
+		if err != nil {
+			log.Warningf("%s: submodule %s: %s", submodule.Path, submodule.URL, err)
+			continue
+		}
+		modHashes, err := wt.FileHashesFromRef(submodule.Rev, modSubPath)
+		if err != nil {
+			return err
+		}
+		for path, fileHash := range modHashes {
+			hashes[filepath.Join(prefix, path)] = fileHash
+		}
+	}
+	return nil
+}
+
+// submoduleFileHashes returns fetch wrapped to add the file hashes of
+// the submodules of each ref.
+func (g *gitWorkingTree) submoduleFileHashes(fetch func(ref, subPath string) (FileHashes, error)) func(ref, subPath string) (FileHashes, error) {
+	return func(ref, subPath string) (FileHashes, error) {
+		hashes, err := fetch(ref, subPath)
+		if err != nil {
+			return nil, err
+		}
+		submodules, err := g.Submodules(ref)
+		if err != nil {
+			return nil, err
+		}
+		for i := range submodules {
+			if submodules[i].URL != "" {
+				submodules[i].URL = submoduleRepo(g.repo, submodules[i].URL)
+			}
+		}
+		if err := addSubmoduleHashes(hashes, subPath, submodules, g.submodules.tree); err != nil {
+			return nil, err
+		}
+		return hashes, nil
+	}
+}
+
+// updateSubmodules checks out the submodules of the revision the
+// working tree is at, if submodules are included.
+func (g *gitWorkingTree) updateSubmodules() error {
+	if g.submodules == nil {
+		return nil
+	}
+	stdout, stderr, err := g.run("submodule", "update", "--init", "--recursive")
+	if err != nil {
+		g.showOutput(stdout, stderr)
+	}
+	return err
+}
+
+// Close removes the local checkout and those of its submodules.
+func (g *gitWorkingTree) Close() error {
+	if g.submodules != nil {
+		g.submodules.close()
+	}
+	return g.anyWorkingTree.Close()
+}
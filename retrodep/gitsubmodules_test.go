@@ -0,0 +1,112 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSubmoduleRepo(t *testing.T) {
+	tcs := []struct {
+		repo, sub, expected string
+	}{
+		{"https://github.com/foo/bar", "https://github.com/eggs/ham", "https://github.com/eggs/ham"},
+		{"https://github.com/foo/bar", "../ham.git", "https://github.com/foo/ham.git"},
+		{"https://github.com/foo/bar/", "./sub", "https://github.com/foo/bar/sub"},
+		{"/srv/git/foo", "../ham", "/srv/git/ham"},
+	}
+	for _, tc := range tcs {
+		if got := submoduleRepo(tc.repo, tc.sub); got != tc.expected {
+			t.Errorf("%s, %s: got %s, want %s", tc.repo, tc.sub, got, tc.expected)
+		}
+	}
+}
+
+// submoduleWorkingTree is a mock WorkingTree whose refs all have the
+// same files.
+type submoduleWorkingTree struct {
+	stubWorkingTree
+	hashes FileHashes
+	refs   []string
+}
+
+func (wt *submoduleWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	wt.refs = append(wt.refs, ref+":"+subPath)
+	return wt.hashes.under(subPath), nil
+}
+
+func TestAddSubmoduleHashes(t *testing.T) {
+	lib := &submoduleWorkingTree{hashes: FileHashes{
+		"lib.go":                          "1",
+		filepath.Join("internal", "i.go"): "2",
+	}}
+	trees := map[string]WorkingTree{"https://example.com/lib": lib}
+	tree := func(repo string) (WorkingTree, error) {
+		if wt, ok := trees[repo]; ok {
+			return wt, nil
+		}
+		return nil, errors.New("cannot clone")
+	}
+	submodules := []Submodule{
+		{Path: "third_party/lib", Rev: "r1", URL: "https://example.com/lib"},
+		{Path: "third_party/gone", Rev: "r2", URL: "https://example.com/gone"},
+		{Path: "elsewhere", Rev: "r3"},
+	}
+
+	hashes := FileHashes{
+		"main.go":                            "m",
+		filepath.Join("third_party", "lib"):  "r1",
+		filepath.Join("third_party", "gone"): "r2",
+		"elsewhere":                          "r3",
+	}
+	if err := addSubmoduleHashes(hashes, "", submodules, tree); err != nil {
+		t.Fatal(err)
+	}
+	expected := FileHashes{
+		"main.go": "m",
+		filepath.Join("third_party", "lib", "lib.go"):           "1",
+		filepath.Join("third_party", "lib", "internal", "i.go"): "2",
+	}
+	if !reflect.DeepEqual(hashes, expected) {
+		t.Errorf("got %v, want %v", hashes, expected)
+	}
+
+	// Submodules within subPath, and subPath within a submodule.
+	hashes = FileHashes{"lib": "r1"}
+	if err := addSubmoduleHashes(hashes, "third_party", submodules, tree); err != nil {
+		t.Fatal(err)
+	}
+	expected = FileHashes{
+		filepath.Join("lib", "lib.go"):           "1",
+		filepath.Join("lib", "internal", "i.go"): "2",
+	}
+	if !reflect.DeepEqual(hashes, expected) {
+		t.Errorf("got %v, want %v", hashes, expected)
+	}
+	hashes = FileHashes{}
+	if err := addSubmoduleHashes(hashes, filepath.Join("third_party", "lib", "internal"), submodules, tree); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hashes, FileHashes{"i.go": "2"}) {
+		t.Errorf("got %v", hashes)
+	}
+	if !reflect.DeepEqual(lib.refs, []string{"r1:", "r1:", "r1:internal"}) {
+		t.Errorf("refs: got %v", lib.refs)
+	}
+}
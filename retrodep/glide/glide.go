@@ -8,7 +8,8 @@ import (
 )
 
 type glideLock struct {
-	Imports []Import `json:"imports"`
+	Imports     []Import `json:"imports"`
+	TestImports []Import `yaml:"testImports"`
 }
 
 type glideConf struct {
@@ -16,7 +17,13 @@ type glideConf struct {
 	Import  []struct {
 		Package string
 		Repo    string `json:"omitempty"`
+		VCS     string `yaml:"vcs"`
 	}
+	TestImport []struct {
+		Package string
+		Repo    string `json:"omitempty"`
+		VCS     string `yaml:"vcs"`
+	} `yaml:"testImport"`
 }
 
 // Import represents an imported package.
@@ -24,6 +31,7 @@ type Import struct {
 	Name    string `json:"name"`
 	Version string `json:"version"`
 	Repo    string `json:"repo"`
+	VCS     string `yaml:"vcs"`
 }
 
 // Glide represents the glide configuration.
@@ -48,7 +56,7 @@ func LoadGlide(projectRoot string) (*Glide, error) {
 		if err != nil {
 			return nil, err
 		}
-		lockImports = lock.Imports
+		lockImports = append(lock.Imports, lock.TestImports...)
 	} else if !os.IsNotExist(err) {
 		return nil, err
 	}
@@ -64,9 +72,24 @@ func LoadGlide(projectRoot string) (*Glide, error) {
 		return nil, err
 	}
 
+	confImports := append(conf.Import, conf.TestImport...)
 	if len(lockImports) == 0 {
-		for _, imp := range conf.Import {
-			lockImports = append(lockImports, Import{Name: imp.Package, Repo: imp.Repo})
+		for _, imp := range confImports {
+			lockImports = append(lockImports, Import{Name: imp.Package, Repo: imp.Repo, VCS: imp.VCS})
+		}
+	} else {
+		// Repository overrides in glide.yaml apply to the
+		// locked imports too.
+		for _, imp := range confImports {
+			if imp.Repo == "" {
+				continue
+			}
+			for i := range lockImports {
+				if lockImports[i].Name == imp.Package && lockImports[i].Repo == "" {
+					lockImports[i].Repo = imp.Repo
+					lockImports[i].VCS = imp.VCS
+				}
+			}
 		}
 	}
 
@@ -15,8 +15,14 @@ func TestGlideFalse(t *testing.T) {
 	if glide.Imports[0].Version != "ca53cad383cad2479bbba7f7a1a05797ec1386e4" {
 		t.Fatalf("expected '%v', got '%v'", "ca53cad383cad2479bbba7f7a1a05797ec1386e4", glide.Imports[0].Version)
 	}
-	if len(glide.Imports) != 2 {
-		t.Fatalf("expected '%v', got '%v'", 2, len(glide.Imports))
+	if len(glide.Imports) != 3 {
+		t.Fatalf("expected '%v', got '%v'", 3, len(glide.Imports))
+	}
+	if glide.Imports[1].Repo != "https://github.com/fork/pflag" || glide.Imports[1].VCS != "git" {
+		t.Fatalf("expected '%v', got '%v'", "https://github.com/fork/pflag", glide.Imports[1].Repo)
+	}
+	if glide.Imports[2].Name != "github.com/stretchr/testify" {
+		t.Fatalf("expected '%v', got '%v'", "github.com/stretchr/testify", glide.Imports[2].Name)
 	}
 	if glide.Package != "github.com/release-engineering/retrodep/testdata/glide" {
 		t.Fatalf("expected '%v', got '%v'", "github.com/release-engineering/retrodep/testdata/glide", glide.Package)
@@ -0,0 +1,64 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+// This file contains built-in knowledge of the golang.org/x
+// repositories, which are vendored by nearly every project.
+
+import (
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+const golangxPrefix = "golang.org/x/"
+const golangxRepoPrefix = "https://go.googlesource.com/"
+const golangxMirrorPrefix = "https://github.com/golang/"
+
+// golangxRepoRoot returns the repository root for an import path
+// under golang.org/x/ without fetching its 'go get' page, or false
+// if it is not under golang.org/x/.
+func golangxRepoRoot(importPath string) (*vcs.RepoRoot, bool) {
+	if !strings.HasPrefix(importPath, golangxPrefix) {
+		return nil, false
+	}
+	name := strings.SplitN(importPath[len(golangxPrefix):], "/", 2)[0]
+	if name == "" {
+		return nil, false
+	}
+	return &vcs.RepoRoot{
+		VCS:  vcs.ByCmd(vcsGit),
+		Repo: golangxRepoPrefix + name,
+		Root: golangxPrefix + name,
+	}, true
+}
+
+// FastMirrors returns mirrors of the repository for root which are
+// usually faster to clone from, and which have the same revisions.
+func FastMirrors(root *vcs.RepoRoot) []*vcs.RepoRoot {
+	if root.VCS == nil || root.VCS.Cmd != vcsGit ||
+		!strings.HasPrefix(root.Repo, golangxRepoPrefix) {
+		return nil
+	}
+	name := root.Repo[len(golangxRepoPrefix):]
+	return []*vcs.RepoRoot{
+		{
+			VCS:  root.VCS,
+			Repo: golangxMirrorPrefix + name,
+			Root: root.Root,
+		},
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestGolangxRepoRoot(t *testing.T) {
+	defer mockRepoRoots()()
+
+	src := &GoSource{}
+	root, err := src.repoRootForImportPath("golang.org/x/net/context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Repo != "https://go.googlesource.com/net" || root.Root != "golang.org/x/net" {
+		t.Errorf("got %s (%s)", root.Repo, root.Root)
+	}
+
+	for _, importPath := range []string{"golang.org/x/", "golang.org/xyz"} {
+		if root, ok := golangxRepoRoot(importPath); ok {
+			t.Errorf("%s: unexpected %v", importPath, root)
+		}
+	}
+}
+
+func TestFastMirrors(t *testing.T) {
+	root, _ := golangxRepoRoot("golang.org/x/sys/unix")
+	mirrors := FastMirrors(root)
+	if len(mirrors) != 1 {
+		t.Fatalf("got %v", mirrors)
+	}
+	if mirrors[0].Repo != "https://github.com/golang/sys" || mirrors[0].Root != "golang.org/x/sys" {
+		t.Errorf("got %s (%s)", mirrors[0].Repo, mirrors[0].Root)
+	}
+
+	other := &vcs.RepoRoot{
+		VCS:  vcs.ByCmd(vcsGit),
+		Repo: "https://github.com/foo/bar",
+		Root: "github.com/foo/bar",
+	}
+	if mirrors := FastMirrors(other); mirrors != nil {
+		t.Errorf("unexpected mirrors %v", mirrors)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+// goModPseudoRE matches the pseudo-versions made by PseudoVersion
+// which Go modules spell differently: those for commits with no
+// reachable tag, and those for commits after a tag which is not a
+// semantic version. It captures the timestamp and revision.
+var goModPseudoRE = regexp.MustCompile(`^(?:v0\.0\.0-0|.+-1)\.([0-9]{14})-([0-9A-Za-z]+)$`)
+
+// GoModVersion returns the version to require ref with in a go.mod
+// file, which is ref.Ver in the form Go modules use. Pseudo-versions
+// for commits with no reachable tag or after a non-semver tag become
+// v0.0.0-timestamp-revision, and versions from major version 2
+// onwards of projects without a major version suffix are marked
+// +incompatible. If ref matched a tag which is not a semantic
+// version the revision is returned, for the go command to replace
+// with a pseudo-version. It returns "" if the version of ref is not
+// known.
+func GoModVersion(ref *Reference) string {
+	if ref.Ver == "" {
+		return ""
+	}
+	if matches := goModPseudoRE.FindStringSubmatch(ref.Ver); matches != nil {
+		return "v0.0.0-" + matches[1] + "-" + matches[2]
+	}
+	v, err := semver.NewVersion(ref.Ver)
+	if err != nil || !strings.HasPrefix(ref.Ver, "v") {
+		return ref.Rev
+	}
+	ver := ref.Ver
+	suffixed := majorSuffixRE.MatchString(ref.Pkg) ||
+		strings.HasPrefix(ref.Pkg, "gopkg.in/")
+	if v.Major() >= 2 && !suffixed && v.Metadata() == "" {
+		ver += "+incompatible"
+	}
+	return ver
+}
+
+// ModuleVersion returns the version of ref, found in wt, as Go
+// modules know it. This is GoModVersion, except that the upstream
+// files within subPath are checked: a version from major version 2
+// onwards of a project without a major version suffix is only
+// +incompatible if it has no go.mod file, as the go command ignores
+// such tags otherwise. Those, and revisions matching tags which are
+// not semantic versions, are given v0.0.0 pseudo-versions.
+func ModuleVersion(ref *Reference, wt WorkingTree, subPath string) (string, error) {
+	ver := GoModVersion(ref)
+	switch {
+	case ver == "":
+		return "", nil
+	case ver == ref.Rev:
+		// Not a semantic version.
+	case strings.HasSuffix(ver, "+incompatible") && !strings.HasSuffix(ref.Ver, "+incompatible"):
+		hashes, err := wt.FileHashesFromRef(ref.Rev, subPath)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := hashes["go.mod"]; !ok {
+			return ver, nil
+		}
+		log.Debugf("%s: %s has a go.mod file", ref.Pkg, ref.Ver)
+	default:
+		return ver, nil
+	}
+
+	t, err := wt.TimeFromRevision(ref.Rev)
+	if err != nil {
+		return "", err
+	}
+	rev := ref.Rev
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	return "v0.0.0-" + t.UTC().Format("20060102150405") + "-" + rev, nil
+}
+
+// WriteGoModRequire writes a go.mod require block for the vendored
+// projects in the report to w, followed by replace directives for
+// those found in forks (see ForkReplacement and ForkPoint). If the report has more than
+// one top-level project, a block is written for each in order of
+// import path. Projects
+// whose version was not identified are listed in comments.
+func (r *Report) WriteGoModRequire(w io.Writer) error {
+	r.sort()
+	var tops []string
+	vendored := make(map[string][]*Reference)
+	for _, ref := range r.Projects {
+		if ref.TopPkg == "" {
+			tops = append(tops, ref.Pkg)
+		} else {
+			vendored[ref.TopPkg] = append(vendored[ref.TopPkg], ref)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	for i, top := range tops {
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		if len(tops) > 1 {
+			fmt.Fprintf(bw, "// %s\n", top)
+		}
+		writeGoModRequire(bw, vendored[top])
+	}
+	return bw.Flush()
+}
+
+// writeGoModRequire writes the require block and replace directives
+// for refs to w.
+func writeGoModRequire(w io.Writer, refs []*Reference) {
+	refs = append([]*Reference(nil), refs...)
+	sort.SliceStable(refs, func(i, j int) bool {
+		return refs[i].Pkg < refs[j].Pkg
+	})
+
+	var unknown, replace []string
+	fmt.Fprintln(w, "require (")
+	for _, ref := range refs {
+		ver := GoModVersion(ref)
+		if ver == "" {
+			unknown = append(unknown, ref.Pkg)
+			continue
+		}
+		comment := ""
+		if ver == ref.Rev {
+			comment = " // " + ref.Ver
+		}
+		if fork := replacement(ref); fork != "" {
+			replace = append(replace, fmt.Sprintf("replace %s => %s %s",
+				ref.Pkg, fork, ver))
+		}
+		fmt.Fprintf(w, "\t%s %s%s\n", ref.Pkg, ver, comment)
+	}
+	for _, pkg := range unknown {
+		fmt.Fprintf(w, "\t// %s: version not identified\n", pkg)
+	}
+	fmt.Fprintln(w, ")")
+
+	if len(replace) > 0 {
+		fmt.Fprintln(w)
+		for _, line := range replace {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
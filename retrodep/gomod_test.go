@@ -0,0 +1,116 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestGoModVersion(t *testing.T) {
+	tcs := []struct {
+		ref Reference
+		exp string
+	}{
+		{Reference{Pkg: "example.com/foo", Ver: "v1.2.0"}, "v1.2.0"},
+		{Reference{Pkg: "example.com/foo", Ver: "v1.2.1-0.20190101000000-0123456789ab"}, "v1.2.1-0.20190101000000-0123456789ab"},
+		{Reference{Pkg: "example.com/foo", Ver: "v1.2.0-rc1.0.20190101000000-0123456789ab"}, "v1.2.0-rc1.0.20190101000000-0123456789ab"},
+		{Reference{Pkg: "example.com/foo", Ver: "v0.0.0-0.20190101000000-0123456789ab"}, "v0.0.0-20190101000000-0123456789ab"},
+		{Reference{Pkg: "example.com/foo", Ver: "release-1-1.20190101000000-0123456789ab"}, "v0.0.0-20190101000000-0123456789ab"},
+		{Reference{Pkg: "example.com/foo", Ver: "v2.1.0"}, "v2.1.0+incompatible"},
+		{Reference{Pkg: "example.com/foo/v2", Ver: "v2.1.0"}, "v2.1.0"},
+		{Reference{Pkg: "gopkg.in/yaml.v2", Ver: "v2.2.1"}, "v2.2.1"},
+		{Reference{Pkg: "example.com/foo", Tag: "release-1", Rev: "0123456789ab", Ver: "release-1"}, "0123456789ab"},
+		{Reference{Pkg: "example.com/foo"}, ""},
+	}
+	for _, tc := range tcs {
+		if ver := GoModVersion(&tc.ref); ver != tc.exp {
+			t.Errorf("%s: got %q, want %q", tc.ref.Ver, ver, tc.exp)
+		}
+	}
+}
+
+// goModWorkingTree has a go.mod file at revisions starting "mod".
+type goModWorkingTree struct{ stubWorkingTree }
+
+func (wt *goModWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	hashes := FileHashes{"foo.go": "1"}
+	if ref[:3] == "mod" {
+		hashes["go.mod"] = "2"
+	}
+	return hashes, nil
+}
+
+func (wt *goModWorkingTree) TimeFromRevision(rev string) (time.Time, error) {
+	return time.Date(2019, 1, 1, 1, 0, 0, 0, time.FixedZone("", 3600)), nil
+}
+
+func TestModuleVersion(t *testing.T) {
+	tcs := []struct {
+		ref Reference
+		exp string
+	}{
+		{Reference{Pkg: "example.com/foo", Rev: "0123456789abcdef", Ver: "v1.2.0"}, "v1.2.0"},
+		{Reference{Pkg: "example.com/foo", Rev: "0123456789abcdef", Ver: "v2.1.0"}, "v2.1.0+incompatible"},
+		{Reference{Pkg: "example.com/foo", Rev: "mod456789abcdef", Ver: "v2.1.0"}, "v0.0.0-20190101000000-mod456789abc"},
+		{Reference{Pkg: "example.com/foo", Rev: "mod456789abcdef", Ver: "v2.1.0+incompatible"}, "v2.1.0+incompatible"},
+		{Reference{Pkg: "example.com/foo/v2", Rev: "mod456789abcdef", Ver: "v2.1.0"}, "v2.1.0"},
+		{Reference{Pkg: "example.com/foo", Tag: "release-1", Rev: "0123456789abcdef", Ver: "release-1"}, "v0.0.0-20190101000000-0123456789ab"},
+		{Reference{Pkg: "example.com/foo"}, ""},
+	}
+	for _, tc := range tcs {
+		ver, err := ModuleVersion(&tc.ref, &goModWorkingTree{}, "")
+		if err != nil {
+			t.Errorf("%s: %s", tc.ref.Ver, err)
+			continue
+		}
+		if ver != tc.exp {
+			t.Errorf("%s: got %q, want %q", tc.ref.Ver, ver, tc.exp)
+		}
+	}
+}
+
+func TestWriteGoModRequire(t *testing.T) {
+	report := NewReport()
+	report.Add(&Reference{Pkg: "example.com/top", Ver: "v1.0.0"})
+	report.Add(&Reference{TopPkg: "example.com/top", Pkg: "example.com/foo", Ver: "v1.2.0"})
+	report.Add(&Reference{TopPkg: "example.com/top", Pkg: "example.com/bar",
+		Repo: "https://github.com/me/bar.git", Ver: "v0.1.0",
+		Fork: &ForkPoint{Upstream: "https://example.com/bar", Commits: 2}})
+	report.Add(&Reference{TopPkg: "example.com/top", Pkg: "example.com/baz"})
+	report.Add(&Reference{TopPkg: "example.com/top", Pkg: "example.com/qux",
+		Repo: "https://github.com/me/qux", Ver: "v1.0.0",
+		Replace: "github.com/me/qux"})
+
+	var buf bytes.Buffer
+	if err := report.WriteGoModRequire(&buf); err != nil {
+		t.Fatal(err)
+	}
+	exp := `require (
+	example.com/bar v0.1.0
+	example.com/foo v1.2.0
+	example.com/qux v1.0.0
+	// example.com/baz: version not identified
+)
+
+replace example.com/bar => github.com/me/bar v0.1.0
+replace example.com/qux => github.com/me/qux v1.0.0
+`
+	if buf.String() != exp {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), exp)
+	}
+}
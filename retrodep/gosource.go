@@ -16,19 +16,24 @@
 package retrodep
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"go/build"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
-	"github.com/op/go-logging"
 	"github.com/pkg/errors"
 	"golang.org/x/tools/go/vcs"
 
+	"github.com/release-engineering/retrodep/v2/retrodep/dep"
 	"github.com/release-engineering/retrodep/v2/retrodep/glide"
 )
 
@@ -49,7 +54,6 @@ type RepoPath struct {
 	Err error
 }
 
-var log = logging.MustGetLogger("retrodep")
 var errorNoImportPathComment = errors.New("no import path comment")
 
 // GoSource represents a filesystem tree containing Go source code.
@@ -72,6 +76,51 @@ type GoSource struct {
 
 	// usesGodep is true if Godeps/Godeps.json is present
 	usesGodep bool
+
+	// usesBazel is true if this is the root of a Bazel workspace
+	usesBazel bool
+
+	// vendorDir is the filepath, relative to Path, holding
+	// vendored projects, or "" for "vendor"
+	vendorDir string
+
+	// hints maps import paths to tags or revisions to try first
+	hints Hints
+
+	// index, if set, finds the refs to try for projects from the
+	// repositories it has indexed
+	index *FingerprintIndex
+
+	// relocations maps old import path prefixes to new ones
+	relocations Relocations
+
+	// hashExcludes maps import path prefixes to paths to leave
+	// out of the local file hashes of projects
+	hashExcludes ProjectHashExcludes
+
+	// forks holds import path prefixes whose repositories are
+	// forks
+	forks []string
+
+	// chooseTag picks among tags matching identically, or is nil
+	// for PreferOldest
+	chooseTag TagChooser
+
+	// resolver finds repositories for import paths, or is nil
+	// for DefaultResolver
+	resolver Resolver
+
+	// claims maps vendored import paths to the revisions claimed
+	// for them by dependency manager manifests
+	claims map[string]*ManifestClaim
+
+	// workspace is the directory of the go.work file listing this
+	// module, or "" if it is not in a Go workspace
+	workspace string
+
+	// requires holds the paths of the modules a workspace module
+	// requires, or is nil if it is not in a Go workspace
+	requires map[string]struct{}
 }
 
 // FindExcludes returns a slice of paths which match the provided
@@ -91,20 +140,37 @@ func FindExcludes(path string, globs []string) ([]string, error) {
 }
 
 // FindGoSources looks for top-level projects at path. If path is itself
-// a top-level project, the returned slice contains a single *GoSource
-// for that project; otherwise immediate sub-directories are tested.
-// Files matching globs in excludeGlobs will not be considered when
-// matching against upstream repositories.
+// a top-level project, the returned slice contains a *GoSource for
+// that project; otherwise immediate sub-directories are tested. If
+// path has a go.work file, there is a *GoSource for each module the
+// workspace uses instead. Go modules with their own vendor
+// directories are also found at any depth, and are excluded from the
+// projects containing them.
+// Files matching globs in excludeGlobs, or listed in the IgnoreFile
+// at path, will not be considered when matching against upstream
+// repositories.
 func FindGoSources(path string, excludeGlobs []string) ([]*GoSource, error) {
 	// Try at the top-level.
 	excludes, err := FindExcludes(path, excludeGlobs)
 	if err != nil {
 		return nil, err
 	}
+	ignored, err := FindIgnored(path)
+	if err != nil {
+		return nil, err
+	}
+	excludes = append(excludes, ignored...)
+	srcs, err := findWorkspace(path, excludes)
+	if err != nil {
+		return nil, err
+	}
+	if srcs != nil {
+		return addNestedModules(path, excludes, srcs)
+	}
 	src, terr := NewGoSource(path, excludes)
 	if terr == nil {
 		log.Debugf("found project at top-level: %s", path)
-		return []*GoSource{src}, nil
+		return addNestedModules(path, excludes, []*GoSource{src})
 	}
 
 	// Convert the exclusions list to absolute paths and make a
@@ -127,7 +193,7 @@ func FindGoSources(path string, excludeGlobs []string) ([]*GoSource, error) {
 
 	// Look in sub-directories.
 	subDirStart := len(abs) + 1
-	srcs := make([]*GoSource, 0)
+	srcs = make([]*GoSource, 0)
 	search := func(p string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -178,6 +244,11 @@ func FindGoSources(path string, excludeGlobs []string) ([]*GoSource, error) {
 		return nil, err
 	}
 
+	srcs, err = addNestedModules(path, excludes, srcs)
+	if err != nil {
+		return nil, err
+	}
+
 	if len(srcs) == 0 {
 		// Return the original error from the top-level check.
 		if _, ok := terr.(*build.NoGoError); ok {
@@ -190,21 +261,123 @@ func FindGoSources(path string, excludeGlobs []string) ([]*GoSource, error) {
 	return srcs, nil
 }
 
+// addNestedModules looks below path for Go modules with their own
+// vendor directories and returns srcs with any not already in it
+// appended. Each module is excluded from the sources containing it.
+func addNestedModules(path string, excludes []string, srcs []*GoSource) ([]*GoSource, error) {
+	excl := make(map[string]struct{})
+	for _, e := range excludes {
+		excl[e] = struct{}{}
+	}
+	found := make(map[string]struct{})
+	for _, src := range srcs {
+		found[src.Path] = struct{}{}
+	}
+
+	search := func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || p == path {
+			return nil
+		}
+		if _, ok := excl[p]; ok {
+			return filepath.SkipDir
+		}
+		switch name := info.Name(); {
+		case name == "vendor", name == "testdata",
+			strings.HasPrefix(name, "."), strings.HasPrefix(name, "_"):
+			return filepath.SkipDir
+		}
+		if _, err := os.Stat(filepath.Join(p, "go.mod")); err != nil {
+			return nil
+		}
+		if st, err := os.Stat(filepath.Join(p, "vendor")); err != nil || !st.IsDir() {
+			return nil
+		}
+
+		if _, ok := found[p]; !ok {
+			src, err := NewGoSource(p, excludes)
+			if err != nil {
+				return err
+			}
+			if err := src.SetSubPath(path); err != nil {
+				return err
+			}
+			log.Debugf("found module in subdir: %s", p)
+			srcs = append(srcs, src)
+			found[p] = struct{}{}
+		}
+		for _, outer := range srcs {
+			if outer.Path != p && pathStartsWith(p, outer.Path) {
+				outer.excludes[p] = struct{}{}
+			}
+		}
+		return nil
+	}
+
+	if err := filepath.Walk(path, search); err != nil {
+		return nil, err
+	}
+	return srcs, nil
+}
+
+// goModuleRE matches the module directive in a go.mod file,
+// capturing the module path.
+var goModuleRE = regexp.MustCompile(`(?m)^\s*module\s+("[^"]+"|\S+)`)
+
+// goModulePath returns the module path from go.mod, or false if
+// there is none.
+func goModulePath(src *GoSource) (string, bool) {
+	conf := filepath.Join(src.Path, "go.mod")
+	if _, skip := src.excludes[conf]; skip {
+		return "", false
+	}
+	data, err := ioutil.ReadFile(conf)
+	if err != nil {
+		return "", false
+	}
+	m := goModuleRE.FindSubmatch(data)
+	if m == nil {
+		return "", false
+	}
+	modulePath := string(m[1])
+	if unquoted, err := strconv.Unquote(modulePath); err == nil {
+		modulePath = unquoted
+	}
+	log.Debugf("import path found from go.mod: %s", modulePath)
+	return modulePath, true
+}
+
 // NewGoSource returns a *GoSource for the given path path. The paths
 // in excludes will not be considered when matching against the
 // upstream repository.
 func NewGoSource(path string, excludes []string) (*GoSource, error) {
 	// There has to be either:
-	// - a 'vendor' subdirectory, or
+	// - a 'vendor' subdirectory (or gb's vendor/src, or godep's
+	//   workspace), or
 	// - some '*.go' files with Go code in
 	// Otherwise there is nothing for us to do.
-	var vendorExists bool
-	st, err := os.Stat(filepath.Join(path, "vendor"))
-	if err == nil {
-		vendorExists = st.IsDir()
+	var vendorDir string
+	var err error
+	vendors := []string{gbVendor, "vendor", godepWorkspace}
+	usesBazel := isBazelWorkspace(path)
+	if usesBazel {
+		vendors = append(vendors, bazelVendors...)
+	}
+	for _, dir := range vendors {
+		var st os.FileInfo
+		st, err = os.Stat(filepath.Join(path, filepath.FromSlash(dir)))
+		if err == nil && st.IsDir() {
+			vendorDir = filepath.FromSlash(dir)
+			break
+		}
+		if err != nil && !os.IsNotExist(err) {
+			break
+		}
 	}
 	switch {
-	case vendorExists:
+	case vendorDir != "":
 		// There is a vendor directory. Nothing else to check.
 	case err == nil || os.IsNotExist(err):
 		// No vendor directory, check for Go source.
@@ -217,23 +390,63 @@ func NewGoSource(path string, excludes []string) (*GoSource, error) {
 		return nil, err
 	}
 
-	excl := make(map[string]struct{})
-	for _, e := range excludes {
-		excl[e] = struct{}{}
-	}
+	return newGoSource(&GoSource{
+		Path:      path,
+		vendorDir: vendorDir,
+		usesBazel: usesBazel,
+	}, excludes)
+}
 
-	src := &GoSource{
-		Path:     path,
-		excludes: excl,
+// newGoSource completes src, whose Path and vendor directory are set,
+// by reading the configuration files of the dependency managers it
+// uses.
+func newGoSource(src *GoSource, excludes []string) (*GoSource, error) {
+	src.excludes = make(map[string]struct{})
+	for _, e := range excludes {
+		src.excludes[e] = struct{}{}
 	}
 
 	// Always read Godeps.json because we need to know whether
 	// godep is in use (if so, files are modified when vendored).
-	err = loadGodepsConf(src)
+	err := loadGodepsConf(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read vendor/vendor.json for the revisions govendor claims.
+	err = loadGovendorConf(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read vendor/modules.txt for the versions 'go mod vendor'
+	// claims.
+	err = loadModulesTxt(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read Gopkg.toml and Gopkg.lock for dep's source overrides
+	// and the revisions it claims.
+	err = loadDepConf(src)
 	if err != nil {
 		return nil, err
 	}
 
+	// Read vendor/manifest for gb's repositories and revisions.
+	err = loadGbConf(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the Bazel workspace files for pinned Go repositories.
+	if src.usesBazel {
+		err = loadBazelConf(src)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Always read glide.yaml because we need to know if there are
 	// replacement repositories.
 	ok, err := loadGlideConf(src)
@@ -242,9 +455,11 @@ func NewGoSource(path string, excludes []string) (*GoSource, error) {
 	}
 
 	if !ok && src.Package == "" {
-		if importPath, err := findImportComment(src); err == nil {
+		if importPath, ok := goModulePath(src); ok {
+			src.Package = importPath
+		} else if importPath, err := findImportComment(src); err == nil {
 			src.Package = importPath
-		} else if importPath, ok := importPathFromFilepath(path); ok {
+		} else if importPath, ok := importPathFromFilepath(src.Path); ok {
 			src.Package = importPath
 		}
 	}
@@ -252,11 +467,25 @@ func NewGoSource(path string, excludes []string) (*GoSource, error) {
 	return src, nil
 }
 
+// godepWorkspace is the directory, relative to the top-level
+// project, where older versions of godep copied dependencies.
+const godepWorkspace = "Godeps/_workspace/src"
+
+// describedTagRE matches 'git describe' output for a commit after a
+// tag, rather than for the tag itself.
+var describedTagRE = regexp.MustCompile(`-[0-9]+-g[0-9a-f]+$`)
+
 // loadGodepsConf parses Godeps/Godeps.json to extract the package
-// name.
+// name and the revisions claimed for vendored packages.
 func loadGodepsConf(src *GoSource) error {
+	type godepsDep struct {
+		ImportPath string
+		Comment    string
+		Rev        string
+	}
 	type godepsConf struct {
 		ImportPath string
+		Deps       []godepsDep
 	}
 	conf := filepath.Join(src.Path, "Godeps", "Godeps.json")
 	if _, skip := src.excludes[conf]; skip {
@@ -281,12 +510,29 @@ func loadGodepsConf(src *GoSource) error {
 
 	src.Package = godeps.ImportPath
 	log.Debugf("import path found from Godeps/Godeps.json: %s", src.Package)
+
+	for _, dep := range godeps.Deps {
+		if dep.ImportPath == "" {
+			continue
+		}
+		claim := &ManifestClaim{
+			File:     "Godeps/Godeps.json",
+			Revision: dep.Rev,
+		}
+		// The comment is from 'git describe --tags', so is
+		// only the tag if the revision is tagged.
+		if !describedTagRE.MatchString(dep.Comment) {
+			claim.Version = dep.Comment
+		}
+		src.addClaim(dep.ImportPath, claim)
+	}
 	return nil
 }
 
 // loadGlideConf parses glide.yaml to extract the package name and the
-// import path repository replacements. It returns true if it parsed
-// successfully.
+// import path repository replacements, and glide.lock for the
+// revisions claimed for vendored projects. It returns true if it
+// parsed successfully.
 func loadGlideConf(src *GoSource) (bool, error) {
 	conf := filepath.Join(src.Path, "glide.yaml")
 	if _, skip := src.excludes[conf]; skip {
@@ -305,7 +551,7 @@ func loadGlideConf(src *GoSource) (bool, error) {
 	log.Debugf("import path found from glide.yaml: %s", src.Package)
 
 	// if there is no vendor folder, the dependencies are flattened
-	_, err = os.Stat(filepath.Join(src.Path, "vendor"))
+	_, err = os.Stat(src.Vendor())
 	if os.IsNotExist(err) {
 		return true, nil
 	}
@@ -313,11 +559,18 @@ func loadGlideConf(src *GoSource) (bool, error) {
 		return false, errors.Wrapf(err, "stat 'vendor' for %s", conf)
 	}
 
-	repoPaths := make(map[string]*RepoPath)
+	if src.repoPaths == nil {
+		src.repoPaths = make(map[string]*RepoPath)
+	}
 	for _, imp := range glide.Imports {
 		theVcs := vcs.ByCmd(vcsGit) // default to git
+		if imp.VCS != "" {
+			if v := vcsByCmd(imp.VCS); v != nil {
+				theVcs = v
+			}
+		}
 		if imp.Repo == "" {
-			root, err := vcs.RepoRootForImportPath(imp.Name, false)
+			root, err := src.resolve(imp.Name)
 			if err != nil {
 				log.Infof("Skipping %v, could not determine repo root: %v", imp.Name, err)
 				continue
@@ -326,7 +579,7 @@ func loadGlideConf(src *GoSource) (bool, error) {
 			theVcs = root.VCS
 		}
 
-		repoPaths[imp.Name] = &RepoPath{
+		src.repoPaths[imp.Name] = &RepoPath{
 			RepoRoot: vcs.RepoRoot{
 				VCS:  theVcs,
 				Repo: imp.Repo,
@@ -334,12 +587,65 @@ func loadGlideConf(src *GoSource) (bool, error) {
 			},
 			Version: imp.Version,
 		}
+		if imp.Version != "" {
+			src.addClaim(imp.Name, &ManifestClaim{
+				File:     "glide.lock",
+				Revision: imp.Version,
+			})
+		}
 	}
 
-	src.repoPaths = repoPaths
 	return true, nil
 }
 
+// loadDepConf parses Gopkg.toml and Gopkg.lock to extract the source
+// overrides and the revisions claimed for vendored projects.
+func loadDepConf(src *GoSource) error {
+	conf := filepath.Join(src.Path, "Gopkg.toml")
+	if _, skip := src.excludes[conf]; skip {
+		return nil
+	}
+
+	manifest, err := dep.LoadDep(src.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for name, source := range manifest.Sources {
+		root := vcs.RepoRoot{
+			VCS:  vcs.ByCmd(vcsGit), // default to git
+			Repo: source,
+			Root: name,
+		}
+		if !strings.Contains(source, "://") && !strings.Contains(source, "@") {
+			// The source is an import path.
+			r, err := src.resolve(source)
+			if err != nil {
+				log.Infof("Skipping source %v for %v, could not determine repo root: %v", source, name, err)
+				continue
+			}
+			root.VCS = r.VCS
+			root.Repo = r.Repo
+		}
+		if src.repoPaths == nil {
+			src.repoPaths = make(map[string]*RepoPath)
+		}
+		src.repoPaths[name] = &RepoPath{RepoRoot: root}
+	}
+
+	for _, project := range manifest.Projects {
+		src.addClaim(project.Name, &ManifestClaim{
+			File:     "Gopkg.lock",
+			Revision: project.Revision,
+			Version:  project.Version,
+		})
+	}
+	return nil
+}
+
 // importPathFromFilepath attempts to use the project directory path to
 // infer its import path.
 func importPathFromFilepath(path string) (string, bool) {
@@ -476,7 +782,10 @@ func (src *GoSource) SetSubPath(root string) error {
 
 // Vendor returns the path to the vendored source code.
 func (src GoSource) Vendor() string {
-	return filepath.Join(src.Path, "vendor")
+	if src.vendorDir == "" {
+		return filepath.Join(src.Path, "vendor")
+	}
+	return filepath.Join(src.Path, src.vendorDir)
 }
 
 // Project returns information about the project's repository, as well
@@ -491,7 +800,7 @@ func (src GoSource) Project(importPath string) (*RepoPath, error) {
 		}
 	}
 
-	repoRoot, err := vcsRepoRootForImportPath(importPath, false)
+	repoRoot, err := src.repoRootForImportPath(importPath)
 	if err != nil {
 		return &RepoPath{
 			RepoRoot: vcs.RepoRoot{Root: importPath},
@@ -538,7 +847,7 @@ func (src GoSource) RepoPathForImportPath(importPath string) (*RepoPath, error)
 	}
 
 	// No replacement found, use the import pth as-is
-	r, err := vcs.RepoRootForImportPath(importPath, false)
+	r, err := src.repoRootForImportPath(importPath)
 	if err != nil {
 		u := strings.Index(importPath, "_")
 		if u == -1 {
@@ -548,7 +857,7 @@ func (src GoSource) RepoPathForImportPath(importPath string) (*RepoPath, error)
 		// gopkg.in/foo/bar.v2/_examples/chat1
 		// because of the underscore. Remove it and try again.
 		importPath = path.Dir(importPath[:u])
-		r2, err2 := vcs.RepoRootForImportPath(importPath, false)
+		r2, err2 := src.repoRootForImportPath(importPath)
 		if err2 != nil {
 			return nil, err // Returning the initial error is intentional
 		}
@@ -562,6 +871,61 @@ func (src GoSource) RepoPathForImportPath(importPath string) (*RepoPath, error)
 // only present in the repository. It returns true if changes were
 // found and false if not.
 func (src GoSource) Diff(project *RepoPath, wt WorkingTree, out io.Writer, dir, ref string) (bool, error) {
+	return src.diff(project, wt, dir, ref, func(refFile, localFile string) (bool, error) {
+		return wt.Diff(out, refFile, localFile)
+	})
+}
+
+// VendoredPatch writes (to out) a patch which makes the files of
+// project at revision ref in wt into its vendored copy, ignoring
+// files which are only present in the repository. It returns true
+// if changes were found and false if not.
+func (src GoSource) VendoredPatch(project *RepoPath, wt WorkingTree, out io.Writer, ref string) (bool, error) {
+	projDir := filepath.Join(src.Vendor(), filepath.FromSlash(project.Root))
+	return src.Patch(project, wt, out, projDir, ref)
+}
+
+// Patch is Diff, but the files are named relative to the top-level
+// project in the a/ and b/ form used by 'git diff', so that the
+// output can be applied there with 'patch -p1'.
+func (src GoSource) Patch(project *RepoPath, wt WorkingTree, out io.Writer, dir, ref string) (bool, error) {
+	return src.diff(project, wt, dir, ref, func(refFile, localFile string) (bool, error) {
+		var buf bytes.Buffer
+		changes, err := wt.Diff(&buf, refFile, localFile)
+		if err != nil || !changes {
+			return changes, err
+		}
+		name, err := filepath.Rel(src.Path, localFile)
+		if err != nil {
+			return false, err
+		}
+		from := "/dev/null"
+		if refFile != "" {
+			from = "a/" + filepath.ToSlash(name)
+		}
+		_, err = io.WriteString(out, relabel(buf.String(), from, "b/"+filepath.ToSlash(name)))
+		return true, err
+	})
+}
+
+// relabel replaces the file names in the header of the 'diff -u'
+// output for a single file.
+func relabel(diff, from, to string) string {
+	lines := strings.SplitAfterN(diff, "\n", 3)
+	if len(lines) < 3 ||
+		!strings.HasPrefix(lines[0], "--- ") ||
+		!strings.HasPrefix(lines[1], "+++ ") {
+		return diff
+	}
+	return "--- " + from + "\n+++ " + to + "\n" + lines[2]
+}
+
+// diff calls each for the files below dir which differ from the
+// repository at revision ref, in path order, with the name of the
+// file in the working tree ("" if it is not there) and that of the
+// local file. It returns true if changes were found and false if
+// not.
+func (src GoSource) diff(project *RepoPath, wt WorkingTree, dir, ref string, each func(refFile, localFile string) (bool, error)) (bool, error) {
 	// Hash the local files.
 	hashes, err := src.hashLocalFiles(wt, project, dir)
 	if err != nil {
@@ -609,7 +973,9 @@ func (src GoSource) Diff(project *RepoPath, wt WorkingTree, out io.Writer, dir,
 	// For files added compared to upstream, write the "diff -u"
 	// output compared to /dev/null.
 	changes := false
-	for _, mismatch := range hashes.Mismatches(refHashes, false) {
+	mismatches := hashes.Mismatches(refHashes, false)
+	sort.Strings(mismatches)
+	for _, mismatch := range mismatches {
 		var refFile string
 
 		// Does the file exist in the working tree?
@@ -617,7 +983,7 @@ func (src GoSource) Diff(project *RepoPath, wt WorkingTree, out io.Writer, dir,
 			refFile = filepath.Join(subPath, mismatch)
 		}
 
-		c, err := wt.Diff(out, refFile, filepath.Join(dir, mismatch))
+		c, err := each(refFile, filepath.Join(dir, mismatch))
 		if err != nil {
 			return changes, err
 		}
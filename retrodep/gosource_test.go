@@ -17,6 +17,8 @@ package retrodep
 
 import (
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -115,6 +117,15 @@ func TestFindGoSources(t *testing.T) {
 				{"testdata/multi/def", "def"},
 			},
 		},
+
+		tcase{
+			name: "modules",
+			path: "testdata/modules",
+			exp: []exp{
+				{"testdata/modules", ""},
+				{"testdata/modules/tools", "tools"},
+			},
+		},
 	}
 	for _, tc := range tcases {
 		srcs, err := FindGoSources(tc.path, nil)
@@ -241,6 +252,8 @@ func TestGlideFalse(t *testing.T) {
 }
 
 func TestGlideTrue(t *testing.T) {
+	defer mockRepoRoots("github.com/pborman/uuid", "github.com/stretchr/testify")()
+
 	src, err := NewGoSource("testdata/glide", nil)
 	if err != nil {
 		t.Fatal(err)
@@ -248,6 +261,21 @@ func TestGlideTrue(t *testing.T) {
 	if src.Package != "github.com/release-engineering/retrodep/testdata/glide" {
 		t.Fatal("usesGodep")
 	}
+
+	pflag, err := src.RepoPathForImportPath("github.com/spf13/pflag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pflag.Repo != "https://github.com/fork/pflag" ||
+		pflag.Version != "583c0c0531f06d5278b7d917446061adc344b5cd" {
+		t.Errorf("github.com/spf13/pflag: got %s (%s)", pflag.Repo, pflag.Version)
+	}
+
+	claims := src.manifestClaims("github.com/pborman/uuid")
+	if len(claims) != 1 || claims[0].File != "glide.lock" ||
+		claims[0].Revision != "ca53cad383cad2479bbba7f7a1a05797ec1386e4" {
+		t.Errorf("unexpected claims %v", claims)
+	}
 }
 
 func TestImportPathFromFilepath(t *testing.T) {
@@ -317,7 +345,7 @@ type mockWorkingTree struct{ stubWorkingTree }
 func (m *mockWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
 	hashes := make(FileHashes)
 	// This is the correct hash for nl.go:
-	hashes["nl.go"] = "4ccdb7b17d6eaf1b51ed56932c020edcf323fd5734ce32d01a2713edeb17f6da"
+	hashes["nl.go"] = "sha256:4ccdb7b17d6eaf1b51ed56932c020edcf323fd5734ce32d01a2713edeb17f6da"
 	return hashes, nil
 }
 
@@ -390,3 +418,139 @@ func TestGoSourceDiff(t *testing.T) {
 		t.Errorf("got %d, expected %d", len(newFiles), len(expected))
 	}
 }
+
+func TestDep(t *testing.T) {
+	defer mockRepoRoots("github.com/fork/ham")()
+
+	src, err := NewGoSource("testdata/dep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ham, err := src.RepoPathForImportPath("github.com/eggs/ham/cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ham.Repo != "https://github.com/fork/ham" || ham.Root != "github.com/eggs/ham" {
+		t.Errorf("github.com/eggs/ham: got %s (%s)", ham.Repo, ham.Root)
+	}
+	spam, err := src.RepoPathForImportPath("example.com/spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spam.Repo != "https://example.org/spam.git" {
+		t.Errorf("example.com/spam: got %s", spam.Repo)
+	}
+
+	hints := src.hintsFor("github.com/foo/bar")
+	exp := []string{"a19ce4aa4d8b5b0e1b0a0c0b3ae0e49a5e1921c2", "v1.2.0"}
+	if !reflect.DeepEqual(hints, exp) {
+		t.Errorf("hints: got %v, want %v", hints, exp)
+	}
+}
+
+func TestGodepWorkspace(t *testing.T) {
+	defer mockRepoRoots("github.com/foo/bar")()
+
+	src, err := NewGoSource("testdata/godepws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !src.usesGodep || src.Package != "example.com/godepws" {
+		t.Errorf("unexpected %v, %s", src.usesGodep, src.Package)
+	}
+	if src.Vendor() != filepath.FromSlash("testdata/godepws/Godeps/_workspace/src") {
+		t.Errorf("Vendor: %s", src.Vendor())
+	}
+
+	vendored, err := src.VendoredProjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vendored) != 1 || vendored["github.com/foo/bar"] == nil {
+		t.Errorf("unexpected vendored projects %v", vendored)
+	}
+	if dir := src.projectDir(&Reference{TopPkg: "example.com/godepws", Pkg: "github.com/foo/bar"}); dir != "Godeps/_workspace/src/github.com/foo/bar" {
+		t.Errorf("projectDir: %s", dir)
+	}
+
+	claims := src.manifestClaims("github.com/foo/bar")
+	if len(claims) != 1 || claims[0].Version != "v1.2.0" {
+		t.Errorf("unexpected claims %v", claims)
+	}
+	claims = src.manifestClaims("github.com/eggs/ham")
+	if len(claims) != 1 || claims[0].Version != "" ||
+		claims[0].Revision != "0123456789abcdef0123456789abcdef01234567" {
+		t.Errorf("unexpected claims %v", claims)
+	}
+}
+
+func TestNestedModules(t *testing.T) {
+	srcs, err := FindGoSources("testdata/modules", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(srcs) != 2 {
+		t.Fatalf("got %d sources", len(srcs))
+	}
+	if srcs[0].Package != "example.com/modules" ||
+		srcs[1].Package != "example.com/modules/tools" {
+		t.Errorf("wrong import paths: %s, %s", srcs[0].Package, srcs[1].Package)
+	}
+	if _, ok := srcs[0].excludes["testdata/modules/tools"]; !ok {
+		t.Errorf("nested module not excluded: %v", srcs[0].excludes)
+	}
+}
+
+func TestGoSourcePatch(t *testing.T) {
+	dir := "testdata/godep"
+	src, err := NewGoSource(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockRepoRoots("example.com/foo/bar")()
+	project, err := src.Project("example.com/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt := &mockWorkingTree{}
+	wt.hasher = &sha256Hasher{}
+
+	writer := &strings.Builder{}
+	changes, err := src.Patch(project, wt, writer, dir, "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changes {
+		t.Error("no changes found")
+	}
+
+	// The files are named relative to the top-level project, in
+	// path order, and none of them are upstream.
+	var headers []string
+	for _, line := range strings.Split(writer.String(), "\n") {
+		if strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "+++ ") {
+			headers = append(headers, line)
+		}
+	}
+	expected := []string{
+		"--- /dev/null", "+++ b/Godeps/Godeps.json",
+		"--- /dev/null", "+++ b/importcomment.go",
+		"--- /dev/null", "+++ b/nonl.go",
+		"--- /dev/null", "+++ b/nonl.txt",
+	}
+	if !reflect.DeepEqual(headers, expected) {
+		t.Errorf("got %q, want %q", headers, expected)
+	}
+}
+
+func TestRelabel(t *testing.T) {
+	diff := "--- /tmp/wt/a.go\t2019-01-01\n+++ /src/a.go\t2019-01-02\n@@ -1 +1 @@\n-a\n+b\n"
+	expected := "--- a/a.go\n+++ b/a.go\n@@ -1 +1 @@\n-a\n+b\n"
+	if got := relabel(diff, "a/a.go", "b/a.go"); got != expected {
+		t.Errorf("got %q, want %q", got, expected)
+	}
+	if got := relabel("Binary files differ\n", "a/a", "b/a"); got != "Binary files differ\n" {
+		t.Errorf("binary: got %q", got)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// govendorFile is the govendor manifest, relative to the top-level
+// project.
+const govendorFile = "vendor/vendor.json"
+
+// loadGovendorConf parses vendor/vendor.json to extract the package
+// name and the revisions claimed for vendored packages.
+func loadGovendorConf(src *GoSource) error {
+	type govendorPackage struct {
+		Path         string
+		Revision     string
+		Version      string
+		VersionExact string
+	}
+	type govendorConf struct {
+		RootPath string
+		Package  []govendorPackage
+	}
+	conf := filepath.Join(src.Path, filepath.FromSlash(govendorFile))
+	if _, skip := src.excludes[conf]; skip {
+		return nil
+	}
+	f, err := os.Open(conf)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	var govendor govendorConf
+	if err := json.NewDecoder(f).Decode(&govendor); err != nil {
+		return errors.Wrapf(err, "decoding %s", conf)
+	}
+
+	if src.Package == "" && govendor.RootPath != "" {
+		src.Package = govendor.RootPath
+		log.Debugf("import path found from %s: %s", govendorFile, src.Package)
+	}
+
+	for _, pkg := range govendor.Package {
+		if pkg.Path == "" {
+			continue
+		}
+		version := pkg.VersionExact
+		if version == "" {
+			version = pkg.Version
+		}
+		src.addClaim(pkg.Path, &ManifestClaim{
+			File:     govendorFile,
+			Revision: pkg.Revision,
+			Version:  version,
+		})
+	}
+	return nil
+}
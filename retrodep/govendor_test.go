@@ -0,0 +1,52 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import "testing"
+
+func TestGovendor(t *testing.T) {
+	src, err := NewGoSource("testdata/govendor", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Package != "github.com/release-engineering/retrodep/govendor" {
+		t.Errorf("wrong import path detected: %s", src.Package)
+	}
+
+	claim := src.claims["github.com/foo/bar"]
+	if claim == nil {
+		t.Fatalf("missing claim: %v", src.claims)
+	}
+	if claim.File != "vendor/vendor.json" ||
+		claim.Revision != "a19ce4aa4d8b5b0e1b0a0c0b3ae0e49a5e1921c2" ||
+		claim.Version != "v1.2.0" {
+		t.Errorf("unexpected claim %+v", claim)
+	}
+	if len(src.claims) != 2 {
+		t.Errorf("unexpected claims %v", src.claims)
+	}
+}
+
+func TestGovendorExcluded(t *testing.T) {
+	src, err := NewGoSource("testdata/govendor",
+		[]string{"testdata/govendor/vendor/vendor.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(src.claims) != 0 {
+		t.Errorf("unexpected claims %v", src.claims)
+	}
+}
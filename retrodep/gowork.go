@@ -0,0 +1,195 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// goWorkFile is the file listing the modules of a Go workspace,
+// which 'go work vendor' vendors into a single vendor directory
+// beside it.
+const goWorkFile = "go.work"
+
+// readModDirectives returns the arguments of each verb directive in
+// a go.mod or go.work file read from r, whether given on a line of
+// its own or in a parenthesized block. Comments are removed and
+// quoted arguments are unquoted.
+func readModDirectives(r io.Reader, verb string) ([][]string, error) {
+	var directives [][]string
+	inBlock := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if inBlock {
+			if fields[0] == ")" {
+				inBlock = false
+				continue
+			}
+		} else {
+			if fields[0] != verb {
+				continue
+			}
+			fields = fields[1:]
+			if len(fields) == 1 && fields[0] == "(" {
+				inBlock = true
+				continue
+			}
+		}
+		for i, field := range fields {
+			if unquoted, err := strconv.Unquote(field); err == nil {
+				fields[i] = unquoted
+			}
+		}
+		if len(fields) > 0 {
+			directives = append(directives, fields)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return directives, nil
+}
+
+// readModFile returns the arguments of each verb directive in the
+// go.mod or go.work file at path.
+func readModFile(path, verb string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	directives, err := readModDirectives(f, verb)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", path)
+	}
+	return directives, nil
+}
+
+// findWorkspace returns a *GoSource for each module used by the Go
+// workspace at path, or nil if there is no go.work file there. The
+// modules share the workspace's vendor directory, and the vendored
+// projects of each are those from the modules its go.mod requires.
+// Modules within other modules are excluded from them.
+func findWorkspace(path string, excludes []string) ([]*GoSource, error) {
+	conf := filepath.Join(path, goWorkFile)
+	for _, e := range excludes {
+		if e == conf {
+			return nil, nil
+		}
+	}
+	uses, err := readModFile(conf, "use")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	log.Debugf("found workspace: %s", path)
+
+	vendor := filepath.Join(path, "vendor")
+	var srcs []*GoSource
+	seen := make(map[string]struct{})
+	for _, use := range uses {
+		dir := filepath.Join(path, filepath.FromSlash(use[0]))
+		if _, ok := seen[dir]; ok {
+			continue
+		}
+		seen[dir] = struct{}{}
+		requires := make(map[string]struct{})
+		reqs, err := readModFile(filepath.Join(dir, "go.mod"), "require")
+		if err != nil {
+			return nil, errors.Wrapf(err, "workspace module %s", use[0])
+		}
+		for _, req := range reqs {
+			requires[req[0]] = struct{}{}
+		}
+		vendorDir, err := filepath.Rel(dir, vendor)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Rel(%q, %q)", dir, vendor)
+		}
+		src, err := newGoSource(&GoSource{
+			Path:      dir,
+			vendorDir: vendorDir,
+			workspace: path,
+			requires:  requires,
+		}, excludes)
+		if err != nil {
+			return nil, err
+		}
+		if dir == path {
+			// The workspace files are not part of the module.
+			src.excludes[conf] = struct{}{}
+			src.excludes[conf+".sum"] = struct{}{}
+		} else if err := src.SetSubPath(path); err != nil {
+			return nil, err
+		}
+		log.Debugf("found workspace module: %s", dir)
+		srcs = append(srcs, src)
+	}
+
+	// List the modules in a stable order, and leave each out of
+	// those containing it.
+	sort.Slice(srcs, func(i, j int) bool {
+		return srcs[i].SubPath < srcs[j].SubPath
+	})
+	for _, inner := range srcs {
+		for _, outer := range srcs {
+			if outer != inner && pathStartsWith(inner.Path, outer.Path) {
+				outer.excludes[inner.Path] = struct{}{}
+			}
+		}
+	}
+	return srcs, nil
+}
+
+// Workspace returns the directory of the go.work file listing this
+// module, or "" if it is not part of a Go workspace.
+func (src GoSource) Workspace() string {
+	return src.workspace
+}
+
+// requiresImportPath returns true if the vendored package or
+// directory at importPath can be from a module required by this
+// one, which it always can be unless this is a workspace module.
+func (src GoSource) requiresImportPath(importPath string) bool {
+	if src.requires == nil {
+		return true
+	}
+	for module := range src.requires {
+		if importPath == module ||
+			strings.HasPrefix(importPath, module+"/") ||
+			strings.HasPrefix(module, importPath+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestReadModDirectives(t *testing.T) {
+	directives, err := readModDirectives(strings.NewReader(`module example.com/foo // comment
+
+require github.com/foo/bar v1.2.0
+require (
+	github.com/eggs/ham v0.1.0 // indirect
+	"github.com/quoted/path" v1.0.0
+
+)
+replace github.com/foo/bar => ../bar
+`), "require")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"github.com/foo/bar", "v1.2.0"},
+		{"github.com/eggs/ham", "v0.1.0"},
+		{"github.com/quoted/path", "v1.0.0"},
+	}
+	if !reflect.DeepEqual(directives, expected) {
+		t.Errorf("got %v, want %v", directives, expected)
+	}
+}
+
+func TestFindWorkspace(t *testing.T) {
+	srcs, err := FindGoSources("testdata/gowork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, src := range srcs {
+		got = append(got, src.SubPath+" "+src.Package)
+		if src.Workspace() != "testdata/gowork" {
+			t.Errorf("%s: Workspace: %q", src.Path, src.Workspace())
+		}
+	}
+	if !reflect.DeepEqual(got, []string{
+		" example.com/gowork",
+		"lib example.com/gowork/lib",
+		"tools example.com/gowork/tools",
+	}) {
+		t.Fatalf("got %v", got)
+	}
+	root, lib, tools := srcs[0], srcs[1], srcs[2]
+	for _, excluded := range []string{"lib", "tools", "go.work"} {
+		if _, ok := root.excludes[filepath.Join("testdata/gowork", excluded)]; !ok {
+			t.Errorf("%s not excluded: %v", excluded, root.excludes)
+		}
+	}
+
+	for _, tc := range []struct {
+		src      *GoSource
+		vendored []string
+		assets   []string
+	}{
+		{root, []string{"github.com/foo/bar"}, nil},
+		{lib, nil, nil},
+		{tools, []string{"github.com/eggs/ham"}, []string{"../vendor/github.com/eggs/notes.txt"}},
+	} {
+		vendored, err := tc.src.VendoredProjects()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var roots []string
+		for root := range vendored {
+			roots = append(roots, root)
+		}
+		sort.Strings(roots)
+		if !reflect.DeepEqual(roots, tc.vendored) {
+			t.Errorf("%s: vendored %v, want %v", tc.src.Package, roots, tc.vendored)
+		}
+
+		assets, err := tc.src.Assets(vendored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, asset := range assets {
+			paths = append(paths, asset.Path)
+		}
+		if !reflect.DeepEqual(paths, tc.assets) {
+			t.Errorf("%s: assets %v, want %v", tc.src.Package, paths, tc.assets)
+		}
+	}
+
+	claims := tools.manifestClaims("github.com/eggs/ham")
+	if len(claims) != 1 || claims[0].Version != "v0.1.0" {
+		t.Errorf("unexpected claims %v", claims)
+	}
+}
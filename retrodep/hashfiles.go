@@ -0,0 +1,97 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// File classes for ParseHashFiles.
+const (
+	// FilesAll is every file, which is the default.
+	FilesAll = "all"
+
+	// FilesGo is Go source files.
+	FilesGo = "go"
+
+	// FilesCgo is the C, C++, Objective-C, Fortran, assembly and
+	// SWIG sources and object files the go command builds into
+	// cgo packages.
+	FilesCgo = "cgo"
+
+	// FilesProto is protocol buffer definitions.
+	FilesProto = "proto"
+)
+
+// fileClasses holds the patterns for the files in each file class
+// except FilesAll.
+var fileClasses = map[string][]string{
+	FilesGo: {"*.go"},
+	FilesCgo: {"*.c", "*.cc", "*.cpp", "*.cxx", "*.h", "*.hh", "*.hpp",
+		"*.hxx", "*.m", "*.f", "*.F", "*.for", "*.f90", "*.s", "*.S",
+		"*.sx", "*.swig", "*.swigcxx", "*.syso"},
+	FilesProto: {"*.proto"},
+}
+
+// hashIncludes are the only paths within projects, both local and
+// upstream, whose file hashes are compared, or nil for all paths.
+var hashIncludes Ignore
+
+// ParseHashFiles returns the patterns for the files given by names,
+// each either a file class (one of the Files... constants) or a
+// pattern in gitignore syntax such as "*.json" or "assets/". It
+// returns nil, for every file, if FilesAll is among them.
+func ParseHashFiles(names []string) (Ignore, error) {
+	var patterns []string
+	for _, name := range names {
+		if name == FilesAll {
+			return nil, nil
+		}
+		if class, ok := fileClasses[name]; ok {
+			patterns = append(patterns, class...)
+		} else {
+			patterns = append(patterns, name)
+		}
+	}
+	return ReadIgnore(strings.NewReader(strings.Join(patterns, "\n")))
+}
+
+// SetHashFiles sets the patterns, from ParseHashFiles, for the only
+// paths within projects to compare, or nil to compare every path.
+// This is for projects whose Go files are the same across several
+// upstream revisions which differ only in assets such as proto files,
+// C sources or embedded files, or whose copies leave such files out.
+// Both sides are compared with only these files, and those matching
+// SetHashExcludes are still left out.
+func SetHashFiles(ig Ignore) {
+	hashIncludes = ig
+}
+
+// including returns h with only the paths matching ig, or h itself
+// if ig is nil.
+func (h FileHashes) including(ig Ignore) FileHashes {
+	if ig == nil {
+		return h
+	}
+	kept := make(FileHashes)
+	for path, fileHash := range h {
+		if ig.MatchPath(filepath.ToSlash(path)) {
+			kept[path] = fileHash
+		}
+	}
+	return kept
+}
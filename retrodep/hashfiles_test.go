@@ -0,0 +1,74 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHashFiles(t *testing.T) {
+	ig, err := ParseHashFiles([]string{FilesGo, FilesProto, "assets/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := FileHashes{
+		"a.go":              "1",
+		"api/a.proto":       "2",
+		"assets/logo.png":   "3",
+		"cgo/a.c":           "4",
+		"README.md":         "5",
+		"docs/assets.md":    "6",
+		"sub/assets/x.json": "7",
+	}
+	expected := FileHashes{
+		"a.go":              "1",
+		"api/a.proto":       "2",
+		"assets/logo.png":   "3",
+		"sub/assets/x.json": "7",
+	}
+	if got := hashes.including(ig); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+
+	ig, err = ParseHashFiles([]string{FilesCgo, FilesAll})
+	if err != nil || ig != nil {
+		t.Errorf("all: got %v, %v", ig, err)
+	}
+	if got := hashes.including(ig); !reflect.DeepEqual(got, hashes) {
+		t.Errorf("all files: got %v", got)
+	}
+}
+
+func TestHashFilesExcluding(t *testing.T) {
+	ig, err := ParseHashFiles([]string{FilesGo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetHashFiles(ig)
+	defer SetHashFiles(nil)
+	ex, err := ParseHashFiles([]string{"*_test.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetHashExcludes(ex)
+	defer SetHashExcludes(nil)
+
+	hashes := FileHashes{"a.go": "1", "a_test.go": "2", "a.c": "3"}
+	if got := hashes.excluding(); !reflect.DeepEqual(got, FileHashes{"a.go": "1"}) {
+		t.Errorf("got %v", got)
+	}
+}
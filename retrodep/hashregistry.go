@@ -0,0 +1,118 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"hash"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// builtinHashes holds the names of the hash algorithms NewHasher
+// knows without registration.
+var builtinHashes = map[string]bool{
+	HashGitSHA1:   true,
+	HashGitSHA256: true,
+	HashSHA256:    true,
+	HashBLAKE3:    true,
+}
+
+var (
+	hashersMu sync.Mutex
+	hashers   = make(map[string]func() Hasher)
+)
+
+// RegisterHasher makes NewHasher, and so SetHashAlgorithm and
+// UseHashAlgorithm, accept algorithm, using newHasher to create its
+// Hasher. The file hashes it returns must be the algorithm name
+// followed by ":" and the hex-encoded hash, as DigestHasher's are.
+// Normalizations can only be applied to a DigestHasher. A nil
+// newHasher removes the registration.
+//
+// It panics if algorithm is built in, or contains "+" or ":".
+func RegisterHasher(algorithm string, newHasher func() Hasher) {
+	if builtinHashes[algorithm] || strings.ContainsAny(algorithm, "+:") {
+		panic("retrodep: cannot register hash algorithm " + algorithm)
+	}
+	hashersMu.Lock()
+	defer hashersMu.Unlock()
+	if newHasher == nil {
+		delete(hashers, algorithm)
+		return
+	}
+	hashers[algorithm] = newHasher
+}
+
+// registeredHasher returns a new Hasher for algorithm if it was
+// registered with RegisterHasher, or else nil.
+func registeredHasher(algorithm string) Hasher {
+	hashersMu.Lock()
+	newHasher := hashers[algorithm]
+	hashersMu.Unlock()
+	if newHasher == nil {
+		return nil
+	}
+	return newHasher()
+}
+
+// HashAlgorithms returns the names of the hash algorithms NewHasher
+// accepts, not counting normalizations, in sorted order.
+func HashAlgorithms() []string {
+	var names []string
+	for name := range builtinHashes {
+		names = append(names, name)
+	}
+	hashersMu.Lock()
+	for name := range hashers {
+		names = append(names, name)
+	}
+	hashersMu.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// DigestHasher returns a Hasher for the named algorithm which hashes
+// the content of files with hash functions from newHash, for use
+// with RegisterHasher.
+func DigestHasher(algorithm string, newHash func() hash.Hash) Hasher {
+	return &digestHasher{algorithm: algorithm, newHash: newHash}
+}
+
+// digestHasher is the Hasher returned by DigestHasher.
+type digestHasher struct {
+	algorithm string
+	newHash   func() hash.Hash
+}
+
+// Hash implements the Hasher interface by hashing the file's content.
+func (h *digestHasher) Hash(relativePath, absPath string) (FileHash, error) {
+	return hashFile(h, absPath)
+}
+
+func (h *digestHasher) hashContent(r io.Reader) (FileHash, error) {
+	return digestContent(h.algorithm, h.newHash(), r)
+}
+
+// UseHashAlgorithm makes working trees compute file hashes with the
+// named algorithm, as though SetHashAlgorithm were called on each
+// once it is created.
+func UseHashAlgorithm(algorithm string) WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		o.algorithm = algorithm
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"crypto/md5"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestRegisterHasher(t *testing.T) {
+	if _, err := NewHasher("md5"); err != ErrorUnknownHash {
+		t.Fatalf("md5 known before registration: %v", err)
+	}
+	RegisterHasher("md5", func() Hasher {
+		return DigestHasher("md5", func() hash.Hash { return md5.New() })
+	})
+	defer RegisterHasher("md5", nil)
+
+	dir, err := ioutil.TempDir("", "retrodep-hashregistry.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a")
+	if err := ioutil.WriteFile(path, []byte("a\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for algorithm, exp := range map[string]FileHash{
+		"md5":      "md5:933222b19ff3e7ea5f65517ea1f7d57e",
+		"md5+crlf": "md5+crlf:60b725f10c9c85c70d97880dfe8191b3",
+	} {
+		h, err := NewHasher(algorithm)
+		if err != nil {
+			t.Errorf("%s: %s", algorithm, err)
+			continue
+		}
+		got, err := h.Hash("a", path)
+		if err != nil {
+			t.Errorf("%s: %s", algorithm, err)
+			continue
+		}
+		if got != exp {
+			t.Errorf("%s: got %s, want %s", algorithm, got, exp)
+		}
+	}
+
+	found := false
+	for _, name := range HashAlgorithms() {
+		if name == "md5" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("md5 not in %v", HashAlgorithms())
+	}
+
+	// Only content hashers can be normalized.
+	RegisterHasher("opaque", func() Hasher { return &countingHasher{} })
+	defer RegisterHasher("opaque", nil)
+	if _, err := NewHasher("opaque+crlf"); err != ErrorUnknownHash {
+		t.Errorf("opaque+crlf: got %v", err)
+	}
+}
+
+func TestRegisterHasherBuiltin(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic")
+		}
+	}()
+	RegisterHasher(HashSHA256, func() Hasher { return &sha256Hasher{} })
+}
+
+func TestUseHashAlgorithm(t *testing.T) {
+	RegisterWorkingTreeFactory("stub", func(ctx context.Context, project *vcs.RepoRoot, dir string) (WorkingTree, error) {
+		return &stubWorkingTree{anyWorkingTree{
+			Dir:       dir,
+			VCS:       project.VCS,
+			hasher:    &sha256Hasher{},
+			algorithm: HashSHA256,
+		}}, nil
+	})
+	defer RegisterWorkingTreeFactory("stub", nil)
+
+	root := &vcs.RepoRoot{VCS: vcsByCmd("stub"), Repo: "stub", Root: "stub"}
+	wt, err := NewWorkingTree(root, UseHashAlgorithm(HashBLAKE3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wt.Close()
+	if algorithm := wt.HashAlgorithm(); algorithm != HashBLAKE3 {
+		t.Errorf("got %s", algorithm)
+	}
+
+	if _, err := NewWorkingTree(root, UseHashAlgorithm("unknown")); err != ErrorUnknownHash {
+		t.Errorf("got %v", err)
+	}
+}
@@ -16,11 +16,14 @@
 package retrodep
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -32,6 +35,9 @@ import (
 
 type hgWorkingTree struct {
 	anyWorkingTree
+
+	// server runs read-only commands, if not nil.
+	server *hgCmdServer
 }
 
 type hgLogEntry struct {
@@ -44,6 +50,34 @@ type hgLogs struct {
 	LogEntries []hgLogEntry `xml:"logentry"`
 }
 
+// WithContext implements the ContextWorkingTree interface.
+func (h *hgWorkingTree) WithContext(ctx context.Context) WorkingTree {
+	c := *h
+	c.ctx = ctx
+	return &c
+}
+
+// Close stops the command server, if any, and removes the working
+// tree.
+func (h *hgWorkingTree) Close() error {
+	if h.server != nil {
+		h.server.Close()
+	}
+	return h.anyWorkingTree.Close()
+}
+
+// query runs the read-only hg command args with the command server,
+// if there is one, falling back to running it as run does.
+func (h *hgWorkingTree) query(args ...string) (*bytes.Buffer, *bytes.Buffer, error) {
+	if h.server != nil {
+		stdout, stderr, err := h.server.run(h.context(), args)
+		if err != errHgServerUnavailable {
+			return stdout, stderr, err
+		}
+	}
+	return h.run(args...)
+}
+
 /// log runs 'hg log --template xml', with the additional args if args
 /// is not nil, and returns the log entries. If expect is not 0, an
 /// error is returned if the number of log entries is different.
@@ -52,7 +86,7 @@ func (h *hgWorkingTree) log(args []string, expect int) ([]hgLogEntry, error) {
 	if args != nil {
 		logArgs = append(logArgs, args...)
 	}
-	stdout, stderr, err := h.run(logArgs...)
+	stdout, stderr, err := h.query(logArgs...)
 	if err != nil {
 		h.showOutput(stdout, stderr)
 		return nil, err
@@ -71,9 +105,15 @@ func (h *hgWorkingTree) log(args []string, expect int) ([]hgLogEntry, error) {
 	return entries, nil
 }
 
-// Revisions returns all revisions in the hg repository, using 'hg log'.
+// Revisions returns all revisions in the hg repository, or those
+// reachable from the Branch, within any RevisionLimits, using 'hg
+// log'.
 func (h *hgWorkingTree) Revisions() ([]string, error) {
-	entries, err := h.log(nil, 0)
+	args := h.limits.hgArgs()
+	if h.branch != "" {
+		args = append(args, "-r", "reverse(ancestors("+hgBranch(h.branch)+"))")
+	}
+	entries, err := h.log(args, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -102,7 +142,7 @@ func (h *hgWorkingTree) RevSync(rev string) error {
 }
 
 // TimeFromRevision returns the commit timestamp for the revision
-// rev, using 'hg log -r ...'.
+// rev, in UTC, using 'hg log -r ...'.
 func (h *hgWorkingTree) TimeFromRevision(rev string) (time.Time, error) {
 	var t time.Time
 	entries, err := h.log([]string{"-r", rev}, 1)
@@ -110,19 +150,39 @@ func (h *hgWorkingTree) TimeFromRevision(rev string) (time.Time, error) {
 		return t, err
 	}
 	err = t.UnmarshalText(entries[0].Date)
-	return t, err
+	return t.UTC(), err
 }
 
 // ReachableTag returns the most recent reachable semver tag, using hg
-// log -r "ancestors(...) & tag(r're:...')". It fails with
-// ErrorVersionNotFound if no suitable tag is found.
+// log -r "ancestors(...) & tag(r're:...')". With a Branch, only tags
+// on that branch are found, and only those the TagMatch accepts are
+// considered. It fails with ErrorVersionNotFound if no suitable tag
+// is found.
 func (h *hgWorkingTree) ReachableTag(rev string) (string, error) {
-	// Find up to 10 reachable tags from the revision that might be semver tags
-	revset := "ancestors(" + rev + ") & tag(r're:v?[0-9]')"
-	entries, err := h.log([]string{"-r", revset, "--limit", "10"}, 0)
+	// Find up to 10 reachable tags from the revision that might be
+	// semver tags, or all of them to choose from with a TagMatch
+	tags := "tag(r're:v?[0-9]')"
+	if len(h.tagMatch.Match) > 0 {
+		tags = "tag()"
+	}
+	revset := "ancestors(" + rev + ") & " + tags
+	if h.branch != "" {
+		revset += " & " + hgBranch(h.branch)
+	}
+	args := []string{"-r", revset}
+	if !h.tagMatch.filters() {
+		args = append(args, "--limit", "10")
+	}
+	all, err := h.log(args, 0)
 	if err != nil {
 		return "", err
 	}
+	var entries []hgLogEntry
+	for _, entry := range all {
+		if h.tagMatch.matches(entry.Tag) {
+			entries = append(entries, entry)
+		}
+	}
 
 	if len(entries) == 0 {
 		return "", ErrorVersionNotFound
@@ -141,9 +201,25 @@ func (h *hgWorkingTree) ReachableTag(rev string) (string, error) {
 }
 
 // FileHashesFromRef returns the file hashes for the given tag or
-// revision ref.
+// revision ref, from the local or shared cache if possible.
 func (h *hgWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
-	dir, err := ioutil.TempDir("", "retrodep.")
+	return h.cachedFileHashes(ref, subPath,
+		h.remoteFileHashes(h.fileHashesFromRef, h.revisionID))
+}
+
+// revisionID returns the node ID of the tag or revision ref.
+func (h *hgWorkingTree) revisionID(ref string) (string, error) {
+	entries, err := h.log([]string{"-r", ref}, 1)
+	if err != nil {
+		return "", err
+	}
+	return entries[0].Node, nil
+}
+
+// fileHashesFromRef returns the file hashes for the given tag or
+// revision ref, using 'hg archive'.
+func (h *hgWorkingTree) fileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	dir, err := h.env.tempDir("retrodep.")
 	if err != nil {
 		return nil, errors.Wrapf(err, "FileHashesFromRef(%s)", ref)
 	}
@@ -159,5 +235,134 @@ func (h *hgWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, erro
 		h.showOutput(stdout, stderr)
 		return nil, err
 	}
-	return NewFileHashes(&sha256Hasher{}, filepath.Join(dir, subPath), nil)
+	return newFileHashes(h.env, h.hasher, filepath.Join(dir, subPath), nil)
+}
+
+type hgGrepText struct {
+	Text string `json:"text"`
+}
+type hgGrepEntry struct {
+	Path   string       `json:"path"`
+	LineNo int          `json:"lineno"`
+	Texts  []hgGrepText `json:"texts"`
+}
+
+// Grep returns the lines matching the regular expression pattern in
+// files at ref, using 'hg grep -r ... --template json'.
+func (h *hgWorkingTree) Grep(ref, pattern, subPath string) ([]GrepMatch, error) {
+	args := []string{"grep", "--encoding", "utf-8", "-r", ref,
+		"--line-number", "--template", "json", "--", pattern}
+	if subPath != "" {
+		args = append(args, "path:"+subPath)
+	}
+	stdout, stderr, err := h.query(args...)
+	if err != nil {
+		if exitedWith(err, 1) && stderr.Len() == 0 {
+			// No lines matched
+			return nil, nil
+		}
+		h.showOutput(stdout, stderr)
+		return nil, err
+	}
+
+	var entries []hgGrepEntry
+	if err := json.Unmarshal(stdout.Bytes(), &entries); err != nil {
+		return nil, errors.Wrap(err, "Grep")
+	}
+	matches := make([]GrepMatch, 0, len(entries))
+	for _, entry := range entries {
+		path := entry.Path
+		if subPath != "" {
+			path, err = filepath.Rel(subPath, path)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Rel(%q, %q)",
+					subPath, entry.Path)
+			}
+		}
+		var text strings.Builder
+		for _, t := range entry.Texts {
+			text.WriteString(t.Text)
+		}
+		matches = append(matches, GrepMatch{
+			Path: path,
+			Line: entry.LineNo,
+			Text: text.String(),
+		})
+	}
+	return matches, nil
+}
+
+// hgChangeLogTemplate is the 'hg log' template for parseChangeLog.
+const hgChangeLogTemplate = changeLogPrefix + "{node}\n" +
+	"{file_adds % 'A\t{file}\n'}" +
+	"{file_mods % 'M\t{file}\n'}" +
+	"{file_dels % 'D\t{file}\n'}"
+
+// LastRevisions returns the revision which last changed each file
+// at ref, using 'hg log -r "reverse(::...)" ...'.
+func (h *hgWorkingTree) LastRevisions(ref, subPath string) (map[string]string, error) {
+	args := []string{"log", "--encoding", "utf-8", "-r", "reverse(::" + ref + ")",
+		"--template", hgChangeLogTemplate}
+	if subPath != "" {
+		args = append(args, "path:"+subPath)
+	}
+	stdout, stderr, err := h.query(args...)
+	if err != nil {
+		h.showOutput(stdout, stderr)
+		return nil, err
+	}
+	return parseChangeLog(stdout, subPath)
+}
+
+// Submodules returns the subrepositories of ref, using 'hg cat' to
+// read the revisions pinned in .hgsubstate and their sources in
+// .hgsub.
+func (h *hgWorkingTree) Submodules(ref string) ([]Submodule, error) {
+	stdout, stderr, err := h.query("cat", "-r", ref, ".hgsubstate")
+	if err != nil {
+		if exitedWith(err, 1) {
+			// No subrepositories
+			return nil, nil
+		}
+		h.showOutput(stdout, stderr)
+		return nil, err
+	}
+	var submodules []Submodule
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		// <node> SP <path>
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		submodules = append(submodules, Submodule{
+			Path: fields[1],
+			Rev:  fields[0],
+		})
+	}
+
+	stdout, _, err = h.query("cat", "-r", ref, ".hgsub")
+	sources := make(map[string]string)
+	if err == nil {
+		for _, line := range strings.Split(stdout.String(), "\n") {
+			// <path> = [<kind>]<source>
+			fields := strings.SplitN(line, "=", 2)
+			if len(fields) != 2 {
+				continue
+			}
+			source := strings.TrimSpace(fields[1])
+			if strings.HasPrefix(source, "[") {
+				if end := strings.IndexByte(source, ']'); end != -1 {
+					source = source[end+1:]
+				}
+			}
+			sources[strings.TrimSpace(fields[0])] = source
+		}
+	}
+	for i := range submodules {
+		submodules[i].URL = sources[submodules[i].Path]
+	}
+	sort.Slice(submodules, func(i, j int) bool {
+		return submodules[i].Path < submodules[j].Path
+	})
+	return submodules, nil
 }
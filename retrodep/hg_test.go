@@ -17,6 +17,7 @@ package retrodep
 
 import (
 	"os/exec"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -151,7 +152,7 @@ func TestHgTimeFromRevision(t *testing.T) {
 		<logentry revision="1" node="d4c3dbfa77a74ae238e401d5d2197b45f30d8513">
 		<tag>tip</tag>
 		<author email="example@example.com">Example</author>
-		<date>2018-09-20T12:00:00+00:00</date>
+		<date>2018-09-20T14:00:00+02:00</date>
 		<msg xml:space="preserve">example</msg>
 		</logentry>
 		</log>
@@ -166,6 +167,9 @@ func TestHgTimeFromRevision(t *testing.T) {
 	if !tm.Equal(expected) {
 		t.Errorf("unexpected time: got %s, want %s", tm, expected)
 	}
+	if tm.Location() != time.UTC {
+		t.Errorf("time not in UTC: %s", tm)
+	}
 }
 
 func TestHgReachableTag(t *testing.T) {
@@ -252,6 +256,46 @@ func TestHgReachableTag(t *testing.T) {
 	}
 }
 
+func TestHgGrep(t *testing.T) {
+	defer mockExecCommand()()
+
+	wt := hgWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			Dir: "",
+			VCS: vcs.ByCmd(vcsHg),
+		},
+	}
+
+	mockedStdout = `[
+ {"lineno": 3, "node": "d4c3dbfa77a7", "path": "sub/version.go", "rev": 1,
+  "texts": [{"matched": false, "text": "const "}, {"matched": true, "text": "Version"}, {"matched": false, "text": " = \"1.0.0\""}]}
+]
+`
+	matches, err := wt.Grep("v1.0.0", "Version", "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []GrepMatch{
+		{Path: "version.go", Line: 3, Text: `const Version = "1.0.0"`},
+	}
+	if !reflect.DeepEqual(matches, expected) {
+		t.Errorf("got %v, want %v", matches, expected)
+	}
+
+	mockedStdout = "["
+	if _, err := wt.Grep("v1.0.0", "Version", ""); err == nil {
+		t.Error("invalid output not reported as error")
+	}
+
+	// No matches
+	mockedStdout = ""
+	mockedExitStatus = 1
+	matches, err = wt.Grep("v1.0.0", "Version", "")
+	if err != nil || len(matches) != 0 {
+		t.Errorf("no matches: got %v, %v", matches, err)
+	}
+}
+
 func TestHgErrors(t *testing.T) {
 	defer mockExecCommand()()
 
@@ -285,4 +329,42 @@ func TestHgErrors(t *testing.T) {
 	if _, ok := err.(*exec.ExitError); !ok {
 		t.Error("FileHashesFromRef: hg failure was not reported")
 	}
+	_, err = wt.LastRevisions("012345", "")
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Error("LastRevisions: hg failure was not reported")
+	}
+	_, err = wt.Grep("012345", "x", "")
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Error("Grep: hg failure was not reported")
+	}
+}
+
+func TestHgSubmodules(t *testing.T) {
+	defer mockExecCommand()()
+
+	wt := hgWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			Dir: "",
+			VCS: vcs.ByCmd(vcsHg),
+		},
+	}
+
+	mockedStdout = "d4c3dbfa77a74ae238e401d5d2197b45f30d8513 sub/zlib\n"
+	submodules, err := wt.Submodules("012345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Submodule{
+		{Path: "sub/zlib", Rev: "d4c3dbfa77a74ae238e401d5d2197b45f30d8513"},
+	}
+	if !reflect.DeepEqual(submodules, expected) {
+		t.Errorf("got %v, want %v", submodules, expected)
+	}
+
+	mockedStdout = ""
+	mockedExitStatus = 1
+	submodules, err = wt.Submodules("012345")
+	if err != nil || submodules != nil {
+		t.Errorf("no .hgsubstate: got %v, %v", submodules, err)
+	}
 }
@@ -0,0 +1,254 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// This file contains a client for the Mercurial command server, which
+// runs hg commands in one long-lived process so that hg's start-up
+// cost is paid once per working tree rather than once per command.
+
+// errHgServerUnavailable is returned by hgCmdServer.run when the
+// command could not be run by the command server, and should be run
+// in a process of its own instead.
+var errHgServerUnavailable = errors.New("hg command server unavailable")
+
+// hgExitError is the error for an hg command run by the command
+// server which failed.
+type hgExitError struct {
+	code int
+}
+
+func (e *hgExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// hgCmdServer runs hg commands in the repository in dir using 'hg
+// serve --cmdserver pipe', started when first needed. It is safe for
+// concurrent use; commands are run one at a time.
+type hgCmdServer struct {
+	env *scanEnv
+	dir string
+
+	mu     sync.Mutex
+	p      *exec.Cmd
+	in     io.WriteCloser
+	out    *bufio.Reader
+	failed bool
+}
+
+func newHgCmdServer(env *scanEnv, dir string) *hgCmdServer {
+	return &hgCmdServer{env: env, dir: dir}
+}
+
+// start starts the command server and reads its hello message.
+func (s *hgCmdServer) start() error {
+	p := s.env.command(vcsHg, "serve", "--cmdserver", "pipe",
+		"--config", "ui.interactive=False")
+	p.Dir = s.dir
+	if p.Env == nil {
+		p.Env = commandEnv()
+	}
+	in, err := p.StdinPipe()
+	if err != nil {
+		return err
+	}
+	out, err := p.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := p.Start(); err != nil {
+		return err
+	}
+	s.p, s.in, s.out = p, in, bufio.NewReader(out)
+	channel, hello, err := s.readChannel()
+	if err == nil && (channel != 'o' || !hgServerCapable(hello)) {
+		err = fmt.Errorf("unexpected hello message: %q", hello)
+	}
+	if err != nil {
+		s.stop()
+		return err
+	}
+	return nil
+}
+
+// hgServerCapable returns true if the hello message from a command
+// server lists the runcommand capability.
+func hgServerCapable(hello []byte) bool {
+	for _, line := range strings.Split(string(hello), "\n") {
+		if strings.HasPrefix(line, "capabilities:") {
+			for _, capability := range strings.Fields(line[len("capabilities:"):]) {
+				if capability == "runcommand" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// stop ends the command server, if it is running.
+func (s *hgCmdServer) stop() {
+	if s.p == nil {
+		return
+	}
+	// The server exits when its input is closed.
+	s.in.Close()
+	if err := s.p.Wait(); err != nil {
+		s.env.log(SubsystemVCS).Debugf("%s: hg command server: %s", s.dir, err)
+	}
+	s.p, s.in, s.out = nil, nil, nil
+}
+
+// Close ends the command server, and no more commands will be run by
+// it.
+func (s *hgCmdServer) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed = true
+	s.stop()
+}
+
+// readChannel reads a message from the command server, returning the
+// channel it is for and its data. For the input channels, 'I' and
+// 'L', there is no data and the length requested is returned in its
+// place.
+func (s *hgCmdServer) readChannel() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(s.out, header[:]); err != nil {
+		return 0, nil, err
+	}
+	channel, length := header[0], binary.BigEndian.Uint32(header[1:])
+	if channel == 'I' || channel == 'L' {
+		return channel, header[1:], nil
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(s.out, data); err != nil {
+		return 0, nil, err
+	}
+	return channel, data, nil
+}
+
+// runCommand sends args to the command server to run, and collects
+// the output until the result arrives.
+func (s *hgCmdServer) runCommand(args []string) (*bytes.Buffer, *bytes.Buffer, error) {
+	arguments := strings.Join(args, "\x00")
+	request := make([]byte, 0, len("runcommand\n")+4+len(arguments))
+	request = append(request, "runcommand\n"...)
+	request = binary.BigEndian.AppendUint32(request, uint32(len(arguments)))
+	request = append(request, arguments...)
+	if _, err := s.in.Write(request); err != nil {
+		return nil, nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	for {
+		channel, data, err := s.readChannel()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch channel {
+		case 'o':
+			stdout.Write(data)
+		case 'e':
+			stderr.Write(data)
+		case 'r':
+			if len(data) != 4 {
+				return nil, nil, fmt.Errorf("unexpected result: %q", data)
+			}
+			if code := int32(binary.BigEndian.Uint32(data)); code != 0 {
+				return &stdout, &stderr, &hgExitError{code: int(code)}
+			}
+			return &stdout, &stderr, nil
+		case 'I', 'L':
+			// There is no input: send an empty block.
+			if _, err := s.in.Write(make([]byte, 4)); err != nil {
+				return nil, nil, err
+			}
+		default:
+			if channel >= 'A' && channel <= 'Z' {
+				return nil, nil, fmt.Errorf("unexpected channel %q", channel)
+			}
+			// Other lower-case channels, such as 'd' for
+			// debugging output, can be ignored.
+		}
+	}
+}
+
+// run runs the hg command args with the command server, starting it
+// if needed, and returns stdout and stderr. The server is killed if
+// ctx is done first. It returns errHgServerUnavailable if the server
+// cannot be used, now or from now on.
+func (s *hgCmdServer) run(ctx context.Context, args []string) (*bytes.Buffer, *bytes.Buffer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed {
+		return nil, nil, errHgServerUnavailable
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	log := s.env.log(SubsystemVCS)
+	if s.out == nil {
+		if err := s.start(); err != nil {
+			log.Debugf("%s: hg command server: %s, running commands separately", s.dir, err)
+			s.failed = true
+			return nil, nil, errHgServerUnavailable
+		}
+	}
+
+	done := make(chan struct{})
+	if ctx.Done() != nil {
+		p := s.p
+		go func() {
+			select {
+			case <-ctx.Done():
+				if p != nil && p.Process != nil {
+					p.Process.Kill()
+				}
+			case <-done:
+			}
+		}()
+	}
+	stdout, stderr, err := s.runCommand(args)
+	close(done)
+	if err == nil {
+		return stdout, stderr, nil
+	}
+	if _, ok := err.(*hgExitError); ok {
+		return stdout, stderr, err
+	}
+
+	// The server is no longer usable.
+	s.failed = true
+	s.stop()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, nil, ctxErr
+	}
+	log.Debugf("%s: hg command server: %s, running commands separately", s.dir, err)
+	return nil, nil, errHgServerUnavailable
+}
@@ -0,0 +1,138 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// fakeHgServer serves runcommand requests read from r, writing
+// responses to w: "cat" asks for input and then fails, and anything
+// else echoes its arguments to the output channel.
+func fakeHgServer(t *testing.T, r io.Reader, w io.Writer) {
+	send := func(channel byte, data string) {
+		msg := []byte{channel}
+		msg = binary.BigEndian.AppendUint32(msg, uint32(len(data)))
+		w.Write(append(msg, data...))
+	}
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if line != "runcommand\n" {
+			t.Errorf("unexpected request %q", line)
+			return
+		}
+		var length [4]byte
+		io.ReadFull(br, length[:])
+		args := make([]byte, binary.BigEndian.Uint32(length[:]))
+		io.ReadFull(br, args)
+		send('d', "debugging")
+		if strings.HasPrefix(string(args), "cat\x00") {
+			w.Write([]byte{'I', 0, 0, 16, 0})
+			io.ReadFull(br, length[:])
+			send('e', "no input\n")
+			send('r', "\x00\x00\x00\x01")
+			continue
+		}
+		send('o', strings.Replace(string(args), "\x00", " ", -1))
+		send('r', "\x00\x00\x00\x00")
+	}
+}
+
+func TestHgCmdServer(t *testing.T) {
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	defer reqW.Close()
+	go fakeHgServer(t, reqR, respW)
+
+	s := newHgCmdServer(nil, "")
+	s.in, s.out = reqW, bufio.NewReader(respR)
+	stdout, _, err := s.run(context.Background(), []string{"log", "-r", "tip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "log -r tip" {
+		t.Errorf("stdout: got %q", stdout.String())
+	}
+
+	_, stderr, err := s.run(context.Background(), []string{"cat", "-r", "tip", "x"})
+	if !exitedWith(err, 1) || stderr.String() != "no input\n" {
+		t.Errorf("cat: got %v, %q", err, stderr.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := s.run(ctx, []string{"log"}); err != context.Canceled {
+		t.Errorf("cancelled: got %v", err)
+	}
+
+	s.Close()
+	if _, _, err := s.run(context.Background(), []string{"log"}); err != errHgServerUnavailable {
+		t.Errorf("closed: got %v", err)
+	}
+}
+
+func TestHgServerCapable(t *testing.T) {
+	for hello, capable := range map[string]bool{
+		"capabilities: getencoding runcommand\nencoding: UTF-8\npid: 1": true,
+		"capabilities: getencoding\nencoding: UTF-8":                    false,
+		"encoding: runcommand":                                          false,
+	} {
+		if got := hgServerCapable([]byte(hello)); got != capable {
+			t.Errorf("%q: got %v", hello, got)
+		}
+	}
+}
+
+func TestHgCmdServerFallback(t *testing.T) {
+	defer mockExecCommand()()
+
+	// The mocked 'hg serve' gives this output too, which is not a
+	// hello message, so the command is run separately.
+	mockedStdout = `<?xml version="1.0"?>
+<log>
+<logentry node="d4c3dbfa77a74ae238e401d5d2197b45f30d8513"><tag>v1.0.0</tag></logentry>
+</log>
+`
+	h := &hgWorkingTree{
+		anyWorkingTree: anyWorkingTree{VCS: vcs.ByCmd(vcsHg)},
+		server:         newHgCmdServer(nil, ""),
+	}
+	defer h.server.Close()
+	for i := 0; i < 2; i++ {
+		revs, err := h.Revisions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(revs, []string{"d4c3dbfa77a74ae238e401d5d2197b45f30d8513"}) {
+			t.Errorf("Revisions: got %v", revs)
+		}
+	}
+	if !h.server.failed {
+		t.Error("server not marked as failed")
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Hints maps project import paths to tags or revisions which are
+// suspected to match, in order of preference.
+type Hints map[string][]string
+
+// ReadHints parses a hints file from r. Each line has an import
+// path followed by one or more tags or revisions, separated by
+// whitespace. Blank lines and lines starting with "#" are ignored.
+func ReadHints(r io.Reader) (Hints, error) {
+	hints := make(Hints)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		hints[fields[0]] = append(hints[fields[0]], fields[1:]...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return hints, nil
+}
+
+// AddHints adds hints for projects in this GoSource. Each hinted
+// tag or revision is tried, in order, before searching all tags and
+// revisions in the upstream repository.
+func (src *GoSource) AddHints(hints Hints) {
+	if src.hints == nil {
+		src.hints = make(Hints)
+	}
+	for importPath, refs := range hints {
+		src.hints[importPath] = append(src.hints[importPath], refs...)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadHints(t *testing.T) {
+	hints, err := ReadHints(strings.NewReader(`
+# comment
+github.com/foo/bar v1.2.0 0123456789abcdef
+github.com/eggs/ham	v0.1.0
+github.com/foo/bar v1.1.0
+github.com/no/refs
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Hints{
+		"github.com/foo/bar":  {"v1.2.0", "0123456789abcdef", "v1.1.0"},
+		"github.com/eggs/ham": {"v0.1.0"},
+	}
+	if !reflect.DeepEqual(hints, expected) {
+		t.Errorf("got %v, want %v", hints, expected)
+	}
+}
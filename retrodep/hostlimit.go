@@ -0,0 +1,176 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+// This file contains the limits on the clones and requests made to
+// each upstream host at once, shared by every working tree.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HostLimits limits the number of clones, fetches and API requests
+// made to each host at once, across all working trees and Scanners.
+type HostLimits struct {
+	// Default is the limit for hosts not in Hosts, or 0 for no
+	// limit.
+	Default int
+
+	// Hosts maps host names, such as "github.com", to their
+	// limits. Requests to a forge's API host, such as
+	// api.github.com, count towards the limit for the forge.
+	Hosts map[string]int
+}
+
+// ReadHostLimits parses a host limits file from r. Each line has a
+// host name, or "*" for the default, followed by the limit, separated
+// by whitespace. Blank lines and lines starting with "#" are ignored.
+func ReadHostLimits(r io.Reader) (HostLimits, error) {
+	limits := HostLimits{Hosts: make(map[string]int)}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return limits, fmt.Errorf("invalid host limit %q", line)
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 0 {
+			return limits, fmt.Errorf("invalid host limit %q", line)
+		}
+		if fields[0] == "*" {
+			limits.Default = n
+		} else {
+			limits.Hosts[strings.ToLower(fields[0])] = n
+		}
+	}
+	return limits, scanner.Err()
+}
+
+var (
+	hostLimitsMu sync.Mutex
+	hostLimits   HostLimits
+	hostLimiters = make(map[string]limiter)
+)
+
+// SetHostLimits sets the number of clones, fetches and API requests
+// which may be made to each host at once. By default there is no
+// limit for any host, only the limit on clones set by
+// SetConcurrency.
+func SetHostLimits(l HostLimits) {
+	hostLimitsMu.Lock()
+	defer hostLimitsMu.Unlock()
+	hostLimits = l
+	hostLimiters = make(map[string]limiter)
+}
+
+// forgeHosts maps the API hosts of forges to the hosts whose limits
+// they share.
+var forgeHosts = map[string]string{
+	"api.github.com":      "github.com",
+	"codeload.github.com": "github.com",
+}
+
+// hostLimiter returns the limiter for host, or nil if it has no
+// limit.
+func hostLimiter(host string) limiter {
+	host = strings.ToLower(host)
+	if forge, ok := forgeHosts[host]; ok {
+		host = forge
+	}
+	hostLimitsMu.Lock()
+	defer hostLimitsMu.Unlock()
+	if l, ok := hostLimiters[host]; ok {
+		return l
+	}
+	n, ok := hostLimits.Hosts[host]
+	if !ok {
+		n = hostLimits.Default
+	}
+	var l limiter
+	if n > 0 {
+		l = make(limiter, n)
+	}
+	hostLimiters[host] = l
+	return l
+}
+
+// acquireHost waits until another clone or request may be made to
+// the host of the repository or URL repo, and returns the function
+// to call once it is done.
+func acquireHost(repo string) func() {
+	l := hostLimiter(urlHost(repo))
+	if l == nil {
+		return func() {}
+	}
+	l.acquire()
+	var once sync.Once
+	return func() {
+		once.Do(l.release)
+	}
+}
+
+// urlHost returns the host name from a repository URL, including
+// the scp-like form user@host:path used by git and bzr.
+func urlHost(repo string) string {
+	if u, err := url.Parse(repo); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	if i := strings.Index(repo, ":"); i != -1 && !strings.Contains(repo[:i], "/") {
+		host := repo[:i]
+		if at := strings.LastIndex(host, "@"); at != -1 {
+			host = host[at+1:]
+		}
+		return host
+	}
+	return ""
+}
+
+// hostLimitedDo is client.Do, counting towards the limit for the
+// request's host until the response body is closed.
+func hostLimitedDo(client *http.Client, req *http.Request) (*http.Response, error) {
+	release := acquireHost(req.URL.String())
+	resp, err := client.Do(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &hostLimitedBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// hostLimitedBody is a response body which releases the limit for
+// its host when closed.
+type hostLimitedBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *hostLimitedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
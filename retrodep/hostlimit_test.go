@@ -0,0 +1,131 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadHostLimits(t *testing.T) {
+	limits, err := ReadHostLimits(strings.NewReader(`
+# comment
+*          8
+GitHub.com 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := HostLimits{Default: 8, Hosts: map[string]int{"github.com": 2}}
+	if !reflect.DeepEqual(limits, exp) {
+		t.Errorf("got %+v", limits)
+	}
+
+	for _, bad := range []string{"github.com", "github.com x", "github.com -1"} {
+		if _, err := ReadHostLimits(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestURLHost(t *testing.T) {
+	for repo, exp := range map[string]string{
+		"https://github.com/foo/bar":    "github.com",
+		"ssh://git@example.com:22/foo":  "example.com",
+		"git@gitlab.com:foo/bar.git":    "gitlab.com",
+		"https://api.github.com/repos/": "api.github.com",
+		"/local/path":                   "",
+	} {
+		if host := urlHost(repo); host != exp {
+			t.Errorf("%s: got %q, want %q", repo, host, exp)
+		}
+	}
+}
+
+// acquired returns true if acquireHost(repo) returns before long.
+func acquired(repo string) (func(), bool) {
+	ch := make(chan func(), 1)
+	go func() {
+		ch <- acquireHost(repo)
+	}()
+	select {
+	case release := <-ch:
+		return release, true
+	case <-time.After(50 * time.Millisecond):
+		return func() { (<-ch)() }, false
+	}
+}
+
+func TestAcquireHost(t *testing.T) {
+	SetHostLimits(HostLimits{Default: 2, Hosts: map[string]int{"github.com": 1}})
+	defer SetHostLimits(HostLimits{})
+
+	release := acquireHost("https://github.com/foo/bar")
+
+	// API requests share the limit for github.com.
+	waiting, ok := acquired("https://api.github.com/repos/foo/bar")
+	if ok {
+		t.Error("limit for github.com not applied")
+	}
+	release()
+	waiting()
+
+	// Other hosts have the default limit.
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		r, ok := acquired("https://example.com/foo")
+		if !ok {
+			t.Fatal("default limit too low")
+		}
+		releases = append(releases, r)
+	}
+	waiting, ok = acquired("https://example.com/bar")
+	if ok {
+		t.Error("default limit not applied")
+	}
+	for _, r := range releases {
+		r()
+	}
+	waiting()
+}
+
+func TestHostLimitedDo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	SetHostLimits(HostLimits{Default: 1})
+	defer SetHostLimits(HostLimits{})
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := hostLimitedDo(http.DefaultClient, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiting, ok := acquired(srv.URL)
+	if ok {
+		t.Error("limit released before the body was closed")
+	}
+	resp.Body.Close()
+	waiting()
+}
@@ -0,0 +1,276 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile is the file, at the top of the path examined, listing
+// paths to ignore using gitignore syntax.
+const IgnoreFile = ".retrodepignore"
+
+// ignorePattern is a single line of an ignore file.
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Ignore holds the patterns from an ignore file, in order.
+type Ignore []ignorePattern
+
+// ReadIgnore parses an ignore file from r. The syntax is that of
+// gitignore(5): blank lines and lines starting with "#" are ignored,
+// "!" negates a pattern, a trailing "/" matches only directories, a
+// pattern containing "/" is relative to the top of the path
+// examined, and "**" matches any number of directories.
+func ReadIgnore(r io.Reader) (Ignore, error) {
+	var ignore Ignore
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " ")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var p ignorePattern
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\#") || strings.HasPrefix(line, "\\!") {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		expr := globToRegexp(line)
+		if anchored {
+			expr = "^" + expr + "$"
+		} else {
+			expr = "(^|/)" + expr + "$"
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		p.re = re
+		ignore = append(ignore, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ignore, nil
+}
+
+// globToRegexp converts a gitignore glob to a regular expression.
+func globToRegexp(glob string) string {
+	var expr strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			expr.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end == -1 {
+				expr.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end
+		case c == '\\' && i+1 < len(glob):
+			i++
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String()
+}
+
+// Match returns true if rel, a slash-separated path relative to the
+// top of the path examined, is ignored. The last matching pattern
+// decides.
+func (ig Ignore) Match(rel string, isDir bool) bool {
+	ignored := false
+	for _, p := range ig {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(rel) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// MatchPath returns true if the file rel, a slash-separated path
+// relative to the top of the path examined, is ignored either itself
+// or because a directory containing it is.
+func (ig Ignore) MatchPath(rel string) bool {
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if ig.Match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return ig.Match(rel, false)
+}
+
+// hashExcludes are the paths within projects, both local and
+// upstream, which are left out of the file hashes compared.
+var hashExcludes Ignore
+
+// SetHashExcludes sets the patterns, in gitignore syntax, for paths
+// within projects to leave out of the file hashes compared. This is
+// for copies of projects which have been pruned, for example of
+// their testdata directories, so that both sides are compared
+// without them.
+func SetHashExcludes(ig Ignore) {
+	hashExcludes = ig
+}
+
+// excluding returns h without the paths matching hashExcludes, and
+// with only those matching hashIncludes.
+func (h FileHashes) excluding() FileHashes {
+	return h.including(hashIncludes).without(hashExcludes)
+}
+
+// without returns h without the paths matching ig.
+func (h FileHashes) without(ig Ignore) FileHashes {
+	if len(ig) == 0 {
+		return h
+	}
+	kept := make(FileHashes)
+	for path, fileHash := range h {
+		if !ig.MatchPath(filepath.ToSlash(path)) {
+			kept[path] = fileHash
+		}
+	}
+	return kept
+}
+
+// ProjectHashExcludes maps import path prefixes to the patterns for
+// paths to leave out of the file hashes of the projects below them,
+// in addition to those set with SetHashExcludes.
+type ProjectHashExcludes map[string]Ignore
+
+// For returns the patterns for the project at importPath, from the
+// longest import path prefix matching it, or nil if there is none.
+func (p ProjectHashExcludes) For(importPath string) Ignore {
+	var best string
+	for prefix := range p {
+		if len(prefix) > len(best) &&
+			(importPath == prefix || strings.HasPrefix(importPath, prefix+"/")) {
+			best = prefix
+		}
+	}
+	return p[best]
+}
+
+// AddHashExcludes adds patterns for paths to leave out of the local
+// file hashes of projects in this GoSource. The working trees they
+// are compared with need the same patterns, given with HashExcludes.
+func (src *GoSource) AddHashExcludes(excludes ProjectHashExcludes) {
+	if src.hashExcludes == nil {
+		src.hashExcludes = make(ProjectHashExcludes)
+	}
+	for prefix, ig := range excludes {
+		src.hashExcludes[prefix] = append(src.hashExcludes[prefix], ig...)
+	}
+}
+
+// HashExcludes makes working trees leave the paths matching ig out
+// of the file hashes of refs, in addition to those set with
+// SetHashExcludes.
+func HashExcludes(ig Ignore) WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		o.excludes = ig
+	}
+}
+
+// FindIgnored returns the paths below path which are ignored by its
+// IgnoreFile, including the IgnoreFile itself, or nil if there is no
+// IgnoreFile. Directories which are ignored are not descended into.
+func FindIgnored(path string) ([]string, error) {
+	f, err := os.Open(filepath.Join(path, IgnoreFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	ignore, err := ReadIgnore(f)
+	if err != nil {
+		return nil, err
+	}
+
+	ignored := []string{filepath.Join(path, IgnoreFile)}
+	walkfn := func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if pth == path {
+			return nil
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(path, pth)
+		if err != nil {
+			return err
+		}
+		if !ignore.Match(filepath.ToSlash(rel), info.IsDir()) {
+			return nil
+		}
+		ignored = append(ignored, pth)
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+	if err := filepath.Walk(path, walkfn); err != nil {
+		return nil, err
+	}
+	return ignored, nil
+}
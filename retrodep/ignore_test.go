@@ -0,0 +1,219 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestIgnoreMatch(t *testing.T) {
+	ignore, err := ReadIgnore(strings.NewReader(`# comment
+*.log
+!keep.log
+build/
+/Dockerfile
+docs/**/*.md
+**/generated
+\#hash
+vendor/github.com/foo/bar/ex?mples/
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcs := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"a.log", false, true},
+		{"sub/b.log", false, true},
+		{"sub/keep.log", false, false},
+		{"build", true, true},
+		{"sub/build", true, true},
+		{"build", false, false},
+		{"Dockerfile", false, true},
+		{"sub/Dockerfile", false, false},
+		{"docs/README.md", false, true},
+		{"docs/a/b/c.md", false, true},
+		{"docs/a/b/c.txt", false, false},
+		{"x/y/generated", true, true},
+		{"generated", false, true},
+		{"#hash", false, true},
+		{"comment", false, false},
+		{"vendor/github.com/foo/bar/examples", true, true},
+		{"vendor/github.com/foo/bar/example", true, false},
+	}
+	for _, tc := range tcs {
+		if ignored := ignore.Match(tc.path, tc.isDir); ignored != tc.ignored {
+			t.Errorf("%s: got %t, want %t", tc.path, ignored, tc.ignored)
+		}
+	}
+}
+
+func TestFindIgnored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-ignore.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if ignored, err := FindIgnored(dir); err != nil || ignored != nil {
+		t.Errorf("without ignore file: got %v, %v", ignored, err)
+	}
+
+	writeFiles(t, dir, map[string]string{
+		IgnoreFile:                         "Dockerfile\nhack/\n",
+		"main.go":                          "package main\n",
+		"Dockerfile":                       "FROM scratch\n",
+		"hack/build.sh":                    "#!/bin/sh\n",
+		"vendor/github.com/foo/bar/bar.go": "package bar\n",
+	})
+	ignored, err := FindIgnored(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(ignored)
+	exp := []string{
+		filepath.Join(dir, IgnoreFile),
+		filepath.Join(dir, "Dockerfile"),
+		filepath.Join(dir, "hack"),
+	}
+	sort.Strings(exp)
+	if !reflect.DeepEqual(ignored, exp) {
+		t.Errorf("got %v, want %v", ignored, exp)
+	}
+
+	srcs, err := FindGoSources(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srcs[0].excludes[filepath.Join(dir, "hack")]; !ok {
+		t.Errorf("ignored paths not excluded: %v", srcs[0].excludes)
+	}
+}
+
+func TestHashExcludes(t *testing.T) {
+	ignore, err := ReadIgnore(strings.NewReader("testdata/\n/docs\n*.md\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetHashExcludes(nil)
+	SetHashExcludes(ignore)
+
+	hashes := FileHashes{
+		"a.go":                "1",
+		"README.md":           "2",
+		"docs/index.html":     "3",
+		"sub/docs/doc.go":     "4",
+		"sub/testdata/t.json": "5",
+		"testdata":            "6",
+	}
+	expected := FileHashes{
+		"a.go":            "1",
+		"sub/docs/doc.go": "4",
+		"testdata":        "6",
+	}
+	if got := hashes.excluding(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+
+	// Both local and upstream file hashes leave them out.
+	wt := &anyWorkingTree{}
+	got, err := wt.cachedFileHashes("v1.0.0", "", func(ref, subPath string) (FileHashes, error) {
+		return hashes, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("upstream: got %v, want %v", got, expected)
+	}
+
+	dir, err := ioutil.TempDir("", "retrodep-excludes.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"a.go":              "package a\n",
+		"testdata/a.golden": "golden\n",
+	})
+	src, err := NewGoSource(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proj := &RepoPath{RepoRoot: vcs.RepoRoot{Root: "example.com/a"}}
+	local, err := src.hashLocalFiles(&sha256Hasher{}, proj, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := local["a.go"]; !ok || len(local) != 1 {
+		t.Errorf("local: got %v", local)
+	}
+}
+
+func TestProjectHashExcludes(t *testing.T) {
+	ignore, err := ReadIgnore(strings.NewReader("testdata/\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config workingTreeOptions
+	HashExcludes(ignore)(&config)
+	wt := &anyWorkingTree{excludes: config.excludes}
+	got, err := wt.cachedFileHashes("v1.0.0", "", func(ref, subPath string) (FileHashes, error) {
+		return FileHashes{"a.go": "1", "testdata/a.golden": "2"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (FileHashes{"a.go": "1"}); !reflect.DeepEqual(got, expected) {
+		t.Errorf("upstream: got %v, want %v", got, expected)
+	}
+
+	dir, err := ioutil.TempDir("", "retrodep-excludes.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"a.go":              "package a\n",
+		"testdata/a.golden": "golden\n",
+	})
+	src, err := NewGoSource(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.AddHashExcludes(ProjectHashExcludes{"example.com/a": ignore})
+	for root, n := range map[string]int{"example.com/a": 1, "example.com/b": 2} {
+		proj := &RepoPath{RepoRoot: vcs.RepoRoot{Root: root}}
+		local, err := src.hashLocalFiles(&sha256Hasher{}, proj, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(local) != n {
+			t.Errorf("%s: got %v", root, local)
+		}
+	}
+}
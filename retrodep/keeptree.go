@@ -0,0 +1,48 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import "sync"
+
+var tempDirMu sync.Mutex
+var tempDirPath string
+
+// SetTempDir makes working trees, and files exported from them, be
+// created in dir rather than in os.TempDir(), for instance where
+// /tmp is too small for large repositories. An empty dir restores
+// the default. Options.TempDir takes precedence for a Scanner.
+func SetTempDir(dir string) {
+	tempDirMu.Lock()
+	defer tempDirMu.Unlock()
+	tempDirPath = dir
+}
+
+// tempDirInUse returns the directory set by SetTempDir.
+func tempDirInUse() string {
+	tempDirMu.Lock()
+	defer tempDirMu.Unlock()
+	return tempDirPath
+}
+
+// KeepWorkingTree makes closing a working tree leave its local
+// checkout in place, logging where it is, so that it can be examined
+// afterwards, for instance to find out why no version matched. It
+// does not apply to working trees from a WorkingTreeFactory.
+func KeepWorkingTree() WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		o.keep = true
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestSetTempDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "retrodep-keeptree.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	SetTempDir(tmp)
+	defer SetTempDir("")
+	var env *scanEnv
+	dir, err := env.tempDir("retrodep.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(dir) != tmp {
+		t.Errorf("created %s", dir)
+	}
+
+	// A Scanner's own directory comes first.
+	other := filepath.Join(tmp, "other")
+	if err := os.Mkdir(other, 0700); err != nil {
+		t.Fatal(err)
+	}
+	dir, err = (&scanEnv{dir: other}).tempDir("retrodep.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(dir) != other {
+		t.Errorf("created %s", dir)
+	}
+}
+
+func TestKeepWorkingTree(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "retrodep-keeptree.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	logger := &messageLogger{}
+	s := NewScanner(Options{
+		Command: func(name string, args ...string) *exec.Cmd {
+			return exec.Command("true")
+		},
+		TempDir:     tmp,
+		Logger:      logger,
+		TreeOptions: []WorkingTreeOption{KeepWorkingTree()},
+	})
+	root := &vcs.RepoRoot{
+		VCS:  vcs.ByCmd(vcsGit),
+		Repo: "https://example.invalid/foo",
+		Root: "example.invalid/foo",
+	}
+	wt, err := s.NewWorkingTree(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := wt.Root()
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("working tree not kept: %s", err)
+	}
+	found := false
+	for _, msg := range logger.msgs {
+		if strings.Contains(msg, dir) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("logged %q", logger.msgs)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// moduleLayout is one way the local files of a project may be laid
+// out in its upstream repository: the file hashes, relative to the
+// directory subPath of the repository.
+type moduleLayout struct {
+	hashes  FileHashes
+	subPath string
+}
+
+// majorVersionDir returns whether name is a major version
+// subdirectory, such as "v3", for major version 2 onwards.
+func majorVersionDir(name string) bool {
+	if len(name) < 2 || name[0] != 'v' {
+		return false
+	}
+	n, err := strconv.Atoi(name[1:])
+	return err == nil && n >= 2 && name[1] != '0'
+}
+
+// moduleLayouts returns the layouts to try for the local files in dir
+// with hashes, which are expected at subPath upstream. A module with
+// a major version suffix, such as github.com/foo/bar/v3, is either at
+// the root of its repository at the v3 tags, or in its v3
+// subdirectory, so for a major version subdirectory, whether at the
+// end of subPath, holding all the local files, or named by the
+// module path in dir's go.mod, the other layout is tried after the
+// one expected.
+func moduleLayouts(hashes FileHashes, subPath, dir string) []moduleLayout {
+	layouts := []moduleLayout{{hashes, subPath}}
+	slashed := filepath.ToSlash(subPath)
+
+	// The project is in a major version subdirectory, which may
+	// be the root of the repository instead.
+	if base := path.Base(slashed); majorVersionDir(base) {
+		parent := filepath.FromSlash(path.Dir(slashed))
+		if parent == "." {
+			parent = ""
+		}
+		return append(layouts, moduleLayout{hashes, parent})
+	}
+
+	// All the local files are in a major version subdirectory, as
+	// for a vendored github.com/foo/bar/v3, which may have been
+	// copied from the root of the repository.
+	var major string
+	for p := range hashes {
+		first := strings.SplitN(filepath.ToSlash(p), "/", 2)
+		if len(first) < 2 || !majorVersionDir(first[0]) ||
+			(major != "" && first[0] != major) {
+			major = ""
+			break
+		}
+		major = first[0]
+	}
+	if major != "" {
+		prefix := major + string(filepath.Separator)
+		stripped := make(FileHashes, len(hashes))
+		for p, h := range hashes {
+			stripped[strings.TrimPrefix(p, prefix)] = h
+		}
+		return append(layouts, moduleLayout{stripped, subPath})
+	}
+
+	// A nested go.mod names a module with a major version
+	// suffix, which may have been copied from the subdirectory.
+	modulePath, ok := goModulePath(&GoSource{Path: dir})
+	if !ok {
+		return layouts
+	}
+	if base := path.Base(modulePath); majorVersionDir(base) {
+		matchLog.Debugf("%s: also trying subdirectory %s", modulePath, base)
+		layouts = append(layouts, moduleLayout{hashes, filepath.Join(subPath, base)})
+	}
+	return layouts
+}
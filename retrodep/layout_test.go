@@ -0,0 +1,89 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestModuleLayouts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-layout.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gomod := "module github.com/foo/bar/v3\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(gomod), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rooted := FileHashes{"bar.go": "1"}
+	nested := FileHashes{filepath.Join("v3", "bar.go"): "1"}
+	tcs := []struct {
+		name    string
+		hashes  FileHashes
+		subPath string
+		dir     string
+		exp     []moduleLayout
+	}{
+		{"plain", rooted, "sub", "", []moduleLayout{{rooted, "sub"}}},
+		{"v1", FileHashes{filepath.Join("v1", "bar.go"): "1"}, "", "",
+			[]moduleLayout{{FileHashes{filepath.Join("v1", "bar.go"): "1"}, ""}}},
+		{"subpath", rooted, "v3", "", []moduleLayout{{rooted, "v3"}, {rooted, ""}}},
+		{"vendored", nested, "", "", []moduleLayout{{nested, ""}, {rooted, ""}}},
+		{"go.mod", rooted, "", dir, []moduleLayout{{rooted, ""}, {rooted, "v3"}}},
+	}
+	for _, tc := range tcs {
+		layouts := moduleLayouts(tc.hashes, tc.subPath, tc.dir)
+		if !reflect.DeepEqual(layouts, tc.exp) {
+			t.Errorf("%s: got %v, want %v", tc.name, layouts, tc.exp)
+		}
+	}
+}
+
+// layoutWorkingTree has bar.go at the root of the repository at
+// v3.0.0, and in the v3 subdirectory at v3.1.0.
+type layoutWorkingTree struct{ stubWorkingTree }
+
+func (wt *layoutWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	hashes := make(FileHashes)
+	if (ref == "v3.0.0" && subPath == "") || (ref == "v3.1.0" && subPath == "v3") {
+		hashes["bar.go"] = "1"
+	}
+	return hashes, nil
+}
+
+func TestMatchLayoutsFromRefs(t *testing.T) {
+	rooted := FileHashes{"bar.go": "1"}
+	layouts := moduleLayouts(rooted, "v3", "")
+	for _, ref := range []string{"v3.0.0", "v3.1.0"} {
+		matches, err := matchLayoutsFromRefs(false, layouts, &layoutWorkingTree{}, []string{ref})
+		if err != nil {
+			t.Errorf("%s: %s", ref, err)
+			continue
+		}
+		if len(matches) != 1 || matches[0] != ref {
+			t.Errorf("%s: got %v", ref, matches)
+		}
+	}
+	if _, err := matchFromRefs(false, rooted, &layoutWorkingTree{}, "v3", []string{"v3.0.0"}); err != ErrorVersionNotFound {
+		t.Errorf("matched v3.0.0 in the v3 subdirectory: %v", err)
+	}
+}
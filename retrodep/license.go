@@ -0,0 +1,137 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/Masterminds/semver"
+)
+
+// Kinds of LicenseChange.
+const (
+	// LicenseAdded means the upstream file is not in the local copy.
+	LicenseAdded = "added"
+
+	// LicenseRemoved means the local file is not upstream.
+	LicenseRemoved = "removed"
+
+	// LicenseModified means the upstream file has different content.
+	LicenseModified = "modified"
+)
+
+// LicenseChange describes a license or notice file which differs
+// between the local copy of a project and an upstream version.
+type LicenseChange struct {
+	// Kind is one of LicenseAdded, LicenseRemoved or
+	// LicenseModified.
+	Kind string
+
+	// Name is the file name.
+	Name string
+
+	// Ref is the upstream tag or revision compared with.
+	Ref string
+}
+
+// latestRelease returns the highest tag in tags, which must be sorted
+// as by VersionTags, which is not a prerelease. It returns "" if
+// there is none.
+func latestRelease(tags []string) string {
+	for _, tag := range tags {
+		v, err := semver.NewVersion(tag)
+		if err == nil && v.Prerelease() == "" {
+			return tag
+		}
+	}
+	return ""
+}
+
+// compareLicenses returns the changes from local to upstream, which
+// was read from ref.
+func compareLicenses(local, upstream []NoticeFile, ref string) []*LicenseChange {
+	localText := make(map[string]string)
+	for _, file := range local {
+		localText[filepath.Base(file.Name)] = file.Text
+	}
+
+	var changes []*LicenseChange
+	seen := make(map[string]bool)
+	for _, file := range upstream {
+		name := filepath.Base(file.Name)
+		seen[name] = true
+		text, ok := localText[name]
+		switch {
+		case !ok:
+			changes = append(changes, &LicenseChange{LicenseAdded, name, ref})
+		case text != file.Text:
+			changes = append(changes, &LicenseChange{LicenseModified, name, ref})
+		}
+	}
+	for name := range localText {
+		if !seen[name] {
+			changes = append(changes, &LicenseChange{LicenseRemoved, name, ref})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+// LicenseChanges compares the license and notice files in the local
+// copy of the project described by ref with those at subPath in the
+// matched upstream revision, and in the latest upstream release if
+// that is different. It returns ErrorVersionNotFound if ref has no
+// revision.
+func (src GoSource) LicenseChanges(ref *Reference, wt WorkingTree, subPath string) ([]*LicenseChange, error) {
+	if ref.Rev == "" {
+		return nil, ErrorVersionNotFound
+	}
+	local, err := noticeFiles(src.Path, filepath.FromSlash(src.projectDir(ref)))
+	if err != nil {
+		return nil, err
+	}
+
+	refs := []string{ref.Rev}
+	tags, err := wt.VersionTags()
+	if err != nil {
+		return nil, err
+	}
+	if latest := latestRelease(tags); latest != "" && latest != ref.Tag {
+		rev, err := wt.RevisionFromTag(latest)
+		if err != nil {
+			return nil, err
+		}
+		if rev != ref.Rev {
+			refs = append(refs, latest)
+		}
+	}
+
+	var changes []*LicenseChange
+	for _, r := range refs {
+		if err := wt.RevSync(r); err != nil {
+			return nil, err
+		}
+		upstream, err := noticeFiles(wt.Root(), subPath)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, compareLicenses(local, upstream, r)...)
+	}
+	return changes, nil
+}
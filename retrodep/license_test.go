@@ -0,0 +1,130 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// licenseWorkingTree is a mock WorkingTree with a directory for each
+// revision.
+type licenseWorkingTree struct {
+	stubWorkingTree
+	dirs map[string]string
+	tags map[string]string
+}
+
+func (wt *licenseWorkingTree) VersionTags() ([]string, error) {
+	return []string{"v2.0.0-rc1", "v1.1.0", "v1.0.0"}, nil
+}
+
+func (wt *licenseWorkingTree) RevisionFromTag(tag string) (string, error) {
+	return wt.tags[tag], nil
+}
+
+func (wt *licenseWorkingTree) RevSync(rev string) error {
+	if r, ok := wt.tags[rev]; ok {
+		rev = r
+	}
+	wt.Dir = wt.dirs[rev]
+	return nil
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLicenseChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-license.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, dir, map[string]string{
+		"src/main.go":                      "package main\n",
+		"src/vendor/example.com/p/p.go":    "package p\n",
+		"src/vendor/example.com/p/LICENSE": "MIT\n",
+		"v1.0.0/LICENSE":                   "MIT\n",
+		"v1.0.0/PATENTS":                   "Patents\n",
+		"v1.1.0/LICENSE":                   "AGPL\n",
+	})
+	src, err := NewGoSource(filepath.Join(dir, "src"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt := &licenseWorkingTree{
+		dirs: map[string]string{
+			"rev1": filepath.Join(dir, "v1.0.0"),
+			"rev2": filepath.Join(dir, "v1.1.0"),
+		},
+		tags: map[string]string{
+			"v1.0.0": "rev1",
+			"v1.1.0": "rev2",
+		},
+	}
+
+	ref := &Reference{
+		TopPkg: "example.com/top",
+		Pkg:    "example.com/p",
+		Tag:    "v1.0.0",
+		Rev:    "rev1",
+		Ver:    "v1.0.0",
+	}
+	changes, err := src.LicenseChanges(ref, wt, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []LicenseChange{
+		{LicenseAdded, "PATENTS", "rev1"},
+		{LicenseModified, "LICENSE", "v1.1.0"},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("got %d changes, want %d", len(changes), len(expected))
+	}
+	for i, c := range changes {
+		if *c != expected[i] {
+			t.Errorf("%d: got %v, want %v", i, *c, expected[i])
+		}
+	}
+
+	// The latest release is not compared twice.
+	ref = &Reference{
+		TopPkg: "example.com/top",
+		Pkg:    "example.com/p",
+		Tag:    "v1.1.0",
+		Rev:    "rev2",
+		Ver:    "v1.1.0",
+	}
+	changes, err = src.LicenseChanges(ref, wt, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Ref != "rev2" {
+		t.Errorf("unexpected changes %v", changes)
+	}
+}
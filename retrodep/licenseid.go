@@ -0,0 +1,189 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LicenseFile is a license file in the upstream version of a
+// project, and the license identified from its text.
+type LicenseFile struct {
+	// Path is the slash-separated path of the file relative to
+	// the repository root.
+	Path string `json:"path"`
+
+	// License is the SPDX license identifier for the file, or
+	// empty if the license was not recognised.
+	License string `json:"license,omitempty"`
+}
+
+// licenseOnlyRE matches the names of files holding license terms,
+// unlike licenseFileRE which also matches notice files.
+var licenseOnlyRE = regexp.MustCompile(`(?i)^(un)?(licen[cs]e|copying)([-._].*)?$`)
+
+// licenseWordRE matches the runs of characters ignored when
+// comparing license text.
+var licenseWordRE = regexp.MustCompile(`[^a-z0-9]+`)
+
+// licenseRule identifies a license by phrases which must all appear
+// in its normalized text and phrases which must not.
+type licenseRule struct {
+	id      string
+	all     []string
+	without []string
+}
+
+// licenseRules identify the licenses recognised. The full text of
+// some licenses names others, such as the GNU General Public License
+// recommending the GNU Lesser General Public License, so the license
+// whose first phrase appears earliest in the text is chosen.
+var licenseRules = []licenseRule{
+	{id: "AGPL-3.0", all: []string{"gnu affero general public license", "version 3"}},
+	{id: "LGPL-3.0", all: []string{"gnu lesser general public license", "version 3 29 june 2007"}},
+	{id: "LGPL-2.1", all: []string{"gnu lesser general public license", "version 2 1"}},
+	{id: "LGPL-2.0", all: []string{"gnu library general public license", "version 2"}},
+	{id: "GPL-3.0", all: []string{"gnu general public license", "version 3 29 june 2007"}},
+	{id: "GPL-2.0", all: []string{"gnu general public license", "version 2 june 1991"}},
+	{id: "MPL-2.0", all: []string{"mozilla public license version 2 0"}},
+	{id: "EPL-2.0", all: []string{"eclipse public license", "v 2 0"}},
+	{id: "EPL-1.0", all: []string{"eclipse public license", "v 1 0"}},
+	{id: "Apache-2.0", all: []string{"apache license", "version 2 0"}},
+	{id: "BSL-1.0", all: []string{"boost software license version 1 0"}},
+	{id: "CC0-1.0", all: []string{"cc0 1 0 universal"}},
+	{id: "Unlicense", all: []string{"this is free and unencumbered software released into the public domain"}},
+	{id: "ISC", all: []string{"permission to use copy modify and or distribute this software for any purpose with or without fee is hereby granted"}},
+	{id: "MIT", all: []string{
+		"permission is hereby granted free of charge to any person obtaining a copy",
+		"the above copyright notice and this permission notice shall be included",
+	}},
+	{id: "Zlib", all: []string{
+		"the origin of this software must not be misrepresented",
+		"altered source versions must be plainly marked as such",
+	}},
+	{id: "BSD-3-Clause", all: []string{
+		"redistribution and use in source and binary forms",
+		"may be used to endorse or promote products derived from this software",
+	}},
+	{id: "BSD-2-Clause", all: []string{
+		"redistribution and use in source and binary forms",
+		"redistributions in binary form must reproduce the above copyright notice",
+	}, without: []string{"endorse or promote"}},
+}
+
+// gnuLicenses are the identifiers which need an "-only" or
+// "-or-later" suffix.
+var gnuLicenses = map[string]bool{
+	"AGPL-3.0": true,
+	"GPL-2.0":  true,
+	"GPL-3.0":  true,
+	"LGPL-2.0": true,
+	"LGPL-2.1": true,
+	"LGPL-3.0": true,
+}
+
+// IdentifyLicense returns the SPDX license identifier for the license
+// whose terms are in text, or "" if it is not recognised. The text is
+// compared ignoring case, punctuation and line breaks. GNU licenses
+// are identified as "-or-later" if the text before the terms and
+// conditions permits any later version, and as "-only" otherwise.
+func IdentifyLicense(text string) string {
+	norm := " " + licenseWordRE.ReplaceAllString(strings.ToLower(text), " ") + " "
+	var match *licenseRule
+	first := len(norm)
+	for i := range licenseRules {
+		rule := &licenseRules[i]
+		if !containsAll(norm, rule.all) || containsAny(norm, rule.without) {
+			continue
+		}
+		if pos := strings.Index(norm, rule.all[0]); pos < first {
+			match, first = rule, pos
+		}
+	}
+	if match == nil {
+		return ""
+	}
+	if !gnuLicenses[match.id] {
+		return match.id
+	}
+	preamble := norm
+	if i := strings.Index(norm, " terms and conditions "); i >= 0 {
+		preamble = norm[:i]
+	}
+	if strings.Contains(preamble, " any later version ") {
+		return match.id + "-or-later"
+	}
+	return match.id + "-only"
+}
+
+func containsAll(s string, phrases []string) bool {
+	for _, phrase := range phrases {
+		if !strings.Contains(s, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAny(s string, phrases []string) bool {
+	for _, phrase := range phrases {
+		if strings.Contains(s, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectLicenses syncs wt to the revision in ref and identifies the
+// license files in the repository root and the directories down to
+// the project at subPath. It sets ref.LicenseFiles to the files found
+// and ref.License to an SPDX license expression combining the
+// licenses recognised, or "" if none were. It returns
+// ErrorVersionNotFound if ref has no revision.
+func DetectLicenses(wt WorkingTree, ref *Reference, subPath string) error {
+	if ref.Rev == "" {
+		return ErrorVersionNotFound
+	}
+	if err := wt.RevSync(ref.Rev); err != nil {
+		return err
+	}
+	licenses, err := projectNoticeFiles(wt.Root(), subPath)
+	if err != nil {
+		return err
+	}
+
+	var files []LicenseFile
+	seen := make(map[string]bool)
+	var ids []string
+	for _, nf := range licenses {
+		if !licenseOnlyRE.MatchString(path.Base(nf.Name)) {
+			continue
+		}
+		id := IdentifyLicense(nf.Text)
+		files = append(files, LicenseFile{Path: nf.Name, License: id})
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	ref.LicenseFiles = files
+	ref.License = strings.Join(ids, " AND ")
+	return nil
+}
@@ -0,0 +1,143 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+const (
+	testMIT = `MIT License
+
+Copyright (c) 2019 A
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+...
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+`
+
+	testBSD = `Copyright (c) 2019 A
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+`
+
+	testGPL3 = `                    GNU GENERAL PUBLIC LICENSE
+                       Version 3, 29 June 2007
+...
+                       TERMS AND CONDITIONS
+...
+use the GNU Lesser General Public License instead of this License.
+`
+)
+
+func TestIdentifyLicense(t *testing.T) {
+	tests := []struct {
+		name, text, exp string
+	}{
+		{"mit", testMIT, "MIT"},
+		{"bsd-2", testBSD, "BSD-2-Clause"},
+		{
+			"bsd-3",
+			testBSD + `* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+`,
+			"BSD-3-Clause",
+		},
+		{
+			"apache",
+			"\n                                 Apache License\n                           Version 2.0, January 2004\n",
+			"Apache-2.0",
+		},
+		{"mpl", "Mozilla Public License Version 2.0\n", "MPL-2.0"},
+		{"gpl-3-full", testGPL3, "GPL-3.0-only"},
+		{
+			"gpl-3-header",
+			"This program is free software: you can redistribute it and/or modify\n" +
+				"it under the terms of the GNU General Public License as published by\n" +
+				"the Free Software Foundation, either version 3 of the License, or\n" +
+				"(at your option) any later version.\n\n" +
+				"GNU GENERAL PUBLIC LICENSE Version 3, 29 June 2007\n",
+			"GPL-3.0-or-later",
+		},
+		{
+			"lgpl-2.1",
+			"GNU LESSER GENERAL PUBLIC LICENSE\nVersion 2.1, February 1999\n\n" +
+				"[This is the first released version of the Lesser GPL.  It also counts\n" +
+				" as the successor of the GNU Library Public License, version 2]\n",
+			"LGPL-2.1-only",
+		},
+		{"unknown", "All rights reserved.\n", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IdentifyLicense(test.text); got != test.exp {
+				t.Errorf("got %q, want %q", got, test.exp)
+			}
+		})
+	}
+}
+
+func TestDetectLicenses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-licenseid.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, dir, map[string]string{
+		"LICENSE":           testMIT,
+		"NOTICE":            "Not a license",
+		"sub/COPYING":       testBSD,
+		"sub/LICENSE.other": testMIT,
+		"sub/LICENSE.mine":  "All rights reserved.\n",
+		"other/LICENSE":     testGPL3,
+	})
+
+	wt := &stubWorkingTree{anyWorkingTree{Dir: dir}}
+	if err := DetectLicenses(wt, &Reference{Pkg: "p"}, "sub"); err != ErrorVersionNotFound {
+		t.Errorf("got %v, want ErrorVersionNotFound", err)
+	}
+
+	ref := &Reference{Pkg: "p", Rev: "abc"}
+	if err := DetectLicenses(wt, ref, "sub"); err != nil {
+		t.Fatal(err)
+	}
+	expFiles := []LicenseFile{
+		{Path: "LICENSE", License: "MIT"},
+		{Path: "sub/COPYING", License: "BSD-2-Clause"},
+		{Path: "sub/LICENSE.mine"},
+		{Path: "sub/LICENSE.other", License: "MIT"},
+	}
+	if !reflect.DeepEqual(ref.LicenseFiles, expFiles) {
+		t.Errorf("files: got %v, want %v", ref.LicenseFiles, expFiles)
+	}
+	if exp := "BSD-2-Clause AND MIT"; ref.License != exp {
+		t.Errorf("license: got %q, want %q", ref.License, exp)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// LocalRepo makes NewWorkingTree use the existing checkout of the
+// project in dir rather than cloning the repository. The checkout is
+// first brought up to date from its own upstream, for instance with
+// 'git fetch', where that is possible. Closing the working tree
+// leaves dir in place, but syncing the working tree to a revision,
+// as Diff needs, checks that revision out in dir. An empty dir means
+// the repository is cloned as usual.
+func LocalRepo(dir string) WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		o.localRepo = dir
+	}
+}
+
+// LocalRepos maps import path prefixes to the directories holding
+// existing checkouts of the projects below them.
+type LocalRepos map[string]string
+
+// ReadLocalRepos parses a local repositories file from r. Each line
+// has an import path prefix followed by the directory, separated by
+// whitespace. Blank lines and lines starting with "#" are ignored.
+func ReadLocalRepos(r io.Reader) (LocalRepos, error) {
+	repos, err := readPrefixMap(r)
+	return LocalRepos(repos), err
+}
+
+// For returns the directory for the project at importPath, from the
+// longest import path prefix matching it, or "" if there is none.
+func (l LocalRepos) For(importPath string) string {
+	return longestPrefix(l, importPath)
+}
+
+// localFetchArgs are the arguments which bring a checkout up to date
+// from its upstream, for each VCS.
+var localFetchArgs = map[string][]string{
+	vcsGit:    {"fetch", "--tags"},
+	vcsHg:     {"pull"},
+	vcsSvn:    {"update"},
+	vcsBzr:    {"pull"},
+	vcsFossil: {"pull"},
+}
+
+// openLocalRepo returns the absolute path of the checkout from
+// LocalRepo in config for project, after bringing it up to date.
+// Failing to update it, for instance without the network, is not an
+// error, as the revisions it already has can still be used.
+func openLocalRepo(ctx context.Context, project *vcs.RepoRoot, config *workingTreeOptions, options []string) (string, error) {
+	dir, err := filepath.Abs(config.localRepo)
+	if err != nil {
+		return "", err
+	}
+	if !isDir(dir) {
+		return "", fmt.Errorf("%s: not a directory", dir)
+	}
+	env := config.env
+	log := env.log(SubsystemGeneral)
+	log.Debugf("%s: using local repository %s", project.Root, dir)
+	args, ok := localFetchArgs[project.VCS.Cmd]
+	if !ok {
+		return dir, nil
+	}
+	args = append(append([]string{}, options...), args...)
+	output, err := config.retry.run(ctx, log, project.Repo, func(ctx context.Context) (string, error) {
+		release := acquireHost(project.Repo)
+		defer release()
+		stdout, stderr, err := runProcess(ctx, withEnv(env.command(project.VCS.Cmd, args...), config.authEnv), dir)
+		return strings.TrimSpace(stdout.String() + stderr.String()), err
+	})
+	if err != nil {
+		log.Warningf("%s: updating %s: %s", project.Root, dir, output)
+	}
+	return dir, nil
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestReadLocalRepos(t *testing.T) {
+	repos, err := ReadLocalRepos(strings.NewReader(`
+# comment
+github.com/foo /src/foo
+github.com/foo/bar /src/bar
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for importPath, exp := range map[string]string{
+		"github.com/foo":         "/src/foo",
+		"github.com/foo/baz":     "/src/foo",
+		"github.com/foo/bar/sub": "/src/bar",
+		"github.com/foobar":      "",
+	} {
+		if dir := repos.For(importPath); dir != exp {
+			t.Errorf("%s: got %q, want %q", importPath, dir, exp)
+		}
+	}
+}
+
+func TestLocalRepo(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "retrodep-localrepo.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	local := filepath.Join(tmp, "local")
+	if err := os.Mkdir(local, 0700); err != nil {
+		t.Fatal(err)
+	}
+	scratch := filepath.Join(tmp, "scratch")
+	if err := os.Mkdir(scratch, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	var ran []string
+	s := NewScanner(Options{
+		Command: func(name string, args ...string) *exec.Cmd {
+			ran = append(ran, strings.Join(append([]string{name}, args...), " "))
+			return exec.Command("true")
+		},
+		TempDir:     scratch,
+		TreeOptions: []WorkingTreeOption{LocalRepo(local)},
+	})
+	root := &vcs.RepoRoot{
+		VCS:  vcs.ByCmd(vcsGit),
+		Repo: "https://example.invalid/foo",
+		Root: "example.invalid/foo",
+	}
+	wt, err := s.NewWorkingTree(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wt.Root() != local {
+		t.Errorf("working tree in %s", wt.Root())
+	}
+	for _, cmd := range ran {
+		if strings.Contains(cmd, " clone ") {
+			t.Errorf("cloned: %s", cmd)
+		}
+	}
+	if len(ran) == 0 || !strings.HasSuffix(ran[0], " fetch --tags") {
+		t.Errorf("ran %q", ran)
+	}
+	if err := wt.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(local); err != nil {
+		t.Errorf("local repository removed: %s", err)
+	}
+	if entries, _ := ioutil.ReadDir(scratch); len(entries) != 0 {
+		t.Errorf("left %d entries in %s", len(entries), scratch)
+	}
+
+	// A missing checkout is an error.
+	s = NewScanner(Options{
+		TreeOptions: []WorkingTreeOption{LocalRepo(filepath.Join(tmp, "missing"))},
+	})
+	if _, err := s.NewWorkingTree(context.Background(), root); err == nil {
+		t.Error("no error for missing directory")
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// digestPrefix identifies the algorithm used by FileHashes.Digest.
+const digestPrefix = "sha256:"
+
+// Digest returns a single hash summarising all the file hashes,
+// independent of map ordering.
+func (h FileHashes) Digest() string {
+	paths := make([]string, 0, len(h))
+	for p := range h {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	// Only the sums are used so that digests do not depend on
+	// whether the file hashes name their algorithm.
+	hash := sha256.New()
+	for _, p := range paths {
+		io.WriteString(hash, filepath.ToSlash(p)+"\x00"+h[p].Sum()+"\n")
+	}
+	return digestPrefix + hex.EncodeToString(hash.Sum(nil))
+}
+
+// LockEntry records the upstream version matched for a project,
+// together with a digest of the local files it was matched from.
+type LockEntry struct {
+	// Dir is the slash-separated directory holding the project,
+	// relative to the path the GoSource was found from.
+	Dir string `json:"dir"`
+
+	Reference
+
+	// Digest is the FileHashes.Digest of the local files.
+	Digest string `json:"digest"`
+}
+
+// Lock records the state of a top-level project and its vendored
+// dependencies, so that later changes can be detected.
+type Lock struct {
+	Projects []*LockEntry `json:"projects"`
+}
+
+// ReadLock reads a lock file from r.
+func ReadLock(r io.Reader) (*Lock, error) {
+	var lock Lock
+	if err := json.NewDecoder(r).Decode(&lock); err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// Write writes the lock file to w, with the projects ordered as in
+// a Report.
+func (l *Lock) Write(w io.Writer) error {
+	sort.SliceStable(l.Projects, func(i, j int) bool {
+		return referenceLess(&l.Projects[i].Reference, &l.Projects[j].Reference)
+	})
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(l)
+}
+
+// projectDir returns the local directory, relative to src.Path,
+// holding the project described by ref.
+func (src GoSource) projectDir(ref *Reference) string {
+	if ref.TopPkg == "" {
+		return "."
+	}
+	vendor := "vendor"
+	if src.vendorDir != "" {
+		vendor = filepath.ToSlash(src.vendorDir)
+	}
+	return path.Join(vendor, ref.Pkg)
+}
+
+// localDigest returns the digest of the files in dir (relative to
+// src.Path), ignoring the same files as DescribeProject.
+func (src GoSource) localDigest(dir string) (string, error) {
+	root := filepath.Join(src.Path, filepath.FromSlash(dir))
+	hashes, err := src.hashLocalFiles(&sha256Hasher{}, &RepoPath{}, root)
+	if err != nil {
+		return "", err
+	}
+	return hashes.Digest(), nil
+}
+
+// NewLockEntry returns a *LockEntry for ref, which must describe
+// this top-level project or one of its vendored projects.
+func (src GoSource) NewLockEntry(ref *Reference) (*LockEntry, error) {
+	dir := src.projectDir(ref)
+	digest, err := src.localDigest(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &LockEntry{
+		Dir:       path.Join(filepath.ToSlash(src.SubPath), dir),
+		Reference: *ref,
+		Digest:    digest,
+	}, nil
+}
+
+// Kinds of LockDrift.
+const (
+	// DriftModified means the local files have changed.
+	DriftModified = "modified"
+
+	// DriftMissing means the locked directory no longer exists.
+	DriftMissing = "missing"
+
+	// DriftUntracked means there is vendored Go source not
+	// covered by any lock entry.
+	DriftUntracked = "untracked"
+)
+
+// LockDrift describes a difference between the local files and a
+// lock file.
+type LockDrift struct {
+	// Kind is one of DriftModified, DriftMissing or
+	// DriftUntracked.
+	Kind string
+
+	// Dir is the directory, in the same form as LockEntry.Dir.
+	Dir string
+
+	// Entry is the lock entry for Dir, or nil if Kind is
+	// DriftUntracked.
+	Entry *LockEntry
+}
+
+// VerifyLock compares the local files with the entries in lock
+// belonging to this GoSource, and returns the differences found.
+func (src GoSource) VerifyLock(lock *Lock) ([]*LockDrift, error) {
+	prefix := filepath.ToSlash(src.SubPath)
+	drifts := make([]*LockDrift, 0)
+	locked := make(map[string]struct{})
+	for _, entry := range lock.Projects {
+		dir := entry.Dir
+		if prefix != "" {
+			if dir != prefix && !strings.HasPrefix(dir, prefix+"/") {
+				continue
+			}
+			dir = strings.TrimPrefix(dir[len(prefix):], "/")
+			if dir == "" {
+				dir = "."
+			}
+		}
+		locked[dir] = struct{}{}
+
+		digest, err := src.localDigest(dir)
+		switch {
+		case err == ErrorNoFiles || os.IsNotExist(err):
+			drifts = append(drifts, &LockDrift{
+				Kind:  DriftMissing,
+				Dir:   entry.Dir,
+				Entry: entry,
+			})
+		case err != nil:
+			return nil, err
+		case digest != entry.Digest:
+			drifts = append(drifts, &LockDrift{
+				Kind:  DriftModified,
+				Dir:   entry.Dir,
+				Entry: entry,
+			})
+		}
+	}
+
+	untracked, err := src.untrackedVendorDirs(locked)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range untracked {
+		drifts = append(drifts, &LockDrift{
+			Kind: DriftUntracked,
+			Dir:  path.Join(prefix, dir),
+		})
+	}
+
+	return drifts, nil
+}
+
+// untrackedVendorDirs returns the vendored directories (relative to
+// src.Path) holding Go source not within any of the locked
+// directories.
+func (src GoSource) untrackedVendorDirs(locked map[string]struct{}) ([]string, error) {
+	untracked := make([]string, 0)
+	seen := make(map[string]struct{})
+
+	// isCovered returns true if dir or a parent directory is
+	// locked or has already been reported.
+	isCovered := func(dir string) bool {
+		for d := dir; d != "." && d != "/"; d = path.Dir(d) {
+			if _, ok := locked[d]; ok {
+				return true
+			}
+			if _, ok := seen[d]; ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	walkfn := func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || !strings.HasSuffix(pth, ".go") {
+			return nil
+		}
+		imp, err := filepath.Rel(src.Vendor(), filepath.Dir(pth))
+		if err != nil {
+			return err
+		}
+		if !src.requiresImportPath(filepath.ToSlash(imp)) {
+			// Vendored for another workspace module.
+			return nil
+		}
+		rel, err := filepath.Rel(src.Path, filepath.Dir(pth))
+		if err != nil {
+			return err
+		}
+		dir := filepath.ToSlash(rel)
+		if isCovered(dir) {
+			return nil
+		}
+		seen[dir] = struct{}{}
+		untracked = append(untracked, dir)
+		return nil
+	}
+
+	if _, err := os.Stat(src.Vendor()); os.IsNotExist(err) {
+		return untracked, nil
+	}
+	if err := filepath.Walk(src.Vendor(), walkfn); err != nil {
+		return nil, err
+	}
+	return untracked, nil
+}
@@ -0,0 +1,95 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDigest(t *testing.T) {
+	a := FileHashes{"a.go": "1", "b.go": "2"}
+	b := FileHashes{"b.go": "2", "a.go": "1"}
+	if a.Digest() != b.Digest() {
+		t.Errorf("digest depends on ordering")
+	}
+	b["b.go"] = "3"
+	if a.Digest() == b.Digest() {
+		t.Errorf("digest unchanged after modification")
+	}
+}
+
+func TestLockRoundTrip(t *testing.T) {
+	src, err := NewGoSource("testdata/gosource", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock := &Lock{}
+	for _, ref := range []*Reference{
+		{Pkg: "example.com/top", Ver: "v1.0.0"},
+		{TopPkg: "example.com/top", Pkg: "github.com/foo/bar", Ver: "v1.2.0"},
+	} {
+		entry, err := src.NewLockEntry(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lock.Projects = append(lock.Projects, entry)
+	}
+
+	var buf bytes.Buffer
+	if err := lock.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = ReadLock(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.Projects[1].Dir != "vendor/github.com/foo/bar" {
+		t.Errorf("Dir: got %q", lock.Projects[1].Dir)
+	}
+	if lock.Projects[1].Ver != "v1.2.0" {
+		t.Errorf("Ver: got %q", lock.Projects[1].Ver)
+	}
+
+	// Change one digest and add a project which does not exist
+	// locally. The github.com/eggs/ham project was never locked so
+	// should be reported as untracked.
+	lock.Projects[1].Digest = "sha256:0"
+	lock.Projects = append(lock.Projects, &LockEntry{
+		Dir:       "vendor/github.com/gone",
+		Reference: Reference{Pkg: "github.com/gone"},
+	})
+	drifts, err := src.VerifyLock(lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"vendor/github.com/foo/bar":  DriftModified,
+		"vendor/github.com/gone":     DriftMissing,
+		"vendor/github.com/eggs/ham": DriftUntracked,
+	}
+	if len(drifts) != len(expected) {
+		t.Fatalf("got %d drifts, want %d", len(drifts), len(expected))
+	}
+	for _, drift := range drifts {
+		if expected[drift.Dir] != drift.Kind {
+			t.Errorf("%s: got %s, want %s", drift.Dir, drift.Kind,
+				expected[drift.Dir])
+		}
+	}
+}
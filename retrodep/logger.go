@@ -0,0 +1,231 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/op/go-logging"
+)
+
+// LogLevel is the severity of a logged message.
+type LogLevel int
+
+// Log levels, least severe first.
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarning
+	LogError
+)
+
+// String returns the name of the level, such as "debug".
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarning:
+		return "warning"
+	case LogError:
+		return "error"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// Subsystems messages are logged from.
+const (
+	// SubsystemGeneral is anything not in another subsystem,
+	// such as resolving import paths and reading manifests.
+	SubsystemGeneral = "general"
+
+	// SubsystemVCS is running VCS commands, including tracing
+	// them and the output of those which fail.
+	SubsystemVCS = "vcs"
+
+	// SubsystemHashing is finding file hashes.
+	SubsystemHashing = "hashing"
+
+	// SubsystemMatcher is comparing local files with upstream
+	// tags and revisions.
+	SubsystemMatcher = "matcher"
+)
+
+// A Logger receives the messages logged by this package. Its methods
+// may be called from several goroutines at once.
+type Logger interface {
+	// Enabled returns false if messages at level from subsystem
+	// would be discarded, so that they need not be formatted.
+	Enabled(level LogLevel, subsystem string) bool
+
+	// Log records msg, logged at level from subsystem. Fields,
+	// which may be nil, holds details such as the directory a
+	// command ran in.
+	Log(level LogLevel, subsystem, msg string, fields map[string]string)
+}
+
+// goLogger is the default Logger, which logs with
+// github.com/op/go-logging: the vcs subsystem to the "retrodep.vcs"
+// module and the others to the "retrodep" module.
+type goLogger struct {
+	general, vcs *logging.Logger
+}
+
+func newGoLogger() *goLogger {
+	return &goLogger{
+		general: logging.MustGetLogger("retrodep"),
+		vcs:     logging.MustGetLogger("retrodep.vcs"),
+	}
+}
+
+func (g *goLogger) logger(subsystem string) *logging.Logger {
+	if subsystem == SubsystemVCS {
+		return g.vcs
+	}
+	return g.general
+}
+
+// goLevels maps each LogLevel to the go-logging level.
+var goLevels = map[LogLevel]logging.Level{
+	LogDebug:   logging.DEBUG,
+	LogInfo:    logging.INFO,
+	LogWarning: logging.WARNING,
+	LogError:   logging.ERROR,
+}
+
+func (g *goLogger) Enabled(level LogLevel, subsystem string) bool {
+	return g.logger(subsystem).IsEnabledFor(goLevels[level])
+}
+
+// Log logs msg followed by any fields, sorted by name, as
+// " [name=value ...]".
+func (g *goLogger) Log(level LogLevel, subsystem, msg string, fields map[string]string) {
+	if len(fields) > 0 {
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, name := range names {
+			names[i] = name + "=" + fields[name]
+		}
+		msg += " [" + strings.Join(names, " ") + "]"
+	}
+	l := g.logger(subsystem)
+	switch level {
+	case LogDebug:
+		l.Debug(msg)
+	case LogInfo:
+		l.Info(msg)
+	case LogWarning:
+		l.Warning(msg)
+	default:
+		l.Error(msg)
+	}
+}
+
+// logger is set by SetLogger.
+var logger Logger = newGoLogger()
+
+// SetLogger makes l receive the messages logged by this package,
+// instead of github.com/op/go-logging. A nil l restores the default.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = newGoLogger()
+	}
+	logger = l
+}
+
+// subsystemLogger logs messages from a subsystem to the Logger.
+type subsystemLogger struct {
+	subsystem string
+
+	// logger, if set, is used instead of the Logger set by
+	// SetLogger.
+	logger Logger
+}
+
+var (
+	log      = subsystemLogger{subsystem: SubsystemGeneral}
+	traceLog = subsystemLogger{subsystem: SubsystemVCS}
+	hashLog  = subsystemLogger{subsystem: SubsystemHashing}
+	matchLog = subsystemLogger{subsystem: SubsystemMatcher}
+)
+
+// to returns the Logger messages are logged to.
+func (s subsystemLogger) to() Logger {
+	if s.logger == nil {
+		return logger
+	}
+	return s.logger
+}
+
+// enabled returns false if messages at level would be discarded.
+func (s subsystemLogger) enabled(level LogLevel) bool {
+	return s.to().Enabled(level, s.subsystem)
+}
+
+// logf logs the formatted message at level, with fields.
+func (s subsystemLogger) logf(level LogLevel, fields map[string]string, format string, args ...interface{}) {
+	l := s.to()
+	if !l.Enabled(level, s.subsystem) {
+		return
+	}
+	l.Log(level, s.subsystem, fmt.Sprintf(format, args...), fields)
+}
+
+func (s subsystemLogger) Debugf(format string, args ...interface{}) {
+	s.logf(LogDebug, nil, format, args...)
+}
+
+func (s subsystemLogger) Infof(format string, args ...interface{}) {
+	s.logf(LogInfo, nil, format, args...)
+}
+
+func (s subsystemLogger) Warningf(format string, args ...interface{}) {
+	s.logf(LogWarning, nil, format, args...)
+}
+
+func (s subsystemLogger) Errorf(format string, args ...interface{}) {
+	s.logf(LogError, nil, format, args...)
+}
+
+// output logs the output of a failed VCS command, stdout and then
+// stderr, each with the field "stream" naming it and any other
+// fields given as name, value pairs.
+func (s subsystemLogger) output(stdout, stderr *bytes.Buffer, keyval ...string) {
+	for _, o := range []struct {
+		stream string
+		output *bytes.Buffer
+	}{{"stdout", stdout}, {"stderr", stderr}} {
+		if o.output == nil {
+			continue
+		}
+		output := strings.TrimRight(o.output.String(), "\n")
+		if output == "" {
+			continue
+		}
+		fields := map[string]string{"stream": o.stream}
+		for i := 0; i+1 < len(keyval); i += 2 {
+			fields[keyval[i]] = keyval[i+1]
+		}
+		s.logf(LogWarning, fields, "%s", output)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type logEntry struct {
+	level     LogLevel
+	subsystem string
+	msg       string
+	fields    map[string]string
+}
+
+// recordingLogger is a Logger recording the messages at or above
+// level.
+type recordingLogger struct {
+	level   LogLevel
+	entries []logEntry
+}
+
+func (r *recordingLogger) Enabled(level LogLevel, subsystem string) bool {
+	return level >= r.level
+}
+
+func (r *recordingLogger) Log(level LogLevel, subsystem, msg string, fields map[string]string) {
+	r.entries = append(r.entries, logEntry{level, subsystem, msg, fields})
+}
+
+func TestSetLogger(t *testing.T) {
+	r := &recordingLogger{level: LogInfo}
+	SetLogger(r)
+	defer SetLogger(nil)
+
+	matchLog.Debugf("%s: trying match", "v1.0.0")
+	log.Warningf("%s: no go.mod", "github.com/foo/bar")
+	wt := &anyWorkingTree{Dir: "/tmp/wt"}
+	wt.showOutput(bytes.NewBufferString(""), bytes.NewBufferString("fatal: bad revision\n"))
+
+	exp := []logEntry{
+		{LogWarning, SubsystemGeneral, "github.com/foo/bar: no go.mod", nil},
+		{LogWarning, SubsystemVCS, "fatal: bad revision",
+			map[string]string{"stream": "stderr", "dir": "/tmp/wt"}},
+	}
+	if !reflect.DeepEqual(r.entries, exp) {
+		t.Errorf("got %v, want %v", r.entries, exp)
+	}
+	if traceLog.enabled(LogDebug) {
+		t.Error("tracing enabled")
+	}
+}
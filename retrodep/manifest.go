@@ -0,0 +1,126 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"sort"
+	"strings"
+)
+
+// ManifestClaim is the revision or version which a dependency
+// manager's manifest claims a vendored project was taken from.
+type ManifestClaim struct {
+	// File is the manifest, relative to the top-level project.
+	File string `json:"file"`
+
+	// Revision is the upstream revision claimed, if any.
+	Revision string `json:"revision,omitempty"`
+
+	// Version is the upstream tag claimed, if any.
+	Version string `json:"version,omitempty"`
+
+	// Matches is true if the vendored copy was found to match
+	// this claim.
+	Matches bool `json:"matches"`
+}
+
+// addClaim records claim for the vendored package importPath.
+func (src *GoSource) addClaim(importPath string, claim *ManifestClaim) {
+	if src.claims == nil {
+		src.claims = make(map[string]*ManifestClaim)
+	}
+	src.claims[importPath] = claim
+}
+
+// manifestClaims returns the claims for the project at root, in
+// order: a claim for root itself, then claims for packages within
+// it, then the claim for its nearest parent.
+func (src GoSource) manifestClaims(root string) []*ManifestClaim {
+	var claims []*ManifestClaim
+	if claim, ok := src.claims[root]; ok {
+		claims = append(claims, claim)
+	}
+
+	var within []string
+	var parent string
+	for importPath := range src.claims {
+		switch {
+		case strings.HasPrefix(importPath, root+"/"):
+			within = append(within, importPath)
+		case strings.HasPrefix(root, importPath+"/") &&
+			len(importPath) > len(parent):
+			parent = importPath
+		}
+	}
+	sort.Strings(within)
+	for _, importPath := range within {
+		claims = append(claims, src.claims[importPath])
+	}
+	if parent != "" {
+		claims = append(claims, src.claims[parent])
+	}
+	return claims
+}
+
+// hintsFor returns the tags and revisions to try first for the
+// project at root: those from AddHints followed by those claimed by
+// manifests.
+func (src GoSource) hintsFor(root string) []string {
+	hints := append([]string{}, src.hints[root]...)
+	seen := make(map[string]struct{})
+	for _, hint := range hints {
+		seen[hint] = struct{}{}
+	}
+	for _, claim := range src.manifestClaims(root) {
+		for _, hint := range []string{claim.Revision, claim.Version} {
+			if _, ok := seen[hint]; ok || hint == "" {
+				continue
+			}
+			seen[hint] = struct{}{}
+			hints = append(hints, hint)
+		}
+	}
+	return hints
+}
+
+// matches returns true if claim agrees with ref.
+func (claim *ManifestClaim) matches(ref *Reference) bool {
+	if claim.Revision != "" && ref.Rev != "" &&
+		(strings.HasPrefix(ref.Rev, claim.Revision) ||
+			strings.HasPrefix(claim.Revision, ref.Rev)) {
+		return true
+	}
+	return claim.Version != "" && claim.Version == ref.Tag
+}
+
+// checkManifest compares ref with the manifest claims for it, and
+// returns the claim which matches, or else the first claim, or nil
+// if there are no claims.
+func (src GoSource) checkManifest(ref *Reference) *ManifestClaim {
+	claims := src.manifestClaims(ref.Pkg)
+	if len(claims) == 0 {
+		return nil
+	}
+	for _, claim := range claims {
+		if claim.matches(ref) {
+			checked := *claim
+			checked.Matches = true
+			return &checked
+		}
+	}
+	checked := *claims[0]
+	return &checked
+}
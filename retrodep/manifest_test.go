@@ -0,0 +1,78 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHintsFor(t *testing.T) {
+	src := &GoSource{}
+	src.AddHints(Hints{"github.com/foo/bar": {"v1.0.0"}})
+	src.addClaim("github.com/foo/bar", &ManifestClaim{Revision: "abc", Version: "v1.0.0"})
+	src.addClaim("github.com/foo/bar/sub", &ManifestClaim{Revision: "def"})
+	src.addClaim("github.com/foo", &ManifestClaim{Revision: "ghi"})
+	src.addClaim("github.com/foo/barn", &ManifestClaim{Revision: "jkl"})
+
+	hints := src.hintsFor("github.com/foo/bar")
+	exp := []string{"v1.0.0", "abc", "def", "ghi"}
+	if !reflect.DeepEqual(hints, exp) {
+		t.Errorf("got %v, want %v", hints, exp)
+	}
+	if hints := src.hintsFor("github.com/eggs/ham"); len(hints) != 0 {
+		t.Errorf("unexpected hints %v", hints)
+	}
+}
+
+func TestCheckManifest(t *testing.T) {
+	src := &GoSource{}
+	src.addClaim("github.com/foo/bar", &ManifestClaim{
+		File:     "vendor/vendor.json",
+		Revision: "0123456789abcdef",
+	})
+	src.addClaim("github.com/foo/bar/sub", &ManifestClaim{
+		File:    "vendor/vendor.json",
+		Version: "v1.2.0",
+	})
+
+	tcs := []struct {
+		ref     Reference
+		matches bool
+		version string
+	}{
+		{Reference{Pkg: "github.com/foo/bar", Rev: "0123456789ab"}, true, ""},
+		{Reference{Pkg: "github.com/foo/bar", Rev: "fedcba987654", Tag: "v1.2.0"}, true, "v1.2.0"},
+		{Reference{Pkg: "github.com/foo/bar", Rev: "fedcba987654"}, false, ""},
+		{Reference{Pkg: "github.com/foo/bar"}, false, ""},
+	}
+	for _, tc := range tcs {
+		claim := src.checkManifest(&tc.ref)
+		if claim == nil {
+			t.Errorf("%v: no claim", tc.ref)
+			continue
+		}
+		if claim.Matches != tc.matches || claim.Version != tc.version {
+			t.Errorf("%v: got %+v", tc.ref, claim)
+		}
+	}
+	if src.claims["github.com/foo/bar"].Matches {
+		t.Error("claim modified")
+	}
+	if claim := src.checkManifest(&Reference{Pkg: "github.com/eggs/ham"}); claim != nil {
+		t.Errorf("unexpected claim %+v", claim)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Names of the metrics recorded, in the form Prometheus uses.
+const (
+	// MetricClones counts the working trees cloned.
+	MetricClones = "retrodep_clones_total"
+
+	// MetricCloneSeconds observes the time taken by each clone.
+	MetricCloneSeconds = "retrodep_clone_seconds"
+
+	// MetricRetries counts the clones and fetches tried again
+	// after failing.
+	MetricRetries = "retrodep_retries_total"
+
+	// MetricRevisionsTested counts the upstream tags and
+	// revisions compared with local files.
+	MetricRevisionsTested = "retrodep_revisions_tested_total"
+
+	// MetricCacheHits and MetricCacheMisses count the lookups
+	// of whole-ref file hashes in working tree caches.
+	MetricCacheHits   = "retrodep_cache_hits_total"
+	MetricCacheMisses = "retrodep_cache_misses_total"
+
+	// MetricHashBytes counts the bytes of local files hashed.
+	MetricHashBytes = "retrodep_hash_bytes_total"
+
+	// MetricDescribeSeconds observes the wall time taken to
+	// describe each project, whether top-level or vendored.
+	MetricDescribeSeconds = "retrodep_describe_seconds"
+)
+
+// Metrics receives the counters and observations recorded while
+// describing projects. Its methods may be called from several
+// goroutines at once, and should return quickly.
+type Metrics interface {
+	// Add adds n to the counter name.
+	Add(name string, n int64)
+
+	// Observe records value, such as a duration in seconds, in
+	// the histogram name.
+	Observe(name string, value float64)
+}
+
+// noMetrics is the Metrics used by default, which ignores
+// everything.
+type noMetrics struct{}
+
+func (noMetrics) Add(name string, n int64)           {}
+func (noMetrics) Observe(name string, value float64) {}
+
+// metrics is set by SetMetrics.
+var metrics Metrics = noMetrics{}
+
+// SetMetrics makes m receive the metrics recorded. A nil m stops
+// recording them.
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = noMetrics{}
+	}
+	metrics = m
+}
+
+// observeSince records the seconds since start in the histogram
+// name.
+func observeSince(name string, start time.Time) {
+	metrics.Observe(name, time.Since(start).Seconds())
+}
+
+// metricHelp holds the help text for each metric.
+var metricHelp = map[string]string{
+	MetricClones:          "Working trees cloned.",
+	MetricCloneSeconds:    "Time taken to clone each working tree.",
+	MetricRetries:         "Clones and fetches tried again after failing.",
+	MetricRevisionsTested: "Upstream tags and revisions compared with local files.",
+	MetricCacheHits:       "File hashes found in working tree caches.",
+	MetricCacheMisses:     "File hashes not found in working tree caches.",
+	MetricHashBytes:       "Bytes of local files hashed.",
+	MetricDescribeSeconds: "Wall time taken to describe each project.",
+}
+
+// DefaultBuckets are the upper bounds, in seconds, of the histogram
+// buckets used by PrometheusMetrics.
+var DefaultBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+type histogram struct {
+	counts []int64 // per bucket, then +Inf
+	sum    float64
+	count  int64
+}
+
+// PrometheusMetrics is Metrics which keeps the totals so they can be
+// written in the Prometheus text exposition format, for example for
+// the node exporter's textfile collector. The zero value is not
+// usable; use NewPrometheusMetrics.
+type PrometheusMetrics struct {
+	mu         sync.Mutex
+	buckets    []float64
+	counters   map[string]int64
+	histograms map[string]*histogram
+}
+
+// NewPrometheusMetrics returns a PrometheusMetrics whose histograms
+// have buckets with the upper bounds in buckets, or DefaultBuckets
+// if it is nil.
+func NewPrometheusMetrics(buckets []float64) *PrometheusMetrics {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &PrometheusMetrics{
+		buckets:    buckets,
+		counters:   make(map[string]int64),
+		histograms: make(map[string]*histogram),
+	}
+}
+
+// Add implements the Metrics interface.
+func (p *PrometheusMetrics) Add(name string, n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.counters[name] += n
+}
+
+// Observe implements the Metrics interface.
+func (p *PrometheusMetrics) Observe(name string, value float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.histograms[name]
+	if !ok {
+		h = &histogram{counts: make([]int64, len(p.buckets)+1)}
+		p.histograms[name] = h
+	}
+	i := sort.SearchFloat64s(p.buckets, value)
+	h.counts[i]++
+	h.sum += value
+	h.count++
+}
+
+// Write writes the metrics to w in the Prometheus text exposition
+// format, sorted by name.
+func (p *PrometheusMetrics) Write(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var names []string
+	for name := range p.counters {
+		names = append(names, name)
+	}
+	for name := range p.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if help, ok := metricHelp[name]; ok {
+			if _, err := fmt.Fprintf(w, "# HELP %s %s\n", name, help); err != nil {
+				return err
+			}
+		}
+		if n, ok := p.counters[name]; ok {
+			if _, err := fmt.Fprintf(w, "# TYPE %s counter\n%s %d\n", name, name, n); err != nil {
+				return err
+			}
+			continue
+		}
+
+		h := p.histograms[name]
+		if _, err := fmt.Fprintf(w, "# TYPE %s histogram\n", name); err != nil {
+			return err
+		}
+		var cumulative int64
+		for i, count := range h.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(p.buckets) {
+				le = strconv.FormatFloat(p.buckets[i], 'g', -1, 64)
+			}
+			if _, err := fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, le, cumulative); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", name,
+			strconv.FormatFloat(h.sum, 'g', -1, 64), name, h.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	m := NewPrometheusMetrics([]float64{1, 10})
+	m.Add(MetricClones, 1)
+	m.Add(MetricClones, 2)
+	m.Observe(MetricDescribeSeconds, 0.5)
+	m.Observe(MetricDescribeSeconds, 1)
+	m.Observe(MetricDescribeSeconds, 20)
+
+	var out strings.Builder
+	if err := m.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	exp := `# HELP retrodep_clones_total Working trees cloned.
+# TYPE retrodep_clones_total counter
+retrodep_clones_total 3
+# HELP retrodep_describe_seconds Wall time taken to describe each project.
+# TYPE retrodep_describe_seconds histogram
+retrodep_describe_seconds_bucket{le="1"} 2
+retrodep_describe_seconds_bucket{le="10"} 2
+retrodep_describe_seconds_bucket{le="+Inf"} 3
+retrodep_describe_seconds_sum 21.5
+retrodep_describe_seconds_count 3
+`
+	if out.String() != exp {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), exp)
+	}
+}
+
+func TestHashBytesMetric(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-metrics.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.go")
+	if err := ioutil.WriteFile(path, []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewPrometheusMetrics(nil)
+	SetMetrics(m)
+	defer SetMetrics(nil)
+	if _, err := (sha256Hasher{}).Hash("a.go", path); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.counters[MetricHashBytes]; n != 10 {
+		t.Errorf("hashed %d bytes", n)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// HgMirrors returns git repositories which may hold conversions of
+// the Mercurial repository for root, for use when it is no longer
+// available (for example since Bitbucket stopped hosting Mercurial
+// repositories). It returns nil if root is not a Mercurial
+// repository.
+func HgMirrors(root *vcs.RepoRoot) []*vcs.RepoRoot {
+	if root.VCS == nil || root.VCS.Cmd != vcsHg {
+		return nil
+	}
+	u, err := url.Parse(root.Repo)
+	if err != nil {
+		return nil
+	}
+	p := strings.Trim(u.Path, "/")
+	if strings.Count(p, "/") != 1 {
+		return nil
+	}
+
+	// The same owner and name, on the original host (which may
+	// have converted it) and on other hosts.
+	git := vcs.ByCmd(vcsGit)
+	var mirrors []*vcs.RepoRoot
+	for _, host := range []string{u.Host, "github.com", "gitlab.com"} {
+		mirrors = append(mirrors, &vcs.RepoRoot{
+			VCS:  git,
+			Repo: "https://" + host + "/" + p,
+			Root: root.Root,
+		})
+	}
+	return mirrors
+}
@@ -0,0 +1,52 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestHgMirrors(t *testing.T) {
+	mirrors := HgMirrors(&vcs.RepoRoot{
+		VCS:  vcs.ByCmd(vcsHg),
+		Repo: "https://bitbucket.org/ww/goautoneg",
+		Root: "bitbucket.org/ww/goautoneg",
+	})
+	expected := []string{
+		"https://bitbucket.org/ww/goautoneg",
+		"https://github.com/ww/goautoneg",
+		"https://gitlab.com/ww/goautoneg",
+	}
+	if len(mirrors) != len(expected) {
+		t.Fatalf("got %d mirrors, want %d", len(mirrors), len(expected))
+	}
+	for i, m := range mirrors {
+		if m.Repo != expected[i] || m.VCS.Cmd != vcsGit ||
+			m.Root != "bitbucket.org/ww/goautoneg" {
+			t.Errorf("%d: got %s %s (%s), want git %s", i,
+				m.VCS.Cmd, m.Repo, m.Root, expected[i])
+		}
+	}
+
+	if mirrors := HgMirrors(&vcs.RepoRoot{
+		VCS:  vcs.ByCmd(vcsGit),
+		Repo: "https://github.com/foo/bar",
+	}); mirrors != nil {
+		t.Errorf("git: got %v", mirrors)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ModuleHash returns the "h1:" hash which the go command records in
+// go.sum for the zip of the module modPath at version, whose files
+// have the HashSHA256 hashes given, relative to the module root.
+// It returns ErrorUnknownHash if hashes were computed with another
+// algorithm.
+func ModuleHash(modPath, version string, hashes FileHashes) (string, error) {
+	if len(hashes) == 0 {
+		return "", ErrorNoFiles
+	}
+	files := make([]string, 0, len(hashes))
+	for file, hash := range hashes {
+		if hash.Algorithm() != HashSHA256 {
+			return "", ErrorUnknownHash
+		}
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	prefix := modPath + "@" + version + "/"
+	for _, file := range files {
+		fmt.Fprintf(h, "%s  %s\n", hashes[file].Sum(), prefix+file)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// moduleZipFiles returns the files from all, the files of a
+// repository, which the go command puts in the zip of the module at
+// its root: those not in other modules (directories with their own
+// go.mod file) or in packages in vendor directories.
+func moduleZipFiles(all FileHashes) FileHashes {
+	var nested []string
+	for file := range all {
+		if dir := path.Dir(file); path.Base(file) == "go.mod" && dir != "." {
+			nested = append(nested, dir+"/")
+		}
+	}
+
+	files := make(FileHashes)
+files:
+	for file, hash := range all {
+		for _, dir := range nested {
+			if strings.HasPrefix(file, dir) {
+				continue files
+			}
+		}
+		if isVendoredPackage(file) {
+			continue
+		}
+		files[file] = hash
+	}
+	return files
+}
+
+// isVendoredPackage returns true if the file is in a package in a
+// vendor directory, which the go command leaves out of module zips.
+// Files directly within a vendor directory, such as
+// vendor/modules.txt, are kept.
+func isVendoredPackage(file string) bool {
+	var rest string
+	if strings.HasPrefix(file, "vendor/") {
+		rest = file[len("vendor/"):]
+	} else if i := strings.Index(file, "/vendor/"); i >= 0 {
+		rest = file[i+len("/vendor/"):]
+	} else {
+		return false
+	}
+	return strings.Contains(rest, "/")
+}
+
+// UpstreamModuleHash returns the "h1:" hash of the module zip for
+// the version found in ref, computed from the files at ref.Rev in
+// wt, a working tree of the repository at the module root. The file
+// hashes are computed with HashSHA256 for this, so wt's hash
+// algorithm is changed temporarily if need be, and no paths are
+// left out for SetHashExcludes. It returns ErrorVersionNotFound if
+// ref has no Go module version.
+func UpstreamModuleHash(ref *Reference, wt WorkingTree) (string, error) {
+	version := GoModVersion(ref)
+	if version == "" || ref.Rev == "" || version == ref.Rev {
+		return "", ErrorVersionNotFound
+	}
+	if algorithm := wt.HashAlgorithm(); algorithm != HashSHA256 {
+		if err := wt.SetHashAlgorithm(HashSHA256); err != nil {
+			return "", err
+		}
+		defer wt.SetHashAlgorithm(algorithm)
+	}
+	if all, ok := wt.(allFilesWorkingTree); ok {
+		defer all.includeAllFiles(all.includeAllFiles(true))
+	}
+	all, err := wt.FileHashesFromRef(ref.Rev, "")
+	if err != nil {
+		return "", err
+	}
+	return ModuleHash(ref.Pkg, version, moduleZipFiles(all))
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Hashes of "module example.com/m\n" and "package m\n".
+const (
+	goModHash = "sha256:535ed2ade678415f99d527a5f1eefafeb70243486ec430dbd3578048cc1bacac"
+	mGoHash   = "sha256:4aa8b7c674470695884aab61a414c5a11c9e2e283df176cad2013bd4a059a7b5"
+	mHash1    = "h1:fCHMqo5ggHEQvwcrsN81zr5orRk5lClR36KRHpfUjKg="
+)
+
+func TestModuleHash(t *testing.T) {
+	hashes := FileHashes{"go.mod": goModHash, "m.go": mGoHash}
+	h, err := ModuleHash("example.com/m", "v1.0.0", hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h != mHash1 {
+		t.Errorf("got %s, want %s", h, mHash1)
+	}
+
+	hashes["x.go"] = gitObjectHash("0123456789abcdef0123456789abcdef01234567")
+	if _, err := ModuleHash("example.com/m", "v1.0.0", hashes); err != ErrorUnknownHash {
+		t.Errorf("git hash: got %v", err)
+	}
+}
+
+func TestModuleZipFiles(t *testing.T) {
+	all := FileHashes{
+		"go.mod":                        "a",
+		"m.go":                          "b",
+		"vendor/modules.txt":            "c",
+		"vendor/example.com/x/x.go":     "d",
+		"sub/vendor/example.com/y/y.go": "e",
+		"tools/go.mod":                  "f",
+		"tools/tools.go":                "g",
+		"toolsx/x.go":                   "h",
+	}
+	exp := FileHashes{
+		"go.mod":             "a",
+		"m.go":               "b",
+		"vendor/modules.txt": "c",
+		"toolsx/x.go":        "h",
+	}
+	if files := moduleZipFiles(all); !reflect.DeepEqual(files, exp) {
+		t.Errorf("got %v, want %v", files, exp)
+	}
+}
+
+// modHashWorkingTree is a mock WorkingTree holding the files of
+// example.com/m, which records the hash algorithms used.
+type modHashWorkingTree struct {
+	stubWorkingTree
+
+	algorithms []string
+}
+
+func (wt *modHashWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	wt.algorithms = append(wt.algorithms, wt.HashAlgorithm())
+	return wt.excluding(FileHashes{"go.mod": goModHash, "m.go": mGoHash}), nil
+}
+
+func TestUpstreamModuleHash(t *testing.T) {
+	wt := &modHashWorkingTree{}
+	if err := wt.SetHashAlgorithm(HashGitSHA1); err != nil {
+		t.Fatal(err)
+	}
+	ref := &Reference{Pkg: "example.com/m", Tag: "v1.0.0", Rev: "0123456789ab", Ver: "v1.0.0"}
+	h, err := UpstreamModuleHash(ref, wt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h != mHash1 {
+		t.Errorf("got %s, want %s", h, mHash1)
+	}
+	if len(wt.algorithms) != 1 || wt.algorithms[0] != HashSHA256 ||
+		wt.HashAlgorithm() != HashGitSHA1 {
+		t.Errorf("algorithms: %v, then %s", wt.algorithms, wt.HashAlgorithm())
+	}
+
+	// Paths left out of comparisons are still in the module.
+	ignore, err := ReadIgnore(strings.NewReader("*.go\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetHashExcludes(nil)
+	SetHashExcludes(ignore)
+	if h, err := UpstreamModuleHash(ref, wt); err != nil || h != mHash1 {
+		t.Errorf("excludes: got %s, %v", h, err)
+	}
+	if wt.includeAll {
+		t.Error("still including all files")
+	}
+
+	if _, err := UpstreamModuleHash(&Reference{Pkg: "example.com/m"}, wt); err != ErrorVersionNotFound {
+		t.Errorf("no version: got %v", err)
+	}
+}
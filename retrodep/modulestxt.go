@@ -0,0 +1,237 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// modulesTxtFile is the list of vendored modules written by 'go mod
+// vendor', relative to the top-level project.
+const modulesTxtFile = "vendor/modules.txt"
+
+// VendoredModule is a module listed in vendor/modules.txt.
+type VendoredModule struct {
+	// Path is the module path.
+	Path string
+
+	// Version is the module version, or "" if it is replaced by
+	// a local directory.
+	Version string
+
+	// Replacement is the module path or directory it is replaced
+	// with, if any, and ReplacementVersion is its version.
+	Replacement        string
+	ReplacementVersion string
+
+	// Packages are the import paths of the packages vendored from
+	// the module.
+	Packages []string
+}
+
+// ReadModulesTxt parses a vendor/modules.txt file from r.
+func ReadModulesTxt(r io.Reader) ([]*VendoredModule, error) {
+	var modules []*VendoredModule
+	var module *VendoredModule
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "##"):
+			// Annotations such as "## explicit"
+		case strings.HasPrefix(line, "# "):
+			fields := strings.Fields(line[2:])
+			module = &VendoredModule{Path: fields[0]}
+			if len(fields) > 1 && fields[1] != "=>" {
+				module.Version = fields[1]
+				fields = fields[1:]
+			}
+			if len(fields) > 2 && fields[1] == "=>" {
+				module.Replacement = fields[2]
+				if len(fields) > 3 {
+					module.ReplacementVersion = fields[3]
+				}
+			}
+			modules = append(modules, module)
+		case module != nil:
+			module.Packages = append(module.Packages, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return modules, nil
+}
+
+// loadModulesTxt parses vendor/modules.txt to extract the versions
+// claimed for vendored modules.
+func loadModulesTxt(src *GoSource) error {
+	dir := src.Path
+	if src.workspace != "" {
+		// 'go work vendor' writes it beside go.work.
+		dir = src.workspace
+	}
+	conf := filepath.Join(dir, filepath.FromSlash(modulesTxtFile))
+	if _, skip := src.excludes[conf]; skip {
+		return nil
+	}
+	f, err := os.Open(conf)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	modules, err := ReadModulesTxt(f)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", conf)
+	}
+	for _, module := range modules {
+		version := module.Version
+		if module.Replacement != "" {
+			// The files are from the replacement.
+			version = module.ReplacementVersion
+		}
+		if version == "" {
+			continue
+		}
+		claim := &ManifestClaim{File: modulesTxtFile}
+		if m := pseudoVersionRE.FindStringSubmatch(version); m != nil {
+			claim.Revision = m[1]
+		} else {
+			claim.Version = strings.TrimSuffix(version, "+incompatible")
+		}
+		src.addClaim(module.Path, claim)
+	}
+	return nil
+}
+
+// VendoredModules returns a VendoredModule for each of refs, which
+// must be vendored projects of this top-level project, listing the
+// vendored directories holding Go source as its packages. Versions
+// are given in the form Go modules use (see GoModVersion), and
+// projects found in forks are replaced with them. Projects whose
+// version is not known are left out.
+func (src GoSource) VendoredModules(refs []*Reference) ([]*VendoredModule, error) {
+	roots := make(map[string]struct{})
+	for _, ref := range refs {
+		roots[ref.Pkg] = struct{}{}
+	}
+
+	var modules []*VendoredModule
+	for _, ref := range refs {
+		version := GoModVersion(ref)
+		if ref.TopPkg == "" || version == "" {
+			continue
+		}
+		module := &VendoredModule{Path: ref.Pkg, Version: version}
+		if fork := replacement(ref); fork != "" {
+			module.Replacement = fork
+			module.ReplacementVersion = version
+		}
+		pkgs, err := src.vendoredPackages(ref.Pkg, roots)
+		if err != nil {
+			return nil, err
+		}
+		module.Packages = pkgs
+		modules = append(modules, module)
+	}
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].Path < modules[j].Path
+	})
+	return modules, nil
+}
+
+// vendoredPackages returns the import paths of the directories
+// within the vendored project root which hold Go source other than
+// tests, ignoring the other vendored project roots, testdata and
+// directories which the go command ignores.
+func (src GoSource) vendoredPackages(root string, roots map[string]struct{}) ([]string, error) {
+	dir := filepath.Join(src.Vendor(), filepath.FromSlash(root))
+	seen := make(map[string]struct{})
+	err := filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, pth)
+		if err != nil {
+			return err
+		}
+		importPath := path.Join(root, filepath.ToSlash(rel))
+		if info.IsDir() {
+			name := info.Name()
+			if pth == dir {
+				return nil
+			}
+			if _, ok := roots[importPath]; ok ||
+				name == "testdata" || name == "vendor" ||
+				strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(pth, ".go") || strings.HasSuffix(pth, "_test.go") {
+			return nil
+		}
+		seen[path.Dir(importPath)] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make([]string, 0, len(seen))
+	for pkg := range seen {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	return pkgs, nil
+}
+
+// WriteModulesTxt writes modules to w in the format of
+// vendor/modules.txt, marking each as explicitly required by go.mod
+// (see WriteGoModRequire).
+func WriteModulesTxt(w io.Writer, modules []*VendoredModule) error {
+	bw := bufio.NewWriter(w)
+	for _, module := range modules {
+		fmt.Fprintf(bw, "# %s", module.Path)
+		if module.Version != "" {
+			fmt.Fprintf(bw, " %s", module.Version)
+		}
+		if module.Replacement != "" {
+			fmt.Fprintf(bw, " => %s", module.Replacement)
+			if module.ReplacementVersion != "" {
+				fmt.Fprintf(bw, " %s", module.ReplacementVersion)
+			}
+		}
+		fmt.Fprintln(bw)
+		fmt.Fprintln(bw, "## explicit")
+		for _, pkg := range module.Packages {
+			fmt.Fprintln(bw, pkg)
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,131 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testModulesTxt = `# github.com/foo/bar v1.2.0+incompatible
+## explicit
+github.com/foo/bar
+github.com/foo/bar/sub
+# github.com/eggs/ham v0.0.0-20190101000000-0123456789ab
+## explicit; go 1.12
+github.com/eggs/ham
+# example.com/local => ./local
+example.com/local
+# example.com/old v1.0.0 => github.com/me/old v1.0.1
+example.com/old
+`
+
+func TestReadModulesTxt(t *testing.T) {
+	modules, err := ReadModulesTxt(strings.NewReader(testModulesTxt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []*VendoredModule{
+		{Path: "github.com/foo/bar", Version: "v1.2.0+incompatible",
+			Packages: []string{"github.com/foo/bar", "github.com/foo/bar/sub"}},
+		{Path: "github.com/eggs/ham", Version: "v0.0.0-20190101000000-0123456789ab",
+			Packages: []string{"github.com/eggs/ham"}},
+		{Path: "example.com/local", Replacement: "./local",
+			Packages: []string{"example.com/local"}},
+		{Path: "example.com/old", Version: "v1.0.0",
+			Replacement: "github.com/me/old", ReplacementVersion: "v1.0.1",
+			Packages: []string{"example.com/old"}},
+	}
+	if !reflect.DeepEqual(modules, exp) {
+		for _, m := range modules {
+			t.Errorf("got %+v", m)
+		}
+	}
+}
+
+func TestModulesTxt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-modulestxt.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"main.go":                                 "package main // import \"example.com/top\"\n",
+		"vendor/modules.txt":                      testModulesTxt,
+		"vendor/github.com/foo/bar/bar.go":        "package bar\n",
+		"vendor/github.com/foo/bar/bar_test.go":   "package bar\n",
+		"vendor/github.com/foo/bar/sub/sub.go":    "package sub\n",
+		"vendor/github.com/foo/bar/testdata/x.go": "package x\n",
+		"vendor/github.com/foo/bar/only/README":   "",
+		"vendor/github.com/foo/bar/nested/n.go":   "package nested\n",
+	})
+
+	src, err := NewGoSource(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcs := []struct {
+		pkg      string
+		revision string
+		version  string
+	}{
+		{"github.com/foo/bar", "", "v1.2.0"},
+		{"github.com/eggs/ham", "0123456789ab", ""},
+		{"example.com/old", "", "v1.0.1"},
+	}
+	for _, tc := range tcs {
+		claim := src.claims[tc.pkg]
+		if claim == nil || claim.File != "vendor/modules.txt" ||
+			claim.Revision != tc.revision || claim.Version != tc.version {
+			t.Errorf("%s: unexpected claim %+v", tc.pkg, claim)
+		}
+	}
+	if _, ok := src.claims["example.com/local"]; ok {
+		t.Error("unexpected claim for local replacement")
+	}
+
+	refs := []*Reference{
+		{Pkg: "example.com/top", Ver: "v1.0.0"},
+		{TopPkg: "example.com/top", Pkg: "github.com/foo/bar", Tag: "v2.0.0", Ver: "v2.0.0"},
+		{TopPkg: "example.com/top", Pkg: "github.com/foo/bar/nested",
+			Repo: "https://github.com/me/nested", Ver: "v0.0.0-0.20190101000000-0123456789ab",
+			Fork: &ForkPoint{Upstream: "https://github.com/foo/nested"}},
+		{TopPkg: "example.com/top", Pkg: "github.com/gone/away"},
+	}
+	modules, err := src.VendoredModules(refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteModulesTxt(&buf, modules); err != nil {
+		t.Fatal(err)
+	}
+	exp := `# github.com/foo/bar v2.0.0+incompatible
+## explicit
+github.com/foo/bar
+github.com/foo/bar/sub
+# github.com/foo/bar/nested v0.0.0-20190101000000-0123456789ab => github.com/me/nested v0.0.0-20190101000000-0123456789ab
+## explicit
+github.com/foo/bar/nested
+`
+	if buf.String() != exp {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), exp)
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/vcs"
+)
+
+// ModuleOrigin is where a module version came from, as recorded by
+// the go command in the .info file beside its zip.
+type ModuleOrigin struct {
+	VCS    string `json:"VCS"`
+	URL    string `json:"URL"`
+	Subdir string `json:"Subdir"`
+	Ref    string `json:"Ref"`
+	Hash   string `json:"Hash"`
+}
+
+// ModuleZip is a Go module zip file, as served by a module proxy.
+type ModuleZip struct {
+	// Path is the module path.
+	Path string
+
+	// Version is the module version.
+	Version string
+
+	// File is the zip file.
+	File string
+
+	// Origin is read from the .info file beside File, if there
+	// is one recording it.
+	Origin *ModuleOrigin
+}
+
+// FindModuleZips returns the module zip files at pth, which is
+// either a zip file or a directory laid out as a module proxy or
+// $GOMODCACHE/cache/download is, in module path and version order.
+func FindModuleZips(pth string) ([]*ModuleZip, error) {
+	fi, err := os.Stat(pth)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode().IsRegular() {
+		z, err := readModuleZip(pth)
+		if err != nil {
+			return nil, err
+		}
+		return []*ModuleZip{z}, nil
+	}
+
+	download := filepath.Join(pth, "cache", "download")
+	if fi, err := os.Stat(download); err == nil && fi.IsDir() {
+		pth = download
+	}
+	var zips []*ModuleZip
+	walkfn := func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		dir := filepath.Dir(file)
+		if !info.Mode().IsRegular() || filepath.Ext(file) != ".zip" ||
+			filepath.Base(dir) != "@v" {
+			return nil
+		}
+		rel, err := filepath.Rel(pth, filepath.Dir(dir))
+		if err != nil {
+			return err
+		}
+		modPath, err := unescapeModulePath(filepath.ToSlash(rel))
+		if err != nil {
+			return errors.Wrap(err, file)
+		}
+		version, err := unescapeModulePath(strings.TrimSuffix(info.Name(), ".zip"))
+		if err != nil {
+			return errors.Wrap(err, file)
+		}
+		z := &ModuleZip{Path: modPath, Version: version, File: file}
+		z.readInfo()
+		zips = append(zips, z)
+		return nil
+	}
+	if err := filepath.Walk(pth, walkfn); err != nil {
+		return nil, err
+	}
+	sort.Slice(zips, func(i, j int) bool {
+		if zips[i].Path != zips[j].Path {
+			return zips[i].Path < zips[j].Path
+		}
+		return zips[i].Version < zips[j].Version
+	})
+	return zips, nil
+}
+
+// unescapeModulePath reverses the case encoding used for module
+// paths and versions in file names, where "!" precedes an upper case
+// letter written in lower case.
+func unescapeModulePath(escaped string) (string, error) {
+	var b strings.Builder
+	bang := false
+	for _, r := range escaped {
+		switch {
+		case bang:
+			if r < 'a' || r > 'z' {
+				return "", fmt.Errorf("invalid escaped module path %q", escaped)
+			}
+			b.WriteRune(r - 'a' + 'A')
+			bang = false
+		case r == '!':
+			bang = true
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if bang {
+		return "", fmt.Errorf("invalid escaped module path %q", escaped)
+	}
+	return b.String(), nil
+}
+
+// readModuleZip returns a *ModuleZip for file, identifying the
+// module version from the names of the files it holds.
+func readModuleZip(file string) (*ModuleZip, error) {
+	r, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if len(r.File) == 0 {
+		return nil, errors.Wrap(ErrorNoFiles, file)
+	}
+
+	// Each name starts "<path>@<version>/"
+	name := r.File[0].Name
+	at := strings.Index(name, "@")
+	if at == -1 {
+		return nil, fmt.Errorf("%s: not a module zip: %s", file, name)
+	}
+	slash := strings.Index(name[at:], "/")
+	if slash == -1 {
+		return nil, fmt.Errorf("%s: not a module zip: %s", file, name)
+	}
+	z := &ModuleZip{
+		Path:    name[:at],
+		Version: name[at+1 : at+slash],
+		File:    file,
+	}
+	z.readInfo()
+	return z, nil
+}
+
+// readInfo sets z.Origin from the .info file beside z.File, if there
+// is one recording it.
+func (z *ModuleZip) readInfo() {
+	data, err := ioutil.ReadFile(strings.TrimSuffix(z.File, ".zip") + ".info")
+	if err != nil {
+		return
+	}
+	var info struct {
+		Origin *ModuleOrigin
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		hashLog.Debugf("%s: %s", z.File, err)
+		return
+	}
+	z.Origin = info.Origin
+}
+
+// Hashes returns the hashes, computed with hasher, of the files in
+// the zip. The paths are relative to the module root.
+func (z *ModuleZip) Hashes(hasher Hasher) (FileHashes, error) {
+	dir, err := ioutil.TempDir(tempDirInUse(), "retrodep-modzip.")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := z.extract(dir); err != nil {
+		return nil, errors.Wrap(err, z.File)
+	}
+	return NewFileHashes(hasher, dir, nil)
+}
+
+// extract writes the files in the zip to dir.
+func (z *ModuleZip) extract(dir string) error {
+	r, err := zip.OpenReader(z.File)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	prefix := z.Path + "@" + z.Version + "/"
+	for _, f := range r.File {
+		if !strings.HasPrefix(f.Name, prefix) {
+			return fmt.Errorf("unexpected file %s", f.Name)
+		}
+		rel := path.Clean(f.Name[len(prefix):])
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") || path.IsAbs(rel) {
+			return fmt.Errorf("invalid file name %s", f.Name)
+		}
+		dest := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := extractFile(f, dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractFile writes the content of f to dest.
+func extractFile(f *zip.File, dest string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	w, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// RepoRoot returns the upstream repository for the module path.
+func (z *ModuleZip) RepoRoot() (*vcs.RepoRoot, error) {
+	return DefaultResolver.RepoRootForImportPath(z.Path)
+}
+
+// pseudoVersionRE matches a pseudo-version, capturing its revision.
+var pseudoVersionRE = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+-(?:[^+]*\.)?[0-9]{14}-([0-9a-f]{12,})(?:\+incompatible)?$`)
+
+// majorSuffixRE matches the major version suffix of a module path.
+var majorSuffixRE = regexp.MustCompile(`(^|/)v[0-9]+$`)
+
+// revision returns the upstream tag or revision the module version
+// claims to be: the revision of a pseudo-version, or otherwise a tag
+// named after the version in the module subdirectory modSubdir. The
+// hash in its origin is only checked against this, as the origin
+// comes from the same place as the zip.
+func (z *ModuleZip) revision(modSubdir string) (rev string, tagged bool) {
+	if m := pseudoVersionRE.FindStringSubmatch(z.Version); m != nil {
+		return m[1], false
+	}
+	return path.Join(modSubdir, strings.TrimSuffix(z.Version, "+incompatible")), true
+}
+
+// commitTagWorkingTree is a WorkingTree whose tags can name objects
+// other than commits, such as git's annotated tags.
+type commitTagWorkingTree interface {
+	// commitFromTag returns the commit the tag names.
+	commitFromTag(tag string) (string, error)
+}
+
+func (g *gitWorkingTree) commitFromTag(tag string) (string, error) {
+	stdout, stderr, err := g.run("rev-parse", "--verify", g.tagRef(tag)+"^{commit}")
+	if err != nil {
+		g.showOutput(stdout, stderr)
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// tagCommit returns the commit the tag names in wt.
+func tagCommit(wt WorkingTree, tag string) (string, error) {
+	if cwt, ok := wt.(commitTagWorkingTree); ok {
+		return cwt.commitFromTag(tag)
+	}
+	return wt.RevisionFromTag(tag)
+}
+
+// checkOrigin returns an error unless the upstream revision rev,
+// which is a tag if tagged is set, is the one the origin of z
+// records, if it records one.
+func (z *ModuleZip) checkOrigin(wt WorkingTree, rev string, tagged bool) error {
+	o := z.Origin
+	if o == nil || o.Hash == "" {
+		return nil
+	}
+	if !tagged {
+		if !strings.HasPrefix(o.Hash, rev) {
+			return fmt.Errorf("origin hash %s is not revision %s", o.Hash, rev)
+		}
+		return nil
+	}
+	if o.Ref != "" && o.Ref != "refs/tags/"+rev {
+		return fmt.Errorf("origin ref %s is not tag %s", o.Ref, rev)
+	}
+	commit, err := tagCommit(wt, rev)
+	if err != nil {
+		return errors.Wrapf(err, "resolving %s", rev)
+	}
+	if commit != o.Hash {
+		return fmt.Errorf("origin hash %s, but tag %s is %s", o.Hash, rev, commit)
+	}
+	return nil
+}
+
+// VerifyModuleZip checks that the files in z match those of the
+// upstream revision it claims to be, in the working tree wt of the
+// repository root, and that the revision is the one its origin
+// records, if any. It returns the tag or revision compared with and
+// the files, relative to the module root, which do not match.
+func VerifyModuleZip(z *ModuleZip, root *vcs.RepoRoot, wt WorkingTree) (string, []string, error) {
+	hashes, err := z.Hashes(wt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The module may be in a subdirectory of the repository, with
+	// or without its major version suffix. Tags are prefixed with
+	// the subdirectory without the suffix.
+	subdir := strings.Trim(strings.TrimPrefix(z.Path, root.Root), "/")
+	modSubdir := strings.TrimPrefix(majorSuffixRE.ReplaceAllString(subdir, ""), "/")
+	dirs := []string{subdir}
+	if z.Origin != nil && z.Origin.Subdir != "" {
+		modSubdir = z.Origin.Subdir
+		dirs = []string{modSubdir}
+	} else if modSubdir != subdir {
+		dirs = append(dirs, modSubdir)
+	}
+	rev, tagged := z.revision(modSubdir)
+	if err := z.checkOrigin(wt, rev, tagged); err != nil {
+		return rev, nil, err
+	}
+
+	bySubPath, err := FileHashesForSubPaths(wt, rev, append(dirs, ""))
+	if err != nil {
+		return rev, nil, err
+	}
+	var best []string
+	for i, dir := range dirs {
+		upstream := bySubPath[dir]
+		if _, ok := upstream["LICENSE"]; !ok {
+			// The go command adds the repository's license.
+			if license, ok := bySubPath[""]["LICENSE"]; ok {
+				upstream["LICENSE"] = license
+			}
+		}
+		mismatches := hashes.Mismatches(upstream, false)
+		if i == 0 || len(mismatches) < len(best) {
+			best = mismatches
+		}
+	}
+	sort.Strings(best)
+	return rev, best, nil
+}
@@ -0,0 +1,226 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// writeModuleZip writes a module zip to file holding files for the
+// module version path@version.
+func writeModuleZip(t *testing.T, file, modVer string, files map[string]string) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for name, content := range files {
+		fw, err := w.Create(modVer + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnescapeModulePath(t *testing.T) {
+	if p, err := unescapeModulePath("github.com/!burnt!sushi/toml"); err != nil || p != "github.com/BurntSushi/toml" {
+		t.Errorf("got %q, %v", p, err)
+	}
+	for _, bad := range []string{"github.com/!", "github.com/!B"} {
+		if _, err := unescapeModulePath(bad); err == nil {
+			t.Errorf("%s: invalid escape accepted", bad)
+		}
+	}
+}
+
+func TestFindModuleZips(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-modzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	download := filepath.Join(dir, "cache", "download")
+	toml := filepath.Join(download, "github.com", "!burnt!sushi", "toml", "@v")
+	writeModuleZip(t, filepath.Join(toml, "v0.3.1.zip"),
+		"github.com/BurntSushi/toml@v0.3.1", map[string]string{"toml.go": "package toml\n"})
+	writeFiles(t, toml, map[string]string{
+		"v0.3.1.info": `{"Version":"v0.3.1","Origin":{"VCS":"git","URL":"https://github.com/BurntSushi/toml","Hash":"3012a1dbe2e4bd1391d42b32f0577cb7bbc7f005"}}`,
+	})
+	writeModuleZip(t, filepath.Join(download, "example.com", "m", "@v", "v1.0.0.zip"),
+		"example.com/m@v1.0.0", map[string]string{"go.mod": "module example.com/m\n"})
+
+	zips, err := FindModuleZips(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zips) != 2 {
+		t.Fatalf("got %d zips", len(zips))
+	}
+	if z := zips[0]; z.Path != "example.com/m" || z.Version != "v1.0.0" || z.Origin != nil {
+		t.Errorf("got %+v", z)
+	}
+	z := zips[1]
+	if z.Path != "github.com/BurntSushi/toml" || z.Version != "v0.3.1" ||
+		z.Origin == nil || z.Origin.Hash != "3012a1dbe2e4bd1391d42b32f0577cb7bbc7f005" {
+		t.Errorf("got %+v", z)
+	}
+
+	zips, err = FindModuleZips(z.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zips) != 1 || zips[0].Path != z.Path || zips[0].Version != z.Version {
+		t.Errorf("single zip: got %+v", zips)
+	}
+}
+
+func TestModuleZipRevision(t *testing.T) {
+	tcs := []struct {
+		version string
+		rev     string
+		tagged  bool
+	}{
+		{"v1.2.3", "sub/v1.2.3", true},
+		{"v2.0.0+incompatible", "sub/v2.0.0", true},
+		{"v0.0.0-20190101000000-d4c3dbfa77a7", "d4c3dbfa77a7", false},
+		{"v1.2.4-0.20190101000000-d4c3dbfa77a7", "d4c3dbfa77a7", false},
+		{"v1.2.4-rc.1.0.20190101000000-d4c3dbfa77a7+incompatible", "d4c3dbfa77a7", false},
+	}
+	for _, tc := range tcs {
+		// The origin does not replace the claimed revision.
+		z := &ModuleZip{Version: tc.version, Origin: &ModuleOrigin{Hash: "0123456789abcdef0123456789abcdef01234567"}}
+		if rev, tagged := z.revision("sub"); rev != tc.rev || tagged != tc.tagged {
+			t.Errorf("%s: got %q,%t, want %q", tc.version, rev, tagged, tc.rev)
+		}
+	}
+}
+
+// modzipWorkingTree is a mock WorkingTree with the file hashes of
+// one tag.
+type modzipWorkingTree struct {
+	stubWorkingTree
+
+	tag    string
+	commit string
+	hashes FileHashes
+}
+
+func (wt *modzipWorkingTree) RevisionFromTag(tag string) (string, error) {
+	if tag != wt.tag {
+		return "", ErrorInvalidRef
+	}
+	return wt.commit, nil
+}
+
+func (wt *modzipWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	if ref != wt.tag {
+		return nil, ErrorInvalidRef
+	}
+	return wt.hashes.under(subPath), nil
+}
+
+func TestVerifyModuleZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-modzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	upstream := filepath.Join(dir, "upstream")
+	writeFiles(t, upstream, map[string]string{
+		"LICENSE":          "MIT License\n",
+		"sub/v2/go.mod":    "module example.com/repo/sub/v2\n",
+		"sub/v2/sub.go":    "package sub\n",
+		"sub/v2/README.md": "Only upstream\n",
+	})
+	const commit = "d4c3dbfa77a7e1b5d4c3dbfa77a7e1b5d4c3dbfa"
+	wt := &modzipWorkingTree{tag: "sub/v2.1.0", commit: commit}
+	wt.hasher = &sha256Hasher{}
+	wt.hashes, err = NewFileHashes(wt, upstream, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := &vcs.RepoRoot{Root: "example.com/repo"}
+
+	files := map[string]string{
+		"LICENSE": "MIT License\n",
+		"go.mod":  "module example.com/repo/sub/v2\n",
+		"sub.go":  "package sub\n",
+	}
+	good := &ModuleZip{Path: "example.com/repo/sub/v2", Version: "v2.1.0",
+		File: filepath.Join(dir, "good.zip")}
+	writeModuleZip(t, good.File, "example.com/repo/sub/v2@v2.1.0", files)
+	rev, mismatches, err := VerifyModuleZip(good, root, wt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != "sub/v2.1.0" || mismatches != nil {
+		t.Errorf("good: got %s, %v", rev, mismatches)
+	}
+
+	files["sub.go"] = "package sub // changed\n"
+	bad := &ModuleZip{Path: good.Path, Version: good.Version,
+		File: filepath.Join(dir, "bad.zip")}
+	writeModuleZip(t, bad.File, "example.com/repo/sub/v2@v2.1.0", files)
+	_, mismatches, err = VerifyModuleZip(bad, root, wt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(mismatches, []string{"sub.go"}) {
+		t.Errorf("bad: got %v", mismatches)
+	}
+
+	good.Origin = &ModuleOrigin{VCS: "git", Ref: "refs/tags/sub/v2.1.0", Hash: commit}
+	if _, _, err := VerifyModuleZip(good, root, wt); err != nil {
+		t.Errorf("origin: %v", err)
+	}
+	for _, o := range []ModuleOrigin{
+		// The tag must be the commit the origin claims.
+		{Hash: "0123456789abcdef0123456789abcdef01234567"},
+		{Ref: "refs/tags/sub/v2.0.0", Hash: commit},
+	} {
+		o := o
+		good.Origin = &o
+		if _, _, err := VerifyModuleZip(good, root, wt); err == nil {
+			t.Errorf("%+v: no error", o)
+		}
+	}
+	good.Origin = nil
+
+	missing := &ModuleZip{Path: good.Path, Version: "v2.2.0", File: good.File}
+	writeModuleZip(t, missing.File, "example.com/repo/sub/v2@v2.2.0", files)
+	if _, _, err := VerifyModuleZip(missing, root, wt); err != ErrorInvalidRef {
+		t.Errorf("missing tag: got %v", err)
+	}
+}
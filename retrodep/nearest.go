@@ -0,0 +1,130 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// NearMatch describes the upstream tag or revision closest to a
+// project which matched none exactly.
+type NearMatch struct {
+	// Tag is the tag, or "" if Rev is untagged.
+	Tag string `json:"tag,omitempty"`
+
+	// Rev is the upstream revision.
+	Rev string `json:"rev"`
+
+	// Ver is the semantic version or pseudo-version for Rev.
+	Ver string `json:"ver,omitempty"`
+
+	// Similarity is the percentage of the project's files which
+	// are the same in Rev.
+	Similarity float64 `json:"similarity"`
+
+	// Mismatched lists the project's files, relative to it, which
+	// differ in Rev or are missing from it.
+	Mismatched []string `json:"mismatched,omitempty"`
+}
+
+// NearestVendoredRevision finds the upstream tag or revision, in wt,
+// with the fewest files differing from the vendored copy of project,
+// for when none matches exactly. Tags are preferred to untagged
+// revisions with as many differences, and newer revisions to older.
+// It returns ErrorVersionNotFound if no revision has any of the
+// files the same.
+func (src GoSource) NearestVendoredRevision(project *RepoPath, wt WorkingTree) (*NearMatch, error) {
+	projDir := filepath.Join(src.Vendor(), filepath.FromSlash(project.Root))
+	return src.NearestRevision(project, wt, projDir)
+}
+
+// NearestRevision is NearestVendoredRevision for the project whose
+// files are in dir. Import comments are not stripped for godep.
+func (src GoSource) NearestRevision(project *RepoPath, wt WorkingTree, dir string) (*NearMatch, error) {
+	hashes, err := src.hashLocalFiles(wt, project, dir)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := wt.VersionTags()
+	if err != nil {
+		return nil, err
+	}
+	revs, err := wt.Revisions()
+	if err != nil {
+		return nil, err
+	}
+
+	var best *NearMatch
+	var bestTag string
+	tried := make(map[string]bool)
+	try := func(ref, tag string) error {
+		if tried[ref] {
+			return nil
+		}
+		tried[ref] = true
+		refHashes, err := wt.FileHashesFromRef(ref, project.SubPath)
+		if err == ErrorInvalidRef {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var mismatched []string
+		for path, hash := range hashes {
+			if refHashes[path] != hash {
+				mismatched = append(mismatched, path)
+			}
+		}
+		if len(mismatched) == len(hashes) ||
+			(best != nil && len(mismatched) >= len(best.Mismatched)) {
+			return nil
+		}
+		sort.Strings(mismatched)
+		best = &NearMatch{Rev: ref, Mismatched: mismatched}
+		bestTag = tag
+		return nil
+	}
+	for _, tag := range tags {
+		if err := try(tag, tag); err != nil {
+			return nil, err
+		}
+	}
+	for _, rev := range revs {
+		if err := try(rev, ""); err != nil {
+			return nil, err
+		}
+	}
+	if best == nil {
+		return nil, ErrorVersionNotFound
+	}
+
+	best.Similarity = 100 * float64(len(hashes)-len(best.Mismatched)) / float64(len(hashes))
+	if bestTag != "" {
+		best.Tag = bestTag
+		best.Ver = bestTag
+		best.Rev, err = wt.RevisionFromTag(bestTag)
+		if err != nil {
+			return nil, err
+		}
+		return best, nil
+	}
+	best.Ver, err = PseudoVersion(wt, best.Rev)
+	if err != nil {
+		return nil, err
+	}
+	return best, nil
+}
@@ -0,0 +1,141 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// nearWorkingTree is a mock WorkingTree whose refs have all the local
+// files, with those listed in differ changed.
+type nearWorkingTree struct {
+	stubWorkingTree
+
+	localHashes FileHashes
+	tags        []string
+	revs        []string
+	differ      map[string][]string
+}
+
+func (wt *nearWorkingTree) VersionTags() ([]string, error) {
+	return wt.tags, nil
+}
+
+func (wt *nearWorkingTree) Revisions() ([]string, error) {
+	return wt.revs, nil
+}
+
+func (wt *nearWorkingTree) RevisionFromTag(tag string) (string, error) {
+	return "rev-" + tag, nil
+}
+
+func (wt *nearWorkingTree) ReachableTag(rev string) (string, error) {
+	return "", ErrorVersionNotFound
+}
+
+func (wt *nearWorkingTree) FileHashesFromRef(ref, _ string) (FileHashes, error) {
+	differ, ok := wt.differ[ref]
+	if !ok {
+		return nil, ErrorInvalidRef
+	}
+	hashes := make(FileHashes)
+	for path, hash := range wt.localHashes {
+		hashes[path] = hash
+	}
+	for _, path := range differ {
+		hashes[path] = "changed"
+	}
+	return hashes, nil
+}
+
+func TestNearestRevision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-nearest.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"a.go": "package a\n",
+		"b.go": "package a // b\n",
+		"c.go": "package a // c\n",
+	})
+	src, err := NewGoSource(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proj := &RepoPath{RepoRoot: vcs.RepoRoot{Root: "example.com/a"}}
+	wt := &nearWorkingTree{
+		tags: []string{"v1.1.0", "v1.0.0"},
+		revs: []string{"r3", "r2", "r1"},
+	}
+	wt.hasher = &sha256Hasher{}
+	wt.localHashes, err = src.hashLocalFiles(wt, proj, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []string{"a.go", "b.go", "c.go"}
+
+	// Ties prefer tags, then newer versions.
+	wt.differ = map[string][]string{
+		"v1.1.0": files[:2],
+		"v1.0.0": files[:1],
+		"r3":     files,
+		"r2":     files[1:2],
+	}
+	near, err := src.NearestRevision(proj, wt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &NearMatch{
+		Tag:        "v1.0.0",
+		Rev:        "rev-v1.0.0",
+		Ver:        "v1.0.0",
+		Similarity: 100 * float64(len(files)-1) / float64(len(files)),
+		Mismatched: []string{"a.go"},
+	}
+	if !reflect.DeepEqual(near, expected) {
+		t.Errorf("got %+v, want %+v", near, expected)
+	}
+
+	// An untagged revision with fewer differences.
+	wt.differ["v1.0.0"] = files
+	wt.differ["r1"] = files[2:3]
+	wt.differ["r2"] = files[1:3]
+	near, err = src.NearestRevision(proj, wt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if near.Rev != "r1" || near.Tag != "" || near.Ver == "" ||
+		!reflect.DeepEqual(near.Mismatched, files[2:3]) {
+		t.Errorf("got %+v", near)
+	}
+
+	// Nothing the same.
+	wt.differ = map[string][]string{"v1.1.0": files, "v1.0.0": files}
+	wt.revs = nil
+	if near, err := src.NearestRevision(proj, wt, dir); err != ErrorVersionNotFound {
+		t.Errorf("got %+v, %v", near, err)
+	}
+
+	if _, err := src.NearestVendoredRevision(proj, wt); err == nil {
+		t.Error("expected error for missing vendored project")
+	}
+}
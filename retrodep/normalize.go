@@ -0,0 +1,241 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"go/build/constraint"
+	"go/format"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Normalizer returns the content of the file at the slash-separated
+// path, relative to the project or repository, with a normalization
+// applied. The path is "" for content which is not a file's, such as
+// the target of a symbolic link. It may be called by several
+// goroutines at once, and must not modify content.
+type Normalizer func(path string, content []byte) []byte
+
+// builtinNormalizations lists the built-in normalizations in the
+// order they are applied, before any registered ones.
+var builtinNormalizations = []struct {
+	name       string
+	normalizer Normalizer
+}{
+	{NormalizeCRLF, normalizeCRLF},
+	{NormalizeBuildTags, goNormalizer(normalizeBuildTags)},
+	{NormalizeImportComment, goNormalizer(normalizeImportComment)},
+	{NormalizeGofmt, goNormalizer(normalizeGofmt)},
+	{NormalizeTrailingSpace, normalizeTrailingSpace},
+}
+
+var (
+	normalizersMu sync.Mutex
+	normalizers   = make(map[string]Normalizer)
+)
+
+// RegisterNormalization makes NewHasher, and so -normalize, accept
+// the normalization name, applied with normalizer after the built-in
+// ones. Registered normalizations are applied in name order. A nil
+// normalizer removes the registration.
+//
+// It panics if name is built in, or contains "+" or ":".
+func RegisterNormalization(name string, normalizer Normalizer) {
+	if builtinNormalization(name) != nil || strings.ContainsAny(name, "+:") {
+		panic("retrodep: cannot register normalization " + name)
+	}
+	normalizersMu.Lock()
+	defer normalizersMu.Unlock()
+	if normalizer == nil {
+		delete(normalizers, name)
+		return
+	}
+	normalizers[name] = normalizer
+}
+
+// builtinNormalization returns the built-in Normalizer called name,
+// or nil.
+func builtinNormalization(name string) Normalizer {
+	for _, b := range builtinNormalizations {
+		if b.name == name {
+			return b.normalizer
+		}
+	}
+	return nil
+}
+
+// normalizationPipeline returns a Normalizer applying each of the
+// named normalizations, in the order described for
+// RegisterNormalization. It returns ErrorUnknownHash if any is not
+// known.
+func normalizationPipeline(names []string) (Normalizer, error) {
+	want := make(map[string]bool)
+	for _, name := range names {
+		want[name] = true
+	}
+
+	var pipeline []Normalizer
+	for _, b := range builtinNormalizations {
+		if want[b.name] {
+			pipeline = append(pipeline, b.normalizer)
+			delete(want, b.name)
+		}
+	}
+	registered := make([]string, 0, len(want))
+	for name := range want {
+		registered = append(registered, name)
+	}
+	sort.Strings(registered)
+	normalizersMu.Lock()
+	for _, name := range registered {
+		normalizer := normalizers[name]
+		if normalizer == nil {
+			normalizersMu.Unlock()
+			return nil, ErrorUnknownHash
+		}
+		pipeline = append(pipeline, normalizer)
+	}
+	normalizersMu.Unlock()
+
+	return func(path string, content []byte) []byte {
+		for _, normalizer := range pipeline {
+			content = normalizer(path, content)
+		}
+		return content
+	}, nil
+}
+
+// goNormalizer returns a Normalizer applying normalize to Go files
+// only.
+func goNormalizer(normalize func([]byte) []byte) Normalizer {
+	return func(path string, content []byte) []byte {
+		if !strings.HasSuffix(path, ".go") {
+			return content
+		}
+		return normalize(content)
+	}
+}
+
+func normalizeCRLF(_ string, content []byte) []byte {
+	return bytes.Replace(content, []byte("\r\n"), []byte("\n"), -1)
+}
+
+func normalizeTrailingSpace(_ string, content []byte) []byte {
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	for i, line := range lines {
+		eol := len(line) - len(bytes.TrimRight(line, "\r\n"))
+		trimmed := bytes.TrimRight(line[:len(line)-eol], " \t")
+		lines[i] = append(trimmed[:len(trimmed):len(trimmed)], line[len(line)-eol:]...)
+	}
+	return bytes.Join(lines, nil)
+}
+
+// normalizeImportComment removes the import comment from the package
+// clause.
+func normalizeImportComment(content []byte) []byte {
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	for i, line := range lines {
+		if !bytes.HasPrefix(line, []byte("package ")) {
+			continue
+		}
+		eol := line[len(bytes.TrimRight(line, "\r\n")):]
+		repl := removeImportComment(bytes.TrimRight(line, "\r\n"))
+		if repl == nil {
+			return content
+		}
+		lines[i] = append(repl[:len(repl):len(repl)], eol...)
+		return bytes.Join(lines, nil)
+	}
+	return content
+}
+
+func normalizeGofmt(content []byte) []byte {
+	formatted, err := format.Source(content)
+	if err != nil {
+		return content
+	}
+	return formatted
+}
+
+// normalizeBuildTags replaces the build constraints before the
+// package clause with a single //go:build line where the first of
+// them was.
+func normalizeBuildTags(content []byte) []byte {
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	var (
+		expr    constraint.Expr
+		goBuild constraint.Expr
+		first   = -1
+		keep    = make([]bool, len(lines))
+		changed bool
+	)
+	for i, line := range lines {
+		keep[i] = true
+		text := strings.TrimRight(string(line), "\r\n")
+		if strings.HasPrefix(text, "package ") {
+			for j := i + 1; j < len(lines); j++ {
+				keep[j] = true
+			}
+			break
+		}
+		if !constraint.IsGoBuild(text) && !constraint.IsPlusBuild(text) {
+			continue
+		}
+		e, err := constraint.Parse(text)
+		if err != nil {
+			return content
+		}
+		if first < 0 {
+			first = i
+		} else {
+			keep[i] = false
+			changed = true
+		}
+		switch {
+		case constraint.IsGoBuild(text):
+			goBuild = e
+		case expr == nil:
+			expr = e
+		default:
+			expr = &constraint.AndExpr{X: expr, Y: e}
+		}
+	}
+	if first < 0 {
+		return content
+	}
+	if goBuild != nil {
+		// //go:build takes precedence over // +build lines.
+		expr = goBuild
+	}
+
+	eol := lines[first][len(bytes.TrimRight(lines[first], "\r\n")):]
+	line := append([]byte("//go:build "+expr.String()), eol...)
+	if !changed && bytes.Equal(line, lines[first]) {
+		return content
+	}
+	var out []byte
+	for i, l := range lines {
+		switch {
+		case i == first:
+			out = append(out, line...)
+		case keep[i]:
+			out = append(out, l...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,139 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizations(t *testing.T) {
+	tcs := []struct {
+		name     string
+		path     string
+		content  string
+		expected string
+	}{
+		{
+			NormalizeImportComment, "a.go",
+			"// Comment\npackage foo // import \"example.com/foo\"\n\nvar x = 1\n",
+			"// Comment\npackage foo\n\nvar x = 1\n",
+		},
+		{
+			NormalizeImportComment, "a.txt",
+			"package foo // import \"example.com/foo\"\n",
+			"package foo // import \"example.com/foo\"\n",
+		},
+		{
+			NormalizeGofmt, "a.go",
+			"package foo\nfunc f( ) {\nreturn\n}\n",
+			"package foo\n\nfunc f() {\n\treturn\n}\n",
+		},
+		{
+			NormalizeGofmt, "a.go",
+			"package foo\nfunc {\n",
+			"package foo\nfunc {\n",
+		},
+		{
+			NormalizeBuildTags, "a.go",
+			"// +build linux darwin\n// +build amd64\n\npackage foo\n// +build ignored\n",
+			"//go:build (linux || darwin) && amd64\n\npackage foo\n// +build ignored\n",
+		},
+		{
+			NormalizeBuildTags, "a.go",
+			"//go:build linux\n// +build linux\n\npackage foo\n",
+			"//go:build linux\n\npackage foo\n",
+		},
+		{
+			NormalizeBuildTags, "a.go",
+			"//go:build linux\n\npackage foo\n",
+			"//go:build linux\n\npackage foo\n",
+		},
+	}
+	for _, tc := range tcs {
+		normalize, err := normalizationPipeline([]string{tc.name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		content := []byte(tc.content)
+		got := normalize(tc.path, content)
+		if string(got) != tc.expected {
+			t.Errorf("%s %s %q: got %q, want %q", tc.name, tc.path, tc.content, got, tc.expected)
+		}
+		if string(content) != tc.content {
+			t.Errorf("%s %s %q: content modified", tc.name, tc.path, tc.content)
+		}
+	}
+
+	// A vendored file rewritten in several ways matches upstream.
+	dir, err := ioutil.TempDir("", "retrodep-normalize.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"upstream/a.go": "// +build linux\r\n\r\npackage foo // import \"example.com/foo\"\r\nvar x=1\r\n",
+		"vendored/a.go": "//go:build linux\n\npackage foo\n\nvar x = 1\n",
+	})
+	h, err := NewHasher("sha256+build-tags+crlf+gofmt+import-comment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream, err := h.Hash("a.go", filepath.Join(dir, "upstream", "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vendored, err := h.Hash("a.go", filepath.Join(dir, "vendored", "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upstream != vendored {
+		t.Errorf("got %s and %s", upstream, vendored)
+	}
+}
+
+func TestRegisterNormalization(t *testing.T) {
+	RegisterNormalization("upper", func(path string, content []byte) []byte {
+		return bytes.ToUpper(content)
+	})
+	defer RegisterNormalization("upper", nil)
+
+	normalize, err := normalizationPipeline([]string{"upper", NormalizeTrailingSpace})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := normalize("a.txt", []byte("a \n")); string(got) != "A\n" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := NewHasher("sha256+upper"); err != nil {
+		t.Error(err)
+	}
+
+	RegisterNormalization("upper", nil)
+	if _, err := NewHasher("sha256+upper"); err != ErrorUnknownHash {
+		t.Errorf("unregistered: got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for built-in name")
+		}
+	}()
+	RegisterNormalization(NormalizeCRLF, normalizeCRLF)
+}
@@ -0,0 +1,223 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// licenseFileRE matches the names of files holding license terms or
+// notices which must be reproduced.
+var licenseFileRE = regexp.MustCompile(`(?i)^(licen[cs]e|copying|notice|patents)([-._].*)?$`)
+
+// copyrightRE matches a copyright statement within a comment,
+// capturing the statement without the comment markers.
+var copyrightRE = regexp.MustCompile(`(?i)^\s*(?://|/?\*+|#)?\s*(copyright\b.*?)\s*(?:\*/)?\s*$`)
+
+// NoticeFile is a license or notice file from a project.
+type NoticeFile struct {
+	// Name is the slash-separated path of the file relative to
+	// the repository root.
+	Name string
+
+	// Text is the content of the file.
+	Text string
+}
+
+// Notice holds the license files and copyright statements from the
+// upstream version of a project.
+type Notice struct {
+	Reference *Reference
+
+	// Licenses are the license and notice files, from the
+	// repository root and the directories down to the project.
+	Licenses []NoticeFile
+
+	// Copyrights are the distinct copyright statements from the
+	// header comments of the project's Go files, sorted.
+	Copyrights []string
+}
+
+// CollectNotice syncs wt to the revision in ref and returns the
+// Notice for the project at subPath within it. It returns
+// ErrorVersionNotFound if ref has no revision.
+func CollectNotice(wt WorkingTree, ref *Reference, subPath string) (*Notice, error) {
+	if ref.Rev == "" {
+		return nil, ErrorVersionNotFound
+	}
+	if err := wt.RevSync(ref.Rev); err != nil {
+		return nil, err
+	}
+
+	root := wt.Root()
+	licenses, err := projectNoticeFiles(root, subPath)
+	if err != nil {
+		return nil, err
+	}
+	notice := &Notice{Reference: ref, Licenses: licenses}
+
+	copyrights, err := findCopyrights(filepath.Join(root, subPath))
+	if err != nil {
+		return nil, err
+	}
+	notice.Copyrights = copyrights
+	return notice, nil
+}
+
+// projectNoticeFiles returns the license and notice files in root
+// and in each directory down to subPath.
+func projectNoticeFiles(root, subPath string) ([]NoticeFile, error) {
+	dirs := []string{""}
+	if subPath != "" {
+		dir := ""
+		for _, elem := range strings.Split(filepath.ToSlash(subPath), "/") {
+			dir = filepath.Join(dir, elem)
+			dirs = append(dirs, dir)
+		}
+	}
+	var files []NoticeFile
+	for _, dir := range dirs {
+		found, err := noticeFiles(root, dir)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, found...)
+	}
+	return files, nil
+}
+
+// noticeFiles returns the license and notice files in dir, which is
+// relative to root.
+func noticeFiles(root, dir string) ([]NoticeFile, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(root, dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []NoticeFile
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || !licenseFileRE.MatchString(info.Name()) {
+			continue
+		}
+		name := filepath.Join(dir, info.Name())
+		text, err := ioutil.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, NoticeFile{
+			Name: filepath.ToSlash(name),
+			Text: string(text),
+		})
+	}
+	return files, nil
+}
+
+// findCopyrights returns the distinct copyright statements in the
+// comments before the package clause of each Go file under dir,
+// ignoring vendor and hidden directories.
+func findCopyrights(dir string) ([]string, error) {
+	seen := make(map[string]struct{})
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if path != dir && (name == "vendor" || strings.HasPrefix(name, ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+		return headerCopyrights(path, seen)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	copyrights := make([]string, 0, len(seen))
+	for c := range seen {
+		copyrights = append(copyrights, c)
+	}
+	sort.Strings(copyrights)
+	return copyrights, nil
+}
+
+// headerCopyrights adds the copyright statements found before the
+// package clause in the file at path to seen.
+func headerCopyrights(path string, seen map[string]struct{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+		if matches := copyrightRE.FindStringSubmatch(line); matches != nil {
+			seen[matches[1]] = struct{}{}
+		}
+	}
+	return scanner.Err()
+}
+
+// WriteNotices writes a combined attribution file for notices to w,
+// ordered as in a Report.
+func WriteNotices(w io.Writer, notices []*Notice) error {
+	notices = append([]*Notice(nil), notices...)
+	sort.SliceStable(notices, func(i, j int) bool {
+		return referenceLess(notices[i].Reference, notices[j].Reference)
+	})
+	rule := strings.Repeat("=", 72)
+	bw := bufio.NewWriter(w)
+	for i, notice := range notices {
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		fmt.Fprintf(bw, "%s\n%s %s\n%s\n", rule,
+			notice.Reference.Pkg, notice.Reference.Ver, rule)
+		if len(notice.Copyrights) > 0 {
+			fmt.Fprintln(bw)
+			for _, c := range notice.Copyrights {
+				fmt.Fprintln(bw, c)
+			}
+		}
+		for _, file := range notice.Licenses {
+			fmt.Fprintf(bw, "\n%s:\n\n%s", file.Name, file.Text)
+			if !strings.HasSuffix(file.Text, "\n") {
+				fmt.Fprintln(bw)
+			}
+		}
+	}
+	return bw.Flush()
+}
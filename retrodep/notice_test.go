@@ -0,0 +1,84 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCollectNotice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-notice.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"LICENSE":              "MIT License\n",
+		"README.md":            "Not a license\n",
+		"sub/NOTICE.txt":       "Notice",
+		"sub/a.go":             "// Copyright 2018 A\n\npackage a\n\n// Copyright 2019 Ignored\n",
+		"sub/b.go":             "/*\n * Copyright (c) 2017 B */\npackage b\n",
+		"sub/c.go":             "// Copyright 2018 A\npackage c\n",
+		"sub/vendor/x/x.go":    "// Copyright 2000 Vendored\npackage x\n",
+		"sub/deeper/d.go":      "# not Go\n// Copyright 2019 D\npackage d\n",
+		"sub/deeper/LICENSE.d": "Deeper",
+		"other/LICENSE":        "Other",
+	}
+	writeFiles(t, dir, files)
+
+	wt := &stubWorkingTree{anyWorkingTree{Dir: dir}}
+	if _, err := CollectNotice(wt, &Reference{Pkg: "p"}, "sub"); err != ErrorVersionNotFound {
+		t.Errorf("got %v, want ErrorVersionNotFound", err)
+	}
+
+	ref := &Reference{Pkg: "p", Rev: "abc", Ver: "v1.0.0"}
+	notice, err := CollectNotice(wt, ref, "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expLicenses := []NoticeFile{
+		{"LICENSE", "MIT License\n"},
+		{"sub/NOTICE.txt", "Notice"},
+	}
+	if !reflect.DeepEqual(notice.Licenses, expLicenses) {
+		t.Errorf("licenses: got %v, want %v", notice.Licenses, expLicenses)
+	}
+	expCopyrights := []string{
+		"Copyright (c) 2017 B",
+		"Copyright 2018 A",
+		"Copyright 2019 D",
+	}
+	if !reflect.DeepEqual(notice.Copyrights, expCopyrights) {
+		t.Errorf("copyrights: got %q, want %q", notice.Copyrights, expCopyrights)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteNotices(&buf, []*Notice{notice}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, s := range []string{"p v1.0.0\n", "Copyright 2018 A\n", "sub/NOTICE.txt:\n\nNotice\n"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("%q not in output:\n%s", s, out)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+)
+
+// osvEcosystem is the OSV ecosystem of Go modules.
+const osvEcosystem = "Go"
+
+// Vulnerability is a known vulnerability affecting the version of a
+// project.
+type Vulnerability struct {
+	// ID is the identifier of the advisory, such as GO-2020-0001.
+	ID string `json:"id"`
+
+	// Aliases are other identifiers of the vulnerability, such
+	// as CVE and GHSA identifiers.
+	Aliases []string `json:"aliases,omitempty"`
+
+	// Summary is a short description of the vulnerability.
+	Summary string `json:"summary,omitempty"`
+
+	// Fixed is the earliest later version which is not affected,
+	// if there is one.
+	Fixed string `json:"fixed,omitempty"`
+}
+
+// VulnerabilityDB looks up the known vulnerabilities of Go module
+// versions.
+type VulnerabilityDB interface {
+	// Vulnerabilities returns the vulnerabilities affecting
+	// version of the module modPath, sorted by ID.
+	Vulnerabilities(modPath, version string) ([]Vulnerability, error)
+}
+
+// osvEntry is a vulnerability record in the OSV schema.
+type osvEntry struct {
+	ID        string        `json:"id"`
+	Summary   string        `json:"summary"`
+	Aliases   []string      `json:"aliases"`
+	Withdrawn string        `json:"withdrawn"`
+	Affected  []osvAffected `json:"affected"`
+}
+
+type osvAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+	} `json:"package"`
+	Ranges   []osvRange `json:"ranges"`
+	Versions []string   `json:"versions"`
+}
+
+type osvRange struct {
+	Type   string     `json:"type"`
+	Events []osvEvent `json:"events"`
+}
+
+type osvEvent struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+	Limit        string `json:"limit,omitempty"`
+}
+
+// version returns the version at which e applies.
+func (e osvEvent) version() string {
+	for _, v := range []string{e.Introduced, e.Fixed, e.LastAffected, e.Limit} {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// affects returns whether the entry applies to version of the module
+// modPath and, if so, the earliest later version which is fixed.
+func (e *osvEntry) affects(modPath string, version *semver.Version) (bool, string) {
+	if e.Withdrawn != "" {
+		return false, ""
+	}
+	affected := false
+	var fixed *semver.Version
+	for _, a := range e.Affected {
+		if a.Package.Ecosystem != osvEcosystem || a.Package.Name != modPath {
+			continue
+		}
+		for _, v := range a.Versions {
+			if av, err := semver.NewVersion(v); err == nil && av.Equal(version) {
+				affected = true
+			}
+		}
+		for _, r := range a.Ranges {
+			if r.Type != "SEMVER" {
+				continue
+			}
+			in, f := r.affects(version)
+			if !in {
+				continue
+			}
+			affected = true
+			if f != nil && (fixed == nil || f.LessThan(fixed)) {
+				fixed = f
+			}
+		}
+	}
+	if !affected || fixed == nil {
+		return affected, ""
+	}
+	return true, "v" + strings.TrimPrefix(fixed.Original(), "v")
+}
+
+// affects returns whether version is within the range and, if so,
+// the first version after it which is fixed.
+func (r osvRange) affects(version *semver.Version) (bool, *semver.Version) {
+	type event struct {
+		osvEvent
+		v *semver.Version
+	}
+	var events []event
+	for _, e := range r.Events {
+		v, err := semver.NewVersion(e.version())
+		if err != nil {
+			continue
+		}
+		events = append(events, event{e, v})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].v.LessThan(events[j].v)
+	})
+
+	in := false
+	var fixed *semver.Version
+	for _, e := range events {
+		if e.v.GreaterThan(version) {
+			if in && e.Fixed != "" && fixed == nil {
+				fixed = e.v
+			}
+			continue
+		}
+		switch {
+		case e.Introduced != "":
+			in = true
+		case e.Fixed != "", e.Limit != "":
+			in = false
+		case e.LastAffected != "":
+			in = !e.v.LessThan(version)
+		}
+	}
+	if !in {
+		return false, nil
+	}
+	return true, fixed
+}
+
+// osvVulnerabilities returns the vulnerabilities among entries which
+// affect version of the module modPath, sorted by ID. If known is
+// true the entries are already known to affect it, as when returned
+// by the OSV API, and are only examined for the version fixing them.
+func osvVulnerabilities(entries []*osvEntry, modPath, version string, known bool) ([]Vulnerability, error) {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var vulns []Vulnerability
+	for _, e := range entries {
+		affected, fixed := e.affects(modPath, v)
+		if !(affected || known) || seen[e.ID] {
+			continue
+		}
+		seen[e.ID] = true
+		vulns = append(vulns, Vulnerability{
+			ID:      e.ID,
+			Aliases: e.Aliases,
+			Summary: e.Summary,
+			Fixed:   fixed,
+		})
+	}
+	sort.Slice(vulns, func(i, j int) bool {
+		return vulns[i].ID < vulns[j].ID
+	})
+	return vulns, nil
+}
+
+// OSV is a client for the OSV vulnerability database API, such as
+// https://api.osv.dev.
+type OSV struct {
+	url    string
+	client *http.Client
+}
+
+// NewOSV returns an *OSV for the API at the base URL u.
+func NewOSV(u string) *OSV {
+	return &OSV{
+		url:    strings.TrimSuffix(u, "/"),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// osvQuery is the body of a request to the OSV query endpoint.
+type osvQuery struct {
+	Package struct {
+		Name      string `json:"name"`
+		Ecosystem string `json:"ecosystem"`
+	} `json:"package"`
+	Version   string `json:"version"`
+	PageToken string `json:"page_token,omitempty"`
+}
+
+// osvQueryResult is the response from the OSV query endpoint.
+type osvQueryResult struct {
+	Vulns         []*osvEntry `json:"vulns"`
+	NextPageToken string      `json:"next_page_token"`
+}
+
+// Vulnerabilities returns the vulnerabilities the OSV database has
+// recorded as affecting version of the module modPath.
+func (db *OSV) Vulnerabilities(modPath, version string) ([]Vulnerability, error) {
+	u := db.url + "/v1/query"
+	var query osvQuery
+	query.Package.Name = modPath
+	query.Package.Ecosystem = osvEcosystem
+	// OSV records Go module versions without the "v".
+	query.Version = strings.TrimPrefix(version, "v")
+
+	var entries []*osvEntry
+	for {
+		body, err := json.Marshal(&query)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := db.client.Post(u, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		var result osvQueryResult
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(&result)
+		} else {
+			err = fmt.Errorf("%s: %s", u, resp.Status)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, result.Vulns...)
+		if result.NextPageToken == "" {
+			break
+		}
+		query.PageToken = result.NextPageToken
+	}
+	return osvVulnerabilities(entries, modPath, version, true)
+}
+
+// OSVDatabase is a local copy of OSV vulnerability records, for
+// looking up vulnerabilities without the network.
+type OSVDatabase struct {
+	// byModule holds the entries affecting each module path.
+	byModule map[string][]*osvEntry
+}
+
+// ReadOSVDatabase reads the OSV records in the JSON files in the
+// directory or zip file at path, such as an export of the Go
+// ecosystem from https://osv-vulnerabilities.storage.googleapis.com/Go/all.zip.
+func ReadOSVDatabase(path string) (*OSVDatabase, error) {
+	db := &OSVDatabase{byModule: make(map[string][]*osvEntry)}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			if !strings.HasSuffix(f.Name, ".json") {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			err = db.add(f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return db, nil
+	}
+
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(p, ".json") {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return db.add(p, f)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// add adds the OSV record read from r, named name, to db.
+func (db *OSVDatabase) add(name string, r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var e osvEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	seen := make(map[string]bool)
+	for _, a := range e.Affected {
+		if a.Package.Ecosystem != osvEcosystem || seen[a.Package.Name] {
+			continue
+		}
+		seen[a.Package.Name] = true
+		db.byModule[a.Package.Name] = append(db.byModule[a.Package.Name], &e)
+	}
+	return nil
+}
+
+// Vulnerabilities returns the vulnerabilities in db affecting
+// version of the module modPath.
+func (db *OSVDatabase) Vulnerabilities(modPath, version string) ([]Vulnerability, error) {
+	return osvVulnerabilities(db.byModule[modPath], modPath, version, false)
+}
+
+// CheckVulnerabilities sets ref.Vulnerabilities to those db has for
+// the version of ref, as Go modules know it. It returns
+// ErrorVersionNotFound if ref has no version Go modules can use.
+func CheckVulnerabilities(db VulnerabilityDB, ref *Reference) error {
+	version := GoModVersion(ref)
+	if !strings.HasPrefix(version, "v") {
+		return ErrorVersionNotFound
+	}
+	vulns, err := db.Vulnerabilities(ref.Pkg, version)
+	if err != nil {
+		return err
+	}
+	ref.Vulnerabilities = vulns
+	return nil
+}
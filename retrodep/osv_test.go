@@ -0,0 +1,178 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testOSVRecords are OSV records for github.com/foo/bar, keyed by
+// file name.
+var testOSVRecords = map[string]string{
+	"GO-0001.json": `{
+  "id": "GO-0001",
+  "summary": "Fixed twice",
+  "aliases": ["CVE-2019-0001"],
+  "affected": [{
+    "package": {"ecosystem": "Go", "name": "github.com/foo/bar"},
+    "ranges": [{"type": "SEMVER", "events": [
+      {"introduced": "0"}, {"fixed": "1.2.0"},
+      {"introduced": "2.0.0"}, {"fixed": "2.0.1"}
+    ]}]
+  }]
+}`,
+	"GO-0002.json": `{
+  "id": "GO-0002",
+  "summary": "Unfixed",
+  "affected": [{
+    "package": {"ecosystem": "Go", "name": "github.com/foo/bar"},
+    "ranges": [{"type": "SEMVER", "events": [
+      {"introduced": "1.1.0"}, {"last_affected": "2.0.0"}
+    ]}]
+  }]
+}`,
+	"GO-0003.json": `{
+  "id": "GO-0003",
+  "withdrawn": "2019-01-01T00:00:00Z",
+  "affected": [{
+    "package": {"ecosystem": "Go", "name": "github.com/foo/bar"},
+    "ranges": [{"type": "SEMVER", "events": [{"introduced": "0"}]}]
+  }]
+}`,
+	"PYSEC-0001.json": `{
+  "id": "PYSEC-0001",
+  "affected": [{
+    "package": {"ecosystem": "PyPI", "name": "github.com/foo/bar"},
+    "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}]}]
+  }]
+}`,
+}
+
+func TestOSVDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-osv.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, filepath.Join(dir, "records"), testOSVRecords)
+
+	zipPath := filepath.Join(dir, "all.zip")
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, record := range testOSVRecords {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprint(w, record)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		version string
+		exp     []Vulnerability
+	}{
+		{"v1.0.0", []Vulnerability{
+			{ID: "GO-0001", Aliases: []string{"CVE-2019-0001"}, Summary: "Fixed twice", Fixed: "v1.2.0"},
+		}},
+		{"v1.1.0", []Vulnerability{
+			{ID: "GO-0001", Aliases: []string{"CVE-2019-0001"}, Summary: "Fixed twice", Fixed: "v1.2.0"},
+			{ID: "GO-0002", Summary: "Unfixed"},
+		}},
+		{"v1.2.0", []Vulnerability{
+			{ID: "GO-0002", Summary: "Unfixed"},
+		}},
+		{"v2.0.0+incompatible", []Vulnerability{
+			{ID: "GO-0001", Aliases: []string{"CVE-2019-0001"}, Summary: "Fixed twice", Fixed: "v2.0.1"},
+			{ID: "GO-0002", Summary: "Unfixed"},
+		}},
+		{"v2.0.1", nil},
+	}
+	for _, path := range []string{filepath.Join(dir, "records"), zipPath} {
+		db, err := ReadOSVDatabase(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range tests {
+			vulns, err := db.Vulnerabilities("github.com/foo/bar", test.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(vulns, test.exp) {
+				t.Errorf("%s %s: got %+v, want %+v", path, test.version, vulns, test.exp)
+			}
+		}
+		if vulns, err := db.Vulnerabilities("github.com/foo/other", "v1.0.0"); err != nil || vulns != nil {
+			t.Errorf("other module: got %v, %v", vulns, err)
+		}
+	}
+}
+
+func TestOSVQuery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var query osvQuery
+		if r.Method != "POST" || r.URL.Path != "/v1/query" ||
+			json.NewDecoder(r.Body).Decode(&query) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if query.Package.Ecosystem != "Go" || query.Package.Name != "github.com/foo/bar" ||
+			query.Version != "1.0.0" {
+			fmt.Fprint(w, `{}`)
+			return
+		}
+		if query.PageToken == "" {
+			fmt.Fprintf(w, `{"vulns": [%s], "next_page_token": "2"}`, testOSVRecords["GO-0002.json"])
+			return
+		}
+		fmt.Fprintf(w, `{"vulns": [%s]}`, testOSVRecords["GO-0001.json"])
+	}))
+	defer srv.Close()
+
+	ref := &Reference{Pkg: "github.com/foo/bar", Tag: "v1.0.0", Ver: "v1.0.0"}
+	if err := CheckVulnerabilities(NewOSV(srv.URL+"/"), ref); err != nil {
+		t.Fatal(err)
+	}
+	exp := []Vulnerability{
+		{ID: "GO-0001", Aliases: []string{"CVE-2019-0001"}, Summary: "Fixed twice", Fixed: "v1.2.0"},
+		{ID: "GO-0002", Summary: "Unfixed"},
+	}
+	if !reflect.DeepEqual(ref.Vulnerabilities, exp) {
+		t.Errorf("got %+v, want %+v", ref.Vulnerabilities, exp)
+	}
+
+	noVersion := &Reference{Pkg: "github.com/foo/bar"}
+	if err := CheckVulnerabilities(NewOSV(srv.URL), noVersion); err != ErrorVersionNotFound {
+		t.Errorf("no version: got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// TemplateResult is what an OutputTemplate is executed with for each
+// project. All the fields of its Reference can be used as well.
+type TemplateResult struct {
+	*Reference
+
+	// ImportPath is the import path of the project, as Pkg.
+	ImportPath string
+
+	// Version is the version found, as Ver, or "" if there is
+	// none.
+	Version string
+
+	// Matched is true if an upstream tag or revision matched.
+	Matched bool
+
+	// TopLevel is true for a top-level project rather than a
+	// vendored one.
+	TopLevel bool
+}
+
+// OutputTemplate is a text/template for writing a Report. It is
+// executed once for each project, with a TemplateResult, and may
+// define templates named "header" and "footer", executed with the
+// Report before the first project and after the last.
+type OutputTemplate struct {
+	tmpl *template.Template
+}
+
+// outputTemplateFuncs are the functions available to an
+// OutputTemplate as well as those built in to text/template.
+var outputTemplateFuncs = template.FuncMap{
+	// csv quotes a value for use as a CSV field, if needed.
+	"csv": func(s string) string {
+		if !strings.ContainsAny(s, ",\"\r\n") {
+			return s
+		}
+		return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+	},
+
+	// markdown escapes a value for use in a Markdown table cell.
+	"markdown": func(s string) string {
+		s = strings.Replace(s, `|`, `\|`, -1)
+		return strings.Replace(s, "\n", " ", -1)
+	},
+
+	"join": strings.Join,
+}
+
+// ParseOutputTemplate parses text as an OutputTemplate named name.
+func ParseOutputTemplate(name, text string) (*OutputTemplate, error) {
+	tmpl, err := template.New(name).Funcs(outputTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &OutputTemplate{tmpl: tmpl}, nil
+}
+
+// WriteTemplate writes the projects in the report to w through t,
+// ordered as by Write.
+func (r *Report) WriteTemplate(w io.Writer, t *OutputTemplate) error {
+	r.sort()
+	bw := bufio.NewWriter(w)
+	if header := t.tmpl.Lookup("header"); header != nil {
+		if err := header.Execute(bw, r); err != nil {
+			return err
+		}
+	}
+	for _, ref := range r.Projects {
+		result := &TemplateResult{
+			Reference:  ref,
+			ImportPath: ref.Pkg,
+			Version:    ref.Ver,
+			Matched:    ref.Rev != "",
+			TopLevel:   ref.TopPkg == "",
+		}
+		if err := t.tmpl.Execute(bw, result); err != nil {
+			return err
+		}
+	}
+	if footer := t.tmpl.Lookup("footer"); footer != nil {
+		if err := footer.Execute(bw, r); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
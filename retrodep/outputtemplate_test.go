@@ -0,0 +1,76 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteTemplate(t *testing.T) {
+	report := NewReport()
+	report.Add(&Reference{TopPkg: "example.com/foo", Pkg: "example.com/unknown"})
+	report.Add(&Reference{TopPkg: "example.com/foo", Pkg: "example.com/bar", Tag: "v0.2.0", Rev: "abc", Ver: "v0.2.0"})
+	report.Add(&Reference{Pkg: "example.com/foo", Rev: "def", Ver: "v1.0.0"})
+
+	tests := []struct {
+		name, text, exp string
+	}{
+		{
+			"spec",
+			`{{- if and .Matched (not .TopLevel) -}}
+Provides: bundled(golang({{.ImportPath}})) = {{.Version}}
+{{end -}}`,
+			"Provides: bundled(golang(example.com/bar)) = v0.2.0\n",
+		},
+		{
+			"markdown",
+			`{{define "header"}}| Package | Version |
+|:------- |:------- |
+{{end -}}
+{{define "footer"}}{{len .Projects}} projects
+{{end -}}
+| {{markdown .ImportPath}} | {{or .Version "?"}} |
+`,
+			"| Package | Version |\n|:------- |:------- |\n" +
+				"| example.com/foo | v1.0.0 |\n" +
+				"| example.com/bar | v0.2.0 |\n" +
+				"| example.com/unknown | ? |\n" +
+				"3 projects\n",
+		},
+		{
+			"funcs",
+			`{{define "header"}}{{csv "a,\"b\""}} {{csv "c"}} {{markdown "x|y"}}
+{{end}}`,
+			"\"a,\"\"b\"\"\" c x\\|y\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := ParseOutputTemplate(test.name, test.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := report.WriteTemplate(&buf, tmpl); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != test.exp {
+				t.Errorf("got:\n%s\nwant:\n%s", buf.String(), test.exp)
+			}
+		})
+	}
+}
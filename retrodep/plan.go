@@ -0,0 +1,119 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// TreePlan describes how NewWorkingTree would create the working
+// tree for a project, found out without cloning or hashing anything.
+type TreePlan struct {
+	// LocalRepo is the existing checkout which would be used, if
+	// LocalRepo was given.
+	LocalRepo string
+
+	// Mirrored is true if the repository cache from RepoCache
+	// already has a mirror of the repository, so only new
+	// revisions would be fetched.
+	Mirrored bool
+
+	// Tags is the number of tags which would be tried, or -1 if
+	// this is not known. Untagged revisions may also be tried.
+	Tags int
+
+	// CachedTags is the number of those tags whose file hashes
+	// would be read from the DiskCache set by SetDiskCache rather
+	// than computed.
+	CachedTags int
+}
+
+// PlanWorkingTree returns the TreePlan for project with the options
+// opts. For git repositories the tags are listed with 'git
+// ls-remote', which needs the network unless there is a local
+// checkout, but nothing is cloned.
+func PlanWorkingTree(ctx context.Context, project *vcs.RepoRoot, opts ...WorkingTreeOption) (*TreePlan, error) {
+	var config workingTreeOptions
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.credentials != "" {
+		if err := useRepoCredentials(project.Repo, config.credentials); err != nil {
+			return nil, err
+		}
+	}
+	plan := &TreePlan{Tags: -1}
+	repo := project.Repo
+	if config.localRepo != "" {
+		dir, err := filepath.Abs(config.localRepo)
+		if err != nil {
+			return nil, err
+		}
+		plan.LocalRepo = dir
+		repo = dir
+	} else if config.cacheDir != "" && project.VCS.Cmd == vcsGit {
+		_, err := os.Stat(mirrorPath(config.cacheDir, project.Repo))
+		plan.Mirrored = err == nil
+	}
+	if project.VCS.Cmd != vcsGit {
+		return plan, nil
+	}
+
+	env := config.env
+	args := append(gitConfigArgs(project.Repo), gitAuthArgs(project.Repo)...)
+	args = append(args, "ls-remote", "--tags", "--", repo)
+	p := withEnv(env.command(vcsGit, args...), gitAuthEnv(project.Repo))
+	stdout, stderr, err := runProcess(ctx, p, ".")
+	if err != nil {
+		output := strings.TrimSpace(stdout.String() + stderr.String())
+		env.log(SubsystemVCS).Debugf("%s: %s", project.Repo, output)
+		return nil, newCloneError(project.Repo, err, output)
+	}
+
+	algorithm := config.algorithm
+	if algorithm == "" {
+		algorithm = HashGitSHA1
+	}
+	cache := env.diskCache()
+	tagRef := "refs/tags/" + config.tagPrefix
+	plan.Tags = 0
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 ||
+			!strings.HasPrefix(fields[1], tagRef) ||
+			strings.HasSuffix(fields[1], "^{}") {
+			continue
+		}
+		plan.Tags++
+		if cache == nil {
+			continue
+		}
+		// Entries are keyed by the tag's object, as from
+		// 'git rev-parse'.
+		key := remoteCacheKey(project.Repo, algorithm, fields[0])
+		if _, err := os.Stat(cache.path(key)); err == nil {
+			plan.CachedTags++
+		}
+	}
+	return plan, scanner.Err()
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestPlanWorkingTree(t *testing.T) {
+	var lsRemote []string
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		lsRemote = args
+		return exec.Command("printf", "%s", `1111111111111111111111111111111111111111	refs/tags/v1.0.0
+2222222222222222222222222222222222222222	refs/tags/v1.1.0
+3333333333333333333333333333333333333333	refs/tags/v1.1.0^{}
+4444444444444444444444444444444444444444	refs/tags/api/v0.1.0
+`)
+	}
+
+	dir, err := ioutil.TempDir("", "retrodep-plan.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache := NewDiskCache(dir)
+	SetDiskCache(cache)
+	defer SetDiskCache(nil)
+	const repo = "https://github.com/example/name"
+	key := remoteCacheKey(repo, HashGitSHA1, "2222222222222222222222222222222222222222")
+	if err := cache.Put(key, FileHashes{"a.go": "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	project := &vcs.RepoRoot{VCS: vcs.ByCmd(vcsGit), Repo: repo, Root: "github.com/example/name"}
+	plan, err := PlanWorkingTree(context.Background(), project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Tags != 3 || plan.CachedTags != 1 || plan.Mirrored || plan.LocalRepo != "" {
+		t.Errorf("got %+v", *plan)
+	}
+	if lsRemote[len(lsRemote)-1] != repo {
+		t.Errorf("listed %v", lsRemote)
+	}
+
+	plan, err = PlanWorkingTree(context.Background(), project, TagPrefix("api/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Tags != 1 || plan.CachedTags != 0 {
+		t.Errorf("api/: got %+v", *plan)
+	}
+
+	local := filepath.Join(dir, "checkout")
+	plan, err = PlanWorkingTree(context.Background(), project, LocalRepo(local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.LocalRepo != local {
+		t.Errorf("local: got %+v", *plan)
+	}
+	if lsRemote[len(lsRemote)-1] != local {
+		t.Errorf("local: listed %v", lsRemote)
+	}
+}
+
+func TestPlanWorkingTreeNotGit(t *testing.T) {
+	project := &vcs.RepoRoot{VCS: vcs.ByCmd(vcsHg), Repo: "https://example.com/hg", Root: "example.com/hg"}
+	plan, err := PlanWorkingTree(context.Background(), project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Tags != -1 {
+		t.Errorf("got %+v", *plan)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/Masterminds/semver"
+)
+
+// Rules which a PolicyViolation can be for.
+const (
+	// RuleReleasesBehind is for Policy.MaxReleasesBehind.
+	RuleReleasesBehind = "releases-behind"
+
+	// RuleDaysBehind is for Policy.MaxDaysBehind.
+	RuleDaysBehind = "days-behind"
+
+	// RuleUntagged is for Policy.RequireTag.
+	RuleUntagged = "untagged"
+)
+
+// Policy holds rules which the versions found must satisfy. Rules
+// which are not set are not evaluated.
+type Policy struct {
+	// MaxReleasesBehind is the number of upstream releases newer
+	// than the version found which are allowed.
+	MaxReleasesBehind *int `json:"maxReleasesBehind,omitempty"`
+
+	// MaxDaysBehind is the number of days the revision found may
+	// be older than the latest upstream release.
+	MaxDaysBehind *int `json:"maxDaysBehind,omitempty"`
+
+	// RequireTag means the version found must be a tag rather
+	// than an untagged commit.
+	RequireTag bool `json:"requireTag,omitempty"`
+}
+
+// PolicyViolation describes a project which does not satisfy a
+// Policy rule.
+type PolicyViolation struct {
+	// Pkg is the import path of the project.
+	Pkg string `json:"pkg"`
+
+	// Rule is one of the Rule... constants.
+	Rule string `json:"rule"`
+
+	// Message explains the violation.
+	Message string `json:"message"`
+}
+
+func (v *PolicyViolation) Error() string {
+	return v.Pkg + ": " + v.Message
+}
+
+// ReadPolicy reads a JSON policy from r.
+func ReadPolicy(r io.Reader) (*Policy, error) {
+	var policy Policy
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// Evaluate returns the violations of the policy by ref, the project
+// found in the upstream wt.
+func (p *Policy) Evaluate(ref *Reference, wt WorkingTree) ([]*PolicyViolation, error) {
+	if ref.Ver == "" {
+		return nil, nil
+	}
+
+	var violations []*PolicyViolation
+	violate := func(rule, format string, args ...interface{}) {
+		violations = append(violations, &PolicyViolation{
+			Pkg:     ref.Pkg,
+			Rule:    rule,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if p.RequireTag && ref.Tag == "" {
+		violate(RuleUntagged, "%s is not a tag", ref.Ver)
+	}
+	if p.MaxReleasesBehind == nil && p.MaxDaysBehind == nil {
+		return violations, nil
+	}
+
+	current, err := semver.NewVersion(ref.Ver)
+	if err != nil {
+		// Releases cannot be counted.
+		return violations, nil
+	}
+	tags, err := wt.VersionTags()
+	if err != nil {
+		return nil, err
+	}
+	newer := 0
+	for _, tag := range tags {
+		v, err := semver.NewVersion(tag)
+		if err == nil && v.Prerelease() == "" && v.GreaterThan(current) {
+			newer++
+		}
+	}
+	if newer == 0 {
+		return violations, nil
+	}
+
+	latest := latestRelease(tags)
+	if max := p.MaxReleasesBehind; max != nil && newer > *max {
+		violate(RuleReleasesBehind, "%s is %d releases behind %s",
+			ref.Ver, newer, latest)
+	}
+	if max := p.MaxDaysBehind; max != nil && ref.Rev != "" {
+		latestRev, err := wt.RevisionFromTag(latest)
+		if err != nil {
+			return nil, err
+		}
+		latestTime, err := wt.TimeFromRevision(latestRev)
+		if err != nil {
+			return nil, err
+		}
+		currentTime, err := wt.TimeFromRevision(ref.Rev)
+		if err != nil {
+			return nil, err
+		}
+		days := int(latestTime.Sub(currentTime).Hours() / 24)
+		if days > *max {
+			violate(RuleDaysBehind, "%s is %d days behind %s",
+				ref.Ver, days, latest)
+		}
+	}
+	return violations, nil
+}
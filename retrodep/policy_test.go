@@ -0,0 +1,119 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// policyWorkingTree is a mock WorkingTree with a revision per day.
+type policyWorkingTree struct {
+	stubWorkingTree
+}
+
+func (wt *policyWorkingTree) VersionTags() ([]string, error) {
+	return []string{"v1.3.0-rc1", "v1.2.0", "v1.1.0", "v1.0.0"}, nil
+}
+
+func (wt *policyWorkingTree) RevisionFromTag(tag string) (string, error) {
+	return map[string]string{
+		"v1.2.0": "r100",
+		"v1.1.0": "r50",
+		"v1.0.0": "r0",
+	}[tag], nil
+}
+
+func (wt *policyWorkingTree) TimeFromRevision(rev string) (time.Time, error) {
+	days := map[string]int{"r100": 100, "r50": 50, "r0": 0, "r10": 10}[rev]
+	return time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, days), nil
+}
+
+func TestReadPolicy(t *testing.T) {
+	p, err := ReadPolicy(strings.NewReader(`{"maxReleasesBehind": 0, "requireTag": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.MaxReleasesBehind == nil || *p.MaxReleasesBehind != 0 ||
+		p.MaxDaysBehind != nil || !p.RequireTag {
+		t.Errorf("unexpected policy %+v", p)
+	}
+
+	if _, err := ReadPolicy(strings.NewReader(`{"maxReleases": 1}`)); err == nil {
+		t.Error("unknown field accepted")
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	one, sixty := 1, 60
+	tcs := []struct {
+		name   string
+		policy Policy
+		ref    Reference
+		rules  []string
+	}{
+		{
+			"latest",
+			Policy{MaxReleasesBehind: new(int), MaxDaysBehind: new(int), RequireTag: true},
+			Reference{Tag: "v1.2.0", Rev: "r100", Ver: "v1.2.0"},
+			nil,
+		},
+		{
+			"releases",
+			Policy{MaxReleasesBehind: &one},
+			Reference{Tag: "v1.0.0", Rev: "r0", Ver: "v1.0.0"},
+			[]string{RuleReleasesBehind},
+		},
+		{
+			"days",
+			Policy{MaxReleasesBehind: &one, MaxDaysBehind: &sixty},
+			Reference{Tag: "v1.1.0", Rev: "r50", Ver: "v1.1.0"},
+			nil,
+		},
+		{
+			"untagged",
+			Policy{RequireTag: true, MaxDaysBehind: &sixty},
+			Reference{Rev: "r10", Ver: "v1.0.1-0.20190111000000-r10"},
+			[]string{RuleUntagged, RuleDaysBehind},
+		},
+		{
+			"unknown",
+			Policy{RequireTag: true},
+			Reference{},
+			nil,
+		},
+	}
+
+	wt := &policyWorkingTree{}
+	for _, tc := range tcs {
+		tc.ref.Pkg = "p"
+		violations, err := tc.policy.Evaluate(&tc.ref, wt)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if len(violations) != len(tc.rules) {
+			t.Errorf("%s: got %v, want %v", tc.name, violations, tc.rules)
+			continue
+		}
+		for i, v := range violations {
+			if v.Rule != tc.rules[i] || v.Pkg != "p" {
+				t.Errorf("%s: got %v, want %s", tc.name, v, tc.rules[i])
+			}
+		}
+	}
+}
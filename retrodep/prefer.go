@@ -0,0 +1,97 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"fmt"
+
+	"github.com/Masterminds/semver"
+)
+
+// TagChooser returns the tag to report for project from tags, which
+// all match its files identically. There are at least two tags,
+// sorted newest first.
+type TagChooser func(project *RepoPath, tags []string) (string, error)
+
+// Policies for TagPolicy.
+const (
+	// PreferOldest chooses the oldest semver tag which is not a
+	// prerelease, or else the oldest tag. This is the default.
+	PreferOldest = "oldest"
+
+	// PreferNewest chooses the newest semver tag which is not a
+	// prerelease, or else the newest tag.
+	PreferNewest = "newest"
+)
+
+// TagPolicy returns the TagChooser for the named policy, one of the
+// Prefer... constants.
+func TagPolicy(name string) (TagChooser, error) {
+	switch name {
+	case PreferOldest:
+		return func(_ *RepoPath, tags []string) (string, error) {
+			return chooseBestTag(tags), nil
+		}, nil
+	case PreferNewest:
+		return func(_ *RepoPath, tags []string) (string, error) {
+			return chooseNewestTag(tags), nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown tag policy %q", name)
+	}
+}
+
+// chooseNewestTag takes a sorted list of tags and returns the newest
+// semver tag which is not a prerelease, or else the newest tag.
+func chooseNewestTag(tags []string) string {
+	for _, tag := range tags {
+		v, err := semver.NewVersion(tag)
+		if err == nil && v.Prerelease() == "" {
+			matchLog.Debugf("newest from %v: %v (no prerelease)", tags, tag)
+			return tag
+		}
+	}
+
+	matchLog.Debugf("newest from %v: %v", tags, tags[0])
+	return tags[0]
+}
+
+// SetTagChooser makes choose pick which tag to report when several
+// match a project identically, in place of PreferOldest.
+func (src *GoSource) SetTagChooser(choose TagChooser) {
+	src.chooseTag = choose
+}
+
+// chooseMatchingTag returns the tag to report for project from the
+// sorted list of tags matching it.
+func (src GoSource) chooseMatchingTag(project *RepoPath, tags []string) (string, error) {
+	if len(tags) == 1 {
+		return tags[0], nil
+	}
+	if src.chooseTag == nil {
+		return chooseBestTag(tags), nil
+	}
+	tag, err := src.chooseTag(project, tags)
+	if err != nil {
+		return "", err
+	}
+	for _, t := range tags {
+		if t == tag {
+			return tag, nil
+		}
+	}
+	return "", fmt.Errorf("%s: chosen tag %s does not match", project.Root, tag)
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestTagPolicy(t *testing.T) {
+	tags := []string{"v1.3.0-rc1", "v1.2.1", "v1.2.0", "v1.2.0-rc1"}
+	for policy, exp := range map[string]string{
+		PreferOldest: "v1.2.0",
+		PreferNewest: "v1.2.1",
+	} {
+		choose, err := TagPolicy(policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tag, err := choose(nil, tags); err != nil || tag != exp {
+			t.Errorf("%s: got %q, %v; want %q", policy, tag, err, exp)
+		}
+	}
+
+	choose, _ := TagPolicy(PreferNewest)
+	if tag, _ := choose(nil, []string{"foo", "bar"}); tag != "foo" {
+		t.Errorf("newest without semver: got %q", tag)
+	}
+	if _, err := TagPolicy("latest"); err == nil {
+		t.Error("unknown policy: no error")
+	}
+}
+
+func TestChooseMatchingTag(t *testing.T) {
+	project := &RepoPath{RepoRoot: vcs.RepoRoot{Root: "example.com/foo"}}
+	tags := []string{"v1.2.1", "v1.2.0"}
+	var src GoSource
+	if tag, err := src.chooseMatchingTag(project, tags); err != nil || tag != "v1.2.0" {
+		t.Errorf("default: got %q, %v", tag, err)
+	}
+
+	asked := 0
+	src.SetTagChooser(func(p *RepoPath, tags []string) (string, error) {
+		asked++
+		return "v1.2.1", nil
+	})
+	if tag, err := src.chooseMatchingTag(project, tags); err != nil || tag != "v1.2.1" {
+		t.Errorf("chooser: got %q, %v", tag, err)
+	}
+	if tag, err := src.chooseMatchingTag(project, tags[1:]); err != nil || tag != "v1.2.0" || asked != 1 {
+		t.Errorf("single tag: got %q, %v, asked %d times", tag, err, asked)
+	}
+
+	src.SetTagChooser(func(p *RepoPath, tags []string) (string, error) {
+		return "v9.9.9", nil
+	})
+	if _, err := src.chooseMatchingTag(project, tags); err == nil {
+		t.Error("unmatched choice: no error")
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A ProbingWorkingTree is a WorkingTree which can find the file
+// hashes of a few files at many refs much more cheaply than
+// FileHashesFromRef can find those of every file at each.
+type ProbingWorkingTree interface {
+	WorkingTree
+
+	// ProbeFileHashes returns the file hashes of those of paths,
+	// relative to the root of the repository, which are present
+	// at each of refs, keyed by ref. The path "" is the root
+	// directory, which is present at every valid ref. It returns
+	// errNoProbe if the hashes would differ from those from
+	// FileHashesFromRef.
+	ProbeFileHashes(refs, paths []string) (map[string]FileHashes, error)
+}
+
+// errNoProbe is returned by ProbeFileHashes if the working tree
+// cannot probe file hashes.
+var errNoProbe = errors.New("cannot probe file hashes")
+
+// probeFiles is the number of files probed at each ref.
+const probeFiles = 4
+
+// probePaths returns up to probeFiles of the paths in hashes, spread
+// evenly through them in sorted order.
+func probePaths(hashes FileHashes) []string {
+	paths := make([]string, 0, len(hashes))
+	for p := range hashes {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	if len(paths) <= probeFiles {
+		return paths
+	}
+	probes := make([]string, probeFiles)
+	for i := range probes {
+		probes[i] = paths[i*(len(paths)-1)/(probeFiles-1)]
+	}
+	return probes
+}
+
+// layoutProber rejects refs for layouts by comparing the file
+// hashes of a few of their files at every ref at once, so that
+// FileHashesFromRef is only needed for refs which may match.
+type layoutProber struct {
+	// probes holds the paths probed for each layout, relative to
+	// the root of the repository, and the local file hashes
+	// expected there.
+	probes []FileHashes
+
+	// found maps each ref to the file hashes probed there.
+	found map[string]FileHashes
+}
+
+// probeTree is the path probed to find whether a ref is valid: the
+// top-level tree.
+const probeTree = ""
+
+// newLayoutProber returns a layoutProber for layouts at refs in wt,
+// or nil if wt cannot probe file hashes.
+func newLayoutProber(wt WorkingTree, layouts []moduleLayout, refs []string) *layoutProber {
+	pwt, ok := wt.(ProbingWorkingTree)
+	if !ok || len(refs) < 2 {
+		return nil
+	}
+	p := &layoutProber{}
+	var paths []string
+	for _, layout := range layouts {
+		probes := make(FileHashes)
+		for _, local := range probePaths(layout.hashes) {
+			repoPath := path.Join(filepath.ToSlash(layout.subPath), filepath.ToSlash(local))
+			probes[repoPath] = layout.hashes[local]
+			paths = append(paths, repoPath)
+		}
+		p.probes = append(p.probes, probes)
+	}
+	found, err := pwt.ProbeFileHashes(refs, append(paths, probeTree))
+	if err != nil {
+		if err != errNoProbe {
+			matchLog.Debugf("probing file hashes: %s", err)
+		}
+		return nil
+	}
+	p.found = found
+	return p
+}
+
+// valid returns false if ref is known not to be a valid ref.
+func (p *layoutProber) valid(ref string) bool {
+	if p == nil {
+		return true
+	}
+	_, ok := p.found[ref][probeTree]
+	return ok
+}
+
+// mayMatch returns false if the local files of the layout with
+// index i cannot match ref.
+func (p *layoutProber) mayMatch(i int, ref string) bool {
+	if p == nil {
+		return true
+	}
+	found := p.found[ref]
+	for repoPath, hash := range p.probes[i] {
+		if found[repoPath] != hash {
+			return false
+		}
+	}
+	return true
+}
+
+// ProbeFileHashes looks up the object IDs of paths at every one of
+// refs with a single 'git cat-file --batch-check'. These are only
+// the file hashes when they are git's own object IDs for the files
+// of the repository alone.
+func (g *gitWorkingTree) ProbeFileHashes(refs, paths []string) (map[string]FileHashes, error) {
+	if g.algorithm != g.nativeAlgorithm || g.exportAttributes ||
+		g.lfs || g.submodules != nil {
+		return nil, errNoProbe
+	}
+
+	var input bytes.Buffer
+	type object struct{ ref, path string }
+	var objects []object
+	for _, ref := range refs {
+		for _, p := range paths {
+			if strings.ContainsAny(p, "\n") {
+				continue
+			}
+			objects = append(objects, object{ref, p})
+			input.WriteString(g.tagRef(ref) + ":" + p + "\n")
+		}
+	}
+	stdout, stderr, err := g.runInput(&input, "cat-file", "--batch-check")
+	if err != nil {
+		g.showOutput(stdout, stderr)
+		return nil, err
+	}
+
+	found := make(map[string]FileHashes, len(refs))
+	scanner := bufio.NewScanner(stdout)
+	for i := 0; scanner.Scan(); i++ {
+		if i >= len(objects) {
+			return nil, errors.New("unexpected 'git cat-file' output")
+		}
+		// <object> SP <type> SP <size>, or <name> SP missing
+		fields := strings.Fields(scanner.Text())
+		obj := objects[i]
+		if len(fields) != 3 ||
+			(fields[1] != "blob" && (obj.path != probeTree || fields[1] != "tree")) {
+			continue
+		}
+		if found[obj.ref] == nil {
+			found[obj.ref] = make(FileHashes)
+		}
+		found[obj.ref][obj.path] = gitObjectHash(fields[0])
+	}
+	return found, scanner.Err()
+}
@@ -0,0 +1,143 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestProbePaths(t *testing.T) {
+	hashes := make(FileHashes)
+	for _, p := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		hashes[p] = "1"
+	}
+	if probes, exp := probePaths(hashes), []string{"a", "c", "e", "g"}; !reflect.DeepEqual(probes, exp) {
+		t.Errorf("got %v, want %v", probes, exp)
+	}
+	if probes := probePaths(FileHashes{"a": "1"}); !reflect.DeepEqual(probes, []string{"a"}) {
+		t.Errorf("got %v", probes)
+	}
+}
+
+// probingWorkingTree has foo.go at "match" only, and records the
+// refs whose file hashes are fetched in full.
+type probingWorkingTree struct {
+	stubWorkingTree
+
+	fetched []string
+}
+
+func (wt *probingWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	wt.fetched = append(wt.fetched, ref)
+	if ref == "match" {
+		return FileHashes{"foo.go": "1"}, nil
+	}
+	return FileHashes{"foo.go": "2"}, nil
+}
+
+func (wt *probingWorkingTree) ProbeFileHashes(refs, paths []string) (map[string]FileHashes, error) {
+	found := make(map[string]FileHashes)
+	for _, ref := range refs {
+		if ref == "invalid" {
+			continue
+		}
+		found[ref] = FileHashes{"": "tree", "sub/foo.go": "2"}
+		if ref == "match" {
+			found[ref]["sub/foo.go"] = "1"
+		}
+	}
+	return found, nil
+}
+
+func TestMatchLayoutsProbe(t *testing.T) {
+	wt := &probingWorkingTree{}
+	layouts := []moduleLayout{{FileHashes{"foo.go": "1"}, "sub"}}
+	matches, err := matchLayoutsFromRefs(false, layouts, wt,
+		[]string{"new", "invalid", "match", "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(matches, []string{"match"}) {
+		t.Errorf("matched %v", matches)
+	}
+	if !reflect.DeepEqual(wt.fetched, []string{"match"}) {
+		t.Errorf("fetched %v", wt.fetched)
+	}
+}
+
+func TestGitProbeFileHashes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-probe.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stdin := filepath.Join(dir, "stdin")
+
+	var args []string
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(command string, a ...string) *exec.Cmd {
+		args = a
+		return exec.Command("sh", "-c", `cat >"$0"; printf "%s" "$1"`,
+			stdin, "1111111111111111111111111111111111111111 tree 10\n"+
+				"2222222222222222222222222222222222222222 blob 5\n"+
+				"v1.0.0: missing\n"+
+				"v1.0.0:foo.go missing\n")
+	}
+
+	wt := gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			VCS:             vcs.ByCmd(vcsGit),
+			algorithm:       HashGitSHA1,
+			nativeAlgorithm: HashGitSHA1,
+		},
+	}
+	found, err := wt.ProbeFileHashes([]string{"0123456789abcdef", "v1.0.0"}, []string{"", "foo.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]FileHashes{
+		"0123456789abcdef": {
+			"":       "1111111111111111111111111111111111111111",
+			"foo.go": "2222222222222222222222222222222222222222",
+		},
+	}
+	if !reflect.DeepEqual(found, exp) {
+		t.Errorf("got %v, want %v", found, exp)
+	}
+	if exp := []string{"cat-file", "--batch-check"}; !reflect.DeepEqual(args, exp) {
+		t.Errorf("ran %v, want %v", args, exp)
+	}
+	input, err := ioutil.ReadFile(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "0123456789abcdef:\n0123456789abcdef:foo.go\nv1.0.0:\nv1.0.0:foo.go\n"; string(input) != exp {
+		t.Errorf("input %q, want %q", input, exp)
+	}
+
+	// Other hashes cannot be probed.
+	wt.algorithm = HashSHA256
+	if _, err := wt.ProbeFileHashes(nil, nil); err != errNoProbe {
+		t.Errorf("got %v", err)
+	}
+}
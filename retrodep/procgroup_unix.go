@@ -0,0 +1,42 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !windows
+// +build !windows
+
+package retrodep
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes p start a process group of its own, so that
+// killProcess kills the processes p starts as well.
+func setProcessGroup(p *exec.Cmd) {
+	if p.SysProcAttr == nil {
+		p.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	p.SysProcAttr.Setpgid = true
+}
+
+// killProcess kills the started command p and the other processes
+// in its process group.
+func killProcess(p *exec.Cmd) error {
+	if err := syscall.Kill(-p.Process.Pid, syscall.SIGKILL); err != nil {
+		return p.Process.Kill()
+	}
+	return nil
+}
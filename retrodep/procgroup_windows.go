@@ -0,0 +1,35 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// setProcessGroup does nothing on Windows, where killProcess finds
+// the processes p starts by their parent.
+func setProcessGroup(p *exec.Cmd) {}
+
+// killProcess kills the started command p and the processes it
+// started.
+func killProcess(p *exec.Cmd) error {
+	pid := strconv.Itoa(p.Process.Pid)
+	if exec.Command("taskkill", "/T", "/F", "/PID", pid).Run() != nil {
+		return p.Process.Kill()
+	}
+	return nil
+}
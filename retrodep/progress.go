@@ -0,0 +1,64 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+// A ProgressReporter is told how long-running work is going, so that
+// it can be shown to the user. Its methods may be called from several
+// goroutines at once, and should return quickly.
+type ProgressReporter interface {
+	// CloneStarted is called when cloning repo starts.
+	CloneStarted(repo string)
+
+	// CloneFinished is called when cloning repo finishes, with
+	// the error if it failed.
+	CloneFinished(repo string, err error)
+
+	// RevisionsQueued is called with the number of tags or
+	// revisions about to be compared with local files. Testing
+	// stops early once a run of matching refs ends, so not all
+	// of them may be tested.
+	RevisionsQueued(n int)
+
+	// RevisionTested is called when ref has been compared with
+	// local files.
+	RevisionTested(ref string)
+
+	// FilesHashed is called with the number of upstream files
+	// hashed for a ref, unless they were cached.
+	FilesHashed(n int)
+}
+
+// noProgress is the ProgressReporter used by default, which ignores
+// everything.
+type noProgress struct{}
+
+func (noProgress) CloneStarted(repo string)             {}
+func (noProgress) CloneFinished(repo string, err error) {}
+func (noProgress) RevisionsQueued(n int)                {}
+func (noProgress) RevisionTested(ref string)            {}
+func (noProgress) FilesHashed(n int)                    {}
+
+// progress is set by SetProgressReporter.
+var progress ProgressReporter = noProgress{}
+
+// SetProgressReporter makes r be told about clones, the refs tested
+// when matching, and the files hashed. A nil r stops reporting.
+func SetProgressReporter(r ProgressReporter) {
+	if r == nil {
+		r = noProgress{}
+	}
+	progress = r
+}
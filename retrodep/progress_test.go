@@ -0,0 +1,65 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"reflect"
+	"testing"
+)
+
+// recordingProgress is a ProgressReporter which records the refs
+// tested and counts everything else.
+type recordingProgress struct {
+	noProgress
+	queued int
+	tested []string
+	hashed int
+}
+
+func (p *recordingProgress) RevisionsQueued(n int)     { p.queued += n }
+func (p *recordingProgress) RevisionTested(ref string) { p.tested = append(p.tested, ref) }
+func (p *recordingProgress) FilesHashed(n int)         { p.hashed += n }
+
+func TestProgress(t *testing.T) {
+	p := &recordingProgress{}
+	SetProgressReporter(p)
+	defer SetProgressReporter(nil)
+
+	wt := &probingWorkingTree{}
+	layouts := []moduleLayout{{FileHashes{"foo.go": "1"}, "sub"}}
+	_, err := matchLayoutsFromRefs(false, layouts, wt,
+		[]string{"new", "match", "old", "older"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.queued != 4 {
+		t.Errorf("queued %d", p.queued)
+	}
+	// Testing stops at the end of the run of matches.
+	if exp := []string{"new", "match", "old"}; !reflect.DeepEqual(p.tested, exp) {
+		t.Errorf("tested %v, want %v", p.tested, exp)
+	}
+
+	fetch := func(ref, subPath string) (FileHashes, error) {
+		return FileHashes{"a.go": "1", "b.go": "2"}, nil
+	}
+	if _, err := (&anyWorkingTree{}).cachedFileHashes("v1.0.0", "", fetch); err != nil {
+		t.Fatal(err)
+	}
+	if p.hashed != 2 {
+		t.Errorf("hashed %d", p.hashed)
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/vcs"
+)
+
+// ModuleProxy makes working trees for Go modules read the module
+// zips served by the Go module proxy at the base URL proxy, rather
+// than cloning the repository. The repository is still cloned for
+// modules the proxy does not know.
+func ModuleProxy(proxy string) WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		o.proxy = strings.TrimSuffix(proxy, "/")
+	}
+}
+
+// proxyClient is the HTTP client for module proxy requests.
+var proxyClient = &http.Client{
+	Timeout:   5 * time.Minute,
+	Transport: NewCredentialsTransport(nil),
+}
+
+// proxyInfo is the JSON served by a module proxy for a version.
+type proxyInfo struct {
+	Version string
+	Time    time.Time
+	Origin  *ModuleOrigin
+}
+
+// proxyVersions holds what is known of a module's versions, shared
+// between the copies made by WithContext.
+type proxyVersions struct {
+	mu sync.Mutex
+
+	// tags maps the tag for each version listed by the proxy to
+	// the version, which differs for +incompatible versions.
+	tags map[string]string
+
+	// infos maps versions, tags and revisions to what the proxy
+	// says of them.
+	infos map[string]*proxyInfo
+}
+
+// proxyWorkingTree is a WorkingTree for a Go module read from a
+// module proxy. Its tags are the versions the proxy lists, and its
+// revisions are those versions, or the VCS revisions the proxy
+// gives for them.
+type proxyWorkingTree struct {
+	anyWorkingTree
+
+	proxy    string
+	module   string
+	versions *proxyVersions
+}
+
+// newProxyWorkingTree returns a *proxyWorkingTree, in dir, for the
+// module at project.Root served by proxy. It returns
+// ErrorVersionNotFound if the proxy does not know the module.
+func newProxyWorkingTree(ctx context.Context, proxy string, project *vcs.RepoRoot, dir string) (*proxyWorkingTree, error) {
+	p := &proxyWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			Dir:       dir,
+			VCS:       project.VCS,
+			hasher:    &sha256Hasher{},
+			algorithm: HashSHA256,
+			cache:     newFileHashesCache(fileHashesCacheSize),
+			repo:      project.Repo,
+			ctx:       ctx,
+		},
+		proxy:  proxy,
+		module: project.Root,
+		versions: &proxyVersions{
+			tags:  make(map[string]string),
+			infos: make(map[string]*proxyInfo),
+		},
+	}
+	body, err := p.get("list")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		version := strings.TrimSpace(scanner.Text())
+		if version != "" {
+			p.versions.tags[strings.TrimSuffix(version, "+incompatible")] = version
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	log.Debugf("%s: %d versions from %s", p.module, len(p.versions.tags), proxy)
+	return p, nil
+}
+
+// WithContext returns a copy of p whose requests are made with ctx.
+func (p *proxyWorkingTree) WithContext(ctx context.Context) WorkingTree {
+	c := *p
+	c.ctx = ctx
+	return &c
+}
+
+// get requests the file name from the proxy's @v directory for the
+// module. It returns ErrorVersionNotFound if the proxy does not have
+// it.
+func (p *proxyWorkingTree) get(name string) (io.ReadCloser, error) {
+	u := p.proxy + "/" + escapeModulePath(p.module) + "/@v/" + name
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := hostLimitedDo(proxyClient, req.WithContext(p.context()))
+	traceDuration(start, "GET "+u, err)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound, http.StatusGone:
+		resp.Body.Close()
+		return nil, ErrorVersionNotFound
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("%s: %s", u, resp.Status)
+}
+
+// info returns what the proxy says of the tag, version or revision
+// ref. It returns ErrorInvalidRef if the proxy does not know it.
+func (p *proxyWorkingTree) info(ref string) (*proxyInfo, error) {
+	v := p.versions
+	v.mu.Lock()
+	info, ok := v.infos[ref]
+	query := ref
+	if version, ok := v.tags[ref]; ok {
+		query = version
+	}
+	v.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	body, err := p.get(escapeModulePath(query) + ".info")
+	if err == ErrorVersionNotFound {
+		return nil, ErrorInvalidRef
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	info = &proxyInfo{}
+	if err := json.NewDecoder(body).Decode(info); err != nil {
+		return nil, errors.Wrapf(err, "%s@%s", p.module, query)
+	}
+
+	v.mu.Lock()
+	v.infos[ref] = info
+	v.infos[info.Version] = info
+	if info.Origin != nil && info.Origin.Hash != "" {
+		v.infos[info.Origin.Hash] = info
+	}
+	v.mu.Unlock()
+	return info, nil
+}
+
+// VersionTags returns the tags of the versions listed by the proxy
+// which are semantic versions.
+func (p *proxyWorkingTree) VersionTags() ([]string, error) {
+	p.versions.mu.Lock()
+	tags := make([]string, 0, len(p.versions.tags))
+	for tag := range p.versions.tags {
+		tags = append(tags, tag)
+	}
+	p.versions.mu.Unlock()
+	return versionTags(tags), nil
+}
+
+// Revisions returns the versions listed by the proxy, newest first.
+// Untagged revisions are not known.
+func (p *proxyWorkingTree) Revisions() ([]string, error) {
+	return p.VersionTags()
+}
+
+// RevisionFromTag returns the VCS revision the proxy gives for the
+// version tagged tag, or else the tag itself.
+func (p *proxyWorkingTree) RevisionFromTag(tag string) (string, error) {
+	info, err := p.info(tag)
+	if err != nil {
+		return "", err
+	}
+	if info.Origin != nil && info.Origin.Hash != "" {
+		return info.Origin.Hash, nil
+	}
+	return tag, nil
+}
+
+// TimeFromRevision returns the time the proxy gives for rev, in UTC.
+func (p *proxyWorkingTree) TimeFromRevision(rev string) (time.Time, error) {
+	info, err := p.info(rev)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.Time.UTC(), nil
+}
+
+// ReachableTag returns the tag of the version rev is, if it is a
+// version listed by the proxy. As the proxy does not know the
+// history of the repository, it returns ErrorVersionNotFound
+// otherwise, as it does for tags the TagMatch leaves out.
+func (p *proxyWorkingTree) ReachableTag(rev string) (string, error) {
+	info, err := p.info(rev)
+	if err != nil {
+		return "", err
+	}
+	tag := strings.TrimSuffix(info.Version, "+incompatible")
+	p.versions.mu.Lock()
+	_, listed := p.versions.tags[tag]
+	p.versions.mu.Unlock()
+	if !listed || !p.tagMatch.matches(tag) {
+		return "", ErrorVersionNotFound
+	}
+	return tag, nil
+}
+
+// extract writes the files of the module zip for the tag, version
+// or revision ref to dir.
+func (p *proxyWorkingTree) extract(ref, dir string) error {
+	info, err := p.info(ref)
+	if err != nil {
+		return err
+	}
+	body, err := p.get(escapeModulePath(info.Version) + ".zip")
+	if err == ErrorVersionNotFound {
+		return ErrorInvalidRef
+	}
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	f, err := ioutil.TempFile("", "retrodep-proxy.")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	z := &ModuleZip{Path: p.module, Version: info.Version, File: f.Name()}
+	if err := z.extract(dir); err != nil {
+		return errors.Wrapf(err, "%s@%s", p.module, info.Version)
+	}
+	return nil
+}
+
+// FileHashesFromRef returns the file hashes of the module zip for
+// the tag, version or revision ref.
+func (p *proxyWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	return p.cachedFileHashes(ref, subPath, p.fileHashesFromRef)
+}
+
+func (p *proxyWorkingTree) fileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	hashes, err := extractedFileHashes(p.env, p.hasher, p.extractor(ref), subPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "FileHashesFromRef(%s)", ref)
+	}
+	return hashes, nil
+}
+
+// extractor returns an extractFunc for the module zip for ref.
+func (p *proxyWorkingTree) extractor(ref string) extractFunc {
+	return func(dir string) error {
+		return p.extract(ref, dir)
+	}
+}
+
+// TagSync replaces the files in the working tree with those of the
+// module zip for tag, or for the highest version if tag is "".
+func (p *proxyWorkingTree) TagSync(tag string) error {
+	if tag == "" {
+		tags, err := p.VersionTags()
+		if err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			return ErrorVersionNotFound
+		}
+		tag = tags[0]
+	}
+	return extractedSync(p.Dir, p.extractor(tag))
+}
+
+// RevSync replaces the files in the working tree with those of the
+// module zip for rev.
+func (p *proxyWorkingTree) RevSync(rev string) error {
+	return p.TagSync(rev)
+}
+
+// Grep searches the files within subPath in the module zip for ref.
+func (p *proxyWorkingTree) Grep(ref, pattern, subPath string) ([]GrepMatch, error) {
+	return extractedGrep(p.env, p.extractor(ref), pattern, subPath)
+}
+
+// LastRevisions gives ref as the last revision for each file within
+// subPath, as the proxy does not know the history of the repository.
+func (p *proxyWorkingTree) LastRevisions(ref, subPath string) (map[string]string, error) {
+	hashes, err := p.FileHashesFromRef(ref, subPath)
+	if err != nil {
+		return nil, err
+	}
+	revs := make(map[string]string, len(hashes))
+	for file := range hashes {
+		revs[file] = ref
+	}
+	return revs, nil
+}
+
+// Submodules returns nil, as module zips have no submodules.
+func (p *proxyWorkingTree) Submodules(ref string) ([]Submodule, error) {
+	return nil, nil
+}
@@ -0,0 +1,113 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestProxyWorkingTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const mod = "github.com/Foo/bar"
+	v := filepath.Join(dir, "github.com", "!foo", "bar", "@v")
+	writeModuleZip(t, filepath.Join(v, "v1.0.0.zip"), mod+"@v1.0.0",
+		map[string]string{"bar.go": "package bar\n", "sub/sub.go": "package sub\n"})
+	writeModuleZip(t, filepath.Join(v, "v2.0.0+incompatible.zip"), mod+"@v2.0.0+incompatible",
+		map[string]string{"bar.go": "package bar // v2\n"})
+	writeFiles(t, v, map[string]string{
+		"list":                     "v1.0.0\nv2.0.0+incompatible\n",
+		"v1.0.0.info":              `{"Version":"v1.0.0","Time":"2019-01-01T00:00:00Z","Origin":{"VCS":"git","Hash":"0123456789abcdef"}}`,
+		"v2.0.0+incompatible.info": `{"Version":"v2.0.0+incompatible","Time":"2019-02-01T00:00:00Z"}`,
+		"0123456789abcdef.info":    `{"Version":"v1.0.0","Time":"2019-01-01T00:00:00Z"}`,
+	})
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+
+	project := &vcs.RepoRoot{VCS: vcs.ByCmd(vcsGit), Repo: "https://" + mod, Root: mod}
+	wt, err := NewWorkingTreeContext(context.Background(), project, ModuleProxy(srv.URL+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wt.Close()
+	p, ok := wt.(*proxyWorkingTree)
+	if !ok {
+		t.Fatalf("got %T", wt)
+	}
+
+	tags, err := p.VersionTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"v2.0.0", "v1.0.0"}) {
+		t.Errorf("tags: got %v", tags)
+	}
+	if rev, err := p.RevisionFromTag("v1.0.0"); err != nil || rev != "0123456789abcdef" {
+		t.Errorf("v1.0.0: got %q, %v", rev, err)
+	}
+	if rev, err := p.RevisionFromTag("v2.0.0"); err != nil || rev != "v2.0.0" {
+		t.Errorf("v2.0.0: got %q, %v", rev, err)
+	}
+	if tag, err := p.ReachableTag("0123456789abcdef"); err != nil || tag != "v1.0.0" {
+		t.Errorf("ReachableTag: got %q, %v", tag, err)
+	}
+	if when, err := p.TimeFromRevision("v2.0.0"); err != nil || when.Month() != 2 {
+		t.Errorf("TimeFromRevision: got %v, %v", when, err)
+	}
+	if _, err := p.RevisionFromTag("v3.0.0"); err != ErrorInvalidRef {
+		t.Errorf("v3.0.0: got %v", err)
+	}
+
+	hashes, err := p.FileHashesFromRef("0123456789abcdef", "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 1 || hashes["sub.go"] == "" {
+		t.Errorf("FileHashesFromRef: got %v", hashes)
+	}
+	hashes, err = p.FileHashesFromRef("v2.0.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 1 || hashes["bar.go"] == "" {
+		t.Errorf("FileHashesFromRef: got %v", hashes)
+	}
+
+	if err := p.TagSync("v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(p.Root(), "sub", "sub.go")); err != nil {
+		t.Error(err)
+	}
+
+	unknown := &vcs.RepoRoot{VCS: vcs.ByCmd(vcsGit), Repo: "https://example.com/m", Root: "example.com/m"}
+	if _, err := newProxyWorkingTree(context.Background(), srv.URL, unknown, dir); err != ErrorVersionNotFound {
+		t.Errorf("unknown module: got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Pruning describes which upstream files a copy of a project leaves
+// out. Only the files in the copy are compared with upstream, so
+// copies pruned of tests, non-Go files or unused packages, as dep and
+// 'go mod vendor' prune them, match the version they were taken from.
+type Pruning struct {
+	// Compared is the number of files in the copy, each compared
+	// with upstream.
+	Compared int `json:"compared"`
+
+	// Ignored is the number of upstream files not in the copy,
+	// which were left out of the comparison.
+	Ignored int `json:"ignored"`
+
+	// Packages is the number of upstream directories holding Go
+	// files of which none are in the copy.
+	Packages int `json:"packages,omitempty"`
+}
+
+// VendoredPruning returns the Pruning of the vendored copy of
+// project compared with upstream ref in wt.
+func (src GoSource) VendoredPruning(project *RepoPath, wt WorkingTree, ref string) (*Pruning, error) {
+	projDir := filepath.Join(src.Vendor(), filepath.FromSlash(project.Root))
+	return src.Pruning(project, wt, projDir, ref)
+}
+
+// Pruning is VendoredPruning for the project whose files are in dir.
+func (src GoSource) Pruning(project *RepoPath, wt WorkingTree, dir, ref string) (*Pruning, error) {
+	local, err := src.hashLocalFiles(wt, project, dir)
+	if err != nil {
+		return nil, err
+	}
+	upstream, err := wt.FileHashesFromRef(ref, project.SubPath)
+	if err != nil {
+		return nil, err
+	}
+	return pruning(local, upstream), nil
+}
+
+// pruning returns the Pruning of the local files compared with the
+// upstream ones.
+func pruning(local, upstream FileHashes) *Pruning {
+	p := &Pruning{Compared: len(local)}
+	localDirs := make(map[string]bool)
+	for path := range local {
+		localDirs[filepath.Dir(path)] = true
+	}
+	prunedDirs := make(map[string]bool)
+	for path := range upstream {
+		if _, ok := local[path]; ok || strings.HasPrefix(path, ".") {
+			continue
+		}
+		p.Ignored++
+		dir := filepath.Dir(path)
+		if strings.HasSuffix(path, ".go") && !localDirs[dir] && !prunedDirs[dir] {
+			prunedDirs[dir] = true
+			p.Packages++
+		}
+	}
+	return p
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestPruning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-pruned.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"a.go":       "package a\n",
+		"sub/sub.go": "package sub\n",
+	})
+	src, err := NewGoSource(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proj := &RepoPath{RepoRoot: vcs.RepoRoot{Root: "example.com/a"}}
+	wt := &nearWorkingTree{}
+	wt.hasher = &sha256Hasher{}
+	wt.localHashes, err = src.hashLocalFiles(wt, proj, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt.localHashes["a_test.go"] = "test"
+	wt.localHashes["README.md"] = "readme"
+	wt.localHashes["unused/unused.go"] = "unused"
+	wt.localHashes["unused/unused_test.go"] = "unused test"
+	wt.localHashes[".travis.yml"] = "travis"
+	wt.differ = map[string][]string{"v1.0.0": nil}
+
+	pruned, err := src.Pruning(proj, wt, dir, "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Pruning{Compared: 2, Ignored: 4, Packages: 1}
+	if *pruned != expected {
+		t.Errorf("got %+v, want %+v", *pruned, expected)
+	}
+
+	if _, err := src.Pruning(proj, wt, dir, "v2.0.0"); err != ErrorInvalidRef {
+		t.Errorf("unknown ref: got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"strings"
+	"text/template"
+	"time"
+)
+
+// PseudoVersionInfo holds what is known about a revision, for a
+// PseudoVersionFormatter to make a pseudo-version from.
+type PseudoVersionInfo struct {
+	// Tag is the most recent tag reachable from the revision, or
+	// "" if there is none.
+	Tag string
+
+	// Version is Tag without any "v" prefix, or "0" if there is
+	// no Tag.
+	Version string
+
+	// Time is the commit time of the revision, in UTC.
+	Time time.Time
+
+	// Date is Time in the form yyyymmdd.
+	Date string
+
+	// Timestamp is Time in the form yyyymmddhhmmss.
+	Timestamp string
+
+	// Rev is the revision.
+	Rev string
+
+	// ShortRev is the revision abbreviated to 7 characters.
+	ShortRev string
+}
+
+// PseudoVersionFormatter makes a pseudo-version from info.
+type PseudoVersionFormatter func(info *PseudoVersionInfo) (string, error)
+
+// pseudoVersionFormatter is set by SetPseudoVersionFormatter.
+var pseudoVersionFormatter PseudoVersionFormatter
+
+// SetPseudoVersionFormatter makes PseudoVersion return the
+// pseudo-versions made by f, such as those for the snapshots of a
+// packaging system. A nil f restores the default.
+func SetPseudoVersionFormatter(f PseudoVersionFormatter) {
+	pseudoVersionFormatter = f
+}
+
+// PseudoVersionTemplate returns a PseudoVersionFormatter which
+// executes tmpl with the PseudoVersionInfo, for example
+// "{{.Version}}^{{.Date}}git{{.ShortRev}}" for RPM snapshots.
+func PseudoVersionTemplate(tmpl *template.Template) PseudoVersionFormatter {
+	return func(info *PseudoVersionInfo) (string, error) {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, info); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+}
+
+// formatPseudoVersion returns the pseudo-version made by f for rev.
+func formatPseudoVersion(f PseudoVersionFormatter, d Describable, rev string) (string, error) {
+	info := &PseudoVersionInfo{Version: "0", Rev: rev, ShortRev: rev}
+	tag, err := d.ReachableTag(rev)
+	switch {
+	case err == ErrorVersionNotFound:
+	case err != nil:
+		return "", err
+	default:
+		info.Tag = tag
+		info.Version = strings.TrimPrefix(tag, "v")
+	}
+
+	t, err := d.TimeFromRevision(rev)
+	if err != nil {
+		return "", err
+	}
+	info.Time = t.UTC()
+	info.Date = info.Time.Format("20060102")
+	info.Timestamp = info.Time.Format("20060102150405")
+	if len(rev) > 7 {
+		info.ShortRev = rev[:7]
+	}
+	return f(info)
+}
@@ -0,0 +1,49 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestPseudoVersionTemplate(t *testing.T) {
+	tmpl := template.Must(template.New("pseudo").Parse("{{.Version}}^{{.Date}}git{{.ShortRev}}"))
+	SetPseudoVersionFormatter(PseudoVersionTemplate(tmpl))
+	defer SetPseudoVersionFormatter(nil)
+
+	rev := "d4c3dbfa77a74ae238e401d5d2197b45f30d8513"
+	tm := time.Date(2006, 1, 3, 0, 4, 5, 0, time.FixedZone("", 3600))
+	for _, tc := range []struct {
+		m  mockDescribable
+		pv string
+	}{
+		{mockDescribable{name: "reachable", tag: "v1.2.0"}, "1.2.0^20060102gitd4c3dbf"},
+		{mockDescribable{name: "no-reachable", tagErr: ErrorVersionNotFound}, "0^20060102gitd4c3dbf"},
+	} {
+		m := tc.m
+		m.t = t
+		m.rev = rev
+		m.time = tm
+		pv, err := PseudoVersion(&m, rev)
+		if err != nil {
+			t.Errorf("%s: %s", m.name, err)
+		} else if pv != tc.pv {
+			t.Errorf("%s: got %q, want %q", m.name, pv, tc.pv)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// Relocations maps the import path prefixes of projects which have
+// moved to the import path prefixes of their new homes.
+type Relocations map[string]string
+
+// knownRelocations are well-known projects whose old import paths no
+// longer resolve, or resolve to stale copies.
+var knownRelocations = Relocations{
+	"code.google.com/p/go.crypto":     "golang.org/x/crypto",
+	"code.google.com/p/go.net":        "golang.org/x/net",
+	"code.google.com/p/go.text":       "golang.org/x/text",
+	"code.google.com/p/go.tools":      "golang.org/x/tools",
+	"code.google.com/p/goprotobuf":    "github.com/golang/protobuf",
+	"code.google.com/p/gogoprotobuf":  "github.com/gogo/protobuf",
+	"code.google.com/p/go-uuid":       "github.com/pborman/uuid",
+	"code.google.com/p/snappy-go":     "github.com/golang/snappy",
+	"github.com/Sirupsen/logrus":      "github.com/sirupsen/logrus",
+	"github.com/codegangsta/cli":      "github.com/urfave/cli",
+	"github.com/coreos/etcd":          "go.etcd.io/etcd",
+	"github.com/go-fsnotify/fsnotify": "github.com/fsnotify/fsnotify",
+	"github.com/golang/lint":          "golang.org/x/lint",
+	"github.com/kubernetes/heapster":  "github.com/kubernetes-retired/heapster",
+	"k8s.io/heapster":                 "github.com/kubernetes-retired/heapster",
+
+	"github.com/kubernetes-incubator/custom-metrics-apiserver": "github.com/kubernetes-sigs/custom-metrics-apiserver",
+}
+
+// ReadRelocations parses a relocations file from r. Each line has an
+// old import path prefix followed by the new one, separated by
+// whitespace. Blank lines and lines starting with "#" are ignored.
+func ReadRelocations(r io.Reader) (Relocations, error) {
+	relocations := make(Relocations)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		relocations[fields[0]] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return relocations, nil
+}
+
+// AddRelocations adds relocations for projects in this GoSource.
+// These take precedence over the well-known relocations.
+func (src *GoSource) AddRelocations(relocations Relocations) {
+	if src.relocations == nil {
+		src.relocations = make(Relocations)
+	}
+	for from, to := range relocations {
+		src.relocations[from] = to
+	}
+}
+
+// longestPrefix returns the longest key of r which is importPath or
+// a parent of it, or "" if there is none.
+func (r Relocations) longestPrefix(importPath string) string {
+	var best string
+	for prefix := range r {
+		if len(prefix) > len(best) &&
+			(importPath == prefix || strings.HasPrefix(importPath, prefix+"/")) {
+			best = prefix
+		}
+	}
+	return best
+}
+
+// relocate returns the new import path for importPath, and the
+// old and new prefixes used, or false if it has not moved.
+func (src GoSource) relocate(importPath string) (string, string, string, bool) {
+	for _, r := range []Relocations{src.relocations, knownRelocations} {
+		if from := r.longestPrefix(importPath); from != "" {
+			to := r[from]
+			return to + importPath[len(from):], from, to, true
+		}
+	}
+	return "", "", "", false
+}
+
+// goGetScheme is the scheme used for following redirects.
+var goGetScheme = "https://"
+
+// metaRefreshRE matches an HTML meta refresh, capturing its URL.
+var metaRefreshRE = regexp.MustCompile(`(?i)<meta\s+http-equiv=["']?refresh["']?\s+content=["']?\d+\s*;\s*url=([^"'>\s]+)`)
+
+// followRedirect fetches the page for importPath, as 'go get' would,
+// and returns the import path it is redirected to by HTTP redirects
+// or an HTML meta refresh, or false if it is not redirected.
+func followRedirect(importPath string) (string, bool) {
+	resp, err := forgeClient.Get(goGetScheme + importPath + "?go-get=1")
+	if err != nil {
+		log.Debugf("%s: %s", importPath, err)
+		return "", false
+	}
+	defer resp.Body.Close()
+
+	u := resp.Request.URL
+	if body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16)); err == nil {
+		if matches := metaRefreshRE.FindSubmatch(body); matches != nil {
+			if refresh, err := u.Parse(string(matches[1])); err == nil {
+				u = refresh
+			}
+		}
+	}
+
+	moved := u.Host + strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git")
+	if moved == u.Host || moved == importPath {
+		return "", false
+	}
+	log.Debugf("%s: redirected to %s", importPath, moved)
+	return moved, true
+}
+
+// lookupRedirect is followRedirect, and is a variable so it can be
+// replaced for tests.
+var lookupRedirect = followRedirect
+
+// repoRootForImportPath returns the repository root for importPath,
+// looking up relocations and following redirects if necessary. The
+// returned Root is always in terms of importPath.
+func (src GoSource) repoRootForImportPath(importPath string) (*vcs.RepoRoot, error) {
+	if moved, from, to, ok := src.relocate(importPath); ok {
+		root, err := src.resolve(moved)
+		if err != nil {
+			return nil, err
+		}
+		log.Debugf("%s: relocated to %s", importPath, moved)
+		relocated := *root
+		relocated.Root = from
+		if strings.HasPrefix(root.Root, to) {
+			relocated.Root += root.Root[len(to):]
+		}
+		return &relocated, nil
+	}
+
+	root, err := src.resolve(importPath)
+	if err == nil || offline {
+		return root, err
+	}
+	moved, ok := lookupRedirect(importPath)
+	if !ok {
+		return nil, err
+	}
+	movedRoot, movedErr := src.resolve(moved)
+	if movedErr != nil {
+		return nil, err // Returning the initial error is intentional
+	}
+
+	// Map the root back to importPath using the common suffix.
+	redirected := *movedRoot
+	redirected.Root = importPath
+	if rest := strings.TrimPrefix(moved, movedRoot.Root); strings.HasSuffix(importPath, rest) {
+		redirected.Root = strings.TrimSuffix(importPath, rest)
+	}
+	return &redirected, nil
+}
@@ -0,0 +1,139 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// mockRepoRoots makes vcsRepoRootForImportPath resolve only the
+// import paths below the given roots. The returned function should
+// be deferred to reset it.
+func mockRepoRoots(roots ...string) func() {
+	vcsRepoRootForImportPath = func(importPath string, _ bool) (*vcs.RepoRoot, error) {
+		for _, root := range roots {
+			if importPath == root || strings.HasPrefix(importPath, root+"/") {
+				return &vcs.RepoRoot{
+					VCS:  vcs.ByCmd(vcsGit),
+					Repo: "https://" + root,
+					Root: root,
+				}, nil
+			}
+		}
+		return nil, errors.New("unresolvable")
+	}
+	return func() {
+		vcsRepoRootForImportPath = vcs.RepoRootForImportPath
+	}
+}
+
+func TestReadRelocations(t *testing.T) {
+	r, err := ReadRelocations(strings.NewReader(`# comment
+example.com/old github.com/new/name
+
+incomplete
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 1 || r["example.com/old"] != "github.com/new/name" {
+		t.Errorf("unexpected relocations %v", r)
+	}
+}
+
+func TestRelocate(t *testing.T) {
+	defer mockRepoRoots("github.com/new/name")()
+
+	src := &GoSource{}
+	src.AddRelocations(Relocations{
+		"example.com/old":     "github.com/new/name",
+		"example.com/old/sub": "github.com/elsewhere/sub",
+	})
+
+	tcs := []struct {
+		importPath string
+		repo       string
+		root       string
+	}{
+		{"code.google.com/p/go.net/context", "https://go.googlesource.com/net", "code.google.com/p/go.net"},
+		{"example.com/old/pkg", "https://github.com/new/name", "example.com/old"},
+		{"example.com/older", "", ""},
+		{"example.com/old/sub", "", ""}, // most specific applies
+	}
+	for _, tc := range tcs {
+		root, err := src.repoRootForImportPath(tc.importPath)
+		if tc.repo == "" {
+			if err == nil {
+				t.Errorf("%s: expected error, got %v", tc.importPath, root)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.importPath, err)
+			continue
+		}
+		if root.Repo != tc.repo || root.Root != tc.root {
+			t.Errorf("%s: got %s (%s), want %s (%s)", tc.importPath,
+				root.Repo, root.Root, tc.repo, tc.root)
+		}
+	}
+}
+
+func TestFollowRedirect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/refresh", http.StatusMovedPermanently)
+		case "/refresh":
+			fmt.Fprint(w, `<html><head><meta http-equiv="refresh" content="0; url=https://github.com/new/name.git"></head></html>`)
+		default:
+			fmt.Fprint(w, `<html></html>`)
+		}
+	}))
+	defer srv.Close()
+	defer func() { goGetScheme = "https://" }()
+	goGetScheme = "http://"
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	moved, ok := followRedirect(host + "/moved")
+	if !ok || moved != "github.com/new/name" {
+		t.Errorf("got %q,%t", moved, ok)
+	}
+	if moved, ok := followRedirect(host + "/static"); ok {
+		t.Errorf("unexpected redirect to %q", moved)
+	}
+
+	defer mockRepoRoots("github.com/new/name")()
+	defer func() { lookupRedirect = followRedirect }()
+	lookupRedirect = func(importPath string) (string, bool) {
+		return strings.Replace(importPath, "example.com/gone", "github.com/new/name", 1), true
+	}
+	src := &GoSource{}
+	root, err := src.repoRootForImportPath("example.com/gone/pkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Repo != "https://github.com/new/name" || root.Root != "example.com/gone" {
+		t.Errorf("got %s (%s)", root.Repo, root.Root)
+	}
+}
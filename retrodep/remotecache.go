@@ -0,0 +1,413 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// The shared cache protocol is HTTP. File hashes for a whole
+// revision are read with GET and stored with PUT, at
+//
+//	<base URL>/v1/filehashes/<key>
+//
+// where key is the lower case hex SHA-256 digest of the normalized
+// repository URL, the hash algorithm and the revision ID, separated
+// by NUL bytes. The body is a JSON object with a "hashes" field
+// mapping each file path (relative to the repository root, using "/"
+// separators) to its hash. A missing entry is 404 Not Found.
+//
+// Entries are signed, in a "signature" field, with the lower case
+// hex HMAC-SHA256 of the key, a NUL byte and the JSON encoding of the
+// "hashes" field (with its keys sorted), using a key shared by the
+// service and the clients storing entries. The service only accepts
+// entries with a valid signature, and is read-only without a key.
+
+// remoteCachePath is the path below the base URL of the entries.
+const remoteCachePath = "/v1/filehashes/"
+
+// remoteCacheMaxSize is the largest entry accepted, in bytes.
+const remoteCacheMaxSize = 64 << 20
+
+// remoteCacheKeyRE matches a valid key.
+var remoteCacheKeyRE = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// remoteCacheSampleRate is how many of the entries read from a
+// RemoteCache without a key, for every one, are checked by
+// computing the file hashes anyway.
+var remoteCacheSampleRate = 10
+
+// remoteCacheEntry is the body of a request or response.
+type remoteCacheEntry struct {
+	Hashes    map[string]FileHash `json:"hashes"`
+	Signature string              `json:"signature,omitempty"`
+}
+
+// sign returns the signature of the entry for key, made with
+// secret.
+func (e *remoteCacheEntry) sign(secret []byte, key string) (string, error) {
+	data, err := json.Marshal(e.Hashes)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(key + "\x00"))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verify returns an error unless the entry for key is signed with
+// secret.
+func (e *remoteCacheEntry) verify(secret []byte, key string) error {
+	sig, err := e.sign(secret, key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(sig), []byte(e.Signature)) {
+		return fmt.Errorf("%s: invalid signature", key)
+	}
+	return nil
+}
+
+// RemoteCache is a client for a shared file hashes cache service,
+// so that several hosts need only hash each revision once between
+// them.
+type RemoteCache struct {
+	url    string
+	client *http.Client
+
+	// secret, if set, signs stored entries and checks those read.
+	secret []byte
+
+	mu sync.Mutex
+
+	// distrusted is set once an entry has been found not to
+	// match the file hashes it claims to have.
+	distrusted bool
+}
+
+// NewRemoteCache returns a *RemoteCache for the service at the base
+// URL u.
+func NewRemoteCache(u string) *RemoteCache {
+	return &RemoteCache{
+		url:    strings.TrimSuffix(u, "/"),
+		client: &http.Client{Transport: NewCredentialsTransport(nil)},
+	}
+}
+
+// SetKey makes c sign the entries it stores with secret, the key
+// given to the service, and ignore those read which are not signed
+// with it. Without a key, entries are not stored, and those read are
+// checked by computing the file hashes again for a sample of them.
+func (c *RemoteCache) SetKey(secret []byte) {
+	c.secret = secret
+}
+
+// signed returns whether the entries read from c are checked by
+// their signatures.
+func (c *RemoteCache) signed() bool {
+	return c.secret != nil
+}
+
+// distrust makes c ignore the entries it reads from now on.
+func (c *RemoteCache) distrust() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.distrusted = true
+}
+
+// remoteCacheKey returns the key for the file hashes of revision rev
+// of the repository at the URL repo, using the hash algorithm.
+func remoteCacheKey(repo, algorithm, rev string) string {
+	sum := sha256.Sum256([]byte(normalizeRepoURL(repo) + "\x00" +
+		algorithm + "\x00" + rev))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the file hashes stored for key, or false if there are
+// none. With a key, entries not signed with it are an error.
+func (c *RemoteCache) Get(key string) (FileHashes, bool, error) {
+	c.mu.Lock()
+	distrusted := c.distrusted
+	c.mu.Unlock()
+	if distrusted {
+		return nil, false, nil
+	}
+	resp, err := c.client.Get(c.url + remoteCachePath + key)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("%s: %s", resp.Request.URL, resp.Status)
+	}
+	var entry remoteCacheEntry
+	err = json.NewDecoder(io.LimitReader(resp.Body, remoteCacheMaxSize)).Decode(&entry)
+	if err != nil {
+		return nil, false, err
+	}
+	if c.signed() {
+		if err := entry.verify(c.secret, key); err != nil {
+			return nil, false, err
+		}
+	}
+	hashes := make(FileHashes, len(entry.Hashes))
+	for path, fileHash := range entry.Hashes {
+		hashes[filepath.FromSlash(path)] = fileHash
+	}
+	return hashes, true, nil
+}
+
+// Put stores hashes as the file hashes for key, signed with the key
+// set with SetKey. Without one, nothing is stored, as the service
+// only accepts signed entries.
+func (c *RemoteCache) Put(key string, hashes FileHashes) error {
+	if !c.signed() {
+		return nil
+	}
+	entry := remoteCacheEntry{Hashes: make(map[string]FileHash, len(hashes))}
+	for path, fileHash := range hashes {
+		entry.Hashes[filepath.ToSlash(path)] = fileHash
+	}
+	var err error
+	entry.Signature, err = entry.sign(c.secret, key)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, c.url+remoteCachePath+key,
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", req.URL, resp.Status)
+	}
+	return nil
+}
+
+var remoteCacheMu sync.Mutex
+var remoteCache *RemoteCache
+
+// SetRemoteCache makes working trees look up file hashes in the
+// shared cache c before computing them, and store them there
+// afterwards. A nil c stops them using any shared cache.
+func SetRemoteCache(c *RemoteCache) {
+	remoteCacheMu.Lock()
+	defer remoteCacheMu.Unlock()
+	remoteCache = c
+}
+
+// fileHashesStore is a persistent store of the file hashes of whole
+// revisions, such as a RemoteCache or a DiskCache.
+type fileHashesStore interface {
+	Get(key string) (FileHashes, bool, error)
+	Put(key string, hashes FileHashes) error
+}
+
+// hashStores returns the disk cache for env and the shared cache set
+// by SetRemoteCache, nearest first.
+func hashStores(env *scanEnv) []fileHashesStore {
+	var stores []fileHashesStore
+	if c := env.diskCache(); c != nil {
+		stores = append(stores, c)
+	}
+	remoteCacheMu.Lock()
+	defer remoteCacheMu.Unlock()
+	if remoteCache != nil {
+		stores = append(stores, remoteCache)
+	}
+	return stores
+}
+
+// remoteFileHashes returns fetch wrapped to use the disk cache and
+// the shared cache, if there are any, for the file hashes of whole
+// revisions. The refs are resolved to revision IDs using revision,
+// so that entries are only shared for the same content. Entries
+// found in one store are copied to those nearer. Entries from a
+// shared cache whose signatures are not checked are checked instead
+// by computing the file hashes for a sample of them; if one does not
+// match, the shared cache is not used again.
+func (wt *anyWorkingTree) remoteFileHashes(fetch func(ref, subPath string) (FileHashes, error), revision func(ref string) (string, error)) func(ref, subPath string) (FileHashes, error) {
+	stores := hashStores(wt.env)
+	if len(stores) == 0 || wt.repo == "" {
+		return fetch
+	}
+	return func(ref, subPath string) (FileHashes, error) {
+		rev, err := revision(ref)
+		if err != nil {
+			return nil, err
+		}
+		key := remoteCacheKey(wt.repo, wt.algorithm, rev)
+		var hashes FileHashes
+		found := len(stores)
+		for i, store := range stores {
+			var ok bool
+			hashes, ok, err = store.Get(key)
+			if err != nil {
+				log.Debugf("%s: file hashes cache: %s", wt.repo, err)
+			}
+			if ok {
+				found = i
+				break
+			}
+		}
+		if found == len(stores) {
+			hashes, err = fetch(ref, "")
+			if err != nil {
+				return nil, err
+			}
+		} else if c, ok := stores[found].(*RemoteCache); ok && !c.signed() && rand.Intn(remoteCacheSampleRate) == 0 {
+			computed, err := fetch(ref, "")
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(computed, hashes) {
+				log.Errorf("%s: shared cache entry for %s does not match, no longer using it", wt.repo, rev)
+				c.distrust()
+				hashes = computed
+			}
+		}
+		for _, store := range stores[:found] {
+			if err := store.Put(key, hashes); err != nil {
+				log.Debugf("%s: file hashes cache: %s", wt.repo, err)
+			}
+		}
+		return hashes.under(subPath), nil
+	}
+}
+
+// cacheServer is an http.Handler storing entries as files.
+type cacheServer struct {
+	disk   *DiskCache
+	secret []byte
+}
+
+// NewCacheServer returns an http.Handler serving the shared cache
+// protocol, storing the entries in the directory dir as a DiskCache
+// does. Only entries signed with secret are stored; if it is nil,
+// none are, and the service is read-only.
+func NewCacheServer(dir string, secret []byte) http.Handler {
+	return &cacheServer{disk: NewDiskCache(dir), secret: secret}
+}
+
+func (s *cacheServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, remoteCachePath)
+	if key == r.URL.Path || !remoteCacheKeyRE.MatchString(key) {
+		http.NotFound(w, r)
+		return
+	}
+	pth := s.disk.path(key)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, r, pth)
+	case http.MethodPut:
+		if s.secret == nil {
+			http.Error(w, "read-only", http.StatusForbidden)
+			return
+		}
+		if err := s.put(key, pth, r.Body); err == errInvalidSignature {
+			log.Warningf("%s: from %s: %s", key, r.RemoteAddr, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		} else if err != nil {
+			log.Errorf("%s: %s", key, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// errInvalidSignature is the error for an entry not signed with the
+// service's key.
+var errInvalidSignature = fmt.Errorf("invalid signature")
+
+// put validates the entry for key read from body and writes it to
+// pth.
+func (s *cacheServer) put(key, pth string, body io.Reader) error {
+	data, err := ioutil.ReadAll(io.LimitReader(body, remoteCacheMaxSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > remoteCacheMaxSize {
+		return fmt.Errorf("entry larger than %d bytes", remoteCacheMaxSize)
+	}
+	var entry remoteCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+	if entry.Hashes == nil {
+		return fmt.Errorf("no hashes")
+	}
+	if entry.verify(s.secret, key) != nil {
+		return errInvalidSignature
+	}
+	return writeEntry(pth, data)
+}
+
+// writeEntry writes the entry data to the file pth, replacing it
+// atomically.
+func writeEntry(pth string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(pth), ".entry")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), pth)
+}
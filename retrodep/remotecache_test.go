@@ -0,0 +1,242 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRemoteCacheKey(t *testing.T) {
+	key := remoteCacheKey("https://github.com/foo/bar", HashGitSHA1, "d4c3dbf")
+	if !remoteCacheKeyRE.MatchString(key) {
+		t.Errorf("invalid key %q", key)
+	}
+	if other := remoteCacheKey("https://github.com/Foo/bar.git", HashGitSHA1, "d4c3dbf"); other != key {
+		t.Error("key depends on URL spelling")
+	}
+	for _, other := range []string{
+		remoteCacheKey("https://github.com/foo/bar", HashSHA256, "d4c3dbf"),
+		remoteCacheKey("https://github.com/foo/bar", HashGitSHA1, "a2176f4"),
+		remoteCacheKey("https://github.com/foo/baz", HashGitSHA1, "d4c3dbf"),
+	} {
+		if other == key {
+			t.Error("key collision")
+		}
+	}
+}
+
+func TestCacheServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secret := []byte("secret")
+	srv := httptest.NewServer(NewCacheServer(dir, secret))
+	defer srv.Close()
+
+	c := NewRemoteCache(srv.URL + "/")
+	c.SetKey(secret)
+	key := remoteCacheKey("https://github.com/foo/bar", HashGitSHA1, "d4c3dbf")
+	if _, ok, err := c.Get(key); ok || err != nil {
+		t.Fatalf("empty cache: got %t, %v", ok, err)
+	}
+
+	hashes := FileHashes{"bar.go": "1234", "sub/baz.go": "5678"}
+	if err := c.Put(key, hashes); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := c.Get(key)
+	if err != nil || !ok {
+		t.Fatalf("got %t, %v", ok, err)
+	}
+	if !reflect.DeepEqual(got, hashes) {
+		t.Errorf("got %v, want %v", got, hashes)
+	}
+
+	if _, _, err := c.Get("../../etc/passwd"); err != nil {
+		t.Errorf("invalid key: %s", err)
+	}
+	resp, err := http.Post(srv.URL+remoteCachePath+key, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got %s", resp.Status)
+	}
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+remoteCachePath+key, strings.NewReader("{}"))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("PUT without hashes: got %s", resp.Status)
+	}
+
+	// Entries must be signed with the service's key.
+	other := NewRemoteCache(srv.URL)
+	other.SetKey([]byte("other"))
+	if err := other.Put(key, FileHashes{"bar.go": "0000"}); err == nil {
+		t.Error("wrong key: no error")
+	}
+	req, _ = http.NewRequest(http.MethodPut, srv.URL+remoteCachePath+key, strings.NewReader(`{"hashes":{"bar.go":"0000"}}`))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("PUT unsigned: got %s", resp.Status)
+	}
+	if _, _, err := other.Get(key); err == nil {
+		t.Error("wrong key: entry accepted")
+	}
+	if got, ok, err := NewRemoteCache(srv.URL).Get(key); !ok || err != nil || !reflect.DeepEqual(got, hashes) {
+		t.Errorf("without key: got %v, %t, %v", got, ok, err)
+	}
+
+	// An entry signed for one key cannot be stored for another.
+	data, err := ioutil.ReadFile(filepath.Join(dir, key[:2], key+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey := remoteCacheKey("https://github.com/foo/bar", HashGitSHA1, "a2176f4")
+	req, _ = http.NewRequest(http.MethodPut, srv.URL+remoteCachePath+otherKey, bytes.NewReader(data))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("PUT replayed: got %s", resp.Status)
+	}
+}
+
+func TestCacheServerReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	srv := httptest.NewServer(NewCacheServer(dir, nil))
+	defer srv.Close()
+
+	c := NewRemoteCache(srv.URL)
+	c.SetKey([]byte("secret"))
+	key := remoteCacheKey("https://github.com/foo/bar", HashGitSHA1, "d4c3dbf")
+	if err := c.Put(key, FileHashes{"bar.go": "1234"}); err == nil {
+		t.Error("no error")
+	}
+	if _, err := os.Stat(filepath.Join(dir, key[:2])); !os.IsNotExist(err) {
+		t.Errorf("entry stored: %v", err)
+	}
+}
+
+func TestRemoteFileHashesSampled(t *testing.T) {
+	defer func(rate int) { remoteCacheSampleRate = rate }(remoteCacheSampleRate)
+	remoteCacheSampleRate = 1
+	dir := t.TempDir()
+	srv := httptest.NewServer(NewCacheServer(dir, nil))
+	defer srv.Close()
+	c := NewRemoteCache(srv.URL)
+	SetRemoteCache(c)
+	defer SetRemoteCache(nil)
+
+	// A tampered entry, stored by other means.
+	repo := "https://github.com/foo/bar"
+	if err := NewDiskCache(dir).Put(remoteCacheKey(repo, HashGitSHA1, "rev-v1.0.0"), FileHashes{"README": "0"}); err != nil {
+		t.Fatal(err)
+	}
+	fetches := 0
+	fetch := func(ref, subPath string) (FileHashes, error) {
+		fetches++
+		return FileHashes{"README": "1"}, nil
+	}
+	revision := func(ref string) (string, error) {
+		return "rev-" + ref, nil
+	}
+	wt := &anyWorkingTree{repo: repo, algorithm: HashGitSHA1}
+	hashes, err := wt.remoteFileHashes(fetch, revision)("v1.0.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hashes, FileHashes{"README": "1"}) || fetches != 1 {
+		t.Errorf("got %v, fetched %d times", hashes, fetches)
+	}
+	if _, ok, _ := c.Get(remoteCacheKey(repo, HashGitSHA1, "rev-v1.0.0")); ok {
+		t.Error("still used")
+	}
+}
+
+func TestRemoteFileHashes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secret := []byte("secret")
+	srv := httptest.NewServer(NewCacheServer(dir, secret))
+	defer srv.Close()
+	c := NewRemoteCache(srv.URL)
+	c.SetKey(secret)
+	SetRemoteCache(c)
+	defer SetRemoteCache(nil)
+
+	fetches := 0
+	fetch := func(ref, subPath string) (FileHashes, error) {
+		fetches++
+		return FileHashes{"README": "1", "sub/a.go": "2"}, nil
+	}
+	revision := func(ref string) (string, error) {
+		return "rev-" + ref, nil
+	}
+
+	// Two hosts, each with its own local cache.
+	for i := 0; i < 2; i++ {
+		wt := &anyWorkingTree{
+			cache:     newFileHashesCache(fileHashesCacheSize),
+			repo:      "https://github.com/foo/bar",
+			algorithm: HashGitSHA1,
+		}
+		hashes, err := wt.cachedFileHashes("v1.0.0", "sub",
+			wt.remoteFileHashes(fetch, revision))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(hashes, FileHashes{"a.go": "2"}) {
+			t.Errorf("got %v", hashes)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("fetched %d times", fetches)
+	}
+
+	// Unreachable service
+	SetRemoteCache(NewRemoteCache("http://127.0.0.1:0"))
+	wt := &anyWorkingTree{repo: "https://github.com/foo/bar"}
+	if _, err := wt.remoteFileHashes(fetch, revision)("v1.0.0", ""); err != nil {
+		t.Error(err)
+	}
+	if fetches != 2 {
+		t.Errorf("fetched %d times", fetches)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// This file contains the persistent cache of git repositories kept
+// between runs. Each repository is kept as a bare mirror, named after
+// its URL, which is fetched into when it is used again. Working trees
+// are cloned from the mirror rather than from the repository itself,
+// with their own copies of its objects, so that fetching into the
+// mirror or removing it does not break them.
+
+// RepoCache makes git working trees use bare mirrors kept in dir,
+// fetching into them rather than cloning afresh. If maxSize is
+// positive, the least recently used mirrors are removed once the
+// total size of the cache in bytes is above it. Other VCSs are
+// cloned as usual.
+func RepoCache(dir string, maxSize int64) WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		o.cacheDir = dir
+		o.cacheMaxSize = maxSize
+	}
+}
+
+// repoCacheMu guards repoCacheLocks.
+var repoCacheMu sync.Mutex
+
+// repoCacheLocks serialises use of each mirror within this process.
+var repoCacheLocks = make(map[string]*sync.Mutex)
+
+// mirrorPath returns the path of the mirror of repo in dir.
+func mirrorPath(dir, repo string) string {
+	sum := sha256.Sum256([]byte(normalizeRepoURL(repo)))
+	return filepath.Join(dir, hex.EncodeToString(sum[:16])+".git")
+}
+
+// lockMirror locks the mirror at pth against use by other goroutines
+// and processes, returning the function to unlock it.
+func lockMirror(pth string) (func(), error) {
+	repoCacheMu.Lock()
+	mu, ok := repoCacheLocks[pth]
+	if !ok {
+		mu = &sync.Mutex{}
+		repoCacheLocks[pth] = mu
+	}
+	repoCacheMu.Unlock()
+	mu.Lock()
+
+	f, err := os.OpenFile(pth+".lock", os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		mu.Unlock()
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		mu.Unlock()
+		return nil, errors.Wrap(err, "locking "+pth)
+	}
+	return func() {
+		f.Close() // releases the lock
+		mu.Unlock()
+	}, nil
+}
+
+// updateMirror makes the mirror of repo in the repository cache
+// from config up to date, cloning it if there is none yet, and
+// returns its path. The mirror is left locked, and the returned
+// function unlocks it.
+func updateMirror(ctx context.Context, config *workingTreeOptions, repo string, options []string) (string, func(), error) {
+	dir := config.cacheDir
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", nil, err
+	}
+	mirror := mirrorPath(dir, repo)
+	unlock, err := lockMirror(mirror)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := fetchMirror(ctx, config, repo, mirror, options); err != nil {
+		unlock()
+		return "", nil, err
+	}
+	return mirror, unlock, nil
+}
+
+// fetchMirror brings the locked mirror of repo up to date, cloning
+// it if there is none yet.
+func fetchMirror(ctx context.Context, config *workingTreeOptions, repo, mirror string, options []string) error {
+	env := config.env
+	run := func(dir string, args ...string) error {
+		args = append(append([]string{}, options...), args...)
+		output, err := config.retry.run(ctx, env.log(SubsystemVCS), repo, func(ctx context.Context) (string, error) {
+			release := acquireHost(repo)
+			defer release()
+			stdout, stderr, err := runProcess(ctx, withEnv(env.command(vcsGit, args...), config.authEnv), dir)
+			return strings.TrimSpace(stdout.String() + stderr.String()), err
+		})
+		if err != nil {
+			log.Debugf("%s: %s", repo, output)
+		}
+		return err
+	}
+
+	if _, err := os.Stat(mirror); err == nil {
+		log.Debugf("%s: fetching into %s", repo, mirror)
+		if err := run(mirror, "fetch", "--prune", "--quiet", "origin"); err == nil {
+			now := time.Now()
+			os.Chtimes(mirror, now, now)
+			return nil
+		}
+		// Start again
+		if err := os.RemoveAll(mirror); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := ioutil.TempDir(config.cacheDir, "tmp.")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	log.Debugf("%s: mirroring into %s", repo, mirror)
+	if err := run(".", "clone", "--mirror", "--quiet", "--", repo, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, mirror)
+}
+
+// cloneFromMirror clones the mirror of repo into dir for use as a
+// working tree, with its origin set to repo. The objects needed are
+// copied rather than shared, so the mirror must only be locked while
+// cloning.
+func cloneFromMirror(ctx context.Context, env *scanEnv, mirror, repo, dir string, options []string) error {
+	for _, args := range [][]string{
+		{"clone", "--reference", mirror, "--dissociate", "--quiet", "--", mirror, dir},
+		{"-C", dir, "remote", "set-url", "origin", repo},
+	} {
+		args = append(append([]string{}, options...), args...)
+		stdout, stderr, err := runProcess(ctx, env.command(vcsGit, args...), ".")
+		if err != nil {
+			log.Debugf("%s: %s", repo, strings.TrimSpace(stdout.String()+stderr.String()))
+			return err
+		}
+	}
+	return nil
+}
+
+// evictMirrors removes the least recently used mirrors from dir
+// until its total size is no more than maxSize.
+func evictMirrors(dir string, maxSize int64) {
+	mirrors, err := filepath.Glob(filepath.Join(dir, "*.git"))
+	if err != nil {
+		return
+	}
+	type entry struct {
+		path  string
+		size  int64
+		mtime time.Time
+	}
+	var entries []entry
+	var total int64
+	for _, pth := range mirrors {
+		info, err := os.Stat(pth)
+		if err != nil {
+			continue
+		}
+		size := dirSize(pth)
+		total += size
+		entries = append(entries, entry{pth, size, info.ModTime()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].mtime.Before(entries[j].mtime)
+	})
+	for _, e := range entries {
+		if total <= maxSize {
+			break
+		}
+		unlock, err := lockMirror(e.path)
+		if err != nil {
+			continue
+		}
+		log.Debugf("evicting %s from repository cache", e.path)
+		if err := os.RemoveAll(e.path); err == nil {
+			total -= e.size
+		}
+		os.Remove(e.path + ".lock")
+		unlock()
+	}
+}
+
+// cachedClone clones repo into dir using the repository cache
+// configured in config.
+func cachedClone(ctx context.Context, config *workingTreeOptions, repo, dir string, options []string) error {
+	mirror, unlock, err := updateMirror(ctx, config, repo, options)
+	if err != nil {
+		return err
+	}
+	err = cloneFromMirror(ctx, config.env, mirror, repo, dir, options)
+	unlock()
+	if err != nil {
+		return err
+	}
+	if config.cacheMaxSize > 0 {
+		evictMirrors(config.cacheDir, config.cacheMaxSize)
+	}
+	return nil
+}
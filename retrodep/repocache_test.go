@@ -0,0 +1,162 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMirrorPath(t *testing.T) {
+	a := mirrorPath("/cache", "https://github.com/foo/bar")
+	if b := mirrorPath("/cache", "https://github.com/Foo/bar.git"); a != b {
+		t.Errorf("different mirrors %s and %s", a, b)
+	}
+	if b := mirrorPath("/cache", "https://github.com/foo/baz"); a == b {
+		t.Errorf("same mirror %s", a)
+	}
+	if filepath.Dir(a) != "/cache" || filepath.Ext(a) != ".git" {
+		t.Errorf("unexpected path %s", a)
+	}
+}
+
+func TestEvictMirrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Three mirrors of 100 bytes each, oldest first.
+	data := make([]byte, 100)
+	var mirrors []string
+	for i, name := range []string{"old", "locked", "new"} {
+		mirror := filepath.Join(dir, name+".git")
+		writeFiles(t, mirror, map[string]string{"objects/pack/p.pack": string(data)})
+		mtime := time.Now().Add(time.Duration(i-3) * time.Hour)
+		if err := os.Chtimes(mirror, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		mirrors = append(mirrors, mirror)
+	}
+	evictMirrors(dir, 250)
+	for i, expected := range []bool{false, true, true} {
+		_, err := os.Stat(mirrors[i])
+		if exists := err == nil; exists != expected {
+			t.Errorf("%s: exists=%t, want %t", mirrors[i], exists, expected)
+		}
+	}
+
+	// A mirror being cloned from is evicted once it is unlocked.
+	unlock, err := lockMirror(mirrors[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	evicted := make(chan struct{})
+	go func() {
+		evictMirrors(dir, 0)
+		close(evicted)
+	}()
+	select {
+	case <-evicted:
+		t.Error("locked mirror not waited for")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := os.Stat(mirrors[1]); err != nil {
+		t.Error("locked mirror was evicted")
+	}
+	unlock()
+	<-evicted
+	for _, mirror := range mirrors[1:] {
+		if _, err := os.Stat(mirror); err == nil {
+			t.Errorf("%s not evicted", mirror)
+		}
+	}
+}
+
+func TestCloneFromMirror(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("needs git")
+	}
+	dir := t.TempDir()
+	git := func(dir string, args ...string) {
+		t.Helper()
+		p := exec.Command("git", args...)
+		p.Dir = dir
+		p.Env = append(commandEnv(), "GIT_AUTHOR_NAME=a", "GIT_AUTHOR_EMAIL=a@example.com",
+			"GIT_COMMITTER_NAME=a", "GIT_COMMITTER_EMAIL=a@example.com", "GIT_CONFIG_NOSYSTEM=1")
+		if output, err := p.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s: %s", args, err, output)
+		}
+	}
+	upstream := filepath.Join(dir, "upstream")
+	writeFiles(t, upstream, map[string]string{"README": "hello\n"})
+	git(upstream, "init", "--quiet")
+	git(upstream, "add", "README")
+	git(upstream, "commit", "--quiet", "-m", "init")
+
+	config := &workingTreeOptions{cacheDir: filepath.Join(dir, "cache")}
+	wtDir := filepath.Join(dir, "wt")
+	if err := cachedClone(context.Background(), config, upstream, wtDir, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The working tree does not depend on the mirror's objects.
+	if err := os.RemoveAll(mirrorPath(config.cacheDir, upstream)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(wtDir, ".git", "objects", "info", "alternates")); err == nil {
+		t.Error("objects shared with the mirror")
+	}
+	git(wtDir, "fsck", "--no-dangling")
+	git(wtDir, "log", "-1")
+}
+
+func TestUpdateMirror(t *testing.T) {
+	defer mockExecCommand()()
+	dir, err := ioutil.TempDir("", "retrodep-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := "https://github.com/foo/bar"
+	mirror, unlock, err := updateMirror(context.Background(), &workingTreeOptions{cacheDir: dir}, repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if mirror != mirrorPath(dir, repo) {
+		t.Errorf("got %s", mirror)
+	}
+	if _, err := os.Stat(mirror); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed fetch means cloning again, which fails too.
+	mockedExitStatus = 1
+	if _, _, err := updateMirror(context.Background(), &workingTreeOptions{cacheDir: dir}, repo, nil); err == nil {
+		t.Error("failure not reported")
+	}
+	if _, err := os.Stat(mirror); err == nil {
+		t.Error("broken mirror kept")
+	}
+}
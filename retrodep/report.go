@@ -0,0 +1,237 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportSchemaVersion is the version of the JSON schema (see
+// schema/report.schema.json) which Report conforms to.
+//
+// The minor version is incremented when optional fields are added;
+// consumers should ignore fields they do not recognise. The major
+// version is incremented when fields are removed or their meaning
+// changes, and consumers should reject reports with a major version
+// they do not support.
+const ReportSchemaVersion = "1.21"
+
+// Report is the structured output describing a top-level project
+// and its vendored dependencies.
+type Report struct {
+	// SchemaVersion is the ReportSchemaVersion this report was
+	// written with.
+	SchemaVersion string `json:"schemaVersion"`
+
+	// Projects holds a Reference for the top-level project
+	// followed by one for each vendored project. Projects whose
+	// version could not be identified have no Ver.
+	Projects []*Reference `json:"projects"`
+
+	// Violations holds the policy violations found, if a Policy
+	// was evaluated. Added in schema version 1.1.
+	Violations []*PolicyViolation `json:"violations,omitempty"`
+
+	// Health holds the state of each upstream repository, if
+	// this was checked. Added in schema version 1.2.
+	Health []*RepoHealth `json:"health,omitempty"`
+
+	// Assets holds the vendored files outside any vendored
+	// project, if these were looked for. Added in schema version
+	// 1.7.
+	Assets []*Asset `json:"assets,omitempty"`
+}
+
+// NewReport returns an empty *Report for the current schema version.
+func NewReport() *Report {
+	return &Report{
+		SchemaVersion: ReportSchemaVersion,
+		Projects:      make([]*Reference, 0),
+	}
+}
+
+// Match statuses a Reference in a Report can have.
+const (
+	// MatchTag means the project matched a tag.
+	MatchTag = "tag"
+
+	// MatchRevision means the project matched an untagged
+	// revision, described by a pseudo-version.
+	MatchRevision = "revision"
+
+	// MatchNone means no upstream revision matched the project.
+	MatchNone = "none"
+)
+
+// matchStatus returns the Match... constant describing ref.
+func matchStatus(ref *Reference) string {
+	switch {
+	case ref.Tag != "":
+		return MatchTag
+	case ref.Rev != "":
+		return MatchRevision
+	default:
+		return MatchNone
+	}
+}
+
+// Add appends ref to the report, setting its Match status if it does
+// not have one.
+func (r *Report) Add(ref *Reference) {
+	if ref.Match == "" {
+		ref.Match = matchStatus(ref)
+	}
+	r.Projects = append(r.Projects, ref)
+}
+
+// identified returns the top-level projects in the report and the
+// vendored projects whose version was identified.
+func (r *Report) identified() []*Reference {
+	var refs []*Reference
+	for _, ref := range r.Projects {
+		if ref.TopPkg == "" || ref.Ver != "" {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// digest returns a hash of the versions of refs and the time, for
+// identifying a document describing them.
+func digest(refs []*Reference, created time.Time) []byte {
+	h := sha256.New()
+	for _, ref := range refs {
+		io.WriteString(h, ref.Pkg+"@"+ref.Ver+"\n")
+	}
+	io.WriteString(h, created.UTC().Format(time.RFC3339))
+	return h.Sum(nil)
+}
+
+// AddViolation appends v to the report.
+func (r *Report) AddViolation(v *PolicyViolation) {
+	r.Violations = append(r.Violations, v)
+}
+
+// AddHealth appends h to the report.
+func (r *Report) AddHealth(h *RepoHealth) {
+	r.Health = append(r.Health, h)
+}
+
+// AddAsset appends a to the report.
+func (r *Report) AddAsset(a *Asset) {
+	r.Assets = append(r.Assets, a)
+}
+
+// sort puts the contents of the report in a stable order, so that
+// reports written for the same results are identical: each top-level
+// project is followed by its vendored projects, sorted by import
+// path, and their files are sorted by path.
+func (r *Report) sort() {
+	sort.SliceStable(r.Projects, func(i, j int) bool {
+		return referenceLess(r.Projects[i], r.Projects[j])
+	})
+	for _, ref := range r.Projects {
+		files := ref.Files
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].Path < files[j].Path
+		})
+	}
+	sort.SliceStable(r.Violations, func(i, j int) bool {
+		a, b := r.Violations[i], r.Violations[j]
+		if a.Pkg != b.Pkg {
+			return a.Pkg < b.Pkg
+		}
+		return a.Rule < b.Rule
+	})
+	sort.SliceStable(r.Health, func(i, j int) bool {
+		return r.Health[i].Pkg < r.Health[j].Pkg
+	})
+	sort.SliceStable(r.Assets, func(i, j int) bool {
+		return r.Assets[i].Path < r.Assets[j].Path
+	})
+}
+
+// referenceLess reports whether a comes before b: top-level projects
+// are ordered by import path, each followed by its vendored projects
+// ordered by import path.
+func referenceLess(a, b *Reference) bool {
+	top := func(ref *Reference) string {
+		if ref.TopPkg != "" {
+			return ref.TopPkg
+		}
+		return ref.Pkg
+	}
+	if ta, tb := top(a), top(b); ta != tb {
+		return ta < tb
+	}
+	if (a.TopPkg == "") != (b.TopPkg == "") {
+		return a.TopPkg == ""
+	}
+	return a.Pkg < b.Pkg
+}
+
+// Write writes the report as JSON to w, in a stable order.
+func (r *Report) Write(w io.Writer) error {
+	r.sort()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// ReadReport reads a JSON report from r. It returns an error if the
+// report was written with an incompatible schema version.
+func ReadReport(r io.Reader) (*Report, error) {
+	var report Report
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return nil, err
+	}
+	if err := checkSchemaVersion(report.SchemaVersion); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// schemaMajor returns the major component of a schema version.
+func schemaMajor(version string) (int, error) {
+	major := strings.SplitN(version, ".", 2)[0]
+	n, err := strconv.Atoi(major)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q", version)
+	}
+	return n, nil
+}
+
+// checkSchemaVersion returns an error unless version is compatible
+// with ReportSchemaVersion.
+func checkSchemaVersion(version string) error {
+	have, err := schemaMajor(version)
+	if err != nil {
+		return err
+	}
+	want, _ := schemaMajor(ReportSchemaVersion)
+	if have != want {
+		return fmt.Errorf("unsupported schema version %q (want %d.x)",
+			version, want)
+	}
+	return nil
+}
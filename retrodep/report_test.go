@@ -0,0 +1,188 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportRoundTrip(t *testing.T) {
+	report := NewReport()
+	report.Add(&Reference{Pkg: "example.com/foo", Ver: "v1.0.0"})
+	report.Add(&Reference{TopPkg: "example.com/foo", Pkg: "example.com/bar"})
+	report.AddViolation(&PolicyViolation{
+		Pkg:     "example.com/bar",
+		Rule:    RuleUntagged,
+		Message: "v0.0.0-0.20190101000000-0123456789ab is not a tag",
+	})
+
+	var buf bytes.Buffer
+	if err := report.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"schemaVersion": "`+ReportSchemaVersion+`"`) {
+		t.Errorf("schemaVersion missing: %s", buf.String())
+	}
+
+	read, err := ReadReport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Projects) != 2 {
+		t.Fatalf("got %d projects, want 2", len(read.Projects))
+	}
+	if !reflect.DeepEqual(read.Projects, report.Projects) {
+		t.Errorf("got %v, want %v", read.Projects, report.Projects)
+	}
+	if len(read.Violations) != 1 || *read.Violations[0] != *report.Violations[0] {
+		t.Errorf("got %v, want %v", read.Violations, report.Violations)
+	}
+}
+
+func TestReadReportSchemaVersion(t *testing.T) {
+	tcs := []struct {
+		version string
+		expOk   bool
+	}{
+		{"1.0", true},
+		{"1.7", true},
+		{"2.0", false},
+		{"", false},
+	}
+	for _, tc := range tcs {
+		r := strings.NewReader(`{"schemaVersion":"` + tc.version + `","projects":[]}`)
+		_, err := ReadReport(r)
+		if (err == nil) != tc.expOk {
+			t.Errorf("%q: got %v, want ok:%t", tc.version, err, tc.expOk)
+		}
+	}
+}
+
+func TestReportMatch(t *testing.T) {
+	tcs := []struct {
+		ref   Reference
+		match string
+	}{
+		{Reference{Tag: "v1.0.0", Rev: "0123456789ab", Ver: "v1.0.0"}, MatchTag},
+		{Reference{Rev: "0123456789ab", Ver: "v1.0.1-0.20190101000000-0123456789ab"}, MatchRevision},
+		{Reference{}, MatchNone},
+	}
+	for _, tc := range tcs {
+		report := NewReport()
+		ref := tc.ref
+		report.Add(&ref)
+		if ref.Match != tc.match {
+			t.Errorf("%v: got %q, want %q", tc.ref, ref.Match, tc.match)
+		}
+	}
+}
+
+func TestReportStableOrder(t *testing.T) {
+	src, err := NewGoSource("testdata/gosource", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proj, err := src.Project("github.com/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// run matches the project and makes a report of it, with the
+	// other results added in the order given.
+	run := func(reverse bool) *Report {
+		wt := &mockVendorWorkingTree{}
+		wt.hasher = &dummyHasher{}
+		wt.localHashes, err = src.hashLocalFiles(wt, proj, src.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		top, err := src.DescribeProject(proj, wt, src.Path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		top.Files, err = src.FileMatches(proj, wt, src.Path, top.Rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs := []*Reference{
+			top,
+			{TopPkg: top.Pkg, Pkg: "example.com/a", Ver: "v1.0.0", Rev: "a"},
+			{TopPkg: top.Pkg, Pkg: "example.com/b", Ver: "v2.0.0", Rev: "b"},
+			{Pkg: "example.com/other", Ver: "v0.1.0"},
+			{TopPkg: "example.com/other", Pkg: "example.com/c"},
+		}
+		violations := []*PolicyViolation{
+			{Pkg: "example.com/b", Rule: RuleUntagged},
+			{Pkg: "example.com/a", Rule: RuleReleasesBehind},
+			{Pkg: "example.com/a", Rule: RuleUntagged},
+		}
+		assets := []*Asset{{Path: "vendor/x.txt"}, {Path: "vendor/a.txt"}}
+		if reverse {
+			for i, j := 0, len(refs)-1; i < j; i, j = i+1, j-1 {
+				refs[i], refs[j] = refs[j], refs[i]
+			}
+			violations[1], violations[2] = violations[2], violations[1]
+			assets[0], assets[1] = assets[1], assets[0]
+		}
+		report := NewReport()
+		for _, ref := range refs {
+			report.Add(ref)
+		}
+		for _, v := range violations {
+			report.AddViolation(v)
+		}
+		for _, a := range assets {
+			report.AddAsset(a)
+		}
+		return report
+	}
+
+	created := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	writers := map[string]func(*Report, io.Writer) error{
+		"json":      (*Report).Write,
+		"gomod":     (*Report).WriteGoModRequire,
+		"spdx":      func(r *Report, w io.Writer) error { return r.WriteSPDX(w, created) },
+		"cyclonedx": func(r *Report, w io.Writer) error { return r.WriteCycloneDX(w, created) },
+	}
+	for name, write := range writers {
+		var first, second bytes.Buffer
+		if err := write(run(false), &first); err != nil {
+			t.Fatal(err)
+		}
+		if err := write(run(true), &second); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Errorf("%s: output differs:\n%s\n%s", name, first.String(), second.String())
+		}
+	}
+
+	report := run(true)
+	report.sort()
+	var pkgs []string
+	for _, ref := range report.Projects {
+		pkgs = append(pkgs, ref.Pkg)
+	}
+	expected := []string{"example.com/other", "example.com/c", "github.com/foo/bar", "example.com/a", "example.com/b"}
+	if !reflect.DeepEqual(pkgs, expected) {
+		t.Errorf("got %v, want %v", pkgs, expected)
+	}
+}
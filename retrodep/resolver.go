@@ -0,0 +1,208 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// Resolver finds the repository root for an import path.
+type Resolver interface {
+	// RepoRootForImportPath returns the repository root for
+	// importPath. The returned Root is a prefix of importPath.
+	RepoRootForImportPath(importPath string) (*vcs.RepoRoot, error)
+}
+
+// ResolverFunc is a function which is a Resolver.
+type ResolverFunc func(importPath string) (*vcs.RepoRoot, error)
+
+// RepoRootForImportPath calls f(importPath).
+func (f ResolverFunc) RepoRootForImportPath(importPath string) (*vcs.RepoRoot, error) {
+	return f(importPath)
+}
+
+// DefaultResolver is the Resolver used by a GoSource unless
+// SetResolver is called. It knows the golang.org/x repositories and
+// otherwise uses vcs.RepoRootForImportPath.
+var DefaultResolver Resolver = ResolverFunc(func(importPath string) (*vcs.RepoRoot, error) {
+	if root, ok := golangxRepoRoot(importPath); ok {
+		return root, nil
+	}
+	return vcsRepoRootForImportPath(importPath, false)
+})
+
+// OfflineResolver is a Resolver which makes no network requests. It
+// knows the golang.org/x repositories, and those for github.com and
+// gopkg.in import paths, whose repositories follow from the path.
+// For other import paths it returns ErrorOffline.
+var OfflineResolver Resolver = ResolverFunc(func(importPath string) (*vcs.RepoRoot, error) {
+	if root, ok := golangxRepoRoot(importPath); ok {
+		return root, nil
+	}
+	if root, ok := offlineRepoRoot(importPath); ok {
+		return root, nil
+	}
+	return nil, ErrorOffline
+})
+
+// offlineRepoRoot returns the git repository root for a github.com
+// or gopkg.in import path, as the go tool finds it.
+func offlineRepoRoot(importPath string) (*vcs.RepoRoot, bool) {
+	parts := strings.Split(importPath, "/")
+	var root, repo string
+	switch {
+	case parts[0] == "github.com" && len(parts) >= 3:
+		root = strings.Join(parts[:3], "/")
+		repo = "https://" + root
+	case parts[0] == "gopkg.in" && len(parts) >= 2:
+		// gopkg.in/pkg.v1 is github.com/go-pkg/pkg, and
+		// gopkg.in/user/pkg.v1 is github.com/user/pkg.
+		user, pkg := "", parts[1]
+		if !gopkgVersionRE.MatchString(pkg) {
+			if len(parts) < 3 {
+				return nil, false
+			}
+			user, pkg = parts[1], parts[2]
+		}
+		loc := gopkgVersionRE.FindStringIndex(pkg)
+		if loc == nil {
+			return nil, false
+		}
+		name := pkg[:loc[0]]
+		if user == "" {
+			user = "go-" + name
+			root = "gopkg.in/" + pkg
+		} else {
+			root = "gopkg.in/" + user + "/" + pkg
+		}
+		repo = "https://github.com/" + user + "/" + name
+	default:
+		return nil, false
+	}
+	return &vcs.RepoRoot{
+		VCS:  vcs.ByCmd(vcsGit),
+		Repo: repo,
+		Root: root,
+	}, true
+}
+
+// gopkgVersionRE matches the major version suffix of a gopkg.in
+// package name.
+var gopkgVersionRE = regexp.MustCompile(`\.v[0-9]+(-unstable)?$`)
+
+// offline is set by SetOffline.
+var offline bool
+
+// SetOffline stops the network being used to find the repositories
+// for import paths: DefaultResolver is replaced by OfflineResolver,
+// and redirects are not followed. Repositories for other import paths
+// must be given with other Resolvers, such as a StaticResolver.
+func SetOffline() {
+	offline = true
+	DefaultResolver = OfflineResolver
+}
+
+// StaticResolver maps import path prefixes to their repositories.
+// The Root of each is its import path prefix.
+type StaticResolver map[string]*vcs.RepoRoot
+
+// RepoRootForImportPath returns the repository for the longest
+// prefix of importPath, or ErrorNoRepoRoot if there is none.
+func (s StaticResolver) RepoRootForImportPath(importPath string) (*vcs.RepoRoot, error) {
+	var best string
+	for prefix := range s {
+		if len(prefix) > len(best) &&
+			(importPath == prefix || strings.HasPrefix(importPath, prefix+"/")) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return nil, ErrorNoRepoRoot
+	}
+	root := *s[best]
+	root.Root = best
+	return &root, nil
+}
+
+// ReadStaticResolver parses a repository roots file from r. Each
+// line has an import path prefix, a VCS command (such as "git") and
+// a repository URL, separated by whitespace. Blank lines and lines
+// starting with "#" are ignored. Errors give the line number.
+func ReadStaticResolver(r io.Reader) (StaticResolver, error) {
+	s := make(StaticResolver)
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected import path prefix, VCS and repository URL: %s", lineno, line)
+		}
+		v := vcsByCmd(fields[1])
+		if v == nil {
+			return nil, fmt.Errorf("line %d: %s: %s: %s", lineno, fields[0], ErrorUnknownVCS, fields[1])
+		}
+		s[fields[0]] = &vcs.RepoRoot{
+			VCS:  v,
+			Repo: fields[2],
+			Root: fields[0],
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ChainResolver is a Resolver which tries each Resolver in turn.
+type ChainResolver []Resolver
+
+// RepoRootForImportPath returns the first repository root found, or
+// else the error from the last Resolver.
+func (c ChainResolver) RepoRootForImportPath(importPath string) (*vcs.RepoRoot, error) {
+	err := ErrorNoRepoRoot
+	for _, r := range c {
+		var root *vcs.RepoRoot
+		root, err = r.RepoRootForImportPath(importPath)
+		if err == nil {
+			return root, nil
+		}
+	}
+	return nil, err
+}
+
+// SetResolver sets the Resolver used to find repositories for
+// import paths in this GoSource, after relocations are applied.
+func (src *GoSource) SetResolver(r Resolver) {
+	src.resolver = r
+}
+
+// resolve returns the repository root for importPath using the
+// Resolver for this GoSource.
+func (src GoSource) resolve(importPath string) (*vcs.RepoRoot, error) {
+	if src.resolver != nil {
+		return src.resolver.RepoRootForImportPath(importPath)
+	}
+	return DefaultResolver.RepoRootForImportPath(importPath)
+}
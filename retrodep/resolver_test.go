@@ -0,0 +1,150 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestStaticResolver(t *testing.T) {
+	s, err := ReadStaticResolver(strings.NewReader(`# comment
+example.com/corp     git https://git.example.com/corp.git
+example.com/corp/lib hg  https://hg.example.com/lib
+
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcs := []struct {
+		importPath, vcs, repo, root string
+	}{
+		{"example.com/corp/tool/cmd", "git", "https://git.example.com/corp.git", "example.com/corp"},
+		{"example.com/corp/lib/sub", "hg", "https://hg.example.com/lib", "example.com/corp/lib"},
+		{"example.com/corporate", "", "", ""},
+	}
+	for _, tc := range tcs {
+		root, err := s.RepoRootForImportPath(tc.importPath)
+		if tc.repo == "" {
+			if err != ErrorNoRepoRoot {
+				t.Errorf("%s: got %v, %v", tc.importPath, root, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.importPath, err)
+			continue
+		}
+		if root.VCS.Cmd != tc.vcs || root.Repo != tc.repo || root.Root != tc.root {
+			t.Errorf("%s: got %s %s (%s)", tc.importPath, root.VCS.Cmd, root.Repo, root.Root)
+		}
+	}
+
+	for _, tc := range []struct {
+		roots, err string
+	}{
+		{"example.com/x cvs https://x\n", "line 1: example.com/x: unknown VCS: cvs"},
+		{"# comment\n\nincomplete\n", "line 3: expected import path prefix, VCS and repository URL: incomplete"},
+		{"example.com/x git https://x extra\n", "line 1: expected import path prefix, VCS and repository URL: example.com/x git https://x extra"},
+	} {
+		_, err := ReadStaticResolver(strings.NewReader(tc.roots))
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%q: got %v, want %s", tc.roots, err, tc.err)
+		}
+	}
+}
+
+func TestSetResolver(t *testing.T) {
+	defer mockRepoRoots("github.com/foo/bar")()
+
+	static := StaticResolver{
+		"example.com/corp": {VCS: vcs.ByCmd(vcsGit), Repo: "https://git.example.com/corp"},
+	}
+	src := &GoSource{}
+	src.SetResolver(ChainResolver{static, DefaultResolver})
+	src.AddRelocations(Relocations{"example.com/old": "example.com/corp"})
+
+	tcs := []struct {
+		importPath, repo, root string
+	}{
+		{"example.com/corp/pkg", "https://git.example.com/corp", "example.com/corp"},
+		{"example.com/old/pkg", "https://git.example.com/corp", "example.com/old"},
+		{"github.com/foo/bar/baz", "https://github.com/foo/bar", "github.com/foo/bar"},
+	}
+	for _, tc := range tcs {
+		root, err := src.repoRootForImportPath(tc.importPath)
+		if err != nil {
+			t.Errorf("%s: %s", tc.importPath, err)
+			continue
+		}
+		if root.Repo != tc.repo || root.Root != tc.root {
+			t.Errorf("%s: got %s (%s)", tc.importPath, root.Repo, root.Root)
+		}
+	}
+
+	if _, err := src.repoRootForImportPath("example.com/unknown"); err == nil || err == ErrorNoRepoRoot {
+		t.Errorf("expected error from last resolver, got %v", err)
+	}
+}
+
+func TestOfflineResolver(t *testing.T) {
+	tcs := []struct {
+		importPath, repo, root string
+	}{
+		{"github.com/foo/bar/baz", "https://github.com/foo/bar", "github.com/foo/bar"},
+		{"gopkg.in/yaml.v2", "https://github.com/go-yaml/yaml", "gopkg.in/yaml.v2"},
+		{"gopkg.in/src-d/go-git.v4/plumbing", "https://github.com/src-d/go-git", "gopkg.in/src-d/go-git.v4"},
+		{"golang.org/x/net/context", "https://go.googlesource.com/net", "golang.org/x/net"},
+		{"github.com/foo", "", ""},
+		{"gopkg.in/yaml", "", ""},
+		{"example.com/corp", "", ""},
+	}
+	for _, tc := range tcs {
+		root, err := OfflineResolver.RepoRootForImportPath(tc.importPath)
+		if tc.repo == "" {
+			if err != ErrorOffline {
+				t.Errorf("%s: got %v, %v", tc.importPath, root, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.importPath, err)
+			continue
+		}
+		if root.Repo != tc.repo || root.Root != tc.root || root.VCS.Cmd != "git" {
+			t.Errorf("%s: got %s (%s)", tc.importPath, root.Repo, root.Root)
+		}
+	}
+
+	// Redirects are not followed offline.
+	defer func() { lookupRedirect = followRedirect }()
+	lookupRedirect = func(importPath string) (string, bool) {
+		t.Errorf("%s: redirect looked up", importPath)
+		return "", false
+	}
+	defer func(saved Resolver) {
+		DefaultResolver = saved
+		offline = false
+	}(DefaultResolver)
+	SetOffline()
+	src := &GoSource{}
+	if _, err := src.repoRootForImportPath("example.com/corp"); err != ErrorOffline {
+		t.Errorf("example.com/corp: got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RetryPolicy says how clones and fetches which fail are tried
+// again. The zero RetryPolicy tries each only once.
+type RetryPolicy struct {
+	// Retries is the number of times a failed clone or fetch is
+	// tried again. Failures which trying again would not fix,
+	// such as those needing authentication or for repositories
+	// which do not exist, are not retried.
+	Retries int
+
+	// Backoff is the time to wait before the first retry. It is
+	// doubled for each retry after that.
+	Backoff time.Duration
+
+	// MaxBackoff, if set, is the longest time to wait before a
+	// retry.
+	MaxBackoff time.Duration
+
+	// Timeout, if set, is how long each attempt may take before
+	// it is stopped and counted as a failure.
+	Timeout time.Duration
+}
+
+// Retry makes working trees retry the clones and fetches which fail
+// according to p.
+func Retry(p RetryPolicy) WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		o.retry = p
+	}
+}
+
+// permanentFailures holds the messages, in lower case, from git, hg,
+// svn and bzr showing the repository does not exist.
+var permanentFailures = []string{
+	"repository not found",
+	"does not appear to be a git repository",
+	"the requested url returned error: 404",
+	"http error 404",
+}
+
+// retryable returns true unless the output of a failed VCS command
+// shows a failure which trying again would not fix.
+func retryable(output string) bool {
+	if cloneFailure(output) == ErrorAuthRequired {
+		return false
+	}
+	output = strings.ToLower(output)
+	for _, msg := range permanentFailures {
+		if strings.Contains(output, msg) {
+			return false
+		}
+	}
+	return true
+}
+
+// delay returns the time to wait before retry n, counting from 1.
+func (p RetryPolicy) delay(n int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < n && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// run calls attempt, which returns the output of a VCS command run
+// with ctx and its error, until it succeeds, fails in a way which
+// is not retryable, or has been retried p.Retries times. The output
+// and error from the last attempt are returned. Each retry is logged
+// as being for what.
+func (p RetryPolicy) run(ctx context.Context, log subsystemLogger, what string, attempt func(ctx context.Context) (string, error)) (string, error) {
+	for n := 1; ; n++ {
+		actx, cancel := ctx, context.CancelFunc(func() {})
+		if p.Timeout > 0 {
+			actx, cancel = context.WithTimeout(ctx, p.Timeout)
+		}
+		output, err := attempt(actx)
+		timedOut := err != nil && actx.Err() != nil && ctx.Err() == nil
+		cancel()
+		if timedOut {
+			err = fmt.Errorf("timed out after %s", p.Timeout)
+		}
+		if err == nil || n > p.Retries || ctx.Err() != nil ||
+			(!timedOut && !retryable(output)) {
+			return output, err
+		}
+
+		d := p.delay(n)
+		log.Infof("%s: %s, retrying in %s", what, err, d)
+		metrics.Add(MetricRetries, 1)
+		select {
+		case <-ctx.Done():
+			return output, err
+		case <-time.After(d):
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for n, exp := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if d := p.delay(n + 1); d != exp {
+			t.Errorf("%d: got %s, want %s", n+1, d, exp)
+		}
+	}
+}
+
+func TestRetryRun(t *testing.T) {
+	failure := errors.New("exit status 128")
+	type tcase struct {
+		name     string
+		policy   RetryPolicy
+		outputs  []string
+		attempts int
+		err      bool
+	}
+	tcases := []tcase{
+		{
+			name:     "success",
+			policy:   RetryPolicy{Retries: 2},
+			outputs:  []string{""},
+			attempts: 1,
+		},
+		{
+			name:     "transient",
+			policy:   RetryPolicy{Retries: 2},
+			outputs:  []string{"fatal: unable to access: Could not resolve host: example.com", ""},
+			attempts: 2,
+		},
+		{
+			name:     "exhausted",
+			policy:   RetryPolicy{Retries: 2},
+			outputs:  []string{"early EOF", "early EOF", "early EOF", ""},
+			attempts: 3,
+			err:      true,
+		},
+		{
+			name:     "auth",
+			policy:   RetryPolicy{Retries: 2},
+			outputs:  []string{"fatal: Authentication failed", ""},
+			attempts: 1,
+			err:      true,
+		},
+		{
+			name:     "missing",
+			policy:   RetryPolicy{Retries: 2},
+			outputs:  []string{"remote: Repository not found.", ""},
+			attempts: 1,
+			err:      true,
+		},
+		{
+			name:     "no-retries",
+			outputs:  []string{"early EOF", ""},
+			attempts: 1,
+			err:      true,
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			_, err := tc.policy.run(context.Background(), subsystemLogger{}, "repo", func(ctx context.Context) (string, error) {
+				output := tc.outputs[attempts]
+				attempts++
+				if output == "" {
+					return "", nil
+				}
+				return output, failure
+			})
+			if attempts != tc.attempts {
+				t.Errorf("attempts: got %d, want %d", attempts, tc.attempts)
+			}
+			if (err != nil) != tc.err {
+				t.Errorf("got %v", err)
+			}
+		})
+	}
+}
+
+func TestRetryTimeout(t *testing.T) {
+	p := RetryPolicy{Retries: 1, Timeout: 10 * time.Millisecond}
+	attempts := 0
+	_, err := p.run(context.Background(), subsystemLogger{}, "repo", func(ctx context.Context) (string, error) {
+		attempts++
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	if attempts != 2 {
+		t.Errorf("attempts: got %d", attempts)
+	}
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRetryClone(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "retrodep-retry.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	clones := 0
+	s := NewScanner(Options{
+		Command: func(name string, args ...string) *exec.Cmd {
+			for _, arg := range args {
+				if arg == "clone" {
+					clones++
+					if clones == 1 {
+						return exec.Command("sh", "-c", "echo 'fatal: early EOF' >&2; exit 128")
+					}
+				}
+			}
+			return exec.Command("true")
+		},
+		TempDir:     tmp,
+		TreeOptions: []WorkingTreeOption{Retry(RetryPolicy{Retries: 1})},
+	})
+	root := &vcs.RepoRoot{
+		VCS:  vcs.ByCmd(vcsGit),
+		Repo: "https://example.invalid/foo",
+		Root: "example.invalid/foo",
+	}
+	wt, err := s.NewWorkingTree(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt.Close()
+	if clones != 2 {
+		t.Errorf("cloned %d times", clones)
+	}
+}
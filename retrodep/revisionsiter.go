@@ -0,0 +1,55 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import "errors"
+
+// A RevisionsIterWorkingTree is a WorkingTree which can also list its
+// revisions as they are found, so that they need not all be held at
+// once and listing can stop early.
+type RevisionsIterWorkingTree interface {
+	WorkingTree
+
+	// RevisionsIter calls yield with each revision Revisions
+	// would return, in the same order, until yield returns true
+	// to stop or an error, which is returned.
+	RevisionsIter(yield func(rev string) (stop bool, err error)) error
+}
+
+// errStopRevisions ends the listing of revisions from inside
+// RevisionsIter implementations when yield asks to stop.
+var errStopRevisions = errors.New("stop listing revisions")
+
+// RevisionsIter calls yield with each revision of wt, newest to
+// oldest, until yield returns true to stop or an error, which is
+// returned. Revisions are read as they are needed if wt is a
+// RevisionsIterWorkingTree, and otherwise from Revisions.
+func RevisionsIter(wt WorkingTree, yield func(rev string) (stop bool, err error)) error {
+	if iwt, ok := wt.(RevisionsIterWorkingTree); ok {
+		return iwt.RevisionsIter(yield)
+	}
+	revs, err := wt.Revisions()
+	if err != nil {
+		return err
+	}
+	for _, rev := range revs {
+		stop, err := yield(rev)
+		if err != nil || stop {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// iterWorkingTree has revisions r0 (newest) to r1999, of which the
+// local file matches r600 to r700, and counts those listed.
+type iterWorkingTree struct {
+	stubWorkingTree
+	listed int
+}
+
+func (wt *iterWorkingTree) RevisionsIter(yield func(rev string) (bool, error)) error {
+	for i := 0; i < 2000; i++ {
+		wt.listed++
+		stop, err := yield(fmt.Sprintf("r%d", i))
+		if err != nil || stop {
+			return err
+		}
+	}
+	return nil
+}
+
+func (wt *iterWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	var i int
+	fmt.Sscanf(ref, "r%d", &i)
+	if i >= 600 && i <= 700 {
+		return FileHashes{"a.go": "1"}, nil
+	}
+	return FileHashes{"a.go": "2"}, nil
+}
+
+func TestMatchLayoutsFromRevisions(t *testing.T) {
+	wt := &iterWorkingTree{}
+	layouts := []moduleLayout{{FileHashes{"a.go": "1"}, ""}}
+	matches, err := matchLayoutsFromRevisions(false, layouts, wt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 101 || matches[0] != "r600" || matches[100] != "r700" {
+		t.Errorf("got %d matches: %v", len(matches), matches)
+	}
+	// The matching run spans two batches, and the revisions after
+	// the second are not listed.
+	if wt.listed != 2*revisionBatch {
+		t.Errorf("listed %d revisions", wt.listed)
+	}
+
+	wt = &iterWorkingTree{}
+	layouts = []moduleLayout{{FileHashes{"a.go": "3"}, ""}}
+	if _, err := matchLayoutsFromRevisions(false, layouts, wt); err != ErrorVersionNotFound {
+		t.Errorf("no match: got %v", err)
+	}
+	if wt.listed != 2000 {
+		t.Errorf("listed %d revisions", wt.listed)
+	}
+}
+
+// sliceWorkingTree lists its revisions only with Revisions.
+type sliceWorkingTree struct{ stubWorkingTree }
+
+func (wt *sliceWorkingTree) Revisions() ([]string, error) {
+	return []string{"c", "b", "a"}, nil
+}
+
+func TestRevisionsIter(t *testing.T) {
+	var revs []string
+	err := RevisionsIter(&sliceWorkingTree{}, func(rev string) (bool, error) {
+		revs = append(revs, rev)
+		return rev == "b", nil
+	})
+	if err != nil || !reflect.DeepEqual(revs, []string{"c", "b"}) {
+		t.Errorf("got %v, %v", revs, err)
+	}
+
+	failed := errors.New("failed")
+	err = RevisionsIter(&sliceWorkingTree{}, func(rev string) (bool, error) {
+		return false, failed
+	})
+	if err != failed {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestGitRevisionsIter(t *testing.T) {
+	defer mockExecCommand()()
+	mockedStdout = strings.Repeat("0123456789abcdef0123456789abcdef01234567\n", 3) +
+		"d4c3dbfa77a74ae238e401d5d2197b45f30d8513\n"
+	g := &gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{VCS: vcs.ByCmd(vcsGit)},
+	}
+	var revs []string
+	err := g.RevisionsIter(func(rev string) (bool, error) {
+		revs = append(revs, rev)
+		return len(revs) == 2, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 2 {
+		t.Errorf("got %v", revs)
+	}
+
+	revs = nil
+	if err := RevisionsIter(g, func(rev string) (bool, error) {
+		revs = append(revs, rev)
+		return false, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 4 || revs[3] != "d4c3dbfa77a74ae238e401d5d2197b45f30d8513" {
+		t.Errorf("got %v", revs)
+	}
+
+	mockedExitStatus = 1
+	if err := g.RevisionsIter(func(rev string) (bool, error) { return false, nil }); err == nil {
+		t.Error("failure not reported")
+	}
+}
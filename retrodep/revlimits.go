@@ -0,0 +1,80 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"strconv"
+	"time"
+)
+
+// RevisionLimits restricts the revisions listed by the Revisions
+// method of git and Mercurial working trees, for when it is roughly
+// known when a project was copied.
+type RevisionLimits struct {
+	// Since, if not zero, leaves out revisions committed before
+	// this time.
+	Since time.Time
+
+	// Until, if not zero, leaves out revisions committed after
+	// this time.
+	Until time.Time
+
+	// Max, if not 0, is the most revisions listed, newest first.
+	Max int
+}
+
+// LimitRevisions makes git and Mercurial working trees only list the
+// revisions within limits.
+func LimitRevisions(limits RevisionLimits) WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		o.limits = limits
+	}
+}
+
+// gitArgs returns the 'git rev-list' arguments for the limits.
+func (l RevisionLimits) gitArgs() []string {
+	var args []string
+	if !l.Since.IsZero() {
+		args = append(args, "--since="+l.Since.Format(time.RFC3339))
+	}
+	if !l.Until.IsZero() {
+		args = append(args, "--until="+l.Until.Format(time.RFC3339))
+	}
+	if l.Max > 0 {
+		args = append(args, "--max-count="+strconv.Itoa(l.Max))
+	}
+	return args
+}
+
+// hgDateFormat is the layout of the dates in hg date ranges.
+const hgDateFormat = "2006-01-02 15:04:05 -0700"
+
+// hgArgs returns the 'hg log' arguments for the limits.
+func (l RevisionLimits) hgArgs() []string {
+	var args []string
+	switch {
+	case !l.Since.IsZero() && !l.Until.IsZero():
+		args = append(args, "-d", l.Since.Format(hgDateFormat)+" to "+l.Until.Format(hgDateFormat))
+	case !l.Since.IsZero():
+		args = append(args, "-d", ">"+l.Since.Format(hgDateFormat))
+	case !l.Until.IsZero():
+		args = append(args, "-d", "<"+l.Until.Format(hgDateFormat))
+	}
+	if l.Max > 0 {
+		args = append(args, "-l", strconv.Itoa(l.Max))
+	}
+	return args
+}
@@ -0,0 +1,86 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestRevisionLimits(t *testing.T) {
+	since := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	until := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tcs := []struct {
+		limits RevisionLimits
+		git    []string
+		hg     []string
+	}{
+		{RevisionLimits{}, nil, nil},
+		{
+			RevisionLimits{Since: since, Until: until, Max: 10},
+			[]string{"--since=2019-01-02T03:04:05Z", "--until=2020-01-02T03:04:05Z", "--max-count=10"},
+			[]string{"-d", "2019-01-02 03:04:05 +0000 to 2020-01-02 03:04:05 +0000", "-l", "10"},
+		},
+		{
+			RevisionLimits{Since: since},
+			[]string{"--since=2019-01-02T03:04:05Z"},
+			[]string{"-d", ">2019-01-02 03:04:05 +0000"},
+		},
+		{
+			RevisionLimits{Until: until},
+			[]string{"--until=2020-01-02T03:04:05Z"},
+			[]string{"-d", "<2020-01-02 03:04:05 +0000"},
+		},
+	}
+	for _, tc := range tcs {
+		if args := tc.limits.gitArgs(); !reflect.DeepEqual(args, tc.git) {
+			t.Errorf("%+v: git: got %v, want %v", tc.limits, args, tc.git)
+		}
+		if args := tc.limits.hgArgs(); !reflect.DeepEqual(args, tc.hg) {
+			t.Errorf("%+v: hg: got %v, want %v", tc.limits, args, tc.hg)
+		}
+	}
+}
+
+func TestGitRevisionsLimited(t *testing.T) {
+	var args []string
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(command string, a ...string) *exec.Cmd {
+		args = a
+		return exec.Command("printf", "%s", "0123456789abcdef\n")
+	}
+
+	wt := gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			VCS:    vcs.ByCmd(vcsGit),
+			limits: RevisionLimits{Max: 1},
+		},
+	}
+	revs, err := wt.Revisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(revs, []string{"0123456789abcdef"}) {
+		t.Errorf("got %v", revs)
+	}
+	if exp := []string{"rev-list", "--all", "--max-count=1"}; !reflect.DeepEqual(args, exp) {
+		t.Errorf("ran %v, want %v", args, exp)
+	}
+}
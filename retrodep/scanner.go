@@ -0,0 +1,185 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"io/ioutil"
+	"os/exec"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// This file contains the Scanner, which carries the settings
+// otherwise taken from package-level variables, so that several
+// can be used at once with different settings, for instance within
+// a service, or in tests.
+
+// CommandRunner returns the command to run the program name with
+// args, as exec.Command does.
+type CommandRunner func(name string, args ...string) *exec.Cmd
+
+// Options configures a Scanner. Zero fields mean the package-level
+// settings are used.
+type Options struct {
+	// Command makes the commands run by the Scanner's working
+	// trees, such as git. The default is exec.Command.
+	Command CommandRunner
+
+	// TempDir is the directory working trees, and files exported
+	// from them, are created in. The default is the directory
+	// set by SetTempDir, or else os.TempDir().
+	TempDir string
+
+	// Cache, if set, is used instead of the DiskCache set by
+	// SetDiskCache.
+	Cache *DiskCache
+
+	// Logger, if set, receives the messages about the Scanner's
+	// clones and scans, and those from its working trees, instead
+	// of the Logger set by SetLogger.
+	Logger Logger
+
+	// Concurrency limits the clones and hashing done by this
+	// Scanner, separately from any other. Zero fields mean the
+	// defaults are used.
+	Concurrency Concurrency
+
+	// TreeOptions are the options for the working trees.
+	TreeOptions []WorkingTreeOption
+}
+
+// Scanner describes projects, cloning their upstream repositories,
+// with the settings from its Options.
+type Scanner struct {
+	env  *scanEnv
+	opts []WorkingTreeOption
+}
+
+// NewScanner returns a Scanner with the settings from o.
+func NewScanner(o Options) *Scanner {
+	c := o.Concurrency
+	defaults := DefaultConcurrency()
+	if c.Clones <= 0 {
+		c.Clones = defaults.Clones
+	}
+	if c.Hashers <= 0 {
+		c.Hashers = defaults.Hashers
+	}
+	env := &scanEnv{
+		run:     o.Command,
+		dir:     o.TempDir,
+		cache:   o.Cache,
+		logger:  o.Logger,
+		clones:  make(limiter, c.Clones),
+		hashers: make(limiter, c.Hashers),
+	}
+	opts := append([]WorkingTreeOption{}, o.TreeOptions...)
+	opts = append(opts, func(o *workingTreeOptions) {
+		o.env = env
+	})
+	return &Scanner{env: env, opts: opts}
+}
+
+// TreeOptions returns the options giving working trees the
+// Scanner's settings, such as for NewScanServer.
+func (s *Scanner) TreeOptions() []WorkingTreeOption {
+	return append([]WorkingTreeOption{}, s.opts...)
+}
+
+// NewWorkingTree is NewWorkingTreeContext with the Scanner's
+// settings.
+func (s *Scanner) NewWorkingTree(ctx context.Context, project *vcs.RepoRoot) (WorkingTree, error) {
+	return NewWorkingTreeContext(ctx, project, s.opts...)
+}
+
+// Scan is the function Scan with the Scanner's settings.
+func (s *Scanner) Scan(ctx context.Context, src *GoSource, importPath string) (*Report, error) {
+	return Scan(ctx, src, importPath, s.opts...)
+}
+
+// scanEnv holds the settings of a Scanner. A nil *scanEnv, as for
+// working trees not created by a Scanner, uses the package-level
+// settings.
+type scanEnv struct {
+	run     CommandRunner
+	dir     string
+	cache   *DiskCache
+	logger  Logger
+	clones  limiter
+	hashers limiter
+}
+
+// envOf returns the settings of h, if it is a working tree.
+func envOf(h Hasher) *scanEnv {
+	if wt, ok := h.(interface{ environment() *scanEnv }); ok {
+		return wt.environment()
+	}
+	return nil
+}
+
+// command returns the command to run name with args.
+func (e *scanEnv) command(name string, args ...string) *exec.Cmd {
+	if e == nil || e.run == nil {
+		return execCommand(name, args...)
+	}
+	return e.run(name, args...)
+}
+
+// tempDir creates a temporary directory whose name starts with
+// prefix.
+func (e *scanEnv) tempDir(prefix string) (string, error) {
+	dir := ""
+	if e != nil {
+		dir = e.dir
+	}
+	if dir == "" {
+		dir = tempDirInUse()
+	}
+	return ioutil.TempDir(dir, prefix)
+}
+
+// diskCache returns the DiskCache to use, if any.
+func (e *scanEnv) diskCache() *DiskCache {
+	if e == nil || e.cache == nil {
+		return diskCacheInUse()
+	}
+	return e.cache
+}
+
+// log returns the logger for subsystem.
+func (e *scanEnv) log(subsystem string) subsystemLogger {
+	if e == nil {
+		return subsystemLogger{subsystem: subsystem}
+	}
+	return subsystemLogger{subsystem: subsystem, logger: e.logger}
+}
+
+// cloneLimiter returns the limiter for creating working trees.
+func (e *scanEnv) cloneLimiter() limiter {
+	if e == nil {
+		return cloneLimiter()
+	}
+	return e.clones
+}
+
+// hashLimiter returns the limiter for hashing files.
+func (e *scanEnv) hashLimiter() limiter {
+	if e == nil {
+		return hashLimiter()
+	}
+	return e.hashers
+}
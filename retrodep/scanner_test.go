@@ -0,0 +1,115 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// messageLogger is a Logger which keeps every message.
+type messageLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *messageLogger) Enabled(level LogLevel, subsystem string) bool {
+	return true
+}
+
+func (l *messageLogger) Log(level LogLevel, subsystem, msg string, fields map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, subsystem+": "+msg)
+}
+
+func TestScannerSettings(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "retrodep-scanner.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var ran [][]string
+	logger := &messageLogger{}
+	s := NewScanner(Options{
+		Command: func(name string, args ...string) *exec.Cmd {
+			ran = append(ran, append([]string{name}, args...))
+			return exec.Command("sh", "-c", "echo 'fatal: Authentication failed' >&2; exit 128")
+		},
+		TempDir:     tmp,
+		Logger:      logger,
+		Concurrency: Concurrency{Clones: 1},
+	})
+	if cap(s.env.clones) != 1 || cap(s.env.hashers) != DefaultConcurrency().Hashers {
+		t.Errorf("limits %d, %d", cap(s.env.clones), cap(s.env.hashers))
+	}
+
+	root := &vcs.RepoRoot{
+		VCS:  vcs.ByCmd(vcsGit),
+		Repo: "https://example.invalid/foo",
+		Root: "example.invalid/foo",
+	}
+	_, err = s.NewWorkingTree(context.Background(), root)
+	if !errors.Is(err, ErrorAuthRequired) {
+		t.Errorf("got %v", err)
+	}
+	if len(ran) != 1 || ran[0][0] != vcsGit {
+		t.Fatalf("ran %v", ran)
+	}
+	if dir := ran[0][len(ran[0])-1]; !strings.HasPrefix(dir, tmp+string(os.PathSeparator)) {
+		t.Errorf("cloned into %s", dir)
+	}
+	if entries, _ := ioutil.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("left %d entries in %s", len(entries), tmp)
+	}
+	found := false
+	for _, msg := range logger.msgs {
+		if strings.Contains(msg, "Authentication failed") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("logged %q", logger.msgs)
+	}
+}
+
+func TestScanEnvDefaults(t *testing.T) {
+	var env *scanEnv
+	if env.cloneLimiter() == nil || env.hashLimiter() == nil {
+		t.Error("no default limits")
+	}
+	if l := env.log(SubsystemVCS); l.to() != logger {
+		t.Error("not the package Logger")
+	}
+
+	e := &scanEnv{}
+	wt := &gitWorkingTree{anyWorkingTree: anyWorkingTree{env: e}}
+	if envOf(wt) != e {
+		t.Error("working tree settings not found")
+	}
+	if envOf(&sha256Hasher{}) != nil {
+		t.Error("hasher has settings")
+	}
+}
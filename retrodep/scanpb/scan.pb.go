@@ -0,0 +1,414 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: retrodep/scanpb/scan.proto
+
+package scanpb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+// ScanRequest asks for the project in a repository to be scanned.
+type ScanRequest struct {
+	// Repo is the URL of the repository to clone.
+	Repo string `protobuf:"bytes,1,opt,name=repo,proto3" json:"repo,omitempty"`
+	// VCS is the version control system of repo, such as "git" (the
+	// default) or "hg".
+	Vcs string `protobuf:"bytes,2,opt,name=vcs,proto3" json:"vcs,omitempty"`
+	// Ref is the tag, revision or branch to scan, or "" for the
+	// default branch.
+	Ref string `protobuf:"bytes,3,opt,name=ref,proto3" json:"ref,omitempty"`
+	// ImportPath is the import path of the top-level project, or ""
+	// for it to be worked out.
+	ImportPath           string   `protobuf:"bytes,4,opt,name=import_path,json=importPath,proto3" json:"import_path,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ScanRequest) Reset()         { *m = ScanRequest{} }
+func (m *ScanRequest) String() string { return proto.CompactTextString(m) }
+func (*ScanRequest) ProtoMessage()    {}
+func (*ScanRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fc2f484781711593, []int{0}
+}
+
+func (m *ScanRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ScanRequest.Unmarshal(m, b)
+}
+func (m *ScanRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ScanRequest.Marshal(b, m, deterministic)
+}
+func (m *ScanRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ScanRequest.Merge(m, src)
+}
+func (m *ScanRequest) XXX_Size() int {
+	return xxx_messageInfo_ScanRequest.Size(m)
+}
+func (m *ScanRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ScanRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ScanRequest proto.InternalMessageInfo
+
+func (m *ScanRequest) GetRepo() string {
+	if m != nil {
+		return m.Repo
+	}
+	return ""
+}
+
+func (m *ScanRequest) GetVcs() string {
+	if m != nil {
+		return m.Vcs
+	}
+	return ""
+}
+
+func (m *ScanRequest) GetRef() string {
+	if m != nil {
+		return m.Ref
+	}
+	return ""
+}
+
+func (m *ScanRequest) GetImportPath() string {
+	if m != nil {
+		return m.ImportPath
+	}
+	return ""
+}
+
+// ScanResult holds the projects found by a scan.
+type ScanResult struct {
+	// Projects holds the top-level project followed by each vendored
+	// project.
+	Projects             []*Project `protobuf:"bytes,1,rep,name=projects,proto3" json:"projects,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *ScanResult) Reset()         { *m = ScanResult{} }
+func (m *ScanResult) String() string { return proto.CompactTextString(m) }
+func (*ScanResult) ProtoMessage()    {}
+func (*ScanResult) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fc2f484781711593, []int{1}
+}
+
+func (m *ScanResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ScanResult.Unmarshal(m, b)
+}
+func (m *ScanResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ScanResult.Marshal(b, m, deterministic)
+}
+func (m *ScanResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ScanResult.Merge(m, src)
+}
+func (m *ScanResult) XXX_Size() int {
+	return xxx_messageInfo_ScanResult.Size(m)
+}
+func (m *ScanResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_ScanResult.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ScanResult proto.InternalMessageInfo
+
+func (m *ScanResult) GetProjects() []*Project {
+	if m != nil {
+		return m.Projects
+	}
+	return nil
+}
+
+// Project describes the upstream version of a project.
+type Project struct {
+	// ImportPath is the import path of the project.
+	ImportPath string `protobuf:"bytes,1,opt,name=import_path,json=importPath,proto3" json:"import_path,omitempty"`
+	// Repo is the URL of the upstream repository.
+	Repo string `protobuf:"bytes,2,opt,name=repo,proto3" json:"repo,omitempty"`
+	// TopImportPath is the import path of the top-level project this
+	// project is vendored into, or "" for the top-level project.
+	TopImportPath string `protobuf:"bytes,3,opt,name=top_import_path,json=topImportPath,proto3" json:"top_import_path,omitempty"`
+	// Rev is the upstream revision matched, or "" if none was.
+	Rev string `protobuf:"bytes,4,opt,name=rev,proto3" json:"rev,omitempty"`
+	// Tag is the semver tag matched, or "" if no tag was.
+	Tag string `protobuf:"bytes,5,opt,name=tag,proto3" json:"tag,omitempty"`
+	// Version is the semantic version or pseudo-version of rev.
+	Version string `protobuf:"bytes,6,opt,name=version,proto3" json:"version,omitempty"`
+	// Match is "tag", "revision" or "none".
+	Match string `protobuf:"bytes,7,opt,name=match,proto3" json:"match,omitempty"`
+	// Files describes how each file compares with rev, if this was
+	// checked.
+	Files                []*FileStatus `protobuf:"bytes,8,rep,name=files,proto3" json:"files,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *Project) Reset()         { *m = Project{} }
+func (m *Project) String() string { return proto.CompactTextString(m) }
+func (*Project) ProtoMessage()    {}
+func (*Project) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fc2f484781711593, []int{2}
+}
+
+func (m *Project) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Project.Unmarshal(m, b)
+}
+func (m *Project) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Project.Marshal(b, m, deterministic)
+}
+func (m *Project) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Project.Merge(m, src)
+}
+func (m *Project) XXX_Size() int {
+	return xxx_messageInfo_Project.Size(m)
+}
+func (m *Project) XXX_DiscardUnknown() {
+	xxx_messageInfo_Project.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Project proto.InternalMessageInfo
+
+func (m *Project) GetImportPath() string {
+	if m != nil {
+		return m.ImportPath
+	}
+	return ""
+}
+
+func (m *Project) GetRepo() string {
+	if m != nil {
+		return m.Repo
+	}
+	return ""
+}
+
+func (m *Project) GetTopImportPath() string {
+	if m != nil {
+		return m.TopImportPath
+	}
+	return ""
+}
+
+func (m *Project) GetRev() string {
+	if m != nil {
+		return m.Rev
+	}
+	return ""
+}
+
+func (m *Project) GetTag() string {
+	if m != nil {
+		return m.Tag
+	}
+	return ""
+}
+
+func (m *Project) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+func (m *Project) GetMatch() string {
+	if m != nil {
+		return m.Match
+	}
+	return ""
+}
+
+func (m *Project) GetFiles() []*FileStatus {
+	if m != nil {
+		return m.Files
+	}
+	return nil
+}
+
+// FileStatus describes how a file compares with upstream.
+type FileStatus struct {
+	// Path is the file's path, relative to the project.
+	Path string `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	// Status is "matched", "modified", "added" or "missing".
+	Status               string   `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FileStatus) Reset()         { *m = FileStatus{} }
+func (m *FileStatus) String() string { return proto.CompactTextString(m) }
+func (*FileStatus) ProtoMessage()    {}
+func (*FileStatus) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fc2f484781711593, []int{3}
+}
+
+func (m *FileStatus) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FileStatus.Unmarshal(m, b)
+}
+func (m *FileStatus) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FileStatus.Marshal(b, m, deterministic)
+}
+func (m *FileStatus) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FileStatus.Merge(m, src)
+}
+func (m *FileStatus) XXX_Size() int {
+	return xxx_messageInfo_FileStatus.Size(m)
+}
+func (m *FileStatus) XXX_DiscardUnknown() {
+	xxx_messageInfo_FileStatus.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FileStatus proto.InternalMessageInfo
+
+func (m *FileStatus) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *FileStatus) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+func init() {
+	proto.RegisterType((*ScanRequest)(nil), "retrodep.v1.ScanRequest")
+	proto.RegisterType((*ScanResult)(nil), "retrodep.v1.ScanResult")
+	proto.RegisterType((*Project)(nil), "retrodep.v1.Project")
+	proto.RegisterType((*FileStatus)(nil), "retrodep.v1.FileStatus")
+}
+
+func init() { proto.RegisterFile("retrodep/scanpb/scan.proto", fileDescriptor_fc2f484781711593) }
+
+var fileDescriptor_fc2f484781711593 = []byte{
+	// 360 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x92, 0xcd, 0xae, 0xd3, 0x30,
+	0x10, 0x85, 0x95, 0xdb, 0x9f, 0x5c, 0x26, 0x42, 0x20, 0xeb, 0x8a, 0x5a, 0xdd, 0x50, 0x65, 0x81,
+	0xba, 0x69, 0x02, 0x65, 0x03, 0x08, 0xb1, 0x40, 0x08, 0x89, 0x5d, 0x95, 0xee, 0xd8, 0x54, 0x6e,
+	0x98, 0xfc, 0xa0, 0x34, 0x36, 0xf6, 0x24, 0xef, 0xcc, 0x5b, 0x20, 0xdb, 0x69, 0x9b, 0x56, 0xac,
+	0x72, 0xe6, 0x3b, 0xb6, 0xc6, 0x27, 0x33, 0xb0, 0xd4, 0x48, 0x5a, 0xfe, 0x42, 0x95, 0x9a, 0x5c,
+	0xb4, 0xea, 0xe8, 0x3e, 0x89, 0xd2, 0x92, 0x24, 0x8b, 0xce, 0x5e, 0xd2, 0xbf, 0x8b, 0x0b, 0x88,
+	0xf6, 0xb9, 0x68, 0x33, 0xfc, 0xd3, 0xa1, 0x21, 0xc6, 0x60, 0xaa, 0x51, 0x49, 0x1e, 0xac, 0x82,
+	0xf5, 0xb3, 0xcc, 0x69, 0xf6, 0x12, 0x26, 0x7d, 0x6e, 0xf8, 0x83, 0x43, 0x56, 0x5a, 0xa2, 0xb1,
+	0xe0, 0x13, 0x4f, 0x34, 0x16, 0xec, 0x35, 0x44, 0xf5, 0x49, 0x49, 0x4d, 0x07, 0x25, 0xa8, 0xe2,
+	0x53, 0xe7, 0x80, 0x47, 0x3b, 0x41, 0x55, 0xfc, 0x05, 0xc0, 0xf7, 0x31, 0x5d, 0x43, 0xec, 0x2d,
+	0x3c, 0x2a, 0x2d, 0x7f, 0x63, 0x4e, 0x86, 0x07, 0xab, 0xc9, 0x3a, 0xda, 0x3e, 0x25, 0xa3, 0x57,
+	0x25, 0x3b, 0x6f, 0x66, 0x97, 0x53, 0xf1, 0xdf, 0x00, 0xc2, 0x81, 0xde, 0x37, 0x0b, 0xee, 0x9b,
+	0x5d, 0x52, 0x3c, 0x8c, 0x52, 0xbc, 0x81, 0x17, 0x24, 0xd5, 0x61, 0x7c, 0xd1, 0xbf, 0xff, 0x39,
+	0x49, 0xf5, 0xe3, 0x7a, 0xd7, 0x65, 0xeb, 0x87, 0x04, 0x56, 0x5a, 0x42, 0xa2, 0xe4, 0x33, 0x4f,
+	0x48, 0x94, 0x8c, 0x43, 0xd8, 0xa3, 0x36, 0xb5, 0x6c, 0xf9, 0xdc, 0xd1, 0x73, 0xc9, 0x9e, 0x60,
+	0x76, 0x12, 0x94, 0x57, 0x3c, 0x74, 0xdc, 0x17, 0x6c, 0x03, 0xb3, 0xa2, 0x6e, 0xd0, 0xf0, 0x47,
+	0x97, 0x75, 0x71, 0x93, 0xf5, 0x7b, 0xdd, 0xe0, 0x9e, 0x04, 0x75, 0x26, 0xf3, 0xa7, 0xe2, 0x0f,
+	0x00, 0x57, 0x68, 0xc3, 0x8c, 0x62, 0x3a, 0xcd, 0x5e, 0xc1, 0xdc, 0x38, 0x77, 0x88, 0x38, 0x54,
+	0xdb, 0x6f, 0x10, 0xda, 0xbf, 0xdc, 0xa2, 0x66, 0x1f, 0x61, 0x6a, 0x25, 0xe3, 0x37, 0xcd, 0x46,
+	0xb3, 0x5e, 0x2e, 0xfe, 0xe3, 0xd8, 0xe9, 0x7c, 0xfd, 0xfc, 0xf3, 0x53, 0x59, 0x53, 0xd5, 0x1d,
+	0x93, 0x5c, 0x9e, 0x52, 0x8d, 0x0d, 0x0a, 0x83, 0x1b, 0x6c, 0xcb, 0xba, 0x45, 0xd4, 0x75, 0x5b,
+	0xa6, 0x97, 0xed, 0xea, 0xb7, 0xe9, 0xdd, 0xa6, 0x1d, 0xe7, 0x6e, 0xcb, 0xde, 0xff, 0x1b, 0x00,
+	0x3b, 0x1b, 0xd2, 0x9b, 0x83, 0x02, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// ScannerClient is the client API for Scanner service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type ScannerClient interface {
+	// Scan clones the repository requested and scans the project in
+	// it, returning once the scan is done.
+	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*ScanResult, error)
+}
+
+type scannerClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewScannerClient(cc *grpc.ClientConn) ScannerClient {
+	return &scannerClient{cc}
+}
+
+func (c *scannerClient) Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*ScanResult, error) {
+	out := new(ScanResult)
+	err := c.cc.Invoke(ctx, "/retrodep.v1.Scanner/Scan", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ScannerServer is the server API for Scanner service.
+type ScannerServer interface {
+	// Scan clones the repository requested and scans the project in
+	// it, returning once the scan is done.
+	Scan(context.Context, *ScanRequest) (*ScanResult, error)
+}
+
+// UnimplementedScannerServer can be embedded to have forward compatible implementations.
+type UnimplementedScannerServer struct {
+}
+
+func (*UnimplementedScannerServer) Scan(ctx context.Context, req *ScanRequest) (*ScanResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Scan not implemented")
+}
+
+func RegisterScannerServer(s *grpc.Server, srv ScannerServer) {
+	s.RegisterService(&_Scanner_serviceDesc, srv)
+}
+
+func _Scanner_Scan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScannerServer).Scan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/retrodep.v1.Scanner/Scan",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScannerServer).Scan(ctx, req.(*ScanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Scanner_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "retrodep.v1.Scanner",
+	HandlerType: (*ScannerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Scan",
+			Handler:    _Scanner_Scan_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "retrodep/scanpb/scan.proto",
+}
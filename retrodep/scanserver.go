@@ -0,0 +1,472 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// Scan describes the top-level project in src, whose import path is
+// importPath or, if that is "", is worked out from src, and then its
+// vendored projects, cloning their upstream repositories with opts.
+// Projects whose version is not found, including those whose
+// repository cannot be used, are in the Report without one.
+func Scan(ctx context.Context, src *GoSource, importPath string, opts ...WorkingTreeOption) (*Report, error) {
+	project, err := src.Project(importPath)
+	if err != nil {
+		return nil, err
+	}
+	var config workingTreeOptions
+	for _, opt := range opts {
+		opt(&config)
+	}
+	log := config.env.log(SubsystemGeneral)
+
+	// Projects from the same repository share its working tree.
+	trees := make(map[string]WorkingTree)
+	defer func() {
+		for _, wt := range trees {
+			wt.Close()
+		}
+	}()
+	open := func(root *vcs.RepoRoot) (WorkingTree, error) {
+		key := root.VCS.Cmd + " " + root.Repo
+		if wt, ok := trees[key]; ok {
+			return wt, nil
+		}
+		wt, err := NewWorkingTreeContext(ctx, root, opts...)
+		if err != nil {
+			return nil, err
+		}
+		trees[key] = wt
+		return wt, nil
+	}
+
+	report := NewReport()
+	add := func(ref *Reference) {
+		ref.SourceDir = filepath.ToSlash(src.SubPath)
+		report.Add(ref)
+	}
+
+	top := &Reference{Pkg: project.Root, Repo: project.Repo}
+	if err := project.Err; err != nil {
+		log.Errorf("%s: %s", project.Root, err)
+	} else if wt, err := open(&project.RepoRoot); err != nil {
+		log.Errorf("%s: %s", project.Root, err)
+	} else {
+		ref, err := src.DescribeProject(project, wt, src.Path, nil)
+		if err != nil && err != ErrorVersionNotFound {
+			return nil, err
+		}
+		if ref != nil {
+			top = ref
+		}
+	}
+	add(top)
+
+	vendored, err := src.VendoredProjects()
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []string
+	for pkg := range vendored {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	for _, pkg := range pkgs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		vp := vendored[pkg]
+		ref := &Reference{TopPkg: top.Pkg, TopVer: top.Ver, Pkg: pkg}
+		if err := vp.Err; err != nil {
+			log.Errorf("%s: %s", pkg, err)
+		} else if wt, err := open(&vp.RepoRoot); err != nil {
+			log.Errorf("%s: %s", vp.Root, err)
+			ref.Pkg, ref.Repo = vp.Root, vp.Repo
+		} else {
+			found, err := src.DescribeVendoredProject(vp, wt, top)
+			if err != nil && err != ErrorVersionNotFound {
+				log.Errorf("%s: %s", vp.Root, err)
+			}
+			if found != nil {
+				ref = found
+			} else {
+				ref.Pkg, ref.Repo = vp.Root, vp.Repo
+			}
+		}
+		add(ref)
+	}
+	return report, nil
+}
+
+// ScanRequest asks a ScanServer to scan the project in a repository.
+type ScanRequest struct {
+	// Repo is the URL of the repository to clone.
+	Repo string `json:"repo"`
+
+	// VCS is the version control system of Repo, such as "git"
+	// (the default) or "hg".
+	VCS string `json:"vcs,omitempty"`
+
+	// Ref is the tag, revision or branch to scan, or "" for the
+	// default branch.
+	Ref string `json:"ref,omitempty"`
+
+	// ImportPath is the import path of the top-level project, or
+	// "" for it to be worked out.
+	ImportPath string `json:"importPath,omitempty"`
+}
+
+// Statuses of a ScanJob.
+const (
+	ScanQueued  = "queued"
+	ScanRunning = "running"
+	ScanDone    = "done"
+	ScanFailed  = "failed"
+)
+
+// ScanJob is a scan run by a ScanServer.
+type ScanJob struct {
+	// ID identifies the job in the URL /v1/scans/ID.
+	ID string `json:"id"`
+
+	// Status is one of the Scan... constants.
+	Status string `json:"status"`
+
+	// Request is the scan requested. For a source archive, only
+	// ImportPath is set.
+	Request ScanRequest `json:"request"`
+
+	// Created is when the scan was requested, and Finished when
+	// it was done or failed.
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	// Error explains why the scan failed.
+	Error string `json:"error,omitempty"`
+
+	// Report is the result of the scan, once it is done.
+	Report *Report `json:"report,omitempty"`
+
+	// src is the directory the source archive was unpacked in,
+	// or "" to clone Request.Repo.
+	src string
+}
+
+// scanPath is the path below which scans are requested and their
+// jobs are found.
+const scanPath = "/v1/scans"
+
+// scanArchiveMaxSize is the largest source archive accepted.
+const scanArchiveMaxSize = 1 << 30
+
+// scanJobsKept is the number of jobs remembered. Once there are more,
+// the oldest finished jobs are forgotten.
+const scanJobsKept = 1000
+
+// ScanServer is an http.Handler which accepts requests to scan
+// projects, and runs them in the background, so that retrodep can be
+// used as a service.
+//
+// POST a ScanRequest as JSON, or a gzipped tar archive of the source
+// with the import path in the "importpath" query parameter, to
+// /v1/scans. The response is 202 Accepted with the ScanJob, whose
+// URL is in the Location header. GET that URL for the ScanJob, which
+// has the Report once the scan is done.
+type ScanServer struct {
+	ctx  context.Context
+	dir  string
+	opts []WorkingTreeOption
+
+	queue chan *ScanJob
+
+	mu   sync.Mutex
+	jobs map[string]*ScanJob
+	ids  []string // oldest first
+}
+
+// NewScanServer returns a ScanServer which runs up to workers scans
+// at once, cloning upstream repositories with opts, until ctx is
+// done. Source archives are unpacked below dir, or the default
+// directory for temporary files if it is "".
+func NewScanServer(ctx context.Context, dir string, workers int, opts ...WorkingTreeOption) *ScanServer {
+	if workers < 1 {
+		workers = 1
+	}
+	s := &ScanServer{
+		ctx:   ctx,
+		dir:   dir,
+		opts:  opts,
+		queue: make(chan *ScanJob, scanJobsKept),
+		jobs:  make(map[string]*ScanJob),
+	}
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+	return s
+}
+
+func (s *ScanServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == scanPath:
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.create(w, r)
+	case strings.HasPrefix(r.URL.Path, scanPath+"/"):
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		job, ok := s.job(strings.TrimPrefix(r.URL.Path, scanPath+"/"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeScanJob(w, http.StatusOK, job)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// create queues the scan requested by r.
+func (s *ScanServer) create(w http.ResponseWriter, r *http.Request) {
+	id, err := newScanID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	job := &ScanJob{ID: id, Status: ScanQueued, Created: time.Now().UTC()}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err := dec.Decode(&job.Request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := job.Request.check(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "application/gzip", "application/x-gzip", "application/x-tar":
+		job.Request.ImportPath = r.URL.Query().Get("importpath")
+		job.src, err = ioutil.TempDir(s.dir, "retrodep-scan.")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body := http.MaxBytesReader(w, r.Body, scanArchiveMaxSize)
+		if err := extractTarball(body, job.src); err != nil {
+			os.RemoveAll(job.src)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "expected application/json or application/gzip",
+			http.StatusUnsupportedMediaType)
+		return
+	}
+
+	s.add(job)
+	select {
+	case s.queue <- job:
+	default:
+		if job.src != "" {
+			os.RemoveAll(job.src)
+		}
+		s.finish(job, nil, fmt.Errorf("too many scans queued"))
+	}
+	queued, _ := s.job(job.ID)
+	w.Header().Set("Location", scanPath+"/"+job.ID)
+	writeScanJob(w, http.StatusAccepted, queued)
+}
+
+// newScanID returns a random job ID.
+func newScanID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// add remembers job, forgetting the oldest finished jobs if there
+// are too many.
+func (s *ScanServer) add(job *ScanJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	s.ids = append(s.ids, job.ID)
+	for i := 0; len(s.jobs) > scanJobsKept && i < len(s.ids); {
+		id := s.ids[i]
+		if s.jobs[id].Finished == nil {
+			i++
+			continue
+		}
+		delete(s.jobs, id)
+		s.ids = append(s.ids[:i], s.ids[i+1:]...)
+	}
+}
+
+// job returns a copy of the job with the given ID.
+func (s *ScanServer) job(id string) (ScanJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return ScanJob{}, false
+	}
+	return *job, true
+}
+
+// finish records the result of job.
+func (s *ScanServer) finish(job *ScanJob, report *Report, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	job.Finished = &now
+	if err != nil {
+		job.Status = ScanFailed
+		job.Error = err.Error()
+		return
+	}
+	job.Status = ScanDone
+	job.Report = report
+}
+
+// work runs the queued scans until the server's context is done.
+func (s *ScanServer) work() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case job := <-s.queue:
+			s.mu.Lock()
+			job.Status = ScanRunning
+			s.mu.Unlock()
+			report, err := s.scan(job)
+			s.finish(job, report, err)
+		}
+	}
+}
+
+// scan runs the scan for job, removing its source afterwards.
+func (s *ScanServer) scan(job *ScanJob) (*Report, error) {
+	if job.src != "" {
+		defer os.RemoveAll(job.src)
+	}
+	return scanRequest(s.ctx, job.Request, job.src, s.opts)
+}
+
+// scanRepoSchemes are the URL schemes a ScanRequest's Repo may
+// have. Local paths, file URLs and transports such as git's ext::
+// would let whoever can reach the server read its files or run
+// commands on it.
+var scanRepoSchemes = map[string]bool{
+	"https": true,
+	"ssh":   true,
+}
+
+// check returns an error if r cannot be scanned, after setting its
+// VCS to git if it has none.
+func (r *ScanRequest) check() error {
+	if r.Repo == "" {
+		return errors.New("no repo")
+	}
+	if strings.HasPrefix(r.Repo, "-") {
+		return fmt.Errorf("repo %q starts with \"-\"", r.Repo)
+	}
+	if u, err := url.Parse(r.Repo); err != nil || !scanRepoSchemes[u.Scheme] || u.Host == "" {
+		return fmt.Errorf("repo %q is not an https or ssh URL", r.Repo)
+	}
+	if strings.HasPrefix(r.Ref, "-") {
+		return fmt.Errorf("ref %q starts with \"-\"", r.Ref)
+	}
+	if r.VCS == "" {
+		r.VCS = vcsGit
+	}
+	if vcsByCmd(r.VCS) == nil {
+		return ErrorUnknownVCS
+	}
+	return nil
+}
+
+// scanRequest scans the source in dir, or if dir is "" the source
+// cloned as req asks, cloning upstream repositories with opts.
+func scanRequest(ctx context.Context, req ScanRequest, dir string, opts []WorkingTreeOption) (*Report, error) {
+	if dir == "" {
+		root := &vcs.RepoRoot{VCS: vcsByCmd(req.VCS), Repo: req.Repo, Root: req.Repo}
+		wt, err := NewWorkingTreeContext(ctx, root, opts...)
+		if err != nil {
+			return nil, err
+		}
+		defer wt.Close()
+		if req.Ref != "" {
+			if err := wt.RevSync(req.Ref); err != nil {
+				return nil, fmt.Errorf("%s: %s", req.Ref, err)
+			}
+		}
+		dir = wt.Root()
+	}
+
+	srcs, err := FindGoSources(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	report := NewReport()
+	for _, src := range srcs {
+		r, err := Scan(ctx, src, req.ImportPath, opts...)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range r.Projects {
+			report.Add(ref)
+		}
+	}
+	return report, nil
+}
+
+// writeScanJob writes job as JSON with the given status code.
+func writeScanJob(w http.ResponseWriter, code int, job ScanJob) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(job)
+}
@@ -0,0 +1,148 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanServerErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewScanServer(ctx, "", 1)
+
+	for _, tc := range []struct {
+		method, path, contentType, body string
+		code                            int
+	}{
+		{"GET", "/v1/scans", "", "", http.StatusMethodNotAllowed},
+		{"POST", "/v1/scans", "text/plain", "x", http.StatusUnsupportedMediaType},
+		{"POST", "/v1/scans", "application/json", `{}`, http.StatusBadRequest},
+		{"POST", "/v1/scans", "application/json", `{"repo":"https://example.com/x","vcs":"cvs"}`, http.StatusBadRequest},
+		{"POST", "/v1/scans", "application/json", `{"repo":"--upload-pack=touch /tmp/x"}`, http.StatusBadRequest},
+		{"POST", "/v1/scans", "application/json", `{"repo":"/srv/git/x"}`, http.StatusBadRequest},
+		{"POST", "/v1/scans", "application/json", `{"repo":"file:///srv/git/x"}`, http.StatusBadRequest},
+		{"POST", "/v1/scans", "application/json", `{"repo":"ext::sh -c touch% /tmp/x"}`, http.StatusBadRequest},
+		{"POST", "/v1/scans", "application/json", `{"repo":"https:///x"}`, http.StatusBadRequest},
+		{"POST", "/v1/scans", "application/json", `{"repo":"https://example.com/x","ref":"--orphan=x"}`, http.StatusBadRequest},
+		{"POST", "/v1/scans", "application/gzip", "not gzip", http.StatusBadRequest},
+		{"GET", "/v1/scans/unknown", "", "", http.StatusNotFound},
+		{"GET", "/v2/scans", "", "", http.StatusNotFound},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if tc.contentType != "" {
+			r.Header.Set("Content-Type", tc.contentType)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s %s: got %d, want %d", tc.method, tc.path, tc.body, w.Code, tc.code)
+		}
+	}
+}
+
+func TestScanServerJob(t *testing.T) {
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		return exec.Command("sh", "-c", "echo 'fatal: unable to access: Could not resolve host: example.invalid' >&2; exit 128")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewScanServer(ctx, "", 1)
+
+	r := httptest.NewRequest("POST", "/v1/scans",
+		strings.NewReader(`{"repo":"https://example.invalid/foo","ref":"v1.0.0"}`))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	var job ScanJob
+	if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	if loc := w.Header().Get("Location"); loc != "/v1/scans/"+job.ID {
+		t.Errorf("location %q for %q", loc, job.ID)
+	}
+	if job.Request.VCS != "git" {
+		t.Errorf("vcs %q", job.Request.VCS)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for job.Status == ScanQueued || job.Status == ScanRunning {
+		if time.Now().After(deadline) {
+			t.Fatal("scan did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/v1/scans/"+job.ID, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("got %d", w.Code)
+		}
+		job = ScanJob{}
+		if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if job.Status != ScanFailed || !strings.Contains(job.Error, ErrorUnreachable.Error()) {
+		t.Errorf("got %s: %s", job.Status, job.Error)
+	}
+	if job.Finished == nil || job.Report != nil {
+		t.Errorf("unexpected %+v", job)
+	}
+}
+
+func TestScanServerQueueFull(t *testing.T) {
+	dir := t.TempDir()
+
+	// No workers and no room in the queue.
+	s := &ScanServer{
+		ctx:   context.Background(),
+		dir:   dir,
+		queue: make(chan *ScanJob),
+		jobs:  make(map[string]*ScanJob),
+	}
+	r := httptest.NewRequest("POST", "/v1/scans",
+		bytes.NewReader(tarball(t, "foo", map[string]string{"foo.go": "package foo\n"})))
+	r.Header.Set("Content-Type", "application/gzip")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	var job ScanJob
+	if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != ScanFailed || !strings.Contains(job.Error, "too many") {
+		t.Errorf("got %s: %s", job.Status, job.Error)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("%s not removed", entries[0].Name())
+	}
+}
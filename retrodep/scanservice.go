@@ -0,0 +1,102 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"errors"
+
+	"github.com/release-engineering/retrodep/v2/retrodep/scanpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ScanService implements the Scanner gRPC service defined in
+// scanpb/scan.proto, for services which would rather not run
+// retrodep itself. Register it with scanpb.RegisterScannerServer.
+//
+// Unlike ScanServer, each scan is run while its request waits. All
+// scans clone with the same options, so with RepoCache among them
+// the mirrors are shared by every request.
+type ScanService struct {
+	opts []WorkingTreeOption
+}
+
+// NewScanService returns a ScanService which clones upstream
+// repositories with opts.
+func NewScanService(opts ...WorkingTreeOption) *ScanService {
+	return &ScanService{opts: opts}
+}
+
+// Scan clones the repository in req and scans the project in it.
+func (s *ScanService) Scan(ctx context.Context, req *scanpb.ScanRequest) (*scanpb.ScanResult, error) {
+	r := ScanRequest{
+		Repo:       req.Repo,
+		VCS:        req.Vcs,
+		Ref:        req.Ref,
+		ImportPath: req.ImportPath,
+	}
+	if err := r.check(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	report, err := scanRequest(ctx, r, "", s.opts)
+	if err != nil {
+		return nil, status.Error(scanCode(ctx, err), err.Error())
+	}
+	return scanResult(report), nil
+}
+
+// scanCode returns the gRPC status code for the failure err of a
+// scan with ctx.
+func scanCode(ctx context.Context, err error) codes.Code {
+	switch {
+	case ctx.Err() == context.Canceled:
+		return codes.Canceled
+	case ctx.Err() == context.DeadlineExceeded:
+		return codes.DeadlineExceeded
+	case errors.Is(err, ErrorAuthRequired):
+		return codes.PermissionDenied
+	case errors.Is(err, ErrorUnreachable):
+		return codes.Unavailable
+	case errors.Is(err, ErrorNoGo), errors.Is(err, ErrorNeedImportPath):
+		return codes.FailedPrecondition
+	}
+	return codes.Unknown
+}
+
+// scanResult returns the scanpb.ScanResult for report.
+func scanResult(report *Report) *scanpb.ScanResult {
+	result := &scanpb.ScanResult{}
+	for _, ref := range report.Projects {
+		project := &scanpb.Project{
+			ImportPath:    ref.Pkg,
+			Repo:          ref.Repo,
+			TopImportPath: ref.TopPkg,
+			Rev:           ref.Rev,
+			Tag:           ref.Tag,
+			Version:       ref.Ver,
+			Match:         ref.Match,
+		}
+		for _, file := range ref.Files {
+			project.Files = append(project.Files, &scanpb.FileStatus{
+				Path:   file.Path,
+				Status: file.Status,
+			})
+		}
+		result.Projects = append(result.Projects, project)
+	}
+	return result
+}
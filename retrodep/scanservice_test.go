@@ -0,0 +1,141 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"net"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/release-engineering/retrodep/v2/retrodep/scanpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// scanClient returns a client for s served over an in-memory
+// connection, and the function to stop serving.
+func scanClient(t *testing.T, s *ScanService) (scanpb.ScannerClient, func()) {
+	l := bufconn.Listen(1 << 20)
+	g := grpc.NewServer()
+	scanpb.RegisterScannerServer(g, s)
+	go g.Serve(l)
+	conn, err := grpc.Dial("bufconn", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return l.Dial()
+		}))
+	if err != nil {
+		g.Stop()
+		t.Fatal(err)
+	}
+	return scanpb.NewScannerClient(conn), func() {
+		conn.Close()
+		g.Stop()
+	}
+}
+
+func TestScanServiceErrors(t *testing.T) {
+	s := NewScanner(Options{
+		Command: func(command string, args ...string) *exec.Cmd {
+			return exec.Command("sh", "-c", "echo 'fatal: unable to access: Could not resolve host: example.invalid' >&2; exit 128")
+		},
+	})
+	client, stop := scanClient(t, NewScanService(s.TreeOptions()...))
+	defer stop()
+	for _, tc := range []struct {
+		req  scanpb.ScanRequest
+		code codes.Code
+	}{
+		{scanpb.ScanRequest{}, codes.InvalidArgument},
+		{scanpb.ScanRequest{Repo: "x", Vcs: "cvs"}, codes.InvalidArgument},
+		{scanpb.ScanRequest{Repo: "--upload-pack=touch /tmp/x"}, codes.InvalidArgument},
+		{scanpb.ScanRequest{Repo: "file:///etc"}, codes.InvalidArgument},
+		{scanpb.ScanRequest{Repo: "https://example.invalid/foo", Ref: "--orphan=x"}, codes.InvalidArgument},
+		{scanpb.ScanRequest{Repo: "https://example.invalid/foo"}, codes.Unavailable},
+	} {
+		_, err := client.Scan(context.Background(), &tc.req)
+		if code := status.Code(err); code != tc.code {
+			t.Errorf("%v: got %v (%v), want %v", tc.req, code, err, tc.code)
+		}
+	}
+}
+
+func TestScanResult(t *testing.T) {
+	report := NewReport()
+	report.Add(&Reference{
+		Pkg:  "example.com/foo",
+		Repo: "https://example.com/foo",
+		Rev:  "0123456789abcdef",
+		Tag:  "v1.0.0",
+		Ver:  "v1.0.0",
+	})
+	report.Add(&Reference{
+		TopPkg: "example.com/foo",
+		Pkg:    "example.com/bar",
+		Repo:   "https://example.com/bar",
+		Rev:    "fedcba9876543210",
+		Ver:    "v0.0.0-20190101000000-fedcba987654",
+		Files: []FileMatch{
+			{Path: "bar.go", Status: FileMatched},
+			{Path: "local.go", Status: FileAdded},
+		},
+	})
+	exp := &scanpb.ScanResult{
+		Projects: []*scanpb.Project{
+			{
+				ImportPath: "example.com/foo",
+				Repo:       "https://example.com/foo",
+				Rev:        "0123456789abcdef",
+				Tag:        "v1.0.0",
+				Version:    "v1.0.0",
+				Match:      MatchTag,
+			},
+			{
+				ImportPath:    "example.com/bar",
+				Repo:          "https://example.com/bar",
+				TopImportPath: "example.com/foo",
+				Rev:           "fedcba9876543210",
+				Version:       "v0.0.0-20190101000000-fedcba987654",
+				Match:         MatchRevision,
+				Files: []*scanpb.FileStatus{
+					{Path: "bar.go", Status: FileMatched},
+					{Path: "local.go", Status: FileAdded},
+				},
+			},
+		},
+	}
+	result := scanResult(report)
+	if !proto.Equal(result, exp) {
+		t.Errorf("got %v, want %v", result, exp)
+	}
+
+	// The result survives the wire.
+	b, err := proto.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded scanpb.ScanResult
+	if err := proto.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Projects[1].Files, exp.Projects[1].Files) {
+		t.Errorf("decoded %v", decoded.Projects[1].Files)
+	}
+}
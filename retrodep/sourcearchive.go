@@ -0,0 +1,272 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sourceArchiveSuffixes are the file name suffixes of the source
+// archives ExtractSourceArchive can unpack.
+var sourceArchiveSuffixes = []string{".tar.gz", ".tgz", ".zip"}
+
+// IsSourceArchive returns true if path is a file, rather than a
+// directory, named as a gzipped tar archive (.tar.gz or .tgz) or a
+// zip file, such as a release tarball to be examined in place of a
+// checkout.
+func IsSourceArchive(path string) bool {
+	lower := strings.ToLower(path)
+	for _, suffix := range sourceArchiveSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			info, err := os.Stat(path)
+			return err == nil && info.Mode().IsRegular()
+		}
+	}
+	return false
+}
+
+// ExtractSourceArchive unpacks the source archive at path into dir,
+// which must exist, and returns the directory holding the source:
+// dir itself, or the single top-level directory release archives
+// usually put their files in. Regular files keep their permissions
+// and modification times, and symbolic links are kept to be hashed,
+// but other entries are skipped. Entries named outside dir, or below
+// a symbolic link in the archive, are refused, so that nothing is
+// written outside dir.
+func ExtractSourceArchive(path, dir string) (string, error) {
+	var err error
+	if strings.HasSuffix(strings.ToLower(path), ".zip") {
+		err = extractZipSource(path, dir)
+	} else {
+		err = extractTarSource(path, dir)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", path, err)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), nil
+	}
+	return dir, nil
+}
+
+// archiveEntry is a file, directory or symbolic link to write from a
+// source archive.
+type archiveEntry struct {
+	name    string
+	mode    os.FileMode
+	modTime time.Time
+	target  string // for a symbolic link
+	content io.Reader
+}
+
+// archiveWriter writes the entries of a source archive below dir,
+// remembering the symbolic links written so that nothing is written
+// through them.
+type archiveWriter struct {
+	dir   string
+	links map[string]bool
+}
+
+func newArchiveWriter(dir string) *archiveWriter {
+	return &archiveWriter{dir: dir, links: make(map[string]bool)}
+}
+
+// dest returns the slash-separated path of name relative to a.dir,
+// and where to write it, failing if that is outside a.dir or below
+// a symbolic link.
+func (a *archiveWriter) dest(name string) (string, string, error) {
+	rel := path.Clean(name)
+	if !filepath.IsLocal(filepath.FromSlash(rel)) || throughLink(a.links, rel) {
+		return "", "", fmt.Errorf("invalid file name %s", name)
+	}
+	return rel, filepath.Join(a.dir, filepath.FromSlash(rel)), nil
+}
+
+// write writes e below a.dir, or fails if it would be written outside
+// it. Entries other than regular files, directories and symbolic
+// links are skipped.
+func (a *archiveWriter) write(e *archiveEntry) error {
+	if path.Clean(e.name) == "." {
+		return nil
+	}
+	rel, dest, err := a.dest(e.name)
+	if err != nil {
+		return err
+	}
+	switch {
+	case e.mode.IsDir():
+		return os.MkdirAll(dest, 0755)
+	case e.mode&os.ModeSymlink != 0:
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dest); err != nil {
+			return err
+		}
+		a.links[rel] = true
+		return os.Symlink(e.target, dest)
+	case !e.mode.IsRegular():
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	w, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, e.mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, e.content); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(dest, e.mode.Perm()|0600); err != nil {
+		return err
+	}
+	if !e.modTime.IsZero() {
+		return os.Chtimes(dest, e.modTime, e.modTime)
+	}
+	return nil
+}
+
+// extractTarSource writes the entries of the gzipped tar archive at
+// path to dir.
+func extractTarSource(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	a := newArchiveWriter(dir)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		e := &archiveEntry{
+			name:    hdr.Name,
+			mode:    hdr.FileInfo().Mode(),
+			modTime: hdr.ModTime,
+			target:  hdr.Linkname,
+			content: tr,
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			err = a.writeHardLink(e)
+		} else {
+			err = a.write(e)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeHardLink writes a copy of the regular file already written
+// for the hard link e.
+func (a *archiveWriter) writeHardLink(e *archiveEntry) error {
+	rel, target, err := a.dest(e.target)
+	if err != nil {
+		return err
+	}
+	if rel == path.Clean(e.name) {
+		return nil
+	}
+	info, err := os.Lstat(target)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("invalid hard link %s", e.name)
+	}
+	f, err := os.Open(target)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	e.content = f
+	return a.write(e)
+}
+
+// extractZipSource writes the entries of the zip file at path to
+// dir.
+func extractZipSource(path, dir string) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	a := newArchiveWriter(dir)
+	for _, f := range r.File {
+		if err := writeZipEntry(a, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeZipEntry writes f with a. The content of a symbolic link is
+// its target.
+func writeZipEntry(a *archiveWriter, f *zip.File) error {
+	e := &archiveEntry{name: f.Name, mode: f.Mode(), modTime: f.Modified}
+	if strings.HasSuffix(f.Name, "/") {
+		e.mode |= os.ModeDir
+	}
+	if e.mode.IsDir() {
+		return a.write(e)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if e.mode&os.ModeSymlink != 0 {
+		target, err := ioutil.ReadAll(io.LimitReader(rc, 4096))
+		if err != nil {
+			return err
+		}
+		e.target = string(target)
+	} else {
+		e.content = rc
+	}
+	return a.write(e)
+}
@@ -0,0 +1,207 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestTarball writes a gzipped tar archive of hdrs, with
+// content for each regular file, to path.
+func writeTestTarball(t *testing.T, path string, hdrs []*tar.Header, content map[string]string) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, hdr := range hdrs {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(content[hdr.Name]))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content[hdr.Name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractSourceArchiveTarball(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-sourcearchive.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mtime := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	archive := filepath.Join(dir, "name-1.0.tar.gz")
+	writeTestTarball(t, archive, []*tar.Header{
+		{Name: "name-1.0/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "name-1.0/a.go", Typeflag: tar.TypeReg, Mode: 0644, ModTime: mtime},
+		{Name: "name-1.0/run.sh", Typeflag: tar.TypeReg, Mode: 0755, ModTime: mtime},
+		{Name: "name-1.0/same.go", Typeflag: tar.TypeLink, Linkname: "name-1.0/a.go", Mode: 0644},
+		{Name: "name-1.0/link", Typeflag: tar.TypeSymlink, Linkname: "a.go"},
+		{Name: "name-1.0/fifo", Typeflag: tar.TypeFifo},
+	}, map[string]string{
+		"name-1.0/a.go":   "package a\n",
+		"name-1.0/run.sh": "#!/bin/sh\n",
+	})
+	if !IsSourceArchive(archive) || IsSourceArchive(dir) {
+		t.Error("IsSourceArchive")
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0700); err != nil {
+		t.Fatal(err)
+	}
+	src, err := ExtractSourceArchive(archive, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src != filepath.Join(out, "name-1.0") {
+		t.Fatalf("got %s", src)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(src, "same.go")); err != nil || string(b) != "package a\n" {
+		t.Errorf("hard link: got %q, %v", b, err)
+	}
+	info, err := os.Stat(filepath.Join(src, "run.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 || !info.ModTime().Equal(mtime) {
+		t.Errorf("run.sh: mode %v, modified %v", info.Mode(), info.ModTime())
+	}
+	if target, err := os.Readlink(filepath.Join(src, "link")); err != nil || target != "a.go" {
+		t.Errorf("link: got %q, %v", target, err)
+	}
+	if _, err := os.Lstat(filepath.Join(src, "fifo")); !os.IsNotExist(err) {
+		t.Errorf("fifo: got %v", err)
+	}
+}
+
+func TestExtractSourceArchiveUnsafe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-sourcearchive.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, hdrs := range map[string][]*tar.Header{
+		"parent": {
+			{Name: "../evil.go", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+		"absolute": {
+			{Name: "/tmp/evil.go", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+		"through-link": {
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: dir},
+			{Name: "link/evil.go", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+		"over-link": {
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: filepath.Join(dir, "evil.go")},
+			{Name: "link", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+		"hard-link": {
+			{Name: "a.go", Typeflag: tar.TypeLink, Linkname: "../" + filepath.Base(dir) + "/evil.go"},
+		},
+	} {
+		archive := filepath.Join(dir, name+".tar.gz")
+		writeTestTarball(t, archive, hdrs, map[string]string{})
+		out := filepath.Join(dir, name)
+		if err := os.Mkdir(out, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ExtractSourceArchive(archive, out); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "evil.go")); !os.IsNotExist(err) {
+		t.Errorf("written outside: %v", err)
+	}
+}
+
+func TestExtractSourceArchiveZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-sourcearchive.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, files map[string]string) string {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for path, content := range files {
+			w, err := zw.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(content))
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		archive := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(archive, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return archive
+	}
+
+	// Files at the top level are examined where they are.
+	archive := write("flat.zip", map[string]string{
+		"a.go":     "package a\n",
+		"sub/b.go": "package sub\n",
+	})
+	out := filepath.Join(dir, "flat")
+	if err := os.Mkdir(out, 0700); err != nil {
+		t.Fatal(err)
+	}
+	src, err := ExtractSourceArchive(archive, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src != out {
+		t.Errorf("got %s", src)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(src, "sub", "b.go")); err != nil || string(b) != "package sub\n" {
+		t.Errorf("sub/b.go: got %q, %v", b, err)
+	}
+
+	archive = write("evil.zip", map[string]string{"../evil.go": "package evil\n"})
+	out = filepath.Join(dir, "evil")
+	if err := os.Mkdir(out, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExtractSourceArchive(archive, out); err == nil {
+		t.Error("evil.zip: no error")
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "evil.go")); !os.IsNotExist(err) {
+		t.Errorf("written outside: %v", err)
+	}
+}
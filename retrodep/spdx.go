@@ -0,0 +1,171 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// SPDXVersion is the version of the SPDX specification written by
+// WriteSPDX.
+const SPDXVersion = "SPDX-2.3"
+
+// spdxNoAssertion is the SPDX value for information not provided.
+const spdxNoAssertion = "NOASSERTION"
+
+// spdxDocument is an SPDX document in its JSON serialization.
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// spdxIDInvalidRE matches the characters not allowed in an SPDX
+// identifier.
+var spdxIDInvalidRE = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// newSPDXPackage returns the SPDX package for ref, with identifier id.
+func newSPDXPackage(ref *Reference, id string) spdxPackage {
+	pkg := spdxPackage{
+		Name:             ref.Pkg,
+		SPDXID:           id,
+		VersionInfo:      ref.Ver,
+		DownloadLocation: ref.Repo,
+		LicenseConcluded: spdxNoAssertion,
+		LicenseDeclared:  spdxNoAssertion,
+		CopyrightText:    spdxNoAssertion,
+	}
+	if ref.License != "" {
+		pkg.LicenseDeclared = ref.License
+	}
+	if pkg.DownloadLocation == "" {
+		pkg.DownloadLocation = spdxNoAssertion
+	}
+	if ref.Ver != "" {
+		pkg.ExternalRefs = []spdxExternalRef{{
+			ReferenceCategory: "PACKAGE-MANAGER",
+			ReferenceType:     "purl",
+			ReferenceLocator:  purl(ref),
+		}}
+	}
+	return pkg
+}
+
+// WriteSPDX writes the projects in the report to w as an SPDX 2.3
+// document in JSON, created at the given time. Each top-level
+// project is described by the document and has a CONTAINS
+// relationship with each of its vendored projects whose version was
+// identified. Vendored projects whose version was not identified are
+// left out.
+func (r *Report) WriteSPDX(w io.Writer, created time.Time) error {
+	r.sort()
+	doc := spdxDocument{
+		SPDXVersion: SPDXVersion,
+		DataLicense: "CC0-1.0",
+		SPDXID:      "SPDXRef-DOCUMENT",
+		Name:        "retrodep",
+		CreationInfo: spdxCreationInfo{
+			Created:  created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: retrodep"},
+		},
+		Packages:      make([]spdxPackage, 0, len(r.Projects)),
+		Relationships: make([]spdxRelationship, 0, len(r.Projects)),
+	}
+
+	ids := make(map[string]bool)
+	newID := func(pkg string) string {
+		id := "SPDXRef-Package-" + spdxIDInvalidRE.ReplaceAllString(pkg, "-")
+		unique := id
+		for n := 2; ids[unique]; n++ {
+			unique = id + "-" + strconv.Itoa(n)
+		}
+		ids[unique] = true
+		return unique
+	}
+
+	tops := make(map[string]string)
+	projects := r.identified()
+	for _, ref := range projects {
+		id := newID(ref.Pkg)
+		doc.Packages = append(doc.Packages, newSPDXPackage(ref, id))
+		if ref.TopPkg == "" {
+			if len(tops) == 0 {
+				doc.Name = ref.Pkg
+			}
+			tops[ref.Pkg] = id
+			doc.Relationships = append(doc.Relationships, spdxRelationship{
+				SPDXElementID:      doc.SPDXID,
+				RelationshipType:   "DESCRIBES",
+				RelatedSPDXElement: id,
+			})
+			continue
+		}
+		if top, ok := tops[ref.TopPkg]; ok {
+			doc.Relationships = append(doc.Relationships, spdxRelationship{
+				SPDXElementID:      top,
+				RelationshipType:   "CONTAINS",
+				RelatedSPDXElement: id,
+			})
+		}
+	}
+
+	doc.DocumentNamespace = "https://spdx.org/spdxdocs/" +
+		spdxIDInvalidRE.ReplaceAllString(doc.Name, "-") + "-" +
+		hex.EncodeToString(digest(projects, created))[:32]
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&doc)
+}
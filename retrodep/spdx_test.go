@@ -0,0 +1,75 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteSPDX(t *testing.T) {
+	report := NewReport()
+	report.Add(&Reference{Pkg: "example.com/foo", Repo: "https://example.com/foo", Ver: "v1.0.0", Tag: "v1.0.0"})
+	report.Add(&Reference{TopPkg: "example.com/foo", Pkg: "example.com/bar", Repo: "https://example.com/bar",
+		Rev: "0123456789ab", Ver: "v0.0.0-0.20190101000000-0123456789ab", License: "Apache-2.0 AND MIT"})
+	report.Add(&Reference{TopPkg: "example.com/foo", Pkg: "example.com/unknown"})
+
+	var buf bytes.Buffer
+	created := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := report.WriteSPDX(&buf, created); err != nil {
+		t.Fatal(err)
+	}
+	var doc spdxDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.SPDXVersion != "SPDX-2.3" || doc.Name != "example.com/foo" ||
+		doc.CreationInfo.Created != "2019-01-02T03:04:05Z" ||
+		!strings.HasPrefix(doc.DocumentNamespace, "https://spdx.org/spdxdocs/example.com-foo-") {
+		t.Errorf("unexpected document %+v", doc)
+	}
+	if len(doc.Packages) != 2 {
+		t.Fatalf("got %d packages, want 2", len(doc.Packages))
+	}
+	if foo := doc.Packages[0]; foo.LicenseDeclared != spdxNoAssertion {
+		t.Errorf("unexpected package %+v", foo)
+	}
+	bar := doc.Packages[1]
+	if bar.SPDXID != "SPDXRef-Package-example.com-bar" ||
+		bar.VersionInfo != "v0.0.0-0.20190101000000-0123456789ab" ||
+		bar.DownloadLocation != "https://example.com/bar" ||
+		bar.LicenseDeclared != "Apache-2.0 AND MIT" ||
+		bar.LicenseConcluded != spdxNoAssertion ||
+		bar.ExternalRefs[0].ReferenceLocator != "pkg:golang/example.com/bar@v0.0.0-0.20190101000000-0123456789ab" {
+		t.Errorf("unexpected package %+v", bar)
+	}
+
+	exp := []spdxRelationship{
+		{"SPDXRef-DOCUMENT", "DESCRIBES", "SPDXRef-Package-example.com-foo"},
+		{"SPDXRef-Package-example.com-foo", "CONTAINS", "SPDXRef-Package-example.com-bar"},
+	}
+	if len(doc.Relationships) != len(exp) {
+		t.Fatalf("got %v, want %v", doc.Relationships, exp)
+	}
+	for i, rel := range doc.Relationships {
+		if rel != exp[i] {
+			t.Errorf("got %v, want %v", rel, exp[i])
+		}
+	}
+}
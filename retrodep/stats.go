@@ -0,0 +1,183 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Phases of a run timed in Stats.
+const (
+	// PhaseResolve is finding the repositories for import paths.
+	PhaseResolve = "resolve"
+
+	// PhaseClone is creating working trees.
+	PhaseClone = "clone"
+
+	// PhaseHash is finding the file hashes for upstream refs.
+	PhaseHash = "hash"
+
+	// PhaseMatch is looking for the upstream versions matching
+	// local files. It includes PhaseHash.
+	PhaseMatch = "match"
+)
+
+// Stats holds statistics about a run, for tracking its cost and
+// accuracy.
+type Stats struct {
+	// Projects is the number of projects described.
+	Projects int `json:"projects"`
+
+	// Tagged is the number of projects matched to a tag.
+	Tagged int `json:"tagged"`
+
+	// Untagged is the number of projects matched only to an
+	// untagged revision.
+	Untagged int `json:"untagged"`
+
+	// Failed is the number of projects whose version was not
+	// found.
+	Failed int `json:"failed"`
+
+	// Clones is the number of working trees created.
+	Clones int `json:"clones"`
+
+	// BytesCloned is the size of the working trees created, if
+	// measured (see EnableStats).
+	BytesCloned int64 `json:"bytesCloned"`
+
+	// CacheHits and CacheMisses count the lookups of whole-ref
+	// file hashes in working tree caches.
+	CacheHits   int `json:"cacheHits"`
+	CacheMisses int `json:"cacheMisses"`
+
+	// CacheHitRatio is CacheHits as a fraction of all lookups.
+	CacheHitRatio float64 `json:"cacheHitRatio"`
+
+	// Phases maps each phase, one of the Phase... constants, to
+	// the wall time spent in it in seconds.
+	Phases map[string]float64 `json:"phases"`
+
+	// WallTime is the time since the run started, in seconds.
+	WallTime float64 `json:"wallTime"`
+}
+
+var (
+	statsMu    sync.Mutex
+	statsStart = time.Now()
+	statsSizes = false
+	runStats   Stats
+	phaseTimes = make(map[string]time.Duration)
+)
+
+// EnableStats makes the size of each working tree created be
+// measured for Stats.BytesCloned, which takes extra time.
+func EnableStats() {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	statsSizes = true
+}
+
+// RecordPhase adds the time since start to the time spent in phase.
+func RecordPhase(phase string, start time.Time) {
+	d := time.Since(start)
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	phaseTimes[phase] += d
+}
+
+// recordClone counts the working tree created in dir.
+func recordClone(dir string) {
+	statsMu.Lock()
+	measure := statsSizes
+	runStats.Clones++
+	statsMu.Unlock()
+	metrics.Add(MetricClones, 1)
+	if !measure {
+		return
+	}
+	size := dirSize(dir)
+	statsMu.Lock()
+	runStats.BytesCloned += size
+	statsMu.Unlock()
+}
+
+// recordCacheLookup counts a lookup in a file hashes cache.
+func recordCacheLookup(hit bool) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	if hit {
+		runStats.CacheHits++
+		metrics.Add(MetricCacheHits, 1)
+	} else {
+		runStats.CacheMisses++
+		metrics.Add(MetricCacheMisses, 1)
+	}
+}
+
+// dirSize returns the total size of the files below dir.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// ReadStats returns the statistics collected so far. The counts of
+// projects are not collected; see AddReference.
+func ReadStats() Stats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	s := runStats
+	if lookups := s.CacheHits + s.CacheMisses; lookups > 0 {
+		s.CacheHitRatio = float64(s.CacheHits) / float64(lookups)
+	}
+	s.Phases = make(map[string]float64, len(phaseTimes))
+	for phase, d := range phaseTimes {
+		s.Phases[phase] = d.Seconds()
+	}
+	s.WallTime = time.Since(statsStart).Seconds()
+	return s
+}
+
+// AddReference counts the project described by ref.
+func (s *Stats) AddReference(ref *Reference) {
+	s.Projects++
+	switch {
+	case ref.Tag != "":
+		s.Tagged++
+	case ref.Rev != "" || ref.Ver != "":
+		s.Untagged++
+	default:
+		s.Failed++
+	}
+}
+
+// Write writes the statistics to w as JSON.
+func (s *Stats) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
@@ -0,0 +1,89 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetStats clears the statistics collected. It should be deferred.
+func resetStats() {
+	statsSizes = false
+	runStats = Stats{}
+	phaseTimes = make(map[string]time.Duration)
+}
+
+func TestStats(t *testing.T) {
+	defer resetStats()
+	resetStats()
+
+	dir, err := ioutil.TempDir("", "retrodep-stats.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"a":     "123",
+		"sub/b": "4567",
+	})
+
+	recordClone(dir)
+	EnableStats()
+	recordClone(dir)
+	recordCacheLookup(false)
+	recordCacheLookup(true)
+	recordCacheLookup(true)
+	recordCacheLookup(true)
+	RecordPhase(PhaseClone, time.Now().Add(-time.Second))
+
+	s := ReadStats()
+	for _, ref := range []*Reference{
+		{Pkg: "a", Tag: "v1.0.0", Rev: "r1", Ver: "v1.0.0"},
+		{Pkg: "b", Rev: "r2", Ver: "v0.0.0-20190101000000-r2"},
+		{Pkg: "c"},
+	} {
+		s.AddReference(ref)
+	}
+	if s.Projects != 3 || s.Tagged != 1 || s.Untagged != 1 || s.Failed != 1 {
+		t.Errorf("unexpected counts %+v", s)
+	}
+	if s.Clones != 2 || s.BytesCloned != 7 {
+		t.Errorf("clones: got %d (%d bytes)", s.Clones, s.BytesCloned)
+	}
+	if s.CacheHits != 3 || s.CacheMisses != 1 || s.CacheHitRatio != 0.75 {
+		t.Errorf("cache: got %d/%d (%f)", s.CacheHits, s.CacheMisses, s.CacheHitRatio)
+	}
+	if s.Phases[PhaseClone] < 1 || s.WallTime <= 0 {
+		t.Errorf("times: got %v, %f", s.Phases, s.WallTime)
+	}
+
+	var buf strings.Builder
+	if err := s.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Stats
+	if err := json.Unmarshal([]byte(buf.String()), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Projects != 3 || decoded.Phases[PhaseClone] != s.Phases[PhaseClone] {
+		t.Errorf("got %+v", decoded)
+	}
+}
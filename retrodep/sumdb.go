@@ -0,0 +1,94 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SumDB is a client for a Go checksum database, such as
+// https://sum.golang.org, for looking up the published hashes of
+// module versions.
+//
+// The records returned are not checked against the database's
+// signed tree head, so the connection to the database must be
+// trusted.
+type SumDB struct {
+	url    string
+	client *http.Client
+}
+
+// NewSumDB returns a *SumDB for the checksum database at the base
+// URL u.
+func NewSumDB(u string) *SumDB {
+	return &SumDB{
+		url:    strings.TrimSuffix(u, "/"),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// escapeModulePath applies the case encoding used for module paths
+// and versions in URLs and file names, the reverse of
+// unescapeModulePath.
+func escapeModulePath(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteByte('!')
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Lookup returns the "h1:" hash of the zip of the module modPath at
+// version recorded in the checksum database. It returns
+// ErrorVersionNotFound if the database has no record of it.
+func (db *SumDB) Lookup(modPath, version string) (string, error) {
+	u := db.url + "/lookup/" + escapeModulePath(modPath) + "@" +
+		escapeModulePath(version)
+	resp, err := db.client.Get(u)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone:
+		return "", ErrorVersionNotFound
+	default:
+		return "", fmt.Errorf("%s: %s", u, resp.Status)
+	}
+
+	// The record is the line number in the log followed by
+	// go.sum lines, then a blank line and the signed tree head.
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == modPath && fields[1] == version {
+			return fields[2], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", ErrorVersionNotFound
+}
@@ -0,0 +1,55 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSumDBLookup(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/lookup/github.com/!foo/bar@v1.0.0" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `1234
+github.com/Foo/bar v1.0.0 h1:zip=
+github.com/Foo/bar v1.0.0/go.mod h1:mod=
+
+go.sum database tree
+5678
+abc=
+
+— sum.golang.org sig=
+`)
+	}))
+	defer srv.Close()
+
+	db := NewSumDB(srv.URL + "/")
+	h, err := db.Lookup("github.com/Foo/bar", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h != "h1:zip=" {
+		t.Errorf("got %q", h)
+	}
+	if _, err := db.Lookup("github.com/Foo/bar", "v2.0.0"); err != ErrorVersionNotFound {
+		t.Errorf("missing version: got %v", err)
+	}
+}
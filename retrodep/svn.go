@@ -0,0 +1,480 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/pkg/errors"
+)
+
+// This file contains methods specific to working with svn.
+
+// Subversion has no tags as such. Following the usual layout, the
+// files are in a "trunk" directory and copies of them in a "tags"
+// directory name the tags. Revisions are revision numbers.
+
+type svnWorkingTree struct {
+	anyWorkingTree
+
+	// url is the URL of the directory holding the files, usually
+	// the trunk.
+	url string
+
+	// tagsURL is the URL of the directory holding the tags.
+	tagsURL string
+
+	// rootURL is the URL of the repository root.
+	rootURL string
+}
+
+type svnInfo struct {
+	URL  string `xml:"entry>url"`
+	Root string `xml:"entry>repository>root"`
+}
+
+type svnLogEntry struct {
+	Revision string `xml:"revision,attr"`
+	Date     string `xml:"date"`
+	Paths    []struct {
+		Action string `xml:"action,attr"`
+		Kind   string `xml:"kind,attr"`
+		Path   string `xml:",chardata"`
+	} `xml:"paths>path"`
+}
+
+type svnLog struct {
+	LogEntries []svnLogEntry `xml:"logentry"`
+}
+
+type svnListEntry struct {
+	Kind   string `xml:"kind,attr"`
+	Name   string `xml:"name"`
+	Commit struct {
+		Revision int `xml:"revision,attr"`
+	} `xml:"commit"`
+}
+
+type svnList struct {
+	Entries []svnListEntry `xml:"list>entry"`
+}
+
+// svnOptions are given before the arguments of each svn command.
+var svnOptions = []string{"--non-interactive"}
+
+// newSvnWorkingTree returns the *svnWorkingTree for the checkout in
+// wt, finding where the trunk and tags are.
+func newSvnWorkingTree(wt anyWorkingTree) (*svnWorkingTree, error) {
+	s := &svnWorkingTree{anyWorkingTree: wt}
+	stdout, stderr, err := s.run("info", "--xml")
+	if err != nil {
+		s.showOutput(stdout, stderr)
+		return nil, err
+	}
+	var info svnInfo
+	if err := xml.Unmarshal(stdout.Bytes(), &info); err != nil {
+		return nil, errors.Wrap(err, "svn info")
+	}
+	s.rootURL = info.Root
+	s.url = strings.TrimSuffix(info.URL, "/")
+	switch {
+	case strings.HasSuffix(s.url, "/trunk"):
+		s.tagsURL = strings.TrimSuffix(s.url, "trunk") + "tags"
+	case isDir(filepath.Join(s.Dir, "trunk")):
+		s.tagsURL = s.url + "/tags"
+		s.url += "/trunk"
+	default:
+		s.tagsURL = s.url + "/tags"
+	}
+	return s, nil
+}
+
+// WithContext implements the ContextWorkingTree interface.
+func (s *svnWorkingTree) WithContext(ctx context.Context) WorkingTree {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+// isDir returns true if pth is a directory.
+func isDir(pth string) bool {
+	fi, err := os.Stat(pth)
+	return err == nil && fi.IsDir()
+}
+
+// log runs 'svn log --xml' with the additional args and returns the
+// log entries, newest first.
+func (s *svnWorkingTree) log(args ...string) ([]svnLogEntry, error) {
+	stdout, stderr, err := s.run(append([]string{"log", "--xml"}, args...)...)
+	if err != nil {
+		s.showOutput(stdout, stderr)
+		return nil, err
+	}
+	var logs svnLog
+	if err := xml.Unmarshal(stdout.Bytes(), &logs); err != nil {
+		return nil, errors.Wrap(err, "svn log")
+	}
+	return logs.LogEntries, nil
+}
+
+// tagList returns the tags, using 'svn list' on the tags directory,
+// or nil if there is none.
+func (s *svnWorkingTree) tagList() ([]svnListEntry, error) {
+	stdout, stderr, err := s.run("list", "--xml", s.tagsURL)
+	if err != nil {
+		if strings.Contains(stderr.String(), "E200009") ||
+			strings.Contains(stderr.String(), "W160013") {
+			// No tags directory
+			return nil, nil
+		}
+		s.showOutput(stdout, stderr)
+		return nil, err
+	}
+	var list svnList
+	if err := xml.Unmarshal(stdout.Bytes(), &list); err != nil {
+		return nil, errors.Wrap(err, "svn list")
+	}
+	var tags []svnListEntry
+	for _, entry := range list.Entries {
+		if entry.Kind == "dir" {
+			tags = append(tags, entry)
+		}
+	}
+	return tags, nil
+}
+
+// VersionTags returns the tags that are parseable as semantic tags,
+// e.g. v1.1.0.
+func (s *svnWorkingTree) VersionTags() ([]string, error) {
+	entries, err := s.tagList()
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		tags = append(tags, entry.Name)
+	}
+	return versionTags(tags), nil
+}
+
+// Revisions returns all revisions changing the trunk, newest first,
+// using 'svn log'.
+func (s *svnWorkingTree) Revisions() ([]string, error) {
+	entries, err := s.log("--quiet", s.url)
+	if err != nil {
+		return nil, err
+	}
+	revisions := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		revisions = append(revisions, entry.Revision)
+	}
+	return revisions, nil
+}
+
+// RevisionFromTag returns the revision in which the tag was made,
+// using 'svn list'.
+func (s *svnWorkingTree) RevisionFromTag(tag string) (string, error) {
+	entries, err := s.tagList()
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.Name == tag {
+			return strconv.Itoa(entry.Commit.Revision), nil
+		}
+	}
+	return "", ErrorInvalidRef
+}
+
+// isRevision returns true if ref is a revision number rather than a
+// tag.
+func isRevision(ref string) bool {
+	_, err := strconv.Atoi(ref)
+	return err == nil
+}
+
+// refURL returns the URL, with peg revision if needed, of the files
+// for the tag or revision ref.
+func (s *svnWorkingTree) refURL(ref string) string {
+	if isRevision(ref) {
+		return s.url + "@" + ref
+	}
+	return s.tagsURL + "/" + ref
+}
+
+// TagSync syncs the working tree to the named tag, or to the trunk if
+// tag is "", using 'svn switch'.
+func (s *svnWorkingTree) TagSync(tag string) error {
+	u := s.url
+	if tag != "" {
+		u = s.refURL(tag)
+	}
+	stdout, stderr, err := s.run("switch", "--ignore-ancestry", u)
+	if err != nil {
+		s.showOutput(stdout, stderr)
+	}
+	return err
+}
+
+// RevSync syncs the working tree to the revision rev of the trunk,
+// using 'svn switch'.
+func (s *svnWorkingTree) RevSync(rev string) error {
+	return s.TagSync(rev)
+}
+
+// TimeFromRevision returns the time of the revision rev, in UTC,
+// using 'svn log'.
+func (s *svnWorkingTree) TimeFromRevision(rev string) (time.Time, error) {
+	var t time.Time
+	entries, err := s.log("-r", rev, s.rootURL)
+	if err != nil {
+		return t, err
+	}
+	if len(entries) != 1 {
+		return t, fmt.Errorf("svn log -r %s: %d logentry elements (expected 1)",
+			rev, len(entries))
+	}
+	err = t.UnmarshalText([]byte(entries[0].Date))
+	return t.UTC(), err
+}
+
+// ReachableTag returns the most recent tag made no later than the
+// revision rev, preferring semver tags. Only tags the TagMatch
+// accepts are considered.
+func (s *svnWorkingTree) ReachableTag(rev string) (string, error) {
+	n, err := strconv.Atoi(rev)
+	if err != nil {
+		return "", ErrorInvalidRef
+	}
+	entries, err := s.tagList()
+	if err != nil {
+		return "", err
+	}
+	var best, bestSemver *svnListEntry
+	for i, entry := range entries {
+		if entry.Commit.Revision > n || !s.tagMatch.matches(entry.Name) {
+			continue
+		}
+		if best == nil || entry.Commit.Revision > best.Commit.Revision {
+			best = &entries[i]
+		}
+		if _, err := semver.NewVersion(entry.Name); err == nil &&
+			(bestSemver == nil || entry.Commit.Revision > bestSemver.Commit.Revision) {
+			bestSemver = &entries[i]
+		}
+	}
+	switch {
+	case bestSemver != nil:
+		return bestSemver.Name, nil
+	case best != nil:
+		return best.Name, nil
+	}
+	return "", ErrorVersionNotFound
+}
+
+// FileHashesFromRef returns the file hashes for the given tag or
+// revision ref, from the local or shared cache if possible.
+func (s *svnWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	return s.cachedFileHashes(ref, subPath,
+		s.remoteFileHashes(s.fileHashesFromRef, s.revisionID))
+}
+
+// revisionID returns an ID for the files of the tag or revision ref
+// which does not change while they do not.
+func (s *svnWorkingTree) revisionID(ref string) (string, error) {
+	if isRevision(ref) {
+		return ref, nil
+	}
+	rev, err := s.RevisionFromTag(ref)
+	if err != nil {
+		return "", err
+	}
+	return "tags/" + ref + "@" + rev, nil
+}
+
+// fileHashesFromRef returns the file hashes for the given tag or
+// revision ref, using 'svn export'.
+func (s *svnWorkingTree) fileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	dir, err := s.env.tempDir("retrodep.")
+	if err != nil {
+		return nil, errors.Wrapf(err, "FileHashesFromRef(%s)", ref)
+	}
+	defer os.RemoveAll(dir)
+
+	u := s.refURL(ref)
+	if subPath != "" {
+		u = s.subURL(ref, subPath)
+	}
+	export := filepath.Join(dir, "export")
+	stdout, stderr, err := s.run("export", "--quiet", "--ignore-externals", u, export)
+	if err != nil {
+		if strings.Contains(stderr.String(), "E170000") ||
+			strings.Contains(stderr.String(), "E160013") {
+			return nil, ErrorInvalidRef
+		}
+		s.showOutput(stdout, stderr)
+		return nil, err
+	}
+	return newFileHashes(s.env, s.hasher, export, nil)
+}
+
+// subURL returns the URL, with peg revision if needed, of subPath
+// within the files for the tag or revision ref.
+func (s *svnWorkingTree) subURL(ref, subPath string) string {
+	sub := filepath.ToSlash(subPath)
+	if isRevision(ref) {
+		return s.url + "/" + sub + "@" + ref
+	}
+	return s.tagsURL + "/" + ref + "/" + sub
+}
+
+// Grep returns the lines of files at the tag or revision ref which
+// match the regular expression pattern, searching a copy made with
+// 'svn export'.
+func (s *svnWorkingTree) Grep(ref, pattern, subPath string) ([]GrepMatch, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := s.env.tempDir("retrodep.")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	u := s.refURL(ref)
+	if subPath != "" {
+		u = s.subURL(ref, subPath)
+	}
+	export := filepath.Join(dir, "export")
+	stdout, stderr, err := s.run("export", "--quiet", "--ignore-externals", u, export)
+	if err != nil {
+		s.showOutput(stdout, stderr)
+		return nil, err
+	}
+
+	return grepFiles(export, re)
+}
+
+// LastRevisions returns the most recent revision reachable from ref
+// which changed each file within subPath, using 'svn log -v'.
+func (s *svnWorkingTree) LastRevisions(ref, subPath string) (map[string]string, error) {
+	rev := ref
+	if !isRevision(ref) {
+		var err error
+		rev, err = s.RevisionFromTag(ref)
+		if err != nil {
+			return nil, err
+		}
+	}
+	entries, err := s.log("--verbose", "-r", rev+":1", s.url+"@"+rev)
+	if err != nil {
+		return nil, err
+	}
+
+	// Paths in the log are relative to the repository root.
+	prefix := strings.TrimPrefix(s.url, s.rootURL) + "/"
+	var changeLog bytes.Buffer
+	for _, entry := range entries {
+		fmt.Fprintf(&changeLog, "%s%s\n", changeLogPrefix, entry.Revision)
+		for _, p := range entry.Paths {
+			if p.Kind == "dir" || !strings.HasPrefix(p.Path, prefix) {
+				continue
+			}
+			status := p.Action
+			if status == "R" {
+				status = "M"
+			}
+			fmt.Fprintf(&changeLog, "%s\t%s\n", status,
+				filepath.FromSlash(p.Path[len(prefix):]))
+		}
+	}
+	return parseChangeLog(&changeLog, subPath)
+}
+
+// svnExternalRE matches a line of an svn:externals property in
+// either the old form, "<dir> [-r <rev>] <url>", or the new one,
+// "[-r <rev>] <url>[@<peg>] <dir>".
+var svnExternalRE = regexp.MustCompile(`^(?:-r\s*(\d+)\s+)?(\S+?)(?:@(\d+))?\s+(\S+)$`)
+
+// Submodules returns the externals defined at the tag or revision
+// ref, using 'svn propget svn:externals'. Externals not pinned to a
+// revision have Rev "".
+func (s *svnWorkingTree) Submodules(ref string) ([]Submodule, error) {
+	stdout, stderr, err := s.run("propget", "svn:externals", "--recursive",
+		s.refURL(ref))
+	if err != nil {
+		s.showOutput(stdout, stderr)
+		return nil, err
+	}
+
+	// Each property is given as "<url> - <line>", with further
+	// lines of the same property following on their own.
+	base := strings.TrimSuffix(s.refURL(ref), "@"+ref)
+	var dir string
+	var submodules []Submodule
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if i := strings.Index(line, " - "); i != -1 && strings.Contains(line[:i], "://") {
+			dir = strings.TrimPrefix(strings.TrimPrefix(line[:i], base), "/")
+			line = strings.TrimSpace(line[i+3:])
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		submodule, ok := parseSvnExternal(line)
+		if !ok {
+			continue
+		}
+		submodule.Path = path.Join(dir, submodule.Path)
+		submodules = append(submodules, submodule)
+	}
+	sort.Slice(submodules, func(i, j int) bool {
+		return submodules[i].Path < submodules[j].Path
+	})
+	return submodules, nil
+}
+
+// parseSvnExternal parses one line of an svn:externals property.
+func parseSvnExternal(line string) (Submodule, bool) {
+	fields := strings.Fields(line)
+	if len(fields) >= 2 && !strings.Contains(fields[0], "://") &&
+		!strings.HasPrefix(fields[0], "^/") && !strings.HasPrefix(fields[0], "-r") {
+		// Old form: move the directory to the end
+		line = strings.Join(append(fields[1:], fields[0]), " ")
+	}
+	m := svnExternalRE.FindStringSubmatch(line)
+	if m == nil {
+		return Submodule{}, false
+	}
+	rev := m[1]
+	if rev == "" {
+		rev = m[3]
+	}
+	return Submodule{Path: m[4], Rev: rev, URL: m[2]}, true
+}
@@ -0,0 +1,219 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func newMockSvnWorkingTree() *svnWorkingTree {
+	return &svnWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			Dir: "",
+			VCS: vcs.ByCmd(vcsSvn),
+		},
+		url:     "https://svn.example.com/repo/proj/trunk",
+		tagsURL: "https://svn.example.com/repo/proj/tags",
+		rootURL: "https://svn.example.com/repo",
+	}
+}
+
+func TestSvnInfo(t *testing.T) {
+	defer mockExecCommand()()
+
+	mockedStdout = `<?xml version="1.0" encoding="UTF-8"?>
+<info>
+<entry kind="dir" path="." revision="12">
+<url>https://svn.example.com/repo/proj/trunk</url>
+<repository>
+<root>https://svn.example.com/repo</root>
+</repository>
+</entry>
+</info>
+`
+	s, err := newSvnWorkingTree(anyWorkingTree{Dir: "", VCS: vcs.ByCmd(vcsSvn)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := newMockSvnWorkingTree()
+	if s.url != expected.url || s.tagsURL != expected.tagsURL || s.rootURL != expected.rootURL {
+		t.Errorf("got %q, %q, %q", s.url, s.tagsURL, s.rootURL)
+	}
+
+	mockedStdout = "<info>"
+	if _, err := newSvnWorkingTree(anyWorkingTree{Dir: "", VCS: vcs.ByCmd(vcsSvn)}); err == nil {
+		t.Error("invalid info output accepted")
+	}
+}
+
+func TestSvnRevisions(t *testing.T) {
+	defer mockExecCommand()()
+
+	mockedStdout = `<?xml version="1.0" encoding="UTF-8"?>
+<log>
+<logentry revision="12">
+<author>example</author>
+<date>2019-01-02T12:00:00.000000Z</date>
+</logentry>
+<logentry revision="3">
+<author>example</author>
+<date>2019-01-01T12:00:00.000000Z</date>
+</logentry>
+</log>
+`
+	s := newMockSvnWorkingTree()
+	revs, err := s.Revisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(revs, []string{"12", "3"}) {
+		t.Errorf("Revisions: got %v", revs)
+	}
+}
+
+func TestSvnTags(t *testing.T) {
+	defer mockExecCommand()()
+
+	mockedStdout = `<?xml version="1.0" encoding="UTF-8"?>
+<lists>
+<list path="https://svn.example.com/repo/proj/tags">
+<entry kind="dir">
+<name>v1.0.0</name>
+<commit revision="4"><author>example</author></commit>
+</entry>
+<entry kind="dir">
+<name>v1.1.0</name>
+<commit revision="9"><author>example</author></commit>
+</entry>
+<entry kind="dir">
+<name>nightly</name>
+<commit revision="11"><author>example</author></commit>
+</entry>
+<entry kind="file">
+<name>README</name>
+<commit revision="1"><author>example</author></commit>
+</entry>
+</list>
+</lists>
+`
+	s := newMockSvnWorkingTree()
+	tags, err := s.VersionTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"v1.1.0", "v1.0.0"}) {
+		t.Errorf("VersionTags: got %v", tags)
+	}
+
+	rev, err := s.RevisionFromTag("v1.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != "9" {
+		t.Errorf("RevisionFromTag: got %s", rev)
+	}
+	if _, err := s.RevisionFromTag("README"); err != ErrorInvalidRef {
+		t.Errorf("RevisionFromTag(README): got %v", err)
+	}
+
+	tcs := []struct {
+		rev string
+		tag string
+		err error
+	}{
+		{"12", "v1.1.0", nil},
+		{"8", "v1.0.0", nil},
+		{"4", "v1.0.0", nil},
+		{"3", "", ErrorVersionNotFound},
+		{"v1.0.0", "", ErrorInvalidRef},
+	}
+	for _, tc := range tcs {
+		tag, err := s.ReachableTag(tc.rev)
+		if err != tc.err || tag != tc.tag {
+			t.Errorf("ReachableTag(%s): got %q, %v; want %q, %v",
+				tc.rev, tag, err, tc.tag, tc.err)
+		}
+	}
+}
+
+func TestSvnLastRevisions(t *testing.T) {
+	defer mockExecCommand()()
+
+	mockedStdout = `<?xml version="1.0" encoding="UTF-8"?>
+<log>
+<logentry revision="12">
+<paths>
+<path action="M" kind="file">/proj/trunk/a.go</path>
+<path action="D" kind="file">/proj/trunk/sub/old.go</path>
+<path action="M" kind="file">/other/trunk/a.go</path>
+</paths>
+</logentry>
+<logentry revision="3">
+<paths>
+<path action="A" kind="dir">/proj/trunk/sub</path>
+<path action="A" kind="file">/proj/trunk/a.go</path>
+<path action="A" kind="file">/proj/trunk/sub/b.go</path>
+<path action="A" kind="file">/proj/trunk/sub/old.go</path>
+</paths>
+</logentry>
+</log>
+`
+	s := newMockSvnWorkingTree()
+	revs, err := s.LastRevisions("12", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"a.go":     "12",
+		"sub/b.go": "3",
+	}
+	if !reflect.DeepEqual(revs, expected) {
+		t.Errorf("LastRevisions: got %v, want %v", revs, expected)
+	}
+}
+
+func TestParseSvnExternal(t *testing.T) {
+	tcs := []struct {
+		line     string
+		expected Submodule
+	}{
+		{
+			"third-party/lib -r 21 https://svn.example.com/lib/trunk",
+			Submodule{Path: "third-party/lib", Rev: "21", URL: "https://svn.example.com/lib/trunk"},
+		},
+		{
+			"-r 21 https://svn.example.com/lib/trunk lib",
+			Submodule{Path: "lib", Rev: "21", URL: "https://svn.example.com/lib/trunk"},
+		},
+		{
+			"https://svn.example.com/lib/trunk@30 lib",
+			Submodule{Path: "lib", Rev: "30", URL: "https://svn.example.com/lib/trunk"},
+		},
+		{
+			"^/lib/trunk lib",
+			Submodule{Path: "lib", URL: "^/lib/trunk"},
+		},
+	}
+	for _, tc := range tcs {
+		submodule, ok := parseSvnExternal(tc.line)
+		if !ok || submodule != tc.expected {
+			t.Errorf("%q: got %+v, %t", tc.line, submodule, ok)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"path"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+// Kinds of tag for TagMatch.Prefer.
+const (
+	// AnnotatedTags are git tags with a tag object of their own,
+	// as made by 'git tag -a' or 'git tag -s'.
+	AnnotatedTags = "annotated"
+
+	// LightweightTags are git tags naming a commit directly.
+	LightweightTags = "lightweight"
+)
+
+// TagMatch chooses the tags ReachableTag considers, and so the tag
+// series PseudoVersion bases pseudo-versions on, for repositories
+// which also tag CI builds or nightly snapshots.
+type TagMatch struct {
+	// Match, if not empty, holds shell glob patterns such as
+	// "v1.*", one of which each tag considered must match.
+	Match []string
+
+	// Exclude holds shell glob patterns such as "*-nightly*"
+	// which no tag considered may match.
+	Exclude []string
+
+	// Prefer, if set, is the kind of tag preferred by git
+	// working trees, AnnotatedTags or LightweightTags. The
+	// nearest tag of that kind is taken if there is one, and
+	// otherwise the nearest tag of either kind.
+	Prefer string
+
+	// SemverOnly leaves out tags which are not semantic versions.
+	SemverOnly bool
+}
+
+// MatchTags makes working trees consider only the tags chosen by m
+// in ReachableTag. Patterns apply to tags with any TagPrefix
+// removed.
+func MatchTags(m TagMatch) WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		o.tagMatch = m
+	}
+}
+
+// filters returns true if m leaves out any tags.
+func (m TagMatch) filters() bool {
+	return len(m.Match) > 0 || len(m.Exclude) > 0 || m.SemverOnly
+}
+
+// matches returns true if tag is one of those m considers.
+func (m TagMatch) matches(tag string) bool {
+	if len(m.Match) > 0 && !matchesAnyGlob(m.Match, tag) {
+		return false
+	}
+	if matchesAnyGlob(m.Exclude, tag) {
+		return false
+	}
+	if m.SemverOnly {
+		if _, err := semver.NewVersion(tag); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAnyGlob returns true if name matches one of the glob
+// patterns.
+func matchesAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// globEscape returns the glob pattern matching only name.
+func globEscape(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
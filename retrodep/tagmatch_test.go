@@ -0,0 +1,159 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestTagMatch(t *testing.T) {
+	m := TagMatch{
+		Match:      []string{"v1.*", "1.*"},
+		Exclude:    []string{"*-nightly*"},
+		SemverOnly: true,
+	}
+	for tag, exp := range map[string]bool{
+		"v1.2.0":                  true,
+		"1.2.0":                   true,
+		"v2.0.0":                  false,
+		"v1.3.0-nightly.20190401": false,
+		"v1.x":                    false,
+	} {
+		if got := m.matches(tag); got != exp {
+			t.Errorf("%s: got %v, want %v", tag, got, exp)
+		}
+	}
+	if !(TagMatch{}).matches("ci-build-42") {
+		t.Error("empty TagMatch left out a tag")
+	}
+	if got := globEscape("a*b?[c]\\"); got != `a\*b\?\[c]\\` {
+		t.Errorf("globEscape: got %q", got)
+	}
+}
+
+func TestGitReachableTagMatch(t *testing.T) {
+	var commands [][]string
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		commands = append(commands, args)
+		var out string
+		switch args[0] {
+		case "for-each-ref":
+			out = "tag refs/tags/api/v1.2.0\ncommit refs/tags/api/v1.2.1\n"
+		case "describe":
+			out = "api/v1.2.1-3-gd4c3dbf\n"
+			for _, arg := range args {
+				if arg == "--exclude=api/v1.2.1" {
+					out = "api/ci-7-1-gd4c3dbf\n"
+				}
+				if arg == "--exclude=api/ci-7" {
+					out = "api/v1.2.0-5-gd4c3dbf\n"
+				}
+			}
+		}
+		return exec.Command("printf", "%s", out)
+	}
+
+	wt := gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			VCS: vcs.ByCmd(vcsGit),
+			tagMatch: TagMatch{
+				Match:      []string{"v*", "ci-*"},
+				Exclude:    []string{"*-rc*"},
+				Prefer:     LightweightTags,
+				SemverOnly: true,
+			},
+		},
+		tagPrefix: "api/",
+	}
+	tag, err := wt.ReachableTag("d4c3dbf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "v1.2.1" {
+		t.Errorf("got tag %q", tag)
+	}
+	exp := [][]string{
+		{"for-each-ref", "--format=%(objecttype) %(refname)", "refs/tags/api/"},
+		{"describe", "--tags", "--match=api/v*", "--match=api/ci-*",
+			"--exclude=api/v1.2.0", "--exclude=api/*-rc*", "d4c3dbf"},
+	}
+	if !reflect.DeepEqual(commands, exp) {
+		t.Errorf("ran %v, want %v", commands, exp)
+	}
+
+	// Preferring annotated tags, with v1.2.1 excluded, the
+	// non-semver tag described next is skipped.
+	commands = nil
+	wt.tagMatch.Prefer = AnnotatedTags
+	wt.tagMatch.Match = nil
+	wt.tagMatch.Exclude = []string{"v1.2.1"}
+	tag, err = wt.ReachableTag("d4c3dbf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "v1.2.0" {
+		t.Errorf("got tag %q", tag)
+	}
+	if len(commands) != 2 || strings.Join(commands[0][:2], " ") != "describe --match=api/v[0-9]*" {
+		t.Errorf("ran %v", commands)
+	}
+}
+
+func TestHgReachableTagMatch(t *testing.T) {
+	var commands [][]string
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		commands = append(commands, args)
+		return exec.Command("printf", "%s", `<?xml version="1.0"?>
+<log>
+<logentry revision="1" node="abc">
+<tag>v1.19.2</tag>
+</logentry>
+<logentry revision="2" node="def">
+<tag>1.20.0-nightly</tag>
+</logentry>
+<logentry revision="3" node="123">
+<tag>v1.20.0</tag>
+</logentry>
+</log>
+`)
+	}
+
+	wt := hgWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			VCS:      vcs.ByCmd(vcsHg),
+			tagMatch: TagMatch{Exclude: []string{"v1.19.*", "*-nightly"}},
+		},
+	}
+	tag, err := wt.ReachableTag("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "v1.20.0" {
+		t.Errorf("got tag %q", tag)
+	}
+	for _, arg := range commands[0] {
+		if arg == "--limit" {
+			t.Errorf("ran %v", commands[0])
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+// TagPrefix makes git working trees consider only the tags beginning
+// with prefix, such as "api/" for a module tagged "api/v1.2.3" in a
+// repository holding several. The prefix is removed from the tags
+// VersionTags, ReachableTag and CommitsBehind return, and added back
+// to version tags given as refs.
+func TagPrefix(prefix string) WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		o.tagPrefix = prefix
+	}
+}
+
+// TagPrefixes maps import path prefixes to the tag prefixes used for
+// the projects below them.
+type TagPrefixes map[string]string
+
+// ReadTagPrefixes parses a tag prefixes file from r. Each line has
+// an import path prefix followed by the tag prefix, separated by
+// whitespace. Blank lines and lines starting with "#" are ignored.
+func ReadTagPrefixes(r io.Reader) (TagPrefixes, error) {
+	prefixes, err := readPrefixMap(r)
+	return TagPrefixes(prefixes), err
+}
+
+// For returns the tag prefix for the project at importPath, from the
+// longest import path prefix matching it, or "" if there is none.
+func (p TagPrefixes) For(importPath string) string {
+	return longestPrefix(p, importPath)
+}
+
+// readPrefixMap parses lines of an import path prefix followed by a
+// value, separated by whitespace, from r. Blank lines and lines
+// starting with "#" are ignored, as are lines without two fields.
+func readPrefixMap(r io.Reader) (map[string]string, error) {
+	m := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		m[fields[0]] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// longestPrefix returns the value in m for the longest import path
+// prefix matching importPath, or "" if there is none.
+func longestPrefix(m map[string]string, importPath string) string {
+	var best string
+	for prefix := range m {
+		if len(prefix) > len(best) &&
+			(importPath == prefix || strings.HasPrefix(importPath, prefix+"/")) {
+			best = prefix
+		}
+	}
+	return m[best]
+}
+
+// stripTagPrefix returns those of tags beginning with prefix, with
+// it removed.
+func stripTagPrefix(prefix string, tags []string) []string {
+	var stripped []string
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, prefix) && len(tag) > len(prefix) {
+			stripped = append(stripped, tag[len(prefix):])
+		}
+	}
+	return stripped
+}
+
+// tagRef returns the tag or revision ref as git knows it. Refs which
+// are semantic versions are version tags with the tag prefix
+// removed, and have it added back. Revisions never contain dots.
+func (g *gitWorkingTree) tagRef(ref string) string {
+	if g.tagPrefix == "" || !strings.Contains(ref, ".") {
+		return ref
+	}
+	if _, err := semver.NewVersion(ref); err != nil {
+		return ref
+	}
+	return g.tagPrefix + ref
+}
@@ -0,0 +1,111 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestReadTagPrefixes(t *testing.T) {
+	p, err := ReadTagPrefixes(strings.NewReader(`# comment
+github.com/example/mono api/
+github.com/example/mono/sdk sdk/
+
+incomplete
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 2 {
+		t.Fatalf("unexpected tag prefixes %v", p)
+	}
+	for importPath, expected := range map[string]string{
+		"github.com/example/mono":         "api/",
+		"github.com/example/mono/api/v2":  "api/",
+		"github.com/example/mono/sdk/foo": "sdk/",
+		"github.com/example/monorepo":     "",
+	} {
+		if prefix := p.For(importPath); prefix != expected {
+			t.Errorf("%s: got %q, want %q", importPath, prefix, expected)
+		}
+	}
+}
+
+func TestGitTagPrefix(t *testing.T) {
+	var commands [][]string
+	defer func() { execCommand = exec.Command }()
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		commands = append(commands, args)
+		var out string
+		switch args[0] {
+		case "show-ref":
+			out = "1 refs/tags/api/v1.2.0\n" +
+				"2 refs/tags/api/v1.10.0\n" +
+				"3 refs/tags/api/latest\n" +
+				"4 refs/tags/sdk/v0.5.0\n" +
+				"5 refs/tags/v2.0.0\n"
+		case "describe":
+			out = "api/v1.2.0-3-gd4c3dbf\n"
+		case "rev-parse":
+			out = "d4c3dbfa77a74ae238e401d5d2197b45f30d8513\n"
+		}
+		return exec.Command("printf", "%s", out)
+	}
+
+	wt := gitWorkingTree{
+		anyWorkingTree: anyWorkingTree{
+			VCS: vcs.ByCmd(vcsGit),
+		},
+		tagPrefix: "api/",
+	}
+	tags, err := wt.VersionTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"v1.10.0", "v1.2.0"}; !reflect.DeepEqual(tags, expected) {
+		t.Errorf("VersionTags: got %v, want %v", tags, expected)
+	}
+
+	commands = nil
+	rev := "d4c3dbfa77a74ae238e401d5d2197b45f30d8513"
+	tag, err := wt.ReachableTag(rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "v1.2.0" {
+		t.Errorf("ReachableTag: got %q", tag)
+	}
+	if expected := []string{"describe", "--tags", "--match=api/v[0-9]*", rev}; !reflect.DeepEqual(commands[0], expected) {
+		t.Errorf("ran %v, want %v", commands[0], expected)
+	}
+
+	// Version tags have the prefix added back; revisions do not.
+	commands = nil
+	for _, ref := range []string{"v1.2.0", rev} {
+		if _, err := wt.RevisionFromTag(ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := [][]string{{"rev-parse", "api/v1.2.0"}, {"rev-parse", rev}}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("ran %v, want %v", commands, expected)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// TagKeyring holds the keys which tag signatures are verified with.
+type TagKeyring struct {
+	// GnuPGHome is the GnuPG home directory holding the public
+	// keys for OpenPGP signatures, or "" for the default.
+	GnuPGHome string
+
+	// AllowedSigners is the ssh-keygen allowed signers file for
+	// SSH signatures, or "" if SSH signatures are not accepted.
+	AllowedSigners string
+}
+
+// env returns the environment to verify signatures in.
+func (k TagKeyring) env() []string {
+	env := commandEnv()
+	if k.GnuPGHome != "" {
+		env = append(env, "GNUPGHOME="+k.GnuPGHome)
+	}
+	return env
+}
+
+// TagSignature describes the verification of a tag's signature.
+type TagSignature struct {
+	// Verified is true if the tag has a good signature from a
+	// key in the keyring.
+	Verified bool `json:"verified"`
+
+	// Signer identifies the key which made a good signature: the
+	// user ID for OpenPGP keys or the principal for SSH keys.
+	Signer string `json:"signer,omitempty"`
+
+	// Reason explains why the tag was not verified.
+	Reason string `json:"reason,omitempty"`
+}
+
+// A SignedTagsWorkingTree is a WorkingTree which can verify the
+// signatures of tags.
+type SignedTagsWorkingTree interface {
+	WorkingTree
+
+	// VerifyTag checks the signature of tag against keyring. A
+	// tag which is not signed, or whose signature is bad or from
+	// an unknown key, is not an error but is not Verified.
+	VerifyTag(tag string, keyring TagKeyring) (*TagSignature, error)
+}
+
+// gpgGoodSigRE matches the status line for a good OpenPGP signature,
+// capturing the user ID.
+var gpgGoodSigRE = regexp.MustCompile(`(?m)^\[GNUPG:\] GOODSIG [0-9A-F]+ (.*)$`)
+
+// sshGoodSigRE matches the output for a good SSH signature,
+// capturing the principal.
+var sshGoodSigRE = regexp.MustCompile(`(?m)^Good "git" signature for (\S+) with`)
+
+// VerifyTag checks the signature of tag against keyring, using 'git
+// verify-tag --raw ...'. Both OpenPGP and SSH signatures are checked.
+func (g *gitWorkingTree) VerifyTag(tag string, keyring TagKeyring) (*TagSignature, error) {
+	args := append([]string{}, g.options...)
+	if keyring.AllowedSigners != "" {
+		args = append(args, "-c", "gpg.ssh.allowedSignersFile="+keyring.AllowedSigners)
+	}
+	args = append(args, "verify-tag", "--raw", g.tagRef(tag))
+	p := g.env.command(g.VCS.Cmd, args...)
+	p.Env = keyring.env()
+	stdout, stderr, err := runProcess(g.context(), p, g.Dir)
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, err
+		}
+	}
+
+	output := stdout.String() + stderr.String()
+	sig := &TagSignature{Verified: err == nil}
+	if m := gpgGoodSigRE.FindStringSubmatch(output); m != nil {
+		sig.Signer = m[1]
+	} else if m := sshGoodSigRE.FindStringSubmatch(output); m != nil {
+		sig.Signer = m[1]
+	}
+	if !sig.Verified {
+		sig.Reason = signatureFailure(output)
+	}
+	return sig, nil
+}
+
+// VerifyTag checks the signature of the revision tag refers to
+// against keyring, using 'hg sigcheck ...' from the gpg extension.
+func (h *hgWorkingTree) VerifyTag(tag string, keyring TagKeyring) (*TagSignature, error) {
+	p := h.env.command(h.VCS.Cmd, "--config", "extensions.gpg=", "sigcheck", tag)
+	p.Env = keyring.env()
+	stdout, stderr, err := runProcess(h.context(), p, h.Dir)
+	if err != nil {
+		h.showOutput(stdout, stderr)
+		return nil, err
+	}
+
+	// Good signatures are listed after "... is signed by:", one
+	// user ID per line.
+	sig := &TagSignature{}
+	output := stdout.String() + stderr.String()
+	scanner := bufio.NewScanner(bytes.NewBufferString(output))
+	signedBy := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasSuffix(line, "is signed by:"):
+			signedBy = true
+		case signedBy && line != "" && sig.Signer == "":
+			sig.Verified = true
+			sig.Signer = line
+		}
+	}
+	if !sig.Verified {
+		sig.Reason = signatureFailure(output)
+	}
+	return sig, nil
+}
+
+// signatureFailure returns the reason given in output for a
+// signature not being verified.
+func signatureFailure(output string) string {
+	var reason string
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "[GNUPG:] NO_PUBKEY"):
+			return "no public key"
+		case strings.HasPrefix(line, "[GNUPG:] BADSIG"):
+			return "bad signature"
+		case strings.HasPrefix(line, "[GNUPG:] EXPKEYSIG"):
+			return "expired key"
+		case strings.HasPrefix(line, "[GNUPG:] REVKEYSIG"):
+			return "revoked key"
+		case line == "" || strings.HasPrefix(line, "[GNUPG:]"):
+			continue
+		case reason == "":
+			reason = strings.TrimPrefix(line, "error: ")
+		}
+	}
+	if reason == "" {
+		reason = "no signature"
+	}
+	return reason
+}
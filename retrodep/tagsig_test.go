@@ -0,0 +1,142 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestGitVerifyTag(t *testing.T) {
+	defer func() { execCommand = exec.Command }()
+
+	tcs := []struct {
+		name   string
+		output string
+		status string
+		exp    TagSignature
+	}{
+		{
+			"gpg-good",
+			"[GNUPG:] NEWSIG\n[GNUPG:] GOODSIG 0123456789ABCDEF Jane Doe <jane@example.com>\n",
+			"0",
+			TagSignature{Verified: true, Signer: "Jane Doe <jane@example.com>"},
+		},
+		{
+			"ssh-good",
+			`Good "git" signature for jane@example.com with ED25519 key SHA256:abc` + "\n",
+			"0",
+			TagSignature{Verified: true, Signer: "jane@example.com"},
+		},
+		{
+			"gpg-unknown",
+			"[GNUPG:] NEWSIG\n[GNUPG:] ERRSIG 0123456789ABCDEF 1 8 00 1554000000 9\n[GNUPG:] NO_PUBKEY 0123456789ABCDEF\n",
+			"1",
+			TagSignature{Reason: "no public key"},
+		},
+		{
+			"unsigned",
+			"error: no signature found\n",
+			"1",
+			TagSignature{Reason: "no signature found"},
+		},
+	}
+	for _, tc := range tcs {
+		var args []string
+		execCommand = func(command string, a ...string) *exec.Cmd {
+			args = a
+			return exec.Command("sh", "-c", `printf "%s" "$0"; exit $1`,
+				tc.output, tc.status)
+		}
+		wt := gitWorkingTree{
+			anyWorkingTree: anyWorkingTree{
+				VCS: vcs.ByCmd(vcsGit),
+			},
+			tagPrefix: "api/",
+		}
+		sig, err := wt.VerifyTag("v1.0.0", TagKeyring{
+			GnuPGHome:      "/keys",
+			AllowedSigners: "/signers",
+		})
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*sig, tc.exp) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, *sig, tc.exp)
+		}
+		exp := []string{"-c", "gpg.ssh.allowedSignersFile=/signers",
+			"verify-tag", "--raw", "api/v1.0.0"}
+		if !reflect.DeepEqual(args, exp) {
+			t.Errorf("%s: ran %v, want %v", tc.name, args, exp)
+		}
+	}
+}
+
+func TestTagKeyringEnv(t *testing.T) {
+	env := TagKeyring{GnuPGHome: "/keys"}.env()
+	if last := env[len(env)-1]; last != "GNUPGHOME=/keys" {
+		t.Errorf("environment ends with %q", last)
+	}
+	for _, kv := range (TagKeyring{}).env() {
+		if strings.HasPrefix(kv, "GNUPGHOME=") {
+			t.Errorf("unexpected %s", kv)
+		}
+	}
+}
+
+func TestHgVerifyTag(t *testing.T) {
+	defer func() { execCommand = exec.Command }()
+
+	tcs := []struct {
+		name   string
+		output string
+		exp    TagSignature
+	}{
+		{
+			"good",
+			"1:0123456789ab is signed by:\n Jane Doe <jane@example.com>\n",
+			TagSignature{Verified: true, Signer: "Jane Doe <jane@example.com>"},
+		},
+		{
+			"unsigned",
+			"no valid signature for 1:0123456789ab\n",
+			TagSignature{Reason: "no valid signature for 1:0123456789ab"},
+		},
+	}
+	for _, tc := range tcs {
+		execCommand = func(command string, args ...string) *exec.Cmd {
+			return exec.Command("printf", "%s", tc.output)
+		}
+		wt := hgWorkingTree{
+			anyWorkingTree: anyWorkingTree{
+				VCS: vcs.ByCmd(vcsHg),
+			},
+		}
+		sig, err := wt.VerifyTag("v1.0.0", TagKeyring{})
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*sig, tc.exp) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, *sig, tc.exp)
+		}
+	}
+}
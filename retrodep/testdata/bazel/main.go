@@ -0,0 +1 @@
+package bazel // import "example.com/bazel"
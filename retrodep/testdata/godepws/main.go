@@ -0,0 +1 @@
+package godepws // import "example.com/godepws"
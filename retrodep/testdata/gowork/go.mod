@@ -0,0 +1,5 @@
+module example.com/gowork
+
+go 1.22
+
+require github.com/foo/bar v1.2.0
@@ -0,0 +1,3 @@
+module example.com/gowork/lib
+
+go 1.22
@@ -0,0 +1,7 @@
+module example.com/gowork/tools
+
+go 1.22
+
+require (
+	github.com/eggs/ham v0.1.0 // indirect
+)
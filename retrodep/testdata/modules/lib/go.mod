@@ -0,0 +1 @@
+module example.com/modules/lib
@@ -0,0 +1,3 @@
+module "example.com/modules/tools"
+
+go 1.12
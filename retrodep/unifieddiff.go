@@ -0,0 +1,238 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// This file contains the unified diff written by Diff, in the same
+// format as 'diff -u'.
+
+// diffContext is the number of unchanged lines shown around each
+// change, as for 'diff -u'.
+const diffContext = 3
+
+// diffTimeFormat is the layout of the modification times in the
+// file headers written by 'diff -u'.
+const diffTimeFormat = "2006-01-02 15:04:05.000000000 -0700"
+
+// diffFiles writes the differences between the files from and to to
+// out in unified diff format, as 'diff -u' does. A from of "" is an
+// empty file. It returns true if there were differences.
+func diffFiles(out io.Writer, from, to string) (bool, error) {
+	fromName, fromLines, err := diffInput(from)
+	if err != nil {
+		return false, err
+	}
+	toName, toLines, err := diffInput(to)
+	if err != nil {
+		return false, err
+	}
+	return unifiedDiff(out, fromName, toName, fromLines, toLines)
+}
+
+// diffInput returns the header and the lines, each with its newline
+// if it has one, of the file path, or of an empty file if path is "".
+func diffInput(path string) (string, []string, error) {
+	if path == "" {
+		return os.DevNull + "\t" + time.Unix(0, 0).Format(diffTimeFormat), nil, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return path + "\t" + info.ModTime().Format(diffTimeFormat), splitLines(string(data)), nil
+}
+
+// splitLines splits s after each newline.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffOp is a line kept (' '), removed ('-') or added ('+') in
+// turning one sequence of lines into another.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns the shortest edit script turning a into b,
+// found with Myers' algorithm.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	off := max + 1
+	v := make([]int, 2*max+3)
+
+	// trace[d] holds v[k] for -d-1 <= k <= d+1 as it was before
+	// step d.
+	var trace [][]int
+	x, y := 0, 0
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int{}, v[off-d-1:off+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y = x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk back through the steps to find the edits.
+	var ops []diffOp
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d]
+		at := func(k int) int { return prev[k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{' ', a[x]})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, diffOp{'+', b[y]})
+		} else {
+			x--
+			ops = append(ops, diffOp{'-', a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, diffOp{' ', a[x]})
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// unifiedDiff writes the differences between the lines of from and
+// to, with the headers fromName and toName, to out. It returns true
+// if there were differences.
+func unifiedDiff(out io.Writer, fromName, toName string, from, to []string) (bool, error) {
+	ops := diffLines(from, to)
+	var changes []int
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return false, nil
+	}
+
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", fromName, toName)
+	for i := 0; i < len(changes); {
+		// Changes close enough to share context make one hunk.
+		j := i
+		for j+1 < len(changes) && changes[j+1]-changes[j] <= 2*diffContext+1 {
+			j++
+		}
+		start := changes[i] - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := changes[j] + diffContext + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+		writeHunk(w, ops, start, end)
+		i = j + 1
+	}
+	return true, w.Flush()
+}
+
+// writeHunk writes the hunk for ops[start:end] to w.
+func writeHunk(w *bufio.Writer, ops []diffOp, start, end int) {
+	var fromLine, toLine int
+	for _, op := range ops[:start] {
+		if op.kind != '+' {
+			fromLine++
+		}
+		if op.kind != '-' {
+			toLine++
+		}
+	}
+	var fromLen, toLen int
+	for _, op := range ops[start:end] {
+		if op.kind != '+' {
+			fromLen++
+		}
+		if op.kind != '-' {
+			toLen++
+		}
+	}
+	fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(fromLine, fromLen), hunkRange(toLine, toLen))
+	for _, op := range ops[start:end] {
+		w.WriteByte(op.kind)
+		w.WriteString(op.line)
+		if !strings.HasSuffix(op.line, "\n") {
+			w.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange returns the range of a hunk header for n lines after the
+// first skipped lines.
+func hunkRange(skipped, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", skipped)
+	case 1:
+		return fmt.Sprintf("%d", skipped+1)
+	}
+	return fmt.Sprintf("%d,%d", skipped+1, n)
+}
@@ -0,0 +1,196 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	type tcase struct {
+		name     string
+		from, to string
+		exp      string
+	}
+	tcases := []tcase{
+		{
+			name: "same",
+			from: "a\nb\n",
+			to:   "a\nb\n",
+		},
+		{
+			name: "changed",
+			from: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			to:   "1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n11\n12\n13\n",
+			exp: "@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n" +
+				"@@ -10,3 +10,4 @@\n 10\n 11\n 12\n+13\n",
+		},
+		{
+			name: "added",
+			from: "",
+			to:   "a\nb\n",
+			exp:  "@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "no-newline",
+			from: "a\nb",
+			to:   "a\nb\n",
+			exp:  "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			changes, err := unifiedDiff(&buf, "from", "to", splitLines(tc.from), splitLines(tc.to))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changes != (tc.exp != "") {
+				t.Errorf("changes: got %v", changes)
+			}
+			exp := ""
+			if tc.exp != "" {
+				exp = "--- from\n+++ to\n" + tc.exp
+			}
+			if got := buf.String(); got != exp {
+				t.Errorf("got:\n%s\nwant:\n%s", got, exp)
+			}
+		})
+	}
+}
+
+func TestDiffFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrodep-unifieddiff.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "new")
+	if err := ioutil.WriteFile(path, []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	changes, err := diffFiles(&buf, "", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changes {
+		t.Error("no changes")
+	}
+	lines := strings.Split(buf.String(), "\n")
+	if len(lines) < 4 ||
+		!strings.HasPrefix(lines[0], "--- "+os.DevNull+"\t") ||
+		!strings.HasPrefix(lines[1], "+++ "+path+"\t") ||
+		lines[2] != "@@ -0,0 +1 @@" ||
+		lines[3] != "+a" {
+		t.Errorf("unexpected diff:\n%s", buf.String())
+	}
+}
+
+// TestDiffFilesGNU compares the output of diffFiles with that of
+// 'diff -u', where there is one.
+func TestDiffFilesGNU(t *testing.T) {
+	if _, err := exec.LookPath("diff"); err != nil {
+		t.Skip("diff not available")
+	}
+	dir, err := ioutil.TempDir("", "retrodep-unifieddiff.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tcases := []struct {
+		name     string
+		from, to string
+	}{
+		{"same", "a\nb\n", "a\nb\n"},
+		{"empty", "", "a\n"},
+		{"removed", "a\nb\nc\n", "a\nc\n"},
+		{"replaced", "a\nb\nc\nd\n", "a\nB\nC\nd\n"},
+		{"prepended", "b\nc\n", "a\nb\nc\n"},
+		{"appended", "a\nb\n", "a\nb\nc\n"},
+		{"no-newline", "a\nb", "a\nc"},
+		{"newline-added", "a\nb", "a\nb\n"},
+		{"hunks", strings.Repeat("x\n", 3) + "a\n" + strings.Repeat("y\n", 10) + "b\n",
+			strings.Repeat("x\n", 3) + "A\n" + strings.Repeat("y\n", 10) + "B\n"},
+		{"joined", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "1\n2\nthree\n4\n5\n6\n7\n8\nnine\n10\n"},
+		{"go", "package a\n\nimport \"fmt\"\n\nfunc A() {\n\tfmt.Println(\"a\")\n}\n",
+			"package a\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\nfunc A() {\n\tfmt.Fprintln(os.Stderr, \"a\")\n}\n"},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			from := filepath.Join(dir, tc.name+".from")
+			to := filepath.Join(dir, tc.name+".to")
+			if tc.from == "" {
+				from = ""
+			} else if err := ioutil.WriteFile(from, []byte(tc.from), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(to, []byte(tc.to), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			changes, err := diffFiles(&buf, from, to)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gnuFrom := from
+			if gnuFrom == "" {
+				gnuFrom = os.DevNull
+			}
+			var gnu bytes.Buffer
+			cmd := exec.Command("diff", "-u", gnuFrom, to)
+			cmd.Stdout = &gnu
+			err = cmd.Run()
+			if changes != exitedWith(err, 1) {
+				t.Errorf("changes: got %v, diff: %v", changes, err)
+			}
+
+			got := strings.SplitAfterN(buf.String(), "\n", 3)
+			exp := strings.SplitAfterN(gnu.String(), "\n", 3)
+			if len(got) != len(exp) {
+				t.Fatalf("got:\n%s\nwant:\n%s", buf.String(), gnu.String())
+			}
+			if len(got) == 3 {
+				// The modification time of os.DevNull is not
+				// that of an empty file, so only compare the
+				// name there.
+				if tc.from == "" {
+					got[0] = strings.SplitN(got[0], "\t", 2)[0]
+					exp[0] = strings.SplitN(exp[0], "\t", 2)[0]
+				}
+				if got[0] != exp[0] {
+					t.Errorf("header: got %q, want %q", got[0], exp[0])
+				}
+				if got[1] != exp[1] {
+					t.Errorf("header: got %q, want %q", got[1], exp[1])
+				}
+				if got[2] != exp[2] {
+					t.Errorf("got:\n%s\nwant:\n%s", got[2], exp[2])
+				}
+			}
+		})
+	}
+}
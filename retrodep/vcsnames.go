@@ -17,3 +17,6 @@ package retrodep
 
 const vcsGit = "git"
 const vcsHg = "hg"
+const vcsSvn = "svn"
+const vcsBzr = "bzr"
+const vcsFossil = "fossil"
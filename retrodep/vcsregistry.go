@@ -0,0 +1,72 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// A WorkingTreeFactory clones the repository for project into the
+// empty directory dir, and returns a WorkingTree for it. Closing the
+// WorkingTree must remove dir. Commands run for it should be killed
+// when ctx is done.
+type WorkingTreeFactory func(ctx context.Context, project *vcs.RepoRoot, dir string) (WorkingTree, error)
+
+var (
+	workingTreeFactoriesMu sync.Mutex
+	workingTreeFactories   = make(map[string]WorkingTreeFactory)
+)
+
+// RegisterWorkingTreeFactory makes NewWorkingTree use fn for
+// repositories whose VCS command is cmd, such as "darcs", instead
+// of any built-in support. The VCS can then also be named by cmd
+// wherever a VCS is given, such as in repository roots files and
+// scan requests. A nil fn removes the registration.
+func RegisterWorkingTreeFactory(cmd string, fn WorkingTreeFactory) {
+	workingTreeFactoriesMu.Lock()
+	defer workingTreeFactoriesMu.Unlock()
+	if fn == nil {
+		delete(workingTreeFactories, cmd)
+		return
+	}
+	workingTreeFactories[cmd] = fn
+}
+
+// workingTreeFactory returns the WorkingTreeFactory registered for
+// cmd, or nil.
+func workingTreeFactory(cmd string) WorkingTreeFactory {
+	workingTreeFactoriesMu.Lock()
+	defer workingTreeFactoriesMu.Unlock()
+	return workingTreeFactories[cmd]
+}
+
+// vcsByCmd is vcs.ByCmd, also knowing fossil and the VCSs registered
+// with RegisterWorkingTreeFactory.
+func vcsByCmd(cmd string) *vcs.Cmd {
+	if v := vcs.ByCmd(cmd); v != nil {
+		return v
+	}
+	if cmd == vcsFossil && workingTreeFactory(cmd) == nil {
+		return vcsFossilCmd
+	}
+	if workingTreeFactory(cmd) != nil {
+		return &vcs.Cmd{Name: cmd, Cmd: cmd}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2019 Tim Waugh
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package retrodep
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestRegisterWorkingTreeFactory(t *testing.T) {
+	if vcsByCmd("darcs") != nil {
+		t.Fatal("darcs known before registration")
+	}
+
+	var dirs []string
+	fail := false
+	RegisterWorkingTreeFactory("darcs", func(ctx context.Context, project *vcs.RepoRoot, dir string) (WorkingTree, error) {
+		dirs = append(dirs, dir)
+		if fail {
+			return nil, errors.New("darcs clone failed")
+		}
+		return &stubWorkingTree{anyWorkingTree{Dir: dir, VCS: project.VCS}}, nil
+	})
+	defer RegisterWorkingTreeFactory("darcs", nil)
+
+	roots, err := ReadStaticResolver(strings.NewReader("example.com/foo darcs https://example.com/foo\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := roots["example.com/foo"]
+	if root == nil || root.VCS.Cmd != "darcs" {
+		t.Fatalf("got %v", root)
+	}
+	wt, err := NewWorkingTree(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := wt.(*stubWorkingTree); !ok || len(dirs) != 1 {
+		t.Fatalf("got %T from %v", wt, dirs)
+	}
+	wt.Close()
+	if _, err := os.Stat(dirs[0]); !os.IsNotExist(err) {
+		t.Errorf("%s not removed: %v", dirs[0], err)
+	}
+
+	fail = true
+	if _, err := NewWorkingTree(root); err == nil || err.Error() != "darcs clone failed" {
+		t.Errorf("got %v", err)
+	}
+	if _, err := os.Stat(dirs[1]); !os.IsNotExist(err) {
+		t.Errorf("%s not removed: %v", dirs[1], err)
+	}
+
+	RegisterWorkingTreeFactory("darcs", nil)
+	if vcsByCmd("darcs") != nil {
+		t.Error("darcs known after removal")
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/Masterminds/semver"
 	"github.com/pkg/errors"
@@ -80,11 +81,27 @@ func (src GoSource) VendoredProjects() (map[string]*RepoPath, error) {
 			return err
 		}
 
+		// Ignore excluded paths
+		if _, skip := src.excludes[pth]; skip {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
 		// Ignore paths within the last project we identified
 		if search.inLastDir(pth) {
 			return nil
 		}
 
+		// Bazel's third_party directory also holds source
+		// which is not Go, at paths which are not import paths
+		if src.usesBazel && info.IsDir() &&
+			filepath.Dir(pth) == search.vendor &&
+			!strings.Contains(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+
 		// Ignore anything except Go source
 		if !info.Mode().IsRegular() || !strings.HasSuffix(pth, ".go") {
 			return nil
@@ -110,6 +127,13 @@ func (src GoSource) VendoredProjects() (map[string]*RepoPath, error) {
 		}
 	}
 
+	// A workspace module only has the projects from the modules
+	// it requires, of those vendored for the whole workspace.
+	for root := range search.vendored {
+		if !src.requiresImportPath(root) {
+			delete(search.vendored, root)
+		}
+	}
 	return search.vendored, nil
 }
 
@@ -170,94 +194,276 @@ func updateHashesAfterStrip(hashes FileHashes, wt WorkingTree, ref string, paths
 }
 
 func matchFromRefs(strip bool, hashes FileHashes, wt WorkingTree, subPath string, refs []string) ([]string, error) {
-	var paths []string
-	if strip {
-		for path := range hashes {
-			paths = append(paths, filepath.Join(subPath, path))
-		}
+	return matchLayoutsFromRefs(strip, []moduleLayout{{hashes, subPath}}, wt, refs)
+}
+
+// matchLayoutsFromRefs is matchFromRefs, but a ref matches if the
+// local files match it in any of layouts.
+func matchLayoutsFromRefs(strip bool, layouts []moduleLayout, wt WorkingTree, refs []string) ([]string, error) {
+	m := &refMatcher{strip: strip, layouts: layouts, wt: wt}
+	if _, err := m.try(refs); err != nil {
+		return nil, err
 	}
+	return m.result()
+}
 
-	matchFromRef := func(th FileHashes, ref string) (bool, error) {
-		if hashes.IsSubsetOf(th) {
-			return true, nil
+// revisionBatch is the number of revisions matchLayoutsFromRevisions
+// reads before trying them.
+const revisionBatch = 512
+
+// matchLayoutsFromRevisions is matchLayoutsFromRefs for every
+// revision of wt, newest first. The revisions are read a batch at a
+// time, as they are needed, so that those after a matching run are
+// never listed by working trees which can stop listing them early.
+func matchLayoutsFromRevisions(strip bool, layouts []moduleLayout, wt WorkingTree) ([]string, error) {
+	m := &refMatcher{strip: strip, layouts: layouts, wt: wt}
+	batch := make([]string, 0, revisionBatch)
+	done := false
+	err := RevisionsIter(wt, func(rev string) (bool, error) {
+		batch = append(batch, rev)
+		if len(batch) < revisionBatch {
+			return false, nil
 		}
+		var err error
+		done, err = m.try(batch)
+		batch = batch[:0]
+		return done, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !done && len(batch) > 0 {
+		if _, err := m.try(batch); err != nil {
+			return nil, err
+		}
+	}
+	return m.result()
+}
+
+// refMatcher finds the first run of refs at which the local files
+// match those upstream in any of layouts, trying refs in the order
+// they are given, over one or more calls to try.
+type refMatcher struct {
+	strip   bool
+	layouts []moduleLayout
+	wt      WorkingTree
 
-		if !strip {
+	// matches holds the matching refs found so far.
+	matches []string
+}
+
+// matchLayout returns true if the local files in layout match th,
+// the file hashes at ref.
+func (m *refMatcher) matchLayout(layout moduleLayout, th FileHashes, ref string) (bool, error) {
+	hashes := layout.hashes
+	if hashes.IsSubsetOf(th) {
+		return true, nil
+	}
+
+	if !m.strip {
+		return false, nil
+	}
+
+	var paths []string
+	for path := range hashes {
+		paths = append(paths, filepath.Join(layout.subPath, path))
+	}
+	for _, path := range paths {
+		if _, ok := th[path]; !ok {
+			// File missing from revision
 			return false, nil
 		}
+	}
 
-		for _, path := range paths {
-			if _, ok := th[path]; !ok {
-				// File missing from revision
-				return false, nil
-			}
-		}
+	changed, err := updateHashesAfterStrip(th, m.wt, ref, paths)
+	if err != nil {
+		return false, err
+	}
 
-		changed, err := updateHashesAfterStrip(th, wt, ref, paths)
-		if err != nil {
-			return false, err
-		}
+	return changed && hashes.IsSubsetOf(th), nil
+}
 
-		return changed && hashes.IsSubsetOf(th), nil
+// try tries each of refs in turn. It returns true once a run of
+// matching refs has ended, after which no more refs need be tried.
+func (m *refMatcher) try(refs []string) (bool, error) {
+	// Reject most refs without finding all their file hashes.
+	// Stripping import comments changes the file hashes probed.
+	var prober *layoutProber
+	if !m.strip {
+		prober = newLayoutProber(m.wt, m.layouts, refs)
 	}
 
-	matches := make([]string, 0)
+	progress.RevisionsQueued(len(refs))
 	for _, ref := range refs {
-		log.Debugf("%s: trying match", ref)
-		refHashes, err := wt.FileHashesFromRef(ref, subPath)
-		if err != nil {
-			if err == ErrorInvalidRef {
+		if !prober.valid(ref) {
+			progress.RevisionTested(ref)
+			metrics.Add(MetricRevisionsTested, 1)
+			continue
+		}
+		matchLog.Debugf("%s: trying match", ref)
+		ok := false
+		for i, layout := range m.layouts {
+			if !prober.mayMatch(i, ref) {
 				continue
 			}
-			return nil, err
-		}
-		ok, err := matchFromRef(refHashes, ref)
-		if err != nil {
-			return nil, err
+			refHashes, err := m.wt.FileHashesFromRef(ref, layout.subPath)
+			if err != nil {
+				if err == ErrorInvalidRef {
+					break
+				}
+				return false, err
+			}
+			ok, err = m.matchLayout(layout, refHashes, ref)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				break
+			}
 		}
+		progress.RevisionTested(ref)
+		metrics.Add(MetricRevisionsTested, 1)
 		if ok {
-			matches = append(matches, ref)
-		} else if len(matches) > 0 {
+			m.matches = append(m.matches, ref)
+		} else if len(m.matches) > 0 {
 			// This is the end of a matching run of refs
-			break
+			return true, nil
 		}
 	}
+	return false, nil
+}
 
-	if len(matches) == 0 {
+// result returns the matching refs found, or ErrorVersionNotFound if
+// there are none.
+func (m *refMatcher) result() ([]string, error) {
+	if len(m.matches) == 0 {
 		return nil, ErrorVersionNotFound
 	}
-
-	return matches, nil
+	return m.matches, nil
 }
 
 // Reference describes the origin of a vendored project.
 type Reference struct {
 	// TopPkg is the name of the top-level package this package is
 	// vendored into, or "" if Pkg is the top-level package.
-	TopPkg string
+	TopPkg string `json:"topPkg,omitempty"`
 
 	// TopVer is the Ver string (see below) for the TopPkg, if
 	// defined.
-	TopVer string
+	TopVer string `json:"topVer,omitempty"`
+
+	// SourceDir is the directory of the top-level project,
+	// relative to the path examined, if that path held several
+	// projects (for example Go modules with their own vendor
+	// directories), or "" otherwise.
+	SourceDir string `json:"sourceDir,omitempty"`
 
 	// Pkg is the name of the package this Reference relates to.
-	Pkg string
+	Pkg string `json:"pkg"`
 
 	// Repo is the URL for the repository holding the source code.
-	Repo string
+	Repo string `json:"repo,omitempty"`
+
+	// Mirror is the URL of the repository used instead of Repo,
+	// if Repo was not available or a faster mirror exists, or ""
+	// if Repo was used. Tag and Rev refer to this repository.
+	Mirror string `json:"mirror,omitempty"`
 
 	// Tag is the semver tag within the upstream repository which
 	// corresponds exactly to the vendored copy of the project. If
 	// no tag corresponds Tag is "".
-	Tag string
+	Tag string `json:"tag,omitempty"`
 
 	// Rev is the upstream revision from which the vendored
 	// copy was taken. If this is not known Rev is "".
-	Rev string
+	Rev string `json:"rev,omitempty"`
+
+	// Change is the code review change ref (for example
+	// refs/changes/45/12345/2 for Gerrit) holding Rev, if Rev is
+	// from an unmerged change, or "" otherwise.
+	Change string `json:"change,omitempty"`
 
 	// Ver is the semantic version or pseudo-version for the
 	// commit named in Reference. This is Tag if Tag is not "".
-	Ver string
+	Ver string `json:"ver,omitempty"`
+
+	// Manifest is the claim made by a dependency manager's
+	// manifest for this project, if any, and whether the vendored
+	// copy was found to match it.
+	Manifest *ManifestClaim `json:"manifest,omitempty"`
+
+	// Fork describes where Rev leaves the history of the
+	// canonical upstream repository, if Repo is a fork of it and
+	// this was checked. Added in schema version 1.8.
+	Fork *ForkPoint `json:"fork,omitempty"`
+
+	// Behind describes how far Rev is behind the latest upstream
+	// release, if this was checked. Added in schema version 1.9.
+	Behind *Staleness `json:"behind,omitempty"`
+
+	// Match is one of the Match... constants, describing whether
+	// the project matched a tag, an untagged revision, or
+	// nothing. It is set by Report.Add. Added in schema version
+	// 1.10.
+	Match string `json:"match,omitempty"`
+
+	// ModuleHash is the "h1:" hash, as recorded in go.sum, of the
+	// Go module zip for the version found, if this was computed.
+	// Added in schema version 1.11.
+	ModuleHash string `json:"moduleHash,omitempty"`
+
+	// Nearest is the upstream version with the fewest files
+	// differing, if nothing matched exactly and this was looked
+	// for. Added in schema version 1.12.
+	Nearest *NearMatch `json:"nearest,omitempty"`
+
+	// Files describes how each file compares with Rev, or else
+	// with Nearest, if this was checked. Added in schema version
+	// 1.13.
+	Files []FileMatch `json:"files,omitempty"`
+
+	// Signature describes the verification of Tag's signature, if
+	// this was checked. Added in schema version 1.14.
+	Signature *TagSignature `json:"signature,omitempty"`
+
+	// HashAlgorithm is the hash algorithm the files were compared
+	// with, such as "git-sha1", so that file hashes recorded
+	// elsewhere for this project can be interpreted. Added in
+	// schema version 1.15.
+	HashAlgorithm string `json:"hashAlgorithm,omitempty"`
+
+	// License is an SPDX license expression for the licenses
+	// identified in the license files of the version found, if
+	// these were looked for. Added in schema version 1.16.
+	License string `json:"license,omitempty"`
+
+	// LicenseFiles are the license files of the version found,
+	// if these were looked for. Added in schema version 1.16.
+	LicenseFiles []LicenseFile `json:"licenseFiles,omitempty"`
+
+	// Vulnerabilities are the known vulnerabilities affecting the
+	// version found, if these were looked for. Added in schema
+	// version 1.17.
+	Vulnerabilities []Vulnerability `json:"vulnerabilities,omitempty"`
+
+	// Replace is the module path of the repository the version was
+	// found in, for a go.mod replace directive, if this is a fork
+	// of the canonical repository for Pkg (see ForkReplacement).
+	// Added in schema version 1.18.
+	Replace string `json:"replace,omitempty"`
+
+	// Pruned describes the upstream files left out of the copy
+	// of the project, if this was checked. Added in schema
+	// version 1.19.
+	Pruned *Pruning `json:"pruned,omitempty"`
+
+	// Time is the commit time of Rev, in UTC, if Ver is a
+	// pseudo-version made from it. Added in schema version 1.20.
+	Time *time.Time `json:"time,omitempty"`
+
+	// Changes lists the files of Files which did not match, by
+	// status. Added in schema version 1.21.
+	Changes *FileChanges `json:"changes,omitempty"`
 }
 
 // chooseBestTag takes a sorted list of tags and returns the oldest
@@ -270,13 +476,13 @@ func chooseBestTag(tags []string) string {
 			continue
 		}
 		if v.Prerelease() == "" {
-			log.Debugf("best from %v: %v (no prerelease)", tags, tag)
+			matchLog.Debugf("best from %v: %v (no prerelease)", tags, tag)
 			return tag
 		}
 	}
 
 	tag := tags[len(tags)-1]
-	log.Debugf("best from %v: %v (earliest)", tags, tag)
+	matchLog.Debugf("best from %v: %v (earliest)", tags, tag)
 	return tag
 }
 
@@ -289,12 +495,15 @@ func (src GoSource) hashLocalFiles(hasher Hasher, project *RepoPath, dir string)
 
 	// Ignore vendor directory
 	excludes[filepath.Join(dir, "vendor")] = struct{}{}
+	if dir == src.Path {
+		excludes[src.Vendor()] = struct{}{}
+	}
 
 	// Work out the sub-directory within the repository root to
 	// use for comparison.
 	subPath := project.SubPath
 	projDir := filepath.Join(project.Root, subPath)
-	log.Debugf("describing %s compared to %s", dir, projDir)
+	matchLog.Debugf("describing %s compared to %s", dir, projDir)
 
 	// Compute the hashes of the local files
 	hashes, err := NewFileHashes(hasher, dir, excludes)
@@ -308,6 +517,7 @@ func (src GoSource) hashLocalFiles(hasher Hasher, project *RepoPath, dir string)
 			delete(hashes, path)
 		}
 	}
+	hashes = hashes.excluding().without(src.hashExcludes.For(project.Root))
 
 	if len(hashes) == 0 {
 		return nil, ErrorNoFiles
@@ -316,6 +526,33 @@ func (src GoSource) hashLocalFiles(hasher Hasher, project *RepoPath, dir string)
 	return hashes, nil
 }
 
+// describeHint fills in ref for a hint which matched. The hint may
+// be one of tags, another tag, or a revision.
+func describeHint(ref *Reference, wt WorkingTree, tags []string, hint string) (*Reference, error) {
+	for _, tag := range tags {
+		if tag != hint {
+			continue
+		}
+		rev, err := wt.RevisionFromTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		ref.Tag = tag
+		ref.Rev = rev
+		ref.Ver = tag
+		return ref, nil
+	}
+
+	// A tag which is not a semantic version still names a commit,
+	// which is reported as the revision, with its pseudo-version.
+	if rev, err := tagCommit(wt, hint); err == nil && rev != "" && !strings.HasPrefix(rev, hint) {
+		ref.Tag = hint
+		return describeRevision(ref, wt, rev)
+	}
+
+	return describeRevision(ref, wt, hint)
+}
+
 // DescribeProject attempts to identify the tag in the version control
 // system which corresponds to the project, available in the working
 // tree wt, based on comparison with files in dir. Vendored files and
@@ -328,6 +565,7 @@ func (src GoSource) DescribeProject(
 	dir string,
 	top *Reference,
 ) (*Reference, error) {
+	defer observeSince(MetricDescribeSeconds, time.Now())
 	hashes, err := src.hashLocalFiles(wt, project, dir)
 	if err != nil {
 		return nil, err
@@ -337,13 +575,17 @@ func (src GoSource) DescribeProject(
 	// use for comparison.
 	subPath := project.SubPath
 	projDir := filepath.Join(project.Root, subPath)
-	log.Debugf("describing %s compared to %s", dir, projDir)
+	matchLog.Debugf("describing %s compared to %s", dir, projDir)
 
 	// If godep is in use, strip import comments from the
 	// project's vendored files (but not files from the top-level
 	// project).
 	strip := src.usesGodep && dir != src.Path
 
+	// A module with a major version suffix may be at the root of
+	// the repository or in a subdirectory, so try both.
+	layouts := moduleLayouts(hashes, subPath, dir)
+
 	var toppkg, topver string
 	if top != nil {
 		toppkg = top.Pkg
@@ -351,29 +593,23 @@ func (src GoSource) DescribeProject(
 	}
 
 	ref := &Reference{
-		TopPkg: toppkg,
-		TopVer: topver,
-		Pkg:    project.Root,
-		Repo:   project.Repo,
+		TopPkg:        toppkg,
+		TopVer:        topver,
+		Pkg:           project.Root,
+		Repo:          project.Repo,
+		HashAlgorithm: wt.HashAlgorithm(),
 	}
 
 	// First try to match against a specific version, if specified
 	if project.Version != "" {
-		matches, err := matchFromRefs(strip, hashes, wt,
-			subPath, []string{project.Version})
+		matches, err := matchLayoutsFromRefs(strip, layouts, wt,
+			[]string{project.Version})
 		switch err {
 		case nil:
 			// Found a match
 			match := matches[0]
-			log.Debugf("Found match for %q which matches dependency management version", match)
-			ver, err := PseudoVersion(wt, match)
-			if err != nil {
-				return nil, err
-			}
-
-			ref.Rev = match
-			ref.Ver = ver
-			return ref, nil
+			matchLog.Debugf("Found match for %q which matches dependency management version", match)
+			return describeRevision(ref, wt, match)
 		case ErrorVersionNotFound:
 			// No match, carry on
 		default:
@@ -382,26 +618,45 @@ func (src GoSource) DescribeProject(
 		}
 	}
 
-	// Second try matching against tags for semantic versions
 	tags, err := wt.VersionTags()
 	if err != nil {
 		return ref, err
 	}
 
-	matches, err := matchFromRefs(strip, hashes, wt, subPath, tags)
-	switch err {
-	case nil:
-		// Found a match
-		match := chooseBestTag(matches)
-		rev, err := wt.RevisionFromTag(match)
-		if err != nil {
+	// Next try any hints, in order
+	for _, hint := range src.hintsFor(project.Root) {
+		if hint == project.Version {
+			// Already tried
+			continue
+		}
+		matches, err := matchLayoutsFromRefs(strip, layouts, wt,
+			[]string{hint})
+		switch err {
+		case nil:
+			matchLog.Debugf("Found match for hint %q", hint)
+			return describeHint(ref, wt, tags, matches[0])
+		case ErrorVersionNotFound:
+			// No match, carry on
+		default:
+			// Some other error, fail
 			return nil, err
 		}
+	}
+
+	// Next try the refs from any fingerprint index
+	if src.index != nil && !strip {
+		described, err := src.describeFromIndex(ref, project, wt, layouts, tags)
+		if err != ErrorVersionNotFound {
+			return described, err
+		}
+	}
 
-		ref.Tag = match
-		ref.Rev = rev
-		ref.Ver = match
-		return ref, nil
+	// Second try matching against tags for semantic versions
+	matches, err := matchLayoutsFromRefs(strip, layouts, wt, tags)
+	switch err {
+	case nil:
+		// Found a match
+		return src.describeTag(ref, project, wt, matches)
 	case ErrorVersionNotFound:
 		// No match, carry on
 	default:
@@ -410,25 +665,66 @@ func (src GoSource) DescribeProject(
 	}
 
 	// Third try each revision
-	revs, err := wt.Revisions()
+	matches, err = matchLayoutsFromRevisions(strip, layouts, wt)
 	if err != nil {
 		return ref, err
 	}
 
-	matches, err = matchFromRefs(strip, hashes, wt, subPath, revs)
+	// Use newest matching revision
+	described, err := describeRevision(ref, wt, matches[0])
 	if err != nil {
 		return ref, err
 	}
+	return described, nil
+}
 
-	// Use newest matching revision
-	rev := matches[0]
-	ver, err := PseudoVersion(wt, rev)
+// describeTag fills in ref for the tag chosen from the sorted list
+// of matching tags.
+func (src GoSource) describeTag(ref *Reference, project *RepoPath, wt WorkingTree, matches []string) (*Reference, error) {
+	match, err := src.chooseMatchingTag(project, matches)
 	if err != nil {
-		return ref, err
+		return nil, err
+	}
+	rev, err := wt.RevisionFromTag(match)
+	if err != nil {
+		return nil, err
+	}
+
+	ref.Tag = match
+	ref.Rev = rev
+	ref.Ver = match
+	return ref, nil
+}
+
+// timeRecorder is a Describable which keeps the commit time it
+// last gave, so that the time a pseudo-version was made from can be
+// reported without asking for it again.
+type timeRecorder struct {
+	Describable
+	t time.Time
+}
+
+func (d *timeRecorder) TimeFromRevision(rev string) (time.Time, error) {
+	t, err := d.Describable.TimeFromRevision(rev)
+	d.t = t
+	return t, err
+}
+
+// describeRevision fills in ref for the matching revision rev, with
+// its pseudo-version and commit time.
+func describeRevision(ref *Reference, wt WorkingTree, rev string) (*Reference, error) {
+	d := &timeRecorder{Describable: wt}
+	ver, err := PseudoVersion(d, rev)
+	if err != nil {
+		return nil, err
 	}
 
 	ref.Rev = rev
 	ref.Ver = ver
+	if !d.t.IsZero() {
+		t := d.t.UTC()
+		ref.Time = &t
+	}
 	return ref, nil
 }
 
@@ -443,5 +739,8 @@ func (src GoSource) DescribeVendoredProject(
 	projRootImportPath := filepath.FromSlash(project.Root)
 	projDir := filepath.Join(src.Vendor(), projRootImportPath)
 	ref, err := src.DescribeProject(project, wt, projDir, top)
+	if ref != nil {
+		ref.Manifest = src.checkManifest(ref)
+	}
 	return ref, err
 }
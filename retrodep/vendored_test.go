@@ -16,7 +16,9 @@
 package retrodep
 
 import (
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestVendoredProjects(t *testing.T) {
@@ -140,3 +142,164 @@ func TestDescribeProject(t *testing.T) {
 		t.Errorf("Revision: got %s but expected %s", ref.Rev, matchRevision)
 	}
 }
+
+func TestDescribeProjectHints(t *testing.T) {
+	src, err := NewGoSource("testdata/gosource", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proj, err := src.Project("github.com/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wt := &mockVendorWorkingTree{}
+	wt.hasher = &dummyHasher{}
+	wt.localHashes, err = src.hashLocalFiles(wt, proj, src.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcs := []struct {
+		hints  []string
+		expTag string
+	}{
+		{[]string{"nomatch", matchRevision}, ""},
+		{[]string{matchVersion}, matchVersion},
+	}
+	for _, tc := range tcs {
+		src.hints = nil
+		src.AddHints(Hints{"github.com/foo/bar": tc.hints})
+		ref, err := src.DescribeProject(proj, wt, src.Path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ref.Rev != matchRevision {
+			t.Errorf("%v: Revision: got %s but expected %s",
+				tc.hints, ref.Rev, matchRevision)
+		}
+		if ref.Tag != tc.expTag {
+			t.Errorf("%v: Tag: got %q but expected %q",
+				tc.hints, ref.Tag, tc.expTag)
+		}
+	}
+}
+
+// zoneWorkingTree has no tags and gives commit times one hour east
+// of UTC.
+type zoneWorkingTree struct{ stubWorkingTree }
+
+func (wt *zoneWorkingTree) ReachableTag(rev string) (string, error) {
+	return "", ErrorVersionNotFound
+}
+
+func (wt *zoneWorkingTree) TimeFromRevision(rev string) (time.Time, error) {
+	return time.Date(2006, 1, 2, 16, 4, 5, 0, time.FixedZone("", 3600)), nil
+}
+
+func TestDescribeRevisionTime(t *testing.T) {
+	ref, err := describeRevision(&Reference{}, &zoneWorkingTree{}, matchRevision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Ver != "v0.0.0-0.20060102150405-"+matchRevision[:12] {
+		t.Errorf("Ver: got %s", ref.Ver)
+	}
+	if ref.Time == nil || ref.Time.Location() != time.UTC ||
+		ref.Time.Format(time.RFC3339) != "2006-01-02T15:04:05Z" {
+		t.Errorf("Time: got %v", ref.Time)
+	}
+
+	// No time is given for tags.
+	ref, err = describeHint(&Reference{}, &zoneWorkingTree{}, []string{matchVersion}, matchVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Time != nil {
+		t.Errorf("Time for tag: got %v", ref.Time)
+	}
+}
+
+// hintTagWorkingTree is a zoneWorkingTree with a tag which is not a
+// semantic version, and which resolves revisions to themselves.
+type hintTagWorkingTree struct{ zoneWorkingTree }
+
+func (wt *hintTagWorkingTree) RevisionFromTag(tag string) (string, error) {
+	switch {
+	case tag == "release-1":
+		return matchRevision, nil
+	case strings.HasPrefix(matchRevision, tag):
+		return matchRevision, nil
+	}
+	return "", ErrorVersionNotFound
+}
+
+func TestDescribeHintTag(t *testing.T) {
+	wt := &hintTagWorkingTree{}
+	ref, err := describeHint(&Reference{}, wt, []string{matchVersion}, "release-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Tag != "release-1" || ref.Rev != matchRevision || ref.Time == nil ||
+		ref.Ver != "v0.0.0-0.20060102150405-"+matchRevision[:12] {
+		t.Errorf("tag: got %+v", ref)
+	}
+
+	ref, err = describeHint(&Reference{}, wt, []string{matchVersion}, matchRevision[:12])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Tag != "" || ref.Rev != matchRevision[:12] ||
+		ref.Ver != "v0.0.0-0.20060102150405-"+matchRevision[:12] {
+		t.Errorf("revision: got %+v", ref)
+	}
+}
+
+// refsWorkingTree is a mockVendorWorkingTree which records the refs
+// whose file hashes are fetched.
+type refsWorkingTree struct {
+	mockVendorWorkingTree
+
+	refs []string
+}
+
+func (wt *refsWorkingTree) FileHashesFromRef(ref, subPath string) (FileHashes, error) {
+	wt.refs = append(wt.refs, ref)
+	return wt.mockVendorWorkingTree.FileHashesFromRef(ref, subPath)
+}
+
+func TestDescribeProjectManifestClaim(t *testing.T) {
+	src, err := NewGoSource("testdata/gosource", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proj, err := src.Project("github.com/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wt := &refsWorkingTree{}
+	wt.hasher = &dummyHasher{}
+	wt.localHashes, err = src.hashLocalFiles(wt, proj, src.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A correct claim from a lock file is the only ref tried.
+	src.addClaim("github.com/foo/bar", &ManifestClaim{
+		File:     "Gopkg.lock",
+		Revision: matchRevision,
+	})
+	ref, err := src.DescribeProject(proj, wt, src.Path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Rev != matchRevision {
+		t.Errorf("Revision: got %s but expected %s", ref.Rev, matchRevision)
+	}
+	if len(wt.refs) != 1 || wt.refs[0] != matchRevision {
+		t.Errorf("file hashes fetched for %v", wt.refs)
+	}
+}
@@ -18,6 +18,8 @@ package retrodep
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -26,7 +28,6 @@ import (
 	"regexp"
 	"sort"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/Masterminds/semver"
@@ -45,7 +46,8 @@ type Describable interface {
 	ReachableTag(rev string) (string, error)
 
 	// TimeFromRevision returns the commit timestamp from the
-	// revision rev.
+	// revision rev, in UTC so that it is the same wherever it is
+	// found.
 	TimeFromRevision(rev string) (time.Time, error)
 }
 
@@ -60,6 +62,18 @@ type WorkingTree interface {
 	// Should be something that supports hashing files.
 	Hasher
 
+	// Root returns the directory holding the local checkout.
+	Root() string
+
+	// HashAlgorithm returns the name of the hash algorithm used
+	// for file hashes, one of the Hash... constants.
+	HashAlgorithm() string
+
+	// SetHashAlgorithm selects the hash algorithm used for file
+	// hashes from now on, which must be one of the Hash...
+	// constants.
+	SetHashAlgorithm(algorithm string) error
+
 	// TagSync syncs the repo to the named tag.
 	TagSync(tag string) error
 
@@ -81,6 +95,23 @@ type WorkingTree interface {
 	// RevisionFromTag returns the revision ID from the tag.
 	RevisionFromTag(tag string) (string, error)
 
+	// Grep returns the lines of files at the tag or revision ref
+	// which match the regular expression pattern. Only files
+	// within subPath, which is relative to the repository root,
+	// are searched, and the paths returned are relative to it.
+	Grep(ref, pattern, subPath string) ([]GrepMatch, error)
+
+	// LastRevisions returns, for each file within subPath at the
+	// tag or revision ref, the most recent revision reachable
+	// from ref which changed it. The map keys are relative to
+	// subPath, which is itself relative to the repository root.
+	LastRevisions(ref, subPath string) (map[string]string, error)
+
+	// Submodules returns the submodules (or subrepositories) of
+	// the tag or revision ref, with the revisions they are pinned
+	// to, in path order.
+	Submodules(ref string) ([]Submodule, error)
+
 	// StripImportComment removes import comments from package
 	// declarations in the same way godep does, writing the result
 	// (if changed) to w. It returns a boolean indicating whether
@@ -89,68 +120,568 @@ type WorkingTree interface {
 	// The file content may be written to w even if no change was made.
 	StripImportComment(path string, w io.Writer) (bool, error)
 
-	// Diff writes output to out from 'diff -u' comparing the
-	// path within the working tree with the localFile. It returns
-	// true if changes were found and false if not.
+	// Diff writes output to out in the format of 'diff -u'
+	// comparing the path within the working tree with the
+	// localFile. It returns true if changes were found and false
+	// if not.
 	Diff(out io.Writer, path, localFile string) (bool, error)
 }
 
+// GrepMatch is a line matched by WorkingTree.Grep.
+type GrepMatch struct {
+	// Path is the file containing the line, relative to the
+	// subPath searched.
+	Path string
+
+	// Line is the line number, starting from 1.
+	Line int
+
+	// Text is the content of the line.
+	Text string
+}
+
+// Submodule is a repository nested within another, as returned by
+// WorkingTree.Submodules.
+type Submodule struct {
+	// Path is the directory of the submodule, relative to the
+	// repository root.
+	Path string `json:"path"`
+
+	// Rev is the revision of the submodule which is pinned.
+	Rev string `json:"rev"`
+
+	// URL is the repository URL of the submodule, or "" if it is
+	// not known.
+	URL string `json:"url,omitempty"`
+}
+
+// A ForkWorkingTree is a WorkingTree which can also find how its
+// revisions relate to those of the repository it was forked from.
+type ForkWorkingTree interface {
+	WorkingTree
+
+	// ForkPoint returns where the history of rev left that of the
+	// repository at the URL upstream.
+	ForkPoint(rev, upstream string) (*ForkPoint, error)
+}
+
+// A BehindWorkingTree is a WorkingTree which can also count the
+// commits between two of its revisions.
+type BehindWorkingTree interface {
+	WorkingTree
+
+	// CommitsBehind returns the number of commits reachable from
+	// target but not from rev, and the tags of those commits.
+	CommitsBehind(rev, target string) (int, []string, error)
+}
+
+// A ChangesWorkingTree is a WorkingTree which can also find
+// revisions from unmerged code review changes, such as Gerrit's
+// refs/changes/*.
+type ChangesWorkingTree interface {
+	WorkingTree
+
+	// FetchChanges fetches the refs for code review changes, so
+	// that their revisions are included in Revisions.
+	FetchChanges() error
+
+	// UnmergedChange returns the name of the change ref containing
+	// rev if rev is only reachable from unmerged changes, or "" if
+	// it is not.
+	UnmergedChange(rev string) (string, error)
+}
+
+// A ContextWorkingTree is a WorkingTree whose VCS commands can be
+// cancelled. All those created by NewWorkingTree are.
+type ContextWorkingTree interface {
+	WorkingTree
+
+	// WithContext returns a WorkingTree for the same checkout
+	// whose VCS commands are killed when ctx is done, making the
+	// methods running them return ctx.Err(). Only one of them
+	// should be closed.
+	WithContext(ctx context.Context) WorkingTree
+}
+
 // anyWorkingTree uses the golang.org/x/tools/go/vcs Cmd type for
 // interacting with the working tree. Other types build on this to
 // provide methods not handled by vcs.Cmd.
 type anyWorkingTree struct {
-	Dir    string
-	VCS    *vcs.Cmd
-	hasher Hasher
+	Dir       string
+	VCS       *vcs.Cmd
+	hasher    Hasher
+	algorithm string
+	cache     *fileHashesCache
+
+	// repo is the URL the working tree was cloned from, if known.
+	repo string
+
+	// nativeAlgorithm is the hash algorithm the VCS itself
+	// records file hashes with, if any.
+	nativeAlgorithm string
+
+	// options are given to the VCS before the arguments of each
+	// command, such as git's "-c name=value".
+	options []string
+
+	// authEnv is added to the environment of each VCS command.
+	authEnv []string
+
+	// ctx, if set, is the context VCS commands are run with.
+	ctx context.Context
+
+	// release, if set, is called on Close. It removes any file
+	// written with credentials for the VCS commands.
+	release func()
+
+	// includeAll, if set, stops hashExcludes and hashIncludes
+	// applying to the file hashes of refs.
+	includeAll bool
+
+	// limits restricts the revisions listed by Revisions.
+	limits RevisionLimits
+
+	// branch, if set, is the only branch whose revisions are
+	// listed by Revisions.
+	branch string
+
+	// env holds the settings of the Scanner which created the
+	// working tree, if any.
+	env *scanEnv
+
+	// keep stops Close removing Dir.
+	keep bool
+
+	// borrowed is set when Dir is a checkout from LocalRepo,
+	// which Close leaves alone.
+	borrowed bool
+
+	// retry says how fetches which fail are tried again.
+	retry RetryPolicy
+
+	// excludes are the paths left out of the file hashes of refs
+	// besides hashExcludes.
+	excludes Ignore
+
+	// tagMatch chooses the tags ReachableTag considers.
+	tagMatch TagMatch
+}
+
+// WorkingTreeOption is an option for NewWorkingTree.
+type WorkingTreeOption func(*workingTreeOptions)
+
+type workingTreeOptions struct {
+	partial      bool
+	cacheDir     string
+	cacheMaxSize int64
+	proxy        string
+	forgeAPI     bool
+	exportAttrs  bool
+	submodules   bool
+	lfs          bool
+	tagPrefix    string
+	limits       RevisionLimits
+	branch       string
+	env          *scanEnv
+	algorithm    string
+	keep         bool
+	localRepo    string
+	retry        RetryPolicy
+	excludes     Ignore
+	credentials  string
+	tagMatch     TagMatch
+
+	// authEnv is the environment supplying credentials to the
+	// VCS commands. It is not set by an option but by
+	// newWorkingTree.
+	authEnv []string
+}
+
+// ExportAttributes makes git working trees find the file hashes of
+// each ref from 'git archive', so that files marked export-ignore in
+// .gitattributes are left out and those marked export-subst are
+// hashed after substitution, as in release archives.
+func ExportAttributes() WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		o.exportAttrs = true
+	}
+}
+
+// PartialClone makes git working trees be cloned without file
+// contents, which git fetches when they are first needed. File
+// hashes only need trees, so most versions can be tried without
+// fetching any. It does not apply to the mirrors kept by RepoCache.
+func PartialClone() WorkingTreeOption {
+	return func(o *workingTreeOptions) {
+		o.partial = true
+	}
+}
+
+// partialCloneFilter is the object filter used for PartialClone.
+const partialCloneFilter = "--filter=blob:none"
+
+// partialCloneArgs returns the git clone command line args with the
+// partial clone filter added.
+func partialCloneArgs(args []string) []string {
+	for i, arg := range args {
+		if arg == "clone" {
+			partial := append([]string{}, args[:i+1]...)
+			partial = append(partial, partialCloneFilter)
+			return append(partial, args[i+1:]...)
+		}
+	}
+	return args
 }
 
 // NewWorkingTree creates a local checkout of the version control
-// system for a Go project.
-func NewWorkingTree(project *vcs.RepoRoot) (WorkingTree, error) {
-	dir, err := ioutil.TempDir("", "retrodep.")
-	if err != nil {
+// system for a Go project. Git, Mercurial, Subversion and Bazaar are
+// built in, and others can be added with RegisterWorkingTreeFactory.
+func NewWorkingTree(project *vcs.RepoRoot, opts ...WorkingTreeOption) (WorkingTree, error) {
+	return NewWorkingTreeContext(context.Background(), project, opts...)
+}
+
+// NewWorkingTreeContext is NewWorkingTree, with the clone and the
+// VCS commands run by the returned WorkingTree killed when ctx is
+// done. The WorkingTree is a ContextWorkingTree, so it can be given
+// a different context with WithContext.
+func NewWorkingTreeContext(ctx context.Context, project *vcs.RepoRoot, opts ...WorkingTreeOption) (WorkingTree, error) {
+	var config workingTreeOptions
+	for _, opt := range opts {
+		opt(&config)
+	}
+	wt, err := newWorkingTree(ctx, project, config, opts)
+	if err != nil || config.algorithm == "" {
+		return wt, err
+	}
+	if err := wt.SetHashAlgorithm(config.algorithm); err != nil {
+		wt.Close()
 		return nil, err
 	}
+	return wt, nil
+}
+
+// newWorkingTree is NewWorkingTreeContext, with the options opts
+// already applied to config.
+func newWorkingTree(ctx context.Context, project *vcs.RepoRoot, config workingTreeOptions, opts []WorkingTreeOption) (WorkingTree, error) {
+	env := config.env
+	log := env.log(SubsystemGeneral)
+	if config.credentials != "" {
+		if err := useRepoCredentials(project.Repo, config.credentials); err != nil {
+			return nil, err
+		}
+	}
+	local := config.localRepo != ""
+	var dir string
+	var err error
+	if !local {
+		dir, err = env.tempDir("retrodep.")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.proxy != "" && !local {
+		pwt, err := newProxyWorkingTree(ctx, config.proxy, project, dir)
+		if err == nil {
+			pwt.env = env
+			pwt.keep = config.keep
+			pwt.excludes = config.excludes
+			pwt.tagMatch = config.tagMatch
+			return pwt, nil
+		}
+		if err != ErrorVersionNotFound {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		log.Debugf("%s: not known to %s, cloning", project.Root, config.proxy)
+	}
+	if config.forgeAPI && !local {
+		fwt, err := newForgeWorkingTree(ctx, project, dir)
+		if err == nil {
+			fwt.env = env
+			fwt.keep = config.keep
+			fwt.excludes = config.excludes
+			fwt.tagMatch = config.tagMatch
+			return fwt, nil
+		}
+		if err != errNoForgeAPI {
+			log.Debugf("%s: forge API: %s, cloning", project.Repo, err)
+		}
+	}
 
-	err = project.VCS.Create(dir, project.Repo)
+	var options []string
+	switch project.VCS.Cmd {
+	case vcsGit:
+		options = append(gitConfigArgs(project.Repo), gitAuthArgs(project.Repo)...)
+		if config.lfs {
+			options = append(options, lfsOptions...)
+		}
+	case vcsHg:
+		options = hgAuthArgs(project.Repo)
+	case vcsSvn:
+		options = svnOptions
+	}
+	credEnv, removeAuth, err := authEnv(project.VCS.Cmd, project.Repo)
 	if err != nil {
-		os.RemoveAll(dir)
+		if !local {
+			os.RemoveAll(dir)
+		}
 		return nil, err
 	}
+	if removeAuth == nil {
+		removeAuth = func() {}
+	}
+	config.authEnv = credEnv
+	var created WorkingTree
+	if local {
+		dir, err = openLocalRepo(ctx, project, &config, options)
+	} else {
+		created, err = cloneRepo(ctx, project, &config, dir, options)
+	}
+	if err != nil || created != nil {
+		removeAuth()
+		return created, err
+	}
 
 	wt := anyWorkingTree{
-		Dir: dir,
-		VCS: project.VCS,
+		Dir:      dir,
+		VCS:      project.VCS,
+		cache:    newFileHashesCache(fileHashesCacheSize),
+		repo:     project.Repo,
+		ctx:      ctx,
+		release:  removeAuth,
+		authEnv:  credEnv,
+		limits:   config.limits,
+		branch:   config.branch,
+		env:      env,
+		keep:     config.keep,
+		borrowed: local,
+		retry:    config.retry,
+		excludes: config.excludes,
+		tagMatch: config.tagMatch,
 	}
 	switch project.VCS.Cmd {
 	case vcsGit:
-		wt.hasher = &gitHasher{}
-		return &gitWorkingTree{anyWorkingTree: wt}, nil
+		wt.options = options
+		gwt := &gitWorkingTree{anyWorkingTree: wt}
+		gwt.hasher = &gitHasher{dir: dir, options: options}
+		gwt.nativeAlgorithm = HashGitSHA1
+		if gwt.objectFormat() == "sha256" {
+			gwt.nativeAlgorithm = HashGitSHA256
+		}
+		gwt.algorithm = gwt.nativeAlgorithm
+		gwt.exportAttributes = config.exportAttrs
+		gwt.lfs = config.lfs
+		gwt.tagPrefix = config.tagPrefix
+		if config.submodules {
+			// Submodules are cloned, not found in the
+			// local repository.
+			gwt.submodules = newGitSubmodules(ctx, append(opts[:len(opts):len(opts)], LocalRepo("")))
+		}
+		return gwt, nil
 	case vcsHg:
 		wt.hasher = &sha256Hasher{}
-		return &hgWorkingTree{anyWorkingTree: wt}, nil
+		wt.algorithm = HashSHA256
+		return &hgWorkingTree{
+			anyWorkingTree: wt,
+			server:         newHgCmdServer(env, dir),
+		}, nil
+	case vcsSvn:
+		wt.options = options
+		wt.hasher = &sha256Hasher{}
+		wt.algorithm = HashSHA256
+		swt, err := newSvnWorkingTree(wt)
+		if err != nil {
+			wt.Close()
+			return nil, err
+		}
+		return swt, nil
+	case vcsBzr:
+		wt.hasher = &sha256Hasher{}
+		wt.algorithm = HashSHA256
+		return &bzrWorkingTree{anyWorkingTree: wt}, nil
+	case vcsFossil:
+		wt.hasher = &sha256Hasher{}
+		wt.algorithm = HashSHA256
+		return &fossilWorkingTree{anyWorkingTree: wt}, nil
 	}
 
 	wt.Close()
 	return nil, ErrorUnknownVCS
 }
 
-// Close removes the local checkout.
+// cloneRepo clones the repository for project into dir, removing dir
+// if it fails. It returns the working tree itself if a
+// WorkingTreeFactory made it.
+func cloneRepo(ctx context.Context, project *vcs.RepoRoot, config *workingTreeOptions, dir string, options []string) (WorkingTree, error) {
+	env := config.env
+	log := env.log(SubsystemGeneral)
+	clones := env.cloneLimiter()
+	clones.acquire()
+	releaseHost := acquireHost(project.Repo)
+	start := time.Now()
+	progress.CloneStarted(project.Repo)
+	factory := workingTreeFactory(project.VCS.Cmd)
+	var created WorkingTree
+	cached := false
+	var err error
+	if factory != nil {
+		created, err = factory(ctx, project, dir)
+	} else if config.cacheDir != "" && project.VCS.Cmd == vcsGit {
+		err = cachedClone(ctx, config, project.Repo, dir, options)
+		if err != nil {
+			log.Debugf("%s: repository cache: %s", project.Repo, err)
+			os.RemoveAll(dir)
+			os.Mkdir(dir, 0700)
+		}
+		cached = err == nil
+	}
+	if factory == nil && !cached {
+		args := expandCmdline(createCmdline(project.VCS), "dir", dir, "repo", project.Repo)
+		if config.partial && project.VCS.Cmd == vcsGit {
+			args = partialCloneArgs(args)
+		}
+		args = append(append([]string{}, options...), args...)
+		var output string
+		output, err = config.retry.run(ctx, log, project.Repo, func(ctx context.Context) (string, error) {
+			stdout, stderr, err := runProcess(ctx, withEnv(env.command(project.VCS.Cmd, args...), config.authEnv), ".")
+			if err != nil {
+				// Start again with an empty directory.
+				os.RemoveAll(dir)
+				os.Mkdir(dir, 0700)
+			}
+			return strings.TrimSpace(stdout.String() + stderr.String()), err
+		})
+		if err != nil {
+			log.Debugf("%s: %s", project.Repo, output)
+			if ctx.Err() == nil {
+				err = newCloneError(project.Repo, err, output)
+			}
+		}
+	}
+	releaseHost()
+	clones.release()
+	traceDuration(start, project.VCS.Cmd+" create "+project.Repo, err)
+	RecordPhase(PhaseClone, start)
+	observeSince(MetricCloneSeconds, start)
+	progress.CloneFinished(project.Repo, err)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	recordClone(dir)
+	return created, nil
+}
+
+// Close removes the local checkout, unless KeepWorkingTree was
+// given, in which case it logs where the checkout is, or it is the
+// checkout from LocalRepo.
 func (wt *anyWorkingTree) Close() error {
-	return os.RemoveAll(wt.Dir)
+	var err error
+	switch {
+	case wt.borrowed:
+	case wt.keep:
+		wt.env.log(SubsystemGeneral).Infof("%s: kept working tree %s", wt.repo, wt.Dir)
+	default:
+		err = os.RemoveAll(wt.Dir)
+	}
+	if wt.release != nil {
+		wt.release()
+	}
+	return err
 }
 
+// Root returns the directory holding the local checkout.
+func (wt *anyWorkingTree) Root() string {
+	return wt.Dir
+}
+
+// HashAlgorithm returns the name of the hash algorithm used for file
+// hashes.
+func (wt *anyWorkingTree) HashAlgorithm() string {
+	return wt.algorithm
+}
+
+// SetHashAlgorithm selects the hash algorithm used for file hashes.
+// Cached file hashes are discarded.
+func (wt *anyWorkingTree) SetHashAlgorithm(algorithm string) error {
+	hasher, err := NewHasher(algorithm)
+	if err != nil {
+		return err
+	}
+	if algorithm == wt.nativeAlgorithm && wt.VCS != nil && wt.VCS.Cmd == vcsGit {
+		// Let git apply any filters from .gitattributes.
+		hasher = &gitHasher{dir: wt.Dir, options: wt.options}
+	}
+	wt.hasher = hasher
+	wt.algorithm = algorithm
+	if wt.cache != nil {
+		wt.cache = newFileHashesCache(wt.cache.size)
+	}
+	return nil
+}
+
+// TagSync syncs the repo to the named tag, as vcs.Cmd.TagSync does.
 func (wt *anyWorkingTree) TagSync(tag string) error {
-	return wt.VCS.TagSync(wt.Dir, tag)
+	v := wt.VCS
+	if v.TagSyncCmd == "" {
+		return nil
+	}
+	if tag != "" {
+		for _, tc := range v.TagLookupCmd {
+			stdout, stderr, err := wt.run(expandCmdline(tc.Cmd, "tag", tag)...)
+			if err != nil {
+				wt.showOutput(stdout, stderr)
+				return err
+			}
+			re := regexp.MustCompile(`(?m-s)` + tc.Pattern)
+			if m := re.FindStringSubmatch(stdout.String()); len(m) > 1 {
+				tag = m[1]
+				break
+			}
+		}
+	}
+	cmdline := v.TagSyncCmd
+	if tag == "" && v.TagSyncDefault != "" {
+		cmdline = v.TagSyncDefault
+	}
+	stdout, stderr, err := wt.run(expandCmdline(cmdline, "tag", tag)...)
+	if err != nil {
+		wt.showOutput(stdout, stderr)
+	}
+	return err
+}
+
+// tags returns all the tags, as vcs.Cmd.Tags does.
+func (wt *anyWorkingTree) tags() ([]string, error) {
+	var tags []string
+	for _, tc := range wt.VCS.TagCmd {
+		stdout, stderr, err := wt.run(expandCmdline(tc.Cmd)...)
+		if err != nil {
+			wt.showOutput(stdout, stderr)
+			return nil, err
+		}
+		re := regexp.MustCompile(`(?m-s)` + tc.Pattern)
+		for _, m := range re.FindAllStringSubmatch(stdout.String(), -1) {
+			tags = append(tags, m[1])
+		}
+	}
+	return tags, nil
 }
 
 // VersionTags returns the tags that are parseable as semantic tags,
 // e.g. v1.1.0.
 func (wt *anyWorkingTree) VersionTags() ([]string, error) {
-	tags, err := wt.VCS.Tags(wt.Dir)
+	tags, err := wt.tags()
 	if err != nil {
 		return nil, err
 	}
+	return versionTags(tags), nil
+}
+
+// versionTags returns those of tags which are parseable as semantic
+// versions, highest first.
+func versionTags(tags []string) []string {
 	versions := make(semver.Collection, 0)
 	versionTags := make(map[*semver.Version]string)
 	for _, tag := range tags {
@@ -166,30 +697,197 @@ func (wt *anyWorkingTree) VersionTags() ([]string, error) {
 	for i, v := range versions {
 		strTags[i] = versionTags[v]
 	}
-	return strTags, nil
+	return strTags
 }
 
-// run runs the VCS command with the provided args
-// and returns stdout and stderr (as bytes.Buffer).
+// run runs the VCS command with the provided args, after the
+// working tree's options, and returns stdout and stderr (as
+// bytes.Buffer).
 func (wt *anyWorkingTree) run(args ...string) (*bytes.Buffer, *bytes.Buffer, error) {
-	p := execCommand(wt.VCS.Cmd, args...)
+	return wt.runInput(nil, args...)
+}
+
+// runInput is run, with stdin read from input.
+func (wt *anyWorkingTree) runInput(input io.Reader, args ...string) (*bytes.Buffer, *bytes.Buffer, error) {
+	return wt.runContext(wt.context(), input, args...)
+}
+
+// runContext is runInput, with the command killed when ctx is done.
+func (wt *anyWorkingTree) runContext(ctx context.Context, input io.Reader, args ...string) (*bytes.Buffer, *bytes.Buffer, error) {
+	if len(wt.options) > 0 {
+		args = append(append([]string{}, wt.options...), args...)
+	}
+	p := withEnv(wt.env.command(wt.VCS.Cmd, args...), wt.authEnv)
+	p.Stdin = input
+	return runProcess(ctx, p, wt.Dir)
+}
+
+// runRetried is run, for commands which fetch from the upstream
+// repository, tried again on failure as the RetryPolicy says.
+func (wt *anyWorkingTree) runRetried(args ...string) (*bytes.Buffer, *bytes.Buffer, error) {
+	var stdout, stderr *bytes.Buffer
+	_, err := wt.retry.run(wt.context(), wt.env.log(SubsystemVCS), wt.repo, func(ctx context.Context) (string, error) {
+		release := acquireHost(wt.repo)
+		defer release()
+		var err error
+		stdout, stderr, err = wt.runContext(ctx, nil, args...)
+		return stdout.String() + stderr.String(), err
+	})
+	return stdout, stderr, err
+}
+
+// context returns the context VCS commands are run with.
+func (wt *anyWorkingTree) context() context.Context {
+	if wt.ctx == nil {
+		return context.Background()
+	}
+	return wt.ctx
+}
+
+// environment returns the settings of the Scanner which created the
+// working tree, or nil.
+func (wt *anyWorkingTree) environment() *scanEnv {
+	return wt.env
+}
+
+// runArgs runs the VCS command cmd with args in dir and returns
+// stdout and stderr (as bytes.Buffer).
+func runArgs(cmd, dir string, args []string) (*bytes.Buffer, *bytes.Buffer, error) {
+	return runProcess(context.Background(), execCommand(cmd, args...), dir)
+}
+
+// runProcess runs p in dir, killing it if ctx is done first, and
+// returns stdout and stderr (as bytes.Buffer).
+func runProcess(ctx context.Context, p *exec.Cmd, dir string) (*bytes.Buffer, *bytes.Buffer, error) {
 	var stdout, stderr bytes.Buffer
 	p.Stdout = &stdout
 	p.Stderr = &stderr
-	p.Dir = wt.Dir
-	err := p.Run()
+	p.Dir = dir
+	err := runCommandContext(ctx, p)
 	return &stdout, &stderr, err
 }
 
-// showOutput writes stdout to os.Stdout and stderr to os.Stderr.
+// createCmdline returns the CreateCmd of v with "--" before {repo},
+// for the version control systems known to accept it, so that a
+// repository starting with "-" is not taken as an option.
+func createCmdline(v *vcs.Cmd) string {
+	switch v.Cmd {
+	case vcsGit, vcsHg, vcsSvn, vcsBzr:
+		if !strings.Contains(v.CreateCmd, " -- ") {
+			return strings.Replace(v.CreateCmd, "{repo}", "-- {repo}", 1)
+		}
+	}
+	return v.CreateCmd
+}
+
+// expandCmdline splits cmdline, one of the command lines from a
+// vcs.Cmd such as CreateCmd, into arguments with each "{key}"
+// replaced by its value from keyval.
+func expandCmdline(cmdline string, keyval ...string) []string {
+	args := strings.Fields(cmdline)
+	for i := range args {
+		for j := 0; j+1 < len(keyval); j += 2 {
+			args[i] = strings.Replace(args[i], "{"+keyval[j]+"}", keyval[j+1], -1)
+		}
+	}
+	return args
+}
+
+// showOutput logs the output of a failed VCS command run in the
+// working tree.
 func (wt *anyWorkingTree) showOutput(stdout, stderr *bytes.Buffer) {
-	os.Stdout.Write(stdout.Bytes())
-	os.Stderr.Write(stderr.Bytes())
+	wt.env.log(SubsystemVCS).output(stdout, stderr, "dir", wt.Dir)
+}
+
+// changeLogPrefix starts the line giving the revision in the output
+// parsed by parseChangeLog.
+const changeLogPrefix = "commit "
+
+// lastChanges collects the newest revision changing each file
+// within subPath from a log of revisions read newest first.
+type lastChanges struct {
+	subPath string
+	revs    map[string]string
+	seen    map[string]bool
+}
+
+func newLastChanges(subPath string) *lastChanges {
+	return &lastChanges{
+		subPath: subPath,
+		revs:    make(map[string]string),
+		seen:    make(map[string]bool),
+	}
+}
+
+// add records that rev changed path, relative to the repository
+// root, unless a newer revision has already changed it. The status
+// is "D" (or starts with it) for deleted files.
+func (c *lastChanges) add(rev, status, path string) {
+	if c.subPath != "" {
+		rel, err := filepath.Rel(c.subPath, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return
+		}
+		path = rel
+	}
+	if c.seen[path] {
+		return
+	}
+	c.seen[path] = true
+	if !strings.HasPrefix(status, "D") {
+		c.revs[path] = rev
+	}
+}
+
+// parseChangeLog reads a log of revisions, newest first, from r and
+// returns the newest revision changing each file within subPath
+// which was not then deleted. Each revision is given by a line
+// starting with changeLogPrefix, followed by a line for each file it
+// changed of the form "<status> TAB <path>", where status is "D"
+// for deleted files.
+func parseChangeLog(r io.Reader, subPath string) (map[string]string, error) {
+	changes := newLastChanges(subPath)
+	var rev string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, changeLogPrefix) {
+			rev = strings.TrimSpace(line[len(changeLogPrefix):])
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 || rev == "" {
+			return nil, fmt.Errorf("unexpected log output: %s", line)
+		}
+		changes.add(rev, fields[0], fields[1])
+	}
+	return changes.revs, scanner.Err()
+}
+
+// modulePseudoVersions is set by SetModulePseudoVersions.
+var modulePseudoVersions bool
+
+// SetModulePseudoVersions makes PseudoVersion return the
+// pseudo-versions the go command would, from ModulePseudoVersion.
+func SetModulePseudoVersions() {
+	modulePseudoVersions = true
 }
 
 // PseudoVersion returns a semantic-like comparable version for a
-// revision, based on tags reachable from that revision.
+// revision, based on tags reachable from that revision. Its form
+// may be changed with SetModulePseudoVersions or
+// SetPseudoVersionFormatter.
 func PseudoVersion(d Describable, rev string) (string, error) {
+	if pseudoVersionFormatter != nil {
+		return formatPseudoVersion(pseudoVersionFormatter, d, rev)
+	}
+	if modulePseudoVersions {
+		return ModulePseudoVersion(d, rev)
+	}
+
 	suffix := "-0." // This commit is *before* some other tag
 	var version string
 	reachable, err := d.ReachableTag(rev)
@@ -220,11 +918,69 @@ func PseudoVersion(d Describable, rev string) (string, error) {
 		return "", err
 	}
 
-	timestamp := t.Format("20060102150405")
-	pseudo := version + suffix + timestamp + "-" + rev[:12]
+	timestamp := t.UTC().Format("20060102150405")
+
+	// As for Go modules, the revision is abbreviated to 12
+	// characters whatever its length, including for git's
+	// sha256 object format.
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	pseudo := version + suffix + timestamp + "-" + rev
 	return pseudo, nil
 }
 
+// moduleSemverRE matches the semantic versions Go modules accept as
+// the base of a pseudo-version: with a "v" prefix, all three
+// numbers, and no build metadata.
+var moduleSemverRE = regexp.MustCompile(`^v(?:0|[1-9][0-9]*)\.(?:0|[1-9][0-9]*)\.(?:0|[1-9][0-9]*)(-[0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*)?$`)
+
+// ModulePseudoVersion returns the pseudo-version the go command
+// would give revision rev, which 'go mod' accepts verbatim: the
+// commit time in UTC and the abbreviated revision follow the version
+// after the most recent reachable tag which Go modules accept as a
+// semantic version, as in v1.2.4-0.20190101000000-0123456789ab for
+// a commit after v1.2.3, or v0.0.0-20190101000000-0123456789ab if
+// there is no such tag.
+func ModulePseudoVersion(d Describable, rev string) (string, error) {
+	var base []string
+	reachable, err := d.ReachableTag(rev)
+	switch {
+	case err == ErrorVersionNotFound:
+	case err != nil:
+		return "", err
+	default:
+		if base = moduleSemverRE.FindStringSubmatch(reachable); base == nil {
+			log.Debugf("%s: %s is not a module version", rev, reachable)
+		}
+	}
+
+	t, err := d.TimeFromRevision(rev)
+	if err != nil {
+		return "", err
+	}
+
+	suffix := t.UTC().Format("20060102150405") + "-"
+	if len(rev) > 12 {
+		suffix += rev[:12]
+	} else {
+		suffix += rev
+	}
+	switch {
+	case base == nil:
+		return "v0.0.0-" + suffix, nil
+	case base[1] != "":
+		// Prerelease
+		return base[0] + ".0." + suffix, nil
+	}
+	ver, err := semver.NewVersion(base[0])
+	if err != nil {
+		return "", err
+	}
+	next := ver.IncPatch()
+	return "v" + next.String() + "-0." + suffix, nil
+}
+
 const quotedRE = `(?:"[^"]+"|` + "`[^`]+`)"
 const importRE = `\s*import\s+` + quotedRE + `\s*`
 
@@ -298,36 +1054,70 @@ func (wt *anyWorkingTree) Hash(relativePath, absPath string) (FileHash, error) {
 	return wt.hasher.Hash(relativePath, absPath)
 }
 
-// Diff writes output to stdout from 'diff -u' comparing the
-// path within the working tree with the localFile. It returns
-// true if changes were found and false if not.
+// Diff writes output to out in the unified format of 'diff -u'
+// comparing the path within the working tree with the localFile. It
+// returns true if changes were found and false if not. The
+// differences are found in-process rather than by running 'diff'
+// for each file.
 func (wt *anyWorkingTree) Diff(out io.Writer, path, localFile string) (bool, error) {
-	if path == "" {
-		path = "/dev/null"
-	} else if path[0] != '/' {
+	if path != "" && !filepath.IsAbs(path) {
 		path = filepath.Join(wt.Dir, path)
 	}
+	return diffFiles(out, path, localFile)
+}
 
-	p := execCommand("diff", "-u", path, localFile)
-	p.Stdout = out
-	err := p.Run()
+// exitedWith returns true if err is from a command which exited
+// with the given status.
+func exitedWith(err error, status int) bool {
+	switch exitErr := err.(type) {
+	case *exec.ExitError:
+		return exitErr.ExitCode() == status
+	case *hgExitError:
+		return exitErr.code == status
+	}
+	return false
+}
 
-	changes := false
-	if err != nil {
-		// Find the exit code from an exec.ExitError by using
-		// the embedded os.ProcessState's Sys method.
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			if waitStatus, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-				// Exit codes for diff are:
-				// 0: no differences were found
-				// 1: some differences were found
-				// >1: trouble
-				if waitStatus.Exited() && waitStatus.ExitStatus() == 1 {
-					return true, nil
-				}
+// grepFiles returns the lines of the text files below root which
+// match re, with paths relative to root. It is for VCSs with no
+// search command of their own.
+func grepFiles(root string, re *regexp.Regexp) ([]GrepMatch, error) {
+	var matches []GrepMatch
+	walkfn := func(pth string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		data, err := ioutil.ReadFile(pth)
+		if err != nil {
+			return err
+		}
+		head := data
+		if len(head) > 8000 {
+			head = head[:8000]
+		}
+		if bytes.IndexByte(head, 0) != -1 {
+			// Binary
+			return nil
+		}
+		rel, err := filepath.Rel(root, pth)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, len(data)+1)
+		for lineno := 1; scanner.Scan(); lineno++ {
+			if line := scanner.Text(); re.MatchString(line) {
+				matches = append(matches, GrepMatch{
+					Path: rel,
+					Line: lineno,
+					Text: line,
+				})
 			}
 		}
+		return scanner.Err()
 	}
-
-	return changes, err
+	if err := filepath.Walk(root, walkfn); err != nil {
+		return nil, err
+	}
+	return matches, nil
 }
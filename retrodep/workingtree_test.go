@@ -18,6 +18,10 @@ package retrodep
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -76,7 +80,8 @@ func TestPseudoVersion(t *testing.T) {
 		timeFromRevisionCalled bool
 	}
 
-	tm := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	// One hour west of UTC, which must not change the timestamp
+	tm := time.Date(2006, 1, 2, 14, 4, 5, 0, time.FixedZone("", -3600))
 	rev := "d4c3dbfa77a74ae238e401d5d2197b45f30d8513"
 	tcases := []tcase{
 		tcase{
@@ -155,6 +160,43 @@ func TestPseudoVersion(t *testing.T) {
 	}
 }
 
+func TestModulePseudoVersion(t *testing.T) {
+	// One hour east of UTC
+	tm := time.Date(2006, 1, 2, 16, 4, 5, 0, time.FixedZone("", 3600))
+	rev := "d4c3dbfa77a74ae238e401d5d2197b45f30d8513"
+	tcases := []struct {
+		m  mockDescribable
+		pv string
+	}{
+		{mockDescribable{name: "no-reachable", tagErr: ErrorVersionNotFound},
+			"v0.0.0-20060102150405-d4c3dbfa77a7"},
+		{mockDescribable{name: "reachable-nonsemver", tag: "v1.2.0beta1"},
+			"v0.0.0-20060102150405-d4c3dbfa77a7"},
+		{mockDescribable{name: "reachable-no-v", tag: "1.2.0"},
+			"v0.0.0-20060102150405-d4c3dbfa77a7"},
+		{mockDescribable{name: "reachable-short", tag: "v1.2"},
+			"v0.0.0-20060102150405-d4c3dbfa77a7"},
+		{mockDescribable{name: "reachable-semver", tag: "v1.2.0"},
+			"v1.2.1-0.20060102150405-d4c3dbfa77a7"},
+		{mockDescribable{name: "reachable-presemver", tag: "v1.2.0-pre1"},
+			"v1.2.0-pre1.0.20060102150405-d4c3dbfa77a7"},
+	}
+
+	for _, tc := range tcases {
+		m := tc.m
+		m.t = t
+		m.rev = rev
+		m.time = tm
+
+		pv, err := ModulePseudoVersion(&m, rev)
+		if err != nil {
+			t.Errorf("%s: %s", m.name, err)
+		} else if pv != tc.pv {
+			t.Errorf("%s: got %q, want %q", m.name, pv, tc.pv)
+		}
+	}
+}
+
 // stubWorkingTree is used to build mocks for WorkingTree.
 type stubWorkingTree struct{ anyWorkingTree }
 
@@ -182,6 +224,18 @@ func (wt *stubWorkingTree) RevisionFromTag(tag string) (string, error) {
 	return "", nil
 }
 
+func (wt *stubWorkingTree) Grep(ref, pattern, subPath string) ([]GrepMatch, error) {
+	return nil, nil
+}
+
+func (wt *stubWorkingTree) LastRevisions(ref, subPath string) (map[string]string, error) {
+	return make(map[string]string), nil
+}
+
+func (wt *stubWorkingTree) Submodules(ref string) ([]Submodule, error) {
+	return nil, nil
+}
+
 func (wt *stubWorkingTree) ReachableTag(rev string) (string, error) {
 	return "", nil
 }
@@ -258,21 +312,30 @@ func TestStripImportCommentNewline(t *testing.T) {
 }
 
 func TestDiff(t *testing.T) {
-	defer mockExecCommand()()
+	dir, err := ioutil.TempDir("", "retrodep-diff.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
 
 	wt := &gitWorkingTree{
 		anyWorkingTree: anyWorkingTree{
-			Dir: "testdata/gosource",
+			Dir: dir,
 			VCS: vcs.ByCmd("git"),
 		},
 	}
 
-	// This will be the file contents *and* the output of 'diff -u'.
-	mockedStdout = "--- ignored.go\n+++ignored.go\n@@ -0,0 +1 @@\n+foo\n"
-	mockedExitStatus = 1
+	// Write the file in the working tree and the local file.
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	localFile := filepath.Join(dir, "local.go")
+	if err := ioutil.WriteFile(localFile, []byte("package a\n\nvar foo int\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
 
 	captured := &strings.Builder{}
-	changes, err := wt.Diff(captured, "ignored.go", "ignored.go")
+	changes, err := wt.Diff(captured, "a.go", localFile)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -281,7 +344,76 @@ func TestDiff(t *testing.T) {
 		t.Errorf("changes: got %t, expected %t", changes, true)
 	}
 
-	if captured.String() != mockedStdout {
-		t.Errorf("got %q, wanted %q", captured.String(), mockedStdout)
+	exp := "@@ -1 +1,3 @@\n package a\n+\n+var foo int\n"
+	lines := strings.SplitAfterN(captured.String(), "\n", 3)
+	if len(lines) != 3 ||
+		!strings.HasPrefix(lines[0], "--- "+filepath.Join(dir, "a.go")+"\t") ||
+		!strings.HasPrefix(lines[1], "+++ "+localFile+"\t") ||
+		lines[2] != exp {
+		t.Errorf("got %q, wanted %q", captured.String(), exp)
+	}
+
+	// No changes.
+	captured.Reset()
+	changes, err = wt.Diff(captured, "a.go", filepath.Join(dir, "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes || captured.Len() > 0 {
+		t.Errorf("unexpected changes: %q", captured.String())
+	}
+}
+
+func TestParseChangeLog(t *testing.T) {
+	log := `commit r3
+
+D	sub/gone.go
+M	sub/a.go
+commit r2
+M	sub/a.go
+A	sub/b.go
+A	other/c.go
+commit r1
+A	sub/gone.go
+`
+	revs, err := parseChangeLog(strings.NewReader(log), "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"a.go": "r3", "b.go": "r2"}
+	if !reflect.DeepEqual(revs, expected) {
+		t.Errorf("got %v, want %v", revs, expected)
+	}
+
+	if _, err := parseChangeLog(strings.NewReader("M\ta.go\n"), ""); err == nil {
+		t.Error("missing revision not reported as error")
+	}
+}
+
+func TestCreateCmdline(t *testing.T) {
+	for _, tc := range []struct {
+		cmd      *vcs.Cmd
+		expected []string
+	}{
+		{vcs.ByCmd(vcsGit), []string{"clone", "--", "-x", "/tmp/dir"}},
+		{vcs.ByCmd(vcsHg), []string{"clone", "-U", "--", "-x", "/tmp/dir"}},
+		{vcsFossilCmd, []string{"open", "--force", "--workdir", "/tmp/dir", "--repodir", "/tmp/dir", "-x"}},
+	} {
+		args := expandCmdline(createCmdline(tc.cmd), "dir", "/tmp/dir", "repo", "-x")
+		if !reflect.DeepEqual(args, tc.expected) {
+			t.Errorf("%s: got %v, want %v", tc.cmd.Cmd, args, tc.expected)
+		}
+	}
+}
+
+func TestPartialCloneArgs(t *testing.T) {
+	args := expandCmdline(vcs.ByCmd(vcsGit).CreateCmd,
+		"dir", "/tmp/dir", "repo", "https://github.com/foo/bar")
+	expected := []string{"clone", "--filter=blob:none", "https://github.com/foo/bar", "/tmp/dir"}
+	if got := partialCloneArgs(args); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+	if !reflect.DeepEqual(args[:2], []string{"clone", "https://github.com/foo/bar"}) {
+		t.Errorf("args modified: %v", args)
 	}
 }